@@ -13,28 +13,41 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
 	"github.com/ushineko/face-puncher-supreme/internal/config"
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/learn"
 	"github.com/ushineko/face-puncher-supreme/internal/logbuf"
 	"github.com/ushineko/face-puncher-supreme/internal/logging"
 	"github.com/ushineko/face-puncher-supreme/internal/mitm"
 	"github.com/ushineko/face-puncher-supreme/internal/plugin"
 	"github.com/ushineko/face-puncher-supreme/internal/probe"
 	"github.com/ushineko/face-puncher-supreme/internal/proxy"
+	"github.com/ushineko/face-puncher-supreme/internal/shutdown"
 	"github.com/ushineko/face-puncher-supreme/internal/stats"
+	"github.com/ushineko/face-puncher-supreme/internal/statsd"
+	"github.com/ushineko/face-puncher-supreme/internal/suggest"
 	"github.com/ushineko/face-puncher-supreme/internal/transparent"
+	"github.com/ushineko/face-puncher-supreme/internal/upstream"
 	"github.com/ushineko/face-puncher-supreme/internal/version"
 	"github.com/ushineko/face-puncher-supreme/web"
 )
@@ -48,6 +61,13 @@ var (
 	flagDataDir       string
 	flagConfigPath    string
 	flagForceCA       bool
+	flagCACN          string
+	flagCAOrg         string
+	flagCAOU          string
+	flagCAKeyType     string
+	flagCAKeyBits     int
+	flagDumpFormat    string
+	flagDumpPretty    bool
 
 	// Dashboard CLI flags.
 	flagDashboardUser string
@@ -82,7 +102,7 @@ var configCmd = &cobra.Command{
 
 var configDumpCmd = &cobra.Command{
 	Use:   "dump",
-	Short: "Print the resolved configuration as YAML",
+	Short: "Print the resolved configuration as YAML (or JSON)",
 	RunE:  runConfigDump,
 }
 
@@ -112,6 +132,14 @@ func init() {
 	rootCmd.Flags().BoolVar(&flagDashboardDev, "dashboard-dev", false, "serve dashboard from filesystem (development mode)")
 
 	generateCACmd.Flags().BoolVar(&flagForceCA, "force", false, "overwrite existing CA files")
+	generateCACmd.Flags().StringVar(&flagCACN, "cn", "", "CA subject common name (overrides mitm.ca_subject.common_name)")
+	generateCACmd.Flags().StringVar(&flagCAOrg, "org", "", "CA subject organization (overrides mitm.ca_subject.organization)")
+	generateCACmd.Flags().StringVar(&flagCAOU, "ou", "", "CA subject organizational unit (overrides mitm.ca_subject.organizational_unit)")
+	generateCACmd.Flags().StringVar(&flagCAKeyType, "key-type", "", "CA key type: ecdsa (default) or rsa (overrides mitm.ca_key_type)")
+	generateCACmd.Flags().IntVar(&flagCAKeyBits, "key-bits", 0, "RSA CA key size: 2048 (default) or 4096 (overrides mitm.ca_key_bits)")
+
+	configDumpCmd.Flags().StringVar(&flagDumpFormat, "format", "yaml", "output format: yaml or json")
+	configDumpCmd.Flags().BoolVar(&flagDumpPretty, "pretty", false, "indent JSON output (with --format json)")
 
 	configCmd.AddCommand(configDumpCmd)
 	configCmd.AddCommand(configValidateCmd)
@@ -179,15 +207,19 @@ func loadConfig(cmd *cobra.Command) (config.Config, error) {
 // blocklistResult holds initialized blocklist resources.
 type blocklistResult struct {
 	bl          *blocklist.DB
-	blocker     proxy.Blocker           // nil if no entries
-	blockDataFn func() *probe.BlockData // nil if no entries
+	blocker     proxy.Blocker
+	blockDataFn func() *probe.BlockData
 }
 
 // mitmResult holds initialized MITM resources. Zero-valued when MITM is disabled.
 type mitmResult struct {
-	interceptor  *mitm.Interceptor
-	caPEMHandler http.HandlerFunc
-	dataFn       func() *probe.MITMData
+	interceptor    *mitm.Interceptor
+	ca             *mitm.CA
+	caPEMHandler   http.HandlerFunc
+	bundleHandler  http.HandlerFunc
+	profileHandler http.HandlerFunc
+	caCheckHandler http.HandlerFunc
+	dataFn         func() *probe.MITMData
 }
 
 // ---------------------------------------------------------------------------
@@ -211,13 +243,50 @@ func runProxy(cmd *cobra.Command, _ []string) error {
 	defer blRes.bl.Close() //nolint:errcheck // best-effort on shutdown
 
 	collector := stats.NewCollector()
+	if len(cfg.Zones) > 0 {
+		collector.SetZones(stats.NewZones(cfg.ZonePrefixes()))
+		logger.Info("network zones configured", "zones", len(cfg.Zones))
+	}
 	collector.StartSampler()
 	defer collector.StopSampler()
 
-	mr, err := initMITM(&cfg, blRes.bl, logger, collector)
+	dialer, err := upstream.NewDialer(cfg.Upstream.Resolver)
+	if err != nil {
+		return fmt.Errorf("upstream resolver: %w", err)
+	}
+	if cfg.Upstream.Resolver != "" {
+		logger.Info("upstream resolver configured", "resolver", cfg.Upstream.Resolver)
+	}
+	if cfg.BlocklistCheckCNAME {
+		lookup := net.DefaultResolver.LookupCNAME
+		if r := dialer.Resolver(); r != nil {
+			lookup = r.LookupCNAME
+		}
+		blRes.blocker = blocklist.NewCNAMEBlocker(blRes.bl, lookup, logger)
+		logger.Info("blocklist cname cloaking check enabled")
+		bl := blRes.bl
+		if bl.Size()+bl.SuffixCount()+bl.PatternCount()+bl.ScheduledSize() == 0 {
+			logger.Warn("blocklist_check_cname is set but the blocklist has no entries; only temporary blocks will be matched")
+		}
+	}
+	fallback, err := upstream.NewFallbackAuth(cfg.Upstream.ProxyFallback, cfg.Upstream.ProxyUser, cfg.Upstream.ProxyPass)
+	if err != nil {
+		return fmt.Errorf("upstream proxy fallback: %w", err)
+	}
+	if fallback != nil {
+		logger.Info("upstream proxy fallback configured",
+			"fallback", fallback.String(),
+			"auth", cfg.Upstream.ProxyUser != "",
+		)
+	}
+
+	mr, err := initMITM(&cfg, blRes.bl, dialer, logger, collector)
 	if err != nil {
 		return err
 	}
+	if mr.interceptor != nil {
+		defer mr.interceptor.Close()
+	}
 
 	pluginsRes, err := initPlugins(&cfg, mr.interceptor, collector, logger)
 	if err != nil {
@@ -235,36 +304,110 @@ func runProxy(cmd *cobra.Command, _ []string) error {
 
 	transparentDataFn := makeTransparentDataFn(&cfg, mr.interceptor != nil, logger)
 
+	learner, err := initLearner(&cfg, blRes.bl, logger)
+	if err != nil {
+		return err
+	}
+	if learner != nil {
+		defer learner.Close() //nolint:errcheck // best-effort on shutdown (includes final flush)
+	}
+
+	hooks := newRequestHooks(&cfg, collector, learner)
+
+	tlsCert, err := listenTLSCert(&cfg, mr.ca, logger)
+	if err != nil {
+		return err
+	}
+
+	blockPage, err := loadBlockPage(&cfg, logger)
+	if err != nil {
+		return err
+	}
+
 	// Create the proxy server with placeholder handlers (replaced after srv exists).
 	srv := proxy.New(&proxy.Config{
-		ListenAddr:        cfg.Listen,
-		Logger:            logger,
-		Verbose:           cfg.Verbose,
-		Blocker:           blRes.blocker,
-		MITMInterceptor:   mr.interceptor,
-		ConnectTimeout:    cfg.Timeouts.Connect.Duration,
-		ReadHeaderTimeout: cfg.Timeouts.ReadHeader.Duration,
-		ManagementPrefix:  cfg.Management.PathPrefix,
-		HeartbeatHandler:  http.NotFound, // placeholder
-		StatsHandler:      http.NotFound, // placeholder
-		CAPEMHandler:      mr.caPEMHandler,
-		OnRequest:         collector.RecordRequest,
-		OnTunnelClose:     collector.RecordBytes,
+		ListenAddr:           cfg.Listen,
+		TLSListenAddr:        cfg.ListenTLS.Addr,
+		TLSCert:              tlsCert,
+		Logger:               logger,
+		Verbose:              cfg.Verbose,
+		Blocker:              blRes.blocker,
+		MITMInterceptor:      mr.interceptor,
+		ConnectBlockPage:     connectBlockPage(&cfg, mr.interceptor, logger),
+		BlockPage:            blockPage,
+		ConnectTimeout:       cfg.Timeouts.Connect.Duration,
+		ReadHeaderTimeout:    cfg.Timeouts.ReadHeader.Duration,
+		RequestTimeout:       cfg.Timeouts.Request.Duration,
+		TimeoutWholeBody:     cfg.Timeouts.RequestIncludesBody,
+		ManagementPrefix:     cfg.Management.PathPrefix,
+		DisabledEndpoints:    cfg.Management.DisabledEndpoints,
+		StripRequestHeaders:  cfg.Proxy.StripRequestHeaders,
+		StripResponseHeaders: cfg.Proxy.StripResponseHeaders,
+		StripCookies:         cfg.Proxy.StripCookiePatterns(),
+		StrictContentLength:  cfg.Proxy.StrictContentLength,
+		Tracer:               debugTracer(&cfg),
+		MaxInflight:          cfg.Proxy.MaxInflight,
+		MaxResponseBytes:     cfg.Proxy.MaxResponseBytes,
+		LenientHeaders:       cfg.Proxy.LenientHeaders,
+		BlockedCIDRs:         cfg.BlocklistPrefixes(),
+		ProxyAuth:            cfg.Proxy.Auth.Credentials(),
+		RateLimit:            cfg.Proxy.RateLimit.RequestsPerSecond,
+		RateBurst:            cfg.Proxy.RateLimit.Burst,
+		UpstreamRetries:      cfg.Proxy.UpstreamRetries,
+		UpstreamRetryBackoff: cfg.Proxy.UpstreamRetryBackoff.Duration,
+		ChaosDelay:           cfg.Chaos.Delay.Duration,
+		ChaosJitter:          cfg.Chaos.Jitter.Duration,
+		ChaosProbability:     cfg.Chaos.Probability,
+		Transport:            proxyTransport(&cfg.Proxy.Transport),
+		CacheMaxBytes:        cfg.Proxy.Cache.MaxSize,
+		CacheMaxObjectBytes:  cfg.Proxy.Cache.MaxObjectSize,
+		OnCacheLookup:        collector.RecordCacheLookup,
+		Dialer:               dialer,
+		Fallback:             fallback,
+		HeartbeatHandler:     http.NotFound, // placeholder
+		StatsHandler:         http.NotFound, // placeholder
+		CAPEMHandler:         mr.caPEMHandler,
+		CABundleHandler:      mr.bundleHandler,
+		CAProfileHandler:     mr.profileHandler,
+		CACheckHandler:       mr.caCheckHandler,
+		OnRequest:            hooks.onRequest,
+		OnTunnelClose:        collector.RecordBytes,
+		OnTunnelBytes:        collector.RecordLiveBytes,
+		OnBlock:              hooks.onBlock,
+		OnConnOpen:           collector.ConnOpened,
+		OnConnClose:          collector.ConnClosed,
+		Passthrough:          hooks.passthrough,
 	})
+	if hooks.tracker != nil {
+		srv.SetSuggestionsHandler(probe.SuggestionsHandler(hooks.tracker))
+	}
+	if learner != nil {
+		srv.SetCandidatesHandler(probe.CandidatesHandler(learner))
+	}
+	if len(cfg.BlocklistCIDRs) > 0 {
+		blRes.blockDataFn = withIPBlocks(blRes.blockDataFn, srv)
+		logger.Info("blocklist ip ranges configured", "cidrs", len(cfg.BlocklistCIDRs))
+	}
 
 	statsProvider := initHandlers(&cfg, srv, collector, statsDB,
 		blRes.blockDataFn, mr.dataFn, transparentDataFn, pluginsDataFn, logger)
 
-	defer initDashboard(&cfg, srv, statsProvider,
+	stopStatsD, err := initStatsD(&cfg, srv, collector, blRes.blockDataFn, logger)
+	if err != nil {
+		return err
+	}
+	defer stopStatsD()
+
+	tpListener := initTransparentListener(&cfg, blRes.blocker, mr.interceptor, dialer, collector, hooks, logger)
+
+	defer initDashboard(&cfg, srv, tpListener, statsProvider,
 		blRes.blockDataFn, mr.dataFn, transparentDataFn, pluginsDataFn,
-		blRes.bl, logBuf, logResult.LevelVar, pluginsRes, logger)()
+		blRes.bl, mr.interceptor, logBuf, logResult.LevelVar, pluginsRes, hooks.passthrough, logger)()
 
 	if statsDB != nil {
 		statsDB.Start()
 	}
 
-	tpListener := initTransparentListener(&cfg, blRes.blocker, mr.interceptor, collector, logger)
-
 	return runServers(&cfg, srv, tpListener, blRes.bl, logger)
 }
 
@@ -278,6 +421,9 @@ func initLogging(cfg *config.Config) (*logbuf.Buffer, logging.Result) {
 
 	logResult := logging.Setup(logging.Config{
 		LogDir:        cfg.LogDir,
+		RotateSizeMB:  cfg.LogRotateSize,
+		RotateKeep:    cfg.LogRotateKeep,
+		Syslog:        cfg.LogSyslog,
 		Verbose:       cfg.Verbose,
 		ExtraHandlers: []slog.Handler{logBuf.Handler()},
 	})
@@ -285,6 +431,46 @@ func initLogging(cfg *config.Config) (*logbuf.Buffer, logging.Result) {
 	return logBuf, logResult
 }
 
+// blocklistFetcher returns an HTTP fetcher that applies the per-source
+// parse options from cfg.BlocklistSources. A mirror group's options apply
+// to each of its mirrors.
+func blocklistFetcher(cfg *config.Config, logger *slog.Logger) (blocklist.ListFetchFunc, error) {
+	sources := make(map[string]blocklist.ParseOptions, len(cfg.BlocklistSources))
+	for u, src := range cfg.BlocklistSources {
+		opts, err := blocklist.NewParseOptions(src.ExcludePatterns)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist source %s: %w", u, err)
+		}
+		sources[u] = opts
+		for _, m := range src.Mirrors {
+			sources[m] = opts
+		}
+	}
+	return blocklist.HTTPListFetcher(sources, logger), nil
+}
+
+// blocklistMirrorGroups converts mirror-group sources from the config.
+func blocklistMirrorGroups(cfg *config.Config) []blocklist.MirrorGroup {
+	names := cfg.MirrorGroupNames()
+	groups := make([]blocklist.MirrorGroup, 0, len(names))
+	for _, name := range names {
+		src := cfg.BlocklistSources[name]
+		groups = append(groups, blocklist.MirrorGroup{
+			Name:     name,
+			Mirrors:  src.Mirrors,
+			Strategy: src.Strategy,
+		})
+	}
+	return groups
+}
+
+// initialFetchAttempts and initialFetchBackoff bound the first-run
+// blocklist fetch retries (2s, 4s, 8s between attempts).
+const (
+	initialFetchAttempts = 4
+	initialFetchBackoff  = 2 * time.Second
+)
+
 // initBlocklist opens the blocklist database, performs first-run fetch if
 // needed, and configures allowlist and inline entries.
 func initBlocklist(cfg *config.Config, logger *slog.Logger) (*blocklistResult, error) {
@@ -296,40 +482,86 @@ func initBlocklist(cfg *config.Config, logger *slog.Logger) (*blocklistResult, e
 	}
 
 	// If blocklist URLs are configured and no existing data, fetch on first run.
-	if len(cfg.BlocklistURLs) > 0 && bl.Size() == 0 {
+	mirrorGroups := blocklistMirrorGroups(cfg)
+	if len(cfg.BlocklistURLs)+len(mirrorGroups) > 0 && bl.Size() == 0 {
 		logger.Info("first run with blocklist URLs, fetching lists...")
-		if updateErr := bl.Update(cfg.BlocklistURLs, blocklist.HTTPFetcher()); updateErr != nil {
+		fetch, fetchErr := blocklistFetcher(cfg, logger)
+		if fetchErr != nil {
+			bl.Close() //nolint:errcheck,gosec // best-effort cleanup on error path
+			return nil, fetchErr
+		}
+		updateErr := bl.InitialUpdate(cfg.BlocklistURLs, mirrorGroups, fetch, initialFetchAttempts, initialFetchBackoff)
+		switch {
+		case errors.Is(updateErr, blocklist.ErrEmpty) && cfg.BlocklistRequireNonempty:
+			bl.Close() //nolint:errcheck,gosec // best-effort cleanup on error path
+			return nil, fmt.Errorf("blocklist_require_nonempty: %w (after %d attempts)", updateErr, initialFetchAttempts)
+		case errors.Is(updateErr, blocklist.ErrEmpty):
+			logger.Error("blocklist is empty after first-run fetch; running WITHOUT list-based blocking until update-blocklist succeeds",
+				"attempts", initialFetchAttempts,
+			)
+		case updateErr != nil:
 			logger.Error("failed to update blocklist on first run", "error", updateErr)
 		}
 	}
 
+	now := time.Now()
+	for _, src := range bl.Sources() {
+		if src.Stale(now) {
+			logger.Warn("blocklist source past its declared expiry, run update-blocklist",
+				"url", src.URL,
+				"title", src.Title,
+				"expires", src.Expires,
+				"fetched", src.Fetched,
+			)
+		}
+	}
+
 	// Load allowlist from config (must be set before AddInlineDomains so
 	// allowlist takes priority in IsBlocked checks).
 	bl.SetAllowlist(cfg.Allowlist)
+	if err := bl.LoadManagedAllowlist(filepath.Join(cfg.DataDir, "allowlist.txt")); err != nil {
+		bl.Close() //nolint:errcheck,gosec // best-effort cleanup on error path
+		return nil, err
+	}
 
 	// Merge inline blocklist entries from config into in-memory map.
 	bl.AddInlineDomains(cfg.Blocklist)
 
+	for i, sc := range cfg.BlocklistSchedules {
+		schedule, schedErr := blocklist.NewSchedule(sc.BlockBetween, sc.Days, sc.TZ)
+		if schedErr != nil {
+			bl.Close() //nolint:errcheck,gosec // best-effort cleanup on error path
+			return nil, fmt.Errorf("blocklist_schedules[%d]: %w", i, schedErr)
+		}
+		bl.AddSchedule(sc.Domains, schedule)
+	}
+	bl.StartTemporarySweeper(time.Minute)
+	bl.SetLockTiming(cfg.BlocklistLockTiming)
+
 	logger.Info("blocklist loaded",
 		"domains", bl.Size(),
 		"sources", bl.SourceCount(),
 		"inline_domains", len(cfg.Blocklist),
+		"inline_suffixes", bl.SuffixCount(),
+		"inline_patterns", bl.PatternCount(),
+		"scheduled_domains", bl.ScheduledSize(),
 		"allowlist_entries", bl.AllowlistSize(),
 		"db_path", dbPath,
 	)
 
-	res := &blocklistResult{bl: bl}
-	if bl.Size() > 0 || bl.AllowlistSize() > 0 {
-		res.blocker = bl
-		res.blockDataFn = makeBlockDataFn(bl)
-	}
-
-	return res, nil
+	// The blocker is wired even with no entries loaded: temporary blocks
+	// and allowlist edits made at runtime through the dashboard API must
+	// take effect without a restart.
+	return &blocklistResult{
+		bl:          bl,
+		blocker:     bl,
+		blockDataFn: makeBlockDataFn(bl),
+	}, nil
 }
 
 // initMITM loads the CA and creates the MITM interceptor. Returns a zero
 // mitmResult if no MITM domains are configured.
-func initMITM(cfg *config.Config, bl *blocklist.DB, logger *slog.Logger, collector *stats.Collector) (mitmResult, error) {
+func initMITM(cfg *config.Config, bl *blocklist.DB, dialer *upstream.Dialer, logger *slog.Logger, collector *stats.Collector) (mitmResult, error) {
 	if len(cfg.MITM.Domains) == 0 {
 		logger.Info("mitm disabled")
 		return mitmResult{}, nil
@@ -342,24 +574,57 @@ func initMITM(cfg *config.Config, bl *blocklist.DB, logger *slog.Logger, collect
 	if caErr != nil {
 		return mitmResult{}, fmt.Errorf("mitm: %w (run 'fpsd generate-ca' to create CA files)", caErr)
 	}
+	if cfg.MITM.CABundle != "" {
+		if err := ca.LoadChain(filepath.Join(cfg.DataDir, cfg.MITM.CABundle)); err != nil {
+			return mitmResult{}, fmt.Errorf("mitm: %w", err)
+		}
+	}
 
-	// Warn about domains in both MITM and blocklist.
+	// Warn about domains in both MITM and blocklist. A "*.example.com"
+	// pattern is checked by its base domain; blocked subdomains under it
+	// are not enumerated.
 	for _, d := range cfg.MITM.Domains {
-		if bl.Size() > 0 && bl.IsBlocked(strings.ToLower(d)) {
+		name := strings.TrimPrefix(strings.ToLower(d), "*.")
+		if blocklisted, allowlisted := bl.Check(name); blocklisted && !allowlisted {
 			logger.Warn("mitm domain is also in blocklist (will be blocked, not intercepted)",
 				"domain", d,
 			)
 		}
 	}
 
+	certDir := cfg.MITM.CertDir
+	if certDir != "" && !filepath.IsAbs(certDir) {
+		certDir = filepath.Join(cfg.DataDir, certDir)
+	}
+	caCheckPath := cfg.Management.PathPrefix + "/ca/check"
+	if slices.Contains(cfg.Management.DisabledEndpoints, proxy.EndpointCA) {
+		caCheckPath = ""
+	}
 	interceptor := mitm.NewInterceptor(&mitm.InterceptorConfig{
 		CA:             ca,
 		Domains:        cfg.MITM.Domains,
+		NeverIntercept: cfg.MITM.NeverIntercept,
+		MaxBufferSize:  cfg.MITM.MaxBufferSize,
+		DiskBufferMax:  cfg.MITM.DiskBufferLimit(),
 		Logger:         logger,
 		Verbose:        cfg.Verbose,
 		ConnectTimeout: cfg.Timeouts.Connect.Duration,
 		OnMITMRequest:  collector.RecordMITMRequest,
+		PipelineDepth:  cfg.MITM.PipelineDepth,
+		CertCacheTTL:   cfg.MITM.CertCacheTTL.Duration,
+		CertDir:        certDir,
+		CACheckPath:    caCheckPath,
+		Tracer:         debugTracer(cfg),
+		Dialer:         dialer,
+
+		StripRequestHeaders:  cfg.Proxy.StripRequestHeaders,
+		StripResponseHeaders: cfg.Proxy.StripResponseHeaders,
+		StripCookies:         cfg.Proxy.StripCookiePatterns(),
+		StrictContentLength:  cfg.Proxy.StrictContentLength,
 	})
+	if cfg.MITM.PregenerateCerts {
+		interceptor.PregenerateCerts()
+	}
 
 	// CA cert download handler.
 	caPEM := ca.CertPEM
@@ -369,12 +634,36 @@ func initMITM(cfg *config.Config, bl *blocklist.DB, logger *slog.Logger, collect
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(caPEM) //nolint:gosec // best-effort response
 	}
+	bundle := ca.Bundle()
+	bundleHandler := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=fps-ca-bundle.pem")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bundle) //nolint:gosec // best-effort response
+	}
+	profile := ca.MobileConfig()
+	profileHandler := func(w http.ResponseWriter, _ *http.Request) {
+		// iOS Safari offers to install a profile only with this type.
+		w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		w.Header().Set("Content-Disposition", "attachment; filename=fps-ca.mobileconfig")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(profile) //nolint:gosec // best-effort response
+	}
 
 	dataFn := func() *probe.MITMData {
 		return &probe.MITMData{
 			Enabled:           true,
 			InterceptsTotal:   interceptor.InterceptsTotal.Load(),
 			DomainsConfigured: interceptor.Domains(),
+			Protocol: probe.MITMProtocolBlock{
+				HTTP10Requests:    interceptor.HTTP10Requests.Load(),
+				HTTP11Requests:    interceptor.HTTP11Requests.Load(),
+				HTTP10Responses:   interceptor.HTTP10Responses.Load(),
+				MalformedRequests: interceptor.MalformedRequests.Load(),
+			},
+			ModifierPanics: interceptor.ModifierPanics.Load(),
+			DiskBuffered:   interceptor.DiskBuffered.Load(),
+			BufferSkipped:  interceptor.BufferSkipped.Load(),
 		}
 	}
 
@@ -395,12 +684,103 @@ func initMITM(cfg *config.Config, bl *blocklist.DB, logger *slog.Logger, collect
 	)
 
 	return mitmResult{
-		interceptor:  interceptor,
-		caPEMHandler: caPEMHandler,
-		dataFn:       dataFn,
+		interceptor:    interceptor,
+		ca:             ca,
+		caPEMHandler:   caPEMHandler,
+		bundleHandler:  bundleHandler,
+		profileHandler: profileHandler,
+		caCheckHandler: interceptor.ServeCACheckInstructions,
+		dataFn:         dataFn,
 	}, nil
 }
 
+// proxyTransport converts proxy.transport settings to the proxy's upstream
+// transport config.
+func proxyTransport(t *config.ProxyTransport) proxy.TransportConfig {
+	return proxy.TransportConfig{
+		MaxIdleConns:        t.MaxIdleConns,
+		MaxIdleConnsPerHost: t.MaxIdleConnsPerHost,
+		IdleConnTimeout:     t.IdleConnTimeout.Duration,
+		TLSHandshakeTimeout: t.TLSHandshakeTimeout.Duration,
+		DisableKeepAlives:   t.DisableKeepAlives,
+	}
+}
+
+// connectBlockPage returns the block page server for blocked CONNECTs when
+// proxy.connect_block_page is set. It needs the MITM CA; without it blocked
+// CONNECTs keep getting a 403.
+func connectBlockPage(cfg *config.Config, interceptor *mitm.Interceptor, logger *slog.Logger) proxy.BlockPageServer {
+	if !cfg.Proxy.ConnectBlockPage {
+		return nil
+	}
+	if interceptor == nil {
+		logger.Warn("proxy.connect_block_page needs mitm enabled, blocked CONNECTs get 403")
+		return nil
+	}
+	return interceptor
+}
+
+// debugTracer returns the tracer for proxy.debug_headers, or nil when
+// debug headers are off.
+func debugTracer(cfg *config.Config) *headers.Tracer {
+	if !cfg.Proxy.DebugHeaders {
+		return nil
+	}
+	return headers.NewTracer(cfg.Proxy.DebugHeaderPrefixes())
+}
+
+// loadBlockPage parses the proxy.block_page template. Returns (nil, nil)
+// when no block page is configured.
+func loadBlockPage(cfg *config.Config, logger *slog.Logger) (*template.Template, error) {
+	if cfg.Proxy.BlockPage == "" {
+		return nil, nil
+	}
+	path := filepath.Join(cfg.DataDir, cfg.Proxy.BlockPage)
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("proxy.block_page: %w", err)
+	}
+	logger.Info("block page loaded", "path", path)
+	return tmpl, nil
+}
+
+// listenTLSCert loads or generates the certificate for the proxy-over-TLS
+// listener. Returns (nil, nil) when listen_tls is not configured.
+func listenTLSCert(cfg *config.Config, ca *mitm.CA, logger *slog.Logger) (*tls.Certificate, error) {
+	if cfg.ListenTLS.Addr == "" {
+		return nil, nil
+	}
+
+	if cfg.ListenTLS.Cert != "" {
+		certPath := filepath.Join(cfg.DataDir, cfg.ListenTLS.Cert)
+		keyPath := filepath.Join(cfg.DataDir, cfg.ListenTLS.Key)
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("listen_tls: load cert/key: %w", err)
+		}
+		logger.Info("listen_tls certificate loaded", "cert", certPath)
+		return &cert, nil
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(cfg.ListenTLS.Addr); err == nil && host != "" {
+		hosts = append(hosts, host)
+	}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	cert, err := mitm.NewServerCert(ca, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("listen_tls: %w", err)
+	}
+	signedBy := "self"
+	if ca != nil {
+		signedBy = "mitm_ca"
+	}
+	logger.Info("listen_tls certificate generated", "hosts", hosts, "signed_by", signedBy)
+	return cert, nil
+}
+
 // initStatsDB opens the stats database if enabled. Returns (nil, nil) when
 // stats are disabled in config.
 func initStatsDB(cfg *config.Config, collector *stats.Collector, bl *blocklist.DB, logger *slog.Logger) (*stats.DB, error) {
@@ -415,15 +795,51 @@ func initStatsDB(cfg *config.Config, collector *stats.Collector, bl *blocklist.D
 	}
 
 	statsDB.SetAllowStatsSource(bl.SnapshotAllowCounts)
+	statsDB.SetAllowStatsReset(bl.ResetCounters)
+	statsDB.SetMITMStatsSource(collector.SnapshotMITMIntercepts)
+	statsDB.SetRetention(cfg.Stats.Retention.Duration)
+	statsDB.SetClientIdle(cfg.Stats.ClientIdle.Duration, cfg.Stats.ClientIdleRetention.Duration)
 
 	logger.Info("stats database initialized",
 		"path", statsDBPath,
 		"flush_interval", cfg.Stats.FlushInterval.Duration,
+		"retention", cfg.Stats.Retention.Duration,
+		"client_idle", cfg.Stats.ClientIdle.Duration,
 	)
 
 	return statsDB, nil
 }
 
+// initStatsD starts the StatsD exporter if statsd.addr is set. Returns a
+// cleanup function that stops it after a final push (no-op if disabled).
+func initStatsD(
+	cfg *config.Config,
+	srv *proxy.Server,
+	collector *stats.Collector,
+	blockDataFn func() *probe.BlockData,
+	logger *slog.Logger,
+) (func(), error) {
+	if cfg.StatsD.Addr == "" {
+		return func() {}, nil
+	}
+
+	client, err := statsd.Dial(cfg.StatsD.Addr, cfg.StatsD.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	sp := &probe.StatsProvider{Info: srv, BlockFn: blockDataFn, Collector: collector}
+	exporter := statsd.NewExporter(client, func() []statsd.Sample { return probe.StatsDSamples(sp) },
+		cfg.StatsD.Interval.Duration, logger)
+	exporter.Start()
+
+	logger.Info("statsd exporter started",
+		"addr", cfg.StatsD.Addr,
+		"prefix", cfg.StatsD.Prefix,
+		"interval", cfg.StatsD.Interval.Duration,
+	)
+	return exporter.Stop, nil
+}
+
 // makeTransparentDataFn creates a TransparentData callback for probe responses.
 // Returns nil if transparent mode is disabled.
 func makeTransparentDataFn(cfg *config.Config, mitmEnabled bool, logger *slog.Logger) func() *probe.TransparentData {
@@ -468,16 +884,24 @@ func initHandlers(
 			MITMFn:        mitmDataFn,
 			TransparentFn: transparentDataFn,
 			PluginsFn:     pluginsDataFn,
-			StatsDB:       statsDB,
 			Collector:     collector,
 			Resolver:      probe.NewReverseDNS(5 * time.Minute),
 		}
+		if statsDB != nil {
+			statsProvider.StatsDB = statsDB
+		}
 		statsHandler = probe.StatsHandler(statsProvider)
+		srv.SetMetricsHandler(probe.MetricsHandler(statsProvider, cfg.Stats.MetricsTopDomains))
+		srv.SetNewDomainsHandler(probe.NewDomainsHandler(statsDB))
+		srv.SetExportCSVHandler(probe.ExportCSVHandler(statsDB))
 	} else {
 		statsHandler = probe.StatsDisabledHandler()
+		srv.SetNewDomainsHandler(probe.StatsDisabledHandler())
+		srv.SetExportCSVHandler(probe.StatsDisabledHandler())
 	}
 
 	srv.SetHandlers(heartbeatHandler, statsHandler)
+	srv.SetPACHandler(probe.PACHandler(cfg.Listen, cfg.Management.ExternalHost, cfg.Management.PACDirect))
 	_ = logger // consistent parameter list; used for future error logging
 
 	return statsProvider
@@ -489,15 +913,18 @@ func initHandlers(
 func initDashboard(
 	cfg *config.Config,
 	srv *proxy.Server,
+	tpListener *transparent.Listener,
 	statsProvider *probe.StatsProvider,
 	blockDataFn func() *probe.BlockData,
 	mitmDataFn func() *probe.MITMData,
 	transparentDataFn func() *probe.TransparentData,
 	pluginsDataFn func() *probe.PluginsData,
 	bl *blocklist.DB,
+	mitmInterceptor *mitm.Interceptor,
 	logBuf *logbuf.Buffer,
 	levelVar *slog.LevelVar,
 	pluginsRes *pluginsResult,
+	passthrough *atomic.Bool,
 	logger *slog.Logger,
 ) func() {
 	if cfg.Dashboard.Username == "" || cfg.Dashboard.Password == "" {
@@ -505,6 +932,11 @@ func initDashboard(
 		return func() {}
 	}
 
+	var mitmDomainFn func(string) bool
+	if mitmInterceptor != nil {
+		mitmDomainFn = mitmInterceptor.IsMITMDomain
+	}
+
 	dashboard := web.NewDashboard(&web.DashboardConfig{
 		PathPrefix: cfg.Management.PathPrefix,
 		Username:   cfg.Dashboard.Username,
@@ -517,7 +949,7 @@ func initDashboard(
 		},
 		StatsJSON: func() ([]byte, error) {
 			if statsProvider != nil {
-				resp := probe.BuildStats(statsProvider, 25, nil)
+				resp := probe.BuildStats(statsProvider, 25, nil, "")
 				return json.Marshal(resp)
 			}
 			return json.Marshal(map[string]string{"status": "stats disabled"})
@@ -526,9 +958,25 @@ func initDashboard(
 			redacted := cfg.Redacted()
 			return json.Marshal(redacted)
 		},
+		ConfigSnapshot: func() ([]byte, error) {
+			snap := configSnapshot(cfg, bl, pluginsRes.pauses)
+			return snap.Dump()
+		},
+		SessionsJSON: func() ([]byte, error) {
+			if tpListener == nil {
+				return json.Marshal(probe.BuildSessions(srv.Sessions()))
+			}
+			return json.Marshal(probe.BuildSessions(srv.Sessions(), tpListener.Sessions()))
+		},
 		ReloadFn:        makeReloadFn(cfg, bl, logBuf, levelVar, logger),
+		ResetStatsFn:    makeResetStatsFn(statsProvider, bl),
 		RewriteStore:    pluginsRes.rewriteStore,
 		RewriteReloadFn: pluginsRes.rewriteReload,
+		PluginPauses:    pluginsRes.pauses,
+		DomainCheckFn:   bl.Check,
+		MITMDomainFn:    mitmDomainFn,
+		BlocklistDB:     bl,
+		Passthrough:     passthrough,
 		Logger:          logger,
 	})
 	dashboard.Start()
@@ -542,13 +990,80 @@ func initDashboard(
 	return dashboard.Stop
 }
 
+// requestHooks holds the per-request callbacks and the passthrough kill
+// switch shared by the proxy and the transparent listener.
+type requestHooks struct {
+	onRequest   func(clientIP, domain string, blocked bool, bytesIn, bytesOut int64)
+	onBlock     func(clientIP, domain, referer string)
+	tracker     *suggest.Tracker // nil when suggestions are disabled
+	passthrough *atomic.Bool     // toggled via /fps/api/passthrough
+}
+
+// newRequestHooks wires request callbacks to the stats collector and, if
+// enabled, the allowlist suggestion tracker and the learning mode learner
+// (nil when disabled). The passthrough switch starts off.
+func newRequestHooks(cfg *config.Config, collector *stats.Collector, learner *learn.Learner) requestHooks {
+	hooks := requestHooks{onRequest: collector.RecordRequest, passthrough: new(atomic.Bool)}
+	if cfg.Suggestions.Enabled {
+		hooks.tracker = suggest.NewTracker(suggest.Config{
+			MinBlocks: cfg.Suggestions.MinBlocks,
+			Window:    cfg.Suggestions.Window.Duration,
+			MaxPairs:  cfg.Suggestions.MaxPairs,
+		})
+		hooks.onBlock = hooks.tracker.RecordBlocked
+	}
+	if hooks.tracker == nil && learner == nil {
+		return hooks
+	}
+	tracker := hooks.tracker
+	hooks.onRequest = func(clientIP, domain string, blocked bool, bytesIn, bytesOut int64) {
+		collector.RecordRequest(clientIP, domain, blocked, bytesIn, bytesOut)
+		if blocked {
+			return
+		}
+		if tracker != nil {
+			tracker.RecordAllowed(clientIP, domain)
+		}
+		if learner != nil {
+			learner.Record(domain)
+		}
+	}
+	return hooks
+}
+
+// initLearner opens the learning mode candidates database if enabled.
+// Returns nil if learning mode is disabled.
+func initLearner(cfg *config.Config, bl *blocklist.DB, logger *slog.Logger) (*learn.Learner, error) {
+	if !cfg.Learning.Enabled {
+		return nil, nil
+	}
+
+	dbPath := filepath.Join(cfg.DataDir, "learn.db")
+	learner, err := learn.Open(dbPath, learn.Config{
+		Patterns: cfg.Learning.Patterns,
+		Listed: func(domain string) bool {
+			blocklisted, _ := bl.Check(domain)
+			return blocklisted
+		},
+	}, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open learn db: %w", err)
+	}
+	learner.Start()
+
+	logger.Info("learning mode enabled", "path", dbPath)
+	return learner, nil
+}
+
 // initTransparentListener creates the transparent proxy listener if enabled.
 // Returns nil if transparent mode is disabled.
 func initTransparentListener(
 	cfg *config.Config,
 	blocker proxy.Blocker,
 	mitmInterceptor *mitm.Interceptor,
+	dialer *upstream.Dialer,
 	collector *stats.Collector,
+	hooks requestHooks,
 	logger *slog.Logger,
 ) *transparent.Listener {
 	if !cfg.Transparent.Enabled {
@@ -563,8 +1078,20 @@ func initTransparentListener(
 		Blocker:         blocker,
 		MITMInterceptor: mitmInterceptor,
 		ConnectTimeout:  cfg.Timeouts.Connect.Duration,
-		OnRequest:       collector.RecordRequest,
+		Dialer:          dialer,
+		OnRequest:       hooks.onRequest,
 		OnTunnelClose:   collector.RecordBytes,
+		OnTunnelBytes:   collector.RecordLiveBytes,
+		OnBlock:         hooks.onBlock,
+		OnConnOpen:      collector.ConnOpened,
+		OnConnClose:     collector.ConnClosed,
+		Passthrough:     hooks.passthrough,
+
+		StripRequestHeaders:  cfg.Proxy.StripRequestHeaders,
+		StripResponseHeaders: cfg.Proxy.StripResponseHeaders,
+		StripCookies:         cfg.Proxy.StripCookiePatterns(),
+		StrictContentLength:  cfg.Proxy.StrictContentLength,
+
 		OnTransparentHTTP: func() {
 			collector.TransparentHTTP.Add(1)
 		},
@@ -621,6 +1148,15 @@ func runServers(
 		}
 	}()
 
+	if srv.TLSEnabled() {
+		go func() {
+			if err := srv.ListenAndServeTLS(); err != nil && err != http.ErrServerClosed {
+				logger.Error("tls proxy server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	if tpListener != nil {
 		go func() {
 			if err := tpListener.ListenAndServe(); err != nil {
@@ -632,17 +1168,30 @@ func runServers(
 	<-ctx.Done()
 	logger.Info("shutdown signal received")
 
-	// Stop transparent listeners first.
+	// Shutdown order (each step has its own deadline, see config.Timeouts):
+	//  1. transparent — close the transparent listeners so no new redirected
+	//     connections arrive.
+	//  2. proxy — stop accepting explicit proxy connections and wait for
+	//     in-flight HTTP requests.
+	//  3. tunnels — let open CONNECT tunnels and MITM sessions drain, then
+	//     force-close whatever remains.
+	var steps []shutdown.Step
 	if tpListener != nil {
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown.Duration)
-		tpListener.Shutdown(shutdownCtx)
-		cancel()
+		steps = append(steps, shutdown.Step{
+			Name:    "transparent",
+			Timeout: cfg.Timeouts.TransparentShutdown(),
+			Fn: func(ctx context.Context) error {
+				tpListener.Shutdown(ctx)
+				return nil
+			},
+		})
 	}
+	steps = append(steps,
+		shutdown.Step{Name: "proxy", Timeout: cfg.Timeouts.ProxyShutdown(), Fn: srv.Shutdown},
+		shutdown.Step{Name: "tunnels", Timeout: cfg.Timeouts.TunnelsShutdown(), Fn: srv.DrainTunnels},
+	)
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown.Duration)
-	defer cancel()
-
-	if err := srv.Shutdown(shutdownCtx); err != nil {
+	if err := shutdown.Run(steps, logger); err != nil {
 		return fmt.Errorf("shutdown error: %w", err)
 	}
 
@@ -654,6 +1203,23 @@ func runServers(
 // Existing helpers (unchanged).
 // ---------------------------------------------------------------------------
 
+// makeResetStatsFn creates the dashboard's stats reset callback. Returns nil
+// (reset disabled) when stats are disabled.
+func makeResetStatsFn(sp *probe.StatsProvider, bl *blocklist.DB) func() error {
+	if sp == nil {
+		return nil
+	}
+	return func() error {
+		if db, ok := sp.StatsDB.(*stats.DB); ok {
+			// Also zeroes the collector and blocklist counters.
+			return db.Reset()
+		}
+		sp.Collector.Reset()
+		bl.ResetCounters()
+		return nil
+	}
+}
+
 // makeReloadFn creates a function that re-reads config and hot-reloads subsystems.
 func makeReloadFn(
 	currentCfg *config.Config,
@@ -677,6 +1243,11 @@ func makeReloadFn(
 		// Update inline blocklist (additive — new domains merged in).
 		bl.AddInlineDomains(newCfg.Blocklist)
 
+		// Toggle lock timing; totals restart only when it changes.
+		if newCfg.BlocklistLockTiming != currentCfg.BlocklistLockTiming {
+			bl.SetLockTiming(newCfg.BlocklistLockTiming)
+		}
+
 		// Update verbose mode.
 		if newCfg.Verbose {
 			levelVar.Set(slog.LevelDebug)
@@ -709,7 +1280,8 @@ func runUpdateBlocklist(cmd *cobra.Command, _ []string) error {
 	defer logResult.Cleanup()
 	logger := logResult.Logger
 
-	if len(cfg.BlocklistURLs) == 0 {
+	mirrorGroups := blocklistMirrorGroups(&cfg)
+	if len(cfg.BlocklistURLs)+len(mirrorGroups) == 0 {
 		return fmt.Errorf("no blocklist URLs configured (use --blocklist-url or config file)")
 	}
 
@@ -721,10 +1293,24 @@ func runUpdateBlocklist(cmd *cobra.Command, _ []string) error {
 	}
 	defer bl.Close() //nolint:errcheck // best-effort on shutdown
 
-	if err := bl.Update(cfg.BlocklistURLs, blocklist.HTTPFetcher()); err != nil {
+	fetch, err := blocklistFetcher(&cfg, logger)
+	if err != nil {
+		return err
+	}
+	if err := bl.UpdateLists(cfg.BlocklistURLs, mirrorGroups, fetch); err != nil {
 		return fmt.Errorf("update blocklist: %w", err)
 	}
 
+	for _, src := range bl.Sources() {
+		logger.Info("blocklist source",
+			"url", src.URL,
+			"title", src.Title,
+			"version", src.Version,
+			"expires", src.Expires,
+			"domains", src.Count,
+		)
+	}
+
 	logger.Info("blocklist update complete",
 		"domains", bl.Size(),
 		"sources", bl.SourceCount(),
@@ -740,7 +1326,18 @@ func runConfigDump(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	out, err := cfg.Dump()
+	// Secrets are masked so the dump is safe to share.
+	redacted := cfg.Redacted()
+	var out []byte
+	switch flagDumpFormat {
+	case "yaml":
+		out, err = redacted.Dump()
+	case "json":
+		out, err = redacted.DumpJSON(flagDumpPretty)
+		out = append(out, '\n')
+	default:
+		return fmt.Errorf("unknown --format %q (want yaml or json)", flagDumpFormat)
+	}
 	if err != nil {
 		return fmt.Errorf("dump config: %w", err)
 	}
@@ -768,7 +1365,36 @@ func runGenerateCA(cmd *cobra.Command, _ []string) error {
 	certPath := filepath.Join(cfg.DataDir, cfg.MITM.CACert)
 	keyPath := filepath.Join(cfg.DataDir, cfg.MITM.CAKey)
 
-	if err := mitm.GenerateCA(certPath, keyPath, flagForceCA); err != nil {
+	if cmd.Flags().Changed("cn") {
+		cfg.MITM.CASubject.CommonName = flagCACN
+	}
+	if cmd.Flags().Changed("org") {
+		cfg.MITM.CASubject.Organization = flagCAOrg
+	}
+	if cmd.Flags().Changed("ou") {
+		cfg.MITM.CASubject.OrganizationalUnit = flagCAOU
+	}
+	if cmd.Flags().Changed("key-type") {
+		cfg.MITM.CAKeyType = flagCAKeyType
+	}
+	if cmd.Flags().Changed("key-bits") {
+		cfg.MITM.CAKeyBits = flagCAKeyBits
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	subject := mitm.Subject{
+		CommonName:         cfg.MITM.CASubject.CommonName,
+		Organization:       cfg.MITM.CASubject.Organization,
+		OrganizationalUnit: cfg.MITM.CASubject.OrganizationalUnit,
+	}
+	opts := mitm.CAOptions{
+		Subject: subject,
+		KeyType: mitm.KeyType(cfg.MITM.CAKeyType),
+		RSABits: cfg.MITM.CAKeyBits,
+	}
+	if err := mitm.GenerateCAWithOptions(certPath, keyPath, opts, flagForceCA); err != nil {
 		return err
 	}
 
@@ -780,8 +1406,9 @@ func runGenerateCA(cmd *cobra.Command, _ []string) error {
 
 // pluginsResult holds initialized plugin resources.
 type pluginsResult struct {
-	dataFn       func() *probe.PluginsData
-	rewriteStore *plugin.RewriteStore
+	dataFn        func() *probe.PluginsData
+	pauses        *plugin.PauseSet
+	rewriteStore  *plugin.RewriteStore
 	rewriteReload func() error
 }
 
@@ -801,7 +1428,7 @@ func initPlugins(
 	// Convert config.PluginConf to plugin.PluginConfig.
 	pluginConfigs := make(map[string]plugin.PluginConfig, len(cfg.Plugins))
 	for name, pc := range cfg.Plugins {
-		opts := pc.Options
+		opts := maps.Clone(pc.Options) // data_dir must not leak into cfg
 		if opts == nil {
 			opts = map[string]any{}
 		}
@@ -820,20 +1447,39 @@ func initPlugins(
 	if initErr != nil {
 		return nil, fmt.Errorf("plugin init: %w", initErr)
 	}
+	if cfg.MITM.ExclusivePluginDomains {
+		if err := plugin.CheckExclusiveDomains(results); err != nil {
+			return nil, fmt.Errorf("plugin init: %w", err)
+		}
+	}
 
 	// Wire response modifier into MITM interceptor.
-	modifier := plugin.BuildResponseModifier(results,
+	pauses := plugin.NewPauseSet(results)
+	stages, stageErr := plugin.BuildStages(results, cfg.MITM.ResponsePipeline, pauses,
 		func(pluginName string) {
 			collector.RecordPluginInspected(pluginName)
 		},
 		func(pluginName, rule string, modified bool, removed int) {
 			collector.RecordPluginMatch(pluginName, rule, modified, removed)
 		},
+		collector.RecordPluginFilterDuration,
+		collector.RecordPluginPanic,
 		logger,
 	)
-	if modifier != nil {
+	if stageErr != nil {
+		return nil, fmt.Errorf("plugin init: %w", stageErr)
+	}
+	if modifier := mitm.NewPipeline(stages, cfg.MITM.DecodeLimit(), logger); modifier != nil {
+		names := make([]string, len(stages))
+		for i, st := range stages {
+			names[i] = st.Name
+		}
+		logger.Info("mitm response pipeline", "stages", names)
 		mitmInterceptor.ResponseModifier = modifier
 	}
+	if reqModifier := plugin.BuildRequestModifier(results, pauses, logger); reqModifier != nil {
+		mitmInterceptor.RequestModifier = reqModifier
+	}
 
 	logger.Info("plugins initialized", "active", len(results))
 
@@ -842,14 +1488,16 @@ func initPlugins(
 			pd := &probe.PluginsData{Active: len(results)}
 			for _, r := range results {
 				pd.Plugins = append(pd.Plugins, probe.PluginInfo{
-					Name:    r.Plugin.Name(),
-					Version: r.Plugin.Version(),
-					Mode:    r.Config.Mode,
-					Domains: r.Config.Domains,
+					Name:          r.Plugin.Name(),
+					Version:       r.Plugin.Version(),
+					Mode:          r.Config.Mode,
+					Domains:       r.Config.Domains,
+					PausedDomains: pauses.PausedDomains(r.Plugin.Name()),
 				})
 			}
 			return pd
 		},
+		pauses: pauses,
 	}
 
 	// Extract rewrite plugin store and reload function for the dashboard API.
@@ -867,7 +1515,76 @@ func initPlugins(
 	return res, nil
 }
 
+// configSnapshot captures the running allowlist, inline blocklist, and
+// plugin state as a config fragment. Dashboard allowlist additions are
+// included; a plugin's paused domains are dropped from its domain list, and
+// a plugin paused for every domain is written as disabled.
+func configSnapshot(cfg *config.Config, bl *blocklist.DB, pauses *plugin.PauseSet) config.Snapshot {
+	snap := cfg.Snapshot()
+
+	snap.Allowlist = snap.Allowlist[:0]
+	for _, e := range bl.Allowlist() {
+		snap.Allowlist = append(snap.Allowlist, e.Entry)
+	}
+
+	if pauses == nil {
+		return snap
+	}
+	for name, pc := range snap.Plugins {
+		paused := pauses.PausedDomains(name)
+		if len(paused) == 0 {
+			continue
+		}
+		var active []string
+		for _, d := range pauses.Domains(name) {
+			if !slices.Contains(paused, d) {
+				active = append(active, d)
+			}
+		}
+		if len(active) == 0 {
+			pc.Enabled = false
+		} else {
+			pc.Domains = active
+		}
+		snap.Plugins[name] = pc
+	}
+	return snap
+}
+
+// blocklistSourceEntries converts blocklist sources for stats responses.
+func blocklistSourceEntries(sources []blocklist.SourceStat) []probe.BlocklistSourceEntry {
+	now := time.Now()
+	entries := make([]probe.BlocklistSourceEntry, len(sources))
+	for i, src := range sources {
+		entries[i] = probe.BlocklistSourceEntry{
+			URL:     src.URL,
+			Title:   src.Title,
+			Version: src.Version,
+			Expires: src.Expires,
+			Domains: src.Count,
+			Fetched: src.Fetched,
+			Stale:   src.Stale(now),
+			Enabled: src.Enabled,
+			Blocks:  src.Blocks,
+		}
+	}
+	return entries
+}
+
 // makeBlockDataFn creates a callback that gathers block stats from the blocklist.
+// withIPBlocks adds the proxy's per-CIDR block counts to the block data
+// returned by fn, which may be nil when no domain blocklist is configured.
+func withIPBlocks(fn func() *probe.BlockData, srv *proxy.Server) func() *probe.BlockData {
+	return func() *probe.BlockData {
+		data := &probe.BlockData{}
+		if fn != nil {
+			data = fn()
+		}
+		data.IPBlocks = srv.IPBlocks()
+		return data
+	}
+}
+
 func makeBlockDataFn(bl *blocklist.DB) func() *probe.BlockData {
 	return func() *probe.BlockData {
 		return &probe.BlockData{
@@ -876,6 +1593,26 @@ func makeBlockDataFn(bl *blocklist.DB) func() *probe.BlockData {
 			Size:          bl.Size(),
 			AllowlistSize: bl.AllowlistSize(),
 			Sources:       bl.SourceCount(),
+			Rescued:       bl.SnapshotRescueCounts(),
+			SourceDetails: blocklistSourceEntries(bl.Sources()),
+			LockWait:      lockWaitBlock(bl),
 		}
 	}
 }
+
+// lockWaitBlock returns the blocklist's lookup lock wait totals, or nil
+// when blocklist_lock_timing is off.
+func lockWaitBlock(bl *blocklist.DB) *probe.LockWaitBlock {
+	lw, ok := bl.LockWait()
+	if !ok {
+		return nil
+	}
+	block := &probe.LockWaitBlock{
+		Lookups:         lw.Lookups,
+		WaitMicrosTotal: lw.Wait.Microseconds(),
+	}
+	if lw.Lookups > 0 {
+		block.AvgWaitNanos = float64(lw.Wait.Nanoseconds()) / float64(lw.Lookups)
+	}
+	return block
+}
@@ -3,6 +3,7 @@ module github.com/ushineko/face-puncher-supreme
 go 1.25.6
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/google/uuid v1.6.0
 	github.com/spf13/cobra v1.10.2
 	github.com/stretchr/testify v1.11.1
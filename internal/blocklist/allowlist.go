@@ -0,0 +1,197 @@
+package blocklist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Allowlist entry sources reported by Allowlist.
+const (
+	AllowSourceConfig  = "config"
+	AllowSourceManaged = "managed"
+)
+
+// Errors returned by AddAllowEntry and RemoveAllowEntry.
+var (
+	ErrAllowEntryExists     = errors.New("allowlist entry already exists")
+	ErrAllowEntryNotFound   = errors.New("allowlist entry not found")
+	ErrAllowEntryFromConfig = errors.New("allowlist entry comes from the config file")
+)
+
+// AllowEntry is a single allowlist entry and where it is defined.
+type AllowEntry struct {
+	Entry  string `json:"entry"`
+	Source string `json:"source"` // AllowSourceConfig or AllowSourceManaged
+}
+
+// NormalizeAllowEntry lowercases and trims an allowlist entry and checks
+// that it is an exact domain or a *.domain suffix pattern.
+func NormalizeAllowEntry(entry string) (string, error) {
+	entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+	domain := strings.TrimPrefix(entry, "*.")
+	if domain == "" || strings.ContainsAny(domain, "*/ \t:") {
+		return "", fmt.Errorf("invalid allowlist entry %q: want example.com or *.example.com", entry)
+	}
+	return entry, nil
+}
+
+// LoadManagedAllowlist reads managed allowlist entries from path and
+// enables persistence of runtime changes to it. A missing file is an empty
+// list. The file holds one entry per line; blank lines and # comments are
+// ignored.
+func (db *DB) LoadManagedAllowlist(path string) error {
+	var entries []string
+
+	f, err := os.Open(path) //nolint:gosec // path is operator-configured
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("open managed allowlist: %w", err)
+	default:
+		defer f.Close() //nolint:errcheck // read-only
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			entry, normErr := NormalizeAllowEntry(line)
+			if normErr != nil {
+				db.logger.Warn("skipping invalid managed allowlist entry", "path", path, "error", normErr)
+				continue
+			}
+			if !slices.Contains(entries, entry) {
+				entries = append(entries, entry)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("read managed allowlist: %w", err)
+		}
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.allowMu.Lock()
+	defer db.allowMu.Unlock()
+	db.managedPath = path
+	db.managedAllow = entries
+	db.rebuildAllowLocked()
+	return nil
+}
+
+// Allowlist returns all allowlist entries, config entries first, each
+// group sorted.
+func (db *DB) Allowlist() []AllowEntry {
+	db.allowMu.RLock()
+	defer db.allowMu.RUnlock()
+
+	result := make([]AllowEntry, 0, len(db.configAllow)+len(db.managedAllow))
+	for _, group := range []struct {
+		entries []string
+		source  string
+	}{
+		{db.configAllow, AllowSourceConfig},
+		{db.managedAllow, AllowSourceManaged},
+	} {
+		sorted := slices.Clone(group.entries)
+		slices.Sort(sorted)
+		for _, e := range slices.Compact(sorted) {
+			result = append(result, AllowEntry{Entry: e, Source: group.source})
+		}
+	}
+	return result
+}
+
+// AddAllowEntry adds a managed allowlist entry, effective immediately, and
+// persists the managed list if a file was loaded.
+func (db *DB) AddAllowEntry(entry string) (string, error) {
+	entry, err := NormalizeAllowEntry(entry)
+	if err != nil {
+		return "", err
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.allowMu.Lock()
+	defer db.allowMu.Unlock()
+
+	if slices.Contains(db.configAllow, entry) || slices.Contains(db.managedAllow, entry) {
+		return entry, ErrAllowEntryExists
+	}
+
+	updated := append(slices.Clone(db.managedAllow), entry)
+	if err := db.persistManagedLocked(updated); err != nil {
+		return entry, err
+	}
+	db.managedAllow = updated
+	db.rebuildAllowLocked()
+	return entry, nil
+}
+
+// RemoveAllowEntry removes a managed allowlist entry, effective
+// immediately. Entries defined in the config file cannot be removed.
+func (db *DB) RemoveAllowEntry(entry string) error {
+	entry, err := NormalizeAllowEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.allowMu.Lock()
+	defer db.allowMu.Unlock()
+
+	idx := slices.Index(db.managedAllow, entry)
+	if idx < 0 {
+		if slices.Contains(db.configAllow, entry) {
+			return ErrAllowEntryFromConfig
+		}
+		return ErrAllowEntryNotFound
+	}
+
+	updated := slices.Delete(slices.Clone(db.managedAllow), idx, idx+1)
+	if err := db.persistManagedLocked(updated); err != nil {
+		return err
+	}
+	db.managedAllow = updated
+	db.rebuildAllowLocked()
+	return nil
+}
+
+// persistManagedLocked atomically rewrites the managed allowlist file.
+// Caller must hold db.allowMu for writing.
+func (db *DB) persistManagedLocked(entries []string) error {
+	if db.managedPath == "" {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("# Managed by fpsd (dashboard allowlist editor). One entry per line.\n")
+	for _, e := range entries {
+		b.WriteString(e)
+		b.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(db.managedPath), ".allowlist-*")
+	if err != nil {
+		return fmt.Errorf("write managed allowlist: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // no-op after successful rename
+
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write managed allowlist: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write managed allowlist: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), db.managedPath); err != nil {
+		return fmt.Errorf("write managed allowlist: %w", err)
+	}
+	return nil
+}
@@ -5,15 +5,33 @@ in-memory cache for O(1) runtime lookups.
 The SQLite database is the persistent store. At startup, all domains are
 loaded into a map[string]struct{} for fast matching. The database is
 rebuilt when blocklist URLs are fetched via Update.
+
+Operations that change what IsBlocked matches (cache reloads after Update
+or a source toggle, SetAllowlist, AddInlineDomains, AddSchedule, and
+managed allowlist edits) are serialized by one mutex, so a config reload
+and a scheduled list refresh never interleave. Each takes effect in a
+single step under the read locks that lookups use: a concurrent lookup
+sees the state before or after it, never part of it. Separate operations
+are not atomic as a group; a lookup between SetAllowlist and
+AddInlineDomains during a reload sees the new allowlist with the old
+inline entries. Inline entries survive every cache reload.
+
+IsBlocked, Check, and Decide share one evaluation order, documented on
+Stage: allow stages (temporary allow, allowlist) beat block stages
+(temporary block, list entries, inline suffixes and patterns, schedules).
 */
 package blocklist
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
@@ -27,8 +45,28 @@ type BlockedEntry struct {
 
 // sourceInfo tracks metadata about a single blocklist source.
 type sourceInfo struct {
-	url   string
-	count int
+	url     string
+	count   int
+	meta    ListMetadata
+	domains []string
+}
+
+// Source describes a blocklist source as of its last successful fetch.
+type Source struct {
+	URL     string    `json:"url"` // mirror group name for mirror groups
+	Fetched time.Time `json:"fetched"`
+	Count   int       `json:"count"`
+	Title   string    `json:"title,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Expires string    `json:"expires,omitempty"`
+	Enabled bool      `json:"enabled"` // disabled sources contribute no domains
+}
+
+// Stale reports whether the list's declared Expires period has passed
+// since it was fetched. Lists without a parseable Expires never go stale.
+func (s Source) Stale(now time.Time) bool {
+	d, ok := ListMetadata{Expires: s.Expires}.ExpiresAfter()
+	return ok && now.Sub(s.Fetched) > d
 }
 
 // DB manages the blocklist database and in-memory cache.
@@ -36,12 +74,49 @@ type DB struct {
 	conn   *sqlite.Conn
 	logger *slog.Logger
 
+	// connMu serializes use of conn once the DB is shared (updates, source
+	// toggles, and block attribution lookups).
+	connMu sync.Mutex
+	// writeMu serializes changes to the matching state (see the package
+	// doc). Lock order: connMu, then writeMu, then mu or allowMu.
+	writeMu sync.Mutex
+	// blockSources caches the sources each blocked domain came from, for
+	// per-source block counts. Guarded by connMu; reset when the cache
+	// is reloaded.
+	blockSources map[string][]string
+
 	mu      sync.RWMutex
 	domains map[string]struct{}
-
-	// Allowlist — config-only, no persistence.
-	exactAllow  map[string]struct{} // exact-match allowlist (lowercased)
-	suffixAllow []string            // suffix patterns (lowercased, without "*." prefix)
+	inline  []string // exact inline domains, merged back on every cache load
+
+	// Inline "*.domain" entries (stored without "*.") and "re:" entries,
+	// checked only after an exact miss.
+	suffixBlock []string
+	patterns    []*regexp.Regexp
+
+	// Scheduled domains are blocked only while one of their schedules is
+	// active. now is the clock used to evaluate them.
+	schedules map[string][]*Schedule
+	now       func() time.Time
+
+	// Temporary allows and blocks set at runtime, by exact domain, with
+	// their expiry. Expired entries are pruned when looked up and by the
+	// sweeper (stopped by closing stopSweep).
+	tempMu    sync.Mutex
+	tempAllow map[string]time.Time
+	tempBlock map[string]time.Time
+	tempCount atomic.Int64 // len(tempAllow)+len(tempBlock); lock-free fast path
+	stopSweep chan struct{}
+
+	// Allowlist — config entries plus managed entries edited at runtime
+	// (persisted to managedPath). exactAllow and suffixAllow are the
+	// effective union, rebuilt on every change.
+	allowMu      sync.RWMutex
+	configAllow  []string            // normalized entries from config
+	managedAllow []string            // normalized entries added via the API
+	managedPath  string              // managed allowlist file ("" = not persisted)
+	exactAllow   map[string]struct{} // exact-match allowlist (lowercased)
+	suffixAllow  *suffixTrie         // suffix patterns (lowercased, without "*." prefix)
 
 	// Block statistics.
 	blocksTotal atomic.Int64
@@ -51,7 +126,12 @@ type DB struct {
 	allowsTotal atomic.Int64
 	allowCounts sync.Map // domain -> *atomic.Int64
 
-	sourceCount int
+	sources []Source // guarded by mu
+
+	// Read-lock wait timing for IsBlocked (see SetLockTiming).
+	lockTiming    atomic.Bool
+	lockLookups   atomic.Int64
+	lockWaitNanos atomic.Int64
 }
 
 // Open opens or creates a blocklist database at the given path and loads
@@ -63,9 +143,12 @@ func Open(dbPath string, logger *slog.Logger) (*DB, error) {
 	}
 
 	db := &DB{
-		conn:    conn,
-		logger:  logger,
-		domains: make(map[string]struct{}),
+		conn:      conn,
+		logger:    logger,
+		domains:   make(map[string]struct{}),
+		now:       time.Now,
+		tempAllow: make(map[string]time.Time),
+		tempBlock: make(map[string]time.Time),
 	}
 
 	if err := db.ensureSchema(); err != nil {
@@ -81,27 +164,26 @@ func Open(dbPath string, logger *slog.Logger) (*DB, error) {
 	return db, nil
 }
 
-// Close closes the underlying database connection.
+// Close stops the temporary entry sweeper, if running, and closes the
+// underlying database connection.
 func (db *DB) Close() error {
+	if db.stopSweep != nil {
+		close(db.stopSweep)
+	}
 	return db.conn.Close()
 }
 
-// IsBlocked returns true if the domain (case-insensitive) is in the blocklist
-// and not in the allowlist. If the domain matches both the blocklist and
-// allowlist, the allowlist wins and allow counters are incremented.
+// IsBlocked returns true if the domain (case-insensitive) matches a block
+// stage and no allow stage (see Stage for the precedence). If an allow
+// stage rescues a blocklisted domain, allow counters are incremented.
 func (db *DB) IsBlocked(domain string) bool {
 	domain = strings.ToLower(domain)
 
-	db.mu.RLock()
-	_, inBlocklist := db.domains[domain]
-	db.mu.RUnlock()
-
-	if !inBlocklist {
+	if db.blockStage(domain, true) == StageNone {
 		return false
 	}
 
-	// Check allowlist — allowlist wins over blocklist.
-	if db.isAllowed(domain) {
+	if db.allowStage(domain) != StageNone {
 		db.allowsTotal.Add(1)
 		val, _ := db.allowCounts.LoadOrStore(domain, &atomic.Int64{})
 		if counter, ok := val.(*atomic.Int64); ok {
@@ -110,20 +192,43 @@ func (db *DB) IsBlocked(domain string) bool {
 		return false
 	}
 
+	db.recordBlock(domain)
+	return true
+}
+
+// recordBlock counts a block of domain.
+func (db *DB) recordBlock(domain string) {
 	db.blocksTotal.Add(1)
 	val, _ := db.blockCounts.LoadOrStore(domain, &atomic.Int64{})
 	if counter, ok := val.(*atomic.Int64); ok {
 		counter.Add(1)
 	}
-	return true
 }
 
-// isAllowed checks whether a domain matches the allowlist (exact or suffix).
-func (db *DB) isAllowed(domain string) bool {
-	if _, ok := db.exactAllow[domain]; ok {
-		return true
+// Check reports whether the domain (case-insensitive) is in the blocklist
+// and whether it matches the allowlist, without touching block or allow
+// counters. Used for dry-run lookups such as the dashboard domain test.
+func (db *DB) Check(domain string) (blocklisted, allowlisted bool) {
+	d := db.Decide(domain)
+	return d.Block != StageNone, d.Allow != StageNone
+}
+
+// scheduleActive reports whether any schedule for domain is active now.
+// Caller must hold db.mu.
+func (db *DB) scheduleActive(domain string) bool {
+	now := db.now()
+	for _, s := range db.schedules[domain] {
+		if s.Active(now) {
+			return true
+		}
 	}
-	for _, suffix := range db.suffixAllow {
+	return false
+}
+
+// suffixMatch reports whether domain is, or is a subdomain of, an inline
+// suffix entry. Caller must hold db.mu.
+func (db *DB) suffixMatch(domain string) bool {
+	for _, suffix := range db.suffixBlock {
 		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
 			return true
 		}
@@ -131,6 +236,28 @@ func (db *DB) isAllowed(domain string) bool {
 	return false
 }
 
+// patternMatch reports whether any inline pattern matches domain.
+// Caller must hold db.mu.
+func (db *DB) patternMatch(domain string) bool {
+	for _, re := range db.patterns {
+		if re.MatchString(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowed checks whether a domain matches the allowlist (exact or suffix).
+func (db *DB) isAllowed(domain string) bool {
+	db.allowMu.RLock()
+	defer db.allowMu.RUnlock()
+
+	if _, ok := db.exactAllow[domain]; ok {
+		return true
+	}
+	return db.suffixAllow.match(domain)
+}
+
 // BlocksTotal returns the total number of blocked requests since startup.
 func (db *DB) BlocksTotal() int64 {
 	return db.blocksTotal.Load()
@@ -145,7 +272,9 @@ func (db *DB) Size() int {
 
 // SourceCount returns the number of configured blocklist sources.
 func (db *DB) SourceCount() int {
-	return db.sourceCount
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.sources)
 }
 
 // TopBlocked returns the top n blocked domains by count.
@@ -184,20 +313,38 @@ func (db *DB) TopBlocked(n int) []BlockedEntry {
 
 // SetAllowlist configures the allowlist from config entries. Each entry
 // is either an exact domain ("example.com") or a suffix pattern ("*.example.com").
-// This replaces any existing allowlist and should be called once at startup.
+// This replaces the config entries (on startup and reload); managed entries
+// added at runtime are kept.
 func (db *DB) SetAllowlist(entries []string) {
-	exact := make(map[string]struct{}, len(entries))
-	var suffixes []string
-
+	var normalized []string
 	for _, entry := range entries {
 		entry = strings.ToLower(strings.TrimSpace(entry))
-		if entry == "" {
-			continue
+		if entry != "" {
+			normalized = append(normalized, entry)
 		}
-		if strings.HasPrefix(entry, "*.") {
-			suffixes = append(suffixes, entry[2:])
-		} else {
-			exact[entry] = struct{}{}
+	}
+
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.allowMu.Lock()
+	db.configAllow = normalized
+	db.rebuildAllowLocked()
+	db.allowMu.Unlock()
+}
+
+// rebuildAllowLocked recomputes the effective allowlist from config and
+// managed entries. Caller must hold db.allowMu for writing.
+func (db *DB) rebuildAllowLocked() {
+	exact := make(map[string]struct{}, len(db.configAllow)+len(db.managedAllow))
+	suffixes := &suffixTrie{}
+
+	for _, list := range [][]string{db.configAllow, db.managedAllow} {
+		for _, entry := range list {
+			if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+				suffixes.add(suffix)
+			} else {
+				exact[entry] = struct{}{}
+			}
 		}
 	}
 
@@ -205,32 +352,135 @@ func (db *DB) SetAllowlist(entries []string) {
 	db.suffixAllow = suffixes
 }
 
+// PatternPrefix marks an inline blocklist entry as a regular expression.
+const PatternPrefix = "re:"
+
 // AddInlineDomains merges inline blocklist domains (from config) into the
 // in-memory cache. These are not stored in SQLite and survive across
 // update-blocklist runs (they come from config, not from downloaded URLs).
+//
+// Entries of the form "*.example.com" block example.com and all of its
+// subdomains, like allowlist suffix patterns. Entries prefixed with "re:" are
+// regular expressions matched case-insensitively against the domain. Both are
+// checked only when the exact lookup misses. Invalid patterns are logged and
+// skipped; config validation rejects them earlier. Entries already present
+// are ignored, so re-adding the config on reload is idempotent.
 func (db *DB) AddInlineDomains(domains []string) {
 	if len(domains) == 0 {
 		return
 	}
 
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, d := range domains {
+		d = strings.TrimSpace(d)
+		if expr, ok := strings.CutPrefix(d, PatternPrefix); ok {
+			db.addPatternLocked(expr)
+			continue
+		}
+		d = strings.ToLower(d)
+		if suffix, ok := strings.CutPrefix(d, "*."); ok {
+			if suffix != "" && !slices.Contains(db.suffixBlock, suffix) {
+				db.suffixBlock = append(db.suffixBlock, suffix)
+			}
+			continue
+		}
+		if d != "" && !slices.Contains(db.inline, d) {
+			db.domains[d] = struct{}{}
+			db.inline = append(db.inline, d)
+		}
+	}
+}
+
+// addPatternLocked compiles and appends expr unless an identical pattern is
+// already present (config reloads re-add every entry). The pattern is made
+// case-insensitive because it is matched against the lowercased domain.
+// Caller must hold db.mu.
+func (db *DB) addPatternLocked(expr string) {
+	expr = "(?i)" + expr
+	for _, re := range db.patterns {
+		if re.String() == expr {
+			return
+		}
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		db.logger.Warn("invalid blocklist pattern skipped", "pattern", expr, "error", err)
+		return
+	}
+	db.patterns = append(db.patterns, re)
+}
+
+// SuffixCount returns the number of inline "*.domain" suffix entries.
+func (db *DB) SuffixCount() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.suffixBlock)
+}
+
+// PatternCount returns the number of inline regex patterns.
+func (db *DB) PatternCount() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.patterns)
+}
+
+// AddSchedule blocks domains only while s is active. Domains that are also
+// on the regular blocklist stay blocked at all times.
+func (db *DB) AddSchedule(domains []string, s *Schedule) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.schedules == nil {
+		db.schedules = make(map[string][]*Schedule)
+	}
 	for _, d := range domains {
 		d = strings.ToLower(strings.TrimSpace(d))
 		if d != "" {
-			db.domains[d] = struct{}{}
+			db.schedules[d] = append(db.schedules[d], s)
 		}
 	}
+}
+
+// ScheduledSize returns the number of domains with a block schedule.
+func (db *DB) ScheduledSize() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.schedules)
+}
+
+// SetClock replaces the clock used to evaluate schedules and temporary
+// entries. For tests.
+func (db *DB) SetClock(now func() time.Time) {
+	db.mu.Lock()
+	db.now = now
 	db.mu.Unlock()
 }
 
 // AllowsTotal returns the total number of allowed requests since startup.
+// Only blocklist overrides count: a request for an allowlisted domain that
+// no blocklist covers is not an allow.
 func (db *DB) AllowsTotal() int64 {
 	return db.allowsTotal.Load()
 }
 
+// ResetCounters zeroes the block and allow counters, totals and per-domain.
+func (db *DB) ResetCounters() {
+	db.blocksTotal.Store(0)
+	db.blockCounts.Clear()
+	db.allowsTotal.Store(0)
+	db.allowCounts.Clear()
+}
+
 // AllowlistSize returns the number of allowlist entries (exact + suffix).
 func (db *DB) AllowlistSize() int {
-	return len(db.exactAllow) + len(db.suffixAllow)
+	db.allowMu.RLock()
+	defer db.allowMu.RUnlock()
+	return len(db.exactAllow) + db.suffixAllow.len()
 }
 
 // TopAllowed returns the top n allowed domains by count.
@@ -272,7 +522,7 @@ func (db *DB) TopAllowed(n int) []BlockedEntry {
 func (db *DB) SnapshotAllowCounts() map[string]int64 {
 	result := make(map[string]int64)
 	db.allowCounts.Range(func(key, value any) bool {
-		domain, _ := key.(string)         //nolint:errcheck // type is guaranteed by LoadOrStore
+		domain, _ := key.(string)           //nolint:errcheck // type is guaranteed by LoadOrStore
 		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed by LoadOrStore
 		result[domain] = counter.Load()
 		return true
@@ -280,27 +530,68 @@ func (db *DB) SnapshotAllowCounts() map[string]int64 {
 	return result
 }
 
+// SnapshotRescueCounts returns allow counts for domains that are currently
+// both blocklisted and allowlisted, i.e. requests the allowlist rescued.
+// Unlike SnapshotAllowCounts, domains that have since left either list are
+// dropped, so the result audits the allowlist as it stands.
+func (db *DB) SnapshotRescueCounts() map[string]int64 {
+	result := make(map[string]int64)
+	db.allowCounts.Range(func(key, value any) bool {
+		domain, _ := key.(string)           //nolint:errcheck // type is guaranteed by LoadOrStore
+		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed by LoadOrStore
+		if blocklisted, allowlisted := db.Check(domain); blocklisted && allowlisted {
+			result[domain] = counter.Load()
+		}
+		return true
+	})
+	return result
+}
+
 // Update downloads blocklists from the given URLs, parses them, and
 // rebuilds the database. This replaces all existing domain data.
 func (db *DB) Update(urls []string, fetchFn FetchFunc) error {
-	var allDomains []string
+	return db.UpdateWithMirrors(urls, nil, fetchFn)
+}
+
+// UpdateWithMirrors is Update plus mirror groups, each fetched from a single
+// mirror chosen by its strategy and recorded as one source.
+func (db *DB) UpdateWithMirrors(urls []string, groups []MirrorGroup, fetchFn FetchFunc) error {
+	return db.UpdateLists(urls, groups, fetchFn.withMetadata())
+}
+
+// UpdateLists is UpdateWithMirrors with a fetcher that also reports each
+// list's header metadata, which is stored with the source.
+func (db *DB) UpdateLists(urls []string, groups []MirrorGroup, fetchFn ListFetchFunc) error {
+	db.connMu.Lock()
+	defer db.connMu.Unlock()
+
 	var sources []sourceInfo
 
 	for _, u := range urls {
 		db.logger.Info("fetching blocklist", "url", u)
 
-		domains, err := fetchFn(u)
+		domains, meta, err := fetchFn(u)
 		if err != nil {
 			db.logger.Error("failed to fetch blocklist", "url", u, "error", err)
 			continue
 		}
 
-		db.logger.Info("parsed blocklist", "url", u, "domains", len(domains))
-		sources = append(sources, sourceInfo{url: u, count: len(domains)})
-		allDomains = append(allDomains, domains...)
+		db.logger.Info("parsed blocklist", "url", u, "domains", len(domains), "title", meta.Title, "version", meta.Version)
+		sources = append(sources, sourceInfo{url: u, count: len(domains), meta: meta, domains: domains})
 	}
 
-	if err := db.rebuildDB(allDomains, sources); err != nil {
+	for _, g := range groups {
+		domains, meta, used, err := db.fetchMirrorGroup(g, fetchFn)
+		if err != nil {
+			db.logger.Error("failed to fetch blocklist", "source", g.Name, "error", err)
+			continue
+		}
+
+		db.logger.Info("parsed blocklist", "source", g.Name, "url", used, "domains", len(domains), "title", meta.Title, "version", meta.Version)
+		sources = append(sources, sourceInfo{url: g.Name, count: len(domains), meta: meta, domains: domains})
+	}
+
+	if err := db.rebuildDB(sources); err != nil {
 		return fmt.Errorf("rebuild blocklist db: %w", err)
 	}
 
@@ -308,7 +599,6 @@ func (db *DB) Update(urls []string, fetchFn FetchFunc) error {
 		return fmt.Errorf("reload cache: %w", err)
 	}
 
-	db.sourceCount = len(sources)
 	db.logger.Info("blocklist updated",
 		"domains", db.Size(),
 		"sources", len(sources),
@@ -317,9 +607,37 @@ func (db *DB) Update(urls []string, fetchFn FetchFunc) error {
 	return nil
 }
 
+// ErrEmpty is returned by InitialUpdate when no list yielded any domains.
+var ErrEmpty = errors.New("blocklist is empty after fetching all sources")
+
+// InitialUpdate runs UpdateLists for a first start with an empty database,
+// trying up to attempts times while the result is still empty (every
+// fetch failed), waiting backoff before the first retry and doubling it
+// each time. It returns ErrEmpty if no attempt loaded any domains.
+func (db *DB) InitialUpdate(urls []string, groups []MirrorGroup, fetchFn ListFetchFunc, attempts int, backoff time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		if err := db.UpdateLists(urls, groups, fetchFn); err != nil {
+			return err
+		}
+		if db.Size() > 0 {
+			return nil
+		}
+		if attempt >= attempts {
+			return ErrEmpty
+		}
+		db.logger.Warn("blocklist still empty after fetch, retrying",
+			"attempt", attempt,
+			"of", attempts,
+			"backoff", backoff,
+		)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 // ensureSchema creates the database tables if they don't exist.
 func (db *DB) ensureSchema() error {
-	return sqlitex.ExecuteScript(db.conn, `
+	err := sqlitex.ExecuteScript(db.conn, `
 		CREATE TABLE IF NOT EXISTS domains (
 			domain TEXT NOT NULL PRIMARY KEY
 		) WITHOUT ROWID;
@@ -327,82 +645,187 @@ func (db *DB) ensureSchema() error {
 		CREATE TABLE IF NOT EXISTS sources (
 			url     TEXT NOT NULL PRIMARY KEY,
 			fetched TEXT NOT NULL,
-			count   INTEGER NOT NULL
+			count   INTEGER NOT NULL,
+			title   TEXT NOT NULL DEFAULT '',
+			version TEXT NOT NULL DEFAULT '',
+			expires TEXT NOT NULL DEFAULT '',
+			enabled INTEGER NOT NULL DEFAULT 1
+		) WITHOUT ROWID;
+
+		CREATE TABLE IF NOT EXISTS domain_sources (
+			domain TEXT NOT NULL,
+			source TEXT NOT NULL,
+			PRIMARY KEY (domain, source)
+		) WITHOUT ROWID;
+
+		CREATE TABLE IF NOT EXISTS mirror_state (
+			name TEXT NOT NULL PRIMARY KEY,
+			next INTEGER NOT NULL
 		) WITHOUT ROWID;
 	`, nil)
+	if err != nil {
+		return err
+	}
+	return db.migrateSchema()
 }
 
-// loadCache reads all domains from SQLite into the in-memory map.
-func (db *DB) loadCache() error {
-	newDomains := make(map[string]struct{})
-
-	err := sqlitex.Execute(db.conn, "SELECT domain FROM domains", &sqlitex.ExecOptions{
+// migrateSchema adds columns that may be missing from older databases.
+func (db *DB) migrateSchema() error {
+	columns := make(map[string]bool)
+	err := sqlitex.Execute(db.conn, "PRAGMA table_info(sources)", &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
-			newDomains[stmt.ColumnText(0)] = struct{}{}
+			columns[stmt.ColumnText(1)] = true
 			return nil
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("load domains from db: %w", err)
+		return fmt.Errorf("check schema: %w", err)
+	}
+	for _, column := range []string{"title", "version", "expires", "enabled"} {
+		if columns[column] {
+			continue
+		}
+		def := "TEXT NOT NULL DEFAULT ''"
+		if column == "enabled" {
+			def = "INTEGER NOT NULL DEFAULT 1"
+		}
+		ddl := "ALTER TABLE sources ADD COLUMN " + column + " " + def
+		if err := sqlitex.ExecuteTransient(db.conn, ddl, nil); err != nil {
+			return fmt.Errorf("migrate sources.%s column: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// activeDomainsQuery selects domains contributed by at least one enabled
+// source. Domains with no recorded source (databases built before sources
+// were tracked per domain) stay active.
+const activeDomainsQuery = `
+	SELECT d.domain FROM domains d
+	WHERE NOT EXISTS (SELECT 1 FROM domain_sources ds WHERE ds.domain = d.domain)
+	   OR EXISTS (
+		SELECT 1 FROM domain_sources ds JOIN sources s ON s.url = ds.source
+		WHERE ds.domain = d.domain AND s.enabled = 1)`
+
+// loadCache reads the domains of enabled sources from SQLite into the
+// in-memory map, keeping inline domains. The new map is built in full and
+// swapped in under mu. Caller must hold connMu.
+func (db *DB) loadCache() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	var sources []Source
+	disabled := false
+	err := sqlitex.Execute(db.conn,
+		"SELECT url, fetched, count, title, version, expires, enabled FROM sources ORDER BY url",
+		&sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				fetched, _ := time.Parse(time.DateTime, stmt.ColumnText(1)) //nolint:errcheck // written by datetime('now')
+				src := Source{
+					URL:     stmt.ColumnText(0),
+					Fetched: fetched,
+					Count:   stmt.ColumnInt(2),
+					Title:   stmt.ColumnText(3),
+					Version: stmt.ColumnText(4),
+					Expires: stmt.ColumnText(5),
+					Enabled: stmt.ColumnBool(6),
+				}
+				disabled = disabled || !src.Enabled
+				sources = append(sources, src)
+				return nil
+			},
+		})
+	if err != nil {
+		return fmt.Errorf("load sources: %w", err)
 	}
 
-	// Count sources.
-	var sourceCount int
-	err = sqlitex.Execute(db.conn, "SELECT COUNT(*) FROM sources", &sqlitex.ExecOptions{
+	query := "SELECT domain FROM domains"
+	if disabled {
+		query = activeDomainsQuery
+	}
+	newDomains := make(map[string]struct{})
+	err = sqlitex.Execute(db.conn, query, &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
-			sourceCount = stmt.ColumnInt(0)
+			newDomains[stmt.ColumnText(0)] = struct{}{}
 			return nil
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("count sources: %w", err)
+		return fmt.Errorf("load domains from db: %w", err)
 	}
 
 	db.mu.Lock()
+	for _, d := range db.inline {
+		newDomains[d] = struct{}{}
+	}
 	db.domains = newDomains
+	db.sources = sources
 	db.mu.Unlock()
-	db.sourceCount = sourceCount
+	db.blockSources = nil
 
 	return nil
 }
 
-// rebuildDB replaces the domains table contents in a transaction.
-func (db *DB) rebuildDB(domains []string, sources []sourceInfo) (err error) {
+// rebuildDB replaces the domains and sources tables in a transaction,
+// recording which sources contributed each domain. Sources that were
+// disabled stay disabled.
+func (db *DB) rebuildDB(sources []sourceInfo) (err error) {
 	defer sqlitex.Save(db.conn)(&err)
 
-	// Clear existing data. Assignments use named return err for deferred Save.
-	if err = sqlitex.Execute(db.conn, "DELETE FROM domains", nil); err != nil { //nolint:gocritic // named return for sqlitex.Save
-		return err
-	}
-	if err = sqlitex.Execute(db.conn, "DELETE FROM sources", nil); err != nil { //nolint:gocritic // named return for sqlitex.Save
+	disabled := make(map[string]bool)
+	err = sqlitex.Execute(db.conn, "SELECT url FROM sources WHERE enabled = 0", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			disabled[stmt.ColumnText(0)] = true
+			return nil
+		},
+	})
+	if err != nil {
 		return err
 	}
 
-	// Deduplicate and insert domains.
-	seen := make(map[string]struct{}, len(domains))
-	for _, d := range domains {
-		d = strings.ToLower(d)
-		if _, ok := seen[d]; ok {
-			continue
+	// Clear existing data. Assignments use named return err for deferred Save.
+	for _, table := range []string{"domains", "sources", "domain_sources"} {
+		if err = sqlitex.Execute(db.conn, "DELETE FROM "+table, nil); err != nil { //nolint:gocritic // named return for sqlitex.Save
+			return err
 		}
-		seen[d] = struct{}{}
+	}
 
-		err = sqlitex.Execute(db.conn,
-			"INSERT INTO domains (domain) VALUES (?)",
-			&sqlitex.ExecOptions{
-				Args: []any{d},
-			})
-		if err != nil {
-			return fmt.Errorf("insert domain %q: %w", d, err)
+	// Deduplicate and insert domains, and each (domain, source) pair.
+	seen := make(map[string]struct{})
+	for _, s := range sources {
+		for _, d := range s.domains {
+			d = strings.ToLower(d)
+			err = sqlitex.Execute(db.conn,
+				"INSERT OR IGNORE INTO domain_sources (domain, source) VALUES (?, ?)",
+				&sqlitex.ExecOptions{
+					Args: []any{d, s.url},
+				})
+			if err != nil {
+				return fmt.Errorf("insert domain source %q: %w", d, err)
+			}
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = struct{}{}
+
+			err = sqlitex.Execute(db.conn,
+				"INSERT INTO domains (domain) VALUES (?)",
+				&sqlitex.ExecOptions{
+					Args: []any{d},
+				})
+			if err != nil {
+				return fmt.Errorf("insert domain %q: %w", d, err)
+			}
 		}
 	}
 
 	// Insert source metadata.
 	for _, s := range sources {
 		err = sqlitex.Execute(db.conn,
-			"INSERT OR REPLACE INTO sources (url, fetched, count) VALUES (?, datetime('now'), ?)",
+			`INSERT OR REPLACE INTO sources (url, fetched, count, title, version, expires, enabled)
+			 VALUES (?, datetime('now'), ?, ?, ?, ?, ?)`,
 			&sqlitex.ExecOptions{
-				Args: []any{s.url, s.count},
+				Args: []any{s.url, s.count, s.meta.Title, s.meta.Version, s.meta.Expires, !disabled[s.url]},
 			})
 		if err != nil {
 			return fmt.Errorf("insert source %q: %w", s.url, err)
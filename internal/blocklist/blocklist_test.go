@@ -1,10 +1,21 @@
 package blocklist_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -108,6 +119,143 @@ func TestParseDomains_InlineComments(t *testing.T) {
 	assert.Equal(t, []string{"ad.example.com"}, domains)
 }
 
+func TestParseDomainsWith_ExcludePatterns(t *testing.T) {
+	input := `0.0.0.0 ad.example.com
+0.0.0.0 cdn.good.com
+0.0.0.0 static.good.com
+||tracker.example.com^
+# comment mentioning good.com
+`
+	opts, err := blocklist.NewParseOptions([]string{`good\.com$`, `^\|\|`, `nomatch`})
+	require.NoError(t, err)
+
+	domains, excluded := blocklist.ParseDomainsWith(strings.NewReader(input), opts)
+	assert.Equal(t, []string{"ad.example.com"}, domains)
+	assert.Equal(t, []int{2, 1, 0}, excluded, "comments are skipped before exclusion")
+}
+
+func TestParseDomainsWith_FirstMatchingPatternCounts(t *testing.T) {
+	opts, err := blocklist.NewParseOptions([]string{`ads`, `example`})
+	require.NoError(t, err)
+
+	domains, excluded := blocklist.ParseDomainsWith(strings.NewReader("ads.example.com\n"), opts)
+	assert.Empty(t, domains)
+	assert.Equal(t, []int{1, 0}, excluded)
+}
+
+func TestParseDomainsWith_NoOptions(t *testing.T) {
+	domains, excluded := blocklist.ParseDomainsWith(strings.NewReader("ad.example.com\n"), blocklist.ParseOptions{})
+	assert.Equal(t, []string{"ad.example.com"}, domains)
+	assert.Empty(t, excluded)
+}
+
+func TestNewParseOptions_InvalidPattern(t *testing.T) {
+	_, err := blocklist.NewParseOptions([]string{"ok", "(unclosed"})
+	assert.ErrorContains(t, err, "(unclosed")
+}
+
+func TestParseList_AdblockMetadata(t *testing.T) {
+	input := `[Adblock Plus 2.0]
+! Title: EasyPrivacy
+! Version: 202610160812
+! Expires: 4 days (update frequency)
+! Homepage: https://easylist.to/
+||tracker.example.com^
+! Title: Not the header
+`
+	domains, _, meta := blocklist.ParseList(strings.NewReader(input), blocklist.ParseOptions{})
+	assert.Equal(t, []string{"tracker.example.com"}, domains)
+	assert.Equal(t, blocklist.ListMetadata{
+		Title:   "EasyPrivacy",
+		Version: "202610160812",
+		Expires: "4 days (update frequency)",
+	}, meta)
+
+	d, ok := meta.ExpiresAfter()
+	assert.True(t, ok)
+	assert.Equal(t, 96*time.Hour, d)
+}
+
+func TestParseList_HostsMetadata(t *testing.T) {
+	input := `# Title: StevenBlack/hosts
+# Version: 3.14.121
+#
+0.0.0.0 ad.example.com
+`
+	domains, _, meta := blocklist.ParseList(strings.NewReader(input), blocklist.ParseOptions{})
+	assert.Equal(t, []string{"ad.example.com"}, domains)
+	assert.Equal(t, "StevenBlack/hosts", meta.Title)
+	assert.Equal(t, "3.14.121", meta.Version)
+	assert.Empty(t, meta.Expires)
+
+	_, ok := meta.ExpiresAfter()
+	assert.False(t, ok)
+}
+
+func TestListMetadata_ExpiresAfter(t *testing.T) {
+	for expires, want := range map[string]time.Duration{
+		"12 hours":     12 * time.Hour,
+		"1 day":        24 * time.Hour,
+		"2 weeks":      14 * 24 * time.Hour,
+		"soon":         0,
+		"0 days":       0,
+		"3 fortnights": 0,
+	} {
+		d, _ := blocklist.ListMetadata{Expires: expires}.ExpiresAfter()
+		assert.Equal(t, want, d, expires)
+	}
+}
+
+// --- Fetcher tests ---
+
+func _gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestHTTPListFetcher_Gzip(t *testing.T) {
+	list := "! Title: Zipped\nads.example.com\n0.0.0.0 tracker.example.com\n"
+	compressed := _gzipBytes(t, list)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			_, _ = w.Write(compressed)
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(compressed)
+		case "/plain":
+			_, _ = io.WriteString(w, list)
+		case "/bad.gz":
+			_, _ = io.WriteString(w, "not gzip at all")
+		case "/truncated":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(compressed[:len(compressed)-6])
+		}
+	}))
+	defer srv.Close()
+
+	fetch := blocklist.HTTPListFetcher(nil, discardLogger)
+	for _, path := range []string{"/list.gz", "/encoded", "/plain"} {
+		domains, meta, err := fetch(srv.URL + path)
+		require.NoError(t, err, path)
+		assert.Equal(t, []string{"ads.example.com", "tracker.example.com"}, domains, path)
+		assert.Equal(t, "Zipped", meta.Title, path)
+	}
+
+	_, _, err := fetch(srv.URL + "/bad.gz")
+	assert.ErrorContains(t, err, "gzip")
+
+	_, _, err = fetch(srv.URL + "/truncated")
+	assert.Error(t, err, "a truncated gzip stream must fail the fetch")
+}
+
 // --- DB tests ---
 
 func TestDBOpenClose(t *testing.T) {
@@ -133,6 +281,155 @@ func TestDBUpdate(t *testing.T) {
 	assert.Equal(t, 1, db.SourceCount())
 }
 
+func TestDBInitialUpdateRetries(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	// The first two fetches fail transiently, the third succeeds.
+	calls := 0
+	fetch := func(string) ([]string, blocklist.ListMetadata, error) {
+		calls++
+		if calls < 3 {
+			return nil, blocklist.ListMetadata{}, errors.New("connection reset")
+		}
+		return []string{"ad.example.com"}, blocklist.ListMetadata{}, nil
+	}
+	require.NoError(t, db.InitialUpdate([]string{"http://list"}, nil, blocklist.ListFetchFunc(fetch), 4, time.Millisecond))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 1, db.Size())
+}
+
+func TestDBInitialUpdateEmpty(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	calls := 0
+	fetch := func(string) ([]string, blocklist.ListMetadata, error) {
+		calls++
+		return nil, blocklist.ListMetadata{}, errors.New("no such host")
+	}
+	err = db.InitialUpdate([]string{"http://list"}, nil, blocklist.ListFetchFunc(fetch), 3, time.Millisecond)
+	require.ErrorIs(t, err, blocklist.ErrEmpty)
+	assert.Equal(t, 3, calls, "gives up after the configured attempts")
+	assert.Equal(t, 0, db.Size())
+}
+
+func TestDBUpdateListsStoresMetadata(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	fetch := func(url string) ([]string, blocklist.ListMetadata, error) {
+		return []string{"ad.example.com"}, blocklist.ListMetadata{Title: "Example", Version: "7", Expires: "1 day"}, nil
+	}
+	require.NoError(t, db.UpdateLists([]string{"http://list"}, nil, blocklist.ListFetchFunc(fetch)))
+
+	sources := db.Sources()
+	require.Len(t, sources, 1)
+	src := sources[0]
+	assert.Equal(t, "http://list", src.URL)
+	assert.Equal(t, 1, src.Count)
+	assert.Equal(t, "Example", src.Title)
+	assert.Equal(t, "7", src.Version)
+	assert.Equal(t, "1 day", src.Expires)
+	assert.WithinDuration(t, time.Now(), src.Fetched, time.Minute)
+
+	assert.False(t, src.Stale(src.Fetched.Add(23*time.Hour)))
+	assert.True(t, src.Stale(src.Fetched.Add(25*time.Hour)))
+}
+
+func TestDBSourcesMetadataPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.db")
+	db, err := blocklist.Open(path, discardLogger)
+	require.NoError(t, err)
+	fetch := func(url string) ([]string, blocklist.ListMetadata, error) {
+		return []string{"ad.example.com"}, blocklist.ListMetadata{Title: "Example"}, nil
+	}
+	require.NoError(t, db.UpdateLists([]string{"http://list"}, nil, blocklist.ListFetchFunc(fetch)))
+	require.NoError(t, db.Close())
+
+	db, err = blocklist.Open(path, discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+	require.Len(t, db.Sources(), 1)
+	assert.Equal(t, "Example", db.Sources()[0].Title)
+	assert.Equal(t, 1, db.SourceCount())
+}
+
+// twoListFetch serves two overlapping lists for source toggle tests.
+func twoListFetch(url string) ([]string, error) {
+	if url == "http://a" {
+		return []string{"ads.example.com", "shared.example.com"}, nil
+	}
+	return []string{"track.example.com", "shared.example.com"}, nil
+}
+
+func TestDBSetSourceEnabled(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	require.NoError(t, db.Update([]string{"http://a", "http://b"}, blocklist.FetchFunc(twoListFetch)))
+	db.AddInlineDomains([]string{"inline.example.com"})
+	assert.Equal(t, 4, db.Size())
+
+	require.NoError(t, db.SetSourceEnabled("http://a", false))
+	assert.False(t, db.IsBlocked("ads.example.com"))
+	assert.True(t, db.IsBlocked("shared.example.com"), "still listed by an enabled source")
+	assert.True(t, db.IsBlocked("track.example.com"))
+	assert.True(t, db.IsBlocked("inline.example.com"), "inline domains survive the reload")
+	assert.Equal(t, 2, db.SourceCount())
+	assert.False(t, db.Sources()[0].Enabled)
+	assert.True(t, db.Sources()[1].Enabled)
+
+	// Disabled state survives an update.
+	require.NoError(t, db.Update([]string{"http://a", "http://b"}, blocklist.FetchFunc(twoListFetch)))
+	assert.False(t, db.IsBlocked("ads.example.com"))
+
+	require.NoError(t, db.SetSourceEnabled("http://a", true))
+	assert.True(t, db.IsBlocked("ads.example.com"))
+
+	assert.ErrorIs(t, db.SetSourceEnabled("http://missing", false), blocklist.ErrSourceNotFound)
+}
+
+func TestDBSourceBlocks(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	require.NoError(t, db.Update([]string{"http://a", "http://b"}, blocklist.FetchFunc(twoListFetch)))
+	db.IsBlocked("ads.example.com")
+	db.IsBlocked("ads.example.com")
+	db.IsBlocked("shared.example.com")
+
+	sources := db.Sources()
+	require.Len(t, sources, 2)
+	assert.Equal(t, "http://a", sources[0].URL)
+	assert.Equal(t, int64(3), sources[0].Blocks)
+	assert.Equal(t, int64(1), sources[1].Blocks, "shared domains count for every source listing them")
+
+	db.IsBlocked("track.example.com")
+	assert.Equal(t, int64(2), db.Sources()[1].Blocks)
+}
+
+func TestDBSourceEnabledPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.db")
+	db, err := blocklist.Open(path, discardLogger)
+	require.NoError(t, err)
+	require.NoError(t, db.Update([]string{"http://a", "http://b"}, blocklist.FetchFunc(twoListFetch)))
+	require.NoError(t, db.SetSourceEnabled("http://b", false))
+	require.NoError(t, db.Close())
+
+	db, err = blocklist.Open(path, discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+	assert.False(t, db.IsBlocked("track.example.com"))
+	assert.True(t, db.IsBlocked("shared.example.com"))
+	assert.Equal(t, 2, db.Size())
+}
+
 func TestDBIsBlocked(t *testing.T) {
 	db, err := blocklist.Open(":memory:", discardLogger)
 	require.NoError(t, err)
@@ -223,6 +520,103 @@ func TestDBUpdateRebuilds(t *testing.T) {
 	assert.True(t, db.IsBlocked("new1.com"))
 }
 
+func TestUpdateMirrorGroupFailover(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	var fetched []string
+	fetch := func(url string) ([]string, error) {
+		fetched = append(fetched, url)
+		if url == "http://mirror1" {
+			return nil, errors.New("rate limited")
+		}
+		return []string{"ads.example.com", "ADS.example.com"}, nil
+	}
+	group := blocklist.MirrorGroup{
+		Name:     "big-list",
+		Mirrors:  []string{"http://mirror1", "http://mirror2", "http://mirror3"},
+		Strategy: blocklist.StrategyFailover,
+	}
+
+	require.NoError(t, db.UpdateWithMirrors(nil, []blocklist.MirrorGroup{group}, blocklist.FetchFunc(fetch)))
+	assert.Equal(t, []string{"http://mirror1", "http://mirror2"}, fetched)
+	assert.Equal(t, 1, db.Size())
+	assert.Equal(t, 1, db.SourceCount(), "mirror group is one logical source")
+	assert.True(t, db.IsBlocked("ads.example.com"))
+}
+
+func TestUpdateMirrorGroupRoundRobinAdvances(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "blocklist.db")
+	group := blocklist.MirrorGroup{
+		Name:     "big-list",
+		Mirrors:  []string{"http://mirror1", "http://mirror2", "http://mirror3"},
+		Strategy: blocklist.StrategyRoundRobin,
+	}
+
+	var fetched []string
+	fetch := blocklist.FetchFunc(func(url string) ([]string, error) {
+		fetched = append(fetched, url)
+		return []string{"ads.example.com"}, nil
+	})
+
+	// Each update uses the next mirror; the position survives reopening.
+	for range 2 {
+		db, err := blocklist.Open(dbPath, discardLogger)
+		require.NoError(t, err)
+		require.NoError(t, db.UpdateWithMirrors(nil, []blocklist.MirrorGroup{group}, fetch))
+		require.NoError(t, db.UpdateWithMirrors(nil, []blocklist.MirrorGroup{group}, fetch))
+		require.NoError(t, db.Close())
+	}
+	assert.Equal(t, []string{
+		"http://mirror1", "http://mirror2", "http://mirror3", "http://mirror1",
+	}, fetched)
+}
+
+func TestUpdateMirrorGroupRoundRobinSkipsFailedMirror(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	group := blocklist.MirrorGroup{
+		Name:     "big-list",
+		Mirrors:  []string{"http://mirror1", "http://mirror2", "http://mirror1"},
+		Strategy: blocklist.StrategyRoundRobin,
+	}
+	var fetched []string
+	fetch := blocklist.FetchFunc(func(url string) ([]string, error) {
+		fetched = append(fetched, url)
+		if url == "http://mirror2" {
+			return nil, errors.New("down")
+		}
+		return []string{"ads.example.com"}, nil
+	})
+
+	require.NoError(t, db.UpdateWithMirrors(nil, []blocklist.MirrorGroup{group}, fetch))
+	require.NoError(t, db.UpdateWithMirrors(nil, []blocklist.MirrorGroup{group}, fetch))
+	// Second run starts at mirror2, which fails, and falls through to mirror1.
+	assert.Equal(t, []string{"http://mirror1", "http://mirror2", "http://mirror1"}, fetched)
+	assert.Equal(t, 1, db.Size())
+}
+
+func TestUpdateMirrorGroupAllFail(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	group := blocklist.MirrorGroup{Name: "big-list", Mirrors: []string{"http://mirror1", "http://mirror2"}}
+	fetch := blocklist.FetchFunc(func(url string) ([]string, error) {
+		if url == "http://plain" {
+			return []string{"plain.example.com"}, nil
+		}
+		return nil, errors.New("down")
+	})
+
+	require.NoError(t, db.UpdateWithMirrors([]string{"http://plain"}, []blocklist.MirrorGroup{group}, fetch))
+	assert.Equal(t, 1, db.SourceCount())
+	assert.True(t, db.IsBlocked("plain.example.com"))
+}
+
 func TestDBMultipleSources(t *testing.T) {
 	db, err := blocklist.Open(":memory:", discardLogger)
 	require.NoError(t, err)
@@ -356,6 +750,78 @@ func TestAllowlistCounters(t *testing.T) {
 	assert.Equal(t, int64(3), top[0].Count)
 }
 
+func TestResetCounters(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	err = db.Update([]string{"http://list"}, blocklist.FetchFunc(func(url string) ([]string, error) {
+		return []string{"safe.example.com", "ad.example.com"}, nil
+	}))
+	require.NoError(t, err)
+	db.SetAllowlist([]string{"safe.example.com"})
+
+	db.IsBlocked("safe.example.com")
+	db.IsBlocked("ad.example.com")
+	db.ResetCounters()
+
+	assert.Zero(t, db.AllowsTotal())
+	assert.Zero(t, db.BlocksTotal())
+	assert.Empty(t, db.TopAllowed(10))
+	assert.Empty(t, db.TopBlocked(10))
+	assert.Empty(t, db.SnapshotAllowCounts())
+
+	db.IsBlocked("ad.example.com")
+	assert.Equal(t, int64(1), db.BlocksTotal())
+}
+
+func TestSnapshotRescueCounts(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{"rescued.example.com", "dropped.example.com", "ad.example.com"})
+	db.SetAllowlist([]string{"rescued.example.com", "dropped.example.com", "plain.example.com"})
+
+	db.IsBlocked("rescued.example.com") // allowed over blocklist
+	db.IsBlocked("rescued.example.com")
+	db.IsBlocked("dropped.example.com")
+	db.IsBlocked("plain.example.com") // allowlisted only: not a rescue
+	db.IsBlocked("ad.example.com")    // blocked
+
+	snap := db.SnapshotRescueCounts()
+	assert.Equal(t, map[string]int64{"rescued.example.com": 2, "dropped.example.com": 1}, snap)
+
+	// Once the allowlist entry is removed the domain is no longer rescued.
+	db.SetAllowlist([]string{"rescued.example.com"})
+	snap = db.SnapshotRescueCounts()
+	assert.Equal(t, map[string]int64{"rescued.example.com": 2}, snap)
+}
+
+func TestCheckDoesNotCount(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{"ad.example.com", "safe.example.com"})
+	db.SetAllowlist([]string{"safe.example.com", "*.cdn.example.com"})
+
+	blocklisted, allowlisted := db.Check("AD.example.com")
+	assert.True(t, blocklisted)
+	assert.False(t, allowlisted)
+
+	blocklisted, allowlisted = db.Check("safe.example.com")
+	assert.True(t, blocklisted)
+	assert.True(t, allowlisted)
+
+	blocklisted, allowlisted = db.Check("img.cdn.example.com")
+	assert.False(t, blocklisted)
+	assert.True(t, allowlisted)
+
+	assert.Equal(t, int64(0), db.BlocksTotal())
+	assert.Equal(t, int64(0), db.AllowsTotal())
+}
+
 func TestAllowlistSize(t *testing.T) {
 	db, err := blocklist.Open(":memory:", discardLogger)
 	require.NoError(t, err)
@@ -467,3 +933,501 @@ func TestInlineDomainsWithAllowlist(t *testing.T) {
 	assert.True(t, db.IsBlocked("ad.example.com"))
 	assert.False(t, db.IsBlocked("safe.example.com")) // allowlist wins
 }
+
+func TestInlineSuffix(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{"*.DoubleClick.net"})
+	db.AddInlineDomains([]string{"*.doubleclick.net"}) // reload re-adds
+
+	assert.Equal(t, 1, db.SuffixCount())
+	assert.Equal(t, 0, db.Size())
+	assert.True(t, db.IsBlocked("doubleclick.net"))
+	assert.True(t, db.IsBlocked("stats.g.doubleclick.net"))
+	assert.False(t, db.IsBlocked("notdoubleclick.net"))
+
+	blocked, _ := db.Check("ad.doubleclick.net")
+	assert.True(t, blocked)
+
+	// Counters record the requested domain, not the wildcard.
+	top := db.TopBlocked(10)
+	domains := make([]string, 0, len(top))
+	for _, e := range top {
+		domains = append(domains, e.Domain)
+	}
+	assert.ElementsMatch(t, []string{"doubleclick.net", "stats.g.doubleclick.net"}, domains)
+}
+
+func TestInlineSuffixAllowlistWins(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{"*.doubleclick.net"})
+	db.SetAllowlist([]string{"*.safe.doubleclick.net"})
+
+	assert.True(t, db.IsBlocked("stats.g.doubleclick.net"))
+	assert.False(t, db.IsBlocked("x.safe.doubleclick.net"))
+	assert.Equal(t, map[string]int64{"x.safe.doubleclick.net": 1}, db.SnapshotRescueCounts())
+}
+
+func TestInlinePatterns(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{`re:^ads-\d+\.cdn\.example\.com$`, "exact.example.com"})
+	db.AddInlineDomains([]string{`re:^ads-\d+\.cdn\.example\.com$`}) // reload re-adds
+	db.AddInlineDomains([]string{"re:("})                            // invalid, skipped
+
+	assert.Equal(t, 1, db.PatternCount())
+	assert.Equal(t, 1, db.Size())
+	assert.True(t, db.IsBlocked("ads-7.cdn.example.com"))
+	assert.True(t, db.IsBlocked("ADS-42.cdn.example.com"))
+	assert.True(t, db.IsBlocked("exact.example.com"))
+	assert.False(t, db.IsBlocked("ads-x.cdn.example.com"))
+	assert.False(t, db.IsBlocked("cdn.example.com"))
+
+	blocked, _ := db.Check("ads-9.cdn.example.com")
+	assert.True(t, blocked)
+
+	// Counters record the requested domain, not the pattern.
+	top := db.TopBlocked(10)
+	domains := make([]string, 0, len(top))
+	for _, e := range top {
+		domains = append(domains, e.Domain)
+	}
+	assert.ElementsMatch(t, []string{"ads-7.cdn.example.com", "ads-42.cdn.example.com", "exact.example.com"}, domains)
+}
+
+func TestInlinePatternMixedCase(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{`re:^Tracker\d+\.com$`})
+
+	assert.True(t, db.IsBlocked("tracker7.com"))
+	assert.True(t, db.IsBlocked("TRACKER8.com"))
+	assert.False(t, db.IsBlocked("tracker.com"))
+}
+
+func TestInlinePatternAllowlistWins(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{`re:\.tracker\.example\.com$`})
+	db.SetAllowlist([]string{"ok.tracker.example.com"})
+
+	assert.True(t, db.IsBlocked("a.tracker.example.com"))
+	assert.False(t, db.IsBlocked("ok.tracker.example.com"))
+	assert.Equal(t, int64(1), db.AllowsTotal())
+}
+
+// --- CNAME cloaking tests ---
+
+func TestCNAMEBlocker(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	db.AddInlineDomains([]string{"tracker.evil.net", "ads.example.com"})
+	db.SetAllowlist([]string{"allowed.mysite.com"})
+
+	cnames := map[string]string{
+		"metrics.mysite.com": "tracker.evil.net.",
+		"allowed.mysite.com": "tracker.evil.net.",
+		"www.mysite.com":     "cdn.example.org.",
+	}
+	var lookups atomic.Int64
+	lookup := func(_ context.Context, host string) (string, error) {
+		lookups.Add(1)
+		if target, ok := cnames[host]; ok {
+			return target, nil
+		}
+		return "", errors.New("no such host")
+	}
+	cb := blocklist.NewCNAMEBlocker(db, lookup, discardLogger)
+
+	assert.True(t, cb.IsBlocked("ads.example.com"), "direct blocks need no lookup")
+	assert.Equal(t, int64(0), lookups.Load())
+
+	assert.True(t, cb.IsBlocked("Metrics.MySite.com"))
+	assert.True(t, cb.IsBlocked("metrics.mysite.com"))
+	assert.Equal(t, int64(1), lookups.Load(), "canonical names are cached")
+
+	assert.False(t, cb.IsBlocked("www.mysite.com"))
+	assert.False(t, cb.IsBlocked("unknown.mysite.com"))
+	assert.False(t, cb.IsBlocked("allowed.mysite.com"), "allowlist wins over the cname target")
+
+	// The block is attributed to the cloaking hostname, not the tracker.
+	top := db.TopBlocked(10)
+	counts := make(map[string]int64, len(top))
+	for _, e := range top {
+		counts[e.Domain] = e.Count
+	}
+	assert.Equal(t, map[string]int64{"ads.example.com": 1, "metrics.mysite.com": 2}, counts)
+	assert.Equal(t, int64(3), db.BlocksTotal())
+
+	// Decide reports the CNAME stage without counting.
+	assert.Equal(t, blocklist.Decision{Blocked: true, Block: blocklist.StageCNAME}, cb.Decide("metrics.mysite.com"))
+	assert.Equal(t, blocklist.Decision{Blocked: true, Block: blocklist.StageList}, cb.Decide("ads.example.com"))
+	assert.Equal(t, blocklist.Decision{Allow: blocklist.StageAllowlist}, cb.Decide("allowed.mysite.com"))
+	assert.Equal(t, blocklist.Decision{}, cb.Decide("www.mysite.com"))
+	assert.Equal(t, "cname", cb.BlockReason("metrics.mysite.com"))
+	assert.Empty(t, cb.BlockReason("allowed.mysite.com"))
+	assert.Equal(t, int64(3), db.BlocksTotal())
+
+	// A temporary allow of the cloaking hostname wins too, until it expires.
+	db.AllowTemporarily("metrics.mysite.com", time.Hour)
+	assert.False(t, cb.IsBlocked("metrics.mysite.com"), "temporary allow wins over the cname target")
+	db.AllowTemporarily("metrics.mysite.com", 0)
+	assert.True(t, cb.IsBlocked("metrics.mysite.com"))
+}
+
+// --- Schedule tests ---
+
+func newScheduledDB(t *testing.T, between string, days []string, tz string) (*blocklist.DB, *time.Time) {
+	t.Helper()
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	s, err := blocklist.NewSchedule(between, days, tz)
+	require.NoError(t, err)
+	db.AddSchedule([]string{"Video.Example.com"}, s)
+
+	now := new(time.Time)
+	db.SetClock(func() time.Time { return *now })
+	return db, now
+}
+
+func TestScheduleBlocksInsideWindow(t *testing.T) {
+	db, now := newScheduledDB(t, "09:00-17:00", []string{"Mon", "Tue", "Wed", "Thu", "Fri"}, "America/New_York")
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	*now = time.Date(2026, 3, 4, 10, 30, 0, 0, ny) // Wednesday
+	assert.True(t, db.IsBlocked("video.example.com"))
+	assert.Equal(t, int64(1), db.BlocksTotal())
+
+	*now = time.Date(2026, 3, 4, 17, 0, 0, 0, ny) // end is exclusive
+	assert.False(t, db.IsBlocked("video.example.com"))
+
+	*now = time.Date(2026, 3, 4, 8, 59, 0, 0, ny)
+	assert.False(t, db.IsBlocked("video.example.com"))
+
+	*now = time.Date(2026, 3, 7, 10, 30, 0, 0, ny) // Saturday
+	assert.False(t, db.IsBlocked("video.example.com"))
+
+	assert.False(t, db.IsBlocked("other.example.com"))
+}
+
+func TestScheduleEvaluatedInItsTimezone(t *testing.T) {
+	db, now := newScheduledDB(t, "09:00-17:00", nil, "America/New_York")
+
+	// 14:00 UTC is 09:00 in New York (EST, UTC-5).
+	*now = time.Date(2026, 1, 14, 14, 0, 0, 0, time.UTC)
+	assert.True(t, db.IsBlocked("video.example.com"))
+
+	*now = time.Date(2026, 1, 14, 13, 59, 0, 0, time.UTC)
+	assert.False(t, db.IsBlocked("video.example.com"))
+}
+
+func TestScheduleOvernightWindow(t *testing.T) {
+	db, now := newScheduledDB(t, "22:00-06:00", []string{"Friday"}, "UTC")
+
+	*now = time.Date(2026, 3, 6, 23, 0, 0, 0, time.UTC) // Friday night
+	assert.True(t, db.IsBlocked("video.example.com"))
+
+	*now = time.Date(2026, 3, 7, 5, 59, 0, 0, time.UTC) // early Saturday, Friday's window
+	assert.True(t, db.IsBlocked("video.example.com"))
+
+	*now = time.Date(2026, 3, 7, 23, 0, 0, 0, time.UTC) // Saturday night
+	assert.False(t, db.IsBlocked("video.example.com"))
+
+	*now = time.Date(2026, 3, 6, 5, 0, 0, 0, time.UTC) // early Friday, Thursday's window
+	assert.False(t, db.IsBlocked("video.example.com"))
+}
+
+func TestScheduleAllowlistWins(t *testing.T) {
+	db, now := newScheduledDB(t, "00:00-23:59", nil, "UTC")
+	db.SetAllowlist([]string{"*.example.com"})
+
+	*now = time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	assert.False(t, db.IsBlocked("video.example.com"))
+	assert.Equal(t, int64(1), db.AllowsTotal())
+}
+
+func TestScheduleAlwaysOnEntryStaysBlocked(t *testing.T) {
+	db, now := newScheduledDB(t, "09:00-17:00", nil, "UTC")
+	db.AddInlineDomains([]string{"video.example.com"})
+
+	*now = time.Date(2026, 3, 4, 20, 0, 0, 0, time.UTC)
+	assert.True(t, db.IsBlocked("video.example.com"))
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		between string
+		days    []string
+		tz      string
+	}{
+		{between: "9-5"},
+		{between: "09:00"},
+		{between: "09:00-09:00"},
+		{between: "25:00-26:00"},
+		{between: "09:00-17:00", days: []string{"Funday"}},
+		{between: "09:00-17:00", days: []string{"M"}},
+		{between: "09:00-17:00", tz: "Mars/Olympus_Mons"},
+	} {
+		_, err := blocklist.NewSchedule(tc.between, tc.days, tc.tz)
+		assert.Error(t, err, "%+v", tc)
+	}
+}
+
+// --- Managed allowlist tests ---
+
+func TestManagedAllowlistPersistsAcrossReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+	db.AddInlineDomains([]string{"ads.example.com", "cdn.example.com"})
+	require.NoError(t, db.LoadManagedAllowlist(path))
+
+	_, err = db.AddAllowEntry("ads.example.com")
+	require.NoError(t, err)
+	_, err = db.AddAllowEntry("*.example.com")
+	require.NoError(t, err)
+	_, err = db.AddAllowEntry("ads.example.com")
+	assert.ErrorIs(t, err, blocklist.ErrAllowEntryExists)
+	require.NoError(t, db.RemoveAllowEntry("*.example.com"))
+
+	// A config reload replaces config entries but keeps managed ones.
+	db.SetAllowlist([]string{"other.example.com"})
+	assert.False(t, db.IsBlocked("ads.example.com"))
+	assert.True(t, db.IsBlocked("cdn.example.com"))
+	assert.ErrorIs(t, db.RemoveAllowEntry("other.example.com"), blocklist.ErrAllowEntryFromConfig)
+	assert.ErrorIs(t, db.RemoveAllowEntry("nope.example.com"), blocklist.ErrAllowEntryNotFound)
+
+	// A fresh DB loading the same file sees the managed entry.
+	db2, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db2.Close() //nolint:errcheck // test cleanup
+	db2.AddInlineDomains([]string{"ads.example.com"})
+	require.NoError(t, db2.LoadManagedAllowlist(path))
+	assert.False(t, db2.IsBlocked("ads.example.com"))
+	assert.Equal(t, []blocklist.AllowEntry{{Entry: "ads.example.com", Source: blocklist.AllowSourceManaged}}, db2.Allowlist())
+}
+
+func TestLoadManagedAllowlistMissingFile(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	require.NoError(t, db.LoadManagedAllowlist(filepath.Join(t.TempDir(), "missing.txt")))
+	assert.Equal(t, 0, db.AllowlistSize())
+}
+
+// --- Temporary entry tests ---
+
+func TestTemporaryAllowAndBlock(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+	db.AddInlineDomains([]string{"ads.example.com"})
+	db.SetAllowlist([]string{"safe.example.com"})
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	db.SetClock(func() time.Time { return now })
+
+	// A temporary allow beats the blocklist and counts as an allow.
+	db.AllowTemporarily("ADS.example.com", 10*time.Minute)
+	assert.False(t, db.IsBlocked("ads.example.com"))
+	assert.Equal(t, int64(1), db.AllowsTotal())
+
+	// A temporary block covers a domain no list names, but not the allowlist.
+	db.BlockTemporarily("video.example.com", 5*time.Minute)
+	db.BlockTemporarily("safe.example.com", 5*time.Minute)
+	assert.True(t, db.IsBlocked("video.example.com"))
+	assert.False(t, db.IsBlocked("safe.example.com"))
+	blocklisted, allowlisted := db.Check("video.example.com")
+	assert.True(t, blocklisted)
+	assert.False(t, allowlisted)
+
+	// Both expire.
+	now = now.Add(5 * time.Minute)
+	assert.False(t, db.IsBlocked("video.example.com"))
+	assert.False(t, db.IsBlocked("ads.example.com"))
+	now = now.Add(5 * time.Minute)
+	assert.True(t, db.IsBlocked("ads.example.com"))
+
+	// The latest temporary entry for a domain replaces the other kind.
+	db.AllowTemporarily("video.example.com", time.Minute)
+	db.BlockTemporarily("video.example.com", time.Minute)
+	assert.True(t, db.IsBlocked("video.example.com"))
+	db.AllowTemporarily("ads.example.com", time.Minute)
+	assert.False(t, db.IsBlocked("ads.example.com"))
+}
+
+func TestTemporaryListAndSweep(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+	db.AddInlineDomains([]string{"ads.example.com"})
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	db.SetClock(func() time.Time { return now })
+
+	db.AllowTemporarily("ads.example.com", 30*time.Minute)
+	db.BlockTemporarily("video.example.com", 10*time.Minute)
+	assert.Equal(t, []blocklist.TemporaryEntry{
+		{Domain: "video.example.com", Expires: now.Add(10 * time.Minute)},
+		{Domain: "ads.example.com", Allow: true, Expires: now.Add(30 * time.Minute)},
+	}, db.Temporary())
+	assert.Equal(t, 0, db.SweepTemporary())
+
+	// Expired entries drop out of the listing and are swept without a lookup.
+	now = now.Add(10 * time.Minute)
+	require.Len(t, db.Temporary(), 1)
+	assert.Equal(t, 1, db.SweepTemporary())
+	assert.False(t, db.IsBlocked("ads.example.com"))
+
+	now = now.Add(20 * time.Minute)
+	assert.Empty(t, db.Temporary())
+	assert.Equal(t, 1, db.SweepTemporary())
+	assert.True(t, db.IsBlocked("ads.example.com"), "allow hole closes after the TTL")
+}
+
+func TestTemporarySweeper(t *testing.T) {
+	logs := &lockedBuffer{}
+	db, err := blocklist.Open(":memory:", slog.New(slog.NewTextHandler(logs, nil)))
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+	var mu sync.Mutex
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	db.SetClock(func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	})
+
+	db.AllowTemporarily("ads.example.com", time.Minute)
+	db.StartTemporarySweeper(5 * time.Millisecond)
+	mu.Lock()
+	now = now.Add(time.Minute)
+	mu.Unlock()
+	assert.Eventually(t, func() bool { return strings.Contains(logs.String(), "temporary domain entries expired") },
+		time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, db.SweepTemporary(), "already swept")
+}
+
+// lockedBuffer is a bytes.Buffer safe for a logger and the test to share.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// --- Concurrency tests ---
+
+// TestConcurrentUpdateAndReload hammers lookups while list updates and
+// config reloads run at the same time. Run with -race.
+func TestConcurrentUpdateAndReload(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck // test cleanup
+
+	fetch := blocklist.FetchFunc(func(string) ([]string, error) {
+		return []string{"listed.example.com", "allowed.example.com"}, nil
+	})
+	require.NoError(t, db.Update([]string{"http://list"}, fetch))
+	db.SetAllowlist([]string{"allowed.example.com"})
+	db.AddInlineDomains([]string{"inline.example.com"})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Every state the writers produce blocks these two and
+				// allows the third.
+				assert.True(t, db.IsBlocked("listed.example.com"))
+				assert.True(t, db.IsBlocked("inline.example.com"), "inline entries survive cache reloads")
+				assert.False(t, db.IsBlocked("allowed.example.com"))
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for range 20 {
+			assert.NoError(t, db.Update([]string{"http://list"}, fetch))
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := range 200 {
+			// What a config reload does.
+			db.SetAllowlist([]string{"allowed.example.com", fmt.Sprintf("extra%d.example.com", i)})
+			db.AddInlineDomains([]string{"inline.example.com"})
+		}
+	}()
+	writers.Wait()
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, 3, db.Size(), "reloading the same inline entries adds nothing")
+}
+
+func TestLockTiming(t *testing.T) {
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	defer db.Close()
+	db.AddInlineDomains([]string{"ads.example.com"})
+
+	db.IsBlocked("ads.example.com")
+	_, ok := db.LockWait()
+	assert.False(t, ok, "off by default")
+
+	db.SetLockTiming(true)
+	db.IsBlocked("ads.example.com")
+	db.IsBlocked("www.example.com")
+	db.Check("ads.example.com") // dry-run lookups are not timed
+	lw, ok := db.LockWait()
+	require.True(t, ok)
+	assert.Equal(t, int64(2), lw.Lookups)
+	assert.GreaterOrEqual(t, lw.Wait, time.Duration(0))
+
+	// Re-enabling resets the totals.
+	db.SetLockTiming(true)
+	lw, _ = db.LockWait()
+	assert.Zero(t, lw.Lookups)
+
+	db.SetLockTiming(false)
+	_, ok = db.LockWait()
+	assert.False(t, ok)
+}
@@ -0,0 +1,146 @@
+package blocklist
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// cnameTTL is how long a resolved canonical name (or a failed lookup)
+	// is cached.
+	cnameTTL = 5 * time.Minute
+	// cnameCacheMax bounds the cache; when full, expired entries are
+	// dropped, and if none expired the cache is cleared.
+	cnameCacheMax = 4096
+	// cnameTimeout bounds a single lookup so a slow resolver cannot stall
+	// requests for long.
+	cnameTimeout = 2 * time.Second
+)
+
+// CNAMELookupFunc resolves host to its canonical name, as
+// net.Resolver.LookupCNAME does.
+type CNAMELookupFunc func(ctx context.Context, host string) (string, error)
+
+// CNAMEBlocker detects CNAME cloaking: a first-party hostname
+// (metrics.example.com) aliased to a blocked tracker (tracker.example.net).
+// Domains not blocked directly are resolved to their canonical name (the
+// end of the CNAME chain) and blocked if that name is. The block is
+// counted under the requested hostname, not the tracker. The allowlist
+// wins for both names.
+type CNAMEBlocker struct {
+	db     *DB
+	lookup CNAMELookupFunc
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	cache map[string]cnameEntry
+}
+
+type cnameEntry struct {
+	target    string // canonical name, "" if none or the lookup failed
+	expiresAt time.Time
+}
+
+// NewCNAMEBlocker wraps db with CNAME cloaking detection using lookup.
+func NewCNAMEBlocker(db *DB, lookup CNAMELookupFunc, logger *slog.Logger) *CNAMEBlocker {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &CNAMEBlocker{
+		db:     db,
+		lookup: lookup,
+		logger: logger,
+		cache:  make(map[string]cnameEntry),
+	}
+}
+
+// IsBlocked reports whether domain is blocked directly or through its
+// canonical name. Block and allow counters are updated as in DB.IsBlocked.
+func (c *CNAMEBlocker) IsBlocked(domain string) bool {
+	if c.db.IsBlocked(domain) {
+		return true
+	}
+	domain = strings.ToLower(domain)
+	if c.db.allowStage(domain) != StageNone {
+		return false
+	}
+	target, ok := c.cloaked(domain)
+	if !ok {
+		return false
+	}
+
+	c.db.recordBlock(domain)
+	c.logger.Debug("blocked cname-cloaked domain", "domain", domain, "cname", target)
+	return true
+}
+
+// Decide is DB.Decide with the CNAME check as a final block stage,
+// StageCNAME, consulted only when no other stage matched. Like IsBlocked it
+// may resolve domain; counters are not touched.
+func (c *CNAMEBlocker) Decide(domain string) Decision {
+	d := c.db.Decide(domain)
+	if d.Block != StageNone || d.Allow != StageNone {
+		return d
+	}
+	if _, ok := c.cloaked(strings.ToLower(domain)); ok {
+		return Decision{Blocked: true, Block: StageCNAME}
+	}
+	return d
+}
+
+// BlockReason is DB.BlockReason with the CNAME check (see Decide).
+func (c *CNAMEBlocker) BlockReason(domain string) string {
+	if d := c.Decide(domain); d.Blocked {
+		return string(d.Reason())
+	}
+	return ""
+}
+
+// cloaked reports whether domain (lowercased) is an alias whose canonical
+// name is blocklisted and not allowlisted, and returns that name.
+func (c *CNAMEBlocker) cloaked(domain string) (string, bool) {
+	target := c.canonical(domain)
+	if target == "" || target == domain {
+		return "", false
+	}
+	blocklisted, allowlisted := c.db.Check(target)
+	return target, blocklisted && !allowlisted
+}
+
+// canonical returns the cached canonical name of domain, resolving it on a
+// miss.
+func (c *CNAMEBlocker) canonical(domain string) string {
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.cache[domain]; ok && now.Before(e.expiresAt) {
+		c.mu.Unlock()
+		return e.target
+	}
+	c.mu.Unlock()
+
+	// Resolve outside the lock.
+	ctx, cancel := context.WithTimeout(context.Background(), cnameTimeout)
+	defer cancel()
+	target := ""
+	if name, err := c.lookup(ctx, domain); err == nil {
+		target = strings.ToLower(strings.TrimSuffix(name, "."))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cache) >= cnameCacheMax {
+		for d, e := range c.cache {
+			if !now.Before(e.expiresAt) {
+				delete(c.cache, d)
+			}
+		}
+		if len(c.cache) >= cnameCacheMax {
+			clear(c.cache)
+		}
+	}
+	c.cache[domain] = cnameEntry{target: target, expiresAt: now.Add(cnameTTL)}
+	return target
+}
@@ -0,0 +1,115 @@
+package blocklist
+
+import "strings"
+
+// Stage names a rule source consulted by Decide. Stages are evaluated in
+// this precedence order, first match wins within each group:
+//
+//  1. StageTempAllow: a runtime temporary allow (AllowTemporarily)
+//  2. StageAllowlist: the config and managed allowlist, exact or *.suffix
+//  3. StageTempBlock: a runtime temporary block (BlockTemporarily)
+//  4. StageList: an exact domain from a downloaded list or inline config
+//  5. StageSuffix: an inline "*.domain" entry
+//  6. StagePattern: an inline "re:" pattern
+//  7. StageSchedule: a scheduled block whose window is active now
+//  8. StageCNAME: the domain's canonical name is blocked (CNAMEBlocker only)
+//
+// Any allow stage beats every block stage: a domain is blocked only when
+// some block stage matches and no allow stage does.
+type Stage string
+
+// Decision stages, in precedence order.
+const (
+	StageNone      Stage = ""
+	StageTempAllow Stage = "temporary-allow"
+	StageAllowlist Stage = "allowlist"
+	StageTempBlock Stage = "temporary-block"
+	StageList      Stage = "blocklist"
+	StageSuffix    Stage = "suffix"
+	StagePattern   Stage = "pattern"
+	StageSchedule  Stage = "schedule"
+	StageCNAME     Stage = "cname"
+)
+
+// Decision is the outcome of evaluating a domain against every stage.
+type Decision struct {
+	Blocked bool
+	// Allow is the first allow stage that matched, StageNone if none.
+	Allow Stage
+	// Block is the first block stage that matched, StageNone if none.
+	// With Allow set it is the block the allow overrode.
+	Block Stage
+}
+
+// Reason returns the stage that decided the outcome: the block stage for
+// a blocked domain, the allow stage for a blocklisted domain it rescued,
+// and StageNone when no block stage matched.
+func (d Decision) Reason() Stage {
+	if d.Block == StageNone {
+		return StageNone
+	}
+	if d.Blocked {
+		return d.Block
+	}
+	return d.Allow
+}
+
+// BlockReason returns the stage that blocks domain, or "" if it is not
+// blocked. Counters are not touched.
+func (db *DB) BlockReason(domain string) string {
+	if d := db.Decide(domain); d.Blocked {
+		return string(d.Reason())
+	}
+	return ""
+}
+
+// Decide evaluates domain (case-insensitive) against every stage without
+// touching block or allow counters. IsBlocked reaches the same verdict.
+func (db *DB) Decide(domain string) Decision {
+	domain = strings.ToLower(domain)
+	d := Decision{
+		Allow: db.allowStage(domain),
+		Block: db.blockStage(domain, false),
+	}
+	d.Blocked = d.Block != StageNone && d.Allow == StageNone
+	return d
+}
+
+// allowStage returns the first allow stage matching domain.
+func (db *DB) allowStage(domain string) Stage {
+	switch {
+	case db.tempAllowed(domain):
+		return StageTempAllow
+	case db.isAllowed(domain):
+		return StageAllowlist
+	}
+	return StageNone
+}
+
+// blockStage returns the first block stage matching domain. timed takes
+// the read lock through rlockLookup, for IsBlocked's lock wait timing.
+func (db *DB) blockStage(domain string, timed bool) Stage {
+	if db.tempBlocked(domain) {
+		return StageTempBlock
+	}
+
+	if timed {
+		db.rlockLookup()
+	} else {
+		db.mu.RLock()
+	}
+	defer db.mu.RUnlock()
+
+	if _, ok := db.domains[domain]; ok {
+		return StageList
+	}
+	switch {
+	case db.suffixMatch(domain):
+		return StageSuffix
+	case db.patternMatch(domain):
+		return StagePattern
+	case db.scheduleActive(domain):
+		return StageSchedule
+	}
+	return StageNone
+}
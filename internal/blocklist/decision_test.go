@@ -0,0 +1,175 @@
+package blocklist_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
+)
+
+// decisionSources lists every rule source in precedence order, each with
+// a way to make it match "x.example.com".
+var decisionSources = []struct {
+	stage blocklist.Stage
+	add   func(t *testing.T, db *blocklist.DB)
+}{
+	{blocklist.StageTempAllow, func(_ *testing.T, db *blocklist.DB) {
+		db.AllowTemporarily("x.example.com", time.Hour)
+	}},
+	{blocklist.StageAllowlist, func(_ *testing.T, db *blocklist.DB) {
+		db.SetAllowlist([]string{"x.example.com"})
+	}},
+	{blocklist.StageTempBlock, func(_ *testing.T, db *blocklist.DB) {
+		db.BlockTemporarily("x.example.com", time.Hour)
+	}},
+	{blocklist.StageList, func(_ *testing.T, db *blocklist.DB) {
+		db.AddInlineDomains([]string{"x.example.com"})
+	}},
+	{blocklist.StageSuffix, func(_ *testing.T, db *blocklist.DB) {
+		db.AddInlineDomains([]string{"*.example.com"})
+	}},
+	{blocklist.StagePattern, func(_ *testing.T, db *blocklist.DB) {
+		db.AddInlineDomains([]string{`re:^x\.`})
+	}},
+	{blocklist.StageSchedule, func(t *testing.T, db *blocklist.DB) {
+		s, err := blocklist.NewSchedule("09:00-17:00", nil, "UTC")
+		require.NoError(t, err)
+		db.AddSchedule([]string{"x.example.com"}, s)
+	}},
+}
+
+// newDecisionDB returns a DB with the sources selected by mask (bit i
+// enables decisionSources[i]) and a clock inside the schedule window.
+func newDecisionDB(t *testing.T, mask int) *blocklist.DB {
+	t.Helper()
+	db, err := blocklist.Open(":memory:", discardLogger)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetClock(func() time.Time { return time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC) })
+	for i, src := range decisionSources {
+		if mask&(1<<i) != 0 {
+			src.add(t, db)
+		}
+	}
+	return db
+}
+
+func TestDecidePrecedence(t *testing.T) {
+	bit := func(stages ...blocklist.Stage) int {
+		mask := 0
+		for _, s := range stages {
+			for i, src := range decisionSources {
+				if src.stage == s {
+					mask |= 1 << i
+				}
+			}
+		}
+		return mask
+	}
+
+	tests := []struct {
+		name    string
+		sources int
+		want    blocklist.Decision
+		reason  blocklist.Stage
+	}{
+		{"nothing matches", 0,
+			blocklist.Decision{}, blocklist.StageNone},
+		{"allowlist alone does not decide", bit(blocklist.StageAllowlist),
+			blocklist.Decision{Allow: blocklist.StageAllowlist}, blocklist.StageNone},
+		{"list blocks", bit(blocklist.StageList),
+			blocklist.Decision{Blocked: true, Block: blocklist.StageList}, blocklist.StageList},
+		{"temporary allow beats list", bit(blocklist.StageTempAllow, blocklist.StageList),
+			blocklist.Decision{Allow: blocklist.StageTempAllow, Block: blocklist.StageList}, blocklist.StageTempAllow},
+		{"temporary allow beats allowlist", bit(blocklist.StageTempAllow, blocklist.StageAllowlist, blocklist.StageList),
+			blocklist.Decision{Allow: blocklist.StageTempAllow, Block: blocklist.StageList}, blocklist.StageTempAllow},
+		{"allowlist beats temporary block", bit(blocklist.StageAllowlist, blocklist.StageTempBlock),
+			blocklist.Decision{Allow: blocklist.StageAllowlist, Block: blocklist.StageTempBlock}, blocklist.StageAllowlist},
+		{"temporary block beats list", bit(blocklist.StageTempBlock, blocklist.StageList),
+			blocklist.Decision{Blocked: true, Block: blocklist.StageTempBlock}, blocklist.StageTempBlock},
+		{"list beats suffix", bit(blocklist.StageList, blocklist.StageSuffix),
+			blocklist.Decision{Blocked: true, Block: blocklist.StageList}, blocklist.StageList},
+		{"suffix beats pattern", bit(blocklist.StageSuffix, blocklist.StagePattern),
+			blocklist.Decision{Blocked: true, Block: blocklist.StageSuffix}, blocklist.StageSuffix},
+		{"pattern beats schedule", bit(blocklist.StagePattern, blocklist.StageSchedule),
+			blocklist.Decision{Blocked: true, Block: blocklist.StagePattern}, blocklist.StagePattern},
+		{"allowlist beats schedule", bit(blocklist.StageAllowlist, blocklist.StageSchedule),
+			blocklist.Decision{Allow: blocklist.StageAllowlist, Block: blocklist.StageSchedule}, blocklist.StageAllowlist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDecisionDB(t, tt.sources)
+			d := db.Decide("X.example.com")
+			assert.Equal(t, tt.want, d)
+			assert.Equal(t, tt.reason, d.Reason())
+			blockReason := ""
+			if tt.want.Blocked {
+				blockReason = string(tt.reason)
+			}
+			assert.Equal(t, blockReason, db.BlockReason("X.example.com"))
+		})
+	}
+}
+
+// TestDecideAllCombinations checks every combination of sources against
+// the documented precedence, and that IsBlocked, Check, and the counters
+// agree with Decide.
+func TestDecideAllCombinations(t *testing.T) {
+	for mask := range 1 << len(decisionSources) {
+		// A temporary allow and block of one domain replace each other,
+		// so they never coexist.
+		if mask&0b101 == 0b101 {
+			continue
+		}
+
+		var want blocklist.Decision
+		var names []string
+		for i, src := range decisionSources {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			names = append(names, string(src.stage))
+			allow := src.stage == blocklist.StageTempAllow || src.stage == blocklist.StageAllowlist
+			if allow && want.Allow == blocklist.StageNone {
+				want.Allow = src.stage
+			}
+			if !allow && want.Block == blocklist.StageNone {
+				want.Block = src.stage
+			}
+		}
+		want.Blocked = want.Block != blocklist.StageNone && want.Allow == blocklist.StageNone
+
+		t.Run(fmt.Sprintf("%07b/%s", mask, strings.Join(names, "+")), func(t *testing.T) {
+			db := newDecisionDB(t, mask)
+			require.Equal(t, want, db.Decide("x.example.com"))
+
+			blocklisted, allowlisted := db.Check("x.example.com")
+			assert.Equal(t, want.Block != blocklist.StageNone, blocklisted)
+			assert.Equal(t, want.Allow != blocklist.StageNone, allowlisted)
+
+			assert.Equal(t, want.Blocked, db.IsBlocked("x.example.com"))
+			rescued := want.Block != blocklist.StageNone && !want.Blocked
+			assert.Equal(t, int64(btoi(want.Blocked)), db.BlocksTotal())
+			assert.Equal(t, int64(btoi(rescued)), db.AllowsTotal())
+
+			assert.False(t, db.IsBlocked("y.other.org"), "unrelated domains pass")
+		})
+	}
+}
+
+func TestDecideScheduleOutsideWindow(t *testing.T) {
+	db := newDecisionDB(t, 1<<6) // schedule only
+	db.SetClock(func() time.Time { return time.Date(2026, 5, 1, 20, 0, 0, 0, time.UTC) })
+	assert.Equal(t, blocklist.Decision{}, db.Decide("x.example.com"))
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -1,7 +1,10 @@
 package blocklist
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -11,32 +14,119 @@ import (
 // This is a function type to allow injection of test doubles.
 type FetchFunc func(url string) ([]string, error)
 
+// ListFetchFunc is FetchFunc that also returns the list's header metadata.
+type ListFetchFunc func(url string) ([]string, ListMetadata, error)
+
+// withMetadata adapts a FetchFunc to a ListFetchFunc reporting no metadata.
+func (f FetchFunc) withMetadata() ListFetchFunc {
+	return func(url string) ([]string, ListMetadata, error) {
+		domains, err := f(url)
+		return domains, ListMetadata{}, err
+	}
+}
+
 // HTTPFetcher returns a FetchFunc that downloads blocklists via HTTP
 // and parses domains from the response body.
 //
 // Only http:// and https:// URLs are accepted. The --blocklist-url flags
 // are operator-controlled CLI input; do not expose to untrusted users.
 func HTTPFetcher() FetchFunc {
+	return HTTPFetcherWithSources(nil, nil)
+}
+
+// HTTPFetcherWithSources is HTTPFetcher with per-source parse options,
+// keyed by URL. Sources without an entry are parsed with defaults. The
+// number of lines each exclude pattern dropped is logged per source.
+func HTTPFetcherWithSources(sources map[string]ParseOptions, logger *slog.Logger) FetchFunc {
+	fetch := HTTPListFetcher(sources, logger)
+	return func(url string) ([]string, error) {
+		domains, _, err := fetch(url)
+		return domains, err
+	}
+}
+
+// HTTPListFetcher is HTTPFetcherWithSources that also returns each list's
+// header metadata. Gzip-compressed lists (Content-Encoding: gzip or a .gz
+// URL) are decompressed; a malformed gzip stream fails the fetch.
+func HTTPListFetcher(sources map[string]ParseOptions, logger *slog.Logger) ListFetchFunc {
+	if logger == nil {
+		logger = slog.Default()
+	}
 	client := &http.Client{
 		Timeout: 60 * time.Second,
 	}
 
-	return func(url string) ([]string, error) {
+	return func(url string) ([]string, ListMetadata, error) {
 		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-			return nil, fmt.Errorf("fetch %s: only http:// and https:// URLs are supported", url)
+			return nil, ListMetadata{}, fmt.Errorf("fetch %s: only http:// and https:// URLs are supported", url)
 		}
 
-		resp, err := client.Get(url) //nolint:gosec // URL comes from operator config, validated above
+		req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+		if err != nil {
+			return nil, ListMetadata{}, fmt.Errorf("fetch %s: %w", url, err)
+		}
+		// Asking for gzip explicitly stops the transport from decoding it
+		// transparently, so both encodings take the same path below.
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := client.Do(req) //nolint:gosec // URL comes from operator config, validated above
 		if err != nil {
-			return nil, fmt.Errorf("fetch %s: %w", url, err)
+			return nil, ListMetadata{}, fmt.Errorf("fetch %s: %w", url, err)
 		}
 		defer resp.Body.Close() //nolint:errcheck // response body close in defer
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("fetch %s: status %d", url, resp.StatusCode)
+			return nil, ListMetadata{}, fmt.Errorf("fetch %s: status %d", url, resp.StatusCode)
+		}
+
+		var body io.Reader = resp.Body
+		if gzipped(resp) {
+			zr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return nil, ListMetadata{}, fmt.Errorf("fetch %s: gzip: %w", url, err)
+			}
+			defer zr.Close() //nolint:errcheck // gzip reader close in defer
+			body = zr
 		}
 
-		domains := ParseDomains(resp.Body)
-		return domains, nil
+		// ParseList stops quietly on a read error; record it so a corrupt
+		// or truncated download fails instead of yielding a partial list.
+		rec := &errRecorder{r: body}
+		opts := sources[url]
+		domains, excluded, meta := ParseList(rec, opts)
+		if rec.err != nil {
+			return nil, ListMetadata{}, fmt.Errorf("fetch %s: read body: %w", url, rec.err)
+		}
+		for i, n := range excluded {
+			logger.Info("blocklist exclude pattern applied",
+				"url", url,
+				"pattern", opts.Exclude[i].String(),
+				"lines_dropped", n,
+			)
+		}
+		return domains, meta, nil
+	}
+}
+
+// gzipped reports whether resp's body is gzip-compressed, by
+// Content-Encoding or a .gz suffix on the (final) URL path.
+func gzipped(resp *http.Response) bool {
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return true
+	}
+	return resp.Request != nil && strings.HasSuffix(strings.ToLower(resp.Request.URL.Path), ".gz")
+}
+
+// errRecorder passes reads through to r and keeps the first error other
+// than io.EOF.
+type errRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF && e.err == nil {
+		e.err = err
 	}
+	return n, err
 }
@@ -0,0 +1,157 @@
+package blocklist
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// domainSet is the lookup side of the blocklist domain map, so the
+// benchmark can compare the current RWMutex design with a copy-on-write
+// map behind an atomic pointer.
+type domainSet interface {
+	has(domain string) bool
+	add(domain string)
+}
+
+// rwDomainSet is the current design: a map guarded by an RWMutex.
+type rwDomainSet struct {
+	mu      sync.RWMutex
+	domains map[string]struct{}
+}
+
+func (s *rwDomainSet) has(domain string) bool {
+	s.mu.RLock()
+	_, ok := s.domains[domain]
+	s.mu.RUnlock()
+	return ok
+}
+
+func (s *rwDomainSet) add(domain string) {
+	s.mu.Lock()
+	s.domains[domain] = struct{}{}
+	s.mu.Unlock()
+}
+
+// cowDomainSet is the lock-free alternative: readers load an immutable
+// map, writers copy it, modify the copy, and swap it in.
+type cowDomainSet struct {
+	writeMu sync.Mutex
+	domains atomic.Pointer[map[string]struct{}]
+}
+
+func (s *cowDomainSet) has(domain string) bool {
+	_, ok := (*s.domains.Load())[domain]
+	return ok
+}
+
+func (s *cowDomainSet) add(domain string) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	old := *s.domains.Load()
+	next := make(map[string]struct{}, len(old)+1)
+	for d := range old {
+		next[d] = struct{}{}
+	}
+	next[domain] = struct{}{}
+	s.domains.Store(&next)
+}
+
+func benchDomains(n int) map[string]struct{} {
+	m := make(map[string]struct{}, n)
+	for i := range n {
+		m[fmt.Sprintf("ads-%d.example.net", i)] = struct{}{}
+	}
+	return m
+}
+
+// BenchmarkLookupUnderUpdates measures parallel read throughput while a
+// writer adds a domain every 100µs, for both map designs. Run with
+// -cpu=1,4,16 to see how read-lock contention scales. Note that every
+// copy-on-write update copies the whole map, which the writer goroutine
+// pays for (and competes for CPU with) at this update rate.
+func BenchmarkLookupUnderUpdates(b *testing.B) {
+	const size = 10000
+	sets := []struct {
+		name string
+		set  func() domainSet
+	}{
+		{"rwmutex", func() domainSet { return &rwDomainSet{domains: benchDomains(size)} }},
+		{"cow", func() domainSet {
+			s := &cowDomainSet{}
+			m := benchDomains(size)
+			s.domains.Store(&m)
+			return s
+		}},
+	}
+	keys := make([]string, 0, size)
+	for d := range benchDomains(size) {
+		keys = append(keys, d)
+	}
+	for _, tc := range sets {
+		b.Run(tc.name, func(b *testing.B) {
+			set := tc.set()
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tick := time.NewTicker(100 * time.Microsecond)
+				defer tick.Stop()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					case <-tick.C:
+						set.add(fmt.Sprintf("new-%d.example.org", i))
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					set.has(keys[i%size])
+					i++
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkIsBlockedLockTiming measures the overhead of lock wait timing
+// on parallel IsBlocked lookups.
+func BenchmarkIsBlockedLockTiming(b *testing.B) {
+	db, err := Open(":memory:", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close() //nolint:errcheck // in-memory
+
+	domains := make([]string, 0, 1000)
+	for d := range benchDomains(1000) {
+		domains = append(domains, d)
+	}
+	db.AddInlineDomains(domains)
+
+	for _, on := range []bool{false, true} {
+		b.Run(fmt.Sprintf("timing=%t", on), func(b *testing.B) {
+			db.SetLockTiming(on)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					db.IsBlocked(domains[i%len(domains)])
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// Mirror selection strategies.
+const (
+	// StrategyFailover tries mirrors in listed order until one succeeds.
+	StrategyFailover = "failover"
+	// StrategyRoundRobin starts from the next mirror on each update,
+	// falling through to the rest if it fails. A mirror listed more than
+	// once gets proportionally more turns.
+	StrategyRoundRobin = "round-robin"
+)
+
+// MirrorGroup is one logical blocklist source served from several URLs.
+// Only one mirror is fetched per update; the group is recorded as a single
+// source under Name.
+type MirrorGroup struct {
+	Name     string
+	Mirrors  []string
+	Strategy string // StrategyFailover (default) or StrategyRoundRobin
+}
+
+// fetchMirrorGroup fetches g from the first mirror that succeeds, in the
+// order given by its strategy. For round-robin the starting mirror advances
+// by one per call, persisted across restarts.
+func (db *DB) fetchMirrorGroup(g MirrorGroup, fetchFn ListFetchFunc) (domains []string, meta ListMetadata, used string, err error) {
+	if len(g.Mirrors) == 0 {
+		return nil, ListMetadata{}, "", fmt.Errorf("mirror group %q has no mirrors", g.Name)
+	}
+
+	start := 0
+	if g.Strategy == StrategyRoundRobin {
+		next, loadErr := db.mirrorNext(g.Name)
+		if loadErr != nil {
+			return nil, ListMetadata{}, "", loadErr
+		}
+		start = next % len(g.Mirrors)
+		if saveErr := db.setMirrorNext(g.Name, (start+1)%len(g.Mirrors)); saveErr != nil {
+			return nil, ListMetadata{}, "", saveErr
+		}
+	}
+
+	var errs []error
+	tried := make(map[string]struct{}, len(g.Mirrors))
+	for i := range g.Mirrors {
+		u := g.Mirrors[(start+i)%len(g.Mirrors)]
+		if _, ok := tried[u]; ok {
+			continue
+		}
+		tried[u] = struct{}{}
+
+		db.logger.Info("fetching blocklist mirror", "source", g.Name, "url", u)
+		domains, meta, err = fetchFn(u)
+		if err == nil {
+			return domains, meta, u, nil
+		}
+		db.logger.Warn("blocklist mirror failed", "source", g.Name, "url", u, "error", err)
+		errs = append(errs, err)
+	}
+	return nil, ListMetadata{}, "", fmt.Errorf("all mirrors failed: %w", errors.Join(errs...))
+}
+
+// mirrorNext returns the persisted round-robin position for a group.
+func (db *DB) mirrorNext(name string) (int, error) {
+	var next int
+	err := sqlitex.Execute(db.conn, "SELECT next FROM mirror_state WHERE name = ?", &sqlitex.ExecOptions{
+		Args: []any{name},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			next = stmt.ColumnInt(0)
+			return nil
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("load mirror state %q: %w", name, err)
+	}
+	return next, nil
+}
+
+// setMirrorNext persists the round-robin position for a group.
+func (db *DB) setMirrorNext(name string, next int) error {
+	err := sqlitex.Execute(db.conn, "INSERT OR REPLACE INTO mirror_state (name, next) VALUES (?, ?)", &sqlitex.ExecOptions{
+		Args: []any{name, next},
+	})
+	if err != nil {
+		return fmt.Errorf("save mirror state %q: %w", name, err)
+	}
+	return nil
+}
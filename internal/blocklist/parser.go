@@ -2,10 +2,34 @@ package blocklist
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// ParseOptions tunes parsing for a single blocklist source.
+type ParseOptions struct {
+	// Exclude drops lines matching any of these patterns before they are
+	// parsed, e.g. to remove a known-bad entry from an otherwise good list.
+	Exclude []*regexp.Regexp
+}
+
+// NewParseOptions compiles exclude patterns into ParseOptions.
+func NewParseOptions(excludePatterns []string) (ParseOptions, error) {
+	var opts ParseOptions
+	for _, p := range excludePatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return ParseOptions{}, fmt.Errorf("exclude pattern %q: %w", p, err)
+		}
+		opts.Exclude = append(opts.Exclude, re)
+	}
+	return opts, nil
+}
+
 // ParseDomains reads a blocklist in hosts or adblock format and returns
 // unique, lowercased domains. Comments (#, !) and blank lines are skipped.
 // Supported formats:
@@ -13,21 +37,46 @@ import (
 //   - Adblock: "||ad.example.com^"
 //   - Domain-only: "ad.example.com"
 func ParseDomains(r io.Reader) []string {
+	domains, _ := ParseDomainsWith(r, ParseOptions{})
+	return domains
+}
+
+// ParseDomainsWith is ParseDomains with per-source options. Lines (trimmed,
+// non-comment) matching an exclude pattern are dropped; excluded[i] counts
+// the lines dropped by opts.Exclude[i] (the first matching pattern wins).
+func ParseDomainsWith(r io.Reader, opts ParseOptions) (domains []string, excluded []int) {
+	domains, excluded, _ = ParseList(r, opts)
+	return domains, excluded
+}
+
+// ParseList is ParseDomainsWith that also collects the list's header
+// metadata ("! Title:", "# Version:", ...) from comment lines. Domain
+// parsing is identical.
+func ParseList(r io.Reader, opts ParseOptions) (domains []string, excluded []int, meta ListMetadata) {
 	seen := make(map[string]struct{})
-	var domains []string
+	excluded = make([]int, len(opts.Exclude))
 
 	scanner := bufio.NewScanner(r)
+lines:
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if line == "" {
 			continue
 		}
 
-		// Skip comments.
+		// Skip comments, keeping any metadata they carry.
 		if line[0] == '#' || line[0] == '!' {
+			meta.parseComment(line)
 			continue
 		}
 
+		for i, re := range opts.Exclude {
+			if re.MatchString(line) {
+				excluded[i]++
+				continue lines
+			}
+		}
+
 		domain := parseLine(line)
 		if domain == "" {
 			continue
@@ -52,7 +101,64 @@ func ParseDomains(r io.Reader) []string {
 		domains = append(domains, domain)
 	}
 
-	return domains
+	return domains, excluded, meta
+}
+
+// ListMetadata is provenance declared in a blocklist's comment header.
+// Fields are empty when the list does not declare them.
+type ListMetadata struct {
+	Title   string
+	Version string
+	Expires string // as written, e.g. "4 days (update frequency)"
+}
+
+// parseComment records a "Key: value" metadata comment such as
+// "! Title: EasyList" or "# Version: 2026.10.01". The first occurrence of
+// each key wins.
+func (m *ListMetadata) parseComment(line string) {
+	key, value, ok := strings.Cut(strings.TrimLeft(line, "#! \t"), ":")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+	var field *string
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "title":
+		field = &m.Title
+	case "version":
+		field = &m.Version
+	case "expires":
+		field = &m.Expires
+	default:
+		return
+	}
+	if *field == "" {
+		*field = value
+	}
+}
+
+// ExpiresAfter parses Expires ("4 days", "12 hours", "1 day (update
+// frequency)") into a duration. ok is false when Expires is empty or not
+// understood.
+func (m ListMetadata) ExpiresAfter() (d time.Duration, ok bool) {
+	fields := strings.Fields(m.Expires)
+	if len(fields) < 2 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	switch strings.TrimSuffix(strings.ToLower(fields[1]), "s") {
+	case "hour":
+		return time.Duration(n) * time.Hour, true
+	case "day":
+		return time.Duration(n) * 24 * time.Hour, true
+	case "week":
+		return time.Duration(n) * 7 * 24 * time.Hour, true
+	default:
+		return 0, false
+	}
 }
 
 // parseLine extracts a domain from a single blocklist line.
@@ -0,0 +1,116 @@
+package blocklist
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a recurring weekly window during which a set of domains is
+// blocked. Outside the window the domains pass (unless they are also on
+// the regular, always-on blocklist).
+type Schedule struct {
+	start, end time.Duration // offsets from midnight; end <= start wraps past midnight
+	days       [7]bool       // indexed by time.Weekday of the day the window opens
+	loc        *time.Location
+}
+
+// NewSchedule parses a window such as "09:00-17:00" or "22:00-06:00"
+// (overnight). days lists weekday names ("Mon", "friday"); empty means every
+// day. For overnight windows a day selects the window that opens on it, so
+// "22:00-06:00" on Fri also blocks early Saturday. tz is an IANA zone name;
+// empty means local time.
+func NewSchedule(between string, days []string, tz string) (*Schedule, error) {
+	start, end, err := ParseWindow(between)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schedule{start: start, end: end, loc: time.Local}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("timezone %q: %w", tz, err)
+		}
+		s.loc = loc
+	}
+
+	if len(days) == 0 {
+		for i := range s.days {
+			s.days[i] = true
+		}
+	}
+	for _, d := range days {
+		wd, ok := ParseWeekday(d)
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", d)
+		}
+		s.days[wd] = true
+	}
+	return s, nil
+}
+
+// Active reports whether t falls inside the window.
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.loc)
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+
+	if s.start < s.end {
+		return s.days[day] && offset >= s.start && offset < s.end
+	}
+	// Overnight: the late part belongs to today's window, the early part
+	// to the window that opened yesterday.
+	if offset >= s.start {
+		return s.days[day]
+	}
+	if offset < s.end {
+		return s.days[(day+6)%7]
+	}
+	return false
+}
+
+// ParseWindow parses "HH:MM-HH:MM" into offsets from midnight. Config
+// validation calls it too, so both accept the same windows.
+func ParseWindow(between string) (start, end time.Duration, err error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(between), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid window %q: want HH:MM-HH:MM", between)
+	}
+	if start, err = parseClock(from); err != nil {
+		return 0, 0, fmt.Errorf("invalid window %q: %w", between, err)
+	}
+	if end, err = parseClock(to); err != nil {
+		return 0, 0, fmt.Errorf("invalid window %q: %w", between, err)
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("invalid window %q: start and end are equal", between)
+	}
+	return start, end, nil
+}
+
+// parseClock parses "HH:MM" into an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseWeekday accepts a full weekday name or any prefix of at least three
+// letters, case-insensitive ("Mon", "tues", "Wednesday").
+func ParseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < 3 {
+		return 0, false
+	}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if strings.HasPrefix(strings.ToLower(wd.String()), s) {
+			return wd, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package blocklist
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// ErrSourceNotFound is returned when toggling a source that is not in the
+// database.
+var ErrSourceNotFound = errors.New("blocklist source not found")
+
+// SourceStat is a blocklist source with the number of blocks its domains
+// have caused since startup. A domain listed by several sources counts
+// toward each of them.
+type SourceStat struct {
+	Source
+	Blocks int64 `json:"blocks"`
+}
+
+// Sources returns the blocklist sources from the last update, by URL, with
+// per-source block counts.
+func (db *DB) Sources() []SourceStat {
+	db.mu.RLock()
+	stats := make([]SourceStat, len(db.sources))
+	for i, src := range db.sources {
+		stats[i] = SourceStat{Source: src}
+	}
+	db.mu.RUnlock()
+
+	if len(stats) == 0 {
+		return stats
+	}
+	blocks := db.sourceBlocks()
+	for i := range stats {
+		stats[i].Blocks = blocks[stats[i].URL]
+	}
+	return stats
+}
+
+// sourceBlocks sums block counts per source. The sources of each blocked
+// domain are looked up once and cached until the next cache reload.
+func (db *DB) sourceBlocks() map[string]int64 {
+	db.connMu.Lock()
+	defer db.connMu.Unlock()
+
+	if db.blockSources == nil {
+		db.blockSources = make(map[string][]string)
+	}
+	totals := make(map[string]int64)
+	db.blockCounts.Range(func(key, value any) bool {
+		domain, _ := key.(string)           //nolint:errcheck // type is guaranteed by LoadOrStore
+		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed by LoadOrStore
+		urls, ok := db.blockSources[domain]
+		if !ok {
+			var err error
+			if urls, err = db.domainSources(domain); err != nil {
+				db.logger.Warn("blocklist source lookup failed", "domain", domain, "error", err)
+				return true
+			}
+			db.blockSources[domain] = urls
+		}
+		for _, u := range urls {
+			totals[u] += counter.Load()
+		}
+		return true
+	})
+	return totals
+}
+
+// domainSources returns the sources that list domain. Caller must hold
+// db.connMu.
+func (db *DB) domainSources(domain string) ([]string, error) {
+	urls := []string{}
+	err := sqlitex.Execute(db.conn, "SELECT source FROM domain_sources WHERE domain = ?", &sqlitex.ExecOptions{
+		Args: []any{domain},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			urls = append(urls, stmt.ColumnText(0))
+			return nil
+		},
+	})
+	return urls, err
+}
+
+// SetSourceEnabled enables or disables a source by URL (or mirror group
+// name) and reloads the in-memory cache, so a disabled source's domains stop
+// matching immediately unless another enabled source also lists them. The
+// setting is stored in the database and survives updates and restarts.
+func (db *DB) SetSourceEnabled(url string, enabled bool) error {
+	db.connMu.Lock()
+	defer db.connMu.Unlock()
+
+	err := sqlitex.Execute(db.conn, "UPDATE sources SET enabled = ? WHERE url = ?", &sqlitex.ExecOptions{
+		Args: []any{enabled, url},
+	})
+	if err != nil {
+		return fmt.Errorf("update source %q: %w", url, err)
+	}
+	if db.conn.Changes() == 0 {
+		return ErrSourceNotFound
+	}
+
+	if err := db.loadCache(); err != nil {
+		return fmt.Errorf("reload cache: %w", err)
+	}
+	db.logger.Info("blocklist source toggled", "url", url, "enabled", enabled, "domains", db.Size())
+	return nil
+}
@@ -0,0 +1,76 @@
+package blocklist
+
+import "strings"
+
+// suffixTrie holds "*.domain" suffix patterns keyed by reversed labels
+// ("com" -> "example" -> ...), so matching a domain walks its labels once
+// instead of comparing it against every pattern.
+type suffixTrie struct {
+	root suffixNode
+	size int
+}
+
+type suffixNode struct {
+	children map[string]*suffixNode
+	terminal bool // a pattern ends at this label
+}
+
+// add inserts suffix (without the "*." prefix). It reports false if the
+// suffix was already present.
+func (t *suffixTrie) add(suffix string) bool {
+	n := &t.root
+	for rest := suffix; ; {
+		i := strings.LastIndexByte(rest, '.')
+		label := rest[i+1:]
+		child := n.children[label]
+		if child == nil {
+			if n.children == nil {
+				n.children = make(map[string]*suffixNode)
+			}
+			child = &suffixNode{}
+			n.children[label] = child
+		}
+		n = child
+		if i < 0 {
+			break
+		}
+		rest = rest[:i]
+	}
+	if n.terminal {
+		return false
+	}
+	n.terminal = true
+	t.size++
+	return true
+}
+
+// match reports whether domain equals a pattern suffix or is a subdomain
+// of one. A nil trie matches nothing.
+func (t *suffixTrie) match(domain string) bool {
+	if t == nil {
+		return false
+	}
+	n := &t.root
+	for rest := domain; ; {
+		i := strings.LastIndexByte(rest, '.')
+		n = n.children[rest[i+1:]]
+		if n == nil {
+			return false
+		}
+		if n.terminal {
+			return true
+		}
+		if i < 0 {
+			return false
+		}
+		rest = rest[:i]
+	}
+}
+
+// len returns the number of patterns (0 for a nil trie).
+func (t *suffixTrie) len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
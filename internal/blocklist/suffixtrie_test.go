@@ -0,0 +1,74 @@
+package blocklist
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// linearSuffixMatch is the pattern-by-pattern scan the trie replaces.
+func linearSuffixMatch(suffixes []string, domain string) bool {
+	for _, suffix := range suffixes {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSuffixTrieMatchesLinearScan(t *testing.T) {
+	suffixes := []string{"example.com", "cdn.example.org", "co.uk", "a.b.c.d"}
+	trie := &suffixTrie{}
+	for _, s := range suffixes {
+		assert.True(t, trie.add(s))
+	}
+	assert.False(t, trie.add("co.uk"), "duplicate")
+	assert.Equal(t, 4, trie.len())
+
+	for _, domain := range []string{
+		"example.com", "www.example.com", "a.b.example.com",
+		"notexample.com", "example.com.evil.net", "com",
+		"cdn.example.org", "img.cdn.example.org", "example.org", "xcdn.example.org",
+		"co.uk", "bbc.co.uk", "uk",
+		"a.b.c.d", "x.a.b.c.d", "b.c.d",
+		"", ".", "example.com.",
+	} {
+		assert.Equal(t, linearSuffixMatch(suffixes, domain), trie.match(domain), domain)
+	}
+
+	var empty *suffixTrie
+	assert.False(t, empty.match("example.com"))
+	assert.Zero(t, empty.len())
+}
+
+// manySuffixes returns n distinct suffix patterns and a domain matching
+// none of them.
+func manySuffixes(n int) (suffixes []string, miss string) {
+	for i := range n {
+		suffixes = append(suffixes, fmt.Sprintf("tracker-%d.example.net", i))
+	}
+	return suffixes, "static.images.cdn.example.com"
+}
+
+func BenchmarkSuffixMatch(b *testing.B) {
+	for _, n := range []int{10, 500} {
+		suffixes, miss := manySuffixes(n)
+		trie := &suffixTrie{}
+		for _, s := range suffixes {
+			trie.add(s)
+		}
+
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				linearSuffixMatch(suffixes, miss)
+			}
+		})
+		b.Run(fmt.Sprintf("trie/%d", n), func(b *testing.B) {
+			for b.Loop() {
+				trie.match(miss)
+			}
+		})
+	}
+}
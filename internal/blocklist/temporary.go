@@ -0,0 +1,153 @@
+package blocklist
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// TemporaryEntry is an active temporary allow or block.
+type TemporaryEntry struct {
+	Domain  string
+	Allow   bool
+	Expires time.Time
+}
+
+// AllowTemporarily lets domain (exact match, case-insensitive) through for
+// ttl even if it is blocklisted. It replaces any temporary block of the
+// same domain. Temporary entries live in memory only.
+func (db *DB) AllowTemporarily(domain string, ttl time.Duration) {
+	db.setTemporary(domain, ttl, true)
+}
+
+// BlockTemporarily blocks domain (exact match, case-insensitive) for ttl.
+// It replaces any temporary allow of the same domain. As with other block
+// sources, the allowlist still wins.
+func (db *DB) BlockTemporarily(domain string, ttl time.Duration) {
+	db.setTemporary(domain, ttl, false)
+}
+
+func (db *DB) setTemporary(domain string, ttl time.Duration, allow bool) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	expires := db.clock().Add(ttl)
+
+	db.tempMu.Lock()
+	defer db.tempMu.Unlock()
+	set, other := db.tempBlock, db.tempAllow
+	if allow {
+		set, other = db.tempAllow, db.tempBlock
+	}
+	delete(other, domain)
+	set[domain] = expires
+	db.tempCount.Store(int64(len(db.tempAllow) + len(db.tempBlock)))
+}
+
+// tempAllowed reports whether domain has an unexpired temporary allow.
+func (db *DB) tempAllowed(domain string) bool {
+	return db.tempActive(db.tempAllow, domain)
+}
+
+// tempBlocked reports whether domain has an unexpired temporary block.
+func (db *DB) tempBlocked(domain string) bool {
+	return db.tempActive(db.tempBlock, domain)
+}
+
+// tempActive looks domain up in entries, dropping the entry if it has
+// expired. It returns early without locking when no temporary entries
+// exist, which is the common case on the blocking hot path.
+func (db *DB) tempActive(entries map[string]time.Time, domain string) bool {
+	if db.tempCount.Load() == 0 {
+		return false
+	}
+	now := db.clock()
+	db.tempMu.Lock()
+	defer db.tempMu.Unlock()
+	expires, ok := entries[domain]
+	if !ok {
+		return false
+	}
+	if !now.Before(expires) {
+		delete(entries, domain)
+		db.tempCount.Add(-1)
+		return false
+	}
+	return true
+}
+
+// Temporary returns the unexpired temporary entries, soonest expiry first.
+func (db *DB) Temporary() []TemporaryEntry {
+	now := db.clock()
+	db.tempMu.Lock()
+	var entries []TemporaryEntry
+	for domain, expires := range db.tempAllow {
+		if now.Before(expires) {
+			entries = append(entries, TemporaryEntry{Domain: domain, Allow: true, Expires: expires})
+		}
+	}
+	for domain, expires := range db.tempBlock {
+		if now.Before(expires) {
+			entries = append(entries, TemporaryEntry{Domain: domain, Expires: expires})
+		}
+	}
+	db.tempMu.Unlock()
+
+	slices.SortFunc(entries, func(a, b TemporaryEntry) int {
+		if c := a.Expires.Compare(b.Expires); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return entries
+}
+
+// SweepTemporary removes expired temporary entries, including ones never
+// looked up again, and returns how many were removed.
+func (db *DB) SweepTemporary() int {
+	now := db.clock()
+	db.tempMu.Lock()
+	defer db.tempMu.Unlock()
+
+	removed := 0
+	for _, entries := range []map[string]time.Time{db.tempAllow, db.tempBlock} {
+		for domain, expires := range entries {
+			if !now.Before(expires) {
+				delete(entries, domain)
+				db.tempCount.Add(-1)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// StartTemporarySweeper runs SweepTemporary every interval until Close,
+// logging each expiry. Call at most once; a non-positive interval is
+// ignored.
+func (db *DB) StartTemporarySweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	db.stopSweep = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-db.stopSweep:
+				return
+			case <-ticker.C:
+				if n := db.SweepTemporary(); n > 0 {
+					db.logger.Info("temporary domain entries expired", "count", n)
+				}
+			}
+		}
+	}()
+}
+
+// clock returns the current time from db.now.
+func (db *DB) clock() time.Time {
+	db.mu.RLock()
+	now := db.now
+	db.mu.RUnlock()
+	return now()
+}
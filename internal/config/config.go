@@ -3,39 +3,92 @@ Package config handles YAML configuration loading, validation, and
 CLI flag merging for fpsd.
 
 Configuration is resolved in this order (highest priority first):
-  1. CLI flags (explicitly passed)
-  2. Config file values
-  3. Built-in defaults
+ 1. CLI flags (explicitly passed)
+ 2. Config file values
+ 3. Built-in defaults
 */
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
+	"github.com/ushineko/face-puncher-supreme/internal/upstream"
 )
 
 // Config is the top-level configuration for fpsd.
 type Config struct {
-	Listen        string                `yaml:"listen"`
-	LogDir        string                `yaml:"log_dir"`
-	Verbose       bool                  `yaml:"verbose"`
-	DataDir       string                `yaml:"data_dir"`
-	BlocklistURLs []string              `yaml:"blocklist_urls"`
-	Blocklist     []string              `yaml:"blocklist"`
-	Allowlist     []string              `yaml:"allowlist"`
-	MITM          MITM                  `yaml:"mitm"`
-	Transparent   Transparent            `yaml:"transparent"`
-	Plugins       map[string]PluginConf `yaml:"plugins"`
-	Timeouts      Timeouts              `yaml:"timeouts"`
-	Management    Management            `yaml:"management"`
-	Stats         Stats                 `yaml:"stats"`
-	Dashboard     Dashboard             `yaml:"dashboard"`
+	Listen        string   `yaml:"listen"`
+	LogDir        string   `yaml:"log_dir"`
+	LogSyslog     bool     `yaml:"log_syslog"`
+	LogRotateSize int      `yaml:"log_rotate_size"` // MB
+	LogRotateKeep int      `yaml:"log_rotate_keep"`
+	Verbose       bool     `yaml:"verbose"`
+	DataDir       string   `yaml:"data_dir"`
+	BlocklistURLs []string `yaml:"blocklist_urls"`
+	// BlocklistSources holds optional per-source settings keyed by URL.
+	// Entries for URLs not in BlocklistURLs are ignored, except mirror
+	// groups (entries with Mirrors), which are keyed by a source name and
+	// fetched in addition to BlocklistURLs.
+	BlocklistSources map[string]BlocklistSource `yaml:"blocklist_sources"`
+	Blocklist        []string                   `yaml:"blocklist"`
+	// BlocklistCheckCNAME also blocks domains whose canonical name (CNAME
+	// target) is blocked, catching CNAME-cloaked trackers. Costs a DNS
+	// lookup per new domain.
+	BlocklistCheckCNAME bool `yaml:"blocklist_check_cname"`
+	// BlocklistRequireNonempty makes startup fail when the first-run fetch
+	// of blocklist_urls (after retries) leaves the blocklist empty, instead
+	// of running without blocking.
+	BlocklistRequireNonempty bool `yaml:"blocklist_require_nonempty"`
+	// BlocklistLockTiming measures how long lookups wait for the blocklist
+	// read lock and reports it in /fps/stats and /fps/metrics.
+	BlocklistLockTiming bool `yaml:"blocklist_lock_timing"`
+	// BlocklistCIDRs blocks upstream hosts that resolve into these ranges
+	// (CIDRs or single IPs), whatever their domain.
+	BlocklistCIDRs []string `yaml:"blocklist_cidrs"`
+	// BlocklistSchedules block domains only during recurring time windows.
+	BlocklistSchedules []BlocklistSchedule `yaml:"blocklist_schedules"`
+	Allowlist          []string            `yaml:"allowlist"`
+	MITM               MITM                `yaml:"mitm"`
+	Transparent        Transparent         `yaml:"transparent"`
+	Proxy              Proxy               `yaml:"proxy"`
+	Upstream           Upstream            `yaml:"upstream"`
+	// ListenTLS optionally serves the explicit proxy over TLS on a second
+	// address, for clients configured with an https:// proxy URL.
+	ListenTLS  ListenTLS             `yaml:"listen_tls"`
+	Plugins    map[string]PluginConf `yaml:"plugins"`
+	Timeouts   Timeouts              `yaml:"timeouts"`
+	Management Management            `yaml:"management"`
+	Stats      Stats                 `yaml:"stats"`
+	Dashboard  Dashboard             `yaml:"dashboard"`
+	// Suggestions enables advisory allowlist suggestions at
+	// /fps/suggestions, derived from blocks that follow site loads.
+	Suggestions Suggestions `yaml:"suggestions"`
+	// Learning records domains matching ad/tracker name heuristics as
+	// candidate blocklist entries at /fps/candidates.
+	Learning Learning `yaml:"learning"`
+	// Zones names network zones by client range (CIDRs or single IPs) for
+	// per-zone stats. Clients outside every range are in zone "default".
+	Zones map[string][]string `yaml:"zones"`
+	// StatsD pushes aggregate counters and gauges to a StatsD server.
+	StatsD StatsD `yaml:"statsd"`
+	// Chaos injects artificial latency into proxied requests. Testing only.
+	Chaos Chaos `yaml:"chaos"`
 }
 
 // PluginConf holds per-plugin configuration from fpsd.yml.
@@ -48,13 +101,205 @@ type PluginConf struct {
 	Priority    int            `yaml:"priority"` // lower = runs first; 0 means default (100)
 }
 
+// BlocklistSource holds parse options for a single blocklist URL, or
+// defines a mirror group when Mirrors is set.
+type BlocklistSource struct {
+	// ExcludePatterns are regexes; matching lines are dropped while parsing.
+	ExcludePatterns []string `yaml:"exclude_patterns"`
+	// Mirrors are alternative URLs for one logical list; one is fetched per
+	// update. Listing a URL more than once weights it under round-robin.
+	Mirrors []string `yaml:"mirrors"`
+	// Strategy picks the mirror: "failover" (default) or "round-robin".
+	Strategy string `yaml:"strategy"`
+}
+
+// MirrorGroupNames returns the BlocklistSources keys that define mirror
+// groups, sorted.
+func (c *Config) MirrorGroupNames() []string {
+	var names []string
+	for name, src := range c.BlocklistSources {
+		if len(src.Mirrors) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BlocklistPrefixes returns BlocklistCIDRs parsed. Invalid entries (which
+// Validate reports) are skipped.
+func (c *Config) BlocklistPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range c.BlocklistCIDRs {
+		if p, err := parsePrefix(s); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// StripCookiePatterns returns StripCookies compiled. Invalid patterns
+// (which Validate reports) are skipped.
+func (p *Proxy) StripCookiePatterns() []*regexp.Regexp {
+	var out []*regexp.Regexp
+	for _, s := range p.StripCookies {
+		if re, err := regexp.Compile(s); err == nil {
+			out = append(out, re)
+		}
+	}
+	return out
+}
+
+// DebugHeaderPrefixes returns DebugHeaderClients parsed. Invalid entries
+// (which Validate reports) are skipped.
+func (p *Proxy) DebugHeaderPrefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range p.DebugHeaderClients {
+		if pfx, err := parsePrefix(s); err == nil {
+			prefixes = append(prefixes, pfx)
+		}
+	}
+	return prefixes
+}
+
+// ZonePrefixes returns Zones with each range parsed. Invalid entries
+// (which Validate reports) are skipped.
+func (c *Config) ZonePrefixes() map[string][]netip.Prefix {
+	zones := make(map[string][]netip.Prefix, len(c.Zones))
+	for name, ranges := range c.Zones {
+		for _, s := range ranges {
+			if p, err := parsePrefix(s); err == nil {
+				zones[name] = append(zones[name], p)
+			}
+		}
+	}
+	return zones
+}
+
+// parsePrefix parses a CIDR, or a single IP as a full-length prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked(), nil
+}
+
+// BlocklistSchedule blocks a set of domains during a recurring window.
+type BlocklistSchedule struct {
+	Domains      []string `yaml:"domains"`
+	BlockBetween string   `yaml:"block_between"` // "HH:MM-HH:MM"; end before start wraps past midnight
+	Days         []string `yaml:"days"`          // weekday names; empty means every day
+	TZ           string   `yaml:"tz"`            // IANA zone; empty means local time
+}
+
+// ListenTLS configures the proxy-over-TLS ("HTTPS proxy") listener. Cert and
+// Key are PEM paths relative to data_dir; when both are empty a certificate
+// is generated at startup (signed by the MITM CA when one is loaded,
+// otherwise self-signed).
+type ListenTLS struct {
+	Addr string `yaml:"addr"` // empty disables the TLS listener
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
 // MITM holds per-domain TLS interception configuration.
 type MITM struct {
 	CACert  string   `yaml:"ca_cert"`
 	CAKey   string   `yaml:"ca_key"`
 	Domains []string `yaml:"domains"`
+	// CertCacheTTL is the maximum age of a cached leaf certificate before it
+	// is regenerated. 0 reuses leaves until they near expiry.
+	CertCacheTTL Duration `yaml:"cert_cache_ttl"`
+	// PipelineDepth is the maximum number of in-flight requests per MITM
+	// session. 0 or 1 keeps the strictly sequential request-response loop.
+	PipelineDepth int `yaml:"pipeline_depth"`
+	// PregenerateCerts warms the leaf cert cache for every configured
+	// domain at startup (in the background).
+	PregenerateCerts bool `yaml:"pregenerate_certs"`
+	// CASubject sets the subject of CAs created by generate-ca. Empty
+	// fields keep the default ("Face Puncher Supreme CA", no O/OU).
+	CASubject CASubject `yaml:"ca_subject"`
+	// CAKeyType ("ecdsa" or "rsa") and CAKeyBits (RSA only: 2048 or 4096)
+	// select the key generate-ca creates. Empty/0 keep ECDSA P-256. Loading
+	// detects the key type from the key file.
+	CAKeyType string `yaml:"ca_key_type,omitempty"`
+	CAKeyBits int    `yaml:"ca_key_bits,omitempty"`
+	// ResponsePipeline orders the plugin stages applied to MITM response
+	// bodies. Empty runs enabled plugins by priority; otherwise it must
+	// list every enabled plugin exactly once.
+	ResponsePipeline []string `yaml:"response_pipeline"`
+	// ExclusivePluginDomains rejects a config where two enabled plugins
+	// handle the same domain. Off by default: such plugins run as a chain.
+	ExclusivePluginDomains bool `yaml:"exclusive_plugin_domains,omitempty"`
+	// NeverIntercept lists domains and "*.domain" suffix patterns that are
+	// always tunneled, even when listed in Domains. A safety override for
+	// sensitive hosts (banking, auth).
+	NeverIntercept []string `yaml:"never_intercept,omitempty"`
+	// MaxBufferSize is the largest response body buffered in memory for
+	// plugin filtering (default 10MB).
+	MaxBufferSize int64 `yaml:"max_buffer_size"`
+	// DiskBuffer lets response bodies over MaxBufferSize, up to
+	// DiskBufferMax bytes, be spilled to a temp file so plugins can still
+	// filter them. Larger bodies stream through unmodified.
+	DiskBuffer    bool  `yaml:"disk_buffer"`
+	DiskBufferMax int64 `yaml:"disk_buffer_max"`
+	// CABundle is a PEM file (relative to data_dir) with the certificates
+	// chaining CACert to its root, for a bring-your-own intermediate CA.
+	// They are appended to /fps/ca/bundle.pem and the iOS profile, and
+	// intercepted leaves are served with CACert so clients reach the root.
+	CABundle string `yaml:"ca_bundle,omitempty"`
+	// CertDir is a directory (absolute, or relative to data_dir) where
+	// generated leaf certificates are kept across restarts. Empty keeps
+	// them in memory.
+	CertDir string `yaml:"cert_dir,omitempty"`
+}
+
+// defaultMaxBufferSize is the default in-memory body buffering limit of
+// the MITM response pipeline.
+const defaultMaxBufferSize = 10 * 1024 * 1024
+
+// DiskBufferLimit returns the disk buffering cap in bytes, or 0 when disk
+// buffering is off.
+func (m *MITM) DiskBufferLimit() int64 {
+	if !m.DiskBuffer {
+		return 0
+	}
+	return m.DiskBufferMax
+}
+
+// DecodeLimit returns the largest decoded body the response pipeline
+// accepts: the disk buffering cap when disk buffering is on, since those
+// bodies exceed MaxBufferSize by design, otherwise MaxBufferSize.
+func (m *MITM) DecodeLimit() int64 {
+	if limit := m.DiskBufferLimit(); limit > m.MaxBufferSize {
+		return limit
+	}
+	return m.MaxBufferSize
 }
 
+// CASubject holds CA certificate subject fields.
+type CASubject struct {
+	CommonName         string `yaml:"common_name"`
+	Organization       string `yaml:"organization"`
+	OrganizationalUnit string `yaml:"organizational_unit"`
+}
+
+// maxSubjectFieldLen is the X.509 upper bound for CN, O, and OU.
+const maxSubjectFieldLen = 64
+
+// MaxPipelineDepth caps mitm.pipeline_depth.
+const MaxPipelineDepth = 64
+
 // Transparent holds transparent proxy listener configuration.
 type Transparent struct {
 	Enabled   bool   `yaml:"enabled"`
@@ -62,22 +307,254 @@ type Transparent struct {
 	HTTPSAddr string `yaml:"https_addr"`
 }
 
+// Proxy holds forwarding behavior shared by the explicit proxy, transparent
+// listener, and MITM paths.
+type Proxy struct {
+	// StripRequestHeaders are extra headers removed from forwarded requests
+	// (in addition to the standard hop-by-hop set).
+	StripRequestHeaders []string `yaml:"strip_request_headers"`
+	// StripResponseHeaders are extra headers removed from relayed responses.
+	StripResponseHeaders []string `yaml:"strip_response_headers"`
+	// StripCookies are regexes matched against cookie names; matching
+	// cookies are removed from forwarded Cookie headers and relayed
+	// Set-Cookie headers.
+	StripCookies []string `yaml:"strip_cookies"`
+	// StrictContentLength buffers relayed responses that declare a
+	// Content-Length (up to 10MB) and aborts them instead of relaying a
+	// truncated body when the upstream sends fewer bytes.
+	StrictContentLength bool `yaml:"strict_content_length"`
+	// DebugHeaders adds an X-FPS-Trace header to plain HTTP and MITM
+	// responses summarizing what the proxy did (blocked, MITM'd, plugins
+	// run, rules matched, bytes saved). DebugHeaderClients limits it to
+	// clients in these ranges (CIDRs or single IPs); empty means all.
+	DebugHeaders       bool     `yaml:"debug_headers,omitempty"`
+	DebugHeaderClients []string `yaml:"debug_header_clients,omitempty"`
+	// MaxInflight caps concurrently active proxy requests; excess requests
+	// get 503 with Retry-After. 0 means unlimited.
+	MaxInflight int `yaml:"max_inflight"`
+	// MaxResponseBytes caps relayed plain HTTP response bodies; the
+	// connection is aborted past it. 0 means unlimited.
+	MaxResponseBytes int64 `yaml:"max_response_bytes"`
+	// LenientHeaders retries bodiless GET/HEAD requests whose upstream
+	// response has malformed header lines, dropping those lines.
+	LenientHeaders bool `yaml:"lenient_headers"`
+	// ConnectBlockPage answers CONNECT to a blocked domain with a block
+	// page served via MITM instead of a 403. Requires mitm to be enabled.
+	ConnectBlockPage bool `yaml:"connect_block_page"`
+	// BlockPage is an HTML template file (relative to data_dir) served
+	// with 403 for plain HTTP requests to blocked domains. Empty sends a
+	// plain-text 403.
+	BlockPage string `yaml:"block_page,omitempty"`
+	// Auth requires clients of the forward proxy to send Basic
+	// Proxy-Authorization credentials. Management endpoints are exempt.
+	Auth ProxyAuth `yaml:"auth,omitempty"`
+	// RateLimit throttles requests per client IP with a token bucket;
+	// excess requests get 429. Management endpoints are exempt.
+	RateLimit RateLimit `yaml:"rate_limit,omitempty"`
+	// UpstreamRetries re-sends a bodiless GET or HEAD up to this many
+	// times when connecting to the upstream fails, waiting
+	// UpstreamRetryBackoff before the first retry and doubling it after
+	// each. HTTP error responses are never retried. 0 disables retries.
+	UpstreamRetries      int      `yaml:"upstream_retries"`
+	UpstreamRetryBackoff Duration `yaml:"upstream_retry_backoff"`
+	// Transport tunes the upstream connection pool used for plain HTTP
+	// forwarding. Zero fields keep Go's defaults.
+	Transport ProxyTransport `yaml:"transport,omitempty"`
+	// Cache keeps cacheable plain HTTP GET responses in memory.
+	Cache ProxyCache `yaml:"cache,omitempty"`
+}
+
+// ProxyCache holds the response cache limits in bytes. A zero MaxSize
+// disables the cache; a zero MaxObjectSize caches objects up to 1MB.
+type ProxyCache struct {
+	MaxSize       int64 `yaml:"max_size"`
+	MaxObjectSize int64 `yaml:"max_object_size"`
+}
+
+// ProxyTransport holds upstream transport tuning. Zero values keep Go's
+// defaults: 100 idle connections, 2 per host, 90s idle timeout, 10s TLS
+// handshake timeout, keep-alives on.
+type ProxyTransport struct {
+	MaxIdleConns        int      `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int      `yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout     Duration `yaml:"idle_conn_timeout"`
+	TLSHandshakeTimeout Duration `yaml:"tls_handshake_timeout"`
+	DisableKeepAlives   bool     `yaml:"disable_keep_alives"`
+}
+
+// maxUpstreamRetries bounds proxy.upstream_retries so a dead upstream
+// cannot hold a client for long.
+const maxUpstreamRetries = 10
+
+// RateLimit is a per-client token bucket: Burst requests at once, refilled
+// at RequestsPerSecond. A zero rate disables limiting; a zero burst uses
+// the rate (at least 1).
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// ProxyAuth holds forward proxy client credentials: a single
+// Username/Password pair, a list of Users, or both. No credentials means
+// authentication is off. Passwords are redacted in dumps.
+type ProxyAuth struct {
+	Username string          `yaml:"username,omitempty"`
+	Password string          `yaml:"password,omitempty"`
+	Users    []ProxyAuthUser `yaml:"users,omitempty"`
+}
+
+// ProxyAuthUser is one proxy client credential.
+type ProxyAuthUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Enabled reports whether any client credentials are configured.
+func (a ProxyAuth) Enabled() bool {
+	return a.Username != "" || len(a.Users) > 0
+}
+
+// Credentials returns the configured users as a username to password map.
+func (a ProxyAuth) Credentials() map[string]string {
+	if !a.Enabled() {
+		return nil
+	}
+	creds := make(map[string]string, len(a.Users)+1)
+	if a.Username != "" {
+		creds[a.Username] = a.Password
+	}
+	for _, u := range a.Users {
+		creds[u.Username] = u.Password
+	}
+	return creds
+}
+
+// Upstream holds settings for connections to upstream servers.
+type Upstream struct {
+	// Resolver resolves upstream hostnames instead of the system resolver:
+	// "ip", "ip:port" for a DNS server, or an https:// DoH URL. Empty uses
+	// the system resolver.
+	Resolver string `yaml:"resolver"`
+	// ProxyFallback is tried when dialing an upstream fails on the explicit
+	// proxy path: "direct" (system resolver) or an http:// proxy URL.
+	// Empty disables the fallback.
+	ProxyFallback string `yaml:"proxy_fallback"`
+	// ProxyUser and ProxyPass are Basic auth credentials for an http://
+	// ProxyFallback that requires them. ProxyPass is redacted in dumps.
+	ProxyUser string `yaml:"proxy_user,omitempty"`
+	ProxyPass string `yaml:"proxy_pass,omitempty"`
+}
+
 // Timeouts holds proxy timeout configuration.
+//
+// Shutdown is the global graceful-shutdown deadline. The per-subsystem
+// shutdown timeouts override it for individual phases; zero falls back to
+// Shutdown.
 type Timeouts struct {
-	Shutdown   Duration `yaml:"shutdown"`
-	Connect    Duration `yaml:"connect"`
-	ReadHeader Duration `yaml:"read_header"`
+	Shutdown            Duration `yaml:"shutdown"`
+	ShutdownTransparent Duration `yaml:"shutdown_transparent,omitempty"`
+	ShutdownProxy       Duration `yaml:"shutdown_proxy,omitempty"`
+	ShutdownTunnels     Duration `yaml:"shutdown_tunnels,omitempty"`
+	Connect             Duration `yaml:"connect"`
+	ReadHeader          Duration `yaml:"read_header"`
+	// Request bounds a forwarded plain HTTP exchange until response headers
+	// arrive (0 = no limit). RequestIncludesBody extends it over the body.
+	Request             Duration `yaml:"request"`
+	RequestIncludesBody bool     `yaml:"request_includes_body,omitempty"`
+}
+
+// TransparentShutdown returns the shutdown deadline for the transparent listeners.
+func (t *Timeouts) TransparentShutdown() time.Duration {
+	return t.shutdownOr(t.ShutdownTransparent)
+}
+
+// ProxyShutdown returns the shutdown deadline for in-flight proxy HTTP requests.
+func (t *Timeouts) ProxyShutdown() time.Duration {
+	return t.shutdownOr(t.ShutdownProxy)
+}
+
+// TunnelsShutdown returns the drain deadline for open CONNECT and MITM tunnels.
+func (t *Timeouts) TunnelsShutdown() time.Duration {
+	return t.shutdownOr(t.ShutdownTunnels)
+}
+
+// shutdownOr returns d if set, otherwise the global shutdown timeout.
+func (t *Timeouts) shutdownOr(d Duration) time.Duration {
+	if d.Duration > 0 {
+		return d.Duration
+	}
+	return t.Shutdown.Duration
 }
 
 // Management holds management endpoint configuration.
 type Management struct {
 	PathPrefix string `yaml:"path_prefix"`
+	// ExternalHost is the host name or IP clients use to reach the proxy,
+	// written into the PAC file at <path_prefix>/proxy.pac. Empty uses the
+	// listen host, or for a wildcard listen address the host the client
+	// fetched the PAC file from.
+	ExternalHost string `yaml:"external_host,omitempty"`
+	// PACDirect lists domains and "*.domain" patterns the PAC file sends
+	// DIRECT instead of through the proxy.
+	PACDirect []string `yaml:"pac_direct,omitempty"`
+	// DisabledEndpoints lists management endpoint groups that answer 404
+	// even when their handler is available. See ManagementEndpoints.
+	DisabledEndpoints []string `yaml:"disabled_endpoints,omitempty"`
+}
+
+// ManagementEndpoints are the endpoint group names accepted by
+// management.disabled_endpoints.
+var ManagementEndpoints = []string{
+	"heartbeat", "stats", "metrics", "suggestions", "candidates", "pac", "ca", "dashboard",
 }
 
 // Stats holds statistics collection configuration.
 type Stats struct {
 	Enabled       bool     `yaml:"enabled"`
 	FlushInterval Duration `yaml:"flush_interval"`
+	// Retention bounds how long hourly per-client traffic rows are kept
+	// (0 = forever). Per-domain totals are not pruned.
+	Retention Duration `yaml:"retention"`
+	// MetricsTopDomains caps the per-domain series at /fps/metrics to the
+	// most blocked domains (0 = aggregate counters only).
+	MetricsTopDomains int `yaml:"metrics_top_domains"`
+	// ClientIdle drops clients with no activity for this long from memory
+	// after their counts are flushed (0 = keep all). ClientIdleRetention
+	// also deletes an evicted client's hourly rows older than it.
+	ClientIdle          Duration `yaml:"client_idle"`
+	ClientIdleRetention Duration `yaml:"client_idle_retention"`
+}
+
+// Suggestions holds allowlist suggestion settings. Zero values use the
+// tracker defaults.
+type Suggestions struct {
+	Enabled   bool     `yaml:"enabled"`
+	MinBlocks int      `yaml:"min_blocks"` // attributed blocks before a domain is suggested
+	Window    Duration `yaml:"window"`     // how long after a site load blocks are attributed to it
+	MaxPairs  int      `yaml:"max_pairs"`  // bound on tracked (site, blocked domain) pairs
+}
+
+// Chaos holds the latency injection settings for resilience testing. It is
+// off while Probability is 0.
+type Chaos struct {
+	Delay       Duration `yaml:"delay"`       // fixed delay added to a selected request
+	Jitter      Duration `yaml:"jitter"`      // extra random delay, uniform in [0, jitter]
+	Probability float64  `yaml:"probability"` // fraction of requests delayed, 0 to 1
+}
+
+// Learning holds learning mode settings. Empty Patterns uses the learner
+// defaults.
+type Learning struct {
+	Enabled  bool     `yaml:"enabled"`
+	Patterns []string `yaml:"patterns"` // label prefixes that mark a candidate
+}
+
+// StatsD holds the StatsD push exporter settings. An empty Addr disables
+// it.
+type StatsD struct {
+	Addr     string   `yaml:"addr"`     // host:port of the StatsD server (UDP)
+	Prefix   string   `yaml:"prefix"`   // metric name prefix, e.g. "fps" for fps.requests
+	Interval Duration `yaml:"interval"` // time between pushes
 }
 
 // Dashboard holds web dashboard configuration.
@@ -89,13 +566,18 @@ type Dashboard struct {
 // Default returns a Config populated with built-in defaults.
 func Default() Config {
 	return Config{
-		Listen:  ":18737",
-		LogDir:  "logs",
-		Verbose: false,
-		DataDir: ".",
+		Listen:        ":18737",
+		LogDir:        "logs",
+		LogRotateSize: 10,
+		LogRotateKeep: 3,
+		Verbose:       false,
+		DataDir:       ".",
 		MITM: MITM{
-			CACert: "ca-cert.pem",
-			CAKey:  "ca-key.pem",
+			CACert:        "ca-cert.pem",
+			CAKey:         "ca-key.pem",
+			CertCacheTTL:  Duration{12 * time.Hour},
+			MaxBufferSize: defaultMaxBufferSize,
+			DiskBufferMax: 100 * 1024 * 1024,
 		},
 		Transparent: Transparent{
 			Enabled:   false,
@@ -106,13 +588,19 @@ func Default() Config {
 			Shutdown:   Duration{5 * time.Second},
 			Connect:    Duration{10 * time.Second},
 			ReadHeader: Duration{10 * time.Second},
+			Request:    Duration{5 * time.Minute},
 		},
 		Management: Management{
 			PathPrefix: "/fps",
 		},
 		Stats: Stats{
-			Enabled:       true,
-			FlushInterval: Duration{60 * time.Second},
+			Enabled:           true,
+			FlushInterval:     Duration{60 * time.Second},
+			MetricsTopDomains: 50,
+		},
+		StatsD: StatsD{
+			Prefix:   "fps",
+			Interval: Duration{10 * time.Second},
 		},
 	}
 }
@@ -201,27 +689,35 @@ func (c *Config) Validate() error {
 	}
 
 	errs = append(errs, validateBlocklistURLs(c.BlocklistURLs)...)
+	errs = append(errs, validateBlocklistSources(c.BlocklistSources)...)
 	errs = append(errs, validateBlocklist(c.Blocklist)...)
+	errs = append(errs, validateBlocklistCIDRs(c.BlocklistCIDRs)...)
+	errs = append(errs, validateBlocklistSchedules(c.BlocklistSchedules)...)
+	if c.BlocklistRequireNonempty && len(c.BlocklistURLs)+len(c.MirrorGroupNames()) == 0 {
+		errs = append(errs, "blocklist_require_nonempty: requires blocklist_urls or mirror groups in blocklist_sources")
+	}
 	errs = append(errs, validateAllowlist(c.Allowlist)...)
+	errs = append(errs, validateManagement(c.Management)...)
 	errs = append(errs, validateMITM(c.MITM)...)
 	errs = append(errs, validateTransparent(c.Transparent, c.Listen)...)
+	errs = append(errs, validateListenTLS(c.ListenTLS, c.Listen)...)
 	errs = append(errs, validatePlugins(c.Plugins)...)
-
-	// Durations must be positive.
-	if c.Timeouts.Shutdown.Duration <= 0 {
-		errs = append(errs, fmt.Sprintf("timeouts.shutdown: must be positive, got %s", c.Timeouts.Shutdown))
+	errs = append(errs, validateResponsePipeline(c.MITM.ResponsePipeline, c.Plugins)...)
+	errs = append(errs, validateProxy(c.Proxy)...)
+	errs = append(errs, validateUpstream(c.Upstream)...)
+	errs = append(errs, validateSuggestions(c.Suggestions)...)
+	errs = append(errs, validateLearning(c.Learning)...)
+	errs = append(errs, validateZones(c.Zones)...)
+	errs = append(errs, validateStatsD(c.StatsD)...)
+	errs = append(errs, validateChaos(c.Chaos)...)
+	if c.LogRotateSize <= 0 {
+		errs = append(errs, fmt.Sprintf("log_rotate_size: must be positive, got %d", c.LogRotateSize))
 	}
-	if c.Timeouts.Connect.Duration <= 0 {
-		errs = append(errs, fmt.Sprintf("timeouts.connect: must be positive, got %s", c.Timeouts.Connect))
-	}
-	if c.Timeouts.ReadHeader.Duration <= 0 {
-		errs = append(errs, fmt.Sprintf("timeouts.read_header: must be positive, got %s", c.Timeouts.ReadHeader))
-	}
-
-	// Stats flush interval must be positive when enabled.
-	if c.Stats.Enabled && c.Stats.FlushInterval.Duration <= 0 {
-		errs = append(errs, fmt.Sprintf("stats.flush_interval: must be positive, got %s", c.Stats.FlushInterval))
+	if c.LogRotateKeep <= 0 {
+		errs = append(errs, fmt.Sprintf("log_rotate_keep: must be positive, got %d", c.LogRotateKeep))
 	}
+	errs = append(errs, validateTimeouts(c.Timeouts)...)
+	errs = append(errs, validateStats(c.Stats)...)
 
 	// Management path prefix.
 	if !strings.HasPrefix(c.Management.PathPrefix, "/") {
@@ -240,6 +736,115 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// validateProxy checks the forward proxy section: header and cookie
+// stripping, client auth, rate limits, retries, debug headers, transport
+// and cache settings, and request limits.
+func validateProxy(p Proxy) []string {
+	var errs []string
+	errs = append(errs, validateHeaderNames("proxy.strip_request_headers", p.StripRequestHeaders)...)
+	errs = append(errs, validateHeaderNames("proxy.strip_response_headers", p.StripResponseHeaders)...)
+	errs = append(errs, validateStripCookies(p.StripCookies)...)
+	errs = append(errs, validateProxyClientAuth(p.Auth)...)
+	if p.RateLimit.RequestsPerSecond < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.rate_limit.requests_per_second: must not be negative, got %g", p.RateLimit.RequestsPerSecond))
+	}
+	if p.RateLimit.Burst < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.rate_limit.burst: must not be negative, got %d", p.RateLimit.Burst))
+	}
+	if p.UpstreamRetries < 0 || p.UpstreamRetries > maxUpstreamRetries {
+		errs = append(errs, fmt.Sprintf("proxy.upstream_retries: must be between 0 and %d, got %d", maxUpstreamRetries, p.UpstreamRetries))
+	}
+	if p.UpstreamRetryBackoff.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.upstream_retry_backoff: must not be negative, got %s", p.UpstreamRetryBackoff.Duration))
+	}
+	for i, s := range p.DebugHeaderClients {
+		if _, err := parsePrefix(s); err != nil {
+			errs = append(errs, fmt.Sprintf("proxy.debug_header_clients[%d]: invalid CIDR or IP %q", i, s))
+		}
+	}
+	errs = append(errs, validateProxyTransport(p.Transport)...)
+	errs = append(errs, validateProxyCache(p.Cache)...)
+	if p.MaxInflight < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.max_inflight: must not be negative, got %d", p.MaxInflight))
+	}
+	if p.MaxResponseBytes < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.max_response_bytes: must not be negative, got %d", p.MaxResponseBytes))
+	}
+	return errs
+}
+
+// validateUpstream checks the upstream resolver and proxy fallback.
+func validateUpstream(u Upstream) []string {
+	var errs []string
+	errs = append(errs, validateResolver(u.Resolver)...)
+	errs = append(errs, validateProxyFallback(u.ProxyFallback)...)
+	errs = append(errs, validateProxyAuth(u)...)
+	return errs
+}
+
+// validateTimeouts checks that required timeouts are positive and optional
+// ones are not negative.
+func validateTimeouts(t Timeouts) []string {
+	var errs []string
+	if t.Shutdown.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.shutdown: must be positive, got %s", t.Shutdown))
+	}
+
+	// Per-subsystem shutdown timeouts are optional (zero = use shutdown).
+	if t.ShutdownTransparent.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.shutdown_transparent: must not be negative, got %s", t.ShutdownTransparent))
+	}
+	if t.ShutdownProxy.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.shutdown_proxy: must not be negative, got %s", t.ShutdownProxy))
+	}
+	if t.ShutdownTunnels.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.shutdown_tunnels: must not be negative, got %s", t.ShutdownTunnels))
+	}
+
+	if t.Connect.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.connect: must be positive, got %s", t.Connect))
+	}
+	if t.ReadHeader.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.read_header: must be positive, got %s", t.ReadHeader))
+	}
+	if t.Request.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("timeouts.request: must not be negative, got %s", t.Request))
+	}
+	if t.RequestIncludesBody && t.Request.Duration == 0 {
+		errs = append(errs, "timeouts.request_includes_body: requires timeouts.request")
+	}
+	return errs
+}
+
+// validateStats checks the stats database settings.
+func validateStats(st Stats) []string {
+	var errs []string
+	if st.MetricsTopDomains < 0 {
+		errs = append(errs, fmt.Sprintf("stats.metrics_top_domains: must not be negative, got %d", st.MetricsTopDomains))
+	}
+
+	// Flush interval must be positive when enabled.
+	if st.Enabled && st.FlushInterval.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("stats.flush_interval: must be positive, got %s", st.FlushInterval))
+	}
+	if st.Retention.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("stats.retention: must not be negative, got %s", st.Retention))
+	} else if r := st.Retention.Duration; r > 0 && r < time.Hour {
+		errs = append(errs, fmt.Sprintf("stats.retention: must be at least 1h (rows are hourly), got %s", st.Retention))
+	}
+	if st.ClientIdle.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("stats.client_idle: must not be negative, got %s", st.ClientIdle))
+	}
+	if r := st.ClientIdleRetention.Duration; r < 0 {
+		errs = append(errs, fmt.Sprintf("stats.client_idle_retention: must not be negative, got %s", st.ClientIdleRetention))
+	} else if r > 0 && r < time.Hour {
+		errs = append(errs, fmt.Sprintf("stats.client_idle_retention: must be at least 1h (rows are hourly), got %s", st.ClientIdleRetention))
+	} else if r > 0 && st.ClientIdle.Duration == 0 {
+		errs = append(errs, "stats.client_idle_retention: requires stats.client_idle")
+	}
+	return errs
+}
+
 // validateBlocklistURLs checks that all blocklist URLs are valid HTTP(S) URLs.
 func validateBlocklistURLs(urls []string) []string {
 	var errs []string
@@ -256,12 +861,304 @@ func validateBlocklistURLs(urls []string) []string {
 	return errs
 }
 
-// validateBlocklist checks that inline blocklist entries are valid domain names.
+// validateResolver checks the upstream.resolver format.
+func validateResolver(spec string) []string {
+	if _, _, err := upstream.ParseResolver(spec); err != nil {
+		return []string{"upstream.resolver: " + err.Error()}
+	}
+	return nil
+}
+
+// validateProxyTransport checks that transport limits are not negative.
+func validateProxyTransport(t ProxyTransport) []string {
+	var errs []string
+	if t.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.transport.max_idle_conns: must not be negative, got %d", t.MaxIdleConns))
+	}
+	if t.MaxIdleConnsPerHost < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.transport.max_idle_conns_per_host: must not be negative, got %d", t.MaxIdleConnsPerHost))
+	}
+	if t.IdleConnTimeout.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.transport.idle_conn_timeout: must not be negative, got %s", t.IdleConnTimeout))
+	}
+	if t.TLSHandshakeTimeout.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.transport.tls_handshake_timeout: must not be negative, got %s", t.TLSHandshakeTimeout))
+	}
+	return errs
+}
+
+// validateProxyCache checks that cache limits are not negative and an
+// object fits in the cache.
+func validateProxyCache(pc ProxyCache) []string {
+	var errs []string
+	if pc.MaxSize < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.cache.max_size: must not be negative, got %d", pc.MaxSize))
+	}
+	if pc.MaxObjectSize < 0 {
+		errs = append(errs, fmt.Sprintf("proxy.cache.max_object_size: must not be negative, got %d", pc.MaxObjectSize))
+	}
+	if pc.MaxSize > 0 && pc.MaxObjectSize > pc.MaxSize {
+		errs = append(errs, fmt.Sprintf("proxy.cache.max_object_size: must not exceed max_size (%d), got %d", pc.MaxSize, pc.MaxObjectSize))
+	}
+	return errs
+}
+
+// validateProxyFallback checks the upstream.proxy_fallback spec.
+func validateProxyFallback(spec string) []string {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "direct" {
+		return nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		return []string{fmt.Sprintf("upstream.proxy_fallback: want \"direct\" or an http:// proxy URL, got %q", spec)}
+	}
+	if u.User != nil {
+		return []string{fmt.Sprintf(
+			"upstream.proxy_fallback: credentials in the URL are not supported, use upstream.proxy_user and upstream.proxy_pass (got %q)",
+			u.Redacted())}
+	}
+	return nil
+}
+
+// validateProxyAuth checks upstream.proxy_user/proxy_pass.
+func validateProxyAuth(u Upstream) []string {
+	var errs []string
+	if u.ProxyUser == "" {
+		if u.ProxyPass != "" {
+			errs = append(errs, "upstream.proxy_pass: requires upstream.proxy_user")
+		}
+		return errs
+	}
+	if strings.Contains(u.ProxyUser, ":") {
+		errs = append(errs, "upstream.proxy_user: must not contain ':'")
+	}
+	if fb := strings.TrimSpace(u.ProxyFallback); fb == "" || fb == "direct" {
+		errs = append(errs, "upstream.proxy_user: requires an http:// upstream.proxy_fallback")
+	}
+	return errs
+}
+
+// validateProxyClientAuth checks proxy.auth credentials: each needs a
+// username without ':' and a password, and usernames must be unique.
+func validateProxyClientAuth(a ProxyAuth) []string {
+	var errs []string
+	seen := make(map[string]bool, len(a.Users)+1)
+	check := func(field string, u ProxyAuthUser) {
+		switch {
+		case u.Username == "":
+			errs = append(errs, field+".username: must not be empty")
+		case strings.Contains(u.Username, ":"):
+			errs = append(errs, field+".username: must not contain ':'")
+		case seen[u.Username]:
+			errs = append(errs, fmt.Sprintf("%s.username: duplicate user %q", field, u.Username))
+		}
+		seen[u.Username] = true
+		if u.Password == "" {
+			errs = append(errs, field+".password: must not be empty")
+		}
+	}
+	if a.Username != "" || a.Password != "" {
+		check("proxy.auth", ProxyAuthUser{Username: a.Username, Password: a.Password})
+	}
+	for i, u := range a.Users {
+		check(fmt.Sprintf("proxy.auth.users[%d]", i), u)
+	}
+	return errs
+}
+
+// validateSuggestions checks that suggestion limits are not negative.
+func validateSuggestions(s Suggestions) []string {
+	var errs []string
+	if s.MinBlocks < 0 {
+		errs = append(errs, fmt.Sprintf("suggestions.min_blocks: must not be negative, got %d", s.MinBlocks))
+	}
+	if s.Window.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("suggestions.window: must not be negative, got %s", s.Window))
+	}
+	if s.MaxPairs < 0 {
+		errs = append(errs, fmt.Sprintf("suggestions.max_pairs: must not be negative, got %d", s.MaxPairs))
+	}
+	return errs
+}
+
+// validateLearning checks that learning patterns are single label prefixes.
+func validateLearning(l Learning) []string {
+	var errs []string
+	for i, p := range l.Patterns {
+		if p = strings.TrimSpace(p); p == "" || strings.ContainsAny(p, ". \t*") {
+			errs = append(errs, fmt.Sprintf("learning.patterns[%d]: want a label prefix such as \"ads\", got %q", i, l.Patterns[i]))
+		}
+	}
+	return errs
+}
+
+// validateStatsD checks the exporter address, prefix, and interval when the
+// exporter is enabled.
+func validateStatsD(s StatsD) []string {
+	if s.Addr == "" {
+		return nil
+	}
+	var errs []string
+	if _, err := net.ResolveUDPAddr("udp", s.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("statsd.addr: invalid address %q: %v", s.Addr, err))
+	}
+	if strings.ContainsAny(s.Prefix, ":|@# \t\n") {
+		errs = append(errs, fmt.Sprintf("statsd.prefix: must not contain ':', '|', '@', '#', or whitespace, got %q", s.Prefix))
+	}
+	if s.Interval.Duration < time.Second {
+		errs = append(errs, fmt.Sprintf("statsd.interval: must be at least 1s, got %s", s.Interval))
+	}
+	return errs
+}
+
+// validateChaos checks that delays are not negative and the probability
+// is a fraction.
+func validateChaos(c Chaos) []string {
+	var errs []string
+	if c.Delay.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("chaos.delay: must not be negative, got %s", c.Delay))
+	}
+	if c.Jitter.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("chaos.jitter: must not be negative, got %s", c.Jitter))
+	}
+	if c.Probability < 0 || c.Probability > 1 {
+		errs = append(errs, fmt.Sprintf("chaos.probability: must be between 0 and 1, got %g", c.Probability))
+	}
+	return errs
+}
+
+// validateZones checks that zones are named and their ranges are CIDRs or
+// IP addresses.
+func validateZones(zones map[string][]string) []string {
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, "zones: zone name must not be empty")
+			continue
+		}
+		for i, s := range zones[name] {
+			if _, err := parsePrefix(s); err != nil {
+				errs = append(errs, fmt.Sprintf("zones.%s[%d]: invalid CIDR or IP %q", name, i, s))
+			}
+		}
+	}
+	return errs
+}
+
+// validateStripCookies checks that proxy.strip_cookies patterns compile.
+func validateStripCookies(patterns []string) []string {
+	var errs []string
+	for i, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Sprintf("proxy.strip_cookies[%d]: invalid regex %q: %v", i, p, err))
+		}
+	}
+	return errs
+}
+
+// validateBlocklistSources checks that per-source exclude patterns compile.
+func validateBlocklistSources(sources map[string]BlocklistSource) []string {
+	urls := make([]string, 0, len(sources))
+	for u := range sources {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	var errs []string
+	for _, u := range urls {
+		src := sources[u]
+		for i, p := range src.ExcludePatterns {
+			if _, err := regexp.Compile(p); err != nil {
+				errs = append(errs, fmt.Sprintf("blocklist_sources[%q].exclude_patterns[%d]: invalid regex %q: %v", u, i, p, err))
+			}
+		}
+		for _, e := range validateBlocklistURLs(src.Mirrors) {
+			errs = append(errs, fmt.Sprintf("blocklist_sources[%q].mirrors%s", u, strings.TrimPrefix(e, "blocklist_urls")))
+		}
+		switch src.Strategy {
+		case "", "failover", "round-robin":
+		default:
+			errs = append(errs, fmt.Sprintf("blocklist_sources[%q].strategy: must be \"failover\" or \"round-robin\", got %q", u, src.Strategy))
+		}
+		if src.Strategy != "" && len(src.Mirrors) == 0 {
+			errs = append(errs, fmt.Sprintf("blocklist_sources[%q].strategy: requires mirrors", u))
+		}
+	}
+	return errs
+}
+
+// validateBlocklistCIDRs checks that blocklist_cidrs entries are CIDRs or
+// IP addresses.
+func validateBlocklistCIDRs(cidrs []string) []string {
+	var errs []string
+	for i, s := range cidrs {
+		if _, err := parsePrefix(s); err != nil {
+			errs = append(errs, fmt.Sprintf("blocklist_cidrs[%d]: invalid CIDR or IP %q", i, s))
+		}
+	}
+	return errs
+}
+
+// validateBlocklist checks that inline blocklist entries are valid domain
+// names, *.domain suffix patterns, or "re:" regular expressions.
 func validateBlocklist(domains []string) []string {
 	var errs []string
 	for i, d := range domains {
-		if d == "" || strings.Contains(d, "*") || strings.Contains(d, "/") || strings.Contains(d, " ") {
+		if expr, ok := strings.CutPrefix(d, "re:"); ok {
+			if expr == "" {
+				errs = append(errs, fmt.Sprintf("blocklist[%d]: empty pattern", i))
+			} else if _, err := regexp.Compile(expr); err != nil {
+				errs = append(errs, fmt.Sprintf("blocklist[%d]: invalid pattern %q: %v", i, expr, err))
+			}
+			continue
+		}
+		switch {
+		case d == "" || strings.Contains(d, "/") || strings.Contains(d, " "):
 			errs = append(errs, fmt.Sprintf("blocklist[%d]: invalid domain %q", i, d))
+		case strings.HasPrefix(d, "*."):
+			if suffix := d[2:]; suffix == "" || strings.Contains(suffix, "*") {
+				errs = append(errs, fmt.Sprintf("blocklist[%d]: invalid suffix pattern %q", i, d))
+			}
+		case strings.Contains(d, "*"):
+			errs = append(errs, fmt.Sprintf("blocklist[%d]: wildcard must be prefix *.domain, got %q", i, d))
+		}
+	}
+	return errs
+}
+
+// validateBlocklistSchedules checks schedule domains, windows, days, and
+// timezones.
+func validateBlocklistSchedules(schedules []BlocklistSchedule) []string {
+	var errs []string
+	for i, s := range schedules {
+		prefix := fmt.Sprintf("blocklist_schedules[%d]", i)
+		if len(s.Domains) == 0 {
+			errs = append(errs, prefix+".domains: must not be empty")
+		}
+		for j, d := range s.Domains {
+			if d == "" || strings.Contains(d, "*") || strings.Contains(d, "/") || strings.Contains(d, " ") {
+				errs = append(errs, fmt.Sprintf("%s.domains[%d]: invalid domain %q", prefix, j, d))
+			}
+		}
+		if _, _, err := blocklist.ParseWindow(s.BlockBetween); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.block_between: %v", prefix, err))
+		}
+		for j, d := range s.Days {
+			if _, ok := blocklist.ParseWeekday(d); !ok {
+				errs = append(errs, fmt.Sprintf("%s.days[%d]: invalid day %q", prefix, j, d))
+			}
+		}
+		if s.TZ != "" {
+			if _, err := time.LoadLocation(s.TZ); err != nil {
+				errs = append(errs, fmt.Sprintf("%s.tz: unknown timezone %q", prefix, s.TZ))
+			}
 		}
 	}
 	return errs
@@ -270,18 +1167,43 @@ func validateBlocklist(domains []string) []string {
 // validateAllowlist checks that allowlist entries are valid exact domains or
 // *.domain suffix patterns.
 func validateAllowlist(entries []string) []string {
+	return validateDomainPatterns("allowlist", entries)
+}
+
+// validateDomainPatterns checks a list of exact domains and "*.domain"
+// suffix patterns, reporting errors under field.
+func validateDomainPatterns(field string, entries []string) []string {
 	var errs []string
 	for i, entry := range entries {
 		switch {
 		case entry == "" || strings.Contains(entry, "/") || strings.Contains(entry, " "):
-			errs = append(errs, fmt.Sprintf("allowlist[%d]: invalid entry %q", i, entry))
+			errs = append(errs, fmt.Sprintf("%s[%d]: invalid entry %q", field, i, entry))
 		case strings.HasPrefix(entry, "*."):
 			domain := entry[2:]
 			if domain == "" || strings.Contains(domain, "*") {
-				errs = append(errs, fmt.Sprintf("allowlist[%d]: invalid suffix pattern %q", i, entry))
+				errs = append(errs, fmt.Sprintf("%s[%d]: invalid suffix pattern %q", field, i, entry))
 			}
 		case strings.Contains(entry, "*"):
-			errs = append(errs, fmt.Sprintf("allowlist[%d]: wildcard must be prefix *.domain, got %q", i, entry))
+			errs = append(errs, fmt.Sprintf("%s[%d]: wildcard must be prefix *.domain, got %q", field, i, entry))
+		}
+	}
+	return errs
+}
+
+// validateManagement checks the PAC file settings.
+func validateManagement(m Management) []string {
+	var errs []string
+	if h := m.ExternalHost; h != "" {
+		ipv6 := strings.Contains(h, ":") && net.ParseIP(strings.Trim(h, "[]")) != nil
+		if strings.ContainsAny(h, " /") || (strings.Contains(h, ":") && !ipv6) {
+			errs = append(errs, fmt.Sprintf("management.external_host: want a host name or IP without port, got %q", h))
+		}
+	}
+	errs = append(errs, validateDomainPatterns("management.pac_direct", m.PACDirect)...)
+	for i, e := range m.DisabledEndpoints {
+		if !slices.Contains(ManagementEndpoints, e) {
+			errs = append(errs, fmt.Sprintf("management.disabled_endpoints[%d]: unknown endpoint %q (want one of %s)",
+				i, e, strings.Join(ManagementEndpoints, ", ")))
 		}
 	}
 	return errs
@@ -291,8 +1213,60 @@ func validateAllowlist(entries []string) []string {
 func validateMITM(m MITM) []string {
 	var errs []string
 	for i, d := range m.Domains {
-		if d == "" || strings.Contains(d, "*") || strings.Contains(d, "/") || strings.Contains(d, " ") {
-			errs = append(errs, fmt.Sprintf("mitm.domains[%d]: invalid domain %q", i, d))
+		pattern := strings.TrimPrefix(d, "*.")
+		if pattern == "" || strings.Contains(pattern, "*") || strings.Contains(d, "/") || strings.Contains(d, " ") {
+			errs = append(errs, fmt.Sprintf("mitm.domains[%d]: invalid domain %q (want example.com or *.example.com)", i, d))
+		}
+	}
+	errs = append(errs, validateDomainPatterns("mitm.never_intercept", m.NeverIntercept)...)
+	if m.MaxBufferSize <= 0 {
+		errs = append(errs, fmt.Sprintf("mitm.max_buffer_size: must be positive, got %d", m.MaxBufferSize))
+	}
+	if m.DiskBuffer && m.DiskBufferMax <= m.MaxBufferSize {
+		errs = append(errs, fmt.Sprintf("mitm.disk_buffer_max: must be above max_buffer_size (%d bytes), got %d", m.MaxBufferSize, m.DiskBufferMax))
+	}
+	if m.CertCacheTTL.Duration < 0 {
+		errs = append(errs, fmt.Sprintf("mitm.cert_cache_ttl: must not be negative, got %s", m.CertCacheTTL))
+	}
+	if m.PipelineDepth < 0 || m.PipelineDepth > MaxPipelineDepth {
+		errs = append(errs, fmt.Sprintf("mitm.pipeline_depth: must be between 0 and %d, got %d", MaxPipelineDepth, m.PipelineDepth))
+	}
+	errs = append(errs, validateCASubject(m.CASubject)...)
+	switch m.CAKeyType {
+	case "", "ecdsa":
+		if m.CAKeyBits != 0 {
+			errs = append(errs, "mitm.ca_key_bits: only applies to mitm.ca_key_type: rsa")
+		}
+	case "rsa":
+		if m.CAKeyBits != 0 && m.CAKeyBits != 2048 && m.CAKeyBits != 4096 {
+			errs = append(errs, fmt.Sprintf("mitm.ca_key_bits: must be 2048 or 4096, got %d", m.CAKeyBits))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("mitm.ca_key_type: must be ecdsa or rsa, got %q", m.CAKeyType))
+	}
+	return errs
+}
+
+// validateCASubject checks CA subject fields: printable UTF-8 of at most
+// 64 characters each.
+func validateCASubject(s CASubject) []string {
+	var errs []string
+	fields := []struct{ key, value string }{
+		{"common_name", s.CommonName},
+		{"organization", s.Organization},
+		{"organizational_unit", s.OrganizationalUnit},
+	}
+	for _, f := range fields {
+		switch {
+		case !utf8.ValidString(f.value):
+			errs = append(errs, fmt.Sprintf("mitm.ca_subject.%s: must be valid UTF-8", f.key))
+		case utf8.RuneCountInString(f.value) > maxSubjectFieldLen:
+			errs = append(errs, fmt.Sprintf("mitm.ca_subject.%s: must be at most %d characters, got %d",
+				f.key, maxSubjectFieldLen, utf8.RuneCountInString(f.value)))
+		case strings.IndexFunc(f.value, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0:
+			errs = append(errs, fmt.Sprintf("mitm.ca_subject.%s: must not contain control characters", f.key))
+		case f.value != strings.TrimSpace(f.value):
+			errs = append(errs, fmt.Sprintf("mitm.ca_subject.%s: must not have leading or trailing spaces", f.key))
 		}
 	}
 	return errs
@@ -333,6 +1307,38 @@ func validateTransparent(t Transparent, listenAddr string) []string {
 	return errs
 }
 
+// validateListenTLS checks the proxy-over-TLS listener configuration.
+// Certificate contents are checked at startup when the key pair is loaded.
+func validateListenTLS(l ListenTLS, listenAddr string) []string {
+	var errs []string
+	if l.Addr == "" {
+		if l.Cert != "" || l.Key != "" {
+			errs = append(errs, "listen_tls: cert and key require addr")
+		}
+		return errs
+	}
+	if _, err := net.ResolveTCPAddr("tcp", l.Addr); err != nil {
+		errs = append(errs, fmt.Sprintf("listen_tls.addr: invalid address %q: %v", l.Addr, err))
+	} else if l.Addr == listenAddr {
+		errs = append(errs, fmt.Sprintf("listen_tls.addr: conflicts with listen address %q", listenAddr))
+	}
+	if (l.Cert == "") != (l.Key == "") {
+		errs = append(errs, "listen_tls: both cert and key must be set (or both empty to generate a certificate)")
+	}
+	return errs
+}
+
+// validateHeaderNames checks that entries are plausible HTTP header names.
+func validateHeaderNames(field string, names []string) []string {
+	var errs []string
+	for i, n := range names {
+		if n == "" || strings.ContainsAny(n, " \t:\r\n") {
+			errs = append(errs, fmt.Sprintf("%s[%d]: invalid header name %q", field, i, n))
+		}
+	}
+	return errs
+}
+
 // validatePlugins checks that plugin configuration entries are well-formed.
 // Note: registry existence and MITM domain subset checks happen at runtime
 // in plugin.InitPlugins, since config doesn't know about the plugin registry.
@@ -363,12 +1369,56 @@ func validatePlugins(plugins map[string]PluginConf) []string {
 	return errs
 }
 
+// validateResponsePipeline checks that a non-empty mitm.response_pipeline
+// names each enabled plugin exactly once.
+func validateResponsePipeline(order []string, plugins map[string]PluginConf) []string {
+	if len(order) == 0 {
+		return nil
+	}
+	var errs []string
+	seen := make(map[string]bool, len(order))
+	for i, name := range order {
+		p, ok := plugins[name]
+		switch {
+		case !ok || !p.Enabled:
+			errs = append(errs, fmt.Sprintf("mitm.response_pipeline[%d]: %q is not an enabled plugin", i, name))
+		case seen[name]:
+			errs = append(errs, fmt.Sprintf("mitm.response_pipeline[%d]: %q listed more than once", i, name))
+		}
+		seen[name] = true
+	}
+	var missing []string
+	for name, p := range plugins {
+		if p.Enabled && !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		errs = append(errs, fmt.Sprintf("mitm.response_pipeline: enabled plugin %q is missing", name))
+	}
+	return errs
+}
+
 // Redacted returns a copy of the config with sensitive fields masked.
 func (c *Config) Redacted() Config {
 	r := *c
 	if r.Dashboard.Password != "" {
 		r.Dashboard.Password = "***"
 	}
+	if r.Upstream.ProxyPass != "" {
+		r.Upstream.ProxyPass = "***"
+	}
+	if r.Proxy.Auth.Password != "" {
+		r.Proxy.Auth.Password = "***"
+	}
+	if len(r.Proxy.Auth.Users) > 0 {
+		users := make([]ProxyAuthUser, len(r.Proxy.Auth.Users))
+		for i, u := range r.Proxy.Auth.Users {
+			users[i] = ProxyAuthUser{Username: u.Username, Password: "***"}
+		}
+		r.Proxy.Auth.Users = users
+	}
 	return r
 }
 
@@ -376,3 +1426,54 @@ func (c *Config) Redacted() Config {
 func (c *Config) Dump() ([]byte, error) {
 	return yaml.Marshal(c)
 }
+
+// Snapshot is the part of the config that can drift at runtime: the
+// allowlist (edited from the dashboard), the inline blocklist, and plugin
+// settings (domains paused from the dashboard). Its YAML uses the same
+// keys as fpsd.yml, so a dumped snapshot can be merged into the config.
+type Snapshot struct {
+	Allowlist []string              `yaml:"allowlist"`
+	Blocklist []string              `yaml:"blocklist"`
+	Plugins   map[string]PluginConf `yaml:"plugins,omitempty"`
+}
+
+// Snapshot returns the config's allowlist, blocklist, and plugins as a
+// Snapshot. Slices and plugin entries are copied, so the caller may
+// adjust the result to the running state without touching c.
+func (c *Config) Snapshot() Snapshot {
+	snap := Snapshot{
+		Allowlist: append([]string{}, c.Allowlist...),
+		Blocklist: append([]string{}, c.Blocklist...),
+	}
+	if len(c.Plugins) > 0 {
+		snap.Plugins = make(map[string]PluginConf, len(c.Plugins))
+		for name, p := range c.Plugins {
+			p.Domains = append([]string(nil), p.Domains...)
+			p.Options = maps.Clone(p.Options)
+			snap.Plugins[name] = p
+		}
+	}
+	return snap
+}
+
+// Dump serializes the snapshot to YAML, formatted like Config.Dump.
+func (s *Snapshot) Dump() ([]byte, error) {
+	return yaml.Marshal(s)
+}
+
+// DumpJSON serializes the config as JSON using the same keys as the YAML
+// file. pretty indents the output; otherwise it is compact.
+func (c *Config) DumpJSON(pretty bool) ([]byte, error) {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+	if pretty {
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	return json.Marshal(doc)
+}
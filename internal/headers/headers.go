@@ -0,0 +1,264 @@
+/*
+Package headers provides the hop-by-hop header handling shared by every
+forwarding path (explicit proxy, transparent listener, MITM).
+
+All paths strip the same RFC 7230 hop-by-hop set. Operators can configure
+additional headers to strip from forwarded requests and relayed responses
+(e.g., Server or X-Powered-By for privacy), and cookies to strip by name.
+
+Headers are edited in place on the http.Header map, so repeated fields keep
+their multiplicity: each Set-Cookie line stays a separate header and is
+never folded into one, on every path.
+
+With a logger set (verbose mode), the Stripper logs each configured rule
+that changed a message: the header names it removed or edited and, for
+cookie rules, the cookie names. Values are never logged.
+
+BufferDeclared backs the strict Content-Length mode: it buffers a relayed
+response before sending it, so a body that arrives short of its declared
+length is aborted instead of relayed as complete.
+*/
+package headers
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// hopByHop are headers that apply to a single transport-level connection
+// and must not be forwarded by proxies. Proxy-Connection is non-standard
+// but still sent by some clients.
+var hopByHop = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopByHop strips the standard hop-by-hop headers from h.
+func RemoveHopByHop(h http.Header) {
+	for _, hdr := range hopByHop {
+		h.Del(hdr)
+	}
+}
+
+// IsWebSocketUpgrade reports whether h asks to upgrade the connection to
+// WebSocket: an "upgrade" token in Connection and Upgrade: websocket.
+func IsWebSocketUpgrade(h http.Header) bool {
+	if !strings.EqualFold(strings.TrimSpace(h.Get("Upgrade")), "websocket") {
+		return false
+	}
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Stripper removes hop-by-hop headers plus operator-configured extras.
+// A nil *Stripper strips only the hop-by-hop set.
+type Stripper struct {
+	request  []string
+	response []string
+	cookies  []*regexp.Regexp
+	logger   *slog.Logger // nil = no change logging
+}
+
+// NewStripper creates a Stripper that additionally removes extraRequest
+// headers from forwarded requests and extraResponse headers from relayed
+// responses. Cookies whose name matches one of cookies are removed from
+// request Cookie headers and response Set-Cookie headers.
+func NewStripper(extraRequest, extraResponse []string, cookies []*regexp.Regexp) *Stripper {
+	return &Stripper{
+		request:  canonical(extraRequest),
+		response: canonical(extraResponse),
+		cookies:  cookies,
+	}
+}
+
+// SetLogger enables debug logging of the changes configured rules make.
+// Nil disables it. Call before the Stripper is used.
+func (s *Stripper) SetLogger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+// StripRequest removes hop-by-hop and configured request headers from h.
+// domain is only used to label change logs.
+func (s *Stripper) StripRequest(h http.Header, domain string) {
+	RemoveHopByHop(h)
+	if s == nil {
+		return
+	}
+	removed := s.deleteHeaders(h, s.request)
+	s.logChange("request", "strip_request_headers", domain, removed, nil, nil)
+	if len(s.cookies) > 0 {
+		names := s.stripCookies(h)
+		s.logCookies("request", "Cookie", domain, h, names)
+	}
+}
+
+// StripResponse removes hop-by-hop and configured response headers from h.
+// domain is only used to label change logs.
+func (s *Stripper) StripResponse(h http.Header, domain string) {
+	RemoveHopByHop(h)
+	if s == nil {
+		return
+	}
+	removed := s.deleteHeaders(h, s.response)
+	s.logChange("response", "strip_response_headers", domain, removed, nil, nil)
+	if len(s.cookies) > 0 {
+		names := s.stripSetCookies(h)
+		s.logCookies("response", "Set-Cookie", domain, h, names)
+	}
+}
+
+// deleteHeaders removes names from h. When logging, it returns the names
+// that were present.
+func (s *Stripper) deleteHeaders(h http.Header, names []string) []string {
+	var removed []string
+	for _, hdr := range names {
+		if s.logger != nil {
+			if _, ok := h[hdr]; ok {
+				removed = append(removed, hdr)
+			}
+		}
+		delete(h, hdr)
+	}
+	return removed
+}
+
+// stripCookies removes matching name=value pairs from each Cookie header,
+// dropping headers left empty. When logging, it returns the removed cookie
+// names.
+func (s *Stripper) stripCookies(h http.Header) []string {
+	values := h["Cookie"]
+	if len(values) == 0 {
+		return nil
+	}
+	var removed []string
+	kept := values[:0]
+	for _, v := range values {
+		var pairs []string
+		for pair := range strings.SplitSeq(v, ";") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			if name, ok := s.cookieMatches(pair); ok {
+				if s.logger != nil {
+					removed = append(removed, name)
+				}
+				continue
+			}
+			pairs = append(pairs, pair)
+		}
+		if len(pairs) > 0 {
+			kept = append(kept, strings.Join(pairs, "; "))
+		}
+	}
+	setValues(h, "Cookie", kept)
+	return removed
+}
+
+// stripSetCookies removes Set-Cookie headers for matching cookies. The
+// others are kept as separate headers, in order. When logging, it returns
+// the removed cookie names.
+func (s *Stripper) stripSetCookies(h http.Header) []string {
+	values := h["Set-Cookie"]
+	if len(values) == 0 {
+		return nil
+	}
+	var removed []string
+	kept := values[:0]
+	for _, v := range values {
+		if name, ok := s.cookieMatches(v); ok {
+			if s.logger != nil {
+				removed = append(removed, name)
+			}
+			continue
+		}
+		kept = append(kept, v)
+	}
+	setValues(h, "Set-Cookie", kept)
+	return removed
+}
+
+// logCookies logs a strip_cookies change to header: removed if no value
+// is left, changed otherwise.
+func (s *Stripper) logCookies(direction, header, domain string, h http.Header, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	if _, ok := h[header]; ok {
+		s.logChange(direction, "strip_cookies", domain, nil, []string{header}, names)
+	} else {
+		s.logChange(direction, "strip_cookies", domain, []string{header}, nil, names)
+	}
+}
+
+// logChange logs the headers a rule removed or changed, if logging is on
+// and the rule did anything.
+func (s *Stripper) logChange(direction, rule, domain string, removed, changed, cookies []string) {
+	if s.logger == nil || len(removed)+len(changed) == 0 {
+		return
+	}
+	attrs := []any{
+		"rule", rule,
+		"direction", direction,
+		"domain", domain,
+	}
+	if len(removed) > 0 {
+		attrs = append(attrs, "removed", removed)
+	}
+	if len(changed) > 0 {
+		attrs = append(attrs, "changed", changed)
+	}
+	if len(cookies) > 0 {
+		attrs = append(attrs, "cookies", cookies)
+	}
+	s.logger.Debug("header rule applied", attrs...)
+}
+
+// cookieMatches returns the name of the cookie at the start of v (a
+// Cookie pair or a Set-Cookie value) and whether it matches a strip
+// pattern.
+func (s *Stripper) cookieMatches(v string) (string, bool) {
+	v, _, _ = strings.Cut(v, ";")
+	name, _, _ := strings.Cut(v, "=")
+	name = strings.TrimSpace(name)
+	for _, re := range s.cookies {
+		if re.MatchString(name) {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// setValues replaces key's values, deleting it when none are left.
+func setValues(h http.Header, key string, values []string) {
+	if len(values) == 0 {
+		delete(h, key)
+		return
+	}
+	h[key] = values
+}
+
+// canonical returns the canonical MIME header keys for names.
+func canonical(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		if n != "" {
+			out = append(out, http.CanonicalHeaderKey(n))
+		}
+	}
+	return out
+}
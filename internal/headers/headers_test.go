@@ -0,0 +1,206 @@
+package headers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveHopByHop(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive")
+	h.Set("Proxy-Connection", "keep-alive")
+	h.Set("Proxy-Authorization", "Basic secret")
+	h.Set("X-Keep", "yes")
+
+	RemoveHopByHop(h)
+
+	assert.Empty(t, h.Get("Connection"))
+	assert.Empty(t, h.Get("Proxy-Connection"))
+	assert.Empty(t, h.Get("Proxy-Authorization"))
+	assert.Equal(t, "yes", h.Get("X-Keep"))
+}
+
+func TestStripper_ExtraHeaders(t *testing.T) {
+	s := NewStripper([]string{"x-client-id"}, []string{"server", "X-Powered-By"}, nil)
+
+	req := http.Header{}
+	req.Set("X-Client-Id", "abc")
+	req.Set("Server", "kept-on-request")
+	req.Set("Upgrade", "websocket")
+	s.StripRequest(req, "")
+	assert.Empty(t, req.Get("X-Client-Id"))
+	assert.Empty(t, req.Get("Upgrade"))
+	assert.Equal(t, "kept-on-request", req.Get("Server"))
+
+	resp := http.Header{}
+	resp.Set("Server", "nginx")
+	resp.Set("X-Powered-By", "PHP")
+	resp.Set("X-Client-Id", "kept-on-response")
+	resp.Set("Keep-Alive", "timeout=5")
+	s.StripResponse(resp, "")
+	assert.Empty(t, resp.Get("Server"))
+	assert.Empty(t, resp.Get("X-Powered-By"))
+	assert.Empty(t, resp.Get("Keep-Alive"))
+	assert.Equal(t, "kept-on-response", resp.Get("X-Client-Id"))
+}
+
+func TestStripper_Nil(t *testing.T) {
+	var s *Stripper
+
+	h := http.Header{}
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Server", "nginx")
+	s.StripResponse(h, "")
+
+	assert.Empty(t, h.Get("Transfer-Encoding"))
+	assert.Equal(t, "nginx", h.Get("Server"))
+}
+
+func TestStripper_SetCookieMultiplicity(t *testing.T) {
+	h := http.Header{}
+	h.Add("Set-Cookie", "session=abc; Path=/; HttpOnly")
+	h.Add("Set-Cookie", "theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+
+	NewStripper(nil, []string{"Server"}, nil).StripResponse(h, "")
+
+	assert.Equal(t, []string{
+		"session=abc; Path=/; HttpOnly",
+		"theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT",
+	}, h.Values("Set-Cookie"), "Set-Cookie headers stay separate and in order")
+}
+
+func TestStripper_Cookies(t *testing.T) {
+	s := NewStripper(nil, nil, []*regexp.Regexp{regexp.MustCompile(`^_ga`), regexp.MustCompile(`^_fbp$`)})
+
+	resp := http.Header{}
+	resp.Add("Set-Cookie", "_ga=GA1.2.3; Path=/")
+	resp.Add("Set-Cookie", "session=abc; HttpOnly")
+	resp.Add("Set-Cookie", "_fbp=fb.1; Domain=example.com")
+	resp.Add("Set-Cookie", "theme=dark")
+	s.StripResponse(resp, "")
+	assert.Equal(t, []string{"session=abc; HttpOnly", "theme=dark"}, resp.Values("Set-Cookie"))
+
+	req := http.Header{}
+	req.Add("Cookie", "_ga=GA1.2.3; session=abc;_gid=x")
+	req.Add("Cookie", "_fbp=fb.1")
+	s.StripRequest(req, "")
+	assert.Equal(t, []string{"session=abc; _gid=x"}, req.Values("Cookie"), "emptied Cookie headers are dropped")
+
+	only := http.Header{}
+	only.Add("Set-Cookie", "_ga=1")
+	s.StripResponse(only, "")
+	_, ok := only["Set-Cookie"]
+	assert.False(t, ok)
+}
+
+func TestStripper_LogsChanges(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewStripper([]string{"x-client-id"}, []string{"Server"}, []*regexp.Regexp{regexp.MustCompile(`^_ga`)})
+	s.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	req := http.Header{}
+	req.Set("X-Client-Id", "abc")
+	req.Set("Connection", "keep-alive")
+	req.Add("Cookie", "_ga=1; session=abc")
+	s.StripRequest(req, "example.com")
+
+	resp := http.Header{}
+	resp.Set("Server", "nginx")
+	resp.Add("Set-Cookie", "_gads=2")
+	s.StripResponse(resp, "example.com")
+
+	// A message no rule touches logs nothing.
+	s.StripResponse(http.Header{"Content-Type": {"text/html"}}, "example.com")
+
+	var entries []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e map[string]any
+		require.NoError(t, dec.Decode(&e))
+		assert.Equal(t, "header rule applied", e["msg"])
+		assert.Equal(t, "example.com", e["domain"])
+		delete(e, "time")
+		delete(e, "level")
+		delete(e, "msg")
+		delete(e, "domain")
+		entries = append(entries, e)
+	}
+	assert.Equal(t, []map[string]any{
+		{"rule": "strip_request_headers", "direction": "request", "removed": []any{"X-Client-Id"}},
+		{"rule": "strip_cookies", "direction": "request", "changed": []any{"Cookie"}, "cookies": []any{"_ga"}},
+		{"rule": "strip_response_headers", "direction": "response", "removed": []any{"Server"}},
+		{"rule": "strip_cookies", "direction": "response", "removed": []any{"Set-Cookie"}, "cookies": []any{"_gads"}},
+	}, entries, "hop-by-hop removal is not a configured rule and is not logged")
+	assert.NotContains(t, buf.String(), "nginx", "header values are never logged")
+}
+
+func TestBufferDeclared(t *testing.T) {
+	short := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Length": {"10"}},
+		ContentLength: 10,
+		Body:          io.NopCloser(io.MultiReader(strings.NewReader("abc"), iotest.ErrReader(io.ErrUnexpectedEOF))),
+	}
+	buffered, err := BufferDeclared(short, 1<<20)
+	assert.True(t, buffered)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Contains(t, err.Error(), "upstream sent 3 of 10 declared bytes")
+	assert.Equal(t, "10", short.Header.Get("Content-Length"), "a truncated body is never re-declared complete")
+
+	exact := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Length": {"3"}},
+		ContentLength: 3,
+		Body:          io.NopCloser(strings.NewReader("abc")),
+	}
+	buffered, err = BufferDeclared(exact, 1<<20)
+	assert.True(t, buffered)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(exact.Body)
+	assert.Equal(t, "abc", string(body))
+
+	head := &http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: 3,
+		Request:       &http.Request{Method: http.MethodHead},
+		Body:          http.NoBody,
+	}
+	buffered, _ = BufferDeclared(head, 1<<20)
+	assert.False(t, buffered, "HEAD responses have no body to check")
+
+	big := &http.Response{StatusCode: http.StatusOK, ContentLength: 100, Body: http.NoBody}
+	buffered, _ = BufferDeclared(big, 10)
+	assert.False(t, buffered, "bodies over the limit stream unchecked")
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	for _, tc := range []struct {
+		connection, upgrade string
+		want                bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, upgrade", "WebSocket", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "h2c", false},
+		{"", "", false},
+	} {
+		h := http.Header{}
+		if tc.connection != "" {
+			h.Set("Connection", tc.connection)
+		}
+		if tc.upgrade != "" {
+			h.Set("Upgrade", tc.upgrade)
+		}
+		assert.Equal(t, tc.want, IsWebSocketUpgrade(h), "%q / %q", tc.connection, tc.upgrade)
+	}
+}
@@ -0,0 +1,47 @@
+package headers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StrictLengthLimit is the largest declared body the strict Content-Length
+// mode buffers; larger responses stream unchecked.
+const StrictLengthLimit = 10 << 20
+
+// BodyExpected reports whether resp carries a body whose size its
+// Content-Length describes: not for HEAD requests or 1xx, 204, and 304
+// responses.
+func BodyExpected(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	s := resp.StatusCode
+	return s >= 200 && s != http.StatusNoContent && s != http.StatusNotModified
+}
+
+// BufferDeclared reads the body of a response that declares a
+// Content-Length of at most limit and replaces it with the buffered bytes,
+// so nothing is relayed until the whole declared body has arrived.
+// buffered reports whether the body was read. If upstream closed short of
+// the declared length, the error wraps io.ErrUnexpectedEOF: the response
+// is truncated and must be aborted, not relayed as complete.
+func BufferDeclared(resp *http.Response, limit int64) (buffered bool, err error) {
+	if !BodyExpected(resp) || resp.ContentLength <= 0 || resp.ContentLength > limit {
+		return false, nil
+	}
+	// The body reader stops at the declared length and reports
+	// io.ErrUnexpectedEOF if the upstream closes short of it.
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err == nil && int64(len(body)) != resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return true, fmt.Errorf("upstream sent %d of %d declared bytes: %w", len(body), resp.ContentLength, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,111 @@
+package headers
+
+import (
+	"context"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// TraceHeader is the response header carrying the proxy's decision trace
+// when debug headers are on.
+const TraceHeader = "X-FPS-Trace"
+
+// Trace records what the proxy did with one request, for the TraceHeader.
+// A trace belongs to a single exchange and is not safe for concurrent use.
+type Trace struct {
+	Path     string   // "http" (plain forward) or "mitm"
+	Blocked  bool     // the domain was blocked
+	Reason   string   // what blocked it: a blocklist stage or "cidr:<range>"
+	Cached   bool     // the response was served from the cache
+	Plugins  []string // plugins that inspected the response, in order
+	Rules    []string // "plugin/rule" for each rule that matched
+	Modified bool     // the response body was filtered
+	Saved    int      // body bytes removed by filtering (negative if grown)
+}
+
+// AddPlugin records that a plugin inspected the response.
+func (t *Trace) AddPlugin(name string) {
+	t.Plugins = append(t.Plugins, name)
+}
+
+// AddRule records a matched plugin rule.
+func (t *Trace) AddRule(plugin, rule string) {
+	t.Rules = append(t.Rules, plugin+"/"+rule)
+}
+
+// String encodes the trace as semicolon-separated key=value pairs, e.g.
+// "path=mitm; blocked=0; plugins=rewrite,reddit-promotions;
+// rules=reddit-promotions/feed-ad; saved=1532". An empty reason, cache for
+// uncached responses, empty lists, and saved for unmodified bodies are
+// omitted.
+func (t *Trace) String() string {
+	blocked := "0"
+	if t.Blocked {
+		blocked = "1"
+	}
+	parts := []string{"path=" + t.Path, "blocked=" + blocked}
+	if t.Reason != "" {
+		parts = append(parts, "reason="+t.Reason)
+	}
+	if t.Cached {
+		parts = append(parts, "cache=hit")
+	}
+	if len(t.Plugins) > 0 {
+		parts = append(parts, "plugins="+strings.Join(t.Plugins, ","))
+	}
+	if len(t.Rules) > 0 {
+		parts = append(parts, "rules="+strings.Join(t.Rules, ","))
+	}
+	if t.Modified {
+		parts = append(parts, "saved="+strconv.Itoa(t.Saved))
+	}
+	return strings.Join(parts, "; ")
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx carrying t.
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFrom returns the trace carried by ctx, or nil when debug headers
+// are off for the request.
+func TraceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Tracer decides which clients get the TraceHeader. A nil *Tracer traces
+// no one.
+type Tracer struct {
+	clients []netip.Prefix
+}
+
+// NewTracer returns a Tracer for clients in the given ranges, or for all
+// clients when clients is empty.
+func NewTracer(clients []netip.Prefix) *Tracer {
+	return &Tracer{clients: clients}
+}
+
+// Enabled reports whether responses to clientIP carry the TraceHeader.
+func (t *Tracer) Enabled(clientIP string) bool {
+	if t == nil {
+		return false
+	}
+	if len(t.clients) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range t.clients {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
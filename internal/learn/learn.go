@@ -0,0 +1,286 @@
+/*
+Package learn records candidate blocklist entries from observed traffic.
+
+In learning mode every allowed request is checked against name heuristics:
+a domain whose labels (or the dash-separated words within them) start with
+a configured pattern such as "ads" or "track" is a candidate. Candidates are
+counted in memory and flushed periodically to a candidates table in SQLite,
+so a starter blocklist can be built from a household's own traffic and
+reviewed at /fps/candidates. Nothing is blocked automatically.
+*/
+package learn
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// DefaultFlushInterval is used when Config.FlushInterval is zero.
+const DefaultFlushInterval = time.Minute
+
+// DefaultPatterns are the name heuristics used when Config.Patterns is
+// empty.
+var DefaultPatterns = []string{"ads", "analytics", "track", "telemetry", "pixel"}
+
+// Config holds learner settings.
+type Config struct {
+	// Patterns are label prefixes that mark a domain as a candidate.
+	Patterns []string
+	// FlushInterval is how often pending counts are written to SQLite.
+	FlushInterval time.Duration
+	// Listed reports whether a domain is already on the blocklist. Listed
+	// domains are left out of Candidates, so promoted entries drop off.
+	// Optional.
+	Listed func(domain string) bool
+}
+
+// Candidate is a domain that matched a heuristic.
+type Candidate struct {
+	Domain    string    `json:"domain"`
+	Pattern   string    `json:"pattern"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Matcher checks domain names against label heuristics.
+type Matcher struct {
+	patterns []string
+}
+
+// NewMatcher creates a Matcher for patterns (lowercased). An empty list
+// uses DefaultPatterns.
+func NewMatcher(patterns []string) *Matcher {
+	if len(patterns) == 0 {
+		patterns = DefaultPatterns
+	}
+	m := &Matcher{patterns: make([]string, 0, len(patterns))}
+	for _, p := range patterns {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	return m
+}
+
+// Match reports the first pattern that starts one of domain's labels, or
+// one of the words in a label split on '-' and '_'. The last label (the
+// TLD) is not checked.
+func (m *Matcher) Match(domain string) (pattern string, ok bool) {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(domain), "."), ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+	for _, label := range labels[:len(labels)-1] {
+		words := strings.FieldsFunc(label, func(r rune) bool { return r == '-' || r == '_' })
+		for _, w := range words {
+			for _, p := range m.patterns {
+				if strings.HasPrefix(w, p) {
+					return p, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+type pendingCount struct {
+	pattern string
+	count   int64
+	last    time.Time
+}
+
+// Learner counts candidate domains and persists them.
+type Learner struct {
+	matcher  *Matcher
+	listed   func(string) bool
+	logger   *slog.Logger
+	interval time.Duration
+	now      func() time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	pendingMu sync.Mutex
+	pending   map[string]*pendingCount
+
+	mu   sync.Mutex // guards conn
+	conn *sqlite.Conn
+}
+
+// Open opens or creates the candidates database at dbPath. Pass ":memory:"
+// for a transient database.
+func Open(dbPath string, cfg Config, logger *slog.Logger) (*Learner, error) {
+	conn, err := sqlite.OpenConn(dbPath, sqlite.OpenReadWrite|sqlite.OpenCreate)
+	if err != nil {
+		return nil, fmt.Errorf("open learn db: %w", err)
+	}
+	err = sqlitex.ExecuteScript(conn, `
+		CREATE TABLE IF NOT EXISTS candidates (
+			domain     TEXT NOT NULL PRIMARY KEY,
+			pattern    TEXT NOT NULL,
+			count      INTEGER NOT NULL DEFAULT 0,
+			first_seen TEXT NOT NULL,
+			last_seen  TEXT NOT NULL
+		) WITHOUT ROWID;
+	`, nil)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("create candidates table: %w", err)
+	}
+
+	l := &Learner{
+		matcher:  NewMatcher(cfg.Patterns),
+		listed:   cfg.Listed,
+		logger:   logger,
+		interval: cfg.FlushInterval,
+		now:      time.Now,
+		done:     make(chan struct{}),
+		pending:  make(map[string]*pendingCount),
+		conn:     conn,
+	}
+	if l.interval <= 0 {
+		l.interval = DefaultFlushInterval
+	}
+	return l, nil
+}
+
+// Start begins the background flush loop.
+func (l *Learner) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	go l.flushLoop(ctx)
+}
+
+// Close stops the flush loop, flushes pending counts, and closes the
+// database.
+func (l *Learner) Close() error {
+	if l.cancel != nil {
+		l.cancel()
+		<-l.done
+	}
+	if err := l.Flush(); err != nil {
+		l.logger.Error("final candidates flush failed", "error", err)
+	}
+	return l.conn.Close()
+}
+
+func (l *Learner) flushLoop(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				l.logger.Error("candidates flush failed", "error", err)
+			}
+		}
+	}
+}
+
+// Record checks domain against the heuristics and counts it if it
+// matches. Call it for allowed requests only.
+func (l *Learner) Record(domain string) {
+	domain = strings.ToLower(domain)
+	pattern, ok := l.matcher.Match(domain)
+	if !ok {
+		return
+	}
+	now := l.now()
+
+	l.pendingMu.Lock()
+	defer l.pendingMu.Unlock()
+	p, ok := l.pending[domain]
+	if !ok {
+		p = &pendingCount{pattern: pattern}
+		l.pending[domain] = p
+	}
+	p.count++
+	p.last = now
+}
+
+// seenLayout is the first_seen/last_seen format: fixed-width UTC, so string
+// comparison orders by time.
+const seenLayout = "2006-01-02T15:04:05Z"
+
+// Flush writes pending counts to the candidates table.
+func (l *Learner) Flush() (err error) {
+	l.pendingMu.Lock()
+	pending := l.pending
+	l.pending = make(map[string]*pendingCount)
+	l.pendingMu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer sqlitex.Save(l.conn)(&err)
+	for domain, p := range pending {
+		seen := p.last.UTC().Format(seenLayout)
+		err = sqlitex.Execute(l.conn, `
+			INSERT INTO candidates (domain, pattern, count, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (domain) DO UPDATE SET
+				pattern   = excluded.pattern,
+				count     = count + excluded.count,
+				last_seen = excluded.last_seen
+		`, &sqlitex.ExecOptions{
+			Args: []any{domain, p.pattern, p.count, seen, seen},
+		})
+		if err != nil {
+			return fmt.Errorf("upsert candidates: %w", err)
+		}
+	}
+	return nil
+}
+
+// Candidates flushes pending counts and returns all recorded candidates
+// not already on the blocklist, most requested first.
+func (l *Learner) Candidates() ([]Candidate, error) {
+	if err := l.Flush(); err != nil {
+		return nil, err
+	}
+
+	out := []Candidate{}
+	l.mu.Lock()
+	err := sqlitex.Execute(l.conn, `
+		SELECT domain, pattern, count, first_seen, last_seen FROM candidates
+		ORDER BY count DESC, domain
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			c := Candidate{
+				Domain:  stmt.ColumnText(0),
+				Pattern: stmt.ColumnText(1),
+				Count:   stmt.ColumnInt64(2),
+			}
+			c.FirstSeen, _ = time.Parse(seenLayout, stmt.ColumnText(3)) //nolint:errcheck // written by Flush
+			c.LastSeen, _ = time.Parse(seenLayout, stmt.ColumnText(4))  //nolint:errcheck // written by Flush
+			out = append(out, c)
+			return nil
+		},
+	})
+	l.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("query candidates: %w", err)
+	}
+
+	if l.listed != nil {
+		kept := out[:0]
+		for _, c := range out {
+			if !l.listed(c.Domain) {
+				kept = append(kept, c)
+			}
+		}
+		out = kept
+	}
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package learn
+
+import (
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher(t *testing.T) {
+	m := NewMatcher(nil)
+	for domain, want := range map[string]string{
+		"ads.example.com":          "ads",
+		"Tracking.Example.com":     "track",
+		"eu-analytics.example.net": "analytics",
+		"cdn.telemetry_v2.example": "telemetry",
+		"pixel.facebook.com.":      "pixel",
+		"adsystem.example.org":     "ads",
+	} {
+		got, ok := m.Match(domain)
+		assert.True(t, ok, domain)
+		assert.Equal(t, want, got, domain)
+	}
+
+	for _, domain := range []string{
+		"www.example.com",
+		"downloads.example.com", // "ads" is not a prefix
+		"example.ads",           // the TLD is not checked
+		"localhost",
+		"readsomething.com",
+	} {
+		_, ok := m.Match(domain)
+		assert.False(t, ok, domain)
+	}
+
+	m = NewMatcher([]string{" Beacon ", ""})
+	got, ok := m.Match("beacons.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "beacon", got)
+	_, ok = m.Match("ads.example.com")
+	assert.False(t, ok, "custom patterns replace the defaults")
+}
+
+func _openTestLearner(t *testing.T, path string, cfg Config) (*Learner, *time.Time) {
+	t.Helper()
+	l, err := Open(path, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	now := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLearnerRecordsCandidates(t *testing.T) {
+	l, now := _openTestLearner(t, ":memory:", Config{
+		Listed: func(domain string) bool { return domain == "pixel.example.com" },
+	})
+	defer l.Close() //nolint:errcheck // test cleanup
+
+	l.Record("www.example.com")
+	l.Record("Ads.Example.com")
+	l.Record("pixel.example.com")
+	require.NoError(t, l.Flush())
+	*now = now.Add(time.Hour)
+	l.Record("ads.example.com")
+	l.Record("metrics-track.example.org")
+
+	got, err := l.Candidates()
+	require.NoError(t, err)
+	assert.Equal(t, []Candidate{
+		{
+			Domain:    "ads.example.com",
+			Pattern:   "ads",
+			Count:     2,
+			FirstSeen: time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC),
+			LastSeen:  time.Date(2026, 2, 1, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			Domain:    "metrics-track.example.org",
+			Pattern:   "track",
+			Count:     1,
+			FirstSeen: time.Date(2026, 2, 1, 13, 0, 0, 0, time.UTC),
+			LastSeen:  time.Date(2026, 2, 1, 13, 0, 0, 0, time.UTC),
+		},
+	}, got, "non-matching and already listed domains are left out")
+}
+
+func TestLearnerPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "learn.db")
+	l, _ := _openTestLearner(t, path, Config{})
+	l.Record("analytics.example.com")
+	require.NoError(t, l.Close(), "close flushes pending counts")
+
+	l, _ = _openTestLearner(t, path, Config{})
+	defer l.Close() //nolint:errcheck // test cleanup
+	l.Record("analytics.example.com")
+	got, err := l.Candidates()
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, int64(2), got[0].Count)
+}
@@ -3,7 +3,9 @@ Package logging configures structured logging with file rotation.
 
 Logs are written to both stderr (text format, for human reading) and a
 rotated JSON log file (for machine parsing and post-hoc analysis).
-The file logger uses lumberjack for size-based rotation.
+The file logger uses lumberjack for size-based rotation (size and backup
+count configurable, 7-day retention). On Unix, logs can
+also be sent to syslog (see syslog_unix.go).
 */
 package logging
 
@@ -20,6 +22,17 @@ import (
 type Config struct {
 	// LogDir is the directory for log files. If empty, file logging is disabled.
 	LogDir string
+	// RotateSizeMB rotates the log file once it exceeds this many megabytes
+	// (default 10).
+	RotateSizeMB int
+	// RotateKeep is the number of rotated files kept (default 3).
+	RotateKeep int
+	// Syslog sends logs to the local syslog daemon (Unix only).
+	Syslog bool
+	// SyslogNetwork and SyslogAddr select a remote syslog endpoint instead of
+	// the local daemon (see syslog.Dial). Empty uses the local daemon.
+	SyslogNetwork string
+	SyslogAddr    string
 	// Verbose enables DEBUG-level logging. Default is INFO.
 	Verbose bool
 	// ExtraHandlers are additional slog.Handlers to include in the fan-out chain
@@ -63,9 +76,9 @@ func Setup(cfg Config) Result {
 		} else {
 			lj := &lumberjack.Logger{
 				Filename:   filepath.Join(cfg.LogDir, "fpsd.log"),
-				MaxSize:    10, // MB per file
-				MaxBackups: 3,  // keep 3 old files
-				MaxAge:     7,  // days to retain
+				MaxSize:    defaultInt(cfg.RotateSizeMB, 10), // MB per file
+				MaxBackups: defaultInt(cfg.RotateKeep, 3),    // old files kept
+				MaxAge:     7,                                // days to retain
 				Compress:   true,
 			}
 
@@ -77,12 +90,32 @@ func Setup(cfg Config) Result {
 		}
 	}
 
+	var syslogCleanup func()
+	if cfg.Syslog {
+		h, closeFn, err := newSyslogHandler(cfg.SyslogNetwork, cfg.SyslogAddr, levelVar)
+		if err != nil {
+			slog.New(stderrHandler).Warn("failed to connect to syslog, syslog logging disabled",
+				"error", err,
+			)
+		} else {
+			handlers = append(handlers, h)
+			syslogCleanup = closeFn
+		}
+	}
+
 	// Add any extra handlers (e.g., logbuf for dashboard).
 	handlers = append(handlers, cfg.ExtraHandlers...)
 
 	if cleanup == nil {
 		cleanup = func() {}
 	}
+	if syslogCleanup != nil {
+		fileCleanup := cleanup
+		cleanup = func() {
+			fileCleanup()
+			syslogCleanup()
+		}
+	}
 
 	multi := &multiHandler{handlers: handlers}
 	return Result{
@@ -92,6 +125,14 @@ func Setup(cfg Config) Result {
 	}
 }
 
+// defaultInt returns v, or def when v is not positive.
+func defaultInt(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
 // multiHandler fans out log records to multiple slog.Handlers.
 type multiHandler struct {
 	handlers []slog.Handler
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup_Rotation(t *testing.T) {
+	dir := t.TempDir()
+
+	// Keep the filler out of the test output; Setup binds os.Stderr.
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer devNull.Close() //nolint:errcheck // test cleanup
+	stderr := os.Stderr
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	res := Setup(Config{LogDir: dir, RotateSizeMB: 1, RotateKeep: 1})
+	defer res.Cleanup()
+
+	// ~3.5 MB of records at 1 MB per file forces several rotations.
+	payload := strings.Repeat("x", 1000)
+	for i := 0; i < 3500; i++ {
+		res.Logger.Info("filler", "i", i, "payload", payload)
+	}
+
+	rotated := func() []string {
+		matches, err := filepath.Glob(filepath.Join(dir, "fpsd-*.log*"))
+		require.NoError(t, err)
+		return matches
+	}
+	// Pruning and compression of old files run in the background.
+	require.Eventually(t, func() bool {
+		m := rotated()
+		return len(m) == 1 && strings.HasSuffix(m[0], ".gz")
+	}, 5*time.Second, 20*time.Millisecond, "want exactly one compressed rotated file, got %v", rotated())
+
+	info, err := os.Stat(filepath.Join(dir, "fpsd.log"))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(1<<20))
+}
@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// newSyslogHandler is unavailable: log/syslog does not support this platform.
+func newSyslogHandler(_, _ string, _ slog.Leveler) (slog.Handler, func(), error) {
+	return nil, nil, errors.New("syslog is not supported on this platform")
+}
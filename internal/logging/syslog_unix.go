@@ -0,0 +1,61 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"log/syslog"
+)
+
+// syslogTag is the program name attached to syslog messages.
+const syslogTag = "fpsd"
+
+// newSyslogHandler returns a handler that sends each record to syslog
+// (facility daemon) as a logfmt line, with the syslog severity mapped from
+// the slog level. An empty network/addr connects to the local daemon.
+func newSyslogHandler(network, addr string, level slog.Leveler) (slog.Handler, func(), error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_DAEMON|syslog.LOG_INFO, syslogTag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := slog.NewTextHandler(&syslogWriter{w: w}, &slog.HandlerOptions{
+		Level: level,
+		// syslog timestamps each message itself.
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return h, func() { _ = w.Close() }, nil
+}
+
+// syslogWriter routes lines from a slog.TextHandler to the syslog severity
+// matching their level. TextHandler performs exactly one Write per record,
+// and with the time attribute removed every line starts with "level=".
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+
+	var err error
+	switch level := bytes.TrimPrefix(p, []byte("level=")); {
+	case bytes.HasPrefix(level, []byte("ERROR")):
+		err = s.w.Err(msg)
+	case bytes.HasPrefix(level, []byte("WARN")):
+		err = s.w.Warning(msg)
+	case bytes.HasPrefix(level, []byte("DEBUG")):
+		err = s.w.Debug(msg)
+	default:
+		err = s.w.Info(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
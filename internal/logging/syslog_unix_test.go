@@ -0,0 +1,51 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup_Syslog(t *testing.T) {
+	// Fake syslog endpoint.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close() //nolint:errcheck // test cleanup
+
+	res := Setup(Config{
+		Syslog:        true,
+		SyslogNetwork: "udp",
+		SyslogAddr:    pc.LocalAddr().String(),
+	})
+	defer res.Cleanup()
+
+	res.Logger.Warn("upstream slow", "domain", "example.com")
+	res.Logger.Debug("not sent") // below INFO
+	res.Logger.Error("dial failed")
+
+	read := func() string {
+		t.Helper()
+		buf := make([]byte, 2048)
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(2*time.Second)))
+		n, _, readErr := pc.ReadFrom(buf)
+		require.NoError(t, readErr)
+		return string(buf[:n])
+	}
+
+	// PRI = facility daemon (3) * 8 + severity.
+	msg := read()
+	assert.True(t, strings.HasPrefix(msg, "<28>"), "warning severity: %q", msg)
+	assert.Contains(t, msg, "fpsd")
+	assert.Contains(t, msg, `msg="upstream slow" domain=example.com`)
+	assert.NotContains(t, msg, "time=")
+
+	msg = read()
+	assert.True(t, strings.HasPrefix(msg, "<27>"), "error severity: %q", msg)
+	assert.Contains(t, msg, `msg="dial failed"`)
+}
@@ -0,0 +1,86 @@
+package mitm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeBlockPage answers a CONNECT to a blocked domain with a readable block
+// page instead of a bare 403, which browsers only show as a generic proxy
+// error. It terminates TLS with a generated certificate for domain, reads
+// one request, and replies 403 with the page. Nothing is sent upstream. The
+// page only loads without a warning if the client trusts the proxy CA.
+//
+// This method takes ownership of clientConn and closes it when done.
+func (i *Interceptor) ServeBlockPage(clientConn net.Conn, domain, clientIP string) {
+	defer func() { _ = clientConn.Close() }()
+
+	leafCert, err := i.certCache.GetCert(domain)
+	if err != nil {
+		i.logger.Error("block page cert generation failed",
+			"domain", domain,
+			"client", clientIP,
+			"error", err,
+		)
+		return
+	}
+
+	clientTLS := tls.Server(clientConn, &tls.Config{
+		Certificates: []tls.Certificate{*leafCert},
+		NextProtos:   []string{"http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	})
+	hsCtx, hsCancel := timeoutCtx(5 * time.Second)
+	defer hsCancel()
+	if err := clientTLS.HandshakeContext(hsCtx); err != nil {
+		i.logger.Debug("block page client TLS handshake failed",
+			"domain", domain,
+			"client", clientIP,
+			"error", err,
+		)
+		return
+	}
+	defer func() { _ = clientTLS.Close() }()
+
+	_ = clientTLS.SetReadDeadline(time.Now().Add(10 * time.Second)) //nolint:errcheck // best-effort
+	req, err := http.ReadRequest(bufio.NewReader(clientTLS))
+	if err != nil {
+		return
+	}
+	_ = i.writeResponse(clientTLS, req, blockPageResponse(req, domain), domain, clientIP) //nolint:errcheck // logged by writeResponse
+}
+
+// blockPageResponse builds the 403 block page for a blocked domain.
+func blockPageResponse(req *http.Request, domain string) *http.Response {
+	body := []byte(fmt.Sprintf(localPage,
+		"Blocked",
+		fmt.Sprintf("<p><code>%s</code> is blocked by the Face Puncher Supreme proxy.</p>",
+			html.EscapeString(domain)),
+	))
+
+	h := make(http.Header)
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        "403 Forbidden",
+		StatusCode:    http.StatusForbidden,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+		Request:       req,
+	}
+}
@@ -0,0 +1,189 @@
+package mitm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+// Device onboarding: the CA bundle (/fps/ca/bundle.pem) is the CA
+// certificate followed by its chain in one PEM file, as MDM tools expect,
+// and the configuration profile (/fps/ca/profile.mobileconfig) installs the
+// same certificates on iOS/iPadOS in one step. The chain is only needed
+// when the configured CA is an intermediate signed by a root of your own.
+
+// LoadChain reads the certificates that chain the CA to its root from a
+// PEM file (mitm.ca_bundle) and keeps them for Bundle, MobileConfig and
+// the leaves' served chains. Every certificate must be a CA, each must
+// sign the one before it (the first signs the CA itself), and the last
+// must be a self-signed root.
+func (ca *CA) LoadChain(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read CA bundle %s: %w", path, err)
+	}
+
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parse CA bundle %s: %w", path, err)
+		}
+		if !cert.IsCA {
+			return fmt.Errorf("CA bundle %s: %q is not a CA certificate", path, cert.Subject.CommonName)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("CA bundle %s: no CERTIFICATE blocks", path)
+	}
+	if err := verifyChain(ca.Cert, chain); err != nil {
+		return fmt.Errorf("CA bundle %s: %w", path, err)
+	}
+
+	ca.Chain = chain
+	return nil
+}
+
+// verifyChain checks that chain links cert to a self-signed root.
+func verifyChain(cert *x509.Certificate, chain []*x509.Certificate) error {
+	child := cert
+	for _, parent := range chain {
+		if err := child.CheckSignatureFrom(parent); err != nil {
+			return fmt.Errorf("%q is not signed by %q: %w", child.Subject.CommonName, parent.Subject.CommonName, err)
+		}
+		child = parent
+	}
+	if !isSelfSigned(child) {
+		return fmt.Errorf("chain ends at %q, which is not a self-signed root", child.Subject.CommonName)
+	}
+	return nil
+}
+
+// leafChain returns the chain a leaf is served with: the leaf, then the
+// CA when it is an intermediate, so clients that trust only the root can
+// still build a path. A self-signed CA is left out, as clients must
+// already trust it.
+func (ca *CA) leafChain(leafDER []byte) [][]byte {
+	if len(ca.Chain) == 0 {
+		return [][]byte{leafDER}
+	}
+	return [][]byte{leafDER, ca.Cert.Raw}
+}
+
+// certs returns the CA certificate followed by its chain.
+func (ca *CA) certs() []*x509.Certificate {
+	return append([]*x509.Certificate{ca.Cert}, ca.Chain...)
+}
+
+// Bundle returns the CA certificate followed by its chain as one PEM file.
+func (ca *CA) Bundle() []byte {
+	var buf bytes.Buffer
+	for _, cert := range ca.certs() {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}) //nolint:errcheck // bytes.Buffer writes cannot fail
+	}
+	return buf.Bytes()
+}
+
+// MobileConfig returns an unsigned iOS/iPadOS configuration profile that
+// installs the CA and its chain. Self-signed certificates go in root
+// payloads, which iOS installs as trust anchors; the rest go in plain
+// certificate payloads. Identifiers are derived from the certificates, so
+// reinstalling the profile for the same CA replaces the old one.
+func (ca *CA) MobileConfig() []byte {
+	p := &plistWriter{}
+	p.raw(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n" +
+		`<plist version="1.0">` + "\n<dict>\n")
+
+	p.raw("<key>PayloadContent</key>\n<array>\n")
+	for i, cert := range ca.certs() {
+		payloadType, desc := "com.apple.security.pkcs1", "Adds an intermediate CA certificate"
+		if isSelfSigned(cert) {
+			payloadType, desc = "com.apple.security.root", "Adds a root CA certificate"
+		}
+		id := payloadUUID(cert.Raw)
+		p.raw("<dict>\n")
+		p.str("PayloadCertificateFileName", fmt.Sprintf("fps-ca-%d.cer", i))
+		p.data("PayloadContent", cert.Raw)
+		p.str("PayloadDescription", desc)
+		p.str("PayloadDisplayName", displayName(cert))
+		p.str("PayloadIdentifier", payloadType+"."+id)
+		p.str("PayloadType", payloadType)
+		p.str("PayloadUUID", id)
+		p.raw("<key>PayloadVersion</key>\n<integer>1</integer>\n")
+		p.raw("</dict>\n")
+	}
+	p.raw("</array>\n")
+
+	id := payloadUUID(append([]byte("profile:"), ca.Cert.Raw...))
+	p.str("PayloadDescription", "Installs the Face Puncher Supreme CA so the proxy can filter HTTPS traffic.")
+	p.str("PayloadDisplayName", displayName(ca.Cert))
+	p.str("PayloadIdentifier", "com.face-puncher-supreme.ca."+id)
+	p.raw("<key>PayloadRemovalDisallowed</key>\n<false/>\n")
+	p.str("PayloadType", "Configuration")
+	p.str("PayloadUUID", id)
+	p.raw("<key>PayloadVersion</key>\n<integer>1</integer>\n")
+	p.raw("</dict>\n</plist>\n")
+	return p.buf.Bytes()
+}
+
+// isSelfSigned reports whether cert is a root: its own issuer, with a
+// signature its own key verifies.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// displayName names a certificate in the profile UI.
+func displayName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return DefaultCACommonName
+}
+
+// payloadUUID derives a stable upper-case UUID (version 8, RFC 9562
+// custom) from b.
+func payloadUUID(b []byte) string {
+	sum := sha256.Sum256(b)
+	sum[6] = sum[6]&0x0f | 0x80
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// plistWriter emits the XML property list subset used by profiles.
+type plistWriter struct {
+	buf bytes.Buffer
+}
+
+func (p *plistWriter) raw(s string) {
+	p.buf.WriteString(s)
+}
+
+func (p *plistWriter) key(k string) {
+	p.buf.WriteString("<key>")
+	_ = xml.EscapeText(&p.buf, []byte(k)) //nolint:errcheck // bytes.Buffer writes cannot fail
+	p.buf.WriteString("</key>\n")
+}
+
+func (p *plistWriter) str(k, v string) {
+	p.key(k)
+	p.buf.WriteString("<string>")
+	_ = xml.EscapeText(&p.buf, []byte(v)) //nolint:errcheck // bytes.Buffer writes cannot fail
+	p.buf.WriteString("</string>\n")
+}
+
+func (p *plistWriter) data(k string, v []byte) {
+	p.key(k)
+	p.buf.WriteString("<data>")
+	p.buf.WriteString(base64.StdEncoding.EncodeToString(v))
+	p.buf.WriteString("</data>\n")
+}
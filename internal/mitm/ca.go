@@ -8,9 +8,11 @@ upstream TLS connection.
 package mitm
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -22,18 +24,82 @@ import (
 )
 
 // CA holds a loaded Certificate Authority certificate and private key.
+// Key is an *ecdsa.PrivateKey (P-256) or an *rsa.PrivateKey.
 type CA struct {
 	Cert        *x509.Certificate
-	Key         *ecdsa.PrivateKey
+	Key         crypto.Signer
 	CertPEM     []byte // Raw PEM bytes for serving at /fps/ca.pem
 	Fingerprint string // SHA-256 fingerprint (hex-encoded, colon-separated)
 	NotAfter    time.Time
+
+	// Chain holds the certificates from Cert up to its root when Cert is
+	// an intermediate (see LoadChain). Empty for a self-signed CA.
+	Chain []*x509.Certificate
+}
+
+// DefaultCACommonName is the CA subject CN used when none is configured.
+const DefaultCACommonName = "Face Puncher Supreme CA"
+
+// Subject holds the CA certificate subject fields. Empty fields are
+// omitted, except CommonName, which defaults to DefaultCACommonName.
+type Subject struct {
+	CommonName         string
+	Organization       string
+	OrganizationalUnit string
+}
+
+// pkixName converts s to a certificate subject.
+func (s Subject) pkixName() pkix.Name {
+	name := pkix.Name{CommonName: s.CommonName}
+	if name.CommonName == "" {
+		name.CommonName = DefaultCACommonName
+	}
+	if s.Organization != "" {
+		name.Organization = []string{s.Organization}
+	}
+	if s.OrganizationalUnit != "" {
+		name.OrganizationalUnit = []string{s.OrganizationalUnit}
+	}
+	return name
+}
+
+// KeyType selects the CA key algorithm.
+type KeyType string
+
+const (
+	// KeyECDSA is an ECDSA P-256 key, the default: small and fast.
+	KeyECDSA KeyType = "ecdsa"
+	// KeyRSA is an RSA key, for clients and trust stores that reject
+	// ECDSA CAs.
+	KeyRSA KeyType = "rsa"
+)
+
+// DefaultRSABits is the RSA CA key size used when none is given.
+const DefaultRSABits = 2048
+
+// CAOptions configures CA generation. The zero value generates an ECDSA
+// CA with the default subject.
+type CAOptions struct {
+	Subject Subject
+	KeyType KeyType // "" = KeyECDSA
+	RSABits int     // KeyRSA only; 0 = DefaultRSABits
 }
 
 // GenerateCA creates a new CA certificate and private key, writing them
 // to certPath and keyPath as PEM files. Returns an error if either file
 // already exists and force is false.
 func GenerateCA(certPath, keyPath string, force bool) error {
+	return GenerateCAWithOptions(certPath, keyPath, CAOptions{}, force)
+}
+
+// GenerateCAWithSubject is GenerateCA with custom subject fields, so the CA
+// is identifiable in trust stores.
+func GenerateCAWithSubject(certPath, keyPath string, subject Subject, force bool) error {
+	return GenerateCAWithOptions(certPath, keyPath, CAOptions{Subject: subject}, force)
+}
+
+// GenerateCAWithOptions is GenerateCA with a custom subject and key type.
+func GenerateCAWithOptions(certPath, keyPath string, opts CAOptions, force bool) error {
 	if !force {
 		if _, err := os.Stat(certPath); err == nil {
 			return fmt.Errorf("CA certificate already exists at %s (use --force to overwrite)", certPath)
@@ -43,9 +109,9 @@ func GenerateCA(certPath, keyPath string, force bool) error {
 		}
 	}
 
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, err := generateCAKey(opts.KeyType, opts.RSABits)
 	if err != nil {
-		return fmt.Errorf("generate CA key: %w", err)
+		return err
 	}
 
 	serial, err := randomSerial()
@@ -55,10 +121,8 @@ func GenerateCA(certPath, keyPath string, force bool) error {
 
 	now := time.Now()
 	template := &x509.Certificate{
-		SerialNumber: serial,
-		Subject: pkix.Name{
-			CommonName: "Face Puncher Supreme CA",
-		},
+		SerialNumber:          serial,
+		Subject:               opts.Subject.pkixName(),
 		NotBefore:             now.Add(-1 * time.Hour), // backdated to avoid clock skew issues
 		NotAfter:              now.Add(10 * 365 * 24 * time.Hour),
 		KeyUsage:              x509.KeyUsageCertSign,
@@ -68,7 +132,7 @@ func GenerateCA(certPath, keyPath string, force bool) error {
 		MaxPathLenZero:        true,
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		return fmt.Errorf("create CA certificate: %w", err)
 	}
@@ -81,11 +145,11 @@ func GenerateCA(certPath, keyPath string, force bool) error {
 	}
 
 	// Write private key PEM with restricted permissions.
-	keyDER, err := x509.MarshalECPrivateKey(key)
+	keyBlock, err := marshalCAKey(key)
 	if err != nil {
 		return fmt.Errorf("marshal CA key: %w", err)
 	}
-	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	keyPEM := pem.EncodeToMemory(keyBlock)
 	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
 		return fmt.Errorf("write CA key: %w", err)
 	}
@@ -120,14 +184,17 @@ func LoadCA(certPath, keyPath string) (*CA, error) {
 	}
 
 	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil || keyBlock.Type != "EC PRIVATE KEY" {
-		return nil, fmt.Errorf("CA key %s: invalid PEM (expected EC PRIVATE KEY block)", keyPath)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("CA key %s: invalid PEM (expected EC, RSA, or PKCS#8 PRIVATE KEY block)", keyPath)
 	}
 
-	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	key, err := parseCAKey(keyBlock)
 	if err != nil {
 		return nil, fmt.Errorf("parse CA key %s: %w", keyPath, err)
 	}
+	if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(key.Public()) {
+		return nil, fmt.Errorf("CA key %s: does not match certificate %s", keyPath, certPath)
+	}
 
 	fingerprint := sha256Fingerprint(cert.Raw)
 
@@ -140,6 +207,78 @@ func LoadCA(certPath, keyPath string) (*CA, error) {
 	}, nil
 }
 
+// generateCAKey creates a CA key of the given type. RSA keys must be 2048
+// or 4096 bits.
+func generateCAKey(kt KeyType, bits int) (crypto.Signer, error) {
+	switch kt {
+	case "", KeyECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate CA key: %w", err)
+		}
+		return key, nil
+	case KeyRSA:
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits != 2048 && bits != 4096 {
+			return nil, fmt.Errorf("generate CA key: RSA key size must be 2048 or 4096, got %d", bits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, fmt.Errorf("generate CA key: %w", err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("generate CA key: unknown key type %q (want ecdsa or rsa)", kt)
+	}
+}
+
+// marshalCAKey encodes a CA key in its traditional PEM form: SEC 1
+// ("EC PRIVATE KEY") or PKCS #1 ("RSA PRIVATE KEY").
+func marshalCAKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// parseCAKey decodes an ECDSA or RSA CA key, detecting the encoding from
+// the PEM block type.
+func parseCAKey(block *pem.Block) (crypto.Signer, error) {
+	var key any
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q (expected EC, RSA, or PKCS#8 PRIVATE KEY)", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T (expected ECDSA or RSA)", key)
+	}
+}
+
 // sha256Fingerprint returns the SHA-256 fingerprint of DER-encoded certificate bytes.
 func sha256Fingerprint(der []byte) string {
 	sum := sha256.Sum256(der)
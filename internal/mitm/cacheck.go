@@ -0,0 +1,96 @@
+package mitm
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// The CA check is a client-side self-test for CA installation. Requesting
+// the check path on any MITM domain (e.g. https://www.reddit.com/fps/ca/check)
+// is answered by the proxy itself, inside the MITM session. The page can only
+// load without a certificate warning if the client trusts the proxy CA, so
+// reaching it is the proof. Requested over plain HTTP (directly against the
+// proxy), the same path returns instructions instead.
+
+// isCACheck reports whether req targets the CA check path.
+func (i *Interceptor) isCACheck(req *http.Request) bool {
+	return i.caCheckPath != "" && req.URL.Path == i.caCheckPath
+}
+
+// caCheckResponse builds the local success response served inside a MITM
+// session. Nothing is sent upstream.
+func caCheckResponse(req *http.Request, domain string) *http.Response {
+	body := []byte(fmt.Sprintf(localPage,
+		"CA installed correctly",
+		fmt.Sprintf("<p>Your browser trusts the Face Puncher Supreme CA: this page was served "+
+			"by the proxy over an intercepted TLS connection to <code>%s</code> "+
+			"without a certificate warning.</p>", html.EscapeString(domain)),
+	))
+
+	h := make(http.Header)
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// ServeCACheckInstructions writes the plain-HTTP instructions page, listing
+// the CA check URL for each configured MITM domain that is actually
+// intercepted (not excluded by NeverIntercept).
+func (i *Interceptor) ServeCACheckInstructions(w http.ResponseWriter, _ *http.Request) {
+	domains := make([]string, 0, len(i.domains))
+	for d := range i.domains {
+		if !i.neverIntercept(d) {
+			domains = append(domains, d)
+		}
+	}
+	slices.Sort(domains)
+
+	var links bytes.Buffer
+	for _, d := range domains {
+		u := html.EscapeString("https://" + d + i.caCheckPath)
+		fmt.Fprintf(&links, "<li><a href=\"%s\">%s</a></li>\n", u, u)
+	}
+
+	body := fmt.Sprintf(localPage,
+		"Check CA installation",
+		"<p>This check must be loaded over HTTPS through the proxy. With your browser "+
+			"configured to use the proxy, open one of these URLs (any MITM domain works):</p>\n"+
+			"<ul>\n"+links.String()+"</ul>\n"+
+			"<p>If the page loads without a certificate warning, the CA is installed correctly. "+
+			"A warning means the CA is missing from the browser's trust store.</p>",
+	)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, body) //nolint:gosec // best-effort response
+}
+
+// localPage is the HTML shell for pages the proxy serves itself (the CA
+// check pages and the block page): title, then body.
+const localPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Face Puncher Supreme: %[1]s</title></head>
+<body>
+<h1>%[1]s</h1>
+%[2]s
+</body>
+</html>
+`
@@ -1,33 +1,46 @@
 package mitm
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"net"
 	"sync"
 	"time"
 )
 
 const (
-	leafValidity     = 24 * time.Hour
-	leafRenewBefore  = 1 * time.Hour // regenerate if less than this remaining
+	leafValidity    = 24 * time.Hour
+	leafRenewBefore = 1 * time.Hour // regenerate if less than this remaining
+	leafBackdate    = 5 * time.Minute
 )
 
-// cachedCert holds a leaf certificate and its expiry time.
+// cachedCert holds a leaf certificate, its creation time, and its expiry time.
 type cachedCert struct {
 	cert      *tls.Certificate
+	createdAt time.Time
 	expiresAt time.Time
 }
 
 // CertCache generates and caches per-domain leaf certificates signed by a CA.
+//
+// With a TTL set, entries older than the TTL are regenerated on next use
+// and evicted by the background sweeper, bounding how long a leaf (and its
+// validity window) is reused. With a directory set (SetDir), leaves are
+// also persisted across restarts.
 type CertCache struct {
 	ca    *CA
 	mu    sync.RWMutex
 	certs map[string]*cachedCert
+	ttl   time.Duration // 0 = reuse until near expiry
+	stop  chan struct{}
+	dir   string // "" = memory only
 }
 
 // NewCertCache creates a certificate cache backed by the given CA.
@@ -38,15 +51,88 @@ func NewCertCache(ca *CA) *CertCache {
 	}
 }
 
+// SetTTL sets the maximum age of cached leaves and starts a sweeper that
+// evicts older entries every ttl/2. Call once, before the cache is used;
+// a non-positive ttl is ignored. Stop the sweeper with Close.
+func (c *CertCache) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.ttl = ttl
+	c.stop = make(chan struct{})
+	go c.sweepLoop(ttl / 2)
+}
+
+// Close stops the background sweeper, if running.
+func (c *CertCache) Close() {
+	if c.stop != nil {
+		close(c.stop)
+	}
+}
+
+// sweepLoop evicts stale entries until Close is called.
+func (c *CertCache) sweepLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.Sweep()
+		}
+	}
+}
+
+// Sweep evicts entries that are past the TTL or near expiry and returns
+// the number evicted.
+func (c *CertCache) Sweep() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evicted := 0
+	for domain, entry := range c.certs {
+		if !c.fresh(entry) {
+			delete(c.certs, domain)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Len returns the number of cached leaves.
+func (c *CertCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.certs)
+}
+
+// Cached reports whether a servable leaf for domain is in the cache.
+func (c *CertCache) Cached(domain string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.certs[domain]
+	return ok && c.fresh(entry)
+}
+
+// fresh reports whether a cached entry can still be served.
+func (c *CertCache) fresh(entry *cachedCert) bool {
+	if time.Until(entry.expiresAt) <= leafRenewBefore {
+		return false
+	}
+	return c.ttl <= 0 || time.Since(entry.createdAt) < c.ttl
+}
+
 // GetCert returns a TLS certificate for the given domain, generating and
-// caching one if needed. Cached certs are reused until near expiry.
+// caching one if needed. Cached certs are reused until near expiry or,
+// with a TTL set, until they are older than the TTL. On a memory miss the
+// on-disk cache, if set, is checked before generating.
 func (c *CertCache) GetCert(domain string) (*tls.Certificate, error) {
 	c.mu.RLock()
-	if entry, ok := c.certs[domain]; ok {
-		if time.Until(entry.expiresAt) > leafRenewBefore {
-			c.mu.RUnlock()
-			return entry.cert, nil
-		}
+	if entry, ok := c.certs[domain]; ok && c.fresh(entry) {
+		c.mu.RUnlock()
+		return entry.cert, nil
 	}
 	c.mu.RUnlock()
 
@@ -55,8 +141,13 @@ func (c *CertCache) GetCert(domain string) (*tls.Certificate, error) {
 	defer c.mu.Unlock()
 
 	// Double-check under write lock.
-	if entry, ok := c.certs[domain]; ok {
-		if time.Until(entry.expiresAt) > leafRenewBefore {
+	if entry, ok := c.certs[domain]; ok && c.fresh(entry) {
+		return entry.cert, nil
+	}
+
+	if c.dir != "" {
+		if entry, ok := c.loadLeaf(domain); ok {
+			c.certs[domain] = entry
 			return entry.cert, nil
 		}
 	}
@@ -66,13 +157,16 @@ func (c *CertCache) GetCert(domain string) (*tls.Certificate, error) {
 		return nil, err
 	}
 
-	c.certs[domain] = &cachedCert{cert: cert, expiresAt: expiresAt}
+	c.certs[domain] = &cachedCert{cert: cert, createdAt: time.Now(), expiresAt: expiresAt}
+	if c.dir != "" {
+		c.saveLeaf(domain, cert)
+	}
 	return cert, nil
 }
 
 // generateLeaf creates a new leaf certificate for the given domain.
 func (c *CertCache) generateLeaf(domain string) (*tls.Certificate, time.Time, error) {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, err := newLeafKey(c.ca.Key)
 	if err != nil {
 		return nil, time.Time{}, fmt.Errorf("generate leaf key for %s: %w", domain, err)
 	}
@@ -91,13 +185,17 @@ func (c *CertCache) generateLeaf(domain string) (*tls.Certificate, time.Time, er
 			CommonName: domain,
 		},
 		DNSNames:    []string{domain},
-		NotBefore:   now.Add(-5 * time.Minute), // small backdate for clock skew
+		NotBefore:   now.Add(-leafBackdate), // small backdate for clock skew
 		NotAfter:    notAfter,
 		KeyUsage:    x509.KeyUsageDigitalSignature,
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment // RSA key exchange
+	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, template, c.ca.Cert, &key.PublicKey, c.ca.Key)
+	// The signature algorithm follows the CA key (ECDSA or RSA with SHA-256).
+	certDER, err := x509.CreateCertificate(rand.Reader, template, c.ca.Cert, key.Public(), c.ca.Key)
 	if err != nil {
 		return nil, time.Time{}, fmt.Errorf("create leaf certificate for %s: %w", domain, err)
 	}
@@ -108,10 +206,76 @@ func (c *CertCache) generateLeaf(domain string) (*tls.Certificate, time.Time, er
 	}
 
 	tlsCert := &tls.Certificate{
-		Certificate: [][]byte{certDER},
+		Certificate: c.ca.leafChain(certDER),
 		PrivateKey:  key,
 		Leaf:        leafCert,
 	}
 
 	return tlsCert, notAfter, nil
 }
+
+// leafRSABits is the RSA leaf key size. Leaves are short-lived, so 2048
+// bits suffices even under a 4096-bit CA and keeps generation fast.
+const leafRSABits = 2048
+
+// newLeafKey generates a leaf key in the same family as the CA key, so a
+// client that accepts the CA's algorithm also accepts the leaf's.
+func newLeafKey(caKey crypto.Signer) (crypto.Signer, error) {
+	if _, ok := caKey.(*rsa.PrivateKey); ok {
+		return rsa.GenerateKey(rand.Reader, leafRSABits)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// serverCertValidity is the lifetime of certificates from NewServerCert.
+// It stays under the 398-day limit browsers enforce for server certs.
+const serverCertValidity = 397 * 24 * time.Hour
+
+// NewServerCert creates a certificate for fpsd's own TLS listeners covering
+// hosts (DNS names or IP addresses). It is signed by ca, or self-signed
+// when ca is nil.
+func NewServerCert(ca *CA, hosts []string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate server key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, fmt.Errorf("generate server serial: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "fpsd"},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(serverCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	parent, signer := template, any(key)
+	if ca != nil {
+		parent, signer = ca.Cert, ca.Key
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("create server certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("parse server certificate: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{certDER},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package mitm
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// On-disk leaf persistence: with a directory set, each generated leaf is
+// written to <dir>/<domain>.pem (certificate and key, mode 0600) and
+// read back on the first request for that domain after a restart, so
+// restarts neither repeat the key generation nor hand clients a different
+// leaf. Files signed by another CA or no longer fresh are regenerated.
+
+// SetDir enables on-disk persistence of leaves in dir, creating it if
+// needed, and removes files whose certificates have expired. Call once,
+// before the cache is used.
+func (c *CertCache) SetDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create cert cache dir %s: %w", dir, err)
+	}
+	c.dir = dir
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("list cert cache dir %s: %w", dir, err)
+	}
+	for _, path := range files {
+		cert, err := readLeaf(path)
+		if err != nil || !time.Now().Before(cert.Leaf.NotAfter) {
+			_ = os.Remove(path) //nolint:errcheck // stale cache file; regenerated on demand
+		}
+	}
+	return nil
+}
+
+// leafPath returns the cache file for domain. The name is path-escaped so
+// an arbitrary SNI value cannot leave the directory.
+func (c *CertCache) leafPath(domain string) string {
+	return filepath.Join(c.dir, url.PathEscape(domain)+".pem")
+}
+
+// loadLeaf returns the persisted leaf for domain if it is still servable
+// and signed by the current CA. Called with c.mu held.
+func (c *CertCache) loadLeaf(domain string) (*cachedCert, bool) {
+	cert, err := readLeaf(c.leafPath(domain))
+	if err != nil {
+		return nil, false
+	}
+	leaf := cert.Leaf
+	if leaf.Subject.CommonName != domain || leaf.CheckSignatureFrom(c.ca.Cert) != nil {
+		return nil, false
+	}
+	cert.Certificate = c.ca.leafChain(cert.Certificate[0])
+	entry := &cachedCert{
+		cert:      cert,
+		createdAt: leaf.NotBefore.Add(leafBackdate),
+		expiresAt: leaf.NotAfter,
+	}
+	return entry, c.fresh(entry)
+}
+
+// saveLeaf persists a generated leaf. Called with c.mu held, so concurrent
+// GetCert calls never write the same file twice. The file is written to a
+// temp name and renamed, so a crash cannot leave a truncated leaf behind.
+// Failures only cost a regeneration after restart and are ignored.
+func (c *CertCache) saveLeaf(domain string, cert *tls.Certificate) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}) //nolint:errcheck // bytes.Buffer writes cannot fail
+	_ = pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})              //nolint:errcheck // bytes.Buffer writes cannot fail
+
+	tmp, err := os.CreateTemp(c.dir, ".leaf-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck // no-op after the rename
+	_, werr := tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); werr != nil || cerr != nil {
+		return
+	}
+	_ = os.Rename(tmp.Name(), c.leafPath(domain)) //nolint:errcheck // best-effort persistence
+}
+
+// readLeaf parses a leaf certificate and key written by saveLeaf.
+func readLeaf(path string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("parse cached leaf %s: %w", path, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("parse cached leaf %s: %w", path, err)
+		}
+	}
+	return &cert, nil
+}
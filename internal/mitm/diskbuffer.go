@@ -0,0 +1,89 @@
+package mitm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spillBody buffers a response body too large for memory in a temp file.
+// head holds the bytes already read from body. Up to limit bytes in total
+// are spilled. If the body fits, the file is mapped into memory and the
+// mapping is returned with a release func that unmaps it. If it does not,
+// replay yields the spilled bytes followed by the rest of body, so the
+// response can still stream through unmodified.
+//
+// The file is unlinked as soon as it is created: the open descriptor and
+// then the mapping keep the data, and nothing is left behind on disk once
+// they are gone, even if the process dies.
+func spillBody(head []byte, body io.ReadCloser, limit int64) (data []byte, release func(), replay io.ReadCloser, err error) {
+	f, err := os.CreateTemp("", "fps-body-*")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("create temp file: %w", err)
+	}
+	_ = os.Remove(f.Name()) //nolint:errcheck // best-effort; the open fd keeps the data
+
+	size, err := f.Write(head)
+	if err == nil {
+		var n int64
+		n, err = io.Copy(f, io.LimitReader(body, limit-int64(size)+1))
+		size += int(n)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, nil, fmt.Errorf("write temp file: %w", err)
+	}
+
+	if int64(size) > limit {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			_ = f.Close()
+			return nil, nil, nil, fmt.Errorf("rewind temp file: %w", err)
+		}
+		return nil, nil, &replayBody{Reader: io.MultiReader(f, body), closers: []io.Closer{f, body}}, nil
+	}
+
+	_ = body.Close()
+	data, err = mapFile(f, size)
+	// The mapping outlives the descriptor.
+	_ = f.Close()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("map temp file: %w", err)
+	}
+	return data, func() { unmapFile(data) }, nil, nil
+}
+
+// replayHead returns a body that yields head followed by the rest of body.
+func replayHead(head []byte, body io.ReadCloser) io.ReadCloser {
+	return &replayBody{Reader: io.MultiReader(bytes.NewReader(head), body), closers: []io.Closer{body}}
+}
+
+// replayBody reads from a chain of readers and closes the underlying ones.
+type replayBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *replayBody) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// releaseBody is a buffered body whose Close frees its backing storage.
+type releaseBody struct {
+	*bytes.Reader
+	release func()
+}
+
+func (r *releaseBody) Close() error {
+	if r.release != nil {
+		r.release()
+		r.release = nil
+	}
+	return nil
+}
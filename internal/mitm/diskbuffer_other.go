@@ -0,0 +1,18 @@
+//go:build !unix
+
+package mitm
+
+import (
+	"errors"
+	"os"
+)
+
+// diskBufferSupported reports whether spilled bodies can be memory-mapped.
+// Without mmap, bodies over the in-memory limit stream through unmodified.
+const diskBufferSupported = false
+
+func mapFile(*os.File, int) ([]byte, error) {
+	return nil, errors.New("disk buffering is not supported on this platform")
+}
+
+func unmapFile([]byte) {}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package mitm
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskBufferSupported reports whether spilled bodies can be memory-mapped.
+const diskBufferSupported = true
+
+// mapFile maps the first size bytes of f copy-on-write, so a modifier
+// that edits its input in place touches private pages, not the file.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE)
+}
+
+// unmapFile releases a mapping made by mapFile.
+func unmapFile(data []byte) {
+	if len(data) > 0 {
+		_ = syscall.Munmap(data) //nolint:errcheck // nothing to do on failure
+	}
+}
@@ -5,23 +5,54 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/upstream"
 )
 
 // Interceptor handles MITM TLS interception for configured domains.
 type Interceptor struct {
 	certCache      *CertCache
 	domains        map[string]struct{}
+	suffixes       []string // from "*.example.com" entries; also cover the base domain
 	logger         *slog.Logger
 	verbose        bool
 	connectTimeout time.Duration
+	headers        *headers.Stripper
+	pipelineDepth  int
+	caCheckPath    string
+	dialer         *upstream.Dialer
+	strictLength   bool
+
+	// neverExact and neverSuffixes are never intercepted, even when listed
+	// in domains. A suffix ("example.com" from "*.example.com") also
+	// covers the base domain.
+	neverExact    map[string]struct{}
+	neverSuffixes []string
+
+	// bufferLimit is the largest body buffered in memory for the
+	// ResponseModifier (0 = maxBufferSize). Bodies up to diskBufferMax
+	// are spilled to disk instead (0 = no disk buffering).
+	bufferLimit   int64
+	diskBufferMax int64
+
+	// tracer selects the clients whose responses carry the decision
+	// trace header (nil = none).
+	tracer *headers.Tracer
 
 	// OnMITMRequest is called for each HTTP request-response cycle through
 	// a MITM session. Parameters: clientIP, domain.
@@ -30,54 +61,239 @@ type Interceptor struct {
 	// InterceptsTotal tracks the total number of MITM'd HTTP requests.
 	InterceptsTotal atomic.Int64
 
+	// Protocol anomaly counters for intercepted connections. HTTP10Requests /
+	// HTTP11Requests count client requests by version, HTTP10Responses counts
+	// upstream responses downgraded to HTTP/1.0, and MalformedRequests counts
+	// client requests that failed to parse.
+	HTTP10Requests    atomic.Int64
+	HTTP11Requests    atomic.Int64
+	HTTP10Responses   atomic.Int64
+	MalformedRequests atomic.Int64
+
+	// ModifierPanics counts recovered modifier panics. A ResponseModifier
+	// panic sends the response unmodified; a RequestModifier panic ends the
+	// session.
+	ModifierPanics atomic.Int64
+
+	// DiskBuffered counts response bodies spilled to disk for the
+	// ResponseModifier.
+	DiskBuffered atomic.Int64
+
+	// BufferSkipped counts response bodies too large to buffer, which
+	// stream through without the ResponseModifier.
+	BufferSkipped atomic.Int64
+
 	// ResponseModifier is called for each MITM'd response if non-nil.
 	// When nil (default), all responses stream through without buffering.
 	ResponseModifier ResponseModifier
+
+	// RequestModifier is called for each MITM'd request before it is
+	// forwarded upstream, if non-nil.
+	RequestModifier RequestModifier
 }
 
 // ResponseModifier may inspect or modify an HTTP response body during MITM.
 // It is called only for text-based Content-Types (text/*, application/json,
 // application/javascript). Binary responses stream through unmodified.
+// It may also change resp.StatusCode and resp.Header; if the new status
+// cannot carry a body (e.g. 204 No Content) the returned body is dropped.
 //
 // If nil, all responses stream through without buffering.
 type ResponseModifier func(domain string, req *http.Request, resp *http.Response, body []byte) ([]byte, error)
 
+// RequestModifier may inspect or modify a client request during MITM,
+// after hop-by-hop and configured headers are stripped and before it is
+// written upstream. It may change the URL, method, and headers. A modifier
+// that replaces req.Body must also set req.ContentLength to the new length
+// (or -1 for chunked) and keep the Content-Length header consistent;
+// req.Write frames the body from ContentLength, not from the header.
+//
+// A non-nil error ends the MITM session without forwarding the request.
+type RequestModifier func(domain string, req *http.Request) error
+
 // InterceptorConfig holds configuration for creating an Interceptor.
 type InterceptorConfig struct {
 	CA             *CA
-	Domains        []string
+	Domains        []string // exact domains or "*.example.com" suffix patterns
 	Logger         *slog.Logger
 	Verbose        bool
 	ConnectTimeout time.Duration
 	OnMITMRequest  func(clientIP, domain string)
+
+	// NeverIntercept lists domains ("bank.example.com") and suffix
+	// patterns ("*.example.com") that are tunneled even when in Domains.
+	NeverIntercept []string
+
+	// MaxBufferSize is the largest response body buffered in memory for
+	// the ResponseModifier. 0 uses the 10MB default.
+	MaxBufferSize int64
+
+	// DiskBufferMax lets bodies larger than the in-memory limit, up to
+	// this many bytes, be spilled to a temp file for the ResponseModifier.
+	// 0 disables disk buffering: larger bodies stream through unmodified.
+	DiskBufferMax int64
+
+	// Extra headers to strip on forward/response (beyond hop-by-hop).
+	StripRequestHeaders  []string
+	StripResponseHeaders []string
+	StripCookies         []*regexp.Regexp // cookie names to strip both ways
+
+	// StrictContentLength buffers unmodified responses that declare a
+	// Content-Length (up to headers.StrictLengthLimit) and ends the session
+	// instead of relaying them when the upstream sends fewer bytes.
+	StrictContentLength bool
+
+	// PipelineDepth is the maximum number of in-flight requests per session.
+	// 0 or 1 uses the sequential request-response loop.
+	PipelineDepth int
+
+	// CertCacheTTL is the maximum age of a cached leaf certificate before
+	// it is regenerated. 0 reuses leaves until near expiry.
+	CertCacheTTL time.Duration
+
+	// CertDir persists generated leaf certificates across restarts. Empty
+	// keeps them in memory only.
+	CertDir string
+
+	// Dialer dials upstream servers. Nil uses the system resolver.
+	Dialer *upstream.Dialer
+
+	// CACheckPath is answered locally in every MITM session as a CA trust
+	// self-test (e.g. "/fps/ca/check"). Empty disables it.
+	CACheckPath string
+
+	// Tracer adds a headers.TraceHeader to responses for the clients it
+	// enables. Nil adds none.
+	Tracer *headers.Tracer
 }
 
 // NewInterceptor creates a MITM interceptor for the given domains.
 func NewInterceptor(cfg *InterceptorConfig) *Interceptor {
 	domains := make(map[string]struct{}, len(cfg.Domains))
+	var suffixes []string
 	for _, d := range cfg.Domains {
-		domains[strings.ToLower(d)] = struct{}{}
+		d = strings.ToLower(d)
+		if suffix, ok := strings.CutPrefix(d, "*."); ok {
+			suffixes = append(suffixes, suffix)
+		} else {
+			domains[d] = struct{}{}
+		}
+	}
+
+	neverExact := make(map[string]struct{})
+	var neverSuffixes []string
+	for _, d := range cfg.NeverIntercept {
+		d = strings.ToLower(d)
+		if suffix, ok := strings.CutPrefix(d, "*."); ok {
+			neverSuffixes = append(neverSuffixes, suffix)
+		} else {
+			neverExact[d] = struct{}{}
+		}
+	}
+
+	certCache := NewCertCache(cfg.CA)
+	certCache.SetTTL(cfg.CertCacheTTL)
+	if cfg.CertDir != "" {
+		if err := certCache.SetDir(cfg.CertDir); err != nil {
+			cfg.Logger.Warn("mitm cert cache not persisted", "error", err)
+		}
+	}
+
+	stripper := headers.NewStripper(cfg.StripRequestHeaders, cfg.StripResponseHeaders, cfg.StripCookies)
+	if cfg.Verbose {
+		stripper.SetLogger(cfg.Logger)
 	}
 
 	return &Interceptor{
-		certCache:      NewCertCache(cfg.CA),
+		certCache:      certCache,
 		domains:        domains,
+		suffixes:       suffixes,
+		neverExact:     neverExact,
+		neverSuffixes:  neverSuffixes,
+		bufferLimit:    cfg.MaxBufferSize,
+		diskBufferMax:  cfg.DiskBufferMax,
 		logger:         cfg.Logger,
 		verbose:        cfg.Verbose,
 		connectTimeout: cfg.ConnectTimeout,
+		headers:        stripper,
+		pipelineDepth:  cfg.PipelineDepth,
+		caCheckPath:    cfg.CACheckPath,
+		dialer:         cfg.Dialer,
+		strictLength:   cfg.StrictContentLength,
+		tracer:         cfg.Tracer,
 		OnMITMRequest:  cfg.OnMITMRequest,
 	}
 }
 
-// IsMITMDomain returns true if the domain is configured for MITM interception.
+// Close releases background resources (the cert cache sweeper).
+func (i *Interceptor) Close() {
+	i.certCache.Close()
+}
+
+// PregenerateCerts fills the cert cache for every configured domain in the
+// background, so the first request to each domain skips leaf generation.
+// Only exact configured names are warmed; names matched by a
+// "*.example.com" pattern get their leaf on first use. The returned
+// channel is closed when generation finishes.
+func (i *Interceptor) PregenerateCerts() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		var failed int
+		for d := range i.domains {
+			if i.neverIntercept(d) {
+				continue
+			}
+			if _, err := i.certCache.GetCert(d); err != nil {
+				failed++
+				i.logger.Warn("mitm cert pregeneration failed", "domain", d, "error", err)
+			}
+		}
+		i.logger.Info("mitm cert cache warmed",
+			"domains", len(i.domains)-failed,
+			"failed", failed,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}()
+	return done
+}
+
+// IsMITMDomain returns true if the domain is configured for MITM
+// interception, exactly or by a suffix pattern, and not excluded by
+// NeverIntercept.
 func (i *Interceptor) IsMITMDomain(domain string) bool {
-	_, ok := i.domains[strings.ToLower(domain)]
-	return ok
+	domain = strings.ToLower(domain)
+	if _, ok := i.domains[domain]; !ok && !matchSuffix(domain, i.suffixes) {
+		return false
+	}
+	return !i.neverIntercept(domain)
+}
+
+// neverIntercept reports whether the lowercased domain matches a
+// NeverIntercept entry.
+func (i *Interceptor) neverIntercept(domain string) bool {
+	if _, ok := i.neverExact[domain]; ok {
+		return true
+	}
+	return matchSuffix(domain, i.neverSuffixes)
 }
 
-// Domains returns the number of configured MITM domains.
+// matchSuffix reports whether domain is, or is a subdomain of, one of
+// suffixes.
+func matchSuffix(domain string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Domains returns the number of configured MITM domains and suffix
+// patterns.
 func (i *Interceptor) Domains() int {
-	return len(i.domains)
+	return len(i.domains) + len(i.suffixes)
 }
 
 // Handle runs a MITM session on an already-hijacked client connection.
@@ -125,7 +341,7 @@ func (i *Interceptor) Handle(clientConn net.Conn, domain, host, clientIP string)
 	defer func() { _ = clientTLS.Close() }()
 
 	// Connect to the real upstream server.
-	upstreamConn, dialErr := net.DialTimeout("tcp", host, i.connectTimeout)
+	upstreamConn, dialErr := i.dialer.DialTimeout("tcp", host, i.connectTimeout)
 	if dialErr != nil {
 		i.logger.Error("mitm upstream dial failed",
 			"domain", domain,
@@ -172,7 +388,14 @@ func (i *Interceptor) Handle(clientConn net.Conn, domain, host, clientIP string)
 // proxyLoop reads HTTP requests from the client and forwards them to the
 // upstream server, then reads responses and forwards them back. Returns
 // the number of request-response cycles completed.
+//
+// With a pipeline depth above 1 the pipelined loop is used instead; the
+// sequential loop is the default.
 func (i *Interceptor) proxyLoop(clientTLS, upstreamTLS *tls.Conn, domain, clientIP string) int {
+	if i.pipelineDepth > 1 {
+		return i.proxyLoopPipelined(clientTLS, upstreamTLS, domain, clientIP)
+	}
+
 	clientReader := bufio.NewReader(clientTLS)
 	upstreamReader := bufio.NewReader(upstreamTLS)
 	requests := 0
@@ -181,173 +404,716 @@ func (i *Interceptor) proxyLoop(clientTLS, upstreamTLS *tls.Conn, domain, client
 		// Read request from client.
 		req, err := http.ReadRequest(clientReader)
 		if err != nil {
-			if err != io.EOF && !isClosedConnErr(err) {
-				i.logger.Debug("mitm client request read failed",
-					"domain", domain,
-					"client", clientIP,
-					"error", err,
-					"requests_completed", requests,
-				)
-			}
+			i.clientReadErr(err, domain, clientIP, requests)
 			break
 		}
+		i.countRequestProto(req, domain, clientIP)
+		req = i.startTrace(req, clientIP)
 
 		reqStart := time.Now()
 
-		// Strip hop-by-hop headers from client request.
-		removeHopByHopHeaders(req.Header)
-
-		// When a ResponseModifier is active, request uncompressed responses
-		// from upstream so the modifier can inspect/modify the raw body.
-		// The browser won't notice because the proxy re-serializes the
-		// response with an accurate Content-Length.
-		if i.ResponseModifier != nil {
-			req.Header.Del("Accept-Encoding")
+		// Answer the CA self-test locally without contacting upstream.
+		if i.isCACheck(req) {
+			if writeErr := i.writeResponse(clientTLS, req, caCheckResponse(req, domain), domain, clientIP); writeErr != nil {
+				break
+			}
+			requests++
+			if req.Close {
+				break
+			}
+			continue
 		}
 
-		// Ensure Host header is set correctly.
-		if req.Host == "" {
-			req.Host = domain
+		if prepErr := i.prepareRequest(req, domain); prepErr != nil {
+			break
 		}
 
 		// Forward request to upstream.
 		if writeErr := req.Write(upstreamTLS); writeErr != nil {
-			i.logger.Error("mitm upstream request write failed",
-				"domain", domain,
-				"client", clientIP,
-				"method", req.Method,
-				"url", req.URL.String(),
-				"error", writeErr,
-			)
+			i.logUpstreamWriteErr(writeErr, req, domain, clientIP)
 			break
 		}
 
 		// Read response from upstream.
 		resp, err := http.ReadResponse(upstreamReader, req)
 		if err != nil {
-			i.logger.Error("mitm upstream response read failed",
-				"domain", domain,
-				"client", clientIP,
-				"method", req.Method,
-				"url", req.URL.String(),
-				"error", err,
-			)
+			i.logUpstreamReadErr(err, req, domain, clientIP)
 			break
 		}
 
-		// Strip hop-by-hop headers from upstream response.
-		removeHopByHopHeaders(resp.Header)
+		i.countResponseProto(resp, domain)
 
-		// If ResponseModifier is set and content is text-based, buffer and modify.
-		if i.ResponseModifier != nil && isTextContent(resp.Header.Get("Content-Type")) {
-			body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxBufferSize+1))
-			_ = resp.Body.Close()
+		// Strip hop-by-hop and configured headers from upstream response.
+		i.headers.StripResponse(resp.Header, domain)
 
+		// If ResponseModifier is set and content is text-based, buffer and
+		// modify. Bodies too large to buffer stream through unmodified.
+		modify := i.shouldModify(resp)
+		var body []byte
+		var release func()
+		if modify {
+			var readErr error
+			body, release, modify, readErr = i.readBody(req, resp, domain)
 			if readErr != nil {
-				i.logger.Error("mitm response body read failed",
-					"domain", domain,
-					"url", req.URL.String(),
-					"error", readErr,
-				)
 				break
 			}
-
-			// Only modify if within size limit.
-			if int64(len(body)) <= maxBufferSize {
-				modified, modErr := i.ResponseModifier(domain, req, resp, body)
-				if modErr != nil {
-					i.logger.Error("mitm response modifier failed",
-						"domain", domain,
-						"url", req.URL.String(),
-						"error", modErr,
-					)
-					break
-				}
-				body = modified
-			}
-
-			// Write modified response with updated Content-Length.
-			resp.Body = io.NopCloser(bytes.NewReader(body))
-			resp.ContentLength = int64(len(body))
-			resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
-			resp.Header.Del("Transfer-Encoding")
-
-			if writeErr := resp.Write(clientTLS); writeErr != nil {
-				if !isClosedConnErr(writeErr) {
-					i.logger.Warn("mitm client response write failed",
-						"domain", domain,
-						"client", clientIP,
-						"method", req.Method,
-						"url", req.URL.String(),
-						"error", writeErr,
-					)
+		}
+		if modify {
+			modified, modErr := i.modifyBody(req, resp, body, domain)
+			if modErr != nil {
+				if release != nil {
+					release()
 				}
 				break
 			}
+			setBody(resp, modified, release)
+		} else if _, lenErr := i.checkDeclaredLength(req, resp, domain); lenErr != nil {
+			break
+		}
+
+		if writeErr := i.writeResponse(clientTLS, req, resp, domain, clientIP); writeErr != nil {
+			break
+		}
+
+		requests++
+		i.recordRequest(req, resp, domain, clientIP, reqStart)
+
+		// Check if either side wants to close the connection.
+		if resp.Close || req.Close {
+			break
+		}
+	}
+
+	return requests
+}
+
+// pipelinedExchange is one request-response cycle in the pipelined loop.
+type pipelinedExchange struct {
+	req      *http.Request
+	reqStart time.Time
+	resp     *http.Response
+	err      error         // modifier failure; ends the session
+	local    bool          // resp was generated by the proxy, not read upstream
+	ready    chan struct{} // closed once resp (and any modified body) is final
+	streamed chan struct{} // closed by the writer once an unbuffered body is consumed
+}
+
+// pipelineConn is the state shared by the three stages of one pipelined
+// session. slots bounds the outstanding requests; inflight carries
+// forwarded requests to the upstream reader and ordered carries their
+// responses to the writer. stop is closed when the writer finishes.
+type pipelineConn struct {
+	clientTLS, upstreamTLS *tls.Conn
+	domain, clientIP       string
+
+	slots    chan struct{}
+	inflight chan *pipelinedExchange
+	ordered  chan *pipelinedExchange
+	stop     chan struct{}
+}
+
+// proxyLoopPipelined is the HTTP/1.1 pipelined variant of proxyLoop. Up to
+// pipelineDepth requests are forwarded upstream before their responses are
+// delivered, and ResponseModifier calls run concurrently, so a slow
+// modification no longer holds back reading the responses behind it.
+// Responses are always written to the client in request order.
+//
+// Three stages run concurrently: client reader (forwards requests upstream),
+// upstream reader (reads responses in order, starts modifiers), and the
+// client writer (this goroutine). Unmodified bodies are streamed, so the
+// upstream reader waits for the writer to consume them before reading the
+// next response.
+func (i *Interceptor) proxyLoopPipelined(clientTLS, upstreamTLS *tls.Conn, domain, clientIP string) int {
+	p := &pipelineConn{
+		clientTLS:   clientTLS,
+		upstreamTLS: upstreamTLS,
+		domain:      domain,
+		clientIP:    clientIP,
+		slots:       make(chan struct{}, i.pipelineDepth),
+		inflight:    make(chan *pipelinedExchange, i.pipelineDepth),
+		ordered:     make(chan *pipelinedExchange, i.pipelineDepth),
+		stop:        make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(p.inflight)
+		i.pipelineForward(p)
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(p.ordered)
+		i.pipelineReadResponses(p)
+	}()
+
+	requests := i.pipelineWrite(p)
+
+	// Unblock the other stages (they may be parked in a read) and wait.
+	close(p.stop)
+	now := time.Now()
+	_ = clientTLS.SetReadDeadline(now)
+	_ = upstreamTLS.SetDeadline(now)
+	go func() {
+		for ex := range p.ordered {
+			<-ex.ready
+			_ = ex.resp.Body.Close()
+		}
+	}()
+	wg.Wait()
+
+	return requests
+}
+
+// pipelineForward is the first pipelined stage: it reads client requests
+// and forwards them upstream (or answers them locally), handing each
+// exchange to the upstream reader in order.
+func (i *Interceptor) pipelineForward(p *pipelineConn) {
+	clientReader := bufio.NewReader(p.clientTLS)
+	for n := 0; ; n++ {
+		req, err := http.ReadRequest(clientReader)
+		if err != nil {
+			i.clientReadErr(err, p.domain, p.clientIP, n)
+			return
+		}
+		i.countRequestProto(req, p.domain, p.clientIP)
+		req = i.startTrace(req, p.clientIP)
+		ex := &pipelinedExchange{req: req, reqStart: time.Now(), ready: make(chan struct{})}
+
+		// Acquire a slot before writing so at most pipelineDepth
+		// requests are outstanding; the writer releases it.
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.stop:
+			return
+		}
+		if i.isCACheck(req) {
+			// Answered locally; the upstream reader passes it through.
+			ex.resp = caCheckResponse(req, p.domain)
+			ex.local = true
+			close(ex.ready)
 		} else {
-			// Stream through unmodified (binary content or no modifier).
-			if writeErr := resp.Write(clientTLS); writeErr != nil {
-				_ = resp.Body.Close()
-				if !isClosedConnErr(writeErr) {
-					i.logger.Warn("mitm client response write failed",
-						"domain", domain,
-						"client", clientIP,
-						"method", req.Method,
-						"url", req.URL.String(),
-						"error", writeErr,
-					)
+			if prepErr := i.prepareRequest(req, p.domain); prepErr != nil {
+				return
+			}
+			if writeErr := req.Write(p.upstreamTLS); writeErr != nil {
+				i.logUpstreamWriteErr(writeErr, req, p.domain, p.clientIP)
+				return
+			}
+		}
+		select {
+		case p.inflight <- ex:
+		case <-p.stop:
+			return
+		}
+		if req.Close {
+			return
+		}
+	}
+}
+
+// pipelineReadResponses is the second pipelined stage: it reads upstream
+// responses in request order, starts their modifiers, and passes each
+// exchange on to the writer.
+func (i *Interceptor) pipelineReadResponses(p *pipelineConn) {
+	upstreamReader := bufio.NewReader(p.upstreamTLS)
+	for ex := range p.inflight {
+		if ex.local {
+			select {
+			case p.ordered <- ex:
+				continue
+			case <-p.stop:
+				return
+			}
+		}
+
+		resp, err := http.ReadResponse(upstreamReader, ex.req)
+		if err != nil {
+			if !isStopped(p.stop) {
+				i.logUpstreamReadErr(err, ex.req, p.domain, p.clientIP)
+			}
+			return
+		}
+		i.countResponseProto(resp, p.domain)
+		i.headers.StripResponse(resp.Header, p.domain)
+		ex.resp = resp
+		closeAfter := resp.Close
+
+		if !i.pipelinePrepareResponse(ex, p.domain) {
+			return
+		}
+
+		select {
+		case p.ordered <- ex:
+		case <-p.stop:
+			// A running modifier replaces the body; close the final one.
+			go func() {
+				<-ex.ready
+				_ = ex.resp.Body.Close()
+			}()
+			return
+		}
+
+		// The next response can't be read until this body is consumed.
+		if ex.streamed != nil {
+			select {
+			case <-ex.streamed:
+			case <-p.stop:
+				return
+			}
+		}
+		if closeAfter {
+			return
+		}
+	}
+}
+
+// pipelinePrepareResponse readies ex.resp for the writer: a modifiable
+// body is read and handed to a modifier goroutine that closes ex.ready
+// when done; other bodies are length-checked and either buffered or marked
+// for streaming. It returns false if the body could not be read.
+func (i *Interceptor) pipelinePrepareResponse(ex *pipelinedExchange, domain string) bool {
+	resp := ex.resp
+	modify := i.shouldModify(resp)
+	var body []byte
+	var release func()
+	if modify {
+		var readErr error
+		body, release, modify, readErr = i.readBody(ex.req, resp, domain)
+		if readErr != nil {
+			return false
+		}
+	}
+	if modify {
+		go func() {
+			defer close(ex.ready)
+			modified, modErr := i.modifyBody(ex.req, resp, body, domain)
+			if modErr != nil {
+				if release != nil {
+					release()
 				}
-				break
+				ex.err = modErr
+				return
 			}
-			_ = resp.Body.Close()
+			setBody(resp, modified, release)
+		}()
+		return true
+	}
+
+	buffered, lenErr := i.checkDeclaredLength(ex.req, resp, domain)
+	switch {
+	case lenErr != nil:
+		ex.err = lenErr
+	case !buffered:
+		ex.streamed = make(chan struct{})
+	}
+	// A buffered body is final now; a streamed one also holds the upstream
+	// reader until the writer has consumed it.
+	close(ex.ready)
+	return true
+}
+
+// pipelineWrite is the third pipelined stage: it writes responses to the
+// client in request order, releasing a slot for each, and returns the
+// number of completed requests.
+func (i *Interceptor) pipelineWrite(p *pipelineConn) int {
+	requests := 0
+	for ex := range p.ordered {
+		<-ex.ready
+		if ex.err != nil {
+			break
+		}
+		writeErr := i.writeResponse(p.clientTLS, ex.req, ex.resp, p.domain, p.clientIP)
+		if ex.streamed != nil {
+			close(ex.streamed)
+		}
+		<-p.slots
+		if writeErr != nil {
+			break
 		}
 
 		requests++
-		i.InterceptsTotal.Add(1)
-		if i.OnMITMRequest != nil {
-			i.OnMITMRequest(clientIP, domain)
+		if !ex.local {
+			i.recordRequest(ex.req, ex.resp, p.domain, p.clientIP, ex.reqStart)
 		}
 
-		if i.verbose {
-			i.logger.Debug("mitm request",
+		if ex.resp.Close || ex.req.Close {
+			break
+		}
+	}
+	return requests
+}
+
+// isStopped reports whether stop has been closed.
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// prepareRequest rewrites a client request before it is forwarded upstream.
+// An error from the RequestModifier is logged and returned.
+func (i *Interceptor) prepareRequest(req *http.Request, domain string) error {
+	// Strip hop-by-hop and configured headers from client request.
+	i.headers.StripRequest(req.Header, domain)
+
+	// When a ResponseModifier is active, request uncompressed responses
+	// from upstream so the modifier can inspect/modify the raw body.
+	// The browser won't notice because the proxy re-serializes the
+	// response with an accurate Content-Length.
+	if i.ResponseModifier != nil {
+		req.Header.Del("Accept-Encoding")
+	}
+
+	// Ensure Host header is set correctly.
+	if req.Host == "" {
+		req.Host = domain
+	}
+
+	if i.RequestModifier == nil {
+		return nil
+	}
+	orig := req.Body
+	if err := i.modifyRequest(req, domain); err != nil {
+		i.logger.Error("mitm request modifier failed",
+			"domain", domain,
+			"url", req.URL.String(),
+			"error", err,
+		)
+		return err
+	}
+	// A modifier that replaced the body leaves the client's bytes unread
+	// on the connection; drain them so the next request parses cleanly.
+	if orig != nil && req.Body != orig {
+		_, _ = io.Copy(io.Discard, orig) //nolint:errcheck // a broken client body surfaces on the next read
+		_ = orig.Close()
+	}
+	return nil
+}
+
+// modifyRequest runs the RequestModifier. In the pipelined loop it runs on
+// the client reader goroutine, so a panic is turned into an error rather
+// than taking down the process.
+func (i *Interceptor) modifyRequest(req *http.Request, domain string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			i.ModifierPanics.Add(1)
+			i.logger.Error("mitm request modifier panicked",
 				"domain", domain,
-				"method", req.Method,
-				"url", req.URL.String(),
-				"status", resp.StatusCode,
-				"content_type", resp.Header.Get("Content-Type"),
-				"content_length", resp.ContentLength,
-				"duration_ms", time.Since(reqStart).Milliseconds(),
+				"panic", v,
+				"stack", string(debug.Stack()),
 			)
+			err = fmt.Errorf("request modifier panic: %v", v)
 		}
+	}()
+	return i.RequestModifier(domain, req)
+}
 
-		// Check if either side wants to close the connection.
-		if resp.Close || req.Close {
-			break
+// shouldModify reports whether resp must be buffered for the ResponseModifier.
+func (i *Interceptor) shouldModify(resp *http.Response) bool {
+	return i.ResponseModifier != nil && isTextContent(resp.Header.Get("Content-Type"))
+}
+
+// readBody buffers the response body for the ResponseModifier and closes
+// it. Bodies up to the in-memory limit are read into memory; larger ones
+// up to diskBufferMax are spilled to a temp file and memory-mapped, and
+// release must be called once the response has been written. ok is false
+// when the body is too large to buffer: resp.Body then yields the whole
+// body, which should stream through unmodified.
+func (i *Interceptor) readBody(req *http.Request, resp *http.Response, domain string) (body []byte, release func(), ok bool, err error) {
+	limit := i.bufferLimit
+	if limit <= 0 {
+		limit = maxBufferSize
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		i.logger.Error("mitm response body read failed",
+			"domain", domain,
+			"url", req.URL.String(),
+			"error", err,
+		)
+		return nil, nil, false, err
+	}
+	if int64(len(body)) <= limit {
+		_ = resp.Body.Close()
+		return body, nil, true, nil
+	}
+	if !diskBufferSupported || i.diskBufferMax <= limit {
+		resp.Body = replayHead(body, resp.Body)
+		i.bufferSkipped(req, domain, limit)
+		return nil, nil, false, nil
+	}
+
+	data, release, replay, err := spillBody(body, resp.Body, i.diskBufferMax)
+	if err != nil {
+		_ = resp.Body.Close()
+		i.logger.Error("mitm response disk buffering failed",
+			"domain", domain,
+			"url", req.URL.String(),
+			"error", err,
+		)
+		return nil, nil, false, err
+	}
+	if replay != nil {
+		resp.Body = replay
+		i.bufferSkipped(req, domain, i.diskBufferMax)
+		return nil, nil, false, nil
+	}
+	i.DiskBuffered.Add(1)
+	if i.verbose {
+		i.logger.Debug("mitm response buffered on disk",
+			"domain", domain,
+			"url", req.URL.String(),
+			"bytes", len(data),
+		)
+	}
+	return data, release, true, nil
+}
+
+// bufferSkipped records a response body over limit that streams through
+// unfiltered. Logged at warn level because plugins silently miss it
+// otherwise; raise mitm.max_buffer_size or enable mitm.disk_buffer.
+func (i *Interceptor) bufferSkipped(req *http.Request, domain string, limit int64) {
+	i.BufferSkipped.Add(1)
+	i.logger.Warn("mitm response too large to filter, streaming unmodified",
+		"domain", domain,
+		"url", req.URL.String(),
+		"limit_bytes", limit,
+	)
+}
+
+// modifyBody runs the ResponseModifier on a buffered body.
+func (i *Interceptor) modifyBody(req *http.Request, resp *http.Response, body []byte, domain string) (modified []byte, err error) {
+	// Pipelined modifications run on their own goroutine, where a panic
+	// would take down the process; forward the body unfiltered instead.
+	defer func() {
+		if v := recover(); v != nil {
+			i.ModifierPanics.Add(1)
+			i.logger.Error("mitm response modifier panicked, forwarding unmodified",
+				"domain", domain,
+				"url", req.URL.String(),
+				"panic", v,
+				"stack", string(debug.Stack()),
+			)
+			modified, err = body, nil
 		}
+	}()
+	declared := resp.Header.Get("Content-Length")
+	modified, err = i.ResponseModifier(domain, req, resp, body)
+	if err != nil {
+		i.logger.Error("mitm response modifier failed",
+			"domain", domain,
+			"url", req.URL.String(),
+			"error", err,
+		)
+		return nil, err
+	}
+	if tr := headers.TraceFrom(req.Context()); tr != nil && !bytes.Equal(body, modified) {
+		tr.Modified = true
+		tr.Saved = len(body) - len(modified)
 	}
+	// setBody fixes Content-Length either way; a modifier that set its own
+	// value and got it wrong has a bug worth surfacing.
+	if cl := resp.Header.Get("Content-Length"); cl != declared && cl != strconv.Itoa(len(modified)) {
+		i.logger.Warn("mitm modifier content-length mismatch, corrected",
+			"domain", domain,
+			"url", req.URL.String(),
+			"declared", cl,
+			"body_bytes", len(modified),
+		)
+	}
+	return modified, nil
+}
 
-	return requests
+// checkDeclaredLength buffers an unmodified response with a declared
+// length in strict Content-Length mode, and is a no-op otherwise. Reports
+// whether the body was buffered, and an error if the upstream sent less
+// than declared: the session is then ended rather than relaying a
+// truncated body as complete.
+func (i *Interceptor) checkDeclaredLength(req *http.Request, resp *http.Response, domain string) (bool, error) {
+	if !i.strictLength {
+		return false, nil
+	}
+	buffered, err := headers.BufferDeclared(resp, headers.StrictLengthLimit)
+	if err != nil {
+		i.logger.Warn("mitm response truncated, aborting",
+			"domain", domain,
+			"url", req.URL.String(),
+			"error", err,
+		)
+	}
+	return buffered, err
+}
+
+// setBody replaces the response body with a buffered one and updates
+// Content-Length to match. release, if non-nil, is called when the new
+// body is closed. If the modifier changed the status to one that cannot
+// carry a body (1xx, 204, 304), the body is dropped.
+func setBody(resp *http.Response, body []byte, release func()) {
+	if !bodyAllowed(resp.StatusCode) {
+		resp.Body = &releaseBody{Reader: bytes.NewReader(nil), release: release}
+		resp.ContentLength = 0
+		resp.Header.Del("Content-Length")
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Transfer-Encoding")
+		return
+	}
+	resp.Body = &releaseBody{Reader: bytes.NewReader(body), release: release}
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Del("Transfer-Encoding")
 }
 
-// hopByHopHeaders are headers that apply to a single transport-level
-// connection and must not be forwarded by proxies.
-var hopByHopHeaders = []string{
-	"Connection",
-	"Keep-Alive",
-	"Proxy-Authenticate",
-	"Proxy-Authorization",
-	"Proxy-Connection",
-	"TE",
-	"Trailers",
-	"Transfer-Encoding",
-	"Upgrade",
+// bodyAllowed reports whether a response with status may include a body
+// (RFC 9110 section 6.4.1).
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
 }
 
-// removeHopByHopHeaders strips hop-by-hop headers from an HTTP header set.
-func removeHopByHopHeaders(h http.Header) {
-	for _, hdr := range hopByHopHeaders {
-		h.Del(hdr)
+// startTrace attaches a decision trace to req when the client gets trace
+// headers, and returns the request to use from then on.
+func (i *Interceptor) startTrace(req *http.Request, clientIP string) *http.Request {
+	if !i.tracer.Enabled(clientIP) {
+		return req
 	}
+	return req.WithContext(headers.WithTrace(req.Context(), &headers.Trace{Path: "mitm"}))
+}
+
+// writeResponse writes resp to the client and closes its body.
+func (i *Interceptor) writeResponse(clientTLS *tls.Conn, req *http.Request, resp *http.Response, domain, clientIP string) error {
+	if tr := headers.TraceFrom(req.Context()); tr != nil {
+		resp.Header.Set(headers.TraceHeader, tr.String())
+	}
+	err := resp.Write(clientTLS)
+	_ = resp.Body.Close()
+	if err != nil && !isClosedConnErr(err) {
+		i.logger.Warn("mitm client response write failed",
+			"domain", domain,
+			"client", clientIP,
+			"method", req.Method,
+			"url", req.URL.String(),
+			"error", err,
+		)
+	}
+	return err
+}
+
+// recordRequest updates counters and logs a completed request-response cycle.
+func (i *Interceptor) recordRequest(req *http.Request, resp *http.Response, domain, clientIP string, reqStart time.Time) {
+	i.InterceptsTotal.Add(1)
+	if i.OnMITMRequest != nil {
+		i.OnMITMRequest(clientIP, domain)
+	}
+
+	if i.verbose {
+		i.logger.Debug("mitm request",
+			"domain", domain,
+			"method", req.Method,
+			"url", req.URL.String(),
+			"status", resp.StatusCode,
+			"content_type", resp.Header.Get("Content-Type"),
+			"content_length", resp.ContentLength,
+			"duration_ms", time.Since(reqStart).Milliseconds(),
+		)
+	}
+}
+
+// clientReadErr classifies a client request read error. Clean closes are
+// ignored, parse failures are counted as malformed and logged as protocol
+// anomalies, and anything else (TLS/transport errors) is logged at debug.
+func (i *Interceptor) clientReadErr(err error, domain, clientIP string, completed int) {
+	if err == io.EOF || isClosedConnErr(err) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return
+	}
+	if isMalformedRequestErr(err) {
+		i.MalformedRequests.Add(1)
+		i.logger.Warn("mitm malformed client request",
+			"domain", domain,
+			"client", clientIP,
+			"error", err,
+			"requests_completed", completed,
+		)
+		return
+	}
+	i.logger.Debug("mitm client request read failed",
+		"domain", domain,
+		"client", clientIP,
+		"error", err,
+		"requests_completed", completed,
+	)
+}
+
+// countRequestProto counts a client request by HTTP version. HTTP/1.0 from
+// a modern client usually means a misbehaving client or a downgrade attempt.
+func (i *Interceptor) countRequestProto(req *http.Request, domain, clientIP string) {
+	switch {
+	case req.ProtoAtLeast(1, 1):
+		i.HTTP11Requests.Add(1)
+	default:
+		i.HTTP10Requests.Add(1)
+		i.logger.Debug("mitm http/1.0 client request",
+			"domain", domain,
+			"client", clientIP,
+			"proto", req.Proto,
+			"url", req.URL.String(),
+		)
+	}
+}
+
+// countResponseProto counts upstream responses downgraded to HTTP/1.0.
+func (i *Interceptor) countResponseProto(resp *http.Response, domain string) {
+	if !resp.ProtoAtLeast(1, 1) {
+		i.HTTP10Responses.Add(1)
+		i.logger.Debug("mitm http/1.0 upstream response",
+			"domain", domain,
+			"proto", resp.Proto,
+		)
+	}
+}
+
+// isMalformedRequestErr reports whether a http.ReadRequest error comes from
+// the request bytes themselves rather than the connection: a bad request
+// line, bad headers, or an unsupported version. A request truncated by the
+// client closing mid-headers also counts. ReadRequest returns either a
+// reader error or a parse error, so anything that is not a transport
+// failure is a parse failure.
+func isMalformedRequestErr(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var recErr tls.RecordHeaderError
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return !errors.As(err, &recErr) && !errors.As(err, &opErr) && !errors.As(err, &dnsErr)
+}
+
+func (i *Interceptor) logUpstreamWriteErr(err error, req *http.Request, domain, clientIP string) {
+	i.logger.Error("mitm upstream request write failed",
+		"domain", domain,
+		"client", clientIP,
+		"method", req.Method,
+		"url", req.URL.String(),
+		"error", err,
+	)
+}
+
+func (i *Interceptor) logUpstreamReadErr(err error, req *http.Request, domain, clientIP string) {
+	i.logger.Error("mitm upstream response read failed",
+		"domain", domain,
+		"client", clientIP,
+		"method", req.Method,
+		"url", req.URL.String(),
+		"error", err,
+	)
 }
 
 // timeoutCtx returns a context with the given timeout and its cancel function.
@@ -356,8 +1122,9 @@ func timeoutCtx(d time.Duration) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), d)
 }
 
-// maxBufferSize is the maximum response body size that will be buffered
-// for plugin inspection. Responses larger than this stream through unmodified.
+// maxBufferSize is the default maximum response body size that will be
+// buffered for plugin inspection (InterceptorConfig.MaxBufferSize overrides
+// it). Responses larger than this stream through unmodified.
 const maxBufferSize = 10 * 1024 * 1024 // 10MB
 
 // isTextContent returns true if the Content-Type is text-based and should
@@ -2,25 +2,40 @@ package mitm
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/pem"
+	"encoding/xml"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
 )
 
 // --- CA tests ---
@@ -86,14 +101,108 @@ func TestLoadCA(t *testing.T) {
 	assert.Equal(t, "Face Puncher Supreme CA", ca.Cert.Subject.CommonName)
 	assert.NotEmpty(t, ca.Fingerprint)
 	assert.NotEmpty(t, ca.CertPEM)
-	assert.IsType(t, &ecdsa.PrivateKey{}, ca.Key)
+	require.IsType(t, &ecdsa.PrivateKey{}, ca.Key)
 
 	// Verify 10-year validity (within a day of tolerance).
 	validYears := ca.NotAfter.Sub(time.Now()).Hours() / 24 / 365
 	assert.InDelta(t, 10.0, validYears, 0.1)
 
 	// Verify key attributes.
-	assert.Equal(t, elliptic.P256(), ca.Key.Curve)
+	key, _ := ca.Key.(*ecdsa.PrivateKey)
+	assert.Equal(t, elliptic.P256(), key.Curve)
+}
+
+func TestGenerateCA_RSA(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca-cert.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+
+	require.NoError(t, GenerateCAWithOptions(certPath, keyPath, CAOptions{KeyType: KeyRSA}, false))
+	keyPEM, err := os.ReadFile(keyPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(keyPEM), "RSA PRIVATE KEY")
+
+	ca, err := LoadCA(certPath, keyPath)
+	require.NoError(t, err)
+	require.IsType(t, &rsa.PrivateKey{}, ca.Key)
+	key, _ := ca.Key.(*rsa.PrivateKey)
+	assert.Equal(t, DefaultRSABits, key.N.BitLen())
+	assert.Equal(t, x509.SHA256WithRSA, ca.Cert.SignatureAlgorithm)
+
+	// Leaves are RSA too, signed with the CA's algorithm, and chain to it.
+	cache := NewCertCache(ca)
+	leaf, err := cache.GetCert("www.example.com")
+	require.NoError(t, err)
+	assert.IsType(t, &rsa.PrivateKey{}, leaf.PrivateKey)
+	assert.Equal(t, x509.SHA256WithRSA, leaf.Leaf.SignatureAlgorithm)
+	assert.NotZero(t, leaf.Leaf.KeyUsage&x509.KeyUsageKeyEncipherment)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	_, err = leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "www.example.com", Roots: pool})
+	require.NoError(t, err)
+
+	err = GenerateCAWithOptions(certPath, keyPath, CAOptions{KeyType: KeyRSA, RSABits: 1024}, true)
+	require.ErrorContains(t, err, "2048 or 4096")
+	err = GenerateCAWithOptions(certPath, keyPath, CAOptions{KeyType: "dsa"}, true)
+	require.ErrorContains(t, err, "unknown key type")
+}
+
+func TestLoadCA_DetectsKeyEncoding(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca-cert.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, GenerateCA(certPath, keyPath, false))
+	ca, err := LoadCA(certPath, keyPath)
+	require.NoError(t, err)
+
+	// A PKCS#8 ("PRIVATE KEY") encoding of the same key loads too.
+	der, err := x509.MarshalPKCS8PrivateKey(ca.Key)
+	require.NoError(t, err)
+	pkcs8Path := filepath.Join(dir, "ca-key-pkcs8.pem")
+	require.NoError(t, os.WriteFile(pkcs8Path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	_, err = LoadCA(certPath, pkcs8Path)
+	require.NoError(t, err)
+
+	// A key that does not belong to the certificate is rejected.
+	otherCert := filepath.Join(dir, "other-cert.pem")
+	otherKey := filepath.Join(dir, "other-key.pem")
+	require.NoError(t, GenerateCAWithOptions(otherCert, otherKey, CAOptions{KeyType: KeyRSA}, false))
+	_, err = LoadCA(certPath, otherKey)
+	require.ErrorContains(t, err, "does not match")
+}
+
+func TestGenerateCAWithSubject(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca-cert.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+
+	subject := Subject{
+		CommonName:         "Example Corp Proxy CA (gw-03)",
+		Organization:       "Example Corp",
+		OrganizationalUnit: "Network Security",
+	}
+	require.NoError(t, GenerateCAWithSubject(certPath, keyPath, subject, false))
+
+	ca, err := LoadCA(certPath, keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, "Example Corp Proxy CA (gw-03)", ca.Cert.Subject.CommonName)
+	assert.Equal(t, []string{"Example Corp"}, ca.Cert.Subject.Organization)
+	assert.Equal(t, []string{"Network Security"}, ca.Cert.Subject.OrganizationalUnit)
+	assert.Equal(t, ca.Cert.Subject.String(), ca.Cert.Issuer.String(), "self-signed")
+}
+
+func TestGenerateCAWithSubjectDefaultsCN(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "ca-cert.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+
+	require.NoError(t, GenerateCAWithSubject(certPath, keyPath, Subject{Organization: "Example Corp"}, false))
+
+	ca, err := LoadCA(certPath, keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCACommonName, ca.Cert.Subject.CommonName)
+	assert.Equal(t, []string{"Example Corp"}, ca.Cert.Subject.Organization)
+	assert.Empty(t, ca.Cert.Subject.OrganizationalUnit)
 }
 
 func TestLoadCA_MissingFile(t *testing.T) {
@@ -101,6 +210,125 @@ func TestLoadCA_MissingFile(t *testing.T) {
 	require.Error(t, err)
 }
 
+// --- CA bundle tests ---
+
+func TestCABundle(t *testing.T) {
+	root := generateTestCA(t)
+	assert.Equal(t, root.CertPEM, root.Bundle(), "a self-signed CA bundles alone")
+
+	// A bring-your-own intermediate with its root from ca_bundle.
+	inter := generateTestIntermediate(t, root)
+	chainPath := filepath.Join(t.TempDir(), "chain.pem")
+	require.NoError(t, os.WriteFile(chainPath, root.CertPEM, 0o600))
+	require.NoError(t, inter.LoadChain(chainPath))
+
+	var got [][]byte
+	rest := inter.Bundle()
+	for block, r := pem.Decode(rest); block != nil; block, r = pem.Decode(r) {
+		assert.Equal(t, "CERTIFICATE", block.Type)
+		got = append(got, block.Bytes)
+	}
+	assert.Equal(t, [][]byte{inter.Cert.Raw, root.Cert.Raw}, got, "CA first, then its root")
+
+	// Leaves under an intermediate carry it, so a client trusting only
+	// the root can build a path.
+	cache := NewCertCache(inter)
+	defer cache.Close()
+	leaf, err := cache.GetCert("example.com")
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{leaf.Certificate[0], inter.Cert.Raw}, leaf.Certificate)
+	require.NoError(t, leaf.Leaf.CheckSignatureFrom(inter.Cert))
+
+	// So do leaves read back from the on-disk cache.
+	dir := t.TempDir()
+	require.NoError(t, cache.SetDir(dir))
+	_, err = cache.GetCert("example.org")
+	require.NoError(t, err)
+	restarted := NewCertCache(inter)
+	defer restarted.Close()
+	require.NoError(t, restarted.SetDir(dir))
+	leaf, err = restarted.GetCert("example.org")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{leaf.Certificate[0], inter.Cert.Raw}, leaf.Certificate)
+
+	rootCache := NewCertCache(root)
+	defer rootCache.Close()
+	leaf, err = rootCache.GetCert("example.com")
+	require.NoError(t, err)
+	assert.Len(t, leaf.Certificate, 1, "a self-signed CA is not sent")
+}
+
+func TestCALoadChainErrors(t *testing.T) {
+	ca := generateTestCA(t)
+	dir := t.TempDir()
+
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("no certs here\n"), 0o600))
+	require.ErrorContains(t, ca.LoadChain(empty), "no CERTIFICATE blocks")
+
+	cache := NewCertCache(ca)
+	defer cache.Close()
+	leaf, err := cache.GetCert("example.com")
+	require.NoError(t, err)
+	notCA := filepath.Join(dir, "leaf.pem")
+	require.NoError(t, os.WriteFile(notCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Certificate[0]}), 0o600))
+	require.ErrorContains(t, ca.LoadChain(notCA), "not a CA certificate")
+
+	require.Error(t, ca.LoadChain(filepath.Join(dir, "missing.pem")))
+	assert.Empty(t, ca.Chain)
+
+	// A bundle must chain the CA to a self-signed root.
+	inter := generateTestIntermediate(t, ca)
+	other := filepath.Join(dir, "other.pem")
+	require.NoError(t, os.WriteFile(other, generateTestCA(t).CertPEM, 0o600))
+	require.ErrorContains(t, inter.LoadChain(other), `"Test Intermediate CA" is not signed by`)
+
+	sub := generateTestIntermediate(t, inter)
+	noRoot := filepath.Join(dir, "noroot.pem")
+	require.NoError(t, os.WriteFile(noRoot, inter.CertPEM, 0o600))
+	require.ErrorContains(t, sub.LoadChain(noRoot), "not a self-signed root")
+	assert.Empty(t, inter.Chain)
+	assert.Empty(t, sub.Chain)
+}
+
+func TestCAMobileConfig(t *testing.T) {
+	root := generateTestCA(t)
+	inter := generateTestIntermediate(t, root)
+	inter.Chain = []*x509.Certificate{root.Cert}
+
+	profile := inter.MobileConfig()
+	assert.Equal(t, profile, inter.MobileConfig(), "identifiers are stable")
+
+	top, ok := parsePlist(t, profile).(map[string]any)
+	require.True(t, ok, "top level is a dict")
+	assert.Equal(t, "Configuration", top["PayloadType"])
+	assert.Equal(t, 1, top["PayloadVersion"])
+	assert.Equal(t, false, top["PayloadRemovalDisallowed"])
+	for _, k := range []string{"PayloadIdentifier", "PayloadUUID", "PayloadDisplayName"} {
+		assert.NotEmpty(t, top[k], k)
+	}
+	assert.Regexp(t, `^[0-9A-F]{8}-[0-9A-F]{4}-8[0-9A-F]{3}-[89AB][0-9A-F]{3}-[0-9A-F]{12}$`, top["PayloadUUID"])
+
+	payloads, ok := top["PayloadContent"].([]any)
+	require.True(t, ok, "PayloadContent is an array")
+	require.Len(t, payloads, 2)
+
+	wantTypes := []string{"com.apple.security.pkcs1", "com.apple.security.root"}
+	wantCerts := [][]byte{inter.Cert.Raw, root.Cert.Raw}
+	uuids := map[any]bool{top["PayloadUUID"]: true}
+	for i, p := range payloads {
+		payload, ok := p.(map[string]any)
+		require.True(t, ok, "payload %d is a dict", i)
+		assert.Equal(t, wantTypes[i], payload["PayloadType"])
+		assert.Equal(t, wantCerts[i], payload["PayloadContent"], "payload %d embeds the certificate", i)
+		assert.Equal(t, 1, payload["PayloadVersion"])
+		id, _ := payload["PayloadUUID"].(string)
+		assert.Equal(t, wantTypes[i]+"."+id, payload["PayloadIdentifier"])
+		uuids[id] = true
+	}
+	assert.Len(t, uuids, 3, "payload UUIDs are distinct")
+}
+
 // --- Cert cache tests ---
 
 func TestCertCache_GetCert(t *testing.T) {
@@ -155,8 +383,152 @@ func TestCertCache_DifferentDomains(t *testing.T) {
 	assert.Equal(t, "old.reddit.com", cert2.Leaf.Subject.CommonName)
 }
 
+func TestCertCache_RegeneratesPastTTL(t *testing.T) {
+	cache := NewCertCache(generateTestCA(t))
+	cache.SetTTL(time.Hour)
+	t.Cleanup(cache.Close)
+
+	cert1, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+
+	// Age the entry past the TTL.
+	cache.mu.Lock()
+	cache.certs["www.reddit.com"].createdAt = time.Now().Add(-2 * time.Hour)
+	cache.mu.Unlock()
+
+	cert2, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.NotSame(t, cert1, cert2)
+	assert.NotEqual(t, cert1.Leaf.SerialNumber, cert2.Leaf.SerialNumber)
+
+	// The fresh entry is cached again.
+	cert3, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.Same(t, cert2, cert3)
+}
+
+func TestCertCache_SweepEvictsStale(t *testing.T) {
+	cache := NewCertCache(generateTestCA(t))
+	cache.SetTTL(time.Hour)
+	t.Cleanup(cache.Close)
+
+	_, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	_, err = cache.GetCert("old.reddit.com")
+	require.NoError(t, err)
+
+	cache.mu.Lock()
+	cache.certs["old.reddit.com"].createdAt = time.Now().Add(-2 * time.Hour)
+	cache.mu.Unlock()
+
+	assert.Equal(t, 1, cache.Sweep())
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestCertCache_NoTTLKeepsEntries(t *testing.T) {
+	cache := NewCertCache(generateTestCA(t))
+
+	cert1, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+
+	cache.mu.Lock()
+	cache.certs["www.reddit.com"].createdAt = time.Now().Add(-48 * time.Hour)
+	cache.mu.Unlock()
+
+	assert.Equal(t, 0, cache.Sweep())
+	cert2, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.Same(t, cert1, cert2)
+}
+
+func TestCertCache_PersistsToDisk(t *testing.T) {
+	ca := generateTestCA(t)
+	dir := filepath.Join(t.TempDir(), "certs")
+
+	cache := NewCertCache(ca)
+	require.NoError(t, cache.SetDir(dir))
+	cert1, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dir, "www.reddit.com.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// A new cache (process restart) reuses the persisted leaf.
+	restarted := NewCertCache(ca)
+	require.NoError(t, restarted.SetDir(dir))
+	cert2, err := restarted.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.Equal(t, cert1.Certificate, cert2.Certificate)
+	assert.True(t, restarted.Cached("www.reddit.com"))
+
+	// A leaf signed by a different CA is regenerated and replaced.
+	other := NewCertCache(generateTestCA(t))
+	require.NoError(t, other.SetDir(dir))
+	cert3, err := other.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, cert1.Leaf.SerialNumber, cert3.Leaf.SerialNumber)
+	persisted, err := readLeaf(filepath.Join(dir, "www.reddit.com.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, cert3.Certificate, persisted.Certificate)
+}
+
+func TestCertCache_DiskRespectsTTL(t *testing.T) {
+	ca := generateTestCA(t)
+	dir := t.TempDir()
+
+	cache := NewCertCache(ca)
+	require.NoError(t, cache.SetDir(dir))
+	cert1, err := cache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+
+	// A TTL shorter than the persisted leaf's age forces regeneration.
+	restarted := NewCertCache(ca)
+	restarted.ttl = time.Nanosecond
+	require.NoError(t, restarted.SetDir(dir))
+	cert2, err := restarted.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, cert1.Leaf.SerialNumber, cert2.Leaf.SerialNumber)
+}
+
+func TestCertCache_SetDirRemovesUnusable(t *testing.T) {
+	dir := t.TempDir()
+	junk := filepath.Join(dir, "bad.example.pem")
+	require.NoError(t, os.WriteFile(junk, []byte("not a leaf\n"), 0o600))
+
+	cache := NewCertCache(generateTestCA(t))
+	require.NoError(t, cache.SetDir(dir))
+	assert.NoFileExists(t, junk)
+
+	// SNI values cannot escape the directory.
+	_, err := cache.GetCert("../escape")
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(dir), "escape.pem"))
+}
+
 // --- Interceptor tests ---
 
+func TestInterceptor_PregenerateCerts(t *testing.T) {
+	i := NewInterceptor(&InterceptorConfig{
+		CA:      generateTestCA(t),
+		Domains: []string{"www.reddit.com", "Old.Reddit.com"},
+		Logger:  slog.Default(),
+	})
+	defer i.Close()
+	assert.Equal(t, 0, i.certCache.Len())
+
+	select {
+	case <-i.PregenerateCerts():
+	case <-time.After(10 * time.Second):
+		t.Fatal("cert pregeneration did not finish")
+	}
+
+	assert.Equal(t, 2, i.certCache.Len())
+	assert.True(t, i.certCache.Cached("www.reddit.com"))
+	assert.True(t, i.certCache.Cached("old.reddit.com"))
+	assert.False(t, i.certCache.Cached("new.reddit.com"))
+}
+
 func TestInterceptor_IsMITMDomain(t *testing.T) {
 	ca := generateTestCA(t)
 	i := NewInterceptor(&InterceptorConfig{
@@ -174,6 +546,56 @@ func TestInterceptor_IsMITMDomain(t *testing.T) {
 	assert.Equal(t, 2, i.Domains())
 }
 
+func TestInterceptor_WildcardDomains(t *testing.T) {
+	i := NewInterceptor(&InterceptorConfig{
+		CA:             generateTestCA(t),
+		Domains:        []string{"*.Reddit.com", "news.example.com"},
+		NeverIntercept: []string{"accounts.reddit.com"},
+		Logger:         slog.Default(),
+		ConnectTimeout: 10 * time.Second,
+	})
+
+	assert.True(t, i.IsMITMDomain("www.reddit.com"))
+	assert.True(t, i.IsMITMDomain("gql-fed.reddit.com"))
+	assert.True(t, i.IsMITMDomain("a.b.reddit.com"))
+	assert.True(t, i.IsMITMDomain("reddit.com"), "suffix pattern covers the base domain")
+	assert.False(t, i.IsMITMDomain("notreddit.com"))
+	assert.False(t, i.IsMITMDomain("accounts.reddit.com"), "never_intercept still wins")
+	assert.True(t, i.IsMITMDomain("news.example.com"))
+	assert.Equal(t, 2, i.Domains())
+
+	// Leaves are minted per concrete name.
+	cert, err := i.certCache.GetCert("www.reddit.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"www.reddit.com"}, cert.Leaf.DNSNames)
+
+	<-i.PregenerateCerts()
+	assert.True(t, i.certCache.Cached("news.example.com"))
+	assert.Equal(t, 2, i.certCache.Len(), "patterns are not pregenerated")
+}
+
+func TestInterceptor_NeverIntercept(t *testing.T) {
+	ca := generateTestCA(t)
+	i := NewInterceptor(&InterceptorConfig{
+		CA:             ca,
+		Domains:        []string{"www.example.com", "login.example.com", "bank.example.org", "api.bank.example.org", "shop.example.net"},
+		NeverIntercept: []string{"Login.Example.com", "*.bank.example.org"},
+		Logger:         slog.Default(),
+		ConnectTimeout: 10 * time.Second,
+	})
+
+	assert.True(t, i.IsMITMDomain("www.example.com"))
+	assert.True(t, i.IsMITMDomain("shop.example.net"))
+	assert.False(t, i.IsMITMDomain("login.example.com"), "exact entry, case insensitive")
+	assert.False(t, i.IsMITMDomain("LOGIN.example.com"))
+	assert.False(t, i.IsMITMDomain("bank.example.org"), "suffix pattern covers the base domain")
+	assert.False(t, i.IsMITMDomain("api.bank.example.org"), "suffix pattern covers subdomains")
+
+	<-i.PregenerateCerts()
+	assert.True(t, i.certCache.Cached("www.example.com"))
+	assert.False(t, i.certCache.Cached("login.example.com"), "never-intercept domains get no leaf cert")
+}
+
 func TestInterceptor_HandleEndToEnd(t *testing.T) {
 	ca := generateTestCA(t)
 
@@ -224,34 +646,23 @@ func TestInterceptor_HandleEndToEnd(t *testing.T) {
 	assert.True(t, i.IsMITMDomain(domain))
 }
 
-func TestInterceptor_MITMProxyLoop(t *testing.T) {
+// startProxyLoop runs interceptor.proxyLoop between a TLS client connection
+// (returned to the caller) and an httptest upstream serving handler. The
+// proxy-side TLS handshakes mirror Handle() but trust the test upstream.
+func startProxyLoop(t *testing.T, interceptor *Interceptor, handler http.Handler) *tls.Conn {
+	t.Helper()
 	ca := generateTestCA(t)
 
-	// Create test HTTPS server.
-	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-Test", "mitm-works")
-		w.WriteHeader(http.StatusOK)
-		_, _ = io.WriteString(w, "upstream response body")
-	}))
-	// Configure upstream with its own TLS cert.
+	upstream := httptest.NewUnstartedServer(handler)
 	upstream.StartTLS()
-	defer upstream.Close()
+	t.Cleanup(upstream.Close)
 
 	upstreamAddr := upstream.Listener.Addr().String()
 	_, port, _ := net.SplitHostPort(upstreamAddr)
 
-	// Build a CA pool that trusts the upstream's cert for the proxy side.
-	upstreamCertPool := x509.NewCertPool()
-	upstreamCertPool.AddCert(upstream.Certificate())
-
 	// Create a net.Pipe to simulate the already-hijacked connection.
 	clientSide, proxySide := net.Pipe()
 
-	var mitmCount atomic.Int64
-	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-
-	// Run the MITM handler in the background. We'll simulate what Handle()
-	// does but with a custom TLS config for the upstream to trust the test server.
 	go func() {
 		defer func() { _ = proxySide.Close() }()
 
@@ -282,7 +693,6 @@ func TestInterceptor_MITMProxyLoop(t *testing.T) {
 		defer func() { _ = upConn.Close() }()
 
 		upTLS := tls.Client(upConn, &tls.Config{
-			RootCAs:    upstreamCertPool,
 			ServerName: "example.com", // httptest uses this
 			MinVersion: tls.VersionTLS12,
 			//nolint:gosec // test only: trust the test server's self-signed cert
@@ -293,14 +703,6 @@ func TestInterceptor_MITMProxyLoop(t *testing.T) {
 			return
 		}
 
-		// Run proxy loop.
-		interceptor := &Interceptor{
-			logger:  logger,
-			verbose: true,
-			OnMITMRequest: func(_, _ string) {
-				mitmCount.Add(1)
-			},
-		}
 		interceptor.proxyLoop(tlsServer, upTLS, "localhost", "127.0.0.1")
 	}()
 
@@ -312,14 +714,34 @@ func TestInterceptor_MITMProxyLoop(t *testing.T) {
 		ServerName: "localhost",
 		MinVersion: tls.VersionTLS12,
 	})
-	err := clientTLS.Handshake()
-	require.NoError(t, err, "client TLS handshake should succeed with our CA")
+	require.NoError(t, clientTLS.Handshake(), "client TLS handshake should succeed with our CA")
+	t.Cleanup(func() { _ = clientTLS.Close() })
+
+	return clientTLS
+}
+
+func TestInterceptor_MITMProxyLoop(t *testing.T) {
+	var mitmCount atomic.Int64
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	interceptor := &Interceptor{
+		logger:  logger,
+		verbose: true,
+		OnMITMRequest: func(_, _ string) {
+			mitmCount.Add(1)
+		},
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "mitm-works")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, "upstream response body")
+	}))
 
 	// Send an HTTP request through the MITM tunnel.
 	req, _ := http.NewRequest(http.MethodGet, "http://localhost/test", http.NoBody)
 	req.Host = "localhost"
 	req.Close = true // signal connection close after this request
-	err = req.Write(clientTLS)
+	err := req.Write(clientTLS)
 	require.NoError(t, err)
 
 	// Read the response.
@@ -341,6 +763,783 @@ func TestInterceptor_MITMProxyLoop(t *testing.T) {
 	assert.Equal(t, int64(1), mitmCount.Load())
 }
 
+func TestInterceptor_StripsConfiguredHeaders(t *testing.T) {
+	interceptor := NewInterceptor(&InterceptorConfig{
+		CA:                   generateTestCA(t),
+		Logger:               slog.New(slog.NewTextHandler(io.Discard, nil)),
+		StripRequestHeaders:  []string{"X-Client-Data"},
+		StripResponseHeaders: []string{"Server", "X-Powered-By"},
+	})
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("X-Client-Data"), "configured request header should be stripped")
+		assert.Empty(t, r.Header.Get("Proxy-Connection"), "hop-by-hop header should be stripped")
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Powered-By", "PHP/8")
+		w.Header().Set("X-Test", "kept")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/test", http.NoBody)
+	req.Host = "localhost"
+	req.Close = true
+	req.Header.Set("X-Client-Data", "tracking")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	require.NoError(t, req.Write(clientTLS))
+
+	resp, err := http.ReadResponse(bufio.NewReader(clientTLS), req)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck // test cleanup, error irrelevant
+
+	assert.Empty(t, resp.Header.Get("Server"))
+	assert.Empty(t, resp.Header.Get("X-Powered-By"))
+	assert.Equal(t, "kept", resp.Header.Get("X-Test"))
+}
+
+func TestInterceptor_SetCookieHeadersPreserved(t *testing.T) {
+	interceptor := NewInterceptor(&InterceptorConfig{
+		CA:           generateTestCA(t),
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		StripCookies: []*regexp.Regexp{regexp.MustCompile(`^_fbp$`)},
+	})
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "session=abc", r.Header.Get("Cookie"), "tracking cookie should be stripped")
+		w.Header().Add("Set-Cookie", "session=abc; Path=/; Secure")
+		w.Header().Add("Set-Cookie", "_fbp=fb.1; Path=/")
+		w.Header().Add("Set-Cookie", "theme=dark; Path=/")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/test", http.NoBody)
+	req.Host = "localhost"
+	req.Close = true
+	req.Header.Set("Cookie", "session=abc; _fbp=fb.1")
+	require.NoError(t, req.Write(clientTLS))
+
+	resp, err := http.ReadResponse(bufio.NewReader(clientTLS), req)
+	require.NoError(t, err)
+	defer resp.Body.Close() //nolint:errcheck // test cleanup, error irrelevant
+
+	assert.Equal(t, []string{
+		"session=abc; Path=/; Secure",
+		"theme=dark; Path=/",
+	}, resp.Header.Values("Set-Cookie"))
+}
+
+// writePipelined writes a GET for each path back-to-back without waiting
+// for responses. Writes run in the background because net.Pipe is
+// unbuffered. The last request asks to close the connection when closeLast
+// is set.
+func writePipelined(clientTLS *tls.Conn, paths []string, closeLast bool) []*http.Request {
+	reqs := make([]*http.Request, len(paths))
+	for n, path := range paths {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost"+path, http.NoBody)
+		req.Host = "localhost"
+		req.Close = closeLast && n == len(paths)-1
+		reqs[n] = req
+	}
+	go func() {
+		for _, req := range reqs {
+			if err := req.Write(clientTLS); err != nil {
+				return
+			}
+		}
+	}()
+	return reqs
+}
+
+// pipelinedRoundTrip pipelines a GET for each path, then reads the responses
+// and returns their bodies in arrival order.
+func pipelinedRoundTrip(t *testing.T, clientTLS *tls.Conn, paths []string) []string {
+	t.Helper()
+	reqs := writePipelined(clientTLS, paths, true)
+
+	br := bufio.NewReader(clientTLS)
+	bodies := make([]string, 0, len(paths))
+	for _, req := range reqs {
+		resp, err := http.ReadResponse(br, req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestInterceptor_PipelinedResponsesInRequestOrder(t *testing.T) {
+	var mitmCount atomic.Int64
+	thirdStarted := make(chan struct{})
+
+	interceptor := &Interceptor{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		pipelineDepth: 4,
+		OnMITMRequest: func(_, _ string) { mitmCount.Add(1) },
+		ResponseModifier: func(_ string, req *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+			switch req.URL.Path {
+			case "/1":
+				// Hold the first modification until the third one has
+				// started; only possible if modifications overlap.
+				select {
+				case <-thirdStarted:
+				case <-time.After(2 * time.Second):
+					return nil, fmt.Errorf("modifiers did not run concurrently")
+				}
+			case "/3":
+				close(thirdStarted)
+			}
+			return append(body, " modified"...), nil
+		},
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/4" {
+			// Binary content streams through without the modifier.
+			w.Header().Set("Content-Type", "application/octet-stream")
+		} else {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		_, _ = io.WriteString(w, "body"+r.URL.Path)
+	}))
+
+	bodies := pipelinedRoundTrip(t, clientTLS, []string{"/1", "/2", "/3", "/4"})
+	assert.Equal(t, []string{
+		"body/1 modified",
+		"body/2 modified",
+		"body/3 modified",
+		"body/4",
+	}, bodies)
+
+	_ = clientTLS.Close()
+	assert.Eventually(t, func() bool { return mitmCount.Load() == 4 }, time.Second, 10*time.Millisecond)
+}
+
+func TestInterceptor_SequentialPipelinedClient(t *testing.T) {
+	// With the default (sequential) loop, a pipelining client still gets
+	// responses in request order.
+	interceptor := &Interceptor{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		ResponseModifier: func(_ string, req *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+			if req.URL.Path == "/1" {
+				time.Sleep(50 * time.Millisecond)
+			}
+			return body, nil
+		},
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "body"+r.URL.Path)
+	}))
+
+	bodies := pipelinedRoundTrip(t, clientTLS, []string{"/1", "/2", "/3"})
+	assert.Equal(t, []string{"body/1", "body/2", "body/3"}, bodies)
+}
+
+func TestInterceptor_PipelinedModifierErrorEndsSession(t *testing.T) {
+	interceptor := &Interceptor{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		pipelineDepth: 4,
+		ResponseModifier: func(_ string, req *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+			if req.URL.Path == "/2" {
+				return nil, fmt.Errorf("boom")
+			}
+			return body, nil
+		},
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "body"+r.URL.Path)
+	}))
+
+	reqs := writePipelined(clientTLS, []string{"/1", "/2", "/3"}, false)
+
+	br := bufio.NewReader(clientTLS)
+	resp, err := http.ReadResponse(br, reqs[0])
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "body/1", string(body))
+
+	// The failed exchange ends the session; nothing after /1 is delivered.
+	_, err = http.ReadResponse(br, reqs[1])
+	assert.Error(t, err)
+}
+
+func TestInterceptor_RequestModifierRewritesBody(t *testing.T) {
+	for _, depth := range []int{1, 4} {
+		t.Run("depth "+strconv.Itoa(depth), func(t *testing.T) {
+			interceptor := &Interceptor{
+				logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				pipelineDepth: depth,
+				RequestModifier: func(domain string, req *http.Request) error {
+					req.Header.Set("X-Modified", domain)
+					if req.URL.Path == "/post" {
+						body := "rewritten"
+						req.Body = io.NopCloser(strings.NewReader(body))
+						req.ContentLength = int64(len(body))
+						req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+					}
+					return nil
+				},
+			}
+			clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = fmt.Fprintf(w, "%s %s", r.Header.Get("X-Modified"), body)
+			}))
+
+			// Keep-alive: the replaced body's bytes must not be parsed as
+			// the next request.
+			post, _ := http.NewRequest(http.MethodPost, "http://localhost/post", strings.NewReader("original body"))
+			next, _ := http.NewRequest(http.MethodGet, "http://localhost/next", http.NoBody)
+			next.Close = true
+			go func() {
+				if post.Write(clientTLS) == nil {
+					_ = next.Write(clientTLS)
+				}
+			}()
+
+			br := bufio.NewReader(clientTLS)
+			for _, tc := range []struct {
+				req  *http.Request
+				want string
+			}{
+				{post, "localhost rewritten"},
+				{next, "localhost "},
+			} {
+				resp, err := http.ReadResponse(br, tc.req)
+				require.NoError(t, err)
+				body, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				assert.Equal(t, tc.want, string(body))
+			}
+		})
+	}
+}
+
+func TestInterceptor_RequestModifierErrorEndsSession(t *testing.T) {
+	var upstreamHits atomic.Int64
+	interceptor := &Interceptor{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		RequestModifier: func(_ string, req *http.Request) error {
+			if req.URL.Path == "/2" {
+				return fmt.Errorf("boom")
+			}
+			return nil
+		},
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "body"+r.URL.Path)
+	}))
+
+	reqs := writePipelined(clientTLS, []string{"/1", "/2", "/3"}, false)
+
+	br := bufio.NewReader(clientTLS)
+	resp, err := http.ReadResponse(br, reqs[0])
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "body/1", string(body))
+
+	// The rejected request is never forwarded and ends the session.
+	_, err = http.ReadResponse(br, reqs[1])
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), upstreamHits.Load())
+}
+
+func TestInterceptor_PipelinedModifierPanicForwardsUnmodified(t *testing.T) {
+	interceptor := &Interceptor{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		pipelineDepth: 4,
+		ResponseModifier: func(_ string, req *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+			if req.URL.Path == "/2" {
+				panic("modifier bug")
+			}
+			return append(body, " modified"...), nil
+		},
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "body"+r.URL.Path)
+	}))
+
+	bodies := pipelinedRoundTrip(t, clientTLS, []string{"/1", "/2", "/3"})
+	assert.Equal(t, []string{"body/1 modified", "body/2", "body/3 modified"}, bodies)
+	assert.Equal(t, int64(1), interceptor.ModifierPanics.Load())
+}
+
+func TestInterceptor_ModifierContentLengthCorrected(t *testing.T) {
+	for _, depth := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pipeline_depth=%d", depth), func(t *testing.T) {
+			var logs bytes.Buffer
+			interceptor := &Interceptor{
+				logger:        slog.New(slog.NewTextHandler(&logs, nil)),
+				pipelineDepth: depth,
+				strictLength:  true,
+				ResponseModifier: func(_ string, _ *http.Request, resp *http.Response, _ []byte) ([]byte, error) {
+					resp.Header.Set("Content-Length", "999") // deliberately wrong
+					return []byte("short"), nil
+				},
+			}
+			clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				_, _ = io.WriteString(w, "the original body")
+			}))
+			require.NoError(t, clientTLS.SetDeadline(time.Now().Add(5*time.Second)))
+
+			req, _ := http.NewRequest(http.MethodGet, "http://localhost/", http.NoBody)
+			req.Host = "localhost"
+			req.Close = true
+			go func() { _ = req.Write(clientTLS) }()
+			resp, err := http.ReadResponse(bufio.NewReader(clientTLS), req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			require.NoError(t, err)
+
+			// The client gets the plugin's body with its real length, and
+			// the plugin bug is logged.
+			assert.Equal(t, "short", string(body))
+			assert.Equal(t, int64(5), resp.ContentLength)
+			assert.Equal(t, "5", resp.Header.Get("Content-Length"))
+			assert.Contains(t, logs.String(), "mitm modifier content-length mismatch, corrected")
+			assert.Contains(t, logs.String(), "declared=999")
+		})
+	}
+}
+
+func TestInterceptor_DiskBuffer(t *testing.T) {
+	for _, depth := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pipeline_depth=%d", depth), func(t *testing.T) {
+			tmp := t.TempDir()
+			t.Setenv("TMPDIR", tmp)
+
+			interceptor := &Interceptor{
+				logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				pipelineDepth: depth,
+				bufferLimit:   1024,
+				diskBufferMax: 64 * 1024,
+				ResponseModifier: func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+					return bytes.ReplaceAll(body, []byte(`"ad":true`), []byte(`"ad":false`)), nil
+				},
+			}
+			clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				items := map[string]int{"/small": 10, "/large": 1000, "/huge": 10000}[r.URL.Path]
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, strings.Repeat(`{"ad":true},`, items))
+			}))
+
+			bodies := pipelinedRoundTrip(t, clientTLS, []string{"/small", "/large", "/huge"})
+			// In memory and on disk (12KB, over the 1KB in-memory limit)
+			// the body is filtered.
+			assert.Equal(t, strings.Repeat(`{"ad":false},`, 10), bodies[0])
+			assert.True(t, bodies[1] == strings.Repeat(`{"ad":false},`, 1000), "disk-buffered body is filtered")
+			// Over the disk cap the whole body streams through unmodified.
+			assert.True(t, bodies[2] == strings.Repeat(`{"ad":true},`, 10000), "oversized body is relayed whole")
+			assert.Equal(t, int64(1), interceptor.DiskBuffered.Load())
+
+			_ = clientTLS.Close()
+			spilled, err := filepath.Glob(filepath.Join(tmp, "fps-body-*"))
+			require.NoError(t, err)
+			assert.Empty(t, spilled, "spilled bodies leave no temp files behind")
+		})
+	}
+}
+
+func TestInterceptor_OversizedBodyStreamsWhole(t *testing.T) {
+	// Without disk buffering a body over the in-memory limit is relayed
+	// in full, not cut at the limit.
+	interceptor := &Interceptor{
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		bufferLimit: 1024,
+		ResponseModifier: func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+			return []byte("modified"), nil
+		},
+	}
+	large := strings.Repeat("x", 4096)
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, large)
+	}))
+
+	bodies := pipelinedRoundTrip(t, clientTLS, []string{"/", "/"})
+	assert.True(t, bodies[0] == large && bodies[1] == large, "bodies relayed whole")
+	assert.Equal(t, int64(2), interceptor.BufferSkipped.Load())
+}
+
+func TestInterceptor_ModifierRewritesStatusToNoContent(t *testing.T) {
+	for _, depth := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pipeline_depth=%d", depth), func(t *testing.T) {
+			interceptor := &Interceptor{
+				logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				pipelineDepth: depth,
+				ResponseModifier: func(_ string, req *http.Request, resp *http.Response, body []byte) ([]byte, error) {
+					if req.URL.Path == "/ad" {
+						resp.StatusCode = http.StatusNoContent
+						resp.Status = "204 No Content"
+						resp.Header.Set("X-Neutralized", "1")
+					}
+					return body, nil
+				},
+			}
+			clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, `{"ad":"buy now"}`+r.URL.Path)
+			}))
+
+			reqs := writePipelined(clientTLS, []string{"/ad", "/feed"}, true)
+			br := bufio.NewReader(clientTLS)
+
+			resp, err := http.ReadResponse(br, reqs[0])
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+			assert.Equal(t, "1", resp.Header.Get("X-Neutralized"))
+			assert.Empty(t, resp.Header.Get("Content-Length"))
+			assert.Empty(t, body)
+
+			// The connection stays in sync for the next response.
+			resp, err = http.ReadResponse(br, reqs[1])
+			require.NoError(t, err)
+			body, _ = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, `{"ad":"buy now"}/feed`, string(body))
+		})
+	}
+}
+
+func TestInterceptor_MaxBufferSizeConfigurable(t *testing.T) {
+	var logs bytes.Buffer
+	interceptor := NewInterceptor(&InterceptorConfig{
+		CA:            generateTestCA(t),
+		Logger:        slog.New(slog.NewTextHandler(&logs, nil)),
+		MaxBufferSize: 1024,
+	})
+	interceptor.ResponseModifier = func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+		return bytes.ToUpper(body), nil
+	}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := map[string]int{"/small": 512, "/large": 2048}[r.URL.Path]
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Repeat("x", size))
+	}))
+
+	bodies := pipelinedRoundTrip(t, clientTLS, []string{"/small", "/large"})
+	assert.True(t, bodies[0] == strings.Repeat("X", 512), "body under the limit is filtered")
+	assert.True(t, bodies[1] == strings.Repeat("x", 2048), "body over the limit streams unmodified")
+	assert.Equal(t, int64(1), interceptor.BufferSkipped.Load())
+	assert.Contains(t, logs.String(), "mitm response too large to filter")
+	assert.Contains(t, logs.String(), "limit_bytes=1024")
+}
+
+func TestInterceptor_StrictContentLengthAbortsShortBody(t *testing.T) {
+	for _, depth := range []int{0, 4} {
+		interceptor := &Interceptor{
+			logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+			pipelineDepth: depth,
+			strictLength:  true,
+		}
+		clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: 100\r\n\r\nshort")
+			_ = buf.Flush()
+		}))
+		require.NoError(t, clientTLS.SetDeadline(time.Now().Add(5*time.Second)))
+
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost/img", http.NoBody)
+		req.Host = "localhost"
+		require.NoError(t, req.Write(clientTLS))
+		// The session ends without a response: a truncated body is never
+		// re-declared complete.
+		_, err := http.ReadResponse(bufio.NewReader(clientTLS), req)
+		require.Error(t, err, "depth %d", depth)
+	}
+}
+
+func TestInterceptor_CACheckServedLocally(t *testing.T) {
+	for _, depth := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pipeline_depth=%d", depth), func(t *testing.T) {
+			var upstreamPaths []string
+			var mu sync.Mutex
+			interceptor := &Interceptor{
+				logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				pipelineDepth: depth,
+				caCheckPath:   "/fps/ca/check",
+			}
+			clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				upstreamPaths = append(upstreamPaths, r.URL.Path)
+				mu.Unlock()
+				_, _ = io.WriteString(w, "upstream"+r.URL.Path)
+			}))
+
+			bodies := pipelinedRoundTrip(t, clientTLS, []string{"/a", "/fps/ca/check", "/b"})
+			require.Len(t, bodies, 3)
+			assert.Equal(t, "upstream/a", bodies[0])
+			assert.Contains(t, bodies[1], "CA installed correctly")
+			assert.Contains(t, bodies[1], "localhost")
+			assert.Equal(t, "upstream/b", bodies[2])
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, []string{"/a", "/b"}, upstreamPaths, "CA check must not reach upstream")
+		})
+	}
+}
+
+func TestInterceptor_CACheckInstructions(t *testing.T) {
+	interceptor := NewInterceptor(&InterceptorConfig{
+		CA:             generateTestCA(t),
+		Domains:        []string{"www.reddit.com", "old.reddit.com", "pay.reddit.com"},
+		NeverIntercept: []string{"pay.reddit.com"},
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		CACheckPath:    "/fps/ca/check",
+	})
+
+	rec := httptest.NewRecorder()
+	interceptor.ServeCACheckInstructions(rec, httptest.NewRequest(http.MethodGet, "/fps/ca/check", http.NoBody))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "https://old.reddit.com/fps/ca/check")
+	assert.Contains(t, body, "https://www.reddit.com/fps/ca/check")
+	assert.Less(t, strings.Index(body, "old.reddit.com"), strings.Index(body, "www.reddit.com"), "domains listed in sorted order")
+	assert.NotContains(t, body, "pay.reddit.com", "never-intercepted domains cannot prove the CA")
+}
+
+func TestInterceptor_CountsMalformedRequest(t *testing.T) {
+	for _, depth := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pipeline_depth=%d", depth), func(t *testing.T) {
+			interceptor := &Interceptor{
+				logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+				pipelineDepth: depth,
+			}
+			clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			_, err := io.WriteString(clientTLS, "NOT AN HTTP REQUEST\r\n\r\n")
+			require.NoError(t, err)
+
+			// The session ends without a response.
+			_, err = http.ReadResponse(bufio.NewReader(clientTLS), nil)
+			assert.Error(t, err)
+			assert.Equal(t, int64(1), interceptor.MalformedRequests.Load())
+			assert.Equal(t, int64(0), interceptor.HTTP11Requests.Load())
+		})
+	}
+}
+
+func TestInterceptor_CountsProtocolVersions(t *testing.T) {
+	interceptor := &Interceptor{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	clientTLS := startProxyLoop(t, interceptor, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// HTTP/1.1 keep-alive request, then an HTTP/1.0 request (closes).
+	_, err := io.WriteString(clientTLS, "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	br := bufio.NewReader(clientTLS)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	_, err = io.WriteString(clientTLS, "GET /b HTTP/1.0\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	resp, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, int64(1), interceptor.HTTP11Requests.Load())
+	assert.Equal(t, int64(1), interceptor.HTTP10Requests.Load())
+	assert.Equal(t, int64(0), interceptor.MalformedRequests.Load())
+}
+
+func TestIsMalformedRequestErr(t *testing.T) {
+	for _, raw := range []string{
+		"GARBAGE\r\n\r\n",
+		"GET / HTTP/x.y\r\n\r\n",
+		"GET / HTTP/1.1\r\nBad Header\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: x",
+	} {
+		_, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		require.Error(t, err, raw)
+		assert.True(t, isMalformedRequestErr(err), "%q: %v", raw, err)
+	}
+
+	_, err := http.ReadRequest(bufio.NewReader(strings.NewReader("")))
+	assert.False(t, isMalformedRequestErr(err), "clean EOF is not malformed")
+
+	for _, err := range []error{
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+		fmt.Errorf("read: %w", os.ErrDeadlineExceeded),
+		net.ErrClosed,
+	} {
+		assert.False(t, isMalformedRequestErr(err), "transport error %v is not malformed", err)
+	}
+}
+
+// --- Response pipeline tests ---
+
+func appendStage(name, suffix string) Stage {
+	return Stage{Name: name, Modify: func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+		return append(body, suffix...), nil
+	}}
+}
+
+func pipelineRequest() (*http.Request, *http.Response) {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/page", http.NoBody)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	return req, resp
+}
+
+func TestNewPipeline_Empty(t *testing.T) {
+	assert.Nil(t, NewPipeline(nil, 0, slog.New(slog.NewTextHandler(io.Discard, nil))))
+}
+
+func TestNewPipeline_StageOrder(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	req, resp := pipelineRequest()
+
+	mod := NewPipeline([]Stage{appendStage("a", "-a"), appendStage("b", "-b"), appendStage("c", "-c")}, 0, logger)
+	out, err := mod("example.com", req, resp, []byte("body"))
+	require.NoError(t, err)
+	assert.Equal(t, "body-a-b-c", string(out))
+
+	mod = NewPipeline([]Stage{appendStage("c", "-c"), appendStage("a", "-a"), appendStage("b", "-b")}, 0, logger)
+	out, err = mod("example.com", req, resp, []byte("body"))
+	require.NoError(t, err)
+	assert.Equal(t, "body-c-a-b", string(out))
+}
+
+func TestNewPipeline_StageErrorFailsOpen(t *testing.T) {
+	failing := Stage{Name: "broken", Modify: func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+		return []byte("garbage"), fmt.Errorf("boom")
+	}}
+	mod := NewPipeline([]Stage{appendStage("a", "-a"), failing, appendStage("b", "-b")}, 0,
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req, resp := pipelineRequest()
+
+	out, err := mod("example.com", req, resp, []byte("body"))
+	require.NoError(t, err)
+	assert.Equal(t, "body-a-b", string(out), "failed stage output is discarded, later stages still run")
+}
+
+func TestNewPipeline_GzipBookends(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			var seen string
+			capture := Stage{Name: "capture", Modify: func(_ string, _ *http.Request, resp *http.Response, body []byte) ([]byte, error) {
+				seen = string(body)
+				assert.Empty(t, resp.Header.Get("Content-Encoding"), "stages see a decoded body")
+				return body, nil
+			}}
+			mod := NewPipeline([]Stage{appendStage("a", "-a"), capture}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			req, resp := pipelineRequest()
+			resp.Header.Set("Content-Encoding", encoding)
+
+			compressed, err := encodeBody(encoding, []byte("body"))
+			require.NoError(t, err)
+			out, err := mod("example.com", req, resp, compressed)
+			require.NoError(t, err)
+
+			assert.Equal(t, "body-a", seen)
+			assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+			plain, err := decodeBody(encoding, out, maxBufferSize)
+			require.NoError(t, err)
+			assert.Equal(t, "body-a", string(plain))
+		})
+	}
+}
+
+func TestNewPipeline_DecodedLimit(t *testing.T) {
+	compressed, err := encodeBody("gzip", bytes.Repeat([]byte("a"), 100))
+	require.NoError(t, err)
+
+	// The configured limit applies, not the 10MB default.
+	mod := NewPipeline([]Stage{appendStage("a", "-a")}, 64, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req, resp := pipelineRequest()
+	resp.Header.Set("Content-Encoding", "gzip")
+	out, err := mod("example.com", req, resp, compressed)
+	require.NoError(t, err)
+	assert.Equal(t, compressed, out, "over-limit body passes through untouched")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	mod = NewPipeline([]Stage{appendStage("a", "-a")}, 128, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req, resp = pipelineRequest()
+	resp.Header.Set("Content-Encoding", "gzip")
+	out, err = mod("example.com", req, resp, compressed)
+	require.NoError(t, err)
+	plain, err := decodeBody("gzip", out, maxBufferSize)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 100)+"-a", string(plain))
+}
+
+func TestNewPipeline_DecodedLimitAboveMemoryBuffer(t *testing.T) {
+	// With disk buffering, a body larger than the in-memory buffer (64)
+	// but under the disk cap (256) is still decoded and transformed.
+	const memoryLimit, diskLimit = 64, 256
+	body := bytes.Repeat([]byte("a"), 100)
+	require.Greater(t, len(body), memoryLimit)
+	compressed, err := encodeBody("gzip", body)
+	require.NoError(t, err)
+
+	mod := NewPipeline([]Stage{appendStage("a", "-a")}, diskLimit, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req, resp := pipelineRequest()
+	resp.Header.Set("Content-Encoding", "gzip")
+	out, err := mod("example.com", req, resp, compressed)
+	require.NoError(t, err)
+	plain, err := decodeBody("gzip", out, maxBufferSize)
+	require.NoError(t, err)
+	assert.Equal(t, string(body)+"-a", string(plain))
+}
+
+func TestNewPipeline_UnchangedBodyKeepsEncoding(t *testing.T) {
+	// A gzip member with a file name, which re-encoding would not reproduce.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = "page.html"
+	_, err := zw.Write([]byte("body"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	compressed := buf.Bytes()
+
+	noop := Stage{Name: "noop", Modify: func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+		return append([]byte(nil), body...), nil
+	}}
+	mod := NewPipeline([]Stage{noop}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req, resp := pipelineRequest()
+	resp.Header.Set("Content-Encoding", "gzip")
+	out, err := mod("example.com", req, resp, compressed)
+	require.NoError(t, err)
+	assert.Equal(t, compressed, out, "original encoded bytes are kept")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+}
+
+func TestNewPipeline_UnknownEncodingPassesThrough(t *testing.T) {
+	called := false
+	stage := Stage{Name: "s", Modify: func(_ string, _ *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+		called = true
+		return body, nil
+	}}
+	mod := NewPipeline([]Stage{stage}, 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req, resp := pipelineRequest()
+	resp.Header.Set("Content-Encoding", "zstd")
+
+	out, err := mod("example.com", req, resp, []byte{0x1, 0x2})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x1, 0x2}, out)
+	assert.False(t, called)
+	assert.Equal(t, "zstd", resp.Header.Get("Content-Encoding"))
+}
+
 // --- Config validation tests ---
 
 func TestValidateMITM_ValidDomains(t *testing.T) {
@@ -386,6 +1585,118 @@ func generateTestCA(t *testing.T) *CA {
 	return ca
 }
 
+// generateTestIntermediate creates an intermediate CA signed by root.
+func generateTestIntermediate(t *testing.T, root *CA) *CA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := randomSerial()
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Test Intermediate CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root.Cert, &key.PublicKey, root.Key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &CA{
+		Cert:     cert,
+		Key:      key,
+		CertPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		NotAfter: cert.NotAfter,
+	}
+}
+
+// parsePlist decodes an XML property list into maps, slices, strings,
+// []byte (data), ints, and bools, failing on anything else.
+func parsePlist(t *testing.T, doc []byte) any {
+	t.Helper()
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var parse func(start xml.StartElement) any
+	parse = func(start xml.StartElement) any {
+		switch start.Name.Local {
+		case "dict":
+			m := map[string]any{}
+			for {
+				tok, err := dec.Token()
+				require.NoError(t, err)
+				if _, ok := tok.(xml.EndElement); ok {
+					return m
+				}
+				keyStart, ok := tok.(xml.StartElement)
+				if !ok {
+					continue
+				}
+				require.Equal(t, "key", keyStart.Name.Local)
+				var key string
+				require.NoError(t, dec.DecodeElement(&key, &keyStart))
+				for {
+					tok, err = dec.Token()
+					require.NoError(t, err)
+					if valStart, ok := tok.(xml.StartElement); ok {
+						require.NotContains(t, m, key, "duplicate key")
+						m[key] = parse(valStart)
+						break
+					}
+				}
+			}
+		case "array":
+			a := []any{}
+			for {
+				tok, err := dec.Token()
+				require.NoError(t, err)
+				if _, ok := tok.(xml.EndElement); ok {
+					return a
+				}
+				if elem, ok := tok.(xml.StartElement); ok {
+					a = append(a, parse(elem))
+				}
+			}
+		case "true", "false":
+			require.NoError(t, dec.Skip())
+			return start.Name.Local == "true"
+		}
+		var text string
+		require.NoError(t, dec.DecodeElement(&text, &start))
+		switch start.Name.Local {
+		case "string":
+			return text
+		case "integer":
+			n, err := strconv.Atoi(text)
+			require.NoError(t, err)
+			return n
+		case "data":
+			b, err := base64.StdEncoding.DecodeString(text)
+			require.NoError(t, err)
+			return b
+		}
+		t.Fatalf("unexpected plist element <%s>", start.Name.Local)
+		return nil
+	}
+
+	for {
+		tok, err := dec.Token()
+		require.NoError(t, err)
+		if start, ok := tok.(xml.StartElement); ok {
+			require.Equal(t, "plist", start.Name.Local)
+			for {
+				tok, err = dec.Token()
+				require.NoError(t, err)
+				if root, ok := tok.(xml.StartElement); ok {
+					return parse(root)
+				}
+			}
+		}
+	}
+}
+
 // MITM type alias for config validation tests (matches config.MITM).
 type MITM = struct {
 	CACert  string   `yaml:"ca_cert"`
@@ -435,3 +1746,51 @@ func TestLeafCertValidPEM(t *testing.T) {
 	pemBlock := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsCert.Certificate[0]})
 	assert.NotEmpty(t, pemBlock)
 }
+
+func TestInterceptor_TraceHeader(t *testing.T) {
+	modifier := func(_ string, req *http.Request, _ *http.Response, body []byte) ([]byte, error) {
+		if tr := headers.TraceFrom(req.Context()); tr != nil {
+			tr.AddPlugin("reddit-promotions")
+			tr.AddRule("reddit-promotions", "feed-ad")
+		}
+		return bytes.ReplaceAll(body, []byte("<ad>buy</ad>"), nil), nil
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<p>post</p><ad>buy</ad>")
+	})
+
+	for _, depth := range []int{0, 4} {
+		t.Run(fmt.Sprintf("pipeline_depth=%d", depth), func(t *testing.T) {
+			interceptor := &Interceptor{
+				logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+				pipelineDepth:    depth,
+				tracer:           headers.NewTracer([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}),
+				ResponseModifier: modifier,
+			}
+			clientTLS := startProxyLoop(t, interceptor, handler)
+
+			reqs := writePipelined(clientTLS, []string{"/"}, true)
+			resp, err := http.ReadResponse(bufio.NewReader(clientTLS), reqs[0])
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			assert.Equal(t, "<p>post</p>", string(body))
+			assert.Equal(t, "path=mitm; blocked=0; plugins=reddit-promotions; rules=reddit-promotions/feed-ad; saved=12",
+				resp.Header.Get(headers.TraceHeader))
+		})
+	}
+
+	// Clients outside the configured ranges get no trace.
+	interceptor := &Interceptor{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		tracer:           headers.NewTracer([]netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}),
+		ResponseModifier: modifier,
+	}
+	clientTLS := startProxyLoop(t, interceptor, handler)
+	reqs := writePipelined(clientTLS, []string{"/"}, true)
+	resp, err := http.ReadResponse(bufio.NewReader(clientTLS), reqs[0])
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, resp.Header.Get(headers.TraceHeader))
+}
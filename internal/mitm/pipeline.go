@@ -0,0 +1,149 @@
+package mitm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Stage is one named step of the response transformation pipeline.
+type Stage struct {
+	Name   string
+	Modify ResponseModifier
+}
+
+// NewPipeline assembles stages into a ResponseModifier that runs them in
+// order, each receiving the previous stage's output. Two fixed bookends
+// wrap the stages: the body is decoded first (Content-Encoding gzip,
+// deflate, or br, for upstreams that compress despite the stripped
+// Accept-Encoding) and re-encoded last, so stages always see plain text.
+// A body the stages leave unchanged keeps its original encoded bytes.
+// maxDecoded caps the decoded body size (0 = the 10MB default); it should
+// match the largest body the interceptor buffers, which is DiskBufferMax
+// when disk buffering is on and MaxBufferSize otherwise.
+//
+// Stages fail open: a stage that returns an error is logged and skipped,
+// and the next stage gets the body as it was before the failed one. A body
+// in an encoding that cannot be decoded, or that decodes to more than
+// maxDecoded bytes, passes through untouched. Returns
+// nil if there are no stages.
+func NewPipeline(stages []Stage, maxDecoded int64, logger *slog.Logger) ResponseModifier {
+	if len(stages) == 0 {
+		return nil
+	}
+	if maxDecoded <= 0 {
+		maxDecoded = maxBufferSize
+	}
+	return func(domain string, req *http.Request, resp *http.Response, body []byte) ([]byte, error) {
+		encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+		decoded, err := decodeBody(encoding, body, maxDecoded)
+		if err != nil {
+			logger.Warn("mitm response not transformed",
+				"domain", domain,
+				"url", req.URL.String(),
+				"content_encoding", encoding,
+				"error", err,
+			)
+			return body, nil
+		}
+		if encoding != "" {
+			resp.Header.Del("Content-Encoding")
+		}
+
+		current := decoded
+		for _, st := range stages {
+			out, stageErr := st.Modify(domain, req, resp, current)
+			if stageErr != nil {
+				logger.Error("mitm response stage failed, skipping",
+					"stage", st.Name,
+					"domain", domain,
+					"url", req.URL.String(),
+					"error", stageErr,
+				)
+				continue
+			}
+			current = out
+		}
+
+		if encoding == "" {
+			return current, nil
+		}
+		if bytes.Equal(current, decoded) {
+			resp.Header.Set("Content-Encoding", encoding)
+			return body, nil
+		}
+		encoded, err := encodeBody(encoding, current)
+		if err != nil {
+			// Cannot happen for in-memory writers; send the plain body
+			// rather than a mislabeled one.
+			logger.Error("mitm response re-encode failed", "domain", domain, "error", err)
+			return current, nil
+		}
+		resp.Header.Set("Content-Encoding", encoding)
+		return encoded, nil
+	}
+}
+
+// decodeBody undoes a Content-Encoding. An empty or identity encoding
+// returns body as is. A decoded body over limit bytes is an error, so it
+// passes through rather than being cut short.
+func decodeBody(encoding string, body []byte, limit int64) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case "br":
+		r = io.NopCloser(brotli.NewReader(bytes.NewReader(body)))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() //nolint:errcheck // in-memory reader
+	decoded, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("decoded body exceeds %d bytes", limit)
+	}
+	return decoded, nil
+}
+
+// encodeBody applies a Content-Encoding previously undone by decodeBody.
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
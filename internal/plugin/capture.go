@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Capture is one request/response pair saved by an InterceptionFilter,
+// for replaying captured traffic through filters in tests and tools.
+type Capture struct {
+	Seq      int
+	Request  CaptureRequest
+	Response CaptureResponse
+	Body     []byte // decompressed response body
+	BodyFile string // path of the body file as stored (may end in .gz)
+}
+
+// CaptureRequest is the contents of an NNN-req.json file.
+type CaptureRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+// CaptureResponse is the contents of an NNN-resp.json file.
+type CaptureResponse struct {
+	Status      int               `json:"status"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// ReadCaptures reads every capture in a session directory
+// (intercepts/<plugin>/<session>/), in sequence order. Bodies saved with
+// compress_captures are decompressed transparently.
+func ReadCaptures(dir string) ([]Capture, error) {
+	reqFiles, err := filepath.Glob(filepath.Join(dir, "*-req.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	captures := make([]Capture, 0, len(reqFiles))
+	for _, reqFile := range reqFiles {
+		prefix := strings.TrimSuffix(filepath.Base(reqFile), "-req.json")
+		seq, err := strconv.Atoi(prefix)
+		if err != nil {
+			continue // not a capture file
+		}
+		c, err := readCapture(dir, prefix)
+		if err != nil {
+			return nil, err
+		}
+		c.Seq = seq
+		captures = append(captures, c)
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].Seq < captures[j].Seq })
+	return captures, nil
+}
+
+// readCapture reads the req, resp, and body files sharing prefix.
+func readCapture(dir, prefix string) (Capture, error) {
+	var c Capture
+	if err := readJSON(filepath.Join(dir, prefix+"-req.json"), &c.Request); err != nil {
+		return c, err
+	}
+	if err := readJSON(filepath.Join(dir, prefix+"-resp.json"), &c.Response); err != nil {
+		return c, err
+	}
+
+	bodies, err := filepath.Glob(filepath.Join(dir, prefix+"-body.*"))
+	if err != nil {
+		return c, err
+	}
+	if len(bodies) != 1 {
+		return c, fmt.Errorf("capture %s: want one body file, found %d", prefix, len(bodies))
+	}
+	c.BodyFile = bodies[0]
+	c.Body, err = readBody(c.BodyFile)
+	return c, err
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// readBody reads a body file, gunzipping it if it ends in .gz.
+func readBody(path string) ([]byte, error) {
+	if !strings.HasSuffix(path, ".gz") {
+		return os.ReadFile(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck // read-only
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s: %w", path, err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s: %w", path, err)
+	}
+	return body, nil
+}
+
+// writeGzip writes data gzip-compressed to path.
+func writeGzip(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if _, err := zw.Write(data); err != nil {
+		f.Close() //nolint:errcheck,gosec // already failing
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close() //nolint:errcheck,gosec // already failing
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// cosmeticFilter hides ad containers client-side by injecting a <style>
+// block before </head>. Unlike element removal, a selector that stops
+// matching after a site redesign just hides nothing, so the page itself
+// never breaks.
+type cosmeticFilter struct {
+	name    string
+	version string
+	logger  *slog.Logger
+
+	css       string            // applied on every domain
+	domainCSS map[string]string // lowercased host -> CSS, added after css
+}
+
+func init() {
+	Registry["cosmetic"] = func() ContentFilter {
+		return &cosmeticFilter{
+			name:    "cosmetic",
+			version: "0.1.0",
+		}
+	}
+}
+
+func (f *cosmeticFilter) Name() string    { return f.name }
+func (f *cosmeticFilter) Version() string { return f.version }
+
+// Domains returns an empty list; cosmetic is generic, so its domains come
+// from config.
+func (f *cosmeticFilter) Domains() []string { return nil }
+
+// Init reads Options["css"], injected on every domain, and
+// Options["domain_css"], a map of exact host to CSS injected only there.
+// At least one of them must be set.
+func (f *cosmeticFilter) Init(cfg *PluginConfig, logger *slog.Logger) error {
+	f.logger = logger
+
+	if v, ok := cfg.Options["css"]; ok && v != nil {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("css must be a string, got %v", v)
+		}
+		if err := checkCSS(s); err != nil {
+			return fmt.Errorf("css: %w", err)
+		}
+		f.css = strings.TrimSpace(s)
+	}
+
+	if v, ok := cfg.Options["domain_css"]; ok && v != nil {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("domain_css must be a map of domain to CSS, got %v", v)
+		}
+		f.domainCSS = make(map[string]string, len(m))
+		for domain, item := range m {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("domain_css[%s] must be a string, got %v", domain, item)
+			}
+			if err := checkCSS(s); err != nil {
+				return fmt.Errorf("domain_css[%s]: %w", domain, err)
+			}
+			if s = strings.TrimSpace(s); s != "" {
+				f.domainCSS[strings.ToLower(domain)] = s
+			}
+		}
+	}
+
+	if f.css == "" && len(f.domainCSS) == 0 {
+		return fmt.Errorf("css or domain_css must be set")
+	}
+	return nil
+}
+
+// checkCSS rejects CSS that would close the injected <style> element early.
+func checkCSS(s string) error {
+	if strings.Contains(strings.ToLower(s), "</style") {
+		return fmt.Errorf("must not contain </style")
+	}
+	return nil
+}
+
+// Filter injects the CSS for the request's host into text/html responses,
+// just before the first </head> (matched case-insensitively). Pages
+// without one pass through unchanged.
+func (f *cosmeticFilter) Filter(req *http.Request, resp *http.Response, body []byte) ([]byte, FilterResult, error) {
+	if normalizeContentType(resp.Header.Get("Content-Type")) != "text/html" {
+		return body, FilterResult{}, nil
+	}
+
+	css := f.cssFor(req.Host)
+	if css == "" {
+		return body, FilterResult{}, nil
+	}
+
+	idx := indexFoldASCII(body, []byte("</head"))
+	if idx < 0 {
+		return body, FilterResult{}, nil
+	}
+
+	style := `<style data-fps="cosmetic">` + css + "</style>"
+	out := make([]byte, 0, len(body)+len(style))
+	out = append(out, body[:idx]...)
+	out = append(out, style...)
+	out = append(out, body[idx:]...)
+
+	return out, FilterResult{
+		Matched:  true,
+		Modified: true,
+		Rule:     "inject-css",
+		Rules:    []RuleMatch{{Rule: "inject-css", Count: 1, Modified: true}},
+	}, nil
+}
+
+// indexFoldASCII returns the offset in s of the first ASCII
+// case-insensitive match of sep, or -1. Unlike searching bytes.ToLower(s),
+// the offset is always valid for s: ToLower rewrites invalid UTF-8 bytes
+// (e.g. Latin-1 pages) as 3-byte U+FFFD, shifting everything after them.
+func indexFoldASCII(s, sep []byte) int {
+	for i := 0; i+len(sep) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// cssFor returns the CSS to inject for host: the global css followed by
+// any domain_css entry for it.
+func (f *cosmeticFilter) cssFor(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	domain := f.domainCSS[strings.ToLower(host)]
+	switch {
+	case f.css == "":
+		return domain
+	case domain == "":
+		return f.css
+	}
+	return f.css + "\n" + domain
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newCosmeticFilter creates an initialized cosmeticFilter for testing.
+func newCosmeticFilter(t *testing.T, options map[string]any) *cosmeticFilter {
+	t.Helper()
+	f, ok := Registry["cosmetic"]().(*cosmeticFilter)
+	require.True(t, ok)
+	require.NoError(t, f.Init(&PluginConfig{
+		Enabled:     true,
+		Mode:        ModeFilter,
+		Placeholder: PlaceholderNone,
+		Domains:     []string{"www.reddit.com", "news.example.com"},
+		Options:     options,
+	}, testLogger()))
+	return f
+}
+
+func TestCosmeticInjectsBeforeHeadClose(t *testing.T) {
+	f := newCosmeticFilter(t, map[string]any{"css": ".ad { display: none !important }"})
+	body := []byte("<html><HEAD><title>x</title></HEAD><body><p>hi</p></body></html>")
+
+	out, result, err := f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.True(t, result.Matched)
+	assert.True(t, result.Modified)
+	assert.Equal(t, []RuleMatch{{Rule: "inject-css", Count: 1, Modified: true}}, result.Rules)
+	assert.Equal(t,
+		`<html><HEAD><title>x</title><style data-fps="cosmetic">.ad { display: none !important }</style></HEAD><body><p>hi</p></body></html>`,
+		string(out))
+}
+
+func TestCosmeticNonUTF8(t *testing.T) {
+	f := newCosmeticFilter(t, map[string]any{"css": ".ad{display:none}"})
+	// Latin-1 "Café ééé": each \xe9 is invalid UTF-8.
+	body := []byte("<html><head><title>Caf\xe9 \xe9\xe9\xe9</title></head><body>\xe9</body></html>")
+
+	out, result, err := f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.True(t, result.Modified)
+	assert.Equal(t,
+		"<html><head><title>Caf\xe9 \xe9\xe9\xe9</title><style data-fps=\"cosmetic\">.ad{display:none}</style></head><body>\xe9</body></html>",
+		string(out))
+
+	// Enough invalid bytes before </head> used to push the offset past the
+	// end of the body.
+	body = append(bytes.Repeat([]byte{0xe9}, 100), "</head>"...)
+	out, _, err = f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.Equal(t, string(bytes.Repeat([]byte{0xe9}, 100))+`<style data-fps="cosmetic">.ad{display:none}</style></head>`, string(out))
+}
+
+func TestCosmeticDomainCSS(t *testing.T) {
+	f := newCosmeticFilter(t, map[string]any{
+		"css": ".global-ad{display:none}",
+		"domain_css": map[string]any{
+			"News.Example.com": ".sponsored{display:none}",
+		},
+	})
+	body := []byte("<head></head>")
+
+	// www.reddit.com only gets the global CSS.
+	out, _, err := f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.Equal(t, `<head><style data-fps="cosmetic">.global-ad{display:none}</style></head>`, string(out))
+
+	// The host's entry is appended, ignoring case and port.
+	req := makeReq("/")
+	req.Host = "news.example.com:443"
+	out, _, err = f.Filter(req, makeResp(), body)
+	require.NoError(t, err)
+	assert.Equal(t, "<head><style data-fps=\"cosmetic\">.global-ad{display:none}\n.sponsored{display:none}</style></head>", string(out))
+
+	// Without global CSS, other hosts pass through.
+	f = newCosmeticFilter(t, map[string]any{
+		"domain_css": map[string]any{"news.example.com": ".sponsored{display:none}"},
+	})
+	out, result, err := f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.False(t, result.Matched)
+	assert.Equal(t, body, out)
+}
+
+func TestCosmeticPassesThrough(t *testing.T) {
+	f := newCosmeticFilter(t, map[string]any{"css": ".ad{display:none}"})
+
+	// No </head>: nothing to anchor on.
+	body := []byte("<p>fragment</p>")
+	out, result, err := f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.False(t, result.Matched)
+	assert.Equal(t, body, out)
+
+	// Not HTML.
+	body = []byte(`{"head":"</head>"}`)
+	out, result, err = f.Filter(makeReq("/"), jsonResp(), body)
+	require.NoError(t, err)
+	assert.False(t, result.Matched)
+	assert.Equal(t, body, out)
+}
+
+func TestCosmeticInitErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]any
+		want    string
+	}{
+		{"missing", nil, "css or domain_css must be set"},
+		{"blank", map[string]any{"css": "  "}, "css or domain_css must be set"},
+		{"css type", map[string]any{"css": 3}, "css must be a string"},
+		{"domain_css type", map[string]any{"domain_css": []any{"x"}}, "domain_css must be a map"},
+		{"domain entry type", map[string]any{"domain_css": map[string]any{"a.com": 1}}, "domain_css[a.com] must be a string"},
+		{"style breakout", map[string]any{"css": "a{}</STYLE><script>"}, "css: must not contain </style"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &cosmeticFilter{name: "cosmetic"}
+			err := f.Init(&PluginConfig{Options: tt.options}, testLogger())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// htmlSanitizeFilter removes tracker <script> tags from HTML responses.
+// External scripts are matched on their src attribute, inline scripts on
+// their body, against configured substrings and regexes. Unlike rewrite
+// rules, a match removes the whole tag, so a half-edited snippet never
+// reaches the page.
+type htmlSanitizeFilter struct {
+	name        string
+	version     string
+	placeholder string
+	logger      *slog.Logger
+
+	sources  []string         // lowercased substrings
+	patterns []*regexp.Regexp // matched as-is
+}
+
+func init() {
+	Registry["html-sanitize"] = func() ContentFilter {
+		return &htmlSanitizeFilter{
+			name:    "html-sanitize",
+			version: "0.1.0",
+		}
+	}
+}
+
+func (f *htmlSanitizeFilter) Name() string    { return f.name }
+func (f *htmlSanitizeFilter) Version() string { return f.version }
+
+// Domains returns an empty list; html-sanitize is generic, so its domains
+// come from config.
+func (f *htmlSanitizeFilter) Domains() []string { return nil }
+
+// Init reads the script matchers. Options["script_sources"] lists
+// case-insensitive substrings and Options["script_patterns"] regexes; a
+// script is removed when any of them matches its src, or its body for an
+// inline script. At least one matcher is required.
+func (f *htmlSanitizeFilter) Init(cfg *PluginConfig, logger *slog.Logger) error {
+	f.placeholder = cfg.Placeholder
+	f.logger = logger
+
+	sources, err := optionStrings(cfg.Options, "script_sources")
+	if err != nil {
+		return err
+	}
+	for _, s := range sources {
+		if s != "" {
+			f.sources = append(f.sources, strings.ToLower(s))
+		}
+	}
+
+	patterns, err := optionStrings(cfg.Options, "script_patterns")
+	if err != nil {
+		return err
+	}
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("script_patterns[%d]: %w", i, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+
+	if len(f.sources) == 0 && len(f.patterns) == 0 {
+		return fmt.Errorf("script_sources or script_patterns must list at least one entry")
+	}
+	return nil
+}
+
+// Filter removes matching <script> elements from text/html responses.
+func (f *htmlSanitizeFilter) Filter(_ *http.Request, resp *http.Response, body []byte) ([]byte, FilterResult, error) {
+	ct := normalizeContentType(resp.Header.Get("Content-Type"))
+	if ct != "text/html" {
+		return body, FilterResult{}, nil
+	}
+
+	lower := bytes.ToLower(body)
+	if !bytes.Contains(lower, []byte("<script")) {
+		return body, FilterResult{}, nil
+	}
+	scripts := appendTagRanges(nil, lower, body, []byte("<script"), []byte("</script"))
+
+	var out bytes.Buffer
+	var srcCount, inlineCount int
+	last := 0
+	for _, r := range scripts {
+		rule, ok := f.match(body[r.start:r.end])
+		if !ok {
+			continue
+		}
+		out.Write(body[last:r.start])
+		out.WriteString(Marker(f.placeholder, f.name, rule, ct))
+		last = r.end
+		if rule == "script-src" {
+			srcCount++
+		} else {
+			inlineCount++
+		}
+	}
+	if srcCount+inlineCount == 0 {
+		return body, FilterResult{}, nil
+	}
+	out.Write(body[last:])
+
+	var rules []RuleMatch
+	if srcCount > 0 {
+		rules = append(rules, RuleMatch{Rule: "script-src", Count: srcCount, Modified: true})
+	}
+	if inlineCount > 0 {
+		rules = append(rules, RuleMatch{Rule: "inline-script", Count: inlineCount, Modified: true})
+	}
+	return out.Bytes(), FilterResult{
+		Matched:  true,
+		Modified: true,
+		Rule:     rules[0].Rule,
+		Removed:  srcCount + inlineCount,
+		Rules:    rules,
+	}, nil
+}
+
+// match reports whether the script element el should be removed, and the
+// rule it falls under: "script-src" for a matching src attribute,
+// "inline-script" for a matching inline body.
+func (f *htmlSanitizeFilter) match(el []byte) (string, bool) {
+	gt := bytes.IndexByte(el, '>')
+	if gt < 0 {
+		// Open tag never closes: leave it for the browser to sort out.
+		return "", false
+	}
+	if src, ok := scriptSrc(el[:gt]); ok {
+		return "script-src", f.matches(src)
+	}
+	inner := el[gt+1:]
+	if end := bytes.LastIndex(bytes.ToLower(inner), []byte("</script")); end >= 0 {
+		inner = inner[:end]
+	}
+	return "inline-script", f.matches(inner)
+}
+
+// matches reports whether any configured substring or regex matches s.
+func (f *htmlSanitizeFilter) matches(s []byte) bool {
+	if len(f.sources) > 0 {
+		lower := bytes.ToLower(s)
+		for _, src := range f.sources {
+			if bytes.Contains(lower, []byte(src)) {
+				return true
+			}
+		}
+	}
+	for _, re := range f.patterns {
+		if re.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptSrc returns the value of the src attribute in an opening <script
+// tag (without its closing '>'), unquoting it if needed.
+func scriptSrc(tag []byte) ([]byte, bool) {
+	lower := bytes.ToLower(tag)
+	for i := len("<script"); i < len(lower); {
+		idx := bytes.Index(lower[i:], []byte("src"))
+		if idx < 0 {
+			return nil, false
+		}
+		start := i + idx
+		i = start + len("src")
+		// The attribute name must stand alone: "data-src" and "srcset" don't
+		// count.
+		if !isHTMLSpace(lower[start-1]) {
+			continue
+		}
+		j := i
+		for j < len(lower) && isHTMLSpace(lower[j]) {
+			j++
+		}
+		if j >= len(lower) || lower[j] != '=' {
+			continue
+		}
+		j++
+		for j < len(lower) && isHTMLSpace(lower[j]) {
+			j++
+		}
+		if j >= len(tag) {
+			return nil, false
+		}
+		if q := tag[j]; q == '"' || q == '\'' {
+			end := bytes.IndexByte(tag[j+1:], q)
+			if end < 0 {
+				return tag[j+1:], true
+			}
+			return tag[j+1 : j+1+end], true
+		}
+		end := j
+		for end < len(tag) && !isHTMLSpace(tag[end]) {
+			end++
+		}
+		return tag[j:end], true
+	}
+	return nil, false
+}
+
+// isHTMLSpace reports whether c is HTML attribute-separating whitespace.
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// optionStrings reads a list of strings from a plugin option. A missing
+// key yields nil.
+func optionStrings(opts map[string]any, key string) ([]string, error) {
+	v, ok := opts[key]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	switch list := v.(type) {
+	case []string:
+		return list, nil
+	case []any:
+		out := make([]string, 0, len(list))
+		for i, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s[%d] must be a string, got %v", key, i, item)
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list of strings, got %v", key, v)
+	}
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHTMLSanitizeFilter creates an initialized htmlSanitizeFilter for testing.
+func newHTMLSanitizeFilter(t *testing.T, placeholder string, options map[string]any) *htmlSanitizeFilter {
+	t.Helper()
+	f, ok := Registry["html-sanitize"]().(*htmlSanitizeFilter)
+	require.True(t, ok)
+	require.NoError(t, f.Init(&PluginConfig{
+		Enabled:     true,
+		Mode:        ModeFilter,
+		Placeholder: placeholder,
+		Domains:     []string{"news.example.com"},
+		Options:     options,
+	}, testLogger()))
+	return f
+}
+
+const sanitizePage = `<html><head>
+<script src="/static/app.js"></script>
+<script async SRC='https://www.googletagmanager.com/gtag/js?id=G-1'></script>
+<script type="text/javascript">
+  window.dataLayer = window.dataLayer || [];
+  function gtag(){dataLayer.push(arguments);}
+  gtag('config', 'G-1');
+</script>
+<script>document.documentElement.className = "js";</script>
+<style>.ad { display: none }</style>
+</head><body><p>Read the script docs.</p></body></html>`
+
+func TestHTMLSanitizeRemovesTrackerScripts(t *testing.T) {
+	f := newHTMLSanitizeFilter(t, PlaceholderNone, map[string]any{
+		"script_sources":  []any{"GoogleTagManager.com"},
+		"script_patterns": []any{`gtag\(`},
+	})
+
+	out, result, err := f.Filter(makeReq("/"), makeResp(), []byte(sanitizePage))
+	require.NoError(t, err)
+
+	assert.True(t, result.Matched)
+	assert.True(t, result.Modified)
+	assert.Equal(t, 2, result.Removed)
+	assert.Equal(t, []RuleMatch{
+		{Rule: "script-src", Count: 1, Modified: true},
+		{Rule: "inline-script", Count: 1, Modified: true},
+	}, result.Rules)
+
+	// Tracker tags are gone entirely.
+	assert.NotContains(t, string(out), "googletagmanager")
+	assert.NotContains(t, string(out), "dataLayer")
+
+	// Legitimate scripts, styles, and text are untouched.
+	assert.Contains(t, string(out), `<script src="/static/app.js"></script>`)
+	assert.Contains(t, string(out), `<script>document.documentElement.className = "js";</script>`)
+	assert.Contains(t, string(out), "<style>.ad { display: none }</style>")
+	assert.Contains(t, string(out), "<p>Read the script docs.</p>")
+}
+
+func TestHTMLSanitizeCommentPlaceholder(t *testing.T) {
+	f := newHTMLSanitizeFilter(t, PlaceholderComment, map[string]any{
+		"script_sources": []any{"googletagmanager.com"},
+	})
+
+	out, _, err := f.Filter(makeReq("/"), makeResp(), []byte(sanitizePage))
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "<!-- fps filtered: html-sanitize/script-src -->")
+	assert.Contains(t, string(out), "gtag('config'", "inline script is only matched by its own body")
+}
+
+func TestHTMLSanitizeMatchesSrcOnlyForExternalScripts(t *testing.T) {
+	f := newHTMLSanitizeFilter(t, PlaceholderNone, map[string]any{
+		"script_sources": []any{"tracker"},
+	})
+	body := `<script data-src="tracker.js" src="/app.js"></script>` +
+		`<script src=/js/tracker.min.js defer></script>`
+
+	out, result, err := f.Filter(makeReq("/"), makeResp(), []byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Removed)
+	assert.Equal(t, `<script data-src="tracker.js" src="/app.js"></script>`, string(out))
+}
+
+func TestHTMLSanitizeSkipsNonHTML(t *testing.T) {
+	f := newHTMLSanitizeFilter(t, PlaceholderNone, map[string]any{
+		"script_sources": []any{"googletagmanager.com"},
+	})
+	resp := &http.Response{Header: http.Header{"Content-Type": []string{"application/json"}}}
+	body := []byte(`{"html":"<script src=\"https://www.googletagmanager.com/gtag/js\"></script>"}`)
+
+	out, result, err := f.Filter(makeReq("/"), resp, body)
+	require.NoError(t, err)
+	assert.False(t, result.Matched)
+	assert.Equal(t, body, out)
+}
+
+func TestHTMLSanitizeInitErrors(t *testing.T) {
+	for name, opts := range map[string]map[string]any{
+		"no matchers":      nil,
+		"bad regex":        {"script_patterns": []any{"("}},
+		"not a list":       {"script_sources": "googletagmanager.com"},
+		"non-string entry": {"script_sources": []any{42}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := Registry["html-sanitize"]()
+			assert.Error(t, f.Init(&PluginConfig{Options: opts}, testLogger()))
+		})
+	}
+}
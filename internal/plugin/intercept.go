@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -29,15 +30,26 @@ type InterceptionFilter struct {
 	logger    *slog.Logger
 	sequence  atomic.Int64
 	sessionID string
+
+	// sampleRate is the probability each request is captured (1 = all).
+	// maxCaptures stops capturing once reached (0 = unlimited).
+	sampleRate  float64
+	maxCaptures int64
+	sample      func() float64
+
+	// compress gzips body files (NNN-body.<ext>.gz); metadata stays JSON.
+	compress bool
 }
 
 // NewInterceptionFilter creates a new interception filter. The name, version,
 // and domains define the plugin identity; actual output directory is set during Init.
 func NewInterceptionFilter(name, version string, domains []string) *InterceptionFilter {
 	return &InterceptionFilter{
-		name:    name,
-		version: version,
-		domains: domains,
+		name:       name,
+		version:    version,
+		domains:    domains,
+		sampleRate: 1,
+		sample:     rand.Float64,
 	}
 }
 
@@ -47,9 +59,35 @@ func (f *InterceptionFilter) Domains() []string  { return f.domains }
 
 // Init sets up the interception output directory. The data_dir is read from
 // Options["data_dir"] (set by main during plugin init).
+// Options["sample_rate"] captures each request with that probability
+// (0 < rate <= 1, default 1) and Options["max_captures"] caps the number of
+// captured pairs per session (0 = unlimited).
+// Options["compress_captures"] gzips the body files (see ReadCaptures).
 func (f *InterceptionFilter) Init(cfg *PluginConfig, logger *slog.Logger) error {
 	f.logger = logger
 
+	if v, ok := cfg.Options["sample_rate"]; ok {
+		rate, ok := optionFloat(v)
+		if !ok || rate <= 0 || rate > 1 {
+			return fmt.Errorf("sample_rate must be a number in (0, 1], got %v", v)
+		}
+		f.sampleRate = rate
+	}
+	if v, ok := cfg.Options["max_captures"]; ok {
+		n, ok := v.(int)
+		if !ok || n < 0 {
+			return fmt.Errorf("max_captures must be a non-negative integer, got %v", v)
+		}
+		f.maxCaptures = int64(n)
+	}
+	if v, ok := cfg.Options["compress_captures"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("compress_captures must be true or false, got %v", v)
+		}
+		f.compress = b
+	}
+
 	dataDir := "."
 	if v, ok := cfg.Options["data_dir"]; ok {
 		if s, ok := v.(string); ok && s != "" {
@@ -67,18 +105,28 @@ func (f *InterceptionFilter) Init(cfg *PluginConfig, logger *slog.Logger) error
 
 	logger.Info("interception mode active",
 		"output_dir", f.outputDir,
+		"sample_rate", f.sampleRate,
+		"max_captures", f.maxCaptures,
+		"compress_captures", f.compress,
 	)
 
 	return nil
 }
 
 // Filter captures the request/response pair to disk and returns the body
-// unchanged (interception mode does not modify responses).
+// unchanged (interception mode does not modify responses). Requests that miss
+// the sample or arrive after max_captures is reached pass through uncaptured.
 //nolint:unparam // FilterResult intentionally zero — interception never modifies
 func (f *InterceptionFilter) Filter(
 	req *http.Request, resp *http.Response, body []byte,
 ) ([]byte, FilterResult, error) {
+	if f.sampleRate < 1 && f.sample() >= f.sampleRate {
+		return body, FilterResult{}, nil
+	}
 	seq := f.sequence.Add(1)
+	if f.maxCaptures > 0 && seq > f.maxCaptures {
+		return body, FilterResult{}, nil
+	}
 
 	// Save request metadata.
 	reqData := map[string]any{
@@ -107,7 +155,14 @@ func (f *InterceptionFilter) Filter(
 	// Determine body extension from content type.
 	ext := bodyExtension(resp.Header.Get("Content-Type"))
 	bodyFile := prefix + "-body" + ext
-	if err := os.WriteFile(filepath.Join(f.outputDir, bodyFile), body, 0600); err != nil {
+	var err error
+	if f.compress {
+		bodyFile += ".gz"
+		err = writeGzip(filepath.Join(f.outputDir, bodyFile), body)
+	} else {
+		err = os.WriteFile(filepath.Join(f.outputDir, bodyFile), body, 0600)
+	}
+	if err != nil {
 		f.logger.Warn("intercept save failed", "file", bodyFile, "error", err)
 	}
 
@@ -121,6 +176,19 @@ func (f *InterceptionFilter) Filter(
 	return body, FilterResult{}, nil
 }
 
+// optionFloat reads a numeric plugin option. YAML decodes whole numbers as
+// int, so both int and float64 are accepted.
+func optionFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
 // flattenHeaders converts http.Header to a simple map for JSON serialization.
 func flattenHeaders(h http.Header) map[string]string {
 	out := make(map[string]string, len(h))
@@ -0,0 +1,267 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// jsonFilter strips array elements from JSON API responses according to
+// config-driven rules, the generic form of the reddit plugin's GraphQL
+// filters. Each rule picks responses by a request header, navigates to an
+// array, and removes the elements that pass its remove_when test.
+type jsonFilter struct {
+	name        string
+	version     string
+	placeholder string
+	logger      *slog.Logger
+
+	rules []jsonRule
+}
+
+// jsonRule is one parsed entry of Options["rules"].
+type jsonRule struct {
+	name        string
+	matchHeader string   // request header to test; empty matches every response
+	matchValue  string   // exact header value required when matchHeader is set
+	arrayPath   []string // keys leading to the array
+	whenPath    []string // keys within an element tested by remove_when
+	whenValue   string   // required value; empty tests key presence only
+	whenEquals  bool
+}
+
+func init() {
+	Registry["json-filter"] = func() ContentFilter {
+		return &jsonFilter{
+			name:    "json-filter",
+			version: "0.1.0",
+		}
+	}
+}
+
+func (f *jsonFilter) Name() string    { return f.name }
+func (f *jsonFilter) Version() string { return f.version }
+
+// Domains returns an empty list; json-filter is generic, so its domains
+// come from config.
+func (f *jsonFilter) Domains() []string { return nil }
+
+// Init parses Options["rules"], a list of
+// {name, match_header, match_value, array_path, remove_when} maps.
+// array_path and the key in remove_when are dot-separated key paths
+// ("data.homeV3.elements.edges", "node.adPayload"). remove_when is either
+// "key", true when the key is present and not null, or "key=value", true
+// when the key's value formats as value. At least one rule is required.
+func (f *jsonFilter) Init(cfg *PluginConfig, logger *slog.Logger) error {
+	f.placeholder = cfg.Placeholder
+	f.logger = logger
+
+	raw, ok := cfg.Options["rules"].([]any)
+	if !ok || len(raw) == 0 {
+		return fmt.Errorf("rules must list at least one rule")
+	}
+	for i, item := range raw {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("rules[%d] must be a map, got %v", i, item)
+		}
+		rule, err := parseJSONRule(m)
+		if err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule-%d", i+1)
+		}
+		f.rules = append(f.rules, rule)
+	}
+	return nil
+}
+
+// parseJSONRule reads one rule map.
+func parseJSONRule(m map[string]any) (jsonRule, error) {
+	str := func(key string) (string, error) {
+		v, ok := m[key]
+		if !ok || v == nil {
+			return "", nil
+		}
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%s must be a string, got %v", key, v)
+		}
+		return s, nil
+	}
+
+	var r jsonRule
+	var err error
+	if r.name, err = str("name"); err != nil {
+		return r, err
+	}
+	if r.matchHeader, err = str("match_header"); err != nil {
+		return r, err
+	}
+	if r.matchValue, err = str("match_value"); err != nil {
+		return r, err
+	}
+	if r.matchHeader == "" && r.matchValue != "" {
+		return r, fmt.Errorf("match_value requires match_header")
+	}
+
+	arrayPath, err := str("array_path")
+	if err != nil {
+		return r, err
+	}
+	if r.arrayPath, err = splitKeyPath(arrayPath); err != nil {
+		return r, fmt.Errorf("array_path: %w", err)
+	}
+
+	when, err := str("remove_when")
+	if err != nil {
+		return r, err
+	}
+	key, value, equals := strings.Cut(when, "=")
+	if r.whenPath, err = splitKeyPath(key); err != nil {
+		return r, fmt.Errorf("remove_when: %w", err)
+	}
+	r.whenValue, r.whenEquals = value, equals
+	return r, nil
+}
+
+// splitKeyPath splits a dot-separated key path, rejecting empty keys.
+func splitKeyPath(s string) ([]string, error) {
+	if s == "" {
+		return nil, fmt.Errorf("must not be empty")
+	}
+	keys := strings.Split(s, ".")
+	for _, k := range keys {
+		if k == "" {
+			return nil, fmt.Errorf("empty key in %q", s)
+		}
+	}
+	return keys, nil
+}
+
+// Filter applies every rule matching the request to a JSON response.
+// Removed elements are replaced with the JSON Marker object, or dropped
+// with placeholder "none". Unparseable bodies pass through unchanged.
+func (f *jsonFilter) Filter(req *http.Request, resp *http.Response, body []byte) ([]byte, FilterResult, error) {
+	ct := resp.Header.Get("Content-Type")
+	if !isJSONContentType(ct) {
+		return body, FilterResult{}, nil
+	}
+
+	var active []jsonRule
+	for _, r := range f.rules {
+		if r.matchHeader == "" || req.Header.Get(r.matchHeader) == r.matchValue {
+			active = append(active, r)
+		}
+	}
+	if len(active) == 0 {
+		return body, FilterResult{}, nil
+	}
+
+	doc, err := decodeJSONObject(body)
+	if err != nil {
+		return body, FilterResult{}, nil // fail open
+	}
+
+	var rules []RuleMatch
+	var total int
+	for _, r := range active {
+		n := f.apply(doc, r, ct)
+		if n > 0 {
+			rules = append(rules, RuleMatch{Rule: r.name, Count: n, Modified: true})
+			total += n
+		}
+	}
+	if total == 0 {
+		return body, FilterResult{}, nil
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return body, FilterResult{}, nil // fail open
+	}
+	return out, FilterResult{
+		Matched:  true,
+		Modified: true,
+		Rule:     rules[0].Rule,
+		Removed:  total,
+		Rules:    rules,
+	}, nil
+}
+
+// decodeJSONObject decodes a JSON object, keeping numbers as json.Number
+// so IDs beyond float64 precision survive re-encoding unchanged.
+func decodeJSONObject(body []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("trailing data after JSON object")
+	}
+	return doc, nil
+}
+
+// apply removes the elements of r's array that pass its test, in place in
+// doc, and returns how many were removed.
+func (f *jsonFilter) apply(doc map[string]any, r jsonRule, ct string) int {
+	parent := doc
+	if len(r.arrayPath) > 1 {
+		var ok bool
+		if parent, ok = jsonPath[map[string]any](doc, r.arrayPath[:len(r.arrayPath)-1]...); !ok {
+			return 0
+		}
+	}
+	last := r.arrayPath[len(r.arrayPath)-1]
+	items, ok := parent[last].([]any)
+	if !ok || len(items) == 0 {
+		return 0
+	}
+
+	var marker any
+	if m := Marker(f.placeholder, f.name, r.name, ct); m != "" {
+		_ = json.Unmarshal([]byte(m), &marker) //nolint:errcheck // Marker emits valid JSON for JSON content types
+	}
+
+	filtered := make([]any, 0, len(items))
+	var removed int
+	for _, item := range items {
+		if !r.remove(item) {
+			filtered = append(filtered, item)
+			continue
+		}
+		removed++
+		if marker != nil {
+			filtered = append(filtered, marker)
+		}
+	}
+	if removed > 0 {
+		parent[last] = filtered
+	}
+	return removed
+}
+
+// remove reports whether item passes the rule's remove_when test. Numbers
+// compare by their literal text, so "count=1000000" matches 1000000.
+func (r jsonRule) remove(item any) bool {
+	m, ok := item.(map[string]any)
+	if !ok {
+		return false
+	}
+	v, ok := jsonPath[any](m, r.whenPath...)
+	if !ok || v == nil {
+		return false
+	}
+	if !r.whenEquals {
+		return true
+	}
+	return fmt.Sprint(v) == r.whenValue
+}
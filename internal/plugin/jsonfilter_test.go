@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redditJSONRules reproduces the reddit plugin's HomeFeedSdui and
+// FeedPostDetailsByIds filters as json-filter rules.
+var redditJSONRules = []any{
+	map[string]any{
+		"name":         "feed-sdui-ad",
+		"match_header": "X-Apollo-Operation-Name",
+		"match_value":  "HomeFeedSdui",
+		"array_path":   "data.homeV3.elements.edges",
+		"remove_when":  "node.adPayload",
+	},
+	map[string]any{
+		"name":         "feed-details-ad",
+		"match_header": "X-Apollo-Operation-Name",
+		"match_value":  "FeedPostDetailsByIds",
+		"array_path":   "data.postsInfoByIds",
+		"remove_when":  "__typename=ProfilePost",
+	},
+}
+
+// newJSONFilter creates an initialized jsonFilter for testing.
+func newJSONFilter(t *testing.T, placeholder string, rules []any) *jsonFilter {
+	t.Helper()
+	f, ok := Registry["json-filter"]().(*jsonFilter)
+	require.True(t, ok)
+	require.NoError(t, f.Init(&PluginConfig{
+		Enabled:     true,
+		Mode:        ModeFilter,
+		Placeholder: placeholder,
+		Domains:     []string{"gql-fed.reddit.com"},
+		Options:     map[string]any{"rules": rules},
+	}, testLogger()))
+	return f
+}
+
+func TestJSONFilterKeyPresence(t *testing.T) {
+	f := newJSONFilter(t, PlaceholderNone, redditJSONRules)
+
+	out, fr, err := f.Filter(gqlRequest("HomeFeedSdui"), jsonResp(), loadFixture(t, "homefeed_sdui.json"))
+	require.NoError(t, err)
+	assert.True(t, fr.Modified)
+	assert.Equal(t, 2, fr.Removed)
+	assert.Equal(t, []RuleMatch{{Rule: "feed-sdui-ad", Count: 2, Modified: true}}, fr.Rules)
+
+	edges := jsonGet[[]any](t, out, "data", "homeV3", "elements", "edges")
+	assert.Len(t, edges, 3)
+	for _, edge := range edges {
+		_, ok := jsonPath[any](edge.(map[string]any), "node", "adPayload")
+		assert.False(t, ok, "ad edge left in output")
+	}
+}
+
+func TestJSONFilterKeyEquals(t *testing.T) {
+	f := newJSONFilter(t, PlaceholderNone, redditJSONRules)
+	body := loadFixture(t, "feed_details.json")
+
+	// Matches the reddit plugin's result for the same fixture.
+	want, wantFR, err := newRedditFilter(t, PlaceholderNone).Filter(gqlRequest("FeedPostDetailsByIds"), jsonResp(), body)
+	require.NoError(t, err)
+
+	out, fr, err := f.Filter(gqlRequest("FeedPostDetailsByIds"), jsonResp(), body)
+	require.NoError(t, err)
+	assert.Equal(t, wantFR.Removed, fr.Removed)
+	assert.Equal(t, "feed-details-ad", fr.Rule)
+	assert.JSONEq(t, string(want), string(out))
+}
+
+func TestJSONFilterHeaderMismatch(t *testing.T) {
+	f := newJSONFilter(t, PlaceholderNone, redditJSONRules)
+	body := loadFixture(t, "homefeed_sdui.json")
+
+	for _, op := range []string{"", "FeedPostDetailsByIds", "SomethingElse"} {
+		out, fr, err := f.Filter(gqlRequest(op), jsonResp(), body)
+		require.NoError(t, err)
+		assert.False(t, fr.Matched, op)
+		assert.Equal(t, body, out, op)
+	}
+}
+
+func TestJSONFilterPlaceholder(t *testing.T) {
+	f := newJSONFilter(t, PlaceholderVisible, redditJSONRules)
+
+	out, fr, err := f.Filter(gqlRequest("HomeFeedSdui"), jsonResp(), loadFixture(t, "homefeed_sdui.json"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, fr.Removed)
+
+	// Removed elements become Marker objects in place.
+	edges := jsonGet[[]any](t, out, "data", "homeV3", "elements", "edges")
+	require.Len(t, edges, 5)
+	var markers int
+	for _, edge := range edges {
+		if edge.(map[string]any)["fps_filtered"] == "json-filter/feed-sdui-ad" {
+			markers++
+		}
+	}
+	assert.Equal(t, 2, markers)
+}
+
+func TestJSONFilterSkipsNonJSON(t *testing.T) {
+	f := newJSONFilter(t, PlaceholderNone, []any{
+		map[string]any{"array_path": "items", "remove_when": "ad"},
+	})
+	body := []byte(`{"items":[{"ad":true},{"id":1}]}`)
+
+	out, fr, err := f.Filter(makeReq("/"), makeResp(), body)
+	require.NoError(t, err)
+	assert.False(t, fr.Matched)
+	assert.Equal(t, body, out)
+
+	// Without match_header a rule applies to every JSON response.
+	out, fr, err = f.Filter(makeReq("/"), jsonResp(), body)
+	require.NoError(t, err)
+	assert.Equal(t, "rule-1", fr.Rule)
+	assert.JSONEq(t, `{"items":[{"id":1}]}`, string(out))
+
+	// Malformed JSON fails open.
+	out, fr, err = f.Filter(makeReq("/"), jsonResp(), []byte(`{"items":`))
+	require.NoError(t, err)
+	assert.False(t, fr.Matched)
+	assert.Equal(t, `{"items":`, string(out))
+}
+
+func TestJSONFilterPreservesNumbers(t *testing.T) {
+	f := newJSONFilter(t, PlaceholderNone, []any{
+		map[string]any{"array_path": "items", "remove_when": "count=1000000"},
+	})
+	body := []byte(`{"items":[{"id":9007199254740993,"count":1000000},{"id":9007199254740995,"count":1.5}],"total":12345678901234567890}`)
+
+	out, fr, err := f.Filter(makeReq("/"), jsonResp(), body)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fr.Removed)
+	assert.JSONEq(t, `{"items":[{"id":9007199254740995,"count":1.5}],"total":12345678901234567890}`, string(out))
+	assert.Contains(t, string(out), "9007199254740995", "large IDs are not rounded through float64")
+	assert.Contains(t, string(out), "12345678901234567890")
+}
+
+func TestJSONFilterInitErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules any
+		want  string
+	}{
+		{"missing", nil, "rules must list at least one rule"},
+		{"not a map", []any{"x"}, "rules[0] must be a map"},
+		{"no array path", []any{map[string]any{"remove_when": "ad"}}, "rules[0]: array_path: must not be empty"},
+		{"empty key", []any{map[string]any{"array_path": "data..edges", "remove_when": "ad"}}, `rules[0]: array_path: empty key in "data..edges"`},
+		{"no test", []any{map[string]any{"array_path": "items"}}, "rules[0]: remove_when: must not be empty"},
+		{
+			"value without header",
+			[]any{map[string]any{"array_path": "items", "remove_when": "ad", "match_value": "x"}},
+			"match_value requires match_header",
+		},
+		{"wrong type", []any{map[string]any{"array_path": 3, "remove_when": "ad"}}, "array_path must be a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &jsonFilter{name: "json-filter"}
+			err := f.Init(&PluginConfig{Options: map[string]any{"rules": tt.rules}}, testLogger())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
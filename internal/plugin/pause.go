@@ -0,0 +1,121 @@
+package plugin
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownPluginDomain is returned when pausing or resuming a
+// (plugin, domain) pair that no active plugin handles.
+var ErrUnknownPluginDomain = errors.New("plugin does not handle domain")
+
+// PausedPair is a (plugin, domain) pair whose filtering is paused.
+type PausedPair struct {
+	Plugin string `json:"plugin"`
+	Domain string `json:"domain"`
+}
+
+// PauseSet tracks (plugin, domain) pairs whose filtering is temporarily
+// paused. The response modifier skips a paused plugin for that domain only;
+// the plugin keeps filtering its other domains. State is in-memory and
+// resets on restart.
+type PauseSet struct {
+	mu     sync.RWMutex
+	known  map[PausedPair]struct{}
+	paused map[PausedPair]struct{}
+}
+
+// NewPauseSet creates an empty PauseSet accepting the (plugin, domain)
+// pairs configured in results.
+func NewPauseSet(results []InitResult) *PauseSet {
+	p := &PauseSet{
+		known:  make(map[PausedPair]struct{}),
+		paused: make(map[PausedPair]struct{}),
+	}
+	for _, r := range results {
+		for _, d := range r.Config.Domains {
+			p.known[PausedPair{Plugin: r.Plugin.Name(), Domain: strings.ToLower(d)}] = struct{}{}
+		}
+	}
+	return p
+}
+
+// Pause stops pluginName from filtering responses for domain.
+func (p *PauseSet) Pause(pluginName, domain string) error {
+	key := PausedPair{Plugin: pluginName, Domain: strings.ToLower(domain)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.known[key]; !ok {
+		return ErrUnknownPluginDomain
+	}
+	p.paused[key] = struct{}{}
+	return nil
+}
+
+// Resume re-enables pluginName for domain.
+func (p *PauseSet) Resume(pluginName, domain string) error {
+	key := PausedPair{Plugin: pluginName, Domain: strings.ToLower(domain)}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.known[key]; !ok {
+		return ErrUnknownPluginDomain
+	}
+	delete(p.paused, key)
+	return nil
+}
+
+// Domains returns the sorted domains pluginName can be paused for, i.e.
+// its resolved domains.
+func (p *PauseSet) Domains(pluginName string) []string {
+	var domains []string
+	for k := range p.known {
+		if k.Plugin == pluginName {
+			domains = append(domains, k.Domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// IsPaused reports whether pluginName is paused for domain (lowercased).
+// A nil PauseSet pauses nothing.
+func (p *PauseSet) IsPaused(pluginName, domain string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.paused[PausedPair{Plugin: pluginName, Domain: domain}]
+	return ok
+}
+
+// Paused returns all paused pairs sorted by plugin, then domain.
+func (p *PauseSet) Paused() []PausedPair {
+	p.mu.RLock()
+	out := make([]PausedPair, 0, len(p.paused))
+	for k := range p.paused {
+		out = append(out, k)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Plugin != out[j].Plugin {
+			return out[i].Plugin < out[j].Plugin
+		}
+		return out[i].Domain < out[j].Domain
+	})
+	return out
+}
+
+// PausedDomains returns the sorted domains paused for pluginName.
+func (p *PauseSet) PausedDomains(pluginName string) []string {
+	domains := []string{}
+	for _, pair := range p.Paused() {
+		if pair.Plugin == pluginName {
+			domains = append(domains, pair.Domain)
+		}
+	}
+	return domains
+}
@@ -54,13 +54,34 @@ type ContentFilter interface {
 	Filter(req *http.Request, resp *http.Response, body []byte) ([]byte, FilterResult, error)
 }
 
+// RequestFilter is an optional interface a ContentFilter may implement to
+// modify requests to its domains before they are forwarded upstream.
+type RequestFilter interface {
+	// FilterRequest may rewrite the request URL, method, and headers.
+	// A plugin that replaces req.Body must also set req.ContentLength to
+	// the new length (or -1 for chunked) and update the Content-Length
+	// header to match. Returning an error ends the MITM session.
+	FilterRequest(req *http.Request) error
+}
+
 // FilterResult reports what the plugin did with a response.
+//
+// StatusCode and Header let a plugin neutralize a response rather than
+// only rewrite its body, e.g. turn a 200 carrying an ad into a 204 No
+// Content. A nonzero StatusCode (200-599) replaces the response status;
+// for a status that cannot carry a body the returned body is dropped. Each
+// Header entry replaces that header on the response, and an entry with no
+// values deletes it. The zero values leave the status and headers
+// unchanged.
 type FilterResult struct {
 	Matched  bool        // true if the response contained filterable content
 	Modified bool        // true if the body was actually changed
 	Rule     string      // which rule matched (for stats/logging), empty if no match
 	Removed  int         // number of content elements removed in this response
 	Rules    []RuleMatch // all matching rules (nil for single-rule plugins)
+
+	StatusCode int
+	Header     http.Header
 }
 
 // RuleMatch holds per-rule match info for multi-rule plugins.
@@ -4,15 +4,20 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/mitm"
 )
 
 // --- Marker tests ---
@@ -209,6 +214,23 @@ func TestInitPluginsDomainNotInMITM(t *testing.T) {
 	assert.Contains(t, err.Error(), "not in mitm.domains")
 }
 
+func TestInitPluginsDomainUnderMITMWildcard(t *testing.T) {
+	Registry["wildcard-test"] = func() ContentFilter {
+		return &mockFilter{name: "wildcard-test", domains: []string{"www.example.com", "example.com"}}
+	}
+	defer delete(Registry, "wildcard-test")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	configs := map[string]PluginConfig{
+		"wildcard-test": {Enabled: true},
+	}
+
+	_, err := InitPlugins(configs, []string{"*.example.com"}, logger)
+	require.NoError(t, err)
+	_, err = InitPlugins(configs, []string{"*.other.com"}, logger)
+	require.ErrorContains(t, err, "not in mitm.domains")
+}
+
 func TestInitPluginsDuplicatePriority(t *testing.T) {
 	Registry["dup-a"] = func() ContentFilter {
 		return &mockFilter{name: "dup-a", domains: []string{"shared.com"}}
@@ -221,15 +243,23 @@ func TestInitPluginsDuplicatePriority(t *testing.T) {
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 
-	// Same priority on same domain: error.
+	// Same priority on same domain: both chain, ordered by name.
 	configs := map[string]PluginConfig{
-		"dup-a": {Enabled: true, Priority: 100},
 		"dup-b": {Enabled: true, Priority: 100},
+		"dup-a": {Enabled: true, Priority: 100},
 	}
 
-	_, err := InitPlugins(configs, []string{"shared.com"}, logger)
+	results, err := InitPlugins(configs, []string{"shared.com"}, logger)
+	require.NoError(t, err)
+	chain := DomainPlugins(results)["shared.com"]
+	require.Len(t, chain, 2)
+	assert.Equal(t, "dup-a", chain[0].Plugin.Name())
+	assert.Equal(t, "dup-b", chain[1].Plugin.Name())
+
+	// Exclusive mode still refuses the shared domain.
+	err = CheckExclusiveDomains(results)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "priority 100 on domain")
+	assert.Contains(t, err.Error(), `domain "shared.com" already claimed by plugin "dup-a"`)
 }
 
 func TestInitPluginsSharedDomainDifferentPriority(t *testing.T) {
@@ -253,6 +283,66 @@ func TestInitPluginsSharedDomainDifferentPriority(t *testing.T) {
 	assert.Len(t, results, 2)
 }
 
+func TestDomainPluginsAndExclusive(t *testing.T) {
+	results := []InitResult{
+		{Plugin: &mockFilter{name: "reddit"}, Config: PluginConfig{Priority: 200, Domains: []string{"www.reddit.com"}}},
+		{Plugin: &mockFilter{name: "rewrite"}, Config: PluginConfig{Priority: 100, Domains: []string{"WWW.reddit.com", "example.com"}}},
+	}
+
+	byDomain := DomainPlugins(results)
+	require.Len(t, byDomain["www.reddit.com"], 2)
+	assert.Equal(t, "rewrite", byDomain["www.reddit.com"][0].Plugin.Name())
+	assert.Equal(t, "reddit", byDomain["www.reddit.com"][1].Plugin.Name())
+	assert.Len(t, byDomain["example.com"], 1)
+
+	err := CheckExclusiveDomains(results)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `plugin "reddit": domain "www.reddit.com" already claimed by plugin "rewrite"`)
+
+	assert.NoError(t, CheckExclusiveDomains(results[1:]))
+}
+
+func TestInitPluginsPriorityOrder(t *testing.T) {
+	// Names sort opposite to priority, so only priority can explain the
+	// order. The body records which plugin ran when.
+	for _, name := range []string{"order-a", "order-b", "order-c"} {
+		Registry[name] = func() ContentFilter {
+			return &mockFilter{
+				name:    name,
+				domains: []string{"builtin.com"},
+				filterFn: func(_ *http.Request, _ *http.Response, body []byte) ([]byte, FilterResult, error) {
+					return append(body, " "+name...), FilterResult{}, nil
+				},
+			}
+		}
+		defer delete(Registry, name)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	configs := map[string]PluginConfig{
+		"order-a": {Enabled: true, Priority: 300, Domains: []string{"shared.com"}},
+		"order-b": {Enabled: true, Domains: []string{"shared.com"}}, // default 100
+		"order-c": {Enabled: true, Priority: 50, Domains: []string{"shared.com"}},
+	}
+
+	for range 5 { // map iteration order varies between runs
+		results, err := InitPlugins(configs, []string{"shared.com"}, logger)
+		require.NoError(t, err)
+		names := make([]string, len(results))
+		for i, r := range results {
+			names[i] = r.Plugin.Name()
+		}
+		assert.Equal(t, []string{"order-c", "order-b", "order-a"}, names)
+
+		mod := BuildResponseModifier(results, nil, nil, nil, nil, logger)
+		req := &http.Request{URL: &url.URL{Path: "/"}, Method: "GET", Header: http.Header{}}
+		resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+		body, err := mod("shared.com", req, resp, []byte("body"))
+		require.NoError(t, err)
+		assert.Equal(t, "body order-c order-b order-a", string(body))
+	}
+}
+
 func TestInitPluginsConfigDomainOverride(t *testing.T) {
 	Registry["override-test"] = func() ContentFilter {
 		return &mockFilter{name: "override-test", domains: []string{"builtin.com"}}
@@ -277,7 +367,7 @@ func TestInitPluginsConfigDomainOverride(t *testing.T) {
 
 func TestBuildResponseModifierEmpty(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	mod := BuildResponseModifier(nil, nil, nil, logger)
+	mod := BuildResponseModifier(nil, nil, nil, nil, nil, logger)
 	assert.Nil(t, mod)
 }
 
@@ -318,7 +408,7 @@ func TestBuildResponseModifierDispatch(t *testing.T) {
 	}
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	mod := BuildResponseModifier(results, onInspect, onMatch, logger)
+	mod := BuildResponseModifier(results, nil, onInspect, onMatch, nil, logger)
 	require.NotNil(t, mod)
 
 	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
@@ -353,7 +443,7 @@ func TestBuildResponseModifierNoMatchPassthrough(t *testing.T) {
 	}
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	mod := BuildResponseModifier(results, onInspect, nil, logger)
+	mod := BuildResponseModifier(results, nil, onInspect, nil, nil, logger)
 	require.NotNil(t, mod)
 
 	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
@@ -410,7 +500,7 @@ func TestBuildResponseModifierChaining(t *testing.T) {
 	onMatch := func(name, rule string, _ bool, _ int) { matched = append(matched, name+":"+rule) }
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	mod := BuildResponseModifier(results, onInspect, onMatch, logger)
+	mod := BuildResponseModifier(results, nil, onInspect, onMatch, nil, logger)
 	require.NotNil(t, mod)
 
 	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
@@ -424,6 +514,126 @@ func TestBuildResponseModifierChaining(t *testing.T) {
 	assert.Equal(t, []string{"plugin-a:upper", "plugin-b:append"}, matched)
 }
 
+func orderTestResults() []InitResult {
+	appendFilter := func(name string) *mockFilter {
+		return &mockFilter{
+			name:    name,
+			version: "1.0",
+			domains: []string{"order.com"},
+			filterFn: func(_ *http.Request, _ *http.Response, body []byte) ([]byte, FilterResult, error) {
+				return append(body, " "+name...), FilterResult{}, nil
+			},
+		}
+	}
+	cfg := func(priority int) PluginConfig {
+		return PluginConfig{
+			Enabled: true, Mode: ModeFilter, Domains: []string{"order.com"},
+			Options: map[string]any{}, Priority: priority,
+		}
+	}
+	return []InitResult{
+		{Plugin: appendFilter("first"), Config: cfg(100)},
+		{Plugin: appendFilter("second"), Config: cfg(200)},
+	}
+}
+
+func TestBuildStagesConfiguredOrder(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+
+	stages, err := BuildStages(orderTestResults(), nil, nil, nil, nil, nil, nil, logger)
+	require.NoError(t, err)
+	body, err := mitm.NewPipeline(stages, 0, logger)("order.com", req, resp, []byte("body"))
+	require.NoError(t, err)
+	assert.Equal(t, "body first second", string(body), "default order follows priority")
+
+	stages, err = BuildStages(orderTestResults(), []string{"second", "first"}, nil, nil, nil, nil, nil, logger)
+	require.NoError(t, err)
+	body, err = mitm.NewPipeline(stages, 0, logger)("order.com", req, resp, []byte("body"))
+	require.NoError(t, err)
+	assert.Equal(t, "body second first", string(body), "configured order overrides priority")
+}
+
+func TestBuildStagesSkipsDomainlessPluginInOrder(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	results := orderTestResults()
+	results = append(results, InitResult{
+		Plugin: &mockFilter{name: "idle", version: "1.0"},
+		Config: PluginConfig{Enabled: true, Mode: ModeFilter, Options: map[string]any{}},
+	})
+
+	stages, err := BuildStages(results, []string{"second", "idle", "first"}, nil, nil, nil, nil, nil, logger)
+	require.NoError(t, err)
+	require.Len(t, stages, 2)
+	assert.Equal(t, "second", stages[0].Name)
+	assert.Equal(t, "first", stages[1].Name)
+
+	_, err = BuildStages(results, []string{"second", "idle", "idle", "first"}, nil, nil, nil, nil, nil, logger)
+	assert.Error(t, err, "duplicates are still rejected")
+}
+
+func TestBuildStagesRejectsBadOrder(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, order := range [][]string{
+		{"first"},
+		{"first", "second", "first"},
+		{"first", "second", "third"},
+	} {
+		_, err := BuildStages(orderTestResults(), order, nil, nil, nil, nil, nil, logger)
+		assert.Error(t, err, "order %v", order)
+	}
+}
+
+func TestBuildResponseModifierPluginErrorFailsOpen(t *testing.T) {
+	results := orderTestResults()
+	results[0].Plugin = &mockFilter{
+		name:    "first",
+		domains: []string{"order.com"},
+		filterFn: func(_ *http.Request, _ *http.Response, _ []byte) ([]byte, FilterResult, error) {
+			return nil, FilterResult{}, fmt.Errorf("broken")
+		},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildResponseModifier(results, nil, nil, nil, nil, logger)
+	require.NotNil(t, mod)
+
+	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+	body, err := mod("order.com", req, resp, []byte("body"))
+	require.NoError(t, err)
+	assert.Equal(t, "body second", string(body))
+}
+
+func TestBuildStagesPluginPanicFailsOpen(t *testing.T) {
+	results := orderTestResults()
+	results[0].Plugin = &mockFilter{
+		name:    "first",
+		domains: []string{"order.com"},
+		filterFn: func(_ *http.Request, _ *http.Response, _ []byte) ([]byte, FilterResult, error) {
+			var m map[string]int
+			m["boom"]++ // nil map write
+			return nil, FilterResult{}, nil
+		},
+	}
+	var panics []string
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	stages, err := BuildStages(results, nil, nil, nil, nil, nil, func(name string) {
+		panics = append(panics, name)
+	}, logger)
+	require.NoError(t, err)
+	mod := mitm.NewPipeline(stages, 0, logger)
+
+	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+	for range 2 {
+		body, err := mod("order.com", req, resp, []byte("body"))
+		require.NoError(t, err)
+		assert.Equal(t, "body second", string(body), "later stages still run")
+	}
+	assert.Equal(t, []string{"first", "first"}, panics)
+}
+
 func TestBuildResponseModifierMultiRuleReport(t *testing.T) {
 	mock := &mockFilter{
 		name:    "multi",
@@ -457,7 +667,7 @@ func TestBuildResponseModifierMultiRuleReport(t *testing.T) {
 	}
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	mod := BuildResponseModifier(results, nil, onMatch, logger)
+	mod := BuildResponseModifier(results, nil, nil, onMatch, nil, logger)
 
 	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
 	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
@@ -467,6 +677,286 @@ func TestBuildResponseModifierMultiRuleReport(t *testing.T) {
 	assert.Equal(t, []string{"multi:rule-a:3", "multi:rule-b:2"}, matches)
 }
 
+func TestBuildResponseModifierRecordsFilterDuration(t *testing.T) {
+	mock := &mockFilter{
+		name:    "slow",
+		version: "1.0",
+		domains: []string{"slow.com"},
+		filterFn: func(_ *http.Request, _ *http.Response, body []byte) ([]byte, FilterResult, error) {
+			time.Sleep(5 * time.Millisecond)
+			return body, FilterResult{}, nil
+		},
+	}
+
+	results := []InitResult{{
+		Plugin: mock,
+		Config: PluginConfig{
+			Enabled: true, Mode: ModeFilter, Domains: []string{"slow.com"},
+			Options: map[string]any{}, Priority: 100,
+		},
+	}}
+
+	var total time.Duration
+	var calls int
+	onDuration := func(name string, d time.Duration) {
+		assert.Equal(t, "slow", name)
+		total += d
+		calls++
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildResponseModifier(results, nil, nil, nil, onDuration, logger)
+
+	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+	for range 2 {
+		_, err := mod("slow.com", req, resp, []byte("input"))
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 2, calls)
+	assert.GreaterOrEqual(t, total, 10*time.Millisecond)
+}
+
+func TestBuildResponseModifierPausedDomain(t *testing.T) {
+	mock := &mockFilter{
+		name:    "multi-domain",
+		version: "1.0",
+		domains: []string{"www.reddit.com", "gql-fed.reddit.com"},
+		filterFn: func(_ *http.Request, _ *http.Response, _ []byte) ([]byte, FilterResult, error) {
+			return []byte("filtered"), FilterResult{Matched: true, Modified: true, Rule: "r"}, nil
+		},
+	}
+
+	results := []InitResult{{
+		Plugin: mock,
+		Config: PluginConfig{
+			Enabled: true, Mode: ModeFilter, Domains: []string{"www.reddit.com", "gql-fed.reddit.com"},
+			Options: map[string]any{}, Priority: 100,
+		},
+	}}
+
+	pauses := NewPauseSet(results)
+	require.NoError(t, pauses.Pause("multi-domain", "GQL-Fed.reddit.com"))
+	assert.ErrorIs(t, pauses.Pause("multi-domain", "old.reddit.com"), ErrUnknownPluginDomain)
+	assert.ErrorIs(t, pauses.Pause("other", "www.reddit.com"), ErrUnknownPluginDomain)
+	assert.Equal(t, []PausedPair{{Plugin: "multi-domain", Domain: "gql-fed.reddit.com"}}, pauses.Paused())
+	assert.Equal(t, []string{"gql-fed.reddit.com"}, pauses.PausedDomains("multi-domain"))
+
+	var inspected []string
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildResponseModifier(results, pauses, func(name string) { inspected = append(inspected, name) }, nil, nil, logger)
+
+	req := &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET"}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+
+	body, err := mod("gql-fed.reddit.com", req, resp, []byte("original"))
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(body), "paused domain passes through")
+
+	body, err = mod("www.reddit.com", req, resp, []byte("original"))
+	require.NoError(t, err)
+	assert.Equal(t, "filtered", string(body), "other domain still filters")
+	assert.Equal(t, []string{"multi-domain"}, inspected)
+
+	require.NoError(t, pauses.Resume("multi-domain", "gql-fed.reddit.com"))
+	body, err = mod("gql-fed.reddit.com", req, resp, []byte("original"))
+	require.NoError(t, err)
+	assert.Equal(t, "filtered", string(body))
+	assert.Empty(t, pauses.Paused())
+}
+
+// mockRequestFilter is a mockFilter that also implements RequestFilter.
+type mockRequestFilter struct {
+	mockFilter
+	requestFn func(*http.Request) error
+}
+
+func (m *mockRequestFilter) FilterRequest(req *http.Request) error {
+	return m.requestFn(req)
+}
+
+func TestBuildRequestModifierEmpty(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	results := []InitResult{{
+		Plugin: &mockFilter{name: "response-only", domains: []string{"example.com"}},
+		Config: PluginConfig{Enabled: true, Domains: []string{"example.com"}, Options: map[string]any{}},
+	}}
+	assert.Nil(t, BuildRequestModifier(results, nil, logger))
+}
+
+func TestBuildRequestModifierDispatch(t *testing.T) {
+	tagger := func(tag string) func(*http.Request) error {
+		return func(req *http.Request) error {
+			req.Header.Add("X-Plugins", tag)
+			return nil
+		}
+	}
+	first := &mockRequestFilter{
+		mockFilter: mockFilter{name: "first", domains: []string{"example.com"}},
+		requestFn:  tagger("first"),
+	}
+	second := &mockRequestFilter{
+		mockFilter: mockFilter{name: "second", domains: []string{"example.com", "other.com"}},
+		requestFn:  tagger("second"),
+	}
+	failing := &mockRequestFilter{
+		mockFilter: mockFilter{name: "failing", domains: []string{"fail.com"}},
+		requestFn:  func(*http.Request) error { return fmt.Errorf("rejected") },
+	}
+	results := []InitResult{
+		{Plugin: second, Config: PluginConfig{Enabled: true, Domains: second.domains, Options: map[string]any{}, Priority: 200}},
+		{Plugin: first, Config: PluginConfig{Enabled: true, Domains: first.domains, Options: map[string]any{}, Priority: 100}},
+		{Plugin: failing, Config: PluginConfig{Enabled: true, Domains: failing.domains, Options: map[string]any{}, Priority: 300}},
+	}
+
+	pauses := NewPauseSet(results)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildRequestModifier(results, pauses, logger)
+	require.NotNil(t, mod)
+
+	newReq := func() *http.Request {
+		return &http.Request{URL: &url.URL{Path: "/test"}, Method: "GET", Header: http.Header{}}
+	}
+
+	req := newReq()
+	require.NoError(t, mod("Example.com", req))
+	assert.Equal(t, []string{"first", "second"}, req.Header.Values("X-Plugins"), "priority order")
+
+	req = newReq()
+	require.NoError(t, mod("unrelated.com", req))
+	assert.Empty(t, req.Header.Values("X-Plugins"))
+
+	require.NoError(t, pauses.Pause("first", "example.com"))
+	req = newReq()
+	require.NoError(t, mod("example.com", req))
+	assert.Equal(t, []string{"second"}, req.Header.Values("X-Plugins"), "paused plugin skipped")
+
+	err := mod("fail.com", newReq())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "plugin failing: rejected")
+}
+
+func TestBuildResponseModifierStatusAndHeaders(t *testing.T) {
+	mock := &mockFilter{
+		name:    "neutralize",
+		version: "1.0",
+		domains: []string{"ads.example.com"},
+		filterFn: func(req *http.Request, _ *http.Response, body []byte) ([]byte, FilterResult, error) {
+			switch req.URL.Path {
+			case "/ad":
+				return nil, FilterResult{
+					Matched: true, Modified: true, Rule: "ad-endpoint",
+					StatusCode: http.StatusNoContent,
+					Header:     http.Header{"x-neutralized": {"1"}, "Content-Type": nil},
+				}, nil
+			case "/bad":
+				return nil, FilterResult{StatusCode: 42}, nil
+			}
+			return body, FilterResult{}, nil
+		},
+	}
+	results := []InitResult{{
+		Plugin: mock,
+		Config: PluginConfig{Enabled: true, Mode: ModeFilter, Domains: mock.domains, Options: map[string]any{}},
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildResponseModifier(results, nil, nil, nil, nil, logger)
+	require.NotNil(t, mod)
+
+	newResp := func() *http.Response {
+		return &http.Response{StatusCode: 200, Status: "200 OK", Header: http.Header{"Content-Type": {"application/json"}}}
+	}
+
+	resp := newResp()
+	req := &http.Request{URL: &url.URL{Path: "/ad"}, Method: "GET", Header: http.Header{}}
+	body, err := mod("ads.example.com", req, resp, []byte(`{"ad":1}`))
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "204 No Content", resp.Status)
+	assert.Equal(t, "1", resp.Header.Get("X-Neutralized"))
+	assert.Empty(t, resp.Header.Get("Content-Type"), "empty header entry deletes")
+
+	// Zero values leave the response alone.
+	resp = newResp()
+	req = &http.Request{URL: &url.URL{Path: "/other"}, Method: "GET", Header: http.Header{}}
+	body, err = mod("ads.example.com", req, resp, []byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	// An invalid status fails the stage, which the pipeline skips.
+	resp = newResp()
+	req = &http.Request{URL: &url.URL{Path: "/bad"}, Method: "GET", Header: http.Header{}}
+	body, err = mod("ads.example.com", req, resp, []byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestBuildResponseModifierUserAgentMatch(t *testing.T) {
+	mock := &mockFilter{
+		name:    "app-only",
+		version: "1.0",
+		domains: []string{"gql-fed.reddit.com"},
+		filterFn: func(_ *http.Request, _ *http.Response, _ []byte) ([]byte, FilterResult, error) {
+			return []byte("filtered"), FilterResult{Matched: true, Modified: true, Rule: "r"}, nil
+		},
+	}
+	results := []InitResult{{
+		Plugin: mock,
+		Config: PluginConfig{
+			Enabled: true, Mode: ModeFilter, Domains: []string{"gql-fed.reddit.com"},
+			Options: map[string]any{"user_agent_match": `^Reddit/`}, Priority: 100,
+		},
+	}}
+
+	var inspected int
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildResponseModifier(results, nil, func(string) { inspected++ }, nil, nil, logger)
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+
+	for _, tc := range []struct {
+		ua   string
+		want string
+	}{
+		{"Reddit/Version 2024.50.0/Build 1234/iOS Version 18.1", "filtered"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15", "original"},
+		{"", "original"},
+	} {
+		req := &http.Request{URL: &url.URL{Path: "/"}, Method: "POST", Header: http.Header{}}
+		if tc.ua != "" {
+			req.Header.Set("User-Agent", tc.ua)
+		}
+		body, err := mod("gql-fed.reddit.com", req, resp, []byte("original"))
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, string(body), "user agent %q", tc.ua)
+	}
+	assert.Equal(t, 1, inspected, "non-matching user agents bypass the plugin entirely")
+}
+
+func TestInitPluginsInvalidUserAgentMatch(t *testing.T) {
+	Registry["ua-test"] = func() ContentFilter {
+		return &mockFilter{name: "ua-test", domains: []string{"example.com"}}
+	}
+	defer delete(Registry, "ua-test")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for opt, want := range map[any]string{
+		"(": "user_agent_match: error parsing regexp",
+		42:  "user_agent_match must be a string",
+	} {
+		configs := map[string]PluginConfig{
+			"ua-test": {Enabled: true, Options: map[string]any{"user_agent_match": opt}},
+		}
+		_, err := InitPlugins(configs, []string{"example.com"}, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), want)
+	}
+}
+
 // --- Interception filter tests ---
 
 func TestInterceptionFilterCapture(t *testing.T) {
@@ -549,6 +1039,131 @@ func TestInterceptionFilterSequencing(t *testing.T) {
 	assert.Equal(t, `{"b":2}`, string(bodyData))
 }
 
+func TestInterceptionFilterSampleRate(t *testing.T) {
+	f := NewInterceptionFilter("test-sample", "0.1.0", []string{"example.com"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	err := f.Init(&PluginConfig{
+		Enabled: true,
+		Mode:    ModeIntercept,
+		Options: map[string]any{"data_dir": t.TempDir(), "sample_rate": 0.1},
+	}, logger)
+	require.NoError(t, err)
+	f.sample = rand.New(rand.NewPCG(1, 2)).Float64 //nolint:gosec // deterministic test source
+
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/"}, Host: "example.com", Header: http.Header{}}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": []string{"text/plain"}}}
+
+	const n = 5000
+	for range n {
+		out, _, filterErr := f.Filter(req, resp, []byte("x"))
+		require.NoError(t, filterErr)
+		assert.Equal(t, []byte("x"), out)
+	}
+
+	entries, err := os.ReadDir(f.outputDir)
+	require.NoError(t, err)
+	captured := len(entries) / 3
+	assert.InDelta(t, 0.1, float64(captured)/n, 0.02, "captured %d of %d", captured, n)
+}
+
+func TestInterceptionFilterMaxCaptures(t *testing.T) {
+	f := NewInterceptionFilter("test-max", "0.1.0", []string{"example.com"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	err := f.Init(&PluginConfig{
+		Enabled: true,
+		Mode:    ModeIntercept,
+		Options: map[string]any{"data_dir": t.TempDir(), "sample_rate": 1, "max_captures": 2},
+	}, logger)
+	require.NoError(t, err)
+
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/"}, Host: "example.com", Header: http.Header{}}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": []string{"text/plain"}}}
+	for range 5 {
+		_, _, _ = f.Filter(req, resp, []byte("x"))
+	}
+
+	entries, err := os.ReadDir(f.outputDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 6) // 001-* and 002-* only
+}
+
+func TestInterceptionFilterCompressedRoundTrip(t *testing.T) {
+	f := NewInterceptionFilter("test-gz", "0.1.0", []string{"example.com"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	err := f.Init(&PluginConfig{
+		Enabled: true,
+		Mode:    ModeIntercept,
+		Options: map[string]any{"data_dir": t.TempDir(), "compress_captures": true},
+	}, logger)
+	require.NoError(t, err)
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Scheme: "https", Host: "example.com", Path: "/feed"},
+		Host:   "example.com",
+		Header: http.Header{"Accept": []string{"text/html"}},
+	}
+	htmlResp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": []string{"text/html"}}}
+	jsonResp := &http.Response{StatusCode: 404, Header: http.Header{"Content-Type": []string{"application/json"}}}
+	page := []byte(strings.Repeat("<div class=\"post\">promoted</div>\n", 200))
+	_, _, _ = f.Filter(req, htmlResp, page)
+	_, _, _ = f.Filter(req, jsonResp, []byte(`{"error":"not found"}`))
+
+	// Bodies are gzipped; metadata stays plain JSON.
+	raw, err := os.ReadFile(filepath.Join(f.outputDir, "001-body.html.gz"))
+	require.NoError(t, err)
+	assert.Less(t, len(raw), len(page)/2)
+	meta, err := os.ReadFile(filepath.Join(f.outputDir, "001-req.json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(meta), `"method": "GET"`)
+
+	captures, err := ReadCaptures(f.outputDir)
+	require.NoError(t, err)
+	require.Len(t, captures, 2)
+	assert.Equal(t, 1, captures[0].Seq)
+	assert.Equal(t, page, captures[0].Body)
+	assert.Equal(t, "https://example.com/feed", captures[0].Request.URL)
+	assert.Equal(t, "text/html", captures[0].Response.Headers["Content-Type"])
+	assert.Equal(t, 404, captures[1].Response.Status)
+	assert.JSONEq(t, `{"error":"not found"}`, string(captures[1].Body))
+}
+
+func TestReadCapturesUncompressed(t *testing.T) {
+	f := NewInterceptionFilter("test-plain", "0.1.0", []string{"example.com"})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	require.NoError(t, f.Init(&PluginConfig{
+		Enabled: true,
+		Mode:    ModeIntercept,
+		Options: map[string]any{"data_dir": t.TempDir()},
+	}, logger))
+
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/"}, Host: "example.com", Header: http.Header{}}
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": []string{"text/plain"}}}
+	_, _, _ = f.Filter(req, resp, []byte("hello"))
+
+	captures, err := ReadCaptures(f.outputDir)
+	require.NoError(t, err)
+	require.Len(t, captures, 1)
+	assert.Equal(t, []byte("hello"), captures[0].Body)
+	assert.Equal(t, filepath.Join(f.outputDir, "001-body.txt"), captures[0].BodyFile)
+}
+
+func TestInterceptionFilterInvalidSampling(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, opts := range []map[string]any{
+		{"sample_rate": 0.0},
+		{"sample_rate": 1.5},
+		{"sample_rate": "often"},
+		{"max_captures": -1},
+		{"compress_captures": "yes"},
+	} {
+		opts["data_dir"] = t.TempDir()
+		f := NewInterceptionFilter("test-invalid", "0.1.0", nil)
+		err := f.Init(&PluginConfig{Enabled: true, Mode: ModeIntercept, Options: opts}, logger)
+		assert.Error(t, err, "%v", opts)
+	}
+}
+
 func TestInterceptionFilterOutputDirPermissions(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -597,3 +1212,38 @@ func TestBodyExtension(t *testing.T) {
 }
 
 // Reddit registration and filter tests are in reddit_test.go.
+
+func TestBuildResponseModifierRecordsTrace(t *testing.T) {
+	stripper := &mockFilter{
+		name:    "stripper",
+		domains: []string{"example.com"},
+		filterFn: func(_ *http.Request, _ *http.Response, body []byte) ([]byte, FilterResult, error) {
+			return []byte(strings.ReplaceAll(string(body), "<ad/>", "")), FilterResult{
+				Matched: true, Modified: true, Rule: "ad-tag", Removed: 2,
+			}, nil
+		},
+	}
+	noop := &mockFilter{name: "noop", domains: []string{"example.com"}}
+	results := []InitResult{
+		{Plugin: stripper, Config: PluginConfig{Priority: 100, Domains: stripper.domains}},
+		{Plugin: noop, Config: PluginConfig{Priority: 200, Domains: noop.domains}},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mod := BuildResponseModifier(results, nil, nil, nil, nil, logger)
+
+	trace := &headers.Trace{Path: "mitm"}
+	req := (&http.Request{URL: &url.URL{Path: "/"}, Method: "GET", Header: http.Header{}}).
+		WithContext(headers.WithTrace(t.Context(), trace))
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Content-Type": {"text/html"}}}
+	_, err := mod("example.com", req, resp, []byte("<p>a<ad/>b<ad/></p>"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"stripper", "noop"}, trace.Plugins)
+	assert.Equal(t, []string{"stripper/ad-tag"}, trace.Rules)
+
+	// Requests without a trace are filtered as usual.
+	req = &http.Request{URL: &url.URL{Path: "/"}, Method: "GET", Header: http.Header{}}
+	body, err := mod("example.com", req, resp, []byte("<ad/>x"))
+	require.NoError(t, err)
+	assert.Equal(t, "x", string(body))
+}
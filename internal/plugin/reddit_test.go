@@ -1,6 +1,8 @@
 package plugin
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
 	"io"
@@ -12,6 +14,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -97,6 +100,52 @@ func TestFeedAdRemoval(t *testing.T) {
 	assert.Contains(t, string(out), "interesting")
 }
 
+func TestFeedAdRemovalGzipped(t *testing.T) {
+	// Upstreams that gzip despite the stripped Accept-Encoding: the
+	// response pipeline decodes before the plugin and re-encodes after.
+	r := newRedditFilter(t, PlaceholderNone)
+	results := []InitResult{{Plugin: r, Config: PluginConfig{
+		Enabled: true, Mode: ModeFilter, Placeholder: PlaceholderNone,
+		Domains: []string{"www.reddit.com"}, Options: map[string]any{},
+	}}}
+	mod := BuildResponseModifier(results, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NotNil(t, mod)
+
+	resp := makeResp()
+	resp.Header.Set("Content-Encoding", "gzip")
+	out, err := mod("www.reddit.com", makeReq("/"), resp, loadFixture(t, "feed_with_ad.html.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(bytes.NewReader(out))
+	require.NoError(t, err)
+	plain, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.NotContains(t, string(plain), "shreddit-ad-post")
+	assert.Contains(t, string(plain), "mildlyinfuriating")
+}
+
+func TestFeedAdRemovalBrotli(t *testing.T) {
+	r := newRedditFilter(t, PlaceholderNone)
+	results := []InitResult{{Plugin: r, Config: PluginConfig{
+		Enabled: true, Mode: ModeFilter, Placeholder: PlaceholderNone,
+		Domains: []string{"www.reddit.com"}, Options: map[string]any{},
+	}}}
+	mod := BuildResponseModifier(results, nil, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NotNil(t, mod)
+
+	resp := makeResp()
+	resp.Header.Set("Content-Encoding", "br")
+	out, err := mod("www.reddit.com", makeReq("/"), resp, loadFixture(t, "feed_with_ad.html.br"))
+	require.NoError(t, err)
+	assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+
+	plain, err := io.ReadAll(brotli.NewReader(bytes.NewReader(out)))
+	require.NoError(t, err)
+	assert.NotContains(t, string(plain), "shreddit-ad-post")
+	assert.Contains(t, string(plain), "mildlyinfuriating")
+}
+
 func TestFeedAdRemovalWithVisiblePlaceholder(t *testing.T) {
 	r := newRedditFilter(t, PlaceholderVisible)
 	body := loadFixture(t, "feed_with_ad.html")
@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"runtime/debug"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
 	"github.com/ushineko/face-puncher-supreme/internal/mitm"
 )
 
@@ -37,6 +41,15 @@ type OnPluginInspect func(pluginName string)
 // Parameters: pluginName, rule, modified (whether body was changed), removed count.
 type OnFilterMatch func(pluginName, rule string, modified bool, removed int)
 
+// OnFilterDuration is called by the response modifier after each plugin
+// Filter call with the time it took (measured on the monotonic clock).
+type OnFilterDuration func(pluginName string, d time.Duration)
+
+// OnPluginPanic is called when a plugin's Filter panics. The panic is
+// recovered and the response continues through the pipeline unfiltered by
+// that plugin.
+type OnPluginPanic func(pluginName string)
+
 // InitResult holds an initialized plugin and its resolved configuration.
 type InitResult struct {
 	Plugin ContentFilter
@@ -44,24 +57,49 @@ type InitResult struct {
 }
 
 // InitPlugins initializes all enabled plugins from config, validates domain
-// assignments, and returns the initialized plugins.
+// assignments, and returns the initialized plugins sorted by effective
+// priority (lower first, ties by name), the order they run in. Plugins are
+// initialized in name order so startup logs and errors are
+// deterministic.
 func InitPlugins(
 	configs map[string]PluginConfig,
 	mitmDomains []string,
 	logger *slog.Logger,
 ) ([]InitResult, error) {
-	// Build MITM domain set for validation.
+	// Build MITM domain set for validation. "*.example.com" entries cover
+	// the base domain and every subdomain.
 	mitmSet := make(map[string]struct{}, len(mitmDomains))
+	var mitmSuffixes []string
 	for _, d := range mitmDomains {
-		mitmSet[strings.ToLower(d)] = struct{}{}
+		d = strings.ToLower(d)
+		if suffix, ok := strings.CutPrefix(d, "*."); ok {
+			mitmSuffixes = append(mitmSuffixes, suffix)
+		} else {
+			mitmSet[d] = struct{}{}
+		}
+	}
+	intercepted := func(domain string) bool {
+		if _, ok := mitmSet[domain]; ok {
+			return true
+		}
+		for _, suffix := range mitmSuffixes {
+			if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+				return true
+			}
+		}
+		return false
 	}
 
-	// Track domain+priority to detect conflicts.
-	// Key: "domain:priority", value: plugin name.
-	domainPriority := make(map[string]string)
 	var results []InitResult
 
-	for name, cfg := range configs {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cfg := configs[name]
 		if !cfg.Enabled {
 			logger.Debug("plugin disabled", "name", name)
 			continue
@@ -107,22 +145,21 @@ func InitPlugins(
 			domains = p.Domains()
 		}
 
-		// Validate domains are in MITM list and no duplicate priorities per domain.
+		// Validate domains are in MITM list. Plugins may share a domain,
+		// even at equal priority: they chain, ties broken by name.
 		for _, d := range domains {
-			dl := strings.ToLower(d)
-			if _, ok := mitmSet[dl]; !ok {
+			if !intercepted(strings.ToLower(d)) {
 				return nil, fmt.Errorf("plugin %q: domain %q is not in mitm.domains (plugin cannot fire for non-intercepted domains)", name, d)
 			}
-			key := fmt.Sprintf("%s:%d", dl, cfg.Priority)
-			if owner, exists := domainPriority[key]; exists {
-				return nil, fmt.Errorf("plugin %q: priority %d on domain %q conflicts with plugin %q", name, cfg.Priority, d, owner)
-			}
-			domainPriority[key] = name
 		}
 
 		// Override config domains with the resolved list.
 		cfg.Domains = domains
 
+		if _, err := userAgentMatch(cfg.Options); err != nil {
+			return nil, fmt.Errorf("plugin %q: %w", name, err)
+		}
+
 		// Initialize the plugin.
 		if err := p.Init(&cfg, logger.With("plugin", name)); err != nil {
 			return nil, fmt.Errorf("plugin %q: init failed: %w", name, err)
@@ -140,107 +177,358 @@ func InitPlugins(
 		results = append(results, InitResult{Plugin: p, Config: cfg})
 	}
 
+	sortByPriority(results)
 	return results, nil
 }
 
+// DomainPlugins maps each lowercased plugin domain to the plugins that
+// handle it, in priority order: the chain a response for that domain
+// passes through.
+func DomainPlugins(results []InitResult) map[string][]InitResult {
+	ordered := append([]InitResult(nil), results...)
+	sortByPriority(ordered)
+	byDomain := make(map[string][]InitResult)
+	for _, r := range ordered {
+		for _, d := range r.Config.Domains {
+			dl := strings.ToLower(d)
+			byDomain[dl] = append(byDomain[dl], r)
+		}
+	}
+	return byDomain
+}
+
+// CheckExclusiveDomains returns an error if any domain is handled by more
+// than one plugin, for setups that want one plugin per domain instead of
+// a chain.
+func CheckExclusiveDomains(results []InitResult) error {
+	byDomain := DomainPlugins(results)
+	domains := make([]string, 0, len(byDomain))
+	for d := range byDomain {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	for _, d := range domains {
+		if chain := byDomain[d]; len(chain) > 1 {
+			return fmt.Errorf("plugin %q: domain %q already claimed by plugin %q",
+				chain[1].Plugin.Name(), d, chain[0].Plugin.Name())
+		}
+	}
+	return nil
+}
+
+// sortByPriority orders results by priority (lower first), then by name.
+func sortByPriority(results []InitResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Config.Priority != results[j].Config.Priority {
+			return results[i].Config.Priority < results[j].Config.Priority
+		}
+		return results[i].Plugin.Name() < results[j].Plugin.Name()
+	})
+}
+
 // BuildResponseModifier creates a ResponseModifier that dispatches to
 // plugins based on domain. Multiple plugins can handle the same domain,
 // executing in priority order (lower number first). Each plugin receives
-// the output of the previous one.
+// the output of the previous one. Plugins paused for a domain in paused
+// (may be nil) are skipped for that domain.
 func BuildResponseModifier(
 	results []InitResult,
+	paused *PauseSet,
 	onInspect OnPluginInspect,
 	onMatch OnFilterMatch,
+	onDuration OnFilterDuration,
 	logger *slog.Logger,
 ) mitm.ResponseModifier {
-	if len(results) == 0 {
-		return nil
+	stages, _ := BuildStages(results, nil, paused, onInspect, onMatch, onDuration, nil, logger) //nolint:errcheck // nil order cannot fail
+	return mitm.NewPipeline(stages, 0, logger)
+}
+
+// BuildStages returns one response pipeline stage per plugin. With an
+// empty order, stages run by priority (lower number first, ties by name);
+// otherwise order lists every plugin name exactly once. Plugins with no
+// domains get no stage; order may still name them, matching config
+// validation, which cannot see plugin default domains. A stage only acts
+// on its plugin's domains and is a no-op where the plugin is paused. A
+// panicking plugin fails its stage like an error and is reported to
+// onPanic (may be nil).
+func BuildStages(
+	results []InitResult,
+	order []string,
+	paused *PauseSet,
+	onInspect OnPluginInspect,
+	onMatch OnFilterMatch,
+	onDuration OnFilterDuration,
+	onPanic OnPluginPanic,
+	logger *slog.Logger,
+) ([]mitm.Stage, error) {
+	byName := make(map[string]InitResult, len(results))
+	idle := make(map[string]bool)
+	for _, r := range results {
+		if len(r.Config.Domains) == 0 {
+			idle[r.Plugin.Name()] = true
+			continue
+		}
+		byName[r.Plugin.Name()] = r
+	}
+
+	var ordered []InitResult
+	if len(order) == 0 {
+		for _, r := range byName {
+			ordered = append(ordered, r)
+		}
+		sortByPriority(ordered)
+	} else {
+		seen := make(map[string]bool, len(order))
+		for _, name := range order {
+			if seen[name] {
+				return nil, fmt.Errorf("response pipeline: %q listed more than once", name)
+			}
+			seen[name] = true
+			r, ok := byName[name]
+			switch {
+			case ok:
+				ordered = append(ordered, r)
+			case idle[name]:
+				logger.Warn("response pipeline: plugin has no domains, skipping", "plugin", name)
+			default:
+				return nil, fmt.Errorf("response pipeline: %q is not an active plugin", name)
+			}
+		}
+		for name := range byName {
+			if !seen[name] {
+				return nil, fmt.Errorf("response pipeline: active plugin %q is missing from the order", name)
+			}
+		}
 	}
 
-	type entry struct {
-		plugin   ContentFilter
-		cfg      PluginConfig
-		priority int
+	stages := make([]mitm.Stage, 0, len(ordered))
+	for _, r := range ordered {
+		stages = append(stages, mitm.Stage{
+			Name:   r.Plugin.Name(),
+			Modify: pluginStage(r, paused, onInspect, onMatch, onDuration, onPanic, logger),
+		})
+	}
+	return stages, nil
+}
+
+// BuildRequestModifier creates a RequestModifier that dispatches to plugins
+// implementing RequestFilter, in priority order (lower number first), under
+// the same domain, pause, and user_agent_match scoping as their response
+// filters. Returns nil if no active plugin filters requests.
+func BuildRequestModifier(results []InitResult, paused *PauseSet, logger *slog.Logger) mitm.RequestModifier {
+	type requestStage struct {
+		name    string
+		filter  RequestFilter
+		domains map[string]bool
+		uaMatch *regexp.Regexp
 	}
 
-	// Build domain → sorted list of entries.
-	lookup := map[string][]entry{}
+	var filters []InitResult
 	for _, r := range results {
-		e := entry{plugin: r.Plugin, cfg: r.Config, priority: r.Config.Priority}
+		if _, ok := r.Plugin.(RequestFilter); ok && len(r.Config.Domains) > 0 {
+			filters = append(filters, r)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	sortByPriority(filters)
+
+	stages := make([]requestStage, 0, len(filters))
+	for _, r := range filters {
+		st := requestStage{
+			name:    r.Plugin.Name(),
+			filter:  r.Plugin.(RequestFilter),
+			domains: make(map[string]bool, len(r.Config.Domains)),
+		}
 		for _, d := range r.Config.Domains {
-			dl := strings.ToLower(d)
-			lookup[dl] = append(lookup[dl], e)
+			st.domains[strings.ToLower(d)] = true
+		}
+		uaMatch, err := userAgentMatch(r.Config.Options)
+		if err != nil {
+			logger.Error("plugin user_agent_match ignored", "plugin", st.name, "error", err)
 		}
+		st.uaMatch = uaMatch
+		stages = append(stages, st)
 	}
 
-	// Sort each domain's entries by priority (ascending = lower runs first).
-	for d := range lookup {
-		sort.Slice(lookup[d], func(i, j int) bool {
-			return lookup[d][i].priority < lookup[d][j].priority
-		})
+	return func(domain string, req *http.Request) error {
+		domain = strings.ToLower(domain)
+		for _, st := range stages {
+			if !st.domains[domain] || paused.IsPaused(st.name, domain) {
+				continue
+			}
+			if st.uaMatch != nil && !st.uaMatch.MatchString(req.Header.Get("User-Agent")) {
+				continue
+			}
+			if err := st.filter.FilterRequest(req); err != nil {
+				return fmt.Errorf("plugin %s: %w", st.name, err)
+			}
+		}
+		return nil
 	}
+}
 
-	if len(lookup) == 0 {
-		return nil
+// userAgentMatch compiles Options["user_agent_match"], a regex a request's
+// User-Agent must match for the plugin to run. A missing option yields nil
+// (all user agents).
+func userAgentMatch(opts map[string]any) (*regexp.Regexp, error) {
+	v, ok := opts["user_agent_match"]
+	if !ok || v == nil {
+		return nil, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("user_agent_match must be a string, got %v", v)
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, fmt.Errorf("user_agent_match: %w", err)
+	}
+	return re, nil
+}
+
+// pluginStage wraps a single plugin's Filter as a pipeline stage.
+func pluginStage(
+	r InitResult,
+	paused *PauseSet,
+	onInspect OnPluginInspect,
+	onMatch OnFilterMatch,
+	onDuration OnFilterDuration,
+	onPanic OnPluginPanic,
+	logger *slog.Logger,
+) mitm.ResponseModifier {
+	p, cfg := r.Plugin, r.Config
+	domains := make(map[string]bool, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		domains[strings.ToLower(d)] = true
+	}
+
+	logMatches := false
+	if v, ok := cfg.Options["log_matches"]; ok {
+		if b, ok := v.(bool); ok {
+			logMatches = b
+		}
+	}
+
+	// InitPlugins rejects a bad pattern; one that slips through leaves the
+	// plugin unscoped.
+	uaMatch, err := userAgentMatch(cfg.Options)
+	if err != nil {
+		logger.Error("plugin user_agent_match ignored", "plugin", p.Name(), "error", err)
 	}
 
 	return func(domain string, req *http.Request, resp *http.Response, body []byte) ([]byte, error) {
-		entries, ok := lookup[strings.ToLower(domain)]
-		if !ok {
+		domain = strings.ToLower(domain)
+		if !domains[domain] || paused.IsPaused(p.Name(), domain) {
 			return body, nil
 		}
+		if uaMatch != nil && !uaMatch.MatchString(req.Header.Get("User-Agent")) {
+			return body, nil
+		}
+		if onInspect != nil {
+			onInspect(p.Name())
+		}
+		trace := headers.TraceFrom(req.Context())
+		if trace != nil {
+			trace.AddPlugin(p.Name())
+		}
 
-		current := body
-		for _, e := range entries {
-			if onInspect != nil {
-				onInspect(e.plugin.Name())
-			}
-
-			modified, result, err := e.plugin.Filter(req, resp, current)
-			if err != nil {
-				return nil, fmt.Errorf("plugin %s: %w", e.plugin.Name(), err)
-			}
+		start := time.Now()
+		modified, result, err := safeFilter(p, req, resp, body, onPanic, logger)
+		if onDuration != nil {
+			onDuration(p.Name(), time.Since(start))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", p.Name(), err)
+		}
+		if err := applyResponseChanges(resp, result); err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", p.Name(), err)
+		}
 
-			// Report matches via callback.
-			if result.Matched && onMatch != nil {
-				if len(result.Rules) > 0 {
-					// Multi-rule plugin: report each rule individually.
-					for _, rm := range result.Rules {
-						onMatch(e.plugin.Name(), rm.Rule, rm.Modified, rm.Count)
-					}
-				} else {
-					// Single-rule plugin: report aggregate.
-					onMatch(e.plugin.Name(), result.Rule, result.Modified, result.Removed)
+		if result.Matched && trace != nil {
+			if len(result.Rules) > 0 {
+				for _, rm := range result.Rules {
+					trace.AddRule(p.Name(), rm.Rule)
 				}
+			} else if result.Rule != "" {
+				trace.AddRule(p.Name(), result.Rule)
 			}
+		}
 
-			logMatches := false
-			if v, ok := e.cfg.Options["log_matches"]; ok {
-				if b, ok := v.(bool); ok {
-					logMatches = b
+		// Report matches via callback.
+		if result.Matched && onMatch != nil {
+			if len(result.Rules) > 0 {
+				// Multi-rule plugin: report each rule individually.
+				for _, rm := range result.Rules {
+					onMatch(p.Name(), rm.Rule, rm.Modified, rm.Count)
 				}
+			} else {
+				// Single-rule plugin: report aggregate.
+				onMatch(p.Name(), result.Rule, result.Modified, result.Removed)
 			}
+		}
 
-			if result.Matched {
-				lvl := slog.LevelDebug
-				if logMatches {
-					lvl = slog.LevelInfo
-				}
-				logger.Log(nil, lvl, "plugin filter match", //nolint:staticcheck // nil context is fine for slog
-					"name", e.plugin.Name(),
-					"rule", result.Rule,
-					"url", req.URL.String(),
-					"method", req.Method,
-					"status", resp.StatusCode,
-					"body_delta", len(modified)-len(current),
-					"placeholder", e.cfg.Placeholder,
-					"removed", result.Removed,
-				)
+		if result.Matched {
+			lvl := slog.LevelDebug
+			if logMatches {
+				lvl = slog.LevelInfo
 			}
-
-			current = modified
+			logger.Log(nil, lvl, "plugin filter match", //nolint:staticcheck // nil context is fine for slog
+				"name", p.Name(),
+				"rule", result.Rule,
+				"url", req.URL.String(),
+				"method", req.Method,
+				"status", resp.StatusCode,
+				"body_delta", len(modified)-len(body),
+				"placeholder", cfg.Placeholder,
+				"removed", result.Removed,
+			)
 		}
 
-		return current, nil
+		return modified, nil
 	}
 }
+
+// applyResponseChanges applies a FilterResult's status and header changes
+// to resp. Only final statuses (200-599) may be set.
+func applyResponseChanges(resp *http.Response, result FilterResult) error {
+	if result.StatusCode != 0 && (result.StatusCode < 200 || result.StatusCode > 599) {
+		return fmt.Errorf("invalid status code %d", result.StatusCode)
+	}
+	if result.StatusCode != 0 {
+		resp.StatusCode = result.StatusCode
+		resp.Status = fmt.Sprintf("%d %s", result.StatusCode, http.StatusText(result.StatusCode))
+	}
+	for key, values := range result.Header {
+		if len(values) == 0 {
+			resp.Header.Del(key)
+			continue
+		}
+		resp.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	return nil
+}
+
+// safeFilter calls p.Filter, turning a panic into an error so one buggy
+// plugin can't take down the MITM session.
+func safeFilter(
+	p ContentFilter, req *http.Request, resp *http.Response, body []byte,
+	onPanic OnPluginPanic, logger *slog.Logger,
+) (modified []byte, result FilterResult, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			logger.Error("plugin filter panicked",
+				"name", p.Name(),
+				"url", req.URL.String(),
+				"panic", v,
+				"stack", string(debug.Stack()),
+			)
+			if onPanic != nil {
+				onPanic(p.Name())
+			}
+			modified, result, err = nil, FilterResult{}, fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return p.Filter(req, resp, body)
+}
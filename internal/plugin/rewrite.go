@@ -2,29 +2,52 @@ package plugin
 
 import (
 	"bytes"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 )
 
-// RewriteRule defines a content rewrite rule.
+// RewriteRule defines a content rewrite rule. A rule rewrites the body
+// when Pattern is set and a response header when HeaderName is set; it may
+// do both.
 type RewriteRule struct {
 	ID           string   `json:"id"`
 	Name         string   `json:"name"`
 	Pattern      string   `json:"pattern"`
 	Replacement  string   `json:"replacement"`
 	IsRegex      bool     `json:"is_regex"`
+	Guard        string   `json:"guard"` // literal substring the body must contain; empty = always run
 	Domains      []string `json:"domains"`
 	URLPatterns  []string `json:"url_patterns"`
 	ContentTypes []string `json:"content_types"`
 	Enabled      bool     `json:"enabled"`
 	CreatedAt    string   `json:"created_at"`
 	UpdatedAt    string   `json:"updated_at"`
+
+	HeaderName        string `json:"header_name"`        // response header to rewrite; empty = body-only rule
+	HeaderAction      string `json:"header_action"`      // HeaderActionAdd, HeaderActionRemove, or HeaderActionReplace
+	HeaderPattern     string `json:"header_pattern"`     // regex matched against each header value
+	HeaderReplacement string `json:"header_replacement"` // added value, or replacement for HeaderPattern matches
 }
 
+// Header rule actions.
+const (
+	// HeaderActionAdd adds HeaderReplacement as a new value.
+	HeaderActionAdd = "add"
+	// HeaderActionRemove drops the values matching HeaderPattern, or the
+	// whole header when HeaderPattern is empty.
+	HeaderActionRemove = "remove"
+	// HeaderActionReplace rewrites HeaderPattern matches within each value
+	// to HeaderReplacement, which may use $1-style group references.
+	HeaderActionReplace = "replace"
+)
+
 // defaultSafeContentTypes is the set of content types that are safe
 // for text replacement. Used when a rule has no explicit ContentTypes.
 var defaultSafeContentTypes = map[string]struct{}{
@@ -37,6 +60,8 @@ type compiledRule struct {
 	RewriteRule
 	re           *regexp.Regexp        // nil for literal rules
 	contentTypes map[string]struct{}   // resolved from ContentTypes or defaults
+	guard        []byte                // nil when the rule has no guard
+	headerRe     *regexp.Regexp        // nil without a HeaderPattern
 }
 
 // rewriteFilter implements ContentFilter with API-managed rewrite rules.
@@ -45,9 +70,58 @@ type rewriteFilter struct {
 	version string
 	logger  *slog.Logger
 
-	mu            sync.RWMutex
-	compiledRules []compiledRule
-	store         *RewriteStore
+	mu       sync.RWMutex
+	index    ruleIndex
+	maxRules int // 0 = unlimited
+	store    *RewriteStore
+}
+
+// ruleIndex holds the active rules bucketed by domain so Filter only walks
+// the rules that can apply to a host. Each byDomain bucket already contains
+// the global (no-domain) rules merged in list order, so rule evaluation order
+// is unchanged.
+type ruleIndex struct {
+	byDomain map[string][]compiledRule
+	global   []compiledRule
+	size     int
+}
+
+// newRuleIndex buckets rules (in evaluation order) by lowercased domain.
+func newRuleIndex(rules []compiledRule) ruleIndex {
+	idx := ruleIndex{byDomain: make(map[string][]compiledRule), size: len(rules)}
+	for i := range rules {
+		for _, d := range rules[i].Domains {
+			idx.byDomain[strings.ToLower(d)] = nil
+		}
+	}
+	for i := range rules {
+		r := rules[i]
+		if len(r.Domains) == 0 {
+			idx.global = append(idx.global, r)
+			for d := range idx.byDomain {
+				idx.byDomain[d] = append(idx.byDomain[d], r)
+			}
+			continue
+		}
+		seen := make(map[string]struct{}, len(r.Domains))
+		for _, d := range r.Domains {
+			d = strings.ToLower(d)
+			if _, dup := seen[d]; dup {
+				continue
+			}
+			seen[d] = struct{}{}
+			idx.byDomain[d] = append(idx.byDomain[d], r)
+		}
+	}
+	return idx
+}
+
+// forHost returns the rules that apply to host (already lowercased).
+func (idx *ruleIndex) forHost(host string) []compiledRule {
+	if rules, ok := idx.byDomain[host]; ok {
+		return rules
+	}
+	return idx.global
 }
 
 func init() {
@@ -67,8 +141,12 @@ func (f *rewriteFilter) Version() string { return f.version }
 func (f *rewriteFilter) Domains() []string { return nil }
 
 // Init opens the rule store and loads compiled rules into memory.
+// Options["max_rules"] caps the number of active rules (0 = unlimited).
 func (f *rewriteFilter) Init(cfg *PluginConfig, logger *slog.Logger) error {
 	f.logger = logger
+	if v, ok := cfg.Options["max_rules"].(int); ok && v > 0 {
+		f.maxRules = v
+	}
 
 	dataDir, _ := cfg.Options["data_dir"].(string) //nolint:errcheck // optional
 	if dataDir == "" {
@@ -90,7 +168,9 @@ func (f *rewriteFilter) Store() *RewriteStore {
 }
 
 // ReloadRules queries the DB for all enabled rules, compiles patterns,
-// and swaps the in-memory rule set under a write lock.
+// and swaps the in-memory rule index under a write lock. When max_rules is
+// set, enabled rules beyond the cap (newest first, by creation order) are
+// left inactive.
 func (f *rewriteFilter) ReloadRules() error {
 	rules, err := f.store.List()
 	if err != nil {
@@ -103,35 +183,90 @@ func (f *rewriteFilter) ReloadRules() error {
 		if !r.Enabled {
 			continue
 		}
-		cr := compiledRule{RewriteRule: *r}
-		if len(r.ContentTypes) > 0 {
-			cr.contentTypes = make(map[string]struct{}, len(r.ContentTypes))
-			for _, ct := range r.ContentTypes {
-				cr.contentTypes[strings.ToLower(strings.TrimSpace(ct))] = struct{}{}
-			}
-		} else {
-			cr.contentTypes = defaultSafeContentTypes
-		}
-		if r.IsRegex {
-			re, compileErr := regexp.Compile(r.Pattern)
-			if compileErr != nil {
-				f.logger.Warn("skipping rule with invalid regex",
-					"rule", r.Name, "pattern", r.Pattern, "error", compileErr)
-				continue
-			}
-			cr.re = re
+		cr, compileErr := compileRule(r)
+		if compileErr != nil {
+			f.logger.Warn("skipping rule with invalid regex",
+				"rule", r.Name, "pattern", r.Pattern, "error", compileErr)
+			continue
 		}
 		compiled = append(compiled, cr)
 	}
 
+	if f.maxRules > 0 && len(compiled) > f.maxRules {
+		f.logger.Warn("rewrite rule limit reached, ignoring newest rules",
+			"max_rules", f.maxRules, "dropped", len(compiled)-f.maxRules)
+		compiled = compiled[:f.maxRules]
+	}
+	idx := newRuleIndex(compiled)
+
 	f.mu.Lock()
-	f.compiledRules = compiled
+	f.index = idx
 	f.mu.Unlock()
 
-	f.logger.Debug("rewrite rules reloaded", "active_rules", len(compiled))
+	f.logger.Debug("rewrite rules reloaded",
+		"active_rules", idx.size, "indexed_domains", len(idx.byDomain))
 	return nil
 }
 
+// compileRule resolves a rule's content types and guard and compiles its
+// regex pattern.
+func compileRule(r *RewriteRule) (compiledRule, error) {
+	cr := compiledRule{RewriteRule: *r}
+	if len(r.ContentTypes) > 0 {
+		cr.contentTypes = make(map[string]struct{}, len(r.ContentTypes))
+		for _, ct := range r.ContentTypes {
+			cr.contentTypes[strings.ToLower(strings.TrimSpace(ct))] = struct{}{}
+		}
+	} else {
+		cr.contentTypes = defaultSafeContentTypes
+	}
+	if r.Guard != "" {
+		cr.guard = []byte(r.Guard)
+	}
+	if r.IsRegex {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		cr.re = re
+	}
+	if r.HeaderPattern != "" {
+		re, err := regexp.Compile(r.HeaderPattern)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		cr.headerRe = re
+	}
+	return cr, nil
+}
+
+// SimulateRewrite applies a single draft rule to body as if it were a
+// response for rawURL with the given Content-Type, without storing the rule.
+// The rule is validated first (an empty name is allowed); domain, URL
+// pattern, content type, and guard checks apply exactly as in Filter, and
+// the rule runs regardless of its enabled flag.
+func SimulateRewrite(rule RewriteRule, contentType, rawURL string, body []byte) ([]byte, FilterResult, error) {
+	if rule.Name == "" {
+		rule.Name = "simulated"
+	}
+	if err := validateRule(&rule); err != nil {
+		return nil, FilterResult{}, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, FilterResult{}, fmt.Errorf("invalid url: %w", err)
+	}
+	cr, err := compileRule(&rule)
+	if err != nil {
+		return nil, FilterResult{}, fmt.Errorf("invalid regex: %w", err)
+	}
+
+	f := &rewriteFilter{index: newRuleIndex([]compiledRule{cr})}
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Hostname()}
+	resp := &http.Response{Header: http.Header{"Content-Type": []string{contentType}}}
+	return f.Filter(req, resp, body)
+}
+
 // Close closes the underlying store.
 func (f *rewriteFilter) Close() error {
 	if f.store != nil {
@@ -140,10 +275,16 @@ func (f *rewriteFilter) Close() error {
 	return nil
 }
 
-// Filter applies rewrite rules to the response body.
+// Filter applies rewrite rules to the response body and headers. Header
+// changes are returned in the FilterResult's Header rather than applied to
+// resp directly, and count towards the rule's matches like body
+// replacements. A header rule with no ContentTypes applies to every
+// content type.
 func (f *rewriteFilter) Filter(req *http.Request, resp *http.Response, body []byte) ([]byte, FilterResult, error) {
+	domain := strings.ToLower(req.Host)
+
 	f.mu.RLock()
-	rules := f.compiledRules
+	rules := f.index.forHost(domain)
 	f.mu.RUnlock()
 
 	if len(rules) == 0 {
@@ -152,7 +293,6 @@ func (f *rewriteFilter) Filter(req *http.Request, resp *http.Response, body []by
 
 	ct := normalizeContentType(resp.Header.Get("Content-Type"))
 	isHTML := ct == "text/html"
-	domain := strings.ToLower(req.Host)
 	urlPath := req.URL.Path
 
 	current := body
@@ -165,13 +305,42 @@ func (f *rewriteFilter) Filter(req *http.Request, resp *http.Response, body []by
 	var totalCount int
 	var matched bool
 	var ruleMatches []RuleMatch
+	var header http.Header // pending header changes
+
+	record := func(name string, count int) {
+		matched = true
+		totalCount += count
+		if firstRule == "" {
+			firstRule = name
+		}
+		ruleMatches = append(ruleMatches, RuleMatch{
+			Rule:     name,
+			Count:    count,
+			Modified: true,
+		})
+	}
 
 	for i := range rules {
 		r := &rules[i]
-		if !matchesContentType(r.contentTypes, ct) {
+		if !matchesURL(r.URLPatterns, urlPath) {
 			continue
 		}
-		if !matchesDomain(r.Domains, domain) || !matchesURL(r.URLPatterns, urlPath) {
+
+		var headerCount int
+		if r.HeaderName != "" && (len(r.ContentTypes) == 0 || matchesContentType(r.contentTypes, ct)) {
+			if header == nil {
+				header = make(http.Header)
+			}
+			headerCount = r.rewriteHeader(resp.Header, header)
+		}
+
+		if r.Pattern == "" || !matchesContentType(r.contentTypes, ct) ||
+			// Quick-skip: a cheap substring check before the (possibly
+			// costly) replace, like the reddit filter's containsAdMarker.
+			(r.guard != nil && !bytes.Contains(current, r.guard)) {
+			if headerCount > 0 {
+				record(r.Name, headerCount)
+			}
 			continue
 		}
 
@@ -193,44 +362,70 @@ func (f *rewriteFilter) Filter(req *http.Request, resp *http.Response, body []by
 		}
 
 		if count > 0 {
-			matched = true
-			totalCount += count
-			if firstRule == "" {
-				firstRule = r.Name
-			}
-			ruleMatches = append(ruleMatches, RuleMatch{
-				Rule:     r.Name,
-				Count:    count,
-				Modified: true,
-			})
 			current = replaced
 			if isHTML {
 				protected = findProtectedRanges(current)
 			}
 		}
+		if count+headerCount > 0 {
+			record(r.Name, count+headerCount)
+		}
 	}
 
+	if len(header) == 0 {
+		header = nil
+	}
 	return current, FilterResult{
 		Matched:  matched,
-		Modified: !bytes.Equal(current, body),
+		Modified: !bytes.Equal(current, body) || header != nil,
 		Rule:     firstRule,
 		Removed:  totalCount,
 		Rules:    ruleMatches,
+		Header:   header,
 	}, nil
 }
 
-// matchesDomain returns true if the domain matches the rule's domain list.
-// Empty domain list matches all domains.
-func matchesDomain(ruleDomains []string, domain string) bool {
-	if len(ruleDomains) == 0 {
-		return true
+// rewriteHeader applies the rule's header action to the values in pending,
+// or in orig when pending has no entry for the header yet, and stores the
+// result in pending (an empty list removes the header). Returns the number
+// of values added, removed, or rewritten.
+func (r *compiledRule) rewriteHeader(orig, pending http.Header) int {
+	key := http.CanonicalHeaderKey(r.HeaderName)
+	values, ok := pending[key]
+	if !ok {
+		values = orig.Values(key)
 	}
-	for _, d := range ruleDomains {
-		if strings.EqualFold(d, domain) {
-			return true
+
+	var out []string
+	var count int
+	switch r.HeaderAction {
+	case HeaderActionAdd:
+		out = append(slices.Clone(values), r.HeaderReplacement)
+		count = 1
+	case HeaderActionRemove:
+		for _, v := range values {
+			if r.headerRe == nil || r.headerRe.MatchString(v) {
+				count++
+				continue
+			}
+			out = append(out, v)
+		}
+	case HeaderActionReplace:
+		for _, v := range values {
+			if r.headerRe.MatchString(v) {
+				v = r.headerRe.ReplaceAllString(v, r.HeaderReplacement)
+				count++
+			}
+			out = append(out, v)
 		}
 	}
-	return false
+	if count > 0 {
+		if out == nil {
+			out = []string{}
+		}
+		pending[key] = out
+	}
+	return count
 }
 
 // matchesURL returns true if the URL path matches any of the rule's URL patterns.
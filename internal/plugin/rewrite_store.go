@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -63,6 +64,11 @@ func (s *RewriteStore) ensureSchema() error {
 			domains       TEXT NOT NULL DEFAULT '[]',
 			url_patterns  TEXT NOT NULL DEFAULT '[]',
 			content_types TEXT NOT NULL DEFAULT '[]',
+			guard         TEXT NOT NULL DEFAULT '',
+			header_name        TEXT NOT NULL DEFAULT '',
+			header_action      TEXT NOT NULL DEFAULT '',
+			header_pattern     TEXT NOT NULL DEFAULT '',
+			header_replacement TEXT NOT NULL DEFAULT '',
 			enabled       INTEGER NOT NULL DEFAULT 1,
 			created_at    TEXT NOT NULL,
 			updated_at    TEXT NOT NULL
@@ -72,31 +78,37 @@ func (s *RewriteStore) ensureSchema() error {
 
 // migrateSchema adds columns that may be missing from older databases.
 func (s *RewriteStore) migrateSchema() error {
-	var hasContentTypes bool
+	columns := make(map[string]bool)
 	err := sqlitex.Execute(s.conn, "PRAGMA table_info(rewrite_rules)", &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
-			if stmt.ColumnText(1) == "content_types" {
-				hasContentTypes = true
-			}
+			columns[stmt.ColumnText(1)] = true
 			return nil
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("check schema: %w", err)
 	}
-	if !hasContentTypes {
-		err = sqlitex.ExecuteTransient(s.conn,
-			"ALTER TABLE rewrite_rules ADD COLUMN content_types TEXT NOT NULL DEFAULT '[]'",
-			nil,
-		)
-		if err != nil {
-			return fmt.Errorf("migrate content_types column: %w", err)
+	migrations := []struct{ column, ddl string }{
+		{"content_types", "ALTER TABLE rewrite_rules ADD COLUMN content_types TEXT NOT NULL DEFAULT '[]'"},
+		{"guard", "ALTER TABLE rewrite_rules ADD COLUMN guard TEXT NOT NULL DEFAULT ''"},
+		{"header_name", "ALTER TABLE rewrite_rules ADD COLUMN header_name TEXT NOT NULL DEFAULT ''"},
+		{"header_action", "ALTER TABLE rewrite_rules ADD COLUMN header_action TEXT NOT NULL DEFAULT ''"},
+		{"header_pattern", "ALTER TABLE rewrite_rules ADD COLUMN header_pattern TEXT NOT NULL DEFAULT ''"},
+		{"header_replacement", "ALTER TABLE rewrite_rules ADD COLUMN header_replacement TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, m := range migrations {
+		if columns[m.column] {
+			continue
+		}
+		if err := sqlitex.ExecuteTransient(s.conn, m.ddl, nil); err != nil {
+			return fmt.Errorf("migrate %s column: %w", m.column, err)
 		}
 	}
 	return nil
 }
 
-const selectColumns = `id, name, pattern, replacement, is_regex, domains, url_patterns, content_types, enabled, created_at, updated_at`
+const selectColumns = `id, name, pattern, replacement, is_regex, domains, url_patterns, content_types, enabled, created_at, updated_at, guard,
+	header_name, header_action, header_pattern, header_replacement`
 
 // List returns all rewrite rules ordered by creation time.
 func (s *RewriteStore) List() ([]RewriteRule, error) {
@@ -179,12 +191,13 @@ func (s *RewriteStore) Add(rule RewriteRule) (RewriteRule, error) {
 
 	err := sqlitex.Execute(s.conn, `
 		INSERT INTO rewrite_rules (`+selectColumns+`)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, &sqlitex.ExecOptions{
 		Args: []any{
 			rule.ID, rule.Name, rule.Pattern, rule.Replacement,
 			boolToInt(rule.IsRegex), string(domainsJSON), string(urlPatternsJSON),
 			string(contentTypesJSON), boolToInt(rule.Enabled), rule.CreatedAt, rule.UpdatedAt,
+			rule.Guard, rule.HeaderName, rule.HeaderAction, rule.HeaderPattern, rule.HeaderReplacement,
 		},
 	})
 	if err != nil {
@@ -211,13 +224,15 @@ func (s *RewriteStore) Update(id string, rule RewriteRule) (RewriteRule, error)
 
 	err := sqlitex.Execute(s.conn, `
 		UPDATE rewrite_rules SET name=?, pattern=?, replacement=?, is_regex=?,
-			domains=?, url_patterns=?, content_types=?, enabled=?, updated_at=?
+			domains=?, url_patterns=?, content_types=?, enabled=?, guard=?,
+			header_name=?, header_action=?, header_pattern=?, header_replacement=?, updated_at=?
 		WHERE id=?
 	`, &sqlitex.ExecOptions{
 		Args: []any{
 			rule.Name, rule.Pattern, rule.Replacement,
 			boolToInt(rule.IsRegex), string(domainsJSON), string(urlPatternsJSON),
-			string(contentTypesJSON), boolToInt(rule.Enabled), now, id,
+			string(contentTypesJSON), boolToInt(rule.Enabled), rule.Guard,
+			rule.HeaderName, rule.HeaderAction, rule.HeaderPattern, rule.HeaderReplacement, now, id,
 		},
 	})
 	if err != nil {
@@ -323,6 +338,12 @@ func scanRule(stmt *sqlite.Stmt) (RewriteRule, error) {
 		Enabled:      stmt.ColumnInt64(8) != 0,
 		CreatedAt:    stmt.ColumnText(9),
 		UpdatedAt:    stmt.ColumnText(10),
+		Guard:        stmt.ColumnText(11),
+
+		HeaderName:        stmt.ColumnText(12),
+		HeaderAction:      stmt.ColumnText(13),
+		HeaderPattern:     stmt.ColumnText(14),
+		HeaderReplacement: stmt.ColumnText(15),
 	}, nil
 }
 
@@ -334,7 +355,7 @@ func validateRule(r *RewriteRule) error {
 	if len(r.Name) > 200 {
 		return fmt.Errorf("name must be 200 characters or fewer")
 	}
-	if r.Pattern == "" {
+	if r.Pattern == "" && r.HeaderName == "" {
 		return fmt.Errorf("pattern is required")
 	}
 	if r.IsRegex {
@@ -342,6 +363,41 @@ func validateRule(r *RewriteRule) error {
 			return fmt.Errorf("invalid regex: %w", err)
 		}
 	}
+	return validateHeaderRule(r)
+}
+
+// validateHeaderRule checks the header fields of a rule.
+func validateHeaderRule(r *RewriteRule) error {
+	if r.HeaderName == "" {
+		if r.HeaderAction != "" || r.HeaderPattern != "" || r.HeaderReplacement != "" {
+			return fmt.Errorf("header_name is required for header rules")
+		}
+		return nil
+	}
+	if strings.ContainsAny(r.HeaderName, " \t\r\n:") {
+		return fmt.Errorf("invalid header_name %q", r.HeaderName)
+	}
+	if strings.ContainsAny(r.HeaderReplacement, "\r\n") {
+		return fmt.Errorf("header_replacement must not contain line breaks")
+	}
+	switch r.HeaderAction {
+	case HeaderActionAdd:
+		if r.HeaderPattern != "" {
+			return fmt.Errorf("header_pattern is not used by header_action add")
+		}
+	case HeaderActionRemove:
+	case HeaderActionReplace:
+		if r.HeaderPattern == "" {
+			return fmt.Errorf("header_pattern is required for header_action replace")
+		}
+	default:
+		return fmt.Errorf("header_action must be add, remove, or replace, got %q", r.HeaderAction)
+	}
+	if r.HeaderPattern != "" {
+		if _, err := regexp.Compile(r.HeaderPattern); err != nil {
+			return fmt.Errorf("invalid header_pattern: %w", err)
+		}
+	}
 	return nil
 }
 
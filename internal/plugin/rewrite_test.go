@@ -1,10 +1,12 @@
 package plugin
 
 import (
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -161,6 +163,23 @@ func TestStoreDomainAndURLPatterns(t *testing.T) {
 	assert.Equal(t, []string{"/blog/*", "/api/*"}, got.URLPatterns)
 }
 
+func TestStoreGuardRoundTrip(t *testing.T) {
+	store := openTestStore(t)
+	created, err := store.Add(RewriteRule{Name: "guarded", Pattern: "x", Guard: "marker", Enabled: true})
+	require.NoError(t, err)
+
+	got, err := store.Get(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "marker", got.Guard)
+
+	got.Guard = "other"
+	_, err = store.Update(created.ID, got)
+	require.NoError(t, err)
+	got, err = store.Get(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "other", got.Guard)
+}
+
 // --- Rewrite filter tests ---
 
 func TestRewriteLiteralReplacement(t *testing.T) {
@@ -286,6 +305,25 @@ func TestRewriteMultipleRules(t *testing.T) {
 	assert.Equal(t, "r2", result.Rules[1].Rule)
 }
 
+func TestRewriteGuardSkipsRule(t *testing.T) {
+	f := setupFilter(t, RewriteRule{
+		Name: "guarded", Pattern: `fo+`, Replacement: "bar", IsRegex: true,
+		Guard: "data-promo", Enabled: true,
+	})
+
+	// Pattern would match, but the guard substring is absent.
+	body, result, err := f.Filter(rewriteReq("example.com", "/"), rewriteResp(), []byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(body))
+	assert.False(t, result.Matched)
+
+	// Guard present: rule runs.
+	body, result, err = f.Filter(rewriteReq("example.com", "/"), rewriteResp(), []byte(`<p data-promo>foo</p>`))
+	require.NoError(t, err)
+	assert.Equal(t, `<p data-promo>bar</p>`, string(body))
+	assert.True(t, result.Matched)
+}
+
 func TestRewriteDisabledRuleSkipped(t *testing.T) {
 	f := setupFilter(t,
 		RewriteRule{Name: "disabled", Pattern: "foo", Replacement: "bar", Enabled: false},
@@ -297,6 +335,56 @@ func TestRewriteDisabledRuleSkipped(t *testing.T) {
 	assert.False(t, result.Matched)
 }
 
+func TestRewriteDomainIndexPreservesRuleOrder(t *testing.T) {
+	f := setupFilter(t,
+		RewriteRule{Name: "global-1", Pattern: "a", Replacement: "b", Enabled: true},
+		RewriteRule{Name: "scoped", Pattern: "b", Replacement: "c", Domains: []string{"Target.com"}, Enabled: true},
+		RewriteRule{Name: "global-2", Pattern: "c", Replacement: "d", Enabled: true},
+	)
+
+	// Scoped host sees globals and its own rule, interleaved in list order.
+	body, result, err := f.Filter(rewriteReq("target.com", "/"), rewriteResp(), []byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "d", string(body))
+	require.Len(t, result.Rules, 3)
+	assert.Equal(t, "global-1", result.Rules[0].Rule)
+	assert.Equal(t, "scoped", result.Rules[1].Rule)
+	assert.Equal(t, "global-2", result.Rules[2].Rule)
+
+	// Other hosts only see the globals.
+	body, result, err = f.Filter(rewriteReq("other.com", "/"), rewriteResp(), []byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(body))
+	require.Len(t, result.Rules, 1)
+	assert.Equal(t, "global-1", result.Rules[0].Rule)
+}
+
+func TestRewriteMaxRules(t *testing.T) {
+	f := setupFilter(t,
+		RewriteRule{Name: "r1", Pattern: "aaa", Replacement: "bbb", Enabled: true},
+		RewriteRule{Name: "r2", Pattern: "bbb", Replacement: "ccc", Enabled: true},
+	)
+	f.maxRules = 1
+	require.NoError(t, f.ReloadRules())
+
+	body, result, err := f.Filter(rewriteReq("example.com", "/"), rewriteResp(), []byte("aaa"))
+	require.NoError(t, err)
+	assert.Equal(t, "bbb", string(body))
+	require.Len(t, result.Rules, 1)
+	assert.Equal(t, "r1", result.Rules[0].Rule)
+}
+
+func TestRewriteInitMaxRulesOption(t *testing.T) {
+	f := &rewriteFilter{name: "rewrite"}
+	err := f.Init(&PluginConfig{Options: map[string]any{
+		"data_dir":  t.TempDir(),
+		"max_rules": 5,
+	}}, testLogger())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = f.Close() })
+	assert.Equal(t, 5, f.maxRules)
+}
+
 func TestRewriteHotReload(t *testing.T) {
 	tmpDir := t.TempDir()
 	store, err := OpenRewriteStore(tmpDir)
@@ -595,3 +683,172 @@ func TestFindProtectedRangesDoesNotMatchScripted(t *testing.T) {
 	ranges := findProtectedRanges(body)
 	assert.Empty(t, ranges)
 }
+
+// --- Header rule tests ---
+
+func TestStoreHeaderRuleRoundTrip(t *testing.T) {
+	store := openTestStore(t)
+	created, err := store.Add(RewriteRule{
+		Name:              "csp",
+		HeaderName:        "Content-Security-Policy",
+		HeaderAction:      HeaderActionReplace,
+		HeaderPattern:     `script-src ([^;]*)`,
+		HeaderReplacement: "script-src $1 'unsafe-inline'",
+		Enabled:           true,
+	})
+	require.NoError(t, err)
+
+	got, err := store.Get(created.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got.Pattern)
+	assert.Equal(t, "Content-Security-Policy", got.HeaderName)
+	assert.Equal(t, HeaderActionReplace, got.HeaderAction)
+	assert.Equal(t, `script-src ([^;]*)`, got.HeaderPattern)
+	assert.Equal(t, "script-src $1 'unsafe-inline'", got.HeaderReplacement)
+
+	got.HeaderName, got.HeaderAction, got.HeaderPattern, got.HeaderReplacement = "Set-Cookie", HeaderActionRemove, "", ""
+	_, err = store.Update(created.ID, got)
+	require.NoError(t, err)
+	got, err = store.Get(created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Set-Cookie", got.HeaderName)
+	assert.Equal(t, HeaderActionRemove, got.HeaderAction)
+	assert.Empty(t, got.HeaderPattern)
+}
+
+func TestStoreHeaderRuleValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RewriteRule
+		want string
+	}{
+		{"fields without name", RewriteRule{Pattern: "x", HeaderAction: HeaderActionRemove}, "header_name is required"},
+		{"bad action", RewriteRule{HeaderName: "Set-Cookie", HeaderAction: "drop"}, "header_action must be add, remove, or replace"},
+		{"bad name", RewriteRule{HeaderName: "Set Cookie", HeaderAction: HeaderActionRemove}, "invalid header_name"},
+		{"replace without pattern", RewriteRule{HeaderName: "X-A", HeaderAction: HeaderActionReplace}, "header_pattern is required"},
+		{"add with pattern", RewriteRule{HeaderName: "X-A", HeaderAction: HeaderActionAdd, HeaderPattern: "x"}, "header_pattern is not used"},
+		{"bad pattern", RewriteRule{HeaderName: "X-A", HeaderAction: HeaderActionRemove, HeaderPattern: "[x"}, "invalid header_pattern"},
+		{"line break", RewriteRule{HeaderName: "X-A", HeaderAction: HeaderActionAdd, HeaderReplacement: "a\r\nX-B: b"}, "line breaks"},
+	}
+	store := openTestStore(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = "header"
+			_, err := store.Add(tt.rule)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestRewriteHeaderActions(t *testing.T) {
+	f := setupFilter(t,
+		RewriteRule{
+			Name: "drop-tracking-cookies", HeaderName: "set-cookie", HeaderAction: HeaderActionRemove,
+			HeaderPattern: `^_ga=`, Enabled: true,
+		},
+		RewriteRule{
+			Name: "relax-csp", HeaderName: "Content-Security-Policy", HeaderAction: HeaderActionReplace,
+			HeaderPattern: `img-src ([^;]*)`, HeaderReplacement: "img-src $1 data:", Enabled: true,
+		},
+		RewriteRule{
+			Name: "tag", HeaderName: "X-Filtered-By", HeaderAction: HeaderActionAdd,
+			HeaderReplacement: "fps", Enabled: true,
+		},
+		RewriteRule{Name: "body", Pattern: "foo", Replacement: "bar", Enabled: true},
+	)
+	resp := rewriteResp()
+	resp.Header["Set-Cookie"] = []string{"_ga=1; Path=/", "session=abc", "_ga=2"}
+	resp.Header.Set("Content-Security-Policy", "default-src 'self'; img-src 'self'")
+
+	body, result, err := f.Filter(rewriteReq("example.com", "/"), resp, []byte("foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(body))
+	assert.True(t, result.Matched)
+	assert.True(t, result.Modified)
+	assert.Equal(t, "drop-tracking-cookies", result.Rule)
+	assert.Equal(t, 5, result.Removed)
+	assert.Equal(t, []RuleMatch{
+		{Rule: "drop-tracking-cookies", Count: 2, Modified: true},
+		{Rule: "relax-csp", Count: 1, Modified: true},
+		{Rule: "tag", Count: 1, Modified: true},
+		{Rule: "body", Count: 1, Modified: true},
+	}, result.Rules)
+	assert.Equal(t, http.Header{
+		"Set-Cookie":              {"session=abc"},
+		"Content-Security-Policy": {"default-src 'self'; img-src 'self' data:"},
+		"X-Filtered-By":           {"fps"},
+	}, result.Header)
+
+	// Changes are returned, not applied: the pipeline applies them.
+	assert.Len(t, resp.Header.Values("Set-Cookie"), 3)
+	require.NoError(t, applyResponseChanges(resp, result))
+	assert.Equal(t, []string{"session=abc"}, resp.Header.Values("Set-Cookie"))
+}
+
+func TestRewriteHeaderRuleScoping(t *testing.T) {
+	f := setupFilter(t,
+		RewriteRule{
+			Name: "no-cookies", HeaderName: "Set-Cookie", HeaderAction: HeaderActionRemove,
+			Domains: []string{"tracker.example"}, Enabled: true,
+		},
+		RewriteRule{
+			Name: "json-only", HeaderName: "X-Api", HeaderAction: HeaderActionAdd, HeaderReplacement: "1",
+			ContentTypes: []string{"application/json"}, Enabled: true,
+		},
+	)
+	newResp := func(ct string) *http.Response {
+		resp := rewriteRespWithCT(ct)
+		resp.Header.Set("Set-Cookie", "id=1")
+		return resp
+	}
+
+	// Without content_types a header rule applies to any content type; a
+	// removed header comes back as an empty list.
+	_, result, err := f.Filter(rewriteReq("tracker.example", "/"), newResp("image/gif"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"Set-Cookie": {}}, result.Header)
+
+	_, result, err = f.Filter(rewriteReq("tracker.example", "/"), newResp("application/json"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"Set-Cookie": {}, "X-Api": {"1"}}, result.Header)
+
+	// Other domains and content types are untouched.
+	_, result, err = f.Filter(rewriteReq("example.com", "/"), newResp("text/html"), nil)
+	require.NoError(t, err)
+	assert.False(t, result.Matched)
+	assert.False(t, result.Modified)
+	assert.Nil(t, result.Header)
+}
+
+func BenchmarkRewriteFilterManyDomainRules(b *testing.B) {
+	store, err := OpenRewriteStore(b.TempDir())
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = store.Close() })
+
+	for i := range 2000 {
+		_, err := store.Add(RewriteRule{
+			Name:        fmt.Sprintf("rule-%d", i),
+			Pattern:     fmt.Sprintf("needle-%d", i),
+			Replacement: "x",
+			Domains:     []string{fmt.Sprintf("site-%d.example", i%200)},
+			Enabled:     true,
+		})
+		require.NoError(b, err)
+	}
+
+	f := &rewriteFilter{name: "rewrite", version: "0.1.0", logger: testLogger(), store: store}
+	require.NoError(b, f.ReloadRules())
+
+	req := rewriteReq("site-7.example", "/")
+	resp := rewriteResp()
+	body := []byte(strings.Repeat("<p>lorem ipsum needle-7 dolor</p>", 100))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for b.Loop() {
+		if _, _, err := f.Filter(req, resp, body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package probe
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ushineko/face-puncher-supreme/internal/statsd"
+)
+
+// labelEscaper escapes label values per the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler returns an http.HandlerFunc serving /fps/metrics in the
+// Prometheus text exposition format. Aggregate counters are always emitted;
+// fps_blocked_total{domain="..."} is emitted only for the topDomains most
+// blocked domains to bound cardinality (0 disables the labeled series).
+// A domain that drops out of the top list stops being reported.
+func MetricsHandler(sp *StatsProvider, topDomains int) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		resp := BuildStats(sp, topDomains, nil, "")
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		writeMetric(w, "fps_requests_total", "counter", "Proxied requests.", resp.Traffic.TotalRequests)
+		writeMetric(w, "fps_blocks_total", "counter", "Requests blocked by the blocklist.", resp.Blocking.BlocksTotal)
+		writeMetric(w, "fps_allows_total", "counter", "Blocklisted requests let through by the allowlist.", resp.Blocking.AllowsTotal)
+		writeMetric(w, "fps_bytes_in_total", "counter", "Bytes received from clients.", resp.Traffic.TotalBytesIn)
+		writeMetric(w, "fps_bytes_out_total", "counter", "Bytes sent to clients.", resp.Traffic.TotalBytesOut)
+		writeMetric(w, "fps_connections_active", "gauge", "Open client connections.", resp.Connections.Active)
+		writeMetric(w, "fps_blocklist_domains", "gauge", "Domains on the blocklist.", int64(resp.Blocking.BlocklistSize))
+
+		if lw := resp.Blocking.LockWait; lw != nil {
+			writeMetric(w, "fps_blocklist_lock_lookups_total", "counter", "Blocklist lookups timed for read-lock wait.", lw.Lookups)
+			writeMetric(w, "fps_blocklist_lock_wait_microseconds_total", "counter", "Time blocklist lookups waited for the read lock.", lw.WaitMicrosTotal)
+		}
+
+		fmt.Fprintf(w, "# HELP fps_blocked_total Blocked requests for the top %d blocked domains.\n", topDomains)
+		fmt.Fprintln(w, "# TYPE fps_blocked_total counter")
+		for _, e := range resp.Blocking.TopBlocked {
+			fmt.Fprintf(w, "fps_blocked_total{domain=\"%s\"} %d\n", labelEscaper.Replace(e.Domain), e.Count)
+		}
+	}
+}
+
+// writeMetric writes one unlabeled sample with its HELP and TYPE lines.
+func writeMetric(w io.Writer, name, typ, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+}
+
+// StatsDSamples returns the aggregate counters and gauges served at
+// /fps/metrics, for the StatsD exporter. Counters are totals since startup.
+// Unlike MetricsHandler it reads only in-memory counters, so frequent
+// pushes don't query the stats database.
+func StatsDSamples(sp *StatsProvider) []statsd.Sample {
+	var blocks, allows, blocklistSize int64
+	if sp.BlockFn != nil {
+		if bd := sp.BlockFn(); bd != nil {
+			blocks, allows, blocklistSize = bd.Total, bd.AllowsTotal, int64(bd.Size)
+		}
+	}
+	return []statsd.Sample{
+		{Name: "requests", Value: sp.Collector.TotalRequests(), Counter: true},
+		{Name: "blocks", Value: blocks, Counter: true},
+		{Name: "allows", Value: allows, Counter: true},
+		{Name: "bytes_in", Value: sp.Collector.TotalBytesIn(), Counter: true},
+		{Name: "bytes_out", Value: sp.Collector.TotalBytesOut(), Counter: true},
+		{Name: "connections.active", Value: sp.Info.ConnectionsActive()},
+		{Name: "blocklist.domains", Value: blocklistSize},
+	}
+}
@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PACHandler serves a Proxy Auto-Config script that sends clients through
+// the proxy. The proxy host is externalHost when set; otherwise the host
+// from listenAddr, or, for a wildcard listen address, the host the client
+// used to fetch the script. The port always comes from listenAddr.
+// Hosts matching a direct entry (exact, or "*.example.com" for the base
+// domain and all subdomains) go DIRECT.
+func PACHandler(listenAddr, externalHost string, direct []string) http.HandlerFunc {
+	listenHost, port, _ := net.SplitHostPort(listenAddr)
+	if ip := net.ParseIP(listenHost); ip != nil && ip.IsUnspecified() {
+		listenHost = ""
+	}
+	directExpr := pacDirectExpr(direct)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := externalHost
+		if host == "" {
+			host = listenHost
+		}
+		if host == "" {
+			host = r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+		}
+		proxy := net.JoinHostPort(strings.Trim(host, "[]"), port)
+
+		var b strings.Builder
+		b.WriteString("function FindProxyForURL(url, host) {\n")
+		if directExpr != "" {
+			b.WriteString("  host = host.toLowerCase();\n")
+			fmt.Fprintf(&b, "  if (%s) {\n    return \"DIRECT\";\n  }\n", directExpr)
+		}
+		fmt.Fprintf(&b, "  return %s;\n}\n", strconv.Quote("PROXY "+proxy))
+
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write([]byte(b.String())) //nolint:errcheck // best-effort response
+	}
+}
+
+// pacDirectExpr builds the JavaScript condition matching direct entries.
+func pacDirectExpr(direct []string) string {
+	var conds []string
+	for _, d := range direct {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if suffix, ok := strings.CutPrefix(d, "*."); ok {
+			conds = append(conds, fmt.Sprintf("host == %s || dnsDomainIs(host, %s)",
+				strconv.Quote(suffix), strconv.Quote("."+suffix)))
+		} else if d != "" {
+			conds = append(conds, "host == "+strconv.Quote(d))
+		}
+	}
+	return strings.Join(conds, " ||\n      ")
+}
@@ -6,13 +6,19 @@ package probe
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ushineko/face-puncher-supreme/internal/learn"
+	"github.com/ushineko/face-puncher-supreme/internal/session"
 	"github.com/ushineko/face-puncher-supreme/internal/stats"
+	"github.com/ushineko/face-puncher-supreme/internal/suggest"
 	"github.com/ushineko/face-puncher-supreme/internal/version"
 )
 
@@ -22,6 +28,17 @@ type ServerInfo interface {
 	StartedAt() time.Time
 	ConnectionsTotal() int64
 	ConnectionsActive() int64
+	ConnectionsShed() int64
+	ConnectionsRateLimited() int64
+	ResponsesTruncated() int64
+	ResponsesSanitized() int64
+	// ChaosDelayed returns the number of requests delayed by chaos
+	// injection.
+	ChaosDelayed() int64
+	// Panics returns the number of handler panics recovered.
+	Panics() int64
+	// PassthroughForced reports whether the passthrough kill switch is on.
+	PassthroughForced() bool
 }
 
 // BlockData holds blocklist metadata for the stats response.
@@ -31,6 +48,29 @@ type BlockData struct {
 	Size          int
 	AllowlistSize int
 	Sources       int
+	// Rescued maps blocklisted+allowlisted domains to their allow counts.
+	Rescued map[string]int64
+	// SourceDetails describes each source from the last update.
+	SourceDetails []BlocklistSourceEntry
+	// IPBlocks maps each blocked CIDR to the connections it refused.
+	IPBlocks map[string]int64
+	// LockWait holds lookup read-lock wait totals; nil unless
+	// blocklist_lock_timing is on.
+	LockWait *LockWaitBlock
+}
+
+// BlocklistSourceEntry describes one blocklist source, with the provenance
+// metadata its header declared.
+type BlocklistSourceEntry struct {
+	URL     string    `json:"url"`
+	Title   string    `json:"title,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Expires string    `json:"expires,omitempty"`
+	Domains int       `json:"domains"`
+	Fetched time.Time `json:"fetched"`
+	Stale   bool      `json:"stale"` // past its declared Expires period
+	Enabled bool      `json:"enabled"`
+	Blocks  int64     `json:"blocks"` // blocks caused by this source's domains since startup
 }
 
 // MITMData holds MITM interception metadata for responses.
@@ -38,6 +78,10 @@ type MITMData struct {
 	Enabled           bool
 	InterceptsTotal   int64
 	DomainsConfigured int
+	Protocol          MITMProtocolBlock
+	ModifierPanics    int64
+	DiskBuffered      int64
+	BufferSkipped     int64
 }
 
 // TopEntry is a domain with a counter value.
@@ -48,10 +92,11 @@ type TopEntry struct {
 
 // PluginInfo holds per-plugin metadata for heartbeat/stats.
 type PluginInfo struct {
-	Name    string
-	Version string
-	Mode    string
-	Domains []string
+	Name          string
+	Version       string
+	Mode          string
+	Domains       []string
+	PausedDomains []string
 }
 
 // PluginsData holds plugin metadata for responses.
@@ -94,6 +139,7 @@ type StatsResponse struct {
 	Blocking    BlockingBlock    `json:"blocking"`
 	MITM        MITMBlock        `json:"mitm"`
 	Transparent TransparentBlock `json:"transparent"`
+	Cache       CacheBlock       `json:"cache"`
 	Plugins     PluginsBlock     `json:"plugins"`
 	Domains     DomainsBlock     `json:"domains"`
 	Clients     ClientsBlock     `json:"clients"`
@@ -112,6 +158,12 @@ type TransparentBlock struct {
 	SNIMissing   int64 `json:"sni_missing"`
 }
 
+// CacheBlock holds proxy response cache lookups (proxy.cache).
+type CacheBlock struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
 // PluginsBlock holds plugin filter statistics.
 type PluginsBlock struct {
 	Active  int                 `json:"active"`
@@ -127,6 +179,10 @@ type PluginFilterEntry struct {
 	ResponsesInspected int64           `json:"responses_inspected"`
 	ResponsesMatched   int64           `json:"responses_matched"`
 	ResponsesModified  int64           `json:"responses_modified"`
+	FilterMicrosTotal  int64           `json:"filter_micros_total"`
+	AvgFilterMicros    float64         `json:"avg_filter_micros"`
+	Panics             int64           `json:"panics"`
+	PausedDomains      []string        `json:"paused_domains"`
 	TopRules           []RuleCountJSON `json:"top_rules"`
 }
 
@@ -138,27 +194,74 @@ type RuleCountJSON struct {
 
 // MITMBlock holds MITM interception statistics.
 type MITMBlock struct {
-	Enabled           bool       `json:"enabled"`
-	InterceptsTotal   int64      `json:"intercepts_total"`
-	DomainsConfigured int        `json:"domains_configured"`
-	TopIntercepted    []TopEntry `json:"top_intercepted"`
+	Enabled           bool              `json:"enabled"`
+	InterceptsTotal   int64             `json:"intercepts_total"`
+	DomainsConfigured int               `json:"domains_configured"`
+	TopIntercepted    []TopEntry        `json:"top_intercepted"`
+	Protocol          MITMProtocolBlock `json:"protocol"`
+	// ModifierPanics counts responses forwarded unmodified after the
+	// response pipeline panicked outside any plugin.
+	ModifierPanics int64 `json:"modifier_panics"`
+	// DiskBuffered counts response bodies spilled to disk for filtering
+	// (mitm.disk_buffer).
+	DiskBuffered int64 `json:"disk_buffered"`
+	// BufferSkipped counts response bodies over the buffering limits that
+	// streamed through unfiltered.
+	BufferSkipped int64 `json:"buffer_skipped"`
+}
+
+// MITMProtocolBlock holds HTTP version and parse-error counters for
+// intercepted connections.
+type MITMProtocolBlock struct {
+	HTTP10Requests    int64 `json:"http10_requests"`
+	HTTP11Requests    int64 `json:"http11_requests"`
+	HTTP10Responses   int64 `json:"http10_responses"`
+	MalformedRequests int64 `json:"malformed_requests"`
 }
 
 // ConnectionsBlock holds real-time connection counters.
 type ConnectionsBlock struct {
-	Total  int64 `json:"total"`
-	Active int64 `json:"active"`
+	Total     int64 `json:"total"`
+	Active    int64 `json:"active"`
+	Shed      int64 `json:"shed"`      // rejected with 503 at the in-flight limit
+	Truncated int64 `json:"truncated"` // responses cut at proxy.max_response_bytes
+	Sanitized int64 `json:"sanitized"` // responses relayed after dropping malformed headers
+	Panics    int64 `json:"panics"`    // handler panics answered with 500
+
+	// RateLimited counts requests rejected with 429 by proxy.rate_limit.
+	RateLimited int64 `json:"rate_limited"`
+	// ChaosDelayed counts requests delayed by chaos latency injection.
+	ChaosDelayed int64 `json:"chaos_delayed"`
 }
 
 // BlockingBlock holds block statistics.
 type BlockingBlock struct {
-	BlocksTotal      int64      `json:"blocks_total"`
-	AllowsTotal      int64      `json:"allows_total"`
-	BlocklistSize    int        `json:"blocklist_size"`
-	AllowlistSize    int        `json:"allowlist_size"`
-	BlocklistSources int        `json:"blocklist_sources"`
-	TopBlocked       []TopEntry `json:"top_blocked"`
-	TopAllowed       []TopEntry `json:"top_allowed"`
+	BlocksTotal      int64                  `json:"blocks_total"`
+	AllowsTotal      int64                  `json:"allows_total"`
+	BlocklistSize    int                    `json:"blocklist_size"`
+	AllowlistSize    int                    `json:"allowlist_size"`
+	BlocklistSources int                    `json:"blocklist_sources"`
+	TopBlocked       []TopEntry             `json:"top_blocked"`
+	TopAllowed       []TopEntry             `json:"top_allowed"`
+	TopRescued       []TopEntry             `json:"top_rescued"` // allowlist overrides of domains still blocklisted
+	Sources          []BlocklistSourceEntry `json:"sources"`
+	IPBlocksTotal    int64                  `json:"ip_blocks_total"` // refused by blocklist_cidrs, not in blocks_total
+	IPBlocks         []IPBlockEntry         `json:"ip_blocks"`
+	LockWait         *LockWaitBlock         `json:"lock_wait,omitempty"` // blocklist_lock_timing only
+}
+
+// LockWaitBlock reports time blocklist lookups spent waiting for the
+// domain map's read lock.
+type LockWaitBlock struct {
+	Lookups         int64   `json:"lookups"`
+	WaitMicrosTotal int64   `json:"wait_micros_total"`
+	AvgWaitNanos    float64 `json:"avg_wait_nanos"`
+}
+
+// IPBlockEntry is a blocked CIDR with the upstream connections it refused.
+type IPBlockEntry struct {
+	CIDR  string `json:"cidr"`
+	Count int64  `json:"count"`
 }
 
 // DomainsBlock holds domain request statistics.
@@ -174,11 +277,23 @@ type ClientEntry struct {
 	Blocked  int64  `json:"blocked"`
 	BytesIn  int64  `json:"bytes_in"`
 	BytesOut int64  `json:"bytes_out"`
+	// BytesSaved estimates the response bytes the client's blocked requests
+	// would have cost, from average response sizes of allowed traffic.
+	BytesSaved int64 `json:"bytes_saved"`
+	// Zone is the client's network zone (omitted with no zones configured).
+	Zone string `json:"zone,omitempty"`
+
+	// Live gauges (always current, regardless of period).
+	ActiveConnections int64 `json:"active_connections"`
+	CurrentBPS        int64 `json:"current_bps"`
 }
 
 // ClientsBlock holds client statistics.
 type ClientsBlock struct {
 	TopByRequests []ClientEntry `json:"top_by_requests"`
+	// Active lists clients with open connections or traffic in the last
+	// second, by current_bps descending.
+	Active []ClientEntry `json:"active"`
 }
 
 // TrafficBlock holds aggregate traffic totals.
@@ -200,6 +315,9 @@ func BuildHeartbeat(
 			mode = "blocking"
 		}
 	}
+	if info.PassthroughForced() {
+		mode = "passthrough-forced"
+	}
 
 	var mitmEnabled bool
 	var mitmDomains int
@@ -269,27 +387,71 @@ func HeartbeatHandler(
 	}
 }
 
-// StatsProvider supplies data for the full stats response.
+// DomainStatsSource supplies persisted per-domain top-N lists. The Top*
+// methods cover the current period window; the MergedTop* methods combine
+// all-time history with live counters.
+type DomainStatsSource interface {
+	TopBlocked(n int) []stats.DomainCount
+	TopAllowed(n int) []stats.DomainCount
+	TopRequested(n int) []stats.DomainCount
+	MergedTopBlocked(n int) []stats.DomainCount
+	MergedTopAllowed(n int) []stats.DomainCount
+	MergedTopRequested(n int) []stats.DomainCount
+	MergedTopMITM(n int) []stats.DomainCount
+}
+
+// ClientStatsSource supplies persisted per-client lists. n <= 0 means no
+// limit for MergedTopClients.
+type ClientStatsSource interface {
+	TopClientsSince(n int, since time.Time) []stats.ClientSnapshot
+	ZoneClientsSince(zone string, since time.Time) []stats.ClientSnapshot
+	MergedTopClients(n int) []stats.ClientSnapshot
+}
+
+// TrafficSource supplies persisted traffic totals for a time window.
+type TrafficSource interface {
+	TrafficTotalsSince(since time.Time) (requests, blocked, bytesIn, bytesOut int64)
+}
+
+// StatsStore is a persistent stats backend; *stats.DB implements it.
+type StatsStore interface {
+	DomainStatsSource
+	ClientStatsSource
+	TrafficSource
+}
+
+// StatsProvider supplies data for the full stats response. StatsDB is
+// optional; without it, stats come from the Collector's in-memory counters
+// only. Leave it unset rather than storing a nil *stats.DB, which would make
+// the interface non-nil.
 type StatsProvider struct {
 	Info          ServerInfo
 	BlockFn       func() *BlockData
 	MITMFn        func() *MITMData
 	TransparentFn func() *TransparentData
 	PluginsFn     func() *PluginsData
-	StatsDB       *stats.DB
+	StatsDB       StatsStore
 	Collector     *stats.Collector
 	Resolver      *ReverseDNS
 }
 
 // BuildStats constructs a StatsResponse from the given data sources.
 // n controls the top-N list sizes. periodSince filters to a time window (nil = all time).
-func BuildStats(sp *StatsProvider, n int, periodSince *time.Time) StatsResponse {
+// A non-empty zone limits the clients and traffic sections to clients in
+// that network zone; domain and blocking sections are not per-client and
+// stay global.
+func BuildStats(sp *StatsProvider, n int, periodSince *time.Time, zone string) StatsResponse {
 	// Block stats from blocklist DB.
 	var blocksTotal int64
 	var allowsTotal int64
 	var blocklistSize int
 	var allowlistSize int
 	var blocklistSources int
+	var rescued []stats.DomainCount
+	var ipBlocksTotal int64
+	var lockWait *LockWaitBlock
+	sources := []BlocklistSourceEntry{}
+	ipBlocks := []IPBlockEntry{}
 	if sp.BlockFn != nil {
 		if bd := sp.BlockFn(); bd != nil {
 			blocksTotal = bd.Total
@@ -297,8 +459,27 @@ func BuildStats(sp *StatsProvider, n int, periodSince *time.Time) StatsResponse
 			blocklistSize = bd.Size
 			allowlistSize = bd.AllowlistSize
 			blocklistSources = bd.Sources
+			lockWait = bd.LockWait
+			if bd.SourceDetails != nil {
+				sources = bd.SourceDetails
+			}
+			for domain, count := range bd.Rescued {
+				rescued = append(rescued, stats.DomainCount{Domain: domain, Count: count})
+			}
+			for cidr, count := range bd.IPBlocks {
+				ipBlocksTotal += count
+				ipBlocks = append(ipBlocks, IPBlockEntry{CIDR: cidr, Count: count})
+			}
+			sort.Slice(ipBlocks, func(i, j int) bool {
+				if ipBlocks[i].Count != ipBlocks[j].Count {
+					return ipBlocks[i].Count > ipBlocks[j].Count
+				}
+				return ipBlocks[i].CIDR < ipBlocks[j].CIDR
+			})
 		}
 	}
+	// Rescues are in-memory since startup regardless of period.
+	topRescued := domainCountsToEntries(topN(rescued, n))
 
 	var topBlocked []TopEntry
 	var topAllowed []TopEntry
@@ -342,20 +523,52 @@ func BuildStats(sp *StatsProvider, n int, periodSince *time.Time) StatsResponse
 	if topRequested == nil {
 		topRequested = []TopEntry{}
 	}
+	if zone != "" {
+		clients := zoneClients(sp, periodSince, zone)
+		totalReqs, totalBlocked, totalBytesIn, totalBytesOut = 0, 0, 0, 0
+		for _, cs := range clients {
+			totalReqs += cs.Requests
+			totalBlocked += cs.Blocked
+			totalBytesIn += cs.BytesIn
+			totalBytesOut += cs.BytesOut
+		}
+		topClients = clientSnapsToEntries(topNClients(clients, n), sp.Resolver)
+	}
 	if topClients == nil {
 		topClients = []ClientEntry{}
 	}
+	live := sp.Collector.SnapshotLiveClients()
+	if zone != "" {
+		var inZone []stats.LiveClientSnapshot
+		for _, lc := range live {
+			if sp.Collector.Zone(lc.IP) == zone {
+				inZone = append(inZone, lc)
+			}
+		}
+		live = inZone
+	}
+	applyLiveGauges(topClients, live)
+	activeClients := activeClientEntries(live, sp.Collector.SnapshotClients(), n, sp.Resolver)
 
-	// MITM stats (always from in-memory — no DB persistence for MITM yet).
+	// MITM session stats are in-memory; per-domain intercepts are persisted.
 	mitmBlock := MITMBlock{}
 	if sp.MITMFn != nil {
 		if md := sp.MITMFn(); md != nil {
 			mitmBlock.Enabled = md.Enabled
 			mitmBlock.InterceptsTotal = md.InterceptsTotal
 			mitmBlock.DomainsConfigured = md.DomainsConfigured
+			mitmBlock.Protocol = md.Protocol
+			mitmBlock.ModifierPanics = md.ModifierPanics
+			mitmBlock.DiskBuffered = md.DiskBuffered
+			mitmBlock.BufferSkipped = md.BufferSkipped
 		}
 	}
-	topMITM := domainCountsToEntries(topN(sp.Collector.SnapshotMITMIntercepts(), n))
+	var topMITM []TopEntry
+	if sp.StatsDB != nil {
+		topMITM = domainCountsToEntries(sp.StatsDB.MergedTopMITM(n))
+	} else {
+		topMITM = domainCountsToEntries(topN(sp.Collector.SnapshotMITMIntercepts(), n))
+	}
 	if topMITM == nil {
 		topMITM = []TopEntry{}
 	}
@@ -375,10 +588,22 @@ func BuildStats(sp *StatsProvider, n int, periodSince *time.Time) StatsResponse
 	transparentBlock.Blocked = sp.Collector.TransparentBlock.Load()
 	transparentBlock.SNIMissing = sp.Collector.SNIMissing.Load()
 
+	cacheBlock := CacheBlock{
+		Hits:   sp.Collector.CacheHits.Load(),
+		Misses: sp.Collector.CacheMisses.Load(),
+	}
+
 	return StatsResponse{
 		Connections: ConnectionsBlock{
-			Total:  sp.Info.ConnectionsTotal(),
-			Active: sp.Info.ConnectionsActive(),
+			Total:     sp.Info.ConnectionsTotal(),
+			Active:    sp.Info.ConnectionsActive(),
+			Shed:      sp.Info.ConnectionsShed(),
+			Truncated: sp.Info.ResponsesTruncated(),
+			Sanitized: sp.Info.ResponsesSanitized(),
+			Panics:    sp.Info.Panics(),
+
+			RateLimited:  sp.Info.ConnectionsRateLimited(),
+			ChaosDelayed: sp.Info.ChaosDelayed(),
 		},
 		Blocking: BlockingBlock{
 			BlocksTotal:      blocksTotal,
@@ -388,15 +613,22 @@ func BuildStats(sp *StatsProvider, n int, periodSince *time.Time) StatsResponse
 			BlocklistSources: blocklistSources,
 			TopBlocked:       topBlocked,
 			TopAllowed:       topAllowed,
+			TopRescued:       topRescued,
+			Sources:          sources,
+			IPBlocksTotal:    ipBlocksTotal,
+			IPBlocks:         ipBlocks,
+			LockWait:         lockWait,
 		},
 		MITM:        mitmBlock,
 		Transparent: transparentBlock,
+		Cache:       cacheBlock,
 		Plugins:     pluginsBlock,
 		Domains: DomainsBlock{
 			TopRequested: topRequested,
 		},
 		Clients: ClientsBlock{
 			TopByRequests: topClients,
+			Active:        activeClients,
 		},
 		Traffic: TrafficBlock{
 			TotalRequests: totalReqs,
@@ -413,7 +645,9 @@ func BuildStats(sp *StatsProvider, n int, periodSince *time.Time) StatsResponse
 }
 
 // StatsHandler returns an http.HandlerFunc for the full stats endpoint.
-// Supports query parameters: n (top-N size), period (time window).
+// Supports query parameters: n (top-N size), period (time window), zone
+// (network zone filter for clients and traffic), and pretty (indent the
+// JSON for reading by eye; compact by default).
 func StatsHandler(sp *StatsProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		n := 10
@@ -423,31 +657,171 @@ func StatsHandler(sp *StatsProvider) http.HandlerFunc {
 			}
 		}
 
-		var periodSince *time.Time
-		if period := r.URL.Query().Get("period"); period != "" {
-			var d time.Duration
-			switch period {
-			case "1h":
-				d = time.Hour
-			case "24h":
-				d = 24 * time.Hour
-			case "7d":
-				d = 7 * 24 * time.Hour
-			}
-			if d > 0 {
-				t := time.Now().Add(-d)
-				periodSince = &t
-			}
+		periodSince := parsePeriod(r.URL.Query().Get("period"), time.Now())
+
+		resp := BuildStats(sp, n, periodSince, r.URL.Query().Get("zone"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty { //nolint:errcheck // invalid means compact
+			enc.SetIndent("", "  ")
+		}
+		_ = enc.Encode(resp) //nolint:gosec // best-effort response
+	}
+}
+
+// parsePeriod resolves a period parameter ("1h", "24h", "7d") to the start
+// of the window ending at now. Empty or unknown periods mean all time (nil).
+func parsePeriod(period string, now time.Time) *time.Time {
+	var d time.Duration
+	switch period {
+	case "1h":
+		d = time.Hour
+	case "24h":
+		d = 24 * time.Hour
+	case "7d":
+		d = 7 * 24 * time.Hour
+	default:
+		return nil
+	}
+	t := now.Add(-d)
+	return &t
+}
+
+// ExportCSVHandler returns an http.HandlerFunc that downloads the hourly
+// per-client traffic rows as CSV. The period query parameter limits the
+// window as for StatsHandler; without it every retained hour is exported.
+func ExportCSVHandler(db *stats.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var since time.Time
+		if p := parsePeriod(r.URL.Query().Get("period"), time.Now()); p != nil {
+			since = *p
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="fps-traffic.csv"`)
+		w.WriteHeader(http.StatusOK)
+		_ = db.ExportCSV(w, since) //nolint:errcheck // headers are sent; a failed export truncates the download
+	}
+}
+
+// NewDomainsResponse is the JSON response for the new-domains endpoint.
+type NewDomainsResponse struct {
+	Since   string             `json:"since"`
+	Domains []stats.DomainSeen `json:"domains"`
+}
+
+// defaultNewDomainsWindow is the lookback when ?since= is omitted.
+const defaultNewDomainsWindow = 24 * time.Hour
+
+// NewDomainsHandler returns an http.HandlerFunc listing domains first seen
+// within a window. The since query parameter is a lookback ("6h", "7d") or
+// an RFC 3339 timestamp; it defaults to 24h.
+func NewDomainsHandler(db *stats.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since, err := parseSince(r.URL.Query().Get("since"), time.Now())
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}) //nolint:gosec // best-effort response
+			return
+		}
+
+		resp := NewDomainsResponse{
+			Since:   since.UTC().Format(time.RFC3339),
+			Domains: db.NewDomainsSince(since),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp) //nolint:gosec // best-effort response
+	}
+}
+
+// parseSince resolves a since parameter relative to now.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return now.Add(-defaultNewDomainsWindow), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.Add(-time.Duration(n) * 24 * time.Hour), nil
 		}
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %q: want a duration (6h, 7d) or RFC 3339 time", v)
+}
 
-		resp := BuildStats(sp, n, periodSince)
+// SuggestionsResponse is the JSON response for the suggestions endpoint.
+type SuggestionsResponse struct {
+	Advisory    string               `json:"advisory"`
+	Suggestions []suggest.Suggestion `json:"suggestions"`
+}
 
+// SuggestionsHandler returns an http.HandlerFunc listing allowlist
+// suggestions from t. The list is advisory; nothing is applied.
+func SuggestionsHandler(t *suggest.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		resp := SuggestionsResponse{
+			Advisory:    "blocked domains frequently requested by these sites; allowlist only if the sites are broken",
+			Suggestions: t.Suggestions(),
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(resp) //nolint:gosec // best-effort response
 	}
 }
 
+// CandidatesResponse is the JSON response for the candidates endpoint.
+type CandidatesResponse struct {
+	Advisory   string            `json:"advisory"`
+	Candidates []learn.Candidate `json:"candidates"`
+}
+
+// CandidatesHandler returns an http.HandlerFunc listing blocklist
+// candidates recorded by l in learning mode. The list is advisory; nothing
+// is blocked.
+func CandidatesHandler(l *learn.Learner) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		candidates, err := l.Candidates()
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"candidates unavailable"}`)) //nolint:errcheck // best-effort response
+			return
+		}
+		resp := CandidatesResponse{
+			Advisory:   "requested domains whose names look like ads or trackers; review before adding to the blocklist",
+			Candidates: candidates,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(resp) //nolint:gosec // best-effort response
+	}
+}
+
+// SessionsResponse is the JSON response for the sessions endpoint.
+type SessionsResponse struct {
+	Count    int            `json:"count"`
+	Sessions []session.Info `json:"sessions"`
+}
+
+// BuildSessions merges the session lists of several listeners, oldest
+// first.
+func BuildSessions(lists ...[]session.Info) SessionsResponse {
+	all := []session.Info{}
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	session.SortByStart(all)
+	return SessionsResponse{Count: len(all), Sessions: all}
+}
+
 // StatsDisabledHandler returns 501 Not Implemented when stats are disabled.
 func StatsDisabledHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
@@ -473,16 +847,20 @@ func buildPluginsBlock(sp *StatsProvider, n int) PluginsBlock {
 	snaps := sp.Collector.SnapshotPlugins()
 	for _, pi := range pd.Plugins {
 		entry := PluginFilterEntry{
-			Name:    pi.Name,
-			Version: pi.Version,
-			Mode:    pi.Mode,
-			Domains: pi.Domains,
+			Name:          pi.Name,
+			Version:       pi.Version,
+			Mode:          pi.Mode,
+			Domains:       pi.Domains,
+			PausedDomains: pi.PausedDomains,
 		}
 		for _, s := range snaps {
 			if s.Name == pi.Name {
 				entry.ResponsesInspected = s.Inspected
 				entry.ResponsesMatched = s.Matched
 				entry.ResponsesModified = s.Modified
+				entry.FilterMicrosTotal = s.FilterNanos / 1e3
+				entry.AvgFilterMicros = s.AvgFilterMicros()
+				entry.Panics = s.Panics
 				break
 			}
 		}
@@ -495,6 +873,9 @@ func buildPluginsBlock(sp *StatsProvider, n int) PluginsBlock {
 		if entry.Domains == nil {
 			entry.Domains = []string{}
 		}
+		if entry.PausedDomains == nil {
+			entry.PausedDomains = []string{}
+		}
 		block.Filters = append(block.Filters, entry)
 	}
 	return block
@@ -515,11 +896,13 @@ func clientSnapsToEntries(snaps []stats.ClientSnapshot, resolver *ReverseDNS) []
 	out := make([]ClientEntry, len(snaps))
 	for i, cs := range snaps {
 		out[i] = ClientEntry{
-			ClientIP: cs.IP,
-			Requests: cs.Requests,
-			Blocked:  cs.Blocked,
-			BytesIn:  cs.BytesIn,
-			BytesOut: cs.BytesOut,
+			ClientIP:   cs.IP,
+			Requests:   cs.Requests,
+			Blocked:    cs.Blocked,
+			BytesIn:    cs.BytesIn,
+			BytesOut:   cs.BytesOut,
+			BytesSaved: cs.BytesSaved,
+			Zone:       cs.Zone,
 		}
 		if resolver != nil {
 			out[i].Hostname = resolver.Lookup(cs.IP)
@@ -528,6 +911,75 @@ func clientSnapsToEntries(snaps []stats.ClientSnapshot, resolver *ReverseDNS) []
 	return out
 }
 
+// applyLiveGauges fills the live connection and byte-rate gauges of entries
+// from the collector's live client snapshot.
+func applyLiveGauges(entries []ClientEntry, live []stats.LiveClientSnapshot) {
+	byIP := make(map[string]stats.LiveClientSnapshot, len(live))
+	for _, lc := range live {
+		byIP[lc.IP] = lc
+	}
+	for i := range entries {
+		lc := byIP[entries[i].ClientIP]
+		entries[i].ActiveConnections = lc.Active
+		entries[i].CurrentBPS = lc.BPS
+	}
+}
+
+// activeClientEntries returns up to n live clients with open connections or
+// a nonzero byte rate, by byte rate then connection count, with their
+// cumulative in-memory counters.
+func activeClientEntries(live []stats.LiveClientSnapshot, snaps []stats.ClientSnapshot, n int, resolver *ReverseDNS) []ClientEntry {
+	byIP := make(map[string]stats.ClientSnapshot, len(snaps))
+	for _, cs := range snaps {
+		byIP[cs.IP] = cs
+	}
+	var active []stats.ClientSnapshot
+	for _, lc := range live {
+		if lc.Active == 0 && lc.BPS == 0 {
+			continue
+		}
+		cs := byIP[lc.IP]
+		cs.IP = lc.IP
+		active = append(active, cs)
+	}
+	out := clientSnapsToEntries(active, resolver)
+	applyLiveGauges(out, live)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CurrentBPS != out[j].CurrentBPS {
+			return out[i].CurrentBPS > out[j].CurrentBPS
+		}
+		if out[i].ActiveConnections != out[j].ActiveConnections {
+			return out[i].ActiveConnections > out[j].ActiveConnections
+		}
+		return out[i].ClientIP < out[j].ClientIP
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// zoneClients returns every client in zone, from the same source BuildStats
+// uses for the unfiltered client list.
+func zoneClients(sp *StatsProvider, periodSince *time.Time, zone string) []stats.ClientSnapshot {
+	if periodSince != nil && sp.StatsDB != nil {
+		return sp.StatsDB.ZoneClientsSince(zone, *periodSince)
+	}
+	var all []stats.ClientSnapshot
+	if sp.StatsDB != nil {
+		all = sp.StatsDB.MergedTopClients(0)
+	} else {
+		all = sp.Collector.SnapshotClients()
+	}
+	var out []stats.ClientSnapshot
+	for _, cs := range all {
+		if cs.Zone == zone {
+			out = append(out, cs)
+		}
+	}
+	return out
+}
+
 // topN returns the top n entries from a DomainCount slice (sorts in-place).
 func topN(dcs []stats.DomainCount, n int) []stats.DomainCount {
 	for i := 1; i < len(dcs); i++ {
@@ -1,29 +1,47 @@
 package probe_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/learn"
 	"github.com/ushineko/face-puncher-supreme/internal/probe"
 	"github.com/ushineko/face-puncher-supreme/internal/stats"
+	"github.com/ushineko/face-puncher-supreme/internal/statsd"
+	"github.com/ushineko/face-puncher-supreme/internal/suggest"
 )
 
 type _mockServerInfo struct {
 	total     int64
 	active    int64
+	shed      int64
+	truncated int64
+	forced    bool
 	uptime    time.Duration
 	startedAt time.Time
 }
 
-func (m *_mockServerInfo) ConnectionsTotal() int64  { return m.total }
-func (m *_mockServerInfo) ConnectionsActive() int64 { return m.active }
-func (m *_mockServerInfo) Uptime() time.Duration    { return m.uptime }
-func (m *_mockServerInfo) StartedAt() time.Time     { return m.startedAt }
+func (m *_mockServerInfo) ConnectionsTotal() int64   { return m.total }
+func (m *_mockServerInfo) ConnectionsActive() int64  { return m.active }
+func (m *_mockServerInfo) ConnectionsShed() int64    { return m.shed }
+func (m *_mockServerInfo) PassthroughForced() bool   { return m.forced }
+func (m *_mockServerInfo) ResponsesTruncated() int64 { return m.truncated }
+func (m *_mockServerInfo) ResponsesSanitized() int64 { return 0 }
+func (m *_mockServerInfo) Panics() int64             { return 0 }
+func (m *_mockServerInfo) Uptime() time.Duration     { return m.uptime }
+func (m *_mockServerInfo) StartedAt() time.Time      { return m.startedAt }
+
+func (m *_mockServerInfo) ConnectionsRateLimited() int64 { return 0 }
+func (m *_mockServerInfo) ChaosDelayed() int64           { return 0 }
 
 func TestHeartbeatHandler(t *testing.T) {
 	tests := []struct {
@@ -124,11 +142,21 @@ func TestHeartbeatHandlerBlockingMode(t *testing.T) {
 	assert.Equal(t, "blocking", resp.Mode)
 }
 
+func TestHeartbeatPassthroughForcedMode(t *testing.T) {
+	blockFn := func() *probe.BlockData { return &probe.BlockData{Size: 1000} }
+	info := &_mockServerInfo{forced: true}
+
+	resp := probe.BuildHeartbeat(info, blockFn, nil, nil, nil)
+	assert.Equal(t, "passthrough-forced", resp.Mode)
+}
+
 func TestStatsHandler(t *testing.T) {
 	collector := stats.NewCollector()
 	collector.RecordRequest("192.168.1.42", "www.example.com", false, 100, 5000)
 	collector.RecordRequest("192.168.1.42", "ads.example.com", true, 0, 0)
 	collector.RecordRequest("192.168.1.15", "www.example.com", false, 200, 3000)
+	collector.ConnOpened("192.168.1.15")
+	collector.ConnOpened("192.168.1.15")
 
 	info := &_mockServerInfo{total: 50, active: 2, startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
 	blockFn := func() *probe.BlockData {
@@ -177,6 +205,220 @@ func TestStatsHandler(t *testing.T) {
 	require.NotEmpty(t, resp.Clients.TopByRequests)
 	assert.Equal(t, "192.168.1.42", resp.Clients.TopByRequests[0].ClientIP)
 	assert.Equal(t, int64(2), resp.Clients.TopByRequests[0].Requests)
+	assert.Equal(t, int64(2), resp.Clients.TopByRequests[1].ActiveConnections)
+
+	// Only 192.168.1.15 has open connections.
+	require.Len(t, resp.Clients.Active, 1)
+	assert.Equal(t, "192.168.1.15", resp.Clients.Active[0].ClientIP)
+	assert.Equal(t, int64(2), resp.Clients.Active[0].ActiveConnections)
+	assert.Equal(t, int64(1), resp.Clients.Active[0].Requests)
+}
+
+func TestStatsTopRescued(t *testing.T) {
+	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	blockFn := func() *probe.BlockData {
+		return &probe.BlockData{
+			AllowsTotal: 7,
+			Rescued:     map[string]int64{"cdn.example.com": 2, "metrics.example.com": 5},
+		}
+	}
+
+	resp := probe.BuildStats(&probe.StatsProvider{Info: info, BlockFn: blockFn, Collector: stats.NewCollector()}, 10, nil, "")
+	assert.Equal(t, []probe.TopEntry{
+		{Domain: "metrics.example.com", Count: 5},
+		{Domain: "cdn.example.com", Count: 2},
+	}, resp.Blocking.TopRescued)
+
+	resp = probe.BuildStats(&probe.StatsProvider{Info: info, Collector: stats.NewCollector()}, 10, nil, "")
+	assert.NotNil(t, resp.Blocking.TopRescued, "empty list, not null")
+	assert.Empty(t, resp.Blocking.TopRescued)
+}
+
+func TestStatsIPBlocks(t *testing.T) {
+	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	blockFn := func() *probe.BlockData {
+		return &probe.BlockData{
+			Total:    4,
+			IPBlocks: map[string]int64{"203.0.113.0/24": 3, "2001:db8::/32": 9},
+		}
+	}
+
+	resp := probe.BuildStats(&probe.StatsProvider{Info: info, BlockFn: blockFn, Collector: stats.NewCollector()}, 10, nil, "")
+	assert.Equal(t, int64(4), resp.Blocking.BlocksTotal, "ip blocks are counted apart")
+	assert.Equal(t, int64(12), resp.Blocking.IPBlocksTotal)
+	assert.Equal(t, []probe.IPBlockEntry{
+		{CIDR: "2001:db8::/32", Count: 9},
+		{CIDR: "203.0.113.0/24", Count: 3},
+	}, resp.Blocking.IPBlocks)
+
+	resp = probe.BuildStats(&probe.StatsProvider{Info: info, Collector: stats.NewCollector()}, 10, nil, "")
+	assert.NotNil(t, resp.Blocking.IPBlocks, "empty list, not null")
+}
+
+func TestMetricsHandlerTopDomains(t *testing.T) {
+	collector := stats.NewCollector()
+	for i, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		for range 3 - i {
+			collector.RecordRequest("192.168.1.42", domain, true, 0, 0)
+		}
+	}
+	collector.RecordRequest("192.168.1.42", "www.example.com", false, 100, 5000)
+	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	blockFn := func() *probe.BlockData { return &probe.BlockData{Total: 6, Size: 3} }
+	sp := &probe.StatsProvider{Info: info, BlockFn: blockFn, Collector: collector}
+
+	rec := httptest.NewRecorder()
+	probe.MetricsHandler(sp, 2)(rec, httptest.NewRequest(http.MethodGet, "/fps/metrics", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+
+	body := rec.Body.String()
+	assert.Contains(t, body, "fps_requests_total 7\n")
+	assert.Contains(t, body, "fps_blocks_total 6\n")
+	assert.Contains(t, body, "# TYPE fps_blocked_total counter\n")
+	assert.Contains(t, body, `fps_blocked_total{domain="a.example.com"} 3`)
+	assert.Contains(t, body, `fps_blocked_total{domain="b.example.com"} 2`)
+	assert.NotContains(t, body, "c.example.com", "only the top domains are labeled")
+	assert.Equal(t, 2, strings.Count(body, "fps_blocked_total{"))
+
+	rec = httptest.NewRecorder()
+	probe.MetricsHandler(sp, 0)(rec, httptest.NewRequest(http.MethodGet, "/fps/metrics", http.NoBody))
+	assert.NotContains(t, rec.Body.String(), "fps_blocked_total{")
+	assert.Contains(t, rec.Body.String(), "fps_blocks_total 6\n")
+}
+
+func TestStatsDSamples(t *testing.T) {
+	collector := stats.NewCollector()
+	collector.RecordRequest("192.168.1.42", "ads.example.com", true, 0, 0)
+	collector.RecordRequest("192.168.1.42", "www.example.com", false, 100, 5000)
+	info := &_mockServerInfo{active: 2}
+	blockFn := func() *probe.BlockData { return &probe.BlockData{Total: 1, AllowsTotal: 4, Size: 3} }
+	sp := &probe.StatsProvider{Info: info, BlockFn: blockFn, Collector: collector}
+
+	assert.Equal(t, []statsd.Sample{
+		{Name: "requests", Value: 2, Counter: true},
+		{Name: "blocks", Value: 1, Counter: true},
+		{Name: "allows", Value: 4, Counter: true},
+		{Name: "bytes_in", Value: 100, Counter: true},
+		{Name: "bytes_out", Value: 5000, Counter: true},
+		{Name: "connections.active", Value: 2},
+		{Name: "blocklist.domains", Value: 3},
+	}, probe.StatsDSamples(sp))
+}
+
+func TestStatsHandlerPretty(t *testing.T) {
+	collector := stats.NewCollector()
+	collector.RecordRequest("192.168.1.42", "www.example.com", false, 100, 5000)
+	info := &_mockServerInfo{total: 5, startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	handler := probe.StatsHandler(&probe.StatsProvider{Info: info, Collector: collector})
+
+	get := func(target string) []byte {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.Bytes()
+	}
+	compact := get("/fps/stats")
+	pretty := get("/fps/stats?pretty=true")
+
+	assert.Equal(t, 1, bytes.Count(compact, []byte("\n")), "compact output is a single line")
+	assert.Contains(t, string(pretty), "\n  \"connections\": {")
+
+	var fromCompact, fromPretty probe.StatsResponse
+	require.NoError(t, json.Unmarshal(compact, &fromCompact))
+	require.NoError(t, json.Unmarshal(pretty, &fromPretty))
+	assert.Equal(t, fromCompact.Connections, fromPretty.Connections)
+	assert.Equal(t, fromCompact.Traffic, fromPretty.Traffic)
+	assert.Equal(t, fromCompact.Domains, fromPretty.Domains)
+}
+
+func TestNewDomainsHandler(t *testing.T) {
+	collector := stats.NewCollector()
+	db, err := stats.Open(":memory:", collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	collector.RecordRequest("10.0.0.1", "fresh.example.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+
+	handler := probe.NewDomainsHandler(db)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/fps/stats/new-domains?since=1h", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp probe.NewDomainsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Domains, 1)
+	assert.Equal(t, "fresh.example.com", resp.Domains[0].Domain)
+
+	// A window starting in the future excludes everything.
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/fps/stats/new-domains?since="+future, http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Empty(t, resp.Domains)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/fps/stats/new-domains?since=yesterday", http.NoBody))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestExportCSVHandler(t *testing.T) {
+	collector := stats.NewCollector()
+	db, err := stats.Open(":memory:", collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	collector.RecordRequest("10.0.0.1", "example.com", false, 20, 300)
+	require.NoError(t, db.Flush())
+
+	rec := httptest.NewRecorder()
+	probe.ExportCSVHandler(db)(rec, httptest.NewRequest(http.MethodGet, "/fps/stats/export.csv?period=7d", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="fps-traffic.csv"`, rec.Header().Get("Content-Disposition"))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "hour,client_ip,requests,blocked,bytes_in,bytes_out", lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], ",10.0.0.1,1,0,20,300"), lines[1])
+}
+
+func TestSuggestionsHandler(t *testing.T) {
+	tracker := suggest.NewTracker(suggest.Config{MinBlocks: 2})
+	tracker.RecordBlocked("10.0.0.1", "cdn.example.net", "https://site.example.com/")
+	tracker.RecordBlocked("10.0.0.1", "cdn.example.net", "https://site.example.com/")
+
+	rec := httptest.NewRecorder()
+	probe.SuggestionsHandler(tracker)(rec, httptest.NewRequest(http.MethodGet, "/fps/suggestions", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var resp probe.SuggestionsResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp.Advisory)
+	require.Len(t, resp.Suggestions, 1)
+	assert.Equal(t, "cdn.example.net", resp.Suggestions[0].Domain)
+	assert.Equal(t, []string{"site.example.com"}, resp.Suggestions[0].Sites)
+}
+
+func TestCandidatesHandler(t *testing.T) {
+	learner, err := learn.Open(":memory:", learn.Config{}, slog.Default())
+	require.NoError(t, err)
+	defer learner.Close() //nolint:errcheck // test cleanup
+	learner.Record("www.example.com")
+	learner.Record("telemetry.example.com")
+
+	rec := httptest.NewRecorder()
+	probe.CandidatesHandler(learner)(rec, httptest.NewRequest(http.MethodGet, "/fps/candidates", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var resp probe.CandidatesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.NotEmpty(t, resp.Advisory)
+	require.Len(t, resp.Candidates, 1)
+	assert.Equal(t, "telemetry.example.com", resp.Candidates[0].Domain)
+	assert.Equal(t, "telemetry", resp.Candidates[0].Pattern)
 }
 
 func TestStatsHandlerTopN(t *testing.T) {
@@ -213,12 +455,50 @@ func TestStatsDisabledHandler(t *testing.T) {
 	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
 }
 
+func TestPACHandler(t *testing.T) {
+	get := func(handler http.HandlerFunc, host string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/fps/proxy.pac", http.NoBody)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// Wildcard listen address: the host the client used is the proxy host.
+	rec := get(probe.PACHandler(":18737", "", nil), "192.168.1.10:18737")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ns-proxy-autoconfig", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "function FindProxyForURL(url, host) {\n"+
+		"  return \"PROXY 192.168.1.10:18737\";\n"+
+		"}\n", rec.Body.String())
+
+	rec = get(probe.PACHandler("0.0.0.0:18737", "", nil), "fpsd.lan")
+	assert.Contains(t, rec.Body.String(), `return "PROXY fpsd.lan:18737";`)
+
+	// A specific listen host is used as-is; external_host overrides it.
+	rec = get(probe.PACHandler("10.0.0.2:18737", "", nil), "localhost:18737")
+	assert.Contains(t, rec.Body.String(), `return "PROXY 10.0.0.2:18737";`)
+	rec = get(probe.PACHandler("10.0.0.2:18737", "proxy.example.lan", nil), "localhost:18737")
+	assert.Contains(t, rec.Body.String(), `return "PROXY proxy.example.lan:18737";`)
+	rec = get(probe.PACHandler(":18737", "fd00::2", nil), "localhost:18737")
+	assert.Contains(t, rec.Body.String(), `return "PROXY [fd00::2]:18737";`)
+
+	// Direct entries mirror allowlist syntax.
+	rec = get(probe.PACHandler(":18737", "fpsd.lan", []string{"Zoom.us", "*.meet.example"}), "")
+	body := rec.Body.String()
+	assert.Contains(t, body, "host = host.toLowerCase();")
+	assert.Contains(t, body, `host == "zoom.us" ||`)
+	assert.Contains(t, body, `host == "meet.example" || dnsDomainIs(host, ".meet.example")`)
+	assert.Contains(t, body, `return "DIRECT";`)
+	assert.True(t, strings.HasSuffix(body, "  return \"PROXY fpsd.lan:18737\";\n}\n"))
+}
+
 func TestStatsResponseResources(t *testing.T) {
 	collector := stats.NewCollector()
 	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
 	sp := &probe.StatsProvider{Info: info, Collector: collector}
 
-	resp := probe.BuildStats(sp, 10, nil)
+	resp := probe.BuildStats(sp, 10, nil, "")
 
 	assert.Greater(t, resp.Resources.Goroutines, 0, "goroutines should be > 0")
 	assert.Greater(t, resp.Resources.MemSysMB, 0.0, "mem_sys_mb should be > 0")
@@ -231,7 +511,7 @@ func TestStatsResponseResourcesFDs(t *testing.T) {
 	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
 	sp := &probe.StatsProvider{Info: info, Collector: collector}
 
-	resp := probe.BuildStats(sp, 10, nil)
+	resp := probe.BuildStats(sp, 10, nil, "")
 
 	// On Linux, FDs should be available.
 	// On other platforms, they're -1 (stub).
@@ -247,13 +527,152 @@ func TestStatsResponseWatermarks(t *testing.T) {
 	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
 	sp := &probe.StatsProvider{Info: info, Collector: collector}
 
-	resp := probe.BuildStats(sp, 10, nil)
+	resp := probe.BuildStats(sp, 10, nil, "")
 
 	// Without the sampler running, watermarks should be zero.
 	assert.Equal(t, 0.0, resp.Watermarks.PeakReqPerSec)
 	assert.Equal(t, int64(0), resp.Watermarks.PeakBytesInSec)
 }
 
+func TestStatsZoneFilter(t *testing.T) {
+	collector := stats.NewCollector()
+	collector.SetZones(stats.NewZones(map[string][]netip.Prefix{
+		"guest": {netip.MustParsePrefix("192.168.50.0/24")},
+	}))
+	collector.RecordRequest("192.168.50.10", "a.com", false, 10, 100)
+	collector.RecordRequest("192.168.50.10", "ads.com", true, 0, 0)
+	collector.RecordRequest("192.168.1.2", "a.com", false, 20, 200)
+	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sp := &probe.StatsProvider{Info: info, Collector: collector}
+
+	resp := probe.BuildStats(sp, 10, nil, "guest")
+	require.Len(t, resp.Clients.TopByRequests, 1)
+	assert.Equal(t, "192.168.50.10", resp.Clients.TopByRequests[0].ClientIP)
+	assert.Equal(t, "guest", resp.Clients.TopByRequests[0].Zone)
+	assert.Equal(t, int64(2), resp.Traffic.TotalRequests)
+	assert.Equal(t, int64(1), resp.Traffic.TotalBlocked)
+	assert.Equal(t, int64(10), resp.Traffic.TotalBytesIn)
+
+	resp = probe.BuildStats(sp, 10, nil, stats.DefaultZone)
+	require.Len(t, resp.Clients.TopByRequests, 1)
+	assert.Equal(t, "192.168.1.2", resp.Clients.TopByRequests[0].ClientIP)
+
+	resp = probe.BuildStats(sp, 10, nil, "")
+	assert.Len(t, resp.Clients.TopByRequests, 2)
+	assert.Equal(t, int64(3), resp.Traffic.TotalRequests)
+}
+
+// _mockStatsStore is a probe.StatsStore whose answers name the method that
+// produced them, so tests can tell which BuildStats branch ran.
+type _mockStatsStore struct {
+	since []time.Time
+}
+
+func _domains(domain string) []stats.DomainCount {
+	return []stats.DomainCount{{Domain: domain, Count: 1}}
+}
+
+func (m *_mockStatsStore) TopBlocked(int) []stats.DomainCount { return _domains("period-blocked") }
+func (m *_mockStatsStore) TopAllowed(int) []stats.DomainCount { return _domains("period-allowed") }
+func (m *_mockStatsStore) TopRequested(int) []stats.DomainCount {
+	return _domains("period-requested")
+}
+func (m *_mockStatsStore) MergedTopBlocked(int) []stats.DomainCount {
+	return _domains("merged-blocked")
+}
+func (m *_mockStatsStore) MergedTopAllowed(int) []stats.DomainCount {
+	return _domains("merged-allowed")
+}
+func (m *_mockStatsStore) MergedTopRequested(int) []stats.DomainCount {
+	return _domains("merged-requested")
+}
+func (m *_mockStatsStore) MergedTopMITM(int) []stats.DomainCount { return _domains("merged-mitm") }
+
+func (m *_mockStatsStore) TopClientsSince(_ int, since time.Time) []stats.ClientSnapshot {
+	m.since = append(m.since, since)
+	return []stats.ClientSnapshot{{IP: "10.0.0.1", Requests: 7}}
+}
+
+func (m *_mockStatsStore) ZoneClientsSince(string, time.Time) []stats.ClientSnapshot { return nil }
+
+func (m *_mockStatsStore) MergedTopClients(int) []stats.ClientSnapshot {
+	return []stats.ClientSnapshot{{IP: "10.0.0.2", Requests: 9}}
+}
+
+func (m *_mockStatsStore) TrafficTotalsSince(since time.Time) (requests, blocked, bytesIn, bytesOut int64) {
+	m.since = append(m.since, since)
+	return 70, 7, 700, 7000
+}
+
+func TestBuildStatsSources(t *testing.T) {
+	info := &_mockServerInfo{startedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	newCollector := func() *stats.Collector {
+		c := stats.NewCollector()
+		c.RecordRequest("10.0.0.3", "live.com", false, 10, 100)
+		c.RecordRequest("10.0.0.3", "ads.live.com", true, 0, 0)
+		c.RecordMITMRequest("10.0.0.3", "mitm.live.com")
+		return c
+	}
+	domainsOf := func(entries []probe.TopEntry) []string {
+		out := make([]string, len(entries))
+		for i, e := range entries {
+			out[i] = e.Domain
+		}
+		return out
+	}
+
+	t.Run("period", func(t *testing.T) {
+		store := &_mockStatsStore{}
+		sp := &probe.StatsProvider{Info: info, StatsDB: store, Collector: newCollector()}
+		since := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		resp := probe.BuildStats(sp, 10, &since, "")
+		assert.Equal(t, []string{"period-blocked"}, domainsOf(resp.Blocking.TopBlocked))
+		assert.Equal(t, []string{"period-allowed"}, domainsOf(resp.Blocking.TopAllowed))
+		assert.Equal(t, []string{"period-requested"}, domainsOf(resp.Domains.TopRequested))
+		assert.Equal(t, []string{"merged-mitm"}, domainsOf(resp.MITM.TopIntercepted))
+		require.Len(t, resp.Clients.TopByRequests, 1)
+		assert.Equal(t, "10.0.0.1", resp.Clients.TopByRequests[0].ClientIP)
+		assert.Equal(t, probe.TrafficBlock{
+			TotalRequests: 70, TotalBlocked: 7, TotalBytesIn: 700, TotalBytesOut: 7000,
+		}, resp.Traffic)
+		assert.Equal(t, []time.Time{since, since}, store.since)
+	})
+
+	t.Run("all time", func(t *testing.T) {
+		store := &_mockStatsStore{}
+		sp := &probe.StatsProvider{Info: info, StatsDB: store, Collector: newCollector()}
+
+		resp := probe.BuildStats(sp, 10, nil, "")
+		assert.Equal(t, []string{"merged-blocked"}, domainsOf(resp.Blocking.TopBlocked))
+		assert.Equal(t, []string{"merged-allowed"}, domainsOf(resp.Blocking.TopAllowed))
+		assert.Equal(t, []string{"merged-requested"}, domainsOf(resp.Domains.TopRequested))
+		assert.Equal(t, []string{"merged-mitm"}, domainsOf(resp.MITM.TopIntercepted))
+		require.Len(t, resp.Clients.TopByRequests, 1)
+		assert.Equal(t, "10.0.0.2", resp.Clients.TopByRequests[0].ClientIP)
+		// All-time totals come from the collector, not the store.
+		assert.Equal(t, int64(2), resp.Traffic.TotalRequests)
+		assert.Equal(t, int64(1), resp.Traffic.TotalBlocked)
+		assert.Empty(t, store.since)
+	})
+
+	t.Run("no store", func(t *testing.T) {
+		sp := &probe.StatsProvider{Info: info, Collector: newCollector()}
+		since := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		// Without a store the period is ignored.
+		resp := probe.BuildStats(sp, 10, &since, "")
+		assert.Equal(t, []string{"ads.live.com"}, domainsOf(resp.Blocking.TopBlocked))
+		assert.Empty(t, resp.Blocking.TopAllowed)
+		assert.ElementsMatch(t, []string{"live.com", "ads.live.com"}, domainsOf(resp.Domains.TopRequested))
+		assert.Equal(t, []string{"mitm.live.com"}, domainsOf(resp.MITM.TopIntercepted))
+		require.Len(t, resp.Clients.TopByRequests, 1)
+		assert.Equal(t, "10.0.0.3", resp.Clients.TopByRequests[0].ClientIP)
+		assert.Equal(t, int64(2), resp.Traffic.TotalRequests)
+		assert.Equal(t, int64(10), resp.Traffic.TotalBytesIn)
+	})
+}
+
 func TestHeartbeatNoDBQueries(t *testing.T) {
 	// Heartbeat should work with no StatsDB — it only reads atomics.
 	info := &_mockServerInfo{
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// proxyAuthRealm is the realm advertised in Proxy-Authenticate.
+const proxyAuthRealm = "fpsd"
+
+// authorized reports whether r carries valid Basic Proxy-Authorization
+// credentials. With no credentials configured every request is authorized.
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.proxyAuth) == 0 {
+		return true
+	}
+	user, pass, ok := parseProxyAuth(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return false
+	}
+	// Compare against every entry so timing does not reveal which
+	// usernames exist.
+	match := 0
+	for u, p := range s.proxyAuth {
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(u))
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(p))
+		match |= userOK & passOK
+	}
+	return match == 1
+}
+
+// requireProxyAuth answers 407 with a Basic challenge.
+func (s *Server) requireProxyAuth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Proxy-Authenticate", `Basic realm="`+proxyAuthRealm+`"`)
+	http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+	s.logger.Debug("proxy auth rejected",
+		"method", r.Method,
+		"host", r.Host,
+		"remote", r.RemoteAddr,
+	)
+}
+
+// parseProxyAuth decodes a Basic Proxy-Authorization header value.
+func parseProxyAuth(header string) (user, pass string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
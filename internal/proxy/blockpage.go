@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// BlockPageData is the data a block page template is executed with.
+type BlockPageData struct {
+	// Domain is the blocked domain.
+	Domain string
+	// URL is the full URL of the blocked request.
+	URL string
+	// Back is the page that linked to the blocked URL (its Referer), or
+	// empty when the client sent none.
+	Back string
+}
+
+// writeBlocked answers a blocked plain HTTP request with 403: the block
+// page template when one is configured, plain text otherwise or if the
+// template fails.
+func (s *Server) writeBlocked(w http.ResponseWriter, r *http.Request, domain string) {
+	if s.blockPage == nil {
+		http.Error(w, "blocked by proxy", http.StatusForbidden)
+		return
+	}
+	var buf bytes.Buffer
+	err := s.blockPage.Execute(&buf, BlockPageData{
+		Domain: domain,
+		URL:    r.URL.String(),
+		Back:   r.Header.Get("Referer"),
+	})
+	if err != nil {
+		s.logger.Error("block page template failed", "domain", domain, "error", err)
+		http.Error(w, "blocked by proxy", http.StatusForbidden)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write(buf.Bytes()) //nolint:errcheck // client may have gone away
+}
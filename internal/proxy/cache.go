@@ -0,0 +1,334 @@
+package proxy
+
+import (
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+)
+
+// defaultCacheObjectBytes is the largest response cached when no object
+// limit is configured.
+const defaultCacheObjectBytes = 1 << 20
+
+// responseCache is an in-memory LRU cache of plain HTTP GET responses,
+// bounded by the total size of cached bodies and headers. It is a shared
+// cache and deliberately conservative: only fresh 200 responses with an
+// explicit lifetime are stored, and anything marked private, carrying
+// cookies, or varying by request header is not.
+type responseCache struct {
+	maxBytes  int64
+	maxObject int64
+	now       func() time.Time
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // front = most recently used; values are *cacheEntry
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	age     time.Duration // Age of the response when stored
+	expires time.Time
+	size    int64
+}
+
+// newResponseCache creates a cache holding up to maxBytes, with objects of
+// at most maxObject bytes (0 uses 1MB, capped at maxBytes). Returns nil (no
+// caching) when maxBytes is not positive.
+func newResponseCache(maxBytes, maxObject int64) *responseCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	if maxObject <= 0 {
+		maxObject = defaultCacheObjectBytes
+	}
+	return &responseCache{
+		maxBytes:  maxBytes,
+		maxObject: min(maxObject, maxBytes),
+		now:       time.Now,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// cacheableRequest reports whether r may be answered from or stored in the
+// cache: a bodiless GET without credentials, ranges, or no-store.
+func cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.ContentLength > 0 {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Range") != "" {
+		return false
+	}
+	_, noStore := cacheDirectives(r.Header)["no-store"]
+	return !noStore
+}
+
+// bypassCache reports whether the client asked for an end-to-end reload
+// (Cache-Control: no-cache or Pragma: no-cache). The response may still be
+// stored.
+func bypassCache(r *http.Request) bool {
+	_, noCache := cacheDirectives(r.Header)["no-cache"]
+	return noCache || strings.EqualFold(r.Header.Get("Pragma"), "no-cache")
+}
+
+// get returns the fresh entry for key, dropping it if it has expired.
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry) //nolint:errcheck,forcetypeassert // only *cacheEntry is stored
+	if !c.now().Before(e.expires) {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// lifetime returns how long resp may be served from the cache, or 0 if it
+// must not be stored. Only 200 responses with an explicit max-age,
+// s-maxage, or Expires qualify; heuristic freshness is never used.
+func (c *responseCache) lifetime(resp *http.Response) (fresh, age time.Duration) {
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0
+	}
+	h := resp.Header
+	if h.Get("Vary") != "" || h.Get("Set-Cookie") != "" {
+		return 0, 0
+	}
+	if resp.ContentLength > c.maxObject {
+		return 0, 0
+	}
+	cc := cacheDirectives(h)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0, 0
+		}
+	}
+
+	if secs, err := strconv.Atoi(h.Get("Age")); err == nil && secs > 0 {
+		age = time.Duration(secs) * time.Second
+	}
+	switch {
+	case cc["s-maxage"] != "":
+		fresh = parseSeconds(cc["s-maxage"])
+	case cc["max-age"] != "":
+		fresh = parseSeconds(cc["max-age"])
+	case h.Get("Expires") != "":
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err != nil {
+			return 0, 0 // an invalid Expires means already expired
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = c.now()
+		}
+		fresh = expires.Sub(date)
+	}
+	return fresh - age, age
+}
+
+// put stores a response body with the given remaining freshness,
+// evicting least recently used entries to make room.
+func (c *responseCache) put(key string, header http.Header, body []byte, fresh, age time.Duration) {
+	size := int64(len(body)) + headerSize(header)
+	if fresh <= 0 || size > c.maxObject {
+		return
+	}
+	now := c.now()
+	e := &cacheEntry{
+		key:     key,
+		header:  header.Clone(),
+		body:    body,
+		stored:  now,
+		age:     age,
+		expires: now.Add(fresh),
+		size:    size,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	for c.size+size > c.maxBytes && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += size
+}
+
+func (c *responseCache) removeLocked(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry) //nolint:errcheck,forcetypeassert // only *cacheEntry is stored
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// cacheDirectives parses Cache-Control into lower-case directive names and
+// their (unquoted) values.
+func cacheDirectives(h http.Header) map[string]string {
+	d := make(map[string]string)
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			d[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return d
+}
+
+// parseSeconds parses a delta-seconds value, treating invalid values as 0.
+func parseSeconds(v string) time.Duration {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(min(n, int64(365*24*time.Hour/time.Second))) * time.Second
+}
+
+// headerSize approximates the memory held by h.
+func headerSize(h http.Header) int64 {
+	var n int64
+	for k, vv := range h {
+		for _, v := range vv {
+			n += int64(len(k) + len(v))
+		}
+	}
+	return n
+}
+
+// cacheRecorder tees a response body into memory while it is relayed, up
+// to limit bytes. The body is usable only if the upstream reached EOF
+// without overflowing.
+type cacheRecorder struct {
+	r        io.Reader
+	limit    int64
+	buf      []byte
+	overflow bool
+	eof      bool
+}
+
+func (c *cacheRecorder) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if !c.overflow {
+		if int64(len(c.buf)+n) > c.limit {
+			c.overflow, c.buf = true, nil
+		} else {
+			c.buf = append(c.buf, p[:n]...)
+		}
+	}
+	if err == io.EOF {
+		c.eof = true
+	}
+	return n, err
+}
+
+// complete returns the recorded body if the whole response was captured.
+func (c *cacheRecorder) complete() ([]byte, bool) {
+	return c.buf, c.eof && !c.overflow
+}
+
+// cacheKeyFor returns the cache key for r when its response may be cached
+// ("" otherwise) and whether r was already answered from the cache.
+func (s *Server) cacheKeyFor(w http.ResponseWriter, r *http.Request,
+	clientIP, domain string, trace *headers.Trace, start time.Time,
+) (key string, served bool) {
+	if s.cache == nil || !cacheableRequest(r) {
+		return "", false
+	}
+	key = r.URL.String()
+	return key, s.serveFromCache(w, r, key, clientIP, domain, trace, start)
+}
+
+// recordForCache wraps resp.Body in a cacheRecorder when the response to
+// key may be stored, returning the reader to relay and a store func to call
+// after the relay; relayed reports whether the body was relayed in full.
+// With an empty key or an uncacheable response, body is resp.Body and store
+// does nothing.
+func (s *Server) recordForCache(key string, resp *http.Response) (body io.Reader, store func(relayed bool)) {
+	if key == "" {
+		return resp.Body, func(bool) {}
+	}
+	fresh, age := s.cache.lifetime(resp)
+	if fresh <= 0 {
+		return resp.Body, func(bool) {}
+	}
+	rec := &cacheRecorder{r: resp.Body, limit: s.cache.maxObject}
+	return rec, func(relayed bool) {
+		if b, ok := rec.complete(); relayed && ok {
+			s.cache.put(key, resp.Header, b, fresh, age)
+		}
+	}
+}
+
+// serveCached writes a cache hit to the client with its current Age.
+func (s *Server) serveCached(w http.ResponseWriter, e *cacheEntry) int64 {
+	for k, vv := range e.header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	age := e.age + s.cache.now().Sub(e.stored)
+	w.Header().Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	w.WriteHeader(http.StatusOK)
+	n, _ := w.Write(e.body) //nolint:errcheck // best-effort response
+	return int64(n)
+}
+
+// cacheLookup reports a cache hit or miss via onCacheLookup.
+func (s *Server) cacheLookup(hit bool) {
+	if s.onCacheLookup != nil {
+		s.onCacheLookup(hit)
+	}
+}
+
+// serveFromCache answers r from the cache if a fresh entry exists, unless
+// the client asked to bypass it, and records the hit or miss. A hit
+// carries trace (nil for none).
+func (s *Server) serveFromCache(w http.ResponseWriter, r *http.Request, key, clientIP, domain string, trace *headers.Trace, start time.Time) bool {
+	if bypassCache(r) {
+		s.cacheLookup(false)
+		return false
+	}
+	e, ok := s.cache.get(key)
+	s.cacheLookup(ok)
+	if !ok {
+		return false
+	}
+
+	if trace != nil {
+		trace.Cached = true
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
+	written := s.serveCached(w, e)
+	if s.onRequest != nil {
+		s.onRequest(clientIP, domain, false, 0, written)
+	}
+	s.logger.Info("http",
+		"method", r.Method,
+		"url", r.URL.String(),
+		"status", http.StatusOK,
+		"content_type", e.header.Get("Content-Type"),
+		"duration_ms", time.Since(start).Milliseconds(),
+		"remote", r.RemoteAddr,
+		"cache", "hit",
+	)
+	return true
+}
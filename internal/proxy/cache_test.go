@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCacheLifetime(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := newResponseCache(1<<20, 0)
+	c.now = func() time.Time { return now }
+
+	resp := func(status int, kv ...string) *http.Response {
+		h := make(http.Header)
+		for i := 0; i < len(kv); i += 2 {
+			h.Add(kv[i], kv[i+1])
+		}
+		return &http.Response{StatusCode: status, Header: h, ContentLength: -1}
+	}
+	date := now.Add(-time.Minute).Format(http.TimeFormat)
+
+	tests := []struct {
+		name  string
+		resp  *http.Response
+		fresh time.Duration
+	}{
+		{"max-age", resp(200, "Cache-Control", "public, max-age=60"), time.Minute},
+		{"s-maxage wins", resp(200, "Cache-Control", "max-age=60, s-maxage=120"), 2 * time.Minute},
+		{"age subtracted", resp(200, "Cache-Control", "max-age=60", "Age", "15"), 45 * time.Second},
+		{"expires from date", resp(200, "Expires", now.Add(time.Hour).Format(http.TimeFormat), "Date", date), 61 * time.Minute},
+		{"invalid expires", resp(200, "Expires", "0"), 0},
+		{"no lifetime", resp(200), 0},
+		{"not 200", resp(404, "Cache-Control", "max-age=60"), 0},
+		{"no-store", resp(200, "Cache-Control", "no-store, max-age=60"), 0},
+		{"private", resp(200, "Cache-Control", "private, max-age=60"), 0},
+		{"no-cache", resp(200, "Cache-Control", `no-cache="Set-Cookie", max-age=60`), 0},
+		{"vary", resp(200, "Cache-Control", "max-age=60", "Vary", "Accept-Encoding"), 0},
+		{"set-cookie", resp(200, "Cache-Control", "max-age=60", "Set-Cookie", "a=b"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fresh, _ := c.lifetime(tt.resp)
+			assert.Equal(t, tt.fresh, max(fresh, 0))
+		})
+	}
+
+	big := resp(200, "Cache-Control", "max-age=60")
+	big.ContentLength = defaultCacheObjectBytes + 1
+	fresh, _ := c.lifetime(big)
+	assert.Zero(t, fresh, "larger than the object limit")
+}
+
+func TestResponseCacheExpiryAndEviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newResponseCache(250, 100)
+	c.now = func() time.Time { return now }
+	body := make([]byte, 90)
+
+	c.put("a", http.Header{}, body, time.Minute, 0)
+	c.put("b", http.Header{}, body, time.Hour, 0)
+	c.put("big", http.Header{}, make([]byte, 101), time.Hour, 0)
+	_, ok := c.get("big")
+	assert.False(t, ok, "over the object limit")
+
+	// Touch a so b is least recently used when c needs room.
+	_, ok = c.get("a")
+	require.True(t, ok)
+	c.put("c", http.Header{}, body, time.Hour, 0)
+	_, ok = c.get("b")
+	assert.False(t, ok, "evicted")
+	assert.Equal(t, int64(180), c.size)
+
+	now = now.Add(time.Minute)
+	_, ok = c.get("a")
+	assert.False(t, ok, "expired")
+	_, ok = c.get("c")
+	assert.True(t, ok)
+	assert.Equal(t, int64(90), c.size)
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// chaosInjector delays a random fraction of requests, for testing how
+// clients cope with a slow proxy. It is never enabled by default.
+type chaosInjector struct {
+	delay       time.Duration
+	jitter      time.Duration
+	probability float64
+}
+
+// newChaosInjector returns nil (no injection) unless probability is
+// positive and there is a delay or jitter to inject. An enabled injector
+// is announced with a warning, so it is not left on by accident.
+func newChaosInjector(delay, jitter time.Duration, probability float64, logger *slog.Logger) *chaosInjector {
+	if probability <= 0 || (delay <= 0 && jitter <= 0) {
+		return nil
+	}
+	logger.Warn("chaos latency injection enabled, for testing only",
+		"delay", delay,
+		"jitter", jitter,
+		"probability", probability,
+	)
+	return &chaosInjector{
+		delay:       max(0, delay),
+		jitter:      max(0, jitter),
+		probability: probability,
+	}
+}
+
+// pick reports whether to delay the next request and by how long: delay
+// plus a uniform extra in [0, jitter].
+func (c *chaosInjector) pick() (time.Duration, bool) {
+	if c.probability < 1 && rand.Float64() >= c.probability {
+		return 0, false
+	}
+	d := c.delay
+	if c.jitter > 0 {
+		d += rand.N(c.jitter + 1)
+	}
+	return d, true
+}
+
+// injectChaos sleeps before a selected request is handled. It returns false
+// if the client went away while waiting, in which case the request is
+// dropped.
+func (s *Server) injectChaos(r *http.Request, clientIP string) bool {
+	d, ok := s.chaos.pick()
+	if !ok {
+		return true
+	}
+	s.chaosDelayed.Add(1)
+	s.logger.Debug("chaos delay injected",
+		"method", r.Method,
+		"host", r.Host,
+		"client", clientIP,
+		"delay_ms", d.Milliseconds(),
+	)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
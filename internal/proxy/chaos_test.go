@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosInjectorStartupWarning(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+	assert.Nil(t, newChaosInjector(time.Second, 0, 0, logger))
+	assert.Nil(t, newChaosInjector(0, 0, 1, logger))
+	assert.Empty(t, logs.String(), "disabled chaos logs nothing")
+
+	assert.NotNil(t, newChaosInjector(time.Second, 0, 0.5, logger))
+	assert.Contains(t, logs.String(), "level=WARN")
+	assert.Contains(t, logs.String(), "chaos latency injection enabled, for testing only")
+	assert.Contains(t, logs.String(), "probability=0.5")
+}
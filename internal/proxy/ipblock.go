@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+)
+
+// ipBlockedError is returned by dialUpstream when a host resolves into a
+// blocked range.
+type ipBlockedError struct {
+	host   string
+	ip     netip.Addr
+	prefix netip.Prefix
+}
+
+func (e *ipBlockedError) Error() string {
+	return fmt.Sprintf("%s resolves to %s, blocked by %s", e.host, e.ip, e.prefix)
+}
+
+// asIPBlocked returns the ipBlockedError in err's chain, or nil.
+func asIPBlocked(err error) *ipBlockedError {
+	var ipErr *ipBlockedError
+	if errors.As(err, &ipErr) {
+		return ipErr
+	}
+	return nil
+}
+
+// dialUpstream dials addr through s.dialer. With blocked CIDRs configured
+// (and filtering on), the host is resolved first and refused if any of its
+// addresses is in a blocked range; otherwise the resolved addresses are
+// dialed directly, so the address checked is the address connected to.
+func (s *Server) dialUpstream(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(s.blockedCIDRs) == 0 || !s.filtering() {
+		return s.dialer.DialContext(ctx, network, addr)
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+
+	ips, err := s.resolveAllowed(ctx, host)
+	if err != nil {
+		if asIPBlocked(err) != nil {
+			return nil, err
+		}
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := s.dialer.DialContext(ctx, network, netip.AddrPortFrom(ip.Unmap(), uint16(port)).String())
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// checkCIDRs resolves host and returns an *ipBlockedError if any of its
+// addresses is in a blocked range. It is for upstreams the proxy does not
+// dial itself (MITM'd CONNECT hosts); lookup failures are not reported and
+// are left to the eventual dial.
+func (s *Server) checkCIDRs(ctx context.Context, host string) *ipBlockedError {
+	if len(s.blockedCIDRs) == 0 || !s.filtering() {
+		return nil
+	}
+	_, err := s.resolveAllowed(ctx, host)
+	return asIPBlocked(err)
+}
+
+// resolveAllowed resolves host and refuses it if any of its addresses is
+// in a blocked range.
+func (s *Server) resolveAllowed(ctx context.Context, host string) ([]netip.Addr, error) {
+	resolver := s.dialer.Resolver()
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		for _, p := range s.blockedCIDRs {
+			if p.Contains(ip) {
+				s.recordIPBlock(p)
+				return nil, &ipBlockedError{host: host, ip: ip, prefix: p}
+			}
+		}
+	}
+	return ips, nil
+}
+
+// recordIPBlock counts a block by prefix p.
+func (s *Server) recordIPBlock(p netip.Prefix) {
+	val, _ := s.ipBlocks.LoadOrStore(p.String(), &atomic.Int64{})
+	val.(*atomic.Int64).Add(1) //nolint:errcheck // type is guaranteed by LoadOrStore
+}
+
+// IPBlocks returns the number of upstream connections refused per blocked
+// CIDR since startup. These are counted apart from domain blocks.
+func (s *Server) IPBlocks() map[string]int64 {
+	out := make(map[string]int64)
+	s.ipBlocks.Range(func(key, value any) bool {
+		cidr, _ := key.(string)             //nolint:errcheck // type is guaranteed
+		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed
+		out[cidr] = counter.Load()
+		return true
+	})
+	return out
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// maxLenientHeaderBytes bounds the response header block read by
+// lenientRoundTrip.
+const maxLenientHeaderBytes = 64 << 10
+
+// malformedHeader reports whether err is the response header parse error Go
+// returns for header lines it rejects (bad field names or control bytes).
+// The error text quotes the offending line.
+func malformedHeader(err error) bool {
+	var pe textproto.ProtocolError
+	return errors.As(err, &pe) && strings.HasPrefix(string(pe), "malformed MIME header")
+}
+
+// resendable reports whether a request is safe to send again, for a
+// lenient or transient-failure retry: only bodiless GET and HEAD.
+func resendable(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.ContentLength == 0
+}
+
+// lenientRoundTrip re-sends req on a fresh connection and drops response
+// header lines that Go's parser rejects before parsing the response. Only
+// lines with an invalid field name, no colon, or control bytes in the value
+// are dropped; if such a line names Content-Length or Transfer-Encoding the
+// response is refused, since dropping it would change message framing.
+// Returns the dropped lines.
+func (s *Server) lenientRoundTrip(req *http.Request) (*http.Response, []string, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		port := "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(req.URL.Hostname(), port)
+	}
+
+	conn, err := s.dialUpstream(req.Context(), "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.URL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: req.URL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(req.Context()); err != nil {
+			_ = conn.Close()
+			return nil, nil, err
+		}
+		conn = tlsConn
+	}
+	stop := context.AfterFunc(req.Context(), func() { _ = conn.Close() })
+
+	out := req.Clone(req.Context())
+	out.Close = true
+	if err := out.Write(conn); err != nil {
+		stop()
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	header, dropped, err := sanitizeHeaderBlock(br)
+	if err != nil {
+		stop()
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(header), br)), req)
+	if err != nil {
+		stop()
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, dropped, nil
+}
+
+// sanitizeHeaderBlock reads a response status line and header block from br
+// and returns it with invalid header lines (and their continuations)
+// removed.
+func sanitizeHeaderBlock(br *bufio.Reader) (header []byte, dropped []string, err error) {
+	var buf bytes.Buffer
+	kept := false // whether the previous header line was kept
+	for first := true; ; first = false {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("read response header: %w", err)
+		}
+		if buf.Len()+len(line) > maxLenientHeaderBytes {
+			return nil, nil, errors.New("response header too large")
+		}
+		text := strings.TrimRight(line, "\r\n")
+		switch {
+		case first:
+			// Status line; http.ReadResponse validates it.
+		case text == "":
+			buf.WriteString(line)
+			return buf.Bytes(), dropped, nil
+		case text[0] == ' ' || text[0] == '\t':
+			if !kept || !validHeaderValue(text) {
+				kept = false
+				dropped = append(dropped, text)
+				continue
+			}
+		default:
+			kept = validHeaderLine(text)
+			if !kept {
+				name, _, _ := strings.Cut(text, ":")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "content-length" || name == "transfer-encoding" {
+					return nil, nil, fmt.Errorf("malformed framing header %q", text)
+				}
+				dropped = append(dropped, text)
+				continue
+			}
+		}
+		buf.WriteString(line)
+	}
+}
+
+// validHeaderLine reports whether line is "name: value" with a name Go's
+// parser accepts (token bytes, plus spaces, which it tolerates) and a valid
+// value.
+func validHeaderLine(line string) bool {
+	name, value, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenByte(name[i]) && name[i] != ' ' {
+			return false
+		}
+	}
+	return validHeaderValue(value)
+}
+
+// validHeaderValue reports whether v is free of control bytes other than
+// tab.
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// isTokenByte reports whether c is an RFC 9110 tchar.
+func isTokenByte(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+	}
+}
+
+// connBody closes the lenient connection along with the response body.
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *connBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stop()
+	_ = b.conn.Close()
+	return err
+}
@@ -5,30 +5,86 @@ import (
 	"strings"
 )
 
+// Management endpoint groups, the names accepted by
+// Config.DisabledEndpoints.
+const (
+	EndpointHeartbeat   = "heartbeat"   // /heartbeat
+	EndpointStats       = "stats"       // /stats, /stats/new-domains, /stats/export.csv
+	EndpointMetrics     = "metrics"     // /metrics
+	EndpointSuggestions = "suggestions" // /suggestions
+	EndpointCandidates  = "candidates"  // /candidates
+	EndpointPAC         = "pac"         // /proxy.pac
+	EndpointCA          = "ca"          // /ca.pem, /ca/bundle.pem, /ca/profile.mobileconfig, /ca/check
+	EndpointDashboard   = "dashboard"   // /dashboard*, /api/*, /logs/stream, /sessions
+)
+
+// managementEndpoint is an exact-match management path: its endpoint group
+// and the Server field holding its handler.
+type managementEndpoint struct {
+	group   string
+	handler func(s *Server) http.HandlerFunc
+}
+
+// managementEndpoints maps exact management paths, relative to the prefix,
+// to their endpoint. These are for monitoring and automation and need no
+// auth. An endpoint whose handler is not set answers 404.
+var managementEndpoints = map[string]managementEndpoint{
+	"/heartbeat":               {EndpointHeartbeat, func(s *Server) http.HandlerFunc { return s.heartbeatHandler }},
+	"/stats":                   {EndpointStats, func(s *Server) http.HandlerFunc { return s.statsHandler }},
+	"/stats/new-domains":       {EndpointStats, func(s *Server) http.HandlerFunc { return s.newDomainsHandler }},
+	"/stats/export.csv":        {EndpointStats, func(s *Server) http.HandlerFunc { return s.exportCSVHandler }},
+	"/metrics":                 {EndpointMetrics, func(s *Server) http.HandlerFunc { return s.metricsHandler }},
+	"/suggestions":             {EndpointSuggestions, func(s *Server) http.HandlerFunc { return s.suggestHandler }},
+	"/candidates":              {EndpointCandidates, func(s *Server) http.HandlerFunc { return s.candidatesHandler }},
+	"/proxy.pac":               {EndpointPAC, func(s *Server) http.HandlerFunc { return s.pacHandler }},
+	"/ca.pem":                  {EndpointCA, func(s *Server) http.HandlerFunc { return s.caPEMHandler }},
+	"/ca/bundle.pem":           {EndpointCA, func(s *Server) http.HandlerFunc { return s.caBundleHandler }},
+	"/ca/profile.mobileconfig": {EndpointCA, func(s *Server) http.HandlerFunc { return s.caProfileHandler }},
+	// Reached over plain HTTP: the real check is answered inside MITM
+	// sessions, so the handler explains how to run it.
+	"/ca/check": {EndpointCA, func(s *Server) http.HandlerFunc { return s.caCheckHandler }},
+}
+
+// dashboardPath reports whether a management path relative to the prefix
+// is served by the dashboard: /dashboard*, /api/*, and the other endpoints
+// behind the dashboard login.
+func dashboardPath(rel string) bool {
+	return strings.HasPrefix(rel, "/dashboard") || strings.HasPrefix(rel, "/api/") ||
+		rel == "/logs/stream" || rel == "/sessions"
+}
+
+// endpointGroup returns the endpoint group of a management path relative
+// to the prefix (e.g. "/stats/export.csv"), or "" for an unknown path.
+func endpointGroup(rel string) string {
+	if e, ok := managementEndpoints[rel]; ok {
+		return e.group
+	}
+	if dashboardPath(rel) {
+		return EndpointDashboard
+	}
+	return ""
+}
+
 // handleManagement routes requests under the management prefix to the
-// appropriate endpoint.
+// appropriate endpoint. Endpoints in a disabled group answer 404 whether
+// or not a handler is set.
 func (s *Server) handleManagement(w http.ResponseWriter, r *http.Request) {
-	// Exact-match endpoints first (monitoring/automation — no auth).
-	switch r.URL.Path {
-	case s.managementPrefix + "/heartbeat":
-		s.heartbeatHandler(w, r)
+	rel := strings.TrimPrefix(r.URL.Path, s.managementPrefix)
+	if s.disabledEndpoints[endpointGroup(rel)] {
+		http.NotFound(w, r)
 		return
-	case s.managementPrefix + "/stats":
-		s.statsHandler(w, r)
-		return
-	case s.managementPrefix + "/ca.pem":
-		if s.caPEMHandler != nil {
-			s.caPEMHandler(w, r)
+	}
+
+	if e, ok := managementEndpoints[rel]; ok {
+		if h := e.handler(s); h != nil {
+			h(w, r)
 		} else {
 			http.NotFound(w, r)
 		}
 		return
 	}
 
-	// Dashboard routes: /fps/dashboard* and /fps/api/*
-	path := r.URL.Path
-	prefix := s.managementPrefix
-	if strings.HasPrefix(path, prefix+"/dashboard") || strings.HasPrefix(path, prefix+"/api/") {
+	if dashboardPath(rel) {
 		if s.dashboardHandler != nil {
 			s.dashboardHandler.ServeHTTP(w, r)
 		} else {
@@ -10,15 +10,26 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"runtime/debug"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/session"
+	"github.com/ushineko/face-puncher-supreme/internal/upstream"
 )
 
 // Blocker checks whether a domain should be blocked.
@@ -26,6 +37,12 @@ type Blocker interface {
 	IsBlocked(domain string) bool
 }
 
+// BlockReasoner is optionally implemented by a Blocker to name the rule
+// that blocks a domain, reported in the trace header.
+type BlockReasoner interface {
+	BlockReason(domain string) string
+}
+
 // MITMInterceptor checks whether a domain should be MITM'd and handles
 // the interception session.
 type MITMInterceptor interface {
@@ -33,39 +50,151 @@ type MITMInterceptor interface {
 	Handle(clientConn net.Conn, domain, host, clientIP string)
 }
 
+// BlockPageServer answers a blocked CONNECT with a block page over TLS on
+// the hijacked client connection, taking ownership of it.
+type BlockPageServer interface {
+	ServeBlockPage(clientConn net.Conn, domain, clientIP string)
+}
+
 // Server is an HTTP/HTTPS forward proxy.
 type Server struct {
 	httpServer       *http.Server
+	tlsServer        *http.Server // nil unless TLSListenAddr is set
 	logger           *slog.Logger
 	verbose          bool
 	startTime        time.Time
 	blocker          Blocker
 	mitmInterceptor  MITMInterceptor
+	connectBlockPage BlockPageServer
+	blockPage        *template.Template // nil = plain-text 403
 	connectTimeout   time.Duration
 	managementPrefix string
+	headers          *headers.Stripper
+	tracer           *headers.Tracer // nil = no trace headers
+
+	// disabledEndpoints holds the management endpoint groups that answer
+	// 404 (see Config.DisabledEndpoints).
+	disabledEndpoints map[string]bool
 
 	// Management endpoint handlers (set during construction).
-	heartbeatHandler http.HandlerFunc
-	statsHandler     http.HandlerFunc
-	caPEMHandler     http.HandlerFunc
-	dashboardHandler http.Handler
+	heartbeatHandler  http.HandlerFunc
+	statsHandler      http.HandlerFunc
+	newDomainsHandler http.HandlerFunc
+	exportCSVHandler  http.HandlerFunc
+	metricsHandler    http.HandlerFunc
+	suggestHandler    http.HandlerFunc
+	candidatesHandler http.HandlerFunc
+	caPEMHandler      http.HandlerFunc
+	caBundleHandler   http.HandlerFunc
+	caProfileHandler  http.HandlerFunc
+	caCheckHandler    http.HandlerFunc
+	pacHandler        http.HandlerFunc
+	dashboardHandler  http.Handler
 
 	// Stats callbacks.
 	onRequest     func(clientIP, domain string, blocked bool, bytesIn, bytesOut int64)
+	onBlock       func(clientIP, domain, referer string)
 	onTunnelClose func(clientIP string, bytesIn, bytesOut int64)
+	onTunnelBytes func(clientIP string, n int64)
+	onConnOpen    func(clientIP string)
+	onConnClose   func(clientIP string)
 
 	// Connection counters.
 	connectionsTotal  atomic.Int64
 	connectionsActive atomic.Int64
+	connectionsShed   atomic.Int64
+	// connectionsRateLimited counts requests rejected with 429 by the
+	// per-client rate limit.
+	connectionsRateLimited atomic.Int64
+	// responsesTruncated counts plain HTTP responses cut off at
+	// maxResponseBytes.
+	responsesTruncated atomic.Int64
+	// responsesSanitized counts plain HTTP responses relayed after
+	// dropping malformed upstream header lines.
+	responsesSanitized atomic.Int64
+	// panics counts handler panics recovered by ServeHTTP.
+	panics atomic.Int64
+	// chaosDelayed counts requests delayed by chaos injection.
+	chaosDelayed atomic.Int64
+
+	// proxyAuth maps client usernames to passwords. Empty disables proxy
+	// authentication.
+	proxyAuth map[string]string
+
+	// rateLimit throttles requests per client IP (nil = unlimited).
+	rateLimit *rateLimiter
+
+	// chaos delays a fraction of requests for resilience testing (nil =
+	// off).
+	chaos *chaosInjector
+
+	// cache stores cacheable plain HTTP GET responses (nil = off).
+	// onCacheLookup is called with each cacheable request's hit or miss.
+	cache         *responseCache
+	onCacheLookup func(hit bool)
+
+	// Upstream dialing. transport is the server's own transport for plain
+	// HTTP forwarding. fallback, if set, is tried when the primary dial
+	// fails.
+	dialer    *upstream.Dialer
+	transport *http.Transport
+	fallback  *upstream.Fallback
+
+	// maxInflight sheds requests beyond this many active connections
+	// (0 = unlimited).
+	maxInflight int64
+
+	// passthrough, when set and true, skips blocking and MITM.
+	passthrough *atomic.Bool
+
+	// maxResponseBytes caps relayed plain HTTP response bodies
+	// (0 = unlimited).
+	maxResponseBytes int64
+
+	strictContentLength bool
+
+	// requestTimeout bounds a plain HTTP upstream exchange (0 = none);
+	// with timeoutWholeBody it also covers body relay.
+	requestTimeout   time.Duration
+	timeoutWholeBody bool
+
+	// lenientHeaders retries bodiless GET/HEAD requests whose response had
+	// malformed header lines, dropping those lines.
+	lenientHeaders bool
+
+	// upstreamRetries re-sends bodiless GET/HEAD requests this many times
+	// when the upstream connection fails, waiting retryBackoff
+	// (doubling each time) in between.
+	upstreamRetries int
+	retryBackoff    time.Duration
+
+	// blockedCIDRs refuses upstream connections to hosts resolving into
+	// these ranges; ipBlocks counts refusals per range.
+	blockedCIDRs []netip.Prefix
+	ipBlocks     sync.Map // string -> *atomic.Int64
+
+	// Hijacked CONNECT tunnels and MITM sessions. http.Server.Shutdown does
+	// not track hijacked connections, so they are drained separately.
+	sessions  *session.Registry
+	tunnelsWG sync.WaitGroup
 
 	// shutdownOnce ensures graceful shutdown runs once.
 	shutdownOnce sync.Once
 }
 
+// shedRetryAfter is the Retry-After value (seconds) sent with 503s when
+// the in-flight limit is reached.
+const shedRetryAfter = "1"
+
 // Config holds proxy server configuration.
 type Config struct {
 	// ListenAddr is the address to listen on (e.g., ":18737" or "0.0.0.0:18737").
 	ListenAddr string
+	// TLSListenAddr is an optional second address on which the same proxy
+	// is served over TLS, for clients using an https:// proxy URL.
+	TLSListenAddr string
+	// TLSCert is the certificate for TLSListenAddr. Required when it is set.
+	TLSCert *tls.Certificate
 	// Logger is the structured logger to use. If nil, a default is created.
 	Logger *slog.Logger
 	// Verbose enables detailed request/response logging (headers, sizes, timing).
@@ -74,24 +203,138 @@ type Config struct {
 	Blocker Blocker
 	// MITMInterceptor handles MITM interception for configured domains. If nil, MITM is disabled.
 	MITMInterceptor MITMInterceptor
+	// ConnectBlockPage, if set, answers CONNECT to a blocked domain with
+	// 200 Connection Established and a block page served over TLS, instead
+	// of a 403 that browsers show as a generic proxy error.
+	ConnectBlockPage BlockPageServer
+	// BlockPage, if set, renders the body of the 403 for a plain HTTP
+	// request to a blocked domain, executed with BlockPageData. Nil sends
+	// "blocked by proxy" as plain text.
+	BlockPage *template.Template
 	// ConnectTimeout is the timeout for upstream TCP connections. Zero uses the default (10s).
 	ConnectTimeout time.Duration
 	// ReadHeaderTimeout is the timeout for reading client request headers. Zero uses the default (10s).
 	ReadHeaderTimeout time.Duration
 	// ManagementPrefix is the URL path prefix for management endpoints. Empty uses "/fps".
 	ManagementPrefix string
+	// DisabledEndpoints lists management endpoint groups (Endpoint*
+	// constants) that answer 404 regardless of their handlers.
+	DisabledEndpoints []string
 	// HeartbeatHandler handles /fps/heartbeat requests. Required.
 	HeartbeatHandler http.HandlerFunc
 	// StatsHandler handles /fps/stats requests. Required.
 	StatsHandler http.HandlerFunc
 	// CAPEMHandler handles /fps/ca.pem requests. If nil, returns 404.
 	CAPEMHandler http.HandlerFunc
+	// CABundleHandler handles /fps/ca/bundle.pem (CA plus chain) and
+	// CAProfileHandler /fps/ca/profile.mobileconfig (iOS profile). If nil,
+	// they return 404.
+	CABundleHandler  http.HandlerFunc
+	CAProfileHandler http.HandlerFunc
+	// CACheckHandler handles plain-HTTP /fps/ca/check requests (CA install
+	// self-test instructions). If nil, returns 404.
+	CACheckHandler http.HandlerFunc
+	// StripRequestHeaders are extra headers removed from forwarded requests
+	// (in addition to the standard hop-by-hop set).
+	StripRequestHeaders []string
+	// StripResponseHeaders are extra headers removed from relayed responses.
+	StripResponseHeaders []string
+	// StripCookies match names of cookies removed from forwarded Cookie
+	// headers and relayed Set-Cookie headers.
+	StripCookies []*regexp.Regexp
+	// StrictContentLength buffers plain HTTP responses that declare a
+	// Content-Length (up to headers.StrictLengthLimit) and answers 502
+	// instead when the upstream sends fewer bytes.
+	StrictContentLength bool
+	// Tracer adds a headers.TraceHeader to plain HTTP responses (blocked,
+	// cached, forwarded, or failed) for the clients it enables. Nil adds
+	// none.
+	Tracer *headers.Tracer
+	// Dialer dials upstream connections. If nil, the system resolver is used.
+	Dialer *upstream.Dialer
+	// Fallback is tried when dialing the upstream fails, before returning
+	// 502. If nil, there is no fallback.
+	Fallback *upstream.Fallback
+	// MaxInflight is the maximum number of concurrently active proxy
+	// requests (including CONNECT tunnels and WebSockets, for as long as
+	// they stay open). Requests beyond it get 503 with Retry-After.
+	// Management endpoints are exempt and not counted. Zero means unlimited.
+	MaxInflight int
+	// Passthrough is a runtime kill switch shared with the transparent
+	// listener: while it is true, blocking and MITM (and so plugins) are
+	// skipped and the proxy is a pure forwarder. Nil means never.
+	Passthrough *atomic.Bool
+	// MaxResponseBytes caps the body relayed for a plain HTTP response.
+	// Past it the relay stops and the client connection is aborted, so a
+	// hostile upstream cannot stream unbounded data. Zero means unlimited.
+	MaxResponseBytes int64
+	// RequestTimeout bounds a plain HTTP upstream exchange: if the upstream
+	// has not sent response headers by then, the request is canceled and
+	// the client gets 504. Zero means no limit.
+	RequestTimeout time.Duration
+	// TimeoutWholeBody extends RequestTimeout over relaying the response
+	// body; a body still streaming at the deadline is cut off.
+	TimeoutWholeBody bool
+	// LenientHeaders re-sends a bodiless GET or HEAD whose response had
+	// header lines Go rejects (invalid names, control bytes), dropping those
+	// lines. Malformed framing headers are never tolerated.
+	LenientHeaders bool
+	// UpstreamRetries is how many times a bodiless GET or HEAD is re-sent
+	// when connecting to the upstream fails (refused, reset, unreachable).
+	// HTTP error statuses are never retried. Zero disables retries.
+	UpstreamRetries int
+	// UpstreamRetryBackoff is the wait before the first retry; it doubles
+	// for each further one. Zero uses 100ms.
+	UpstreamRetryBackoff time.Duration
+	// BlockedCIDRs blocks requests and tunnels whose upstream host resolves
+	// into one of these ranges, catching ad networks that rotate domains
+	// but reuse address space. Checked after the domain blocklist.
+	BlockedCIDRs []netip.Prefix
+	// ProxyAuth maps usernames to passwords that clients must present as
+	// Basic Proxy-Authorization; others get 407. Management endpoints are
+	// exempt. Empty disables authentication.
+	ProxyAuth map[string]string
+	// RateLimit caps requests (including CONNECT tunnels) per second per
+	// client IP, refilling a token bucket of RateBurst requests; excess
+	// requests get 429 with Retry-After. Management endpoints are exempt.
+	// Zero means unlimited; a RateBurst below 1 uses max(1, RateLimit).
+	RateLimit float64
+	RateBurst int
+	// ChaosDelay, ChaosJitter, and ChaosProbability inject latency for
+	// testing client resilience: a ChaosProbability fraction of requests
+	// and CONNECT tunnels wait ChaosDelay plus up to ChaosJitter before
+	// being handled. Management endpoints are exempt. Off while
+	// ChaosProbability is zero.
+	ChaosDelay       time.Duration
+	ChaosJitter      time.Duration
+	ChaosProbability float64
+	// Transport tunes upstream connection pooling, keep-alives, and TLS
+	// handshake timeout for plain HTTP forwarding.
+	Transport TransportConfig
+	// CacheMaxBytes enables an in-memory LRU cache of plain HTTP GET
+	// responses holding up to this many bytes; responses larger than
+	// CacheMaxObjectBytes (0 = 1MB) are not cached. Zero disables caching.
+	CacheMaxBytes       int64
+	CacheMaxObjectBytes int64
+	// OnCacheLookup is called for each cacheable request with whether it
+	// was served from the cache. Used to record stats.
+	OnCacheLookup func(hit bool)
 	// OnRequest is called after each request completes. Used to record stats.
 	// Parameters: clientIP, domain, blocked, bytesIn, bytesOut.
 	OnRequest func(clientIP, domain string, blocked bool, bytesIn, bytesOut int64)
 	// OnTunnelClose is called when a CONNECT tunnel closes with final byte counts.
 	// Parameters: clientIP, bytesIn, bytesOut.
 	OnTunnelClose func(clientIP string, bytesIn, bytesOut int64)
+	// OnTunnelBytes is called as CONNECT tunnel and WebSocket bytes are
+	// relayed, so live rates follow long tunnels. Parameters: clientIP, bytes.
+	OnTunnelBytes func(clientIP string, n int64)
+	// OnBlock is called for each blocked request with the Referer header
+	// (empty for CONNECT). Used for allowlist suggestions.
+	OnBlock func(clientIP, domain, referer string)
+	// OnConnOpen and OnConnClose bracket each proxied request and each
+	// CONNECT tunnel. Used for per-client active connection gauges.
+	OnConnOpen  func(clientIP string)
+	OnConnClose func(clientIP string)
 }
 
 // New creates a new proxy server with the given configuration.
@@ -110,24 +353,74 @@ func New(cfg *Config) *Server {
 		readHeaderTimeout = 10 * time.Second
 	}
 
+	retryBackoff := cfg.UpstreamRetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = 100 * time.Millisecond
+	}
+
 	mgmtPrefix := cfg.ManagementPrefix
 	if mgmtPrefix == "" {
 		mgmtPrefix = "/fps"
 	}
 
+	stripper := headers.NewStripper(cfg.StripRequestHeaders, cfg.StripResponseHeaders, cfg.StripCookies)
+	if cfg.Verbose {
+		stripper.SetLogger(cfg.Logger)
+	}
+
+	disabledEndpoints := make(map[string]bool, len(cfg.DisabledEndpoints))
+	for _, e := range cfg.DisabledEndpoints {
+		disabledEndpoints[e] = true
+	}
+
 	s := &Server{
-		logger:           cfg.Logger,
-		verbose:          cfg.Verbose,
-		startTime:        time.Now(),
-		blocker:          cfg.Blocker,
-		mitmInterceptor:  cfg.MITMInterceptor,
-		connectTimeout:   connectTimeout,
-		managementPrefix: mgmtPrefix,
-		heartbeatHandler: cfg.HeartbeatHandler,
-		statsHandler:     cfg.StatsHandler,
-		caPEMHandler:     cfg.CAPEMHandler,
-		onRequest:        cfg.OnRequest,
-		onTunnelClose:    cfg.OnTunnelClose,
+		logger:              cfg.Logger,
+		verbose:             cfg.Verbose,
+		startTime:           time.Now(),
+		blocker:             cfg.Blocker,
+		mitmInterceptor:     cfg.MITMInterceptor,
+		connectBlockPage:    cfg.ConnectBlockPage,
+		blockPage:           cfg.BlockPage,
+		connectTimeout:      connectTimeout,
+		managementPrefix:    mgmtPrefix,
+		disabledEndpoints:   disabledEndpoints,
+		headers:             stripper,
+		tracer:              cfg.Tracer,
+		heartbeatHandler:    cfg.HeartbeatHandler,
+		statsHandler:        cfg.StatsHandler,
+		caPEMHandler:        cfg.CAPEMHandler,
+		caBundleHandler:     cfg.CABundleHandler,
+		caProfileHandler:    cfg.CAProfileHandler,
+		caCheckHandler:      cfg.CACheckHandler,
+		onRequest:           cfg.OnRequest,
+		onTunnelClose:       cfg.OnTunnelClose,
+		onTunnelBytes:       cfg.OnTunnelBytes,
+		onConnOpen:          cfg.OnConnOpen,
+		onConnClose:         cfg.OnConnClose,
+		onBlock:             cfg.OnBlock,
+		maxInflight:         int64(cfg.MaxInflight),
+		passthrough:         cfg.Passthrough,
+		maxResponseBytes:    cfg.MaxResponseBytes,
+		strictContentLength: cfg.StrictContentLength,
+		requestTimeout:      cfg.RequestTimeout,
+		timeoutWholeBody:    cfg.TimeoutWholeBody,
+		lenientHeaders:      cfg.LenientHeaders,
+		upstreamRetries:     cfg.UpstreamRetries,
+		retryBackoff:        retryBackoff,
+		blockedCIDRs:        cfg.BlockedCIDRs,
+		proxyAuth:           cfg.ProxyAuth,
+		rateLimit:           newRateLimiter(cfg.RateLimit, cfg.RateBurst),
+		chaos:               newChaosInjector(cfg.ChaosDelay, cfg.ChaosJitter, cfg.ChaosProbability, cfg.Logger),
+		cache:               newResponseCache(cfg.CacheMaxBytes, cfg.CacheMaxObjectBytes),
+		onCacheLookup:       cfg.OnCacheLookup,
+		dialer:              cfg.Dialer,
+		transport:           newTransport(cfg.Transport),
+		fallback:            cfg.Fallback,
+		sessions:            session.NewRegistry("proxy"),
+	}
+
+	if cfg.Dialer.Resolver() != nil || len(cfg.BlockedCIDRs) > 0 {
+		s.transport.DialContext = s.dialUpstream
 	}
 
 	s.httpServer = &http.Server{
@@ -136,6 +429,22 @@ func New(cfg *Config) *Server {
 		ReadHeaderTimeout: readHeaderTimeout,
 	}
 
+	if cfg.TLSListenAddr != "" {
+		s.tlsServer = &http.Server{
+			Addr:              cfg.TLSListenAddr,
+			Handler:           s,
+			ReadHeaderTimeout: readHeaderTimeout,
+			TLSConfig: &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cfg.TLSCert},
+				NextProtos:   []string{"http/1.1"},
+			},
+			// CONNECT tunnels hijack the connection, which HTTP/2 does not
+			// allow; a non-nil empty map keeps the TLS listener on HTTP/1.1.
+			TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+		}
+	}
+
 	return s
 }
 
@@ -143,8 +452,7 @@ func New(cfg *Config) *Server {
 // the CONNECT tunnel handler, or the HTTP forward proxy handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.connectionsTotal.Add(1)
-	s.connectionsActive.Add(1)
-	defer s.connectionsActive.Add(-1)
+	defer s.recoverPanic(w, r)
 
 	// Management endpoints are handled directly regardless of request method.
 	prefix := s.managementPrefix + "/"
@@ -153,6 +461,45 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	active := s.connectionsActive.Add(1)
+	defer s.connectionsActive.Add(-1)
+
+	// Authenticate before blocking or hijacking: an unauthenticated client
+	// learns nothing about what would be blocked.
+	if !s.authorized(r) {
+		s.requireProxyAuth(w, r)
+		return
+	}
+
+	clientIP := stripPort(r.RemoteAddr)
+	if s.rateLimit != nil {
+		if ok, wait := s.rateLimit.allow(clientIP); !ok {
+			s.rejectRateLimited(w, r, clientIP, wait)
+			return
+		}
+	}
+
+	// Shed load beyond the in-flight limit rather than queueing it.
+	if s.maxInflight > 0 && active > s.maxInflight {
+		s.connectionsShed.Add(1)
+		w.Header().Set("Retry-After", shedRetryAfter)
+		http.Error(w, "proxy overloaded", http.StatusServiceUnavailable)
+		s.logger.Debug("request shed",
+			"method", r.Method,
+			"host", r.Host,
+			"remote", r.RemoteAddr,
+			"active", active,
+		)
+		return
+	}
+
+	s.connOpened(clientIP)
+	defer s.connClosed(clientIP)
+
+	if s.chaos != nil && !s.injectChaos(r, clientIP) {
+		return
+	}
+
 	if r.Method == http.MethodConnect {
 		s.handleConnect(w, r)
 		return
@@ -161,6 +508,20 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handleHTTP(w, r)
 }
 
+// connOpened reports a client connection opened via onConnOpen.
+func (s *Server) connOpened(clientIP string) {
+	if s.onConnOpen != nil {
+		s.onConnOpen(clientIP)
+	}
+}
+
+// connClosed reports a client connection closed via onConnClose.
+func (s *Server) connClosed(clientIP string) {
+	if s.onConnClose != nil {
+		s.onConnClose(clientIP)
+	}
+}
+
 // handleHTTP forwards an HTTP request to the destination server and relays
 // the response back to the client.
 func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
@@ -176,23 +537,30 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	domain := stripPort(r.URL.Host)
 	clientIP := stripPort(r.RemoteAddr)
+	var trace *headers.Trace
+	if s.tracer.Enabled(clientIP) {
+		trace = &headers.Trace{Path: "http"}
+	}
 
 	// Check blocklist before forwarding.
-	if s.blocker != nil && s.blocker.IsBlocked(domain) {
-		http.Error(w, "blocked by proxy", http.StatusForbidden)
-		s.logger.Info("blocked",
-			"method", r.Method,
-			"host", r.URL.Host,
-			"remote", r.RemoteAddr,
-		)
-		if s.onRequest != nil {
-			s.onRequest(clientIP, domain, true, 0, 0)
-		}
+	if s.filtering() && s.blocker != nil && s.blocker.IsBlocked(domain) {
+		s.blockHTTP(w, r, clientIP, domain, trace)
+		return
+	}
+
+	if headers.IsWebSocketUpgrade(r.Header) {
+		s.handleWebSocket(w, r, domain, clientIP, trace)
 		return
 	}
 
 	start := time.Now()
 
+	// cacheKey is set when the response may be stored in the cache.
+	cacheKey, served := s.cacheKeyFor(w, r, clientIP, domain, trace, start)
+	if served {
+		return
+	}
+
 	if s.verbose {
 		s.logger.Debug("http request",
 			"method", r.Method,
@@ -206,24 +574,25 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Create the outbound request. We must not reuse the incoming request
 	// directly because the proxy hop headers need to be stripped.
-	outReq := r.Clone(r.Context())
+	ctx, stopTimeout := s.withRequestTimeout(r.Context())
+	defer stopTimeout(false)
+	outReq := r.Clone(ctx)
 	outReq.RequestURI = "" // Required for client requests.
-	removeHopByHopHeaders(outReq.Header)
+	s.headers.StripRequest(outReq.Header, domain)
 
-	resp, err := http.DefaultTransport.RoundTrip(outReq)
+	resp, err := s.roundTrip(outReq)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("proxy error: %v", err), http.StatusBadGateway)
-		s.logger.Error("upstream request failed",
-			"method", r.Method,
-			"url", r.URL.String(),
-			"error", err,
-			"duration_ms", time.Since(start).Milliseconds(),
-		)
+		s.upstreamFailed(ctx, w, r, clientIP, domain, trace, err, start)
 		return
 	}
 	defer resp.Body.Close() //nolint:errcheck // response body close in defer
 
-	removeHopByHopHeaders(resp.Header)
+	s.headers.StripResponse(resp.Header, domain)
+	if s.strictContentLength && s.rejectTruncated(w, r, resp, trace) {
+		return
+	}
+
+	body, storeCached := s.recordForCache(cacheKey, resp)
 
 	// Copy response headers.
 	for k, vv := range resp.Header {
@@ -231,8 +600,16 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add(k, v)
 		}
 	}
+	if trace != nil {
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
 	w.WriteHeader(resp.StatusCode)
-	written, _ := io.Copy(w, resp.Body) //nolint:errcheck // best-effort streaming
+	if !s.timeoutWholeBody {
+		stopTimeout(true)
+	}
+	written, truncated := s.copyResponse(w, body)
+	timedOut := errors.Is(context.Cause(ctx), errRequestTimeout)
+	storeCached(!truncated && !timedOut)
 
 	duration := time.Since(start)
 
@@ -255,26 +632,352 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if s.verbose {
-		s.logger.Debug("http response",
+		s.logResponseVerbose(r, resp, written, duration, !truncated && !timedOut)
+	}
+
+	s.abortIncomplete(r, truncated, timedOut)
+}
+
+// upstreamFailed answers a request whose upstream round trip failed: 403
+// for a refused address range, 504 when requestTimeout expired, and 502
+// otherwise.
+func (s *Server) upstreamFailed(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	clientIP, domain string, trace *headers.Trace, err error, start time.Time,
+) {
+	if ipErr := asIPBlocked(err); ipErr != nil {
+		s.ipBlocked(w, r.Method, r.URL.Host, r.RemoteAddr, clientIP, domain, trace, ipErr)
+		return
+	}
+	if trace != nil {
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
+	if errors.Is(context.Cause(ctx), errRequestTimeout) {
+		http.Error(w, "upstream request timed out", http.StatusGatewayTimeout)
+		s.logger.Warn("upstream request timed out",
 			"method", r.Method,
 			"url", r.URL.String(),
-			"status", resp.StatusCode,
-			"response_bytes", written,
-			"content_length", resp.ContentLength,
-			"duration_ms", duration.Milliseconds(),
-			"headers", flattenHeaders(resp.Header),
+			"timeout", s.requestTimeout,
+			"remote", r.RemoteAddr,
 		)
+		return
+	}
+	http.Error(w, fmt.Sprintf("proxy error: %v", err), http.StatusBadGateway)
+	s.logger.Error("upstream request failed",
+		"method", r.Method,
+		"url", r.URL.String(),
+		"error", err,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// abortIncomplete aborts the client connection when the response body was
+// cut off by maxResponseBytes or by the request timeout, so the client sees
+// an incomplete response rather than a cleanly terminated short body.
+func (s *Server) abortIncomplete(r *http.Request, truncated, timedOut bool) {
+	if truncated {
+		s.responsesTruncated.Add(1)
+		s.logger.Warn("response truncated",
+			"url", r.URL.String(),
+			"limit_bytes", s.maxResponseBytes,
+			"remote", r.RemoteAddr,
+		)
+		panic(http.ErrAbortHandler)
+	}
+	if timedOut {
+		s.logger.Warn("response body timed out",
+			"url", r.URL.String(),
+			"timeout", s.requestTimeout,
+			"remote", r.RemoteAddr,
+		)
+		panic(http.ErrAbortHandler)
 	}
 }
 
+// blockHTTP answers a plain HTTP request for a blocked domain and records
+// the block.
+func (s *Server) blockHTTP(w http.ResponseWriter, r *http.Request, clientIP, domain string, trace *headers.Trace) {
+	if trace != nil {
+		trace.Blocked = true
+		if br, ok := s.blocker.(BlockReasoner); ok {
+			trace.Reason = br.BlockReason(domain)
+		}
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
+	s.writeBlocked(w, r, domain)
+	s.logger.Info("blocked",
+		"method", r.Method,
+		"host", r.URL.Host,
+		"remote", r.RemoteAddr,
+	)
+	if s.onRequest != nil {
+		s.onRequest(clientIP, domain, true, 0, 0)
+	}
+	if s.onBlock != nil {
+		s.onBlock(clientIP, domain, r.Header.Get("Referer"))
+	}
+}
+
+// logResponseVerbose logs response details for verbose mode and, for a
+// complete relay, warns when the bytes written differ from the declared
+// Content-Length.
+func (s *Server) logResponseVerbose(r *http.Request, resp *http.Response, written int64, duration time.Duration, complete bool) {
+	s.logger.Debug("http response",
+		"method", r.Method,
+		"url", r.URL.String(),
+		"status", resp.StatusCode,
+		"response_bytes", written,
+		"content_length", resp.ContentLength,
+		"duration_ms", duration.Milliseconds(),
+		"headers", flattenHeaders(resp.Header),
+	)
+	if complete && headers.BodyExpected(resp) && resp.ContentLength >= 0 && written != resp.ContentLength {
+		s.logger.Warn("content-length mismatch",
+			"url", r.URL.String(),
+			"declared", resp.ContentLength,
+			"written", written,
+			"remote", r.RemoteAddr,
+		)
+	}
+}
+
+// rejectTruncated checks a response against its declared length in strict
+// mode. If the upstream sent less, it answers 502 and returns true.
+func (s *Server) rejectTruncated(w http.ResponseWriter, r *http.Request, resp *http.Response, trace *headers.Trace) bool {
+	if err := s.checkDeclaredLength(r, resp); err == nil {
+		return false
+	}
+	if trace != nil {
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
+	http.Error(w, "proxy error: upstream response truncated", http.StatusBadGateway)
+	return true
+}
+
+// checkDeclaredLength buffers a response body with a declared length and
+// returns an error if the upstream sent less.
+func (s *Server) checkDeclaredLength(r *http.Request, resp *http.Response) error {
+	_, err := headers.BufferDeclared(resp, headers.StrictLengthLimit)
+	if err != nil {
+		s.logger.Warn("upstream response truncated, aborting",
+			"url", r.URL.String(),
+			"remote", r.RemoteAddr,
+			"error", err,
+		)
+	}
+	return err
+}
+
+// errRequestTimeout is the cancel cause when requestTimeout expires.
+var errRequestTimeout = errors.New("request timeout")
+
+// withRequestTimeout derives a context canceled with errRequestTimeout
+// once requestTimeout elapses. stop(true) disarms the timer but keeps the
+// context live (for relaying the body); stop(false) also cancels it.
+func (s *Server) withRequestTimeout(parent context.Context) (ctx context.Context, stop func(keep bool)) {
+	if s.requestTimeout <= 0 {
+		return parent, func(bool) {}
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	timer := time.AfterFunc(s.requestTimeout, func() { cancel(errRequestTimeout) })
+	return ctx, func(keep bool) {
+		timer.Stop()
+		if !keep {
+			cancel(nil)
+		}
+	}
+}
+
+// copyResponse relays body to w, stopping at maxResponseBytes. truncated
+// reports whether the upstream had more to send.
+func (s *Server) copyResponse(w io.Writer, body io.Reader) (written int64, truncated bool) {
+	if s.maxResponseBytes <= 0 {
+		written, _ = io.Copy(w, body) //nolint:errcheck // best-effort streaming
+		return written, false
+	}
+	written, _ = io.Copy(w, io.LimitReader(body, s.maxResponseBytes)) //nolint:errcheck // best-effort streaming
+	if written < s.maxResponseBytes {
+		return written, false
+	}
+	var probe [1]byte
+	n, _ := io.ReadFull(body, probe[:]) //nolint:errcheck // only whether more data exists matters
+	return written, n > 0
+}
+
+// roundTrip forwards outReq via the primary transport, retrying via the
+// fallback when the primary could not connect. Requests with any body
+// (known or unknown length) never go to the fallback, since the primary
+// attempt may have consumed the body.
+// A response with malformed headers is logged and, with lenientHeaders,
+// retried once with those header lines dropped.
+func (s *Server) roundTrip(outReq *http.Request) (*http.Response, error) {
+	resp, err := s.transport.RoundTrip(outReq)
+	if err != nil && s.upstreamRetries > 0 && resendable(outReq) {
+		resp, err = s.retryTransient(outReq, err)
+	}
+	if err != nil && malformedHeader(err) {
+		return s.retryMalformed(outReq, err)
+	}
+	if err == nil || s.fallback == nil || !upstream.IsDialError(err) || outReq.ContentLength != 0 {
+		return resp, err
+	}
+	resp, fbErr := s.fallback.Transport().RoundTrip(outReq)
+	s.logFallback(outReq.Method, outReq.URL.Host, err, fbErr)
+	return resp, fbErr
+}
+
+// retryTransient re-sends outReq up to upstreamRetries times while the
+// upstream connection keeps failing, with exponential backoff. err is the
+// first attempt's error; the last error is returned if all retries fail.
+func (s *Server) retryTransient(outReq *http.Request, err error) (*http.Response, error) {
+	backoff := s.retryBackoff
+	for attempt := 1; attempt <= s.upstreamRetries && transientUpstreamError(err); attempt++ {
+		s.logger.Debug("upstream retry",
+			"method", outReq.Method,
+			"url", outReq.URL.String(),
+			"attempt", attempt,
+			"backoff_ms", backoff.Milliseconds(),
+			"error", err,
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-outReq.Context().Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		var resp *http.Response
+		resp, err = s.transport.RoundTrip(outReq)
+		if err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+// transientUpstreamError reports whether err is a connection failure that
+// may clear up on its own: a failed dial (other than an unknown host) or a
+// connection reset.
+func transientUpstreamError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return upstream.IsDialError(err) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// retryMalformed handles an upstream response that failed to parse because
+// of a malformed header line. The offending line (quoted in err) is always
+// logged; with lenientHeaders a bodiless GET or HEAD is re-sent and the bad
+// lines are dropped.
+func (s *Server) retryMalformed(outReq *http.Request, err error) (*http.Response, error) {
+	if !s.lenientHeaders || !resendable(outReq) {
+		s.logger.Warn("upstream sent malformed response header",
+			"method", outReq.Method,
+			"url", outReq.URL.String(),
+			"error", err,
+		)
+		return nil, err
+	}
+	resp, dropped, lenErr := s.lenientRoundTrip(outReq)
+	if lenErr != nil {
+		s.logger.Warn("upstream sent malformed response header, lenient retry failed",
+			"method", outReq.Method,
+			"url", outReq.URL.String(),
+			"error", err,
+			"retry_error", lenErr,
+		)
+		return nil, err
+	}
+	s.responsesSanitized.Add(1)
+	s.logger.Warn("upstream response headers sanitized",
+		"method", outReq.Method,
+		"url", outReq.URL.String(),
+		"dropped", dropped,
+	)
+	return resp, nil
+}
+
+// logFallback records the outcome of a fallback attempt after the primary
+// upstream dial failed.
+func (s *Server) logFallback(method, host string, primaryErr, fallbackErr error) {
+	if fallbackErr != nil {
+		s.logger.Warn("upstream fallback failed",
+			"method", method,
+			"host", host,
+			"fallback", s.fallback.String(),
+			"primary_error", primaryErr,
+			"error", fallbackErr,
+		)
+		return
+	}
+	s.logger.Info("upstream fallback used",
+		"method", method,
+		"host", host,
+		"fallback", s.fallback.String(),
+		"primary_error", primaryErr,
+	)
+}
+
+// ipBlocked answers a request refused by dialUpstream with 403, adding
+// trace (nil for none) to the response. The block is recorded in the
+// request stats but not reported to onBlock, since an allowlist entry would
+// not lift it.
+func (s *Server) ipBlocked(w http.ResponseWriter, method, host, remote, clientIP, domain string, trace *headers.Trace, ipErr *ipBlockedError) {
+	if trace != nil {
+		trace.Blocked = true
+		trace.Reason = "cidr:" + ipErr.prefix.String()
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
+	http.Error(w, "blocked by proxy", http.StatusForbidden)
+	s.logger.Info("blocked by ip range",
+		"method", method,
+		"host", host,
+		"remote", remote,
+		"ip", ipErr.ip.String(),
+		"cidr", ipErr.prefix.String(),
+	)
+	if s.onRequest != nil {
+		s.onRequest(clientIP, domain, true, 0, 0)
+	}
+}
+
+// serveConnectBlockPage hands a blocked CONNECT to connectBlockPage after
+// establishing the tunnel. Returns false, leaving w untouched, when no block
+// page is configured or the connection cannot be hijacked.
+func (s *Server) serveConnectBlockPage(w http.ResponseWriter, domain, clientIP string) bool {
+	if s.connectBlockPage == nil {
+		return false
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return false
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		return false
+	}
+	_, _ = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")) //nolint:gosec // best-effort
+
+	sess := s.trackTunnel(clientConn, session.KindBlockPage, clientIP, domain)
+	go func() {
+		defer s.untrackTunnel(sess)
+		s.connectBlockPage.ServeBlockPage(sess.Conn(clientConn), domain, clientIP)
+	}()
+	return true
+}
+
 // handleConnect establishes a TCP tunnel for HTTPS CONNECT requests.
 func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 	domain := stripPort(r.Host)
 	clientIP := stripPort(r.RemoteAddr)
 
 	// Check blocklist before establishing tunnel.
-	if s.blocker != nil && s.blocker.IsBlocked(domain) {
-		http.Error(w, "blocked by proxy", http.StatusForbidden)
+	if s.filtering() && s.blocker != nil && s.blocker.IsBlocked(domain) {
+		if !s.serveConnectBlockPage(w, domain, clientIP) {
+			http.Error(w, "blocked by proxy", http.StatusForbidden)
+		}
 		s.logger.Info("blocked",
 			"method", "CONNECT",
 			"host", r.Host,
@@ -283,6 +986,9 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 		if s.onRequest != nil {
 			s.onRequest(clientIP, domain, true, 0, 0)
 		}
+		if s.onBlock != nil {
+			s.onBlock(clientIP, domain, "")
+		}
 		return
 	}
 
@@ -298,7 +1004,15 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// MITM interception: hijack the connection and delegate to the interceptor.
-	if s.mitmInterceptor != nil && s.mitmInterceptor.IsMITMDomain(domain) {
+	if s.filtering() && s.mitmInterceptor != nil && s.mitmInterceptor.IsMITMDomain(domain) {
+		// The interceptor dials upstream itself, so apply blocked CIDRs here.
+		checkCtx, cancelCheck := context.WithTimeout(r.Context(), s.connectTimeout)
+		ipErr := s.checkCIDRs(checkCtx, domain)
+		cancelCheck()
+		if ipErr != nil {
+			s.ipBlocked(w, "CONNECT", r.Host, r.RemoteAddr, clientIP, domain, nil, ipErr)
+			return
+		}
 		hijacker, ok := w.(http.Hijacker)
 		if !ok {
 			http.Error(w, "hijacking not supported", http.StatusInternalServerError)
@@ -317,11 +1031,26 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Handle takes ownership of clientConn (closes it when done).
-		go s.mitmInterceptor.Handle(clientConn, domain, r.Host, clientIP)
+		sess := s.trackTunnel(clientConn, session.KindMITM, clientIP, domain)
+		go func() {
+			defer s.untrackTunnel(sess)
+			s.mitmInterceptor.Handle(sess.Conn(clientConn), domain, r.Host, clientIP)
+		}()
 		return
 	}
 
-	destConn, err := net.DialTimeout("tcp", r.Host, s.connectTimeout)
+	dialCtx, cancelDial := context.WithTimeout(r.Context(), s.connectTimeout)
+	destConn, err := s.dialUpstream(dialCtx, "tcp", r.Host)
+	cancelDial()
+	if ipErr := asIPBlocked(err); ipErr != nil {
+		s.ipBlocked(w, "CONNECT", r.Host, r.RemoteAddr, clientIP, domain, nil, ipErr)
+		return
+	}
+	if err != nil && s.fallback != nil {
+		primaryErr := err
+		destConn, err = s.fallback.DialTimeout("tcp", r.Host, s.connectTimeout)
+		s.logFallback("CONNECT", r.Host, primaryErr, err)
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("tunnel error: %v", err), http.StatusBadGateway)
 		s.logger.Error("connect tunnel failed",
@@ -361,17 +1090,20 @@ func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
 	)
 
 	// Bidirectional copy — always track bytes for stats.
+	sess := s.trackTunnel(clientConn, session.KindTunnel, clientIP, domain)
+	sess.ReportLive(s.onTunnelBytes)
 	var uploadBytes, downloadBytes atomic.Int64
 	go func() {
 		defer func() { _ = destConn.Close() }()
 		defer func() { _ = clientConn.Close() }()
-		n, _ := io.Copy(destConn, clientConn) //nolint:errcheck // tunnel streaming
+		n, _ := io.Copy(sess.CountUpload(destConn), clientConn) //nolint:errcheck // tunnel streaming
 		uploadBytes.Store(n)
 	}()
 	go func() {
+		defer s.untrackTunnel(sess)
 		defer func() { _ = destConn.Close() }()
 		defer func() { _ = clientConn.Close() }()
-		n, _ := io.Copy(clientConn, destConn) //nolint:errcheck // tunnel streaming
+		n, _ := io.Copy(sess.CountDownload(clientConn), destConn) //nolint:errcheck // tunnel streaming
 		downloadBytes.Store(n)
 
 		up := uploadBytes.Load()
@@ -400,26 +1132,167 @@ func (s *Server) ListenAndServe() error {
 	return s.httpServer.ListenAndServe()
 }
 
-// Shutdown gracefully shuts down the proxy server.
+// TLSEnabled reports whether a proxy-over-TLS listener is configured.
+func (s *Server) TLSEnabled() bool {
+	return s.tlsServer != nil
+}
+
+// ListenAndServeTLS starts the proxy-over-TLS listener. It returns an error
+// if no TLS listen address was configured.
+func (s *Server) ListenAndServeTLS() error {
+	if s.tlsServer == nil {
+		return errors.New("proxy: TLS listener not configured")
+	}
+	s.logger.Info("proxy tls starting",
+		"addr", s.tlsServer.Addr,
+	)
+	return s.tlsServer.ListenAndServeTLS("", "")
+}
+
+// Shutdown gracefully shuts down the proxy server and its TLS listener,
+// then closes idle upstream connections.
 func (s *Server) Shutdown(ctx context.Context) error {
 	var err error
 	s.shutdownOnce.Do(func() {
 		s.logger.Info("proxy shutting down")
 		err = s.httpServer.Shutdown(ctx)
+		if s.tlsServer != nil {
+			err = errors.Join(err, s.tlsServer.Shutdown(ctx))
+		}
+		s.transport.CloseIdleConnections()
 	})
 	return err
 }
 
+// DrainTunnels waits for open CONNECT tunnels and MITM sessions to finish.
+// If ctx expires first, the remaining tunnels are force-closed and ctx.Err()
+// is returned. Call after Shutdown so no new tunnels are accepted.
+func (s *Server) DrainTunnels(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.tunnelsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	remaining := s.sessions.CloseAll()
+	s.logger.Warn("tunnel drain timed out, closed remaining tunnels", "tunnels", remaining)
+	return ctx.Err()
+}
+
+// TunnelsActive returns the number of open CONNECT tunnels and MITM sessions.
+func (s *Server) TunnelsActive() int {
+	return s.sessions.Len()
+}
+
+// Sessions lists the open CONNECT tunnels and MITM sessions, oldest first.
+func (s *Server) Sessions() []session.Info {
+	return s.sessions.List()
+}
+
+// trackTunnel registers a hijacked client connection as a session, for
+// the sessions list and shutdown draining. The session holds an in-flight
+// slot (see MaxInflight) until untrackTunnel.
+func (s *Server) trackTunnel(conn net.Conn, kind, clientIP, domain string) *session.Session {
+	s.tunnelsWG.Add(1)
+	s.connectionsActive.Add(1)
+	sess := s.sessions.Open(conn, kind, clientIP, domain)
+	s.connOpened(clientIP)
+	return sess
+}
+
+// untrackTunnel removes a session registered with trackTunnel.
+func (s *Server) untrackTunnel(sess *session.Session) {
+	s.sessions.Close(sess)
+	s.connClosed(sess.ClientIP)
+	s.connectionsActive.Add(-1)
+	s.tunnelsWG.Done()
+}
+
 // ConnectionsTotal returns the total number of connections handled.
 func (s *Server) ConnectionsTotal() int64 {
 	return s.connectionsTotal.Load()
 }
 
-// ConnectionsActive returns the number of currently active connections.
+// ConnectionsActive returns the number of in-flight proxy requests and open
+// tunnels.
 func (s *Server) ConnectionsActive() int64 {
 	return s.connectionsActive.Load()
 }
 
+// ConnectionsShed returns the number of requests rejected with 503 because
+// the in-flight limit was reached.
+func (s *Server) ConnectionsShed() int64 {
+	return s.connectionsShed.Load()
+}
+
+// ConnectionsRateLimited returns the number of requests rejected with 429
+// by the per-client rate limit.
+func (s *Server) ConnectionsRateLimited() int64 {
+	return s.connectionsRateLimited.Load()
+}
+
+// ChaosDelayed returns the number of requests delayed by chaos injection.
+func (s *Server) ChaosDelayed() int64 {
+	return s.chaosDelayed.Load()
+}
+
+// PassthroughForced reports whether the passthrough kill switch is on.
+func (s *Server) PassthroughForced() bool {
+	return s.passthrough != nil && s.passthrough.Load()
+}
+
+// filtering reports whether blocking and MITM apply to new requests.
+func (s *Server) filtering() bool {
+	return !s.PassthroughForced()
+}
+
+// recoverPanic turns a handler panic into a logged, counted 500 so a bug
+// (e.g. in a plugin) fails one request instead of silently dropping the
+// connection. http.ErrAbortHandler is re-raised: it is net/http's way of
+// aborting a response on purpose.
+func (s *Server) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+		panic(v)
+	}
+	s.panics.Add(1)
+	s.logger.Error("panic serving request",
+		"method", r.Method,
+		"host", r.Host,
+		"url", r.URL.String(),
+		"remote", r.RemoteAddr,
+		"panic", v,
+		"stack", string(debug.Stack()),
+	)
+	http.Error(w, "internal proxy error", http.StatusInternalServerError)
+}
+
+// Panics returns the number of handler panics recovered by ServeHTTP.
+func (s *Server) Panics() int64 {
+	return s.panics.Load()
+}
+
+// ResponsesTruncated returns the number of plain HTTP responses cut off at
+// the MaxResponseBytes limit.
+func (s *Server) ResponsesTruncated() int64 {
+	return s.responsesTruncated.Load()
+}
+
+// ResponsesSanitized returns the number of plain HTTP responses relayed
+// after dropping malformed upstream header lines.
+func (s *Server) ResponsesSanitized() int64 {
+	return s.responsesSanitized.Load()
+}
+
 // Uptime returns the duration since the server was created.
 func (s *Server) Uptime() time.Duration {
 	return time.Since(s.startTime)
@@ -437,6 +1310,42 @@ func (s *Server) SetHandlers(heartbeat, stats http.HandlerFunc) {
 	s.statsHandler = stats
 }
 
+// SetNewDomainsHandler sets the handler for the /fps/stats/new-domains
+// endpoint. If unset, the endpoint returns 404.
+func (s *Server) SetNewDomainsHandler(handler http.HandlerFunc) {
+	s.newDomainsHandler = handler
+}
+
+// SetExportCSVHandler sets the handler for the /fps/stats/export.csv
+// endpoint. If unset, the endpoint returns 404.
+func (s *Server) SetExportCSVHandler(handler http.HandlerFunc) {
+	s.exportCSVHandler = handler
+}
+
+// SetPACHandler sets the handler for the /fps/proxy.pac endpoint. If
+// unset, the endpoint returns 404.
+func (s *Server) SetPACHandler(handler http.HandlerFunc) {
+	s.pacHandler = handler
+}
+
+// SetMetricsHandler sets the handler for the /fps/metrics endpoint. If
+// unset, the endpoint returns 404.
+func (s *Server) SetMetricsHandler(handler http.HandlerFunc) {
+	s.metricsHandler = handler
+}
+
+// SetSuggestionsHandler sets the handler for the /fps/suggestions
+// endpoint. If unset, the endpoint returns 404.
+func (s *Server) SetSuggestionsHandler(handler http.HandlerFunc) {
+	s.suggestHandler = handler
+}
+
+// SetCandidatesHandler sets the handler for the /fps/candidates endpoint.
+// If unset, the endpoint returns 404.
+func (s *Server) SetCandidatesHandler(handler http.HandlerFunc) {
+	s.candidatesHandler = handler
+}
+
 // SetCAPEMHandler sets the handler for the /fps/ca.pem endpoint.
 func (s *Server) SetCAPEMHandler(handler http.HandlerFunc) {
 	s.caPEMHandler = handler
@@ -447,26 +1356,6 @@ func (s *Server) SetDashboardHandler(handler http.Handler) {
 	s.dashboardHandler = handler
 }
 
-// hopByHopHeaders are headers that apply to a single transport-level
-// connection and must not be forwarded by proxies.
-var hopByHopHeaders = []string{
-	"Connection",
-	"Keep-Alive",
-	"Proxy-Authenticate",
-	"Proxy-Authorization",
-	"TE",
-	"Trailers",
-	"Transfer-Encoding",
-	"Upgrade",
-}
-
-// removeHopByHopHeaders strips hop-by-hop headers from an HTTP header set.
-func removeHopByHopHeaders(h http.Header) {
-	for _, hdr := range hopByHopHeaders {
-		h.Del(hdr)
-	}
-}
-
 // flattenHeaders converts HTTP headers to a flat key=value slice for structured logging.
 func flattenHeaders(h http.Header) []string {
 	var out []string
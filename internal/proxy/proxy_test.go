@@ -1,32 +1,49 @@
 package proxy_test
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/mitm"
 	"github.com/ushineko/face-puncher-supreme/internal/probe"
 	"github.com/ushineko/face-puncher-supreme/internal/proxy"
 	"github.com/ushineko/face-puncher-supreme/internal/stats"
+	"github.com/ushineko/face-puncher-supreme/internal/upstream"
 )
 
 // _startTestProxy starts a proxy server on a random port and returns
 // its URL and a cleanup function.
 func _startTestProxy(t *testing.T) (proxyURL string, cleanup func()) {
 	t.Helper()
+	return _startTestProxyWith(t, nil)
+}
+
+// _startTestProxyWith is like _startTestProxy but lets the caller adjust
+// the proxy config before the server is created.
+func _startTestProxyWith(t *testing.T, configure func(*proxy.Config)) (proxyURL string, cleanup func()) {
+	t.Helper()
 
 	// Find a free port.
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
@@ -36,14 +53,19 @@ func _startTestProxy(t *testing.T) (proxyURL string, cleanup func()) {
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	collector := stats.NewCollector()
-	srv := proxy.New(&proxy.Config{
+	cfg := &proxy.Config{
 		ListenAddr:       addr,
 		Logger:           logger,
 		HeartbeatHandler: http.NotFound,
 		StatsHandler:     http.NotFound,
 		OnRequest:        collector.RecordRequest,
 		OnTunnelClose:    collector.RecordBytes,
-	})
+		OnCacheLookup:    collector.RecordCacheLookup,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	srv := proxy.New(cfg)
 	// Set real handlers now that srv exists.
 	srv.SetHandlers(
 		probe.HeartbeatHandler(srv, nil, nil, nil, nil),
@@ -54,6 +76,9 @@ func _startTestProxy(t *testing.T) (proxyURL string, cleanup func()) {
 	)
 
 	go func() { _ = srv.ListenAndServe() }()
+	if srv.TLSEnabled() {
+		go func() { _ = srv.ListenAndServeTLS() }()
+	}
 
 	// Wait for the server to be ready.
 	deadline := time.Now().Add(2 * time.Second)
@@ -138,6 +163,48 @@ func TestManagementUnknownPath(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 }
 
+func TestManagementDisabledEndpoints(t *testing.T) {
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.DisabledEndpoints = []string{proxy.EndpointStats, proxy.EndpointCA, proxy.EndpointDashboard}
+	})
+	defer cleanup()
+
+	get := func(path string) int {
+		resp, err := http.Get(proxyURL + path)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Disabled groups answer 404 even with a handler set (stats) or where
+	// a missing handler would otherwise answer 503 (dashboard).
+	for _, path := range []string{
+		"/fps/stats", "/fps/stats/export.csv", "/fps/ca.pem", "/fps/ca/check",
+		"/fps/dashboard/", "/fps/api/auth/status", "/fps/logs/stream",
+	} {
+		assert.Equal(t, http.StatusNotFound, get(path), path)
+	}
+
+	assert.Equal(t, http.StatusOK, get("/fps/heartbeat"))
+}
+
+func TestManagementHeartbeatDisabled(t *testing.T) {
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.DisabledEndpoints = []string{proxy.EndpointHeartbeat}
+	})
+	defer cleanup()
+
+	resp, err := http.Get(proxyURL + "/fps/heartbeat")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	stats, err := http.Get(proxyURL + "/fps/stats")
+	require.NoError(t, err)
+	defer stats.Body.Close()
+	assert.Equal(t, http.StatusOK, stats.StatusCode)
+}
+
 func TestHTTPForwardProxy(t *testing.T) {
 	// Create a test upstream server.
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -210,6 +277,68 @@ func TestHTTPForwardProxyStripsHopByHopHeaders(t *testing.T) {
 	assert.Equal(t, "kept", resp.Header.Get("X-Real-Header"))
 }
 
+func TestHTTPForwardProxyStripsConfiguredHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("X-Client-Data"), "configured request header should be stripped")
+		assert.Equal(t, "kept", r.Header.Get("X-Other"))
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Powered-By", "PHP/8")
+		w.Header().Set("X-Real-Header", "kept")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.StripRequestHeaders = []string{"x-client-data"}
+		cfg.StripResponseHeaders = []string{"Server", "X-Powered-By"}
+	})
+	defer cleanup()
+
+	client := _proxyClient(proxyURL)
+	req, err := http.NewRequest(http.MethodGet, upstream.URL, http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("X-Client-Data", "tracking")
+	req.Header.Set("X-Other", "kept")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Empty(t, resp.Header.Get("Server"))
+	assert.Empty(t, resp.Header.Get("X-Powered-By"))
+	assert.Equal(t, "kept", resp.Header.Get("X-Real-Header"))
+}
+
+func TestSetCookieHeadersPreserved(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{"session=abc"}, r.Header.Values("Cookie"), "tracking cookie should be stripped")
+		w.Header().Add("Set-Cookie", "session=abc; Path=/; HttpOnly")
+		w.Header().Add("Set-Cookie", "theme=dark; Path=/")
+		w.Header().Add("Set-Cookie", "_ga=GA1.2.3; Path=/")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.StripCookies = []*regexp.Regexp{regexp.MustCompile(`^_ga`)}
+	})
+	defer cleanup()
+
+	client := _proxyClient(proxyURL)
+	req, err := http.NewRequest(http.MethodGet, upstream.URL, http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Cookie", "_ga=GA1.2.3; session=abc")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, []string{
+		"session=abc; Path=/; HttpOnly",
+		"theme=dark; Path=/",
+	}, resp.Header.Values("Set-Cookie"))
+}
+
 func TestHTTPSConnectTunnel(t *testing.T) {
 	// Create an HTTPS test server.
 	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -240,6 +369,145 @@ func TestHTTPSConnectTunnel(t *testing.T) {
 	assert.Equal(t, "hello from tls upstream", string(body))
 }
 
+func TestProxyOverTLS(t *testing.T) {
+	plainUpstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "hello over https proxy")
+	}))
+	defer plainUpstream.Close()
+	tlsUpstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "hello tls upstream via https proxy")
+	}))
+	defer tlsUpstream.Close()
+
+	cert, err := mitm.NewServerCert(nil, []string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tlsAddr := listener.Addr().String()
+	_ = listener.Close()
+
+	_, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.TLSListenAddr = tlsAddr
+		cfg.TLSCert = cert
+	})
+	defer cleanup()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	roots.AddCert(tlsUpstream.Certificate())
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(&url.URL{Scheme: "https", Host: tlsAddr}),
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	// Wait for the TLS listener to be ready.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, dialErr := net.DialTimeout("tcp", tlsAddr, 100*time.Millisecond)
+		if dialErr == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Plain HTTP forwarded through the TLS proxy connection.
+	resp, err := client.Get(plainUpstream.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello over https proxy", string(body))
+
+	// CONNECT tunnel inside the TLS proxy connection.
+	resp, err = client.Get(tlsUpstream.URL)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello tls upstream via https proxy", string(body))
+}
+
+// _startFallbackProxy starts a stand-in upstream proxy that answers every
+// plain HTTP request itself and answers every CONNECT with a tunnel that
+// writes "via fallback".
+func _startFallbackProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	fb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			_, _ = fmt.Fprintf(w, "via fallback: %s", r.URL.String())
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !assert.True(t, ok) {
+			return
+		}
+		conn, _, err := hijacker.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close() //nolint:errcheck // test cleanup
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\nvia fallback"))
+	}))
+	t.Cleanup(fb.Close)
+	return fb
+}
+
+// _closedAddr returns a loopback address with nothing listening.
+func _closedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+func TestFallbackWhenPrimaryDialFails(t *testing.T) {
+	fb := _startFallbackProxy(t)
+	fallback, err := upstream.NewFallback(fb.URL)
+	require.NoError(t, err)
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Fallback = fallback
+	})
+	defer cleanup()
+
+	target := _closedAddr(t)
+
+	// Plain HTTP: the direct dial is refused, the fallback proxy answers.
+	resp, err := _proxyClient(proxyURL).Get("http://" + target + "/page")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "via fallback: http://"+target+"/page", string(body))
+
+	// CONNECT: the tunnel is opened through the fallback proxy.
+	conn := _openConnectTunnel(t, strings.TrimPrefix(proxyURL, "http://"), target)
+	defer conn.Close() //nolint:errcheck // test cleanup
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "via fallback", string(got))
+}
+
+func TestNoFallbackReturnsBadGateway(t *testing.T) {
+	proxyURL, cleanup := _startTestProxy(t)
+	defer cleanup()
+
+	resp, err := _proxyClient(proxyURL).Get("http://" + _closedAddr(t) + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
 func TestConcurrentConnections(t *testing.T) {
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(10 * time.Millisecond)
@@ -280,256 +548,1039 @@ func TestConcurrentConnections(t *testing.T) {
 	}
 }
 
-func TestMalformedRequest(t *testing.T) {
-	proxyURL, cleanup := _startTestProxy(t)
-	defer cleanup()
-
-	// A GET request without a host (direct to proxy, not a proxy request
-	// and not a management endpoint) should return 400.
-	req, err := http.NewRequest(http.MethodGet, proxyURL+"/some/path", http.NoBody)
-	require.NoError(t, err)
-	// Clear the host to simulate a malformed proxy request.
-	// When sending directly, the URL host is set to the proxy, which makes
-	// this a non-proxy request. The proxy should handle it gracefully.
-	resp, err := http.DefaultClient.Do(req)
-	require.NoError(t, err)
-	defer resp.Body.Close()
-
-	// The proxy sees this as a direct request with URL path /some/path
-	// and no absolute URL host — should return bad request.
-	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
-}
-
-func TestStatsConnectionCounters(t *testing.T) {
+func TestMaxInflightShedsExcessRequests(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	release := make(chan struct{})
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+		if r.URL.Path == "/slow" {
+			started.Done()
+			<-release
+		}
+		_, _ = fmt.Fprint(w, "ok")
 	}))
 	defer upstream.Close()
 
-	proxyURL, cleanup := _startTestProxy(t)
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.MaxInflight = 2
+	})
 	defer cleanup()
 
-	// Make a few requests through the proxy.
-	client := _proxyClient(proxyURL)
-	for i := 0; i < 5; i++ {
-		resp, err := client.Get(upstream.URL)
+	// Occupy both slots.
+	slow := make(chan int, 2)
+	for range 2 {
+		go func() {
+			resp, err := _proxyClient(proxyURL).Get(upstream.URL + "/slow")
+			if err != nil {
+				slow <- 0
+				return
+			}
+			_ = resp.Body.Close()
+			slow <- resp.StatusCode
+		}()
+	}
+	started.Wait()
+
+	for range 3 {
+		resp, err := _proxyClient(proxyURL).Get(upstream.URL + "/fast")
 		require.NoError(t, err)
 		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("Retry-After"))
 	}
 
-	// Check stats counters.
-	resp, err := http.Get(proxyURL + "/fps/stats")
+	// Management endpoints are exempt from shedding.
+	resp, err := http.Get(proxyURL + "/fps/heartbeat")
 	require.NoError(t, err)
-	defer resp.Body.Close()
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	var statsResp probe.StatsResponse
-	err = json.NewDecoder(resp.Body).Decode(&statsResp)
+	close(release)
+	assert.Equal(t, http.StatusOK, <-slow)
+	assert.Equal(t, http.StatusOK, <-slow)
+
+	// Capacity is back once the slow requests finish.
+	resp, err = _proxyClient(proxyURL).Get(upstream.URL + "/fast")
 	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-	// connections.total should be > 5 (5 proxied requests + this stats request + possibly earlier requests).
-	assert.GreaterOrEqual(t, statsResp.Connections.Total, int64(5),
-		"should have counted at least the 5 proxied requests")
-	assert.GreaterOrEqual(t, statsResp.Traffic.TotalRequests, int64(5),
-		"should have recorded at least the 5 proxied requests in traffic")
+	resp, err = http.Get(proxyURL + "/fps/stats")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body struct {
+		Connections probe.ConnectionsBlock `json:"connections"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, int64(3), body.Connections.Shed)
 }
 
-func TestLargeResponse(t *testing.T) {
-	// Generate a 1MB response.
-	largeBody := strings.Repeat("x", 1024*1024)
-	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprint(w, largeBody)
+func TestMaxInflightCountsOpenTunnels(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "ok")
 	}))
 	defer upstream.Close()
 
-	proxyURL, cleanup := _startTestProxy(t)
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.MaxInflight = 1
+	})
 	defer cleanup()
+	pURL, err := url.Parse(proxyURL)
+	require.NoError(t, err)
 
-	client := _proxyClient(proxyURL)
-	resp, err := client.Get(upstream.URL)
+	// An open CONNECT tunnel holds the only slot.
+	conn, err := net.Dial("tcp", pURL.Host)
 	require.NoError(t, err)
-	defer resp.Body.Close()
+	target := upstream.Listener.Addr().String()
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
 
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = _proxyClient(proxyURL).Get(upstream.URL + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
 
-	body, err := io.ReadAll(resp.Body)
+	// Management requests neither count nor get shed.
+	resp, err = http.Get(proxyURL + "/fps/heartbeat")
 	require.NoError(t, err)
-	assert.Len(t, body, 1024*1024, "full 1MB body should be relayed")
-}
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
-// _mockBlocker is a simple blocker for testing that blocks a fixed set of domains.
-type _mockBlocker struct {
-	blocked map[string]bool
+	// Closing the tunnel frees the slot.
+	_ = conn.Close()
+	assert.Eventually(t, func() bool {
+		resp, err := _proxyClient(proxyURL).Get(upstream.URL + "/")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 20*time.Millisecond)
 }
 
-func (m *_mockBlocker) IsBlocked(domain string) bool {
-	return m.blocked[strings.ToLower(domain)]
-}
+// _countingBlocker records lookups and blocks nothing.
+type _countingBlocker struct{ calls atomic.Int64 }
 
-// _startTestProxyWithBlocker starts a proxy with a blocker configured.
-func _startTestProxyWithBlocker(t *testing.T, blocker proxy.Blocker) (proxyURL string, cleanup func()) {
-	t.Helper()
+func (b *_countingBlocker) IsBlocked(string) bool {
+	b.calls.Add(1)
+	return false
+}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
-	require.NoError(t, err)
-	addr := listener.Addr().String()
-	_ = listener.Close()
+func TestProxyAuth(t *testing.T) {
+	var sawAuth atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawAuth.Store(r.Header.Get("Proxy-Authorization") != "")
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
 
-	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	collector := stats.NewCollector()
-	srv := proxy.New(&proxy.Config{
-		ListenAddr:       addr,
-		Logger:           logger,
-		Blocker:          blocker,
-		HeartbeatHandler: http.NotFound,
-		StatsHandler:     http.NotFound,
-		OnRequest:        collector.RecordRequest,
-		OnTunnelClose:    collector.RecordBytes,
+	blocker := &_countingBlocker{}
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = blocker
+		cfg.ProxyAuth = map[string]string{"alice": "s3cret", "bob": "hunter2"}
 	})
-	srv.SetHandlers(
-		probe.HeartbeatHandler(srv, nil, nil, nil, nil),
-		probe.StatsHandler(&probe.StatsProvider{
-			Info:      srv,
-			Collector: collector,
-		}),
-	)
+	defer cleanup()
 
-	go func() { _ = srv.ListenAndServe() }()
+	withUser := func(userinfo *url.Userinfo) string {
+		u, err := url.Parse(proxyURL)
+		require.NoError(t, err)
+		u.User = userinfo
+		return u.String()
+	}
 
-	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
-		conn, dialErr := net.DialTimeout("tcp", addr, 100*time.Millisecond)
-		if dialErr == nil {
-			_ = conn.Close()
-			break
-		}
-		time.Sleep(10 * time.Millisecond)
+	for name, pURL := range map[string]string{
+		"missing":      proxyURL,
+		"bad password": withUser(url.UserPassword("alice", "wrong")),
+		"unknown user": withUser(url.UserPassword("carol", "s3cret")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp, err := _proxyClient(pURL).Get(upstream.URL)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+			assert.Equal(t, `Basic realm="fpsd"`, resp.Header.Get("Proxy-Authenticate"))
+		})
 	}
+	assert.Zero(t, blocker.calls.Load(), "rejected requests must not reach the blocklist")
 
-	return "http://" + addr, func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-		defer cancel()
-		_ = srv.Shutdown(ctx)
+	for _, user := range []*url.Userinfo{url.UserPassword("alice", "s3cret"), url.UserPassword("bob", "hunter2")} {
+		resp, err := _proxyClient(withUser(user)).Get(upstream.URL)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "ok", string(body))
 	}
+	assert.False(t, sawAuth.Load(), "credentials must not be forwarded upstream")
+
+	// Management endpoints stay reachable without credentials.
+	resp, err := http.Get(proxyURL + "/fps/heartbeat")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
-func TestHTTPBlockedDomain(t *testing.T) {
-	// Create a test upstream that should never be reached.
-	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t.Error("upstream should not be reached for blocked domains")
-		w.WriteHeader(http.StatusOK)
+func TestProxyAuthConnect(t *testing.T) {
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.ProxyAuth = map[string]string{"alice": "s3cret"}
+	})
+	defer cleanup()
+	proxyAddr := strings.TrimPrefix(proxyURL, "http://")
+
+	// Without credentials the CONNECT is answered with 407, not hijacked.
+	conn, err := net.DialTimeout("tcp", proxyAddr, 2*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	assert.Equal(t, `Basic realm="fpsd"`, resp.Header.Get("Proxy-Authenticate"))
+
+	// With credentials the tunnel is established.
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "tunneled")
 	}))
-	defer upstream.Close()
+	defer target.Close()
+	u, err := url.Parse(proxyURL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("alice", "s3cret")
+	client := _proxyClient(u.String())
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	targetTransport, ok := target.Client().Transport.(*http.Transport)
+	require.True(t, ok)
+	transport.TLSClientConfig = targetTransport.TLSClientConfig
 
-	// Parse upstream URL to get the host.
-	upstreamURL, err := url.Parse(upstream.URL)
+	tresp, err := client.Get(target.URL)
 	require.NoError(t, err)
+	body, err := io.ReadAll(tresp.Body)
+	_ = tresp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "tunneled", string(body))
+}
 
-	blocker := &_mockBlocker{blocked: map[string]bool{
-		upstreamURL.Hostname(): true,
-	}}
+func TestRateLimit(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
 
-	proxyURL, cleanup := _startTestProxyWithBlocker(t, blocker)
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.RateLimit = 0.01
+		cfg.RateBurst = 2
+	})
 	defer cleanup()
-
 	client := _proxyClient(proxyURL)
+
+	for range 2 {
+		resp, err := client.Get(upstream.URL)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// The burst is spent; the next token is 100s away.
 	resp, err := client.Get(upstream.URL)
 	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "100", resp.Header.Get("Retry-After"))
+
+	// CONNECT draws from the same bucket.
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(proxyURL, "http://"), 2*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	require.NoError(t, err)
+	cresp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	_ = cresp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, cresp.StatusCode)
+
+	// Management endpoints stay reachable for the throttled client.
+	for range 3 {
+		resp, err := http.Get(proxyURL + "/fps/heartbeat")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp, err = http.Get(proxyURL + "/fps/stats")
+	require.NoError(t, err)
 	defer resp.Body.Close()
+	var st probe.StatsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+	assert.Equal(t, int64(2), st.Connections.RateLimited)
+}
 
-	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+func TestRequestTimeoutReturns504(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.RequestTimeout = 100 * time.Millisecond
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+
+	resp, err := client.Get(upstream.URL + "/fast")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	start := time.Now()
+	resp, err = client.Get(upstream.URL + "/slow")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Less(t, time.Since(start), 5*time.Second)
 }
 
-func TestHTTPAllowedDomain(t *testing.T) {
+func TestRequestTimeoutBodyStreaming(t *testing.T) {
 	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Headers immediately, then a body that trickles past the timeout.
 		w.WriteHeader(http.StatusOK)
-		_, _ = fmt.Fprint(w, "allowed")
+		for i := 0; i < 5; i++ {
+			_, _ = w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
 	}))
 	defer upstream.Close()
 
-	// Blocker that blocks a different domain, not the upstream.
-	blocker := &_mockBlocker{blocked: map[string]bool{
-		"blocked.example.com": true,
-	}}
+	for _, wholeBody := range []bool{false, true} {
+		proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+			cfg.RequestTimeout = 100 * time.Millisecond
+			cfg.TimeoutWholeBody = wholeBody
+		})
+		client := _proxyClient(proxyURL)
 
-	proxyURL, cleanup := _startTestProxyWithBlocker(t, blocker)
-	defer cleanup()
+		resp, err := client.Get(upstream.URL)
+		if wholeBody {
+			// The response is aborted at the deadline: either before the
+			// buffered headers reach the client or partway through the body.
+			if err == nil {
+				_, err = io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+			}
+			assert.Error(t, err, "response cut off at the deadline")
+		} else {
+			require.NoError(t, err)
+			body, readErr := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			require.NoError(t, readErr, "timeout stops at headers")
+			assert.Equal(t, strings.Repeat("chunk", 5), string(body))
+		}
+		cleanup()
+	}
+}
+
+func TestMaxResponseBytesStopsRelay(t *testing.T) {
+	const limit = 4096
+	streamed := make(chan int64, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/small" {
+			_, _ = w.Write(make([]byte, limit))
+			return
+		}
+		// Stream until the proxy stops reading (or 64 MiB).
+		chunk := make([]byte, 1024)
+		var n int64
+		for n < 64<<20 {
+			if _, err := w.Write(chunk); err != nil {
+				break
+			}
+			n += int64(len(chunk))
+			w.(http.Flusher).Flush()
+		}
+		streamed <- n
+	}))
+	defer upstream.Close()
 
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.MaxResponseBytes = limit
+	})
+	defer cleanup()
 	client := _proxyClient(proxyURL)
-	resp, err := client.Get(upstream.URL)
+
+	// A response at the limit is relayed intact.
+	resp, err := client.Get(upstream.URL + "/small")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Len(t, body, limit)
+
+	resp, err = client.Get(upstream.URL + "/huge")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Error(t, err, "client must see an aborted response")
+	assert.Len(t, body, limit)
+
+	select {
+	case n := <-streamed:
+		assert.Less(t, n, int64(64<<20), "upstream should stop once the proxy gives up")
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream kept streaming after the limit")
+	}
+
+	resp, err = http.Get(proxyURL + "/fps/stats")
 	require.NoError(t, err)
 	defer resp.Body.Close()
+	var stats struct {
+		Connections probe.ConnectionsBlock `json:"connections"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	assert.Equal(t, int64(1), stats.Connections.Truncated)
+}
 
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
+// _startRawUpstream serves response verbatim to every connection, after
+// reading the request header.
+func _startRawUpstream(t *testing.T, response string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close() //nolint:errcheck // test cleanup
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				_, _ = io.WriteString(conn, response)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestLenientHeaders(t *testing.T) {
+	target := _startRawUpstream(t, "HTTP/1.1 200 OK\r\n"+
+		"Bad(Header: x\r\n"+
+		"X-Control: a\x01b\r\n"+
+		"X-Good: yes\r\n"+
+		"Content-Length: 2\r\n\r\nok")
+
+	// Strict (default): the malformed header fails the request.
+	proxyURL, cleanup := _startTestProxy(t)
+	resp, err := _proxyClient(proxyURL).Get("http://" + target + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	cleanup()
 
+	proxyURL, cleanup = _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.LenientHeaders = true
+	})
+	defer cleanup()
+
+	resp, err = _proxyClient(proxyURL).Get("http://" + target + "/")
+	require.NoError(t, err)
 	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
 	require.NoError(t, err)
-	assert.Equal(t, "allowed", string(body))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, "yes", resp.Header.Get("X-Good"))
+
+	resp, err = http.Get(proxyURL + "/fps/stats")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var stats struct {
+		Connections probe.ConnectionsBlock `json:"connections"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	assert.Equal(t, int64(1), stats.Connections.Sanitized)
 }
 
-func TestCONNECTBlockedDomain(t *testing.T) {
-	// Create an HTTPS upstream that should never be reached.
-	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t.Error("upstream should not be reached for blocked domains")
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer upstream.Close()
+func TestLenientHeadersRefusesFraming(t *testing.T) {
+	target := _startRawUpstream(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 5\x01\r\n"+
+		"Content-Length: 2\r\n\r\nok")
 
-	upstreamURL, err := url.Parse(upstream.URL)
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.LenientHeaders = true
+	})
+	defer cleanup()
+
+	resp, err := _proxyClient(proxyURL).Get("http://" + target + "/")
 	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
 
-	blocker := &_mockBlocker{blocked: map[string]bool{
-		upstreamURL.Hostname(): true,
-	}}
+func TestMalformedRequest(t *testing.T) {
+	proxyURL, cleanup := _startTestProxy(t)
+	defer cleanup()
 
-	proxyURL, cleanup := _startTestProxyWithBlocker(t, blocker)
+	// A GET request without a host (direct to proxy, not a proxy request
+	// and not a management endpoint) should return 400.
+	req, err := http.NewRequest(http.MethodGet, proxyURL+"/some/path", http.NoBody)
+	require.NoError(t, err)
+	// Clear the host to simulate a malformed proxy request.
+	// When sending directly, the URL host is set to the proxy, which makes
+	// this a non-proxy request. The proxy should handle it gracefully.
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The proxy sees this as a direct request with URL path /some/path
+	// and no absolute URL host — should return bad request.
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStatsConnectionCounters(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxy(t)
 	defer cleanup()
 
+	// Make a few requests through the proxy.
 	client := _proxyClient(proxyURL)
-	// Override TLS config to trust test server cert.
-	transport, ok := client.Transport.(*http.Transport)
-	require.True(t, ok)
-	upstreamTransport, ok := upstream.Client().Transport.(*http.Transport)
-	require.True(t, ok)
-	transport.TLSClientConfig = upstreamTransport.TLSClientConfig
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(upstream.URL)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
 
-	// CONNECT to a blocked domain should fail. The HTTP client will get an error
-	// because the proxy returns 403 instead of establishing the tunnel.
-	_, err = client.Get(upstream.URL)
-	assert.Error(t, err, "CONNECT to blocked domain should fail")
+	// Check stats counters.
+	resp, err := http.Get(proxyURL + "/fps/stats")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var statsResp probe.StatsResponse
+	err = json.NewDecoder(resp.Body).Decode(&statsResp)
+	require.NoError(t, err)
+
+	// connections.total should be > 5 (5 proxied requests + this stats request + possibly earlier requests).
+	assert.GreaterOrEqual(t, statsResp.Connections.Total, int64(5),
+		"should have counted at least the 5 proxied requests")
+	assert.GreaterOrEqual(t, statsResp.Traffic.TotalRequests, int64(5),
+		"should have recorded at least the 5 proxied requests in traffic")
 }
 
-func TestHeartbeatShowsPassthroughWithNoBlocker(t *testing.T) {
+func TestLargeResponse(t *testing.T) {
+	// Generate a 1MB response.
+	largeBody := strings.Repeat("x", 1024*1024)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, largeBody)
+	}))
+	defer upstream.Close()
+
 	proxyURL, cleanup := _startTestProxy(t)
 	defer cleanup()
 
-	resp, err := http.Get(proxyURL + "/fps/heartbeat")
+	client := _proxyClient(proxyURL)
+	resp, err := client.Get(upstream.URL)
 	require.NoError(t, err)
 	defer resp.Body.Close()
 
-	var hbResp probe.HeartbeatResponse
-	err = json.NewDecoder(resp.Body).Decode(&hbResp)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
 	require.NoError(t, err)
+	assert.Len(t, body, 1024*1024, "full 1MB body should be relayed")
+}
 
-	assert.Equal(t, "passthrough", hbResp.Mode)
+// _mockBlocker is a simple blocker for testing that blocks a fixed set of domains.
+type _mockBlocker struct {
+	blocked map[string]bool
 }
 
-func TestGracefulShutdown(t *testing.T) {
+func (m *_mockBlocker) IsBlocked(domain string) bool {
+	return m.blocked[strings.ToLower(domain)]
+}
+
+// _startTestProxyWithBlocker starts a proxy with a blocker configured.
+func _startTestProxyWithBlocker(t *testing.T, blocker proxy.Blocker) (proxyURL string, cleanup func()) {
+	t.Helper()
+
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
 	addr := listener.Addr().String()
 	_ = listener.Close()
 
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	collector := stats.NewCollector()
 	srv := proxy.New(&proxy.Config{
 		ListenAddr:       addr,
 		Logger:           logger,
+		Blocker:          blocker,
 		HeartbeatHandler: http.NotFound,
 		StatsHandler:     http.NotFound,
+		OnRequest:        collector.RecordRequest,
+		OnTunnelClose:    collector.RecordBytes,
 	})
+	srv.SetHandlers(
+		probe.HeartbeatHandler(srv, nil, nil, nil, nil),
+		probe.StatsHandler(&probe.StatsProvider{
+			Info:      srv,
+			Collector: collector,
+		}),
+	)
 
-	done := make(chan error, 1)
+	go func() { _ = srv.ListenAndServe() }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, dialErr := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if dialErr == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return "http://" + addr, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+func TestHTTPBlockedDomain(t *testing.T) {
+	// Create a test upstream that should never be reached.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("upstream should not be reached for blocked domains")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	// Parse upstream URL to get the host.
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	blocker := &_mockBlocker{blocked: map[string]bool{
+		upstreamURL.Hostname(): true,
+	}}
+
+	proxyURL, cleanup := _startTestProxyWithBlocker(t, blocker)
+	defer cleanup()
+
+	client := _proxyClient(proxyURL)
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestHTTPBlockPageTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("block").Parse(
+		`<h1>{{.Domain}} is blocked</h1><p>{{.URL}}</p>{{if .Back}}<a href="{{.Back}}">Back</a>{{end}}`))
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = &_mockBlocker{blocked: map[string]bool{"ads.example.com": true}}
+		cfg.BlockPage = tmpl
+	})
+	defer cleanup()
+
+	req, err := http.NewRequest(http.MethodGet, "http://ads.example.com/pixel.gif?q=<x>", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Referer", "http://news.example.com/story")
+	resp, err := _proxyClient(proxyURL).Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(body), "<h1>ads.example.com is blocked</h1>")
+	assert.Contains(t, string(body), `<a href="http://news.example.com/story">Back</a>`)
+	assert.NotContains(t, string(body), "<x>", "template values are HTML-escaped")
+
+	// Without a template the 403 stays plain text.
+	plainURL, plainCleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = &_mockBlocker{blocked: map[string]bool{"ads.example.com": true}}
+	})
+	defer plainCleanup()
+	resp, err = _proxyClient(plainURL).Get("http://ads.example.com/pixel.gif")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "blocked by proxy\n", string(body))
+}
+
+func TestConnectBlockPage(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	require.NoError(t, mitm.GenerateCA(certPath, keyPath, false))
+	ca, err := mitm.LoadCA(certPath, keyPath)
+	require.NoError(t, err)
+	interceptor := mitm.NewInterceptor(&mitm.InterceptorConfig{
+		CA:     ca,
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	defer interceptor.Close()
+
+	blocker := &_mockBlocker{blocked: map[string]bool{"ads.example.com": true}}
+	var blocks atomic.Int64
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = blocker
+		cfg.ConnectBlockPage = interceptor
+		cfg.OnBlock = func(_, _, _ string) { blocks.Add(1) }
+	})
+	defer cleanup()
+
+	// The client trusts the proxy CA, as it must for MITM.
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	pURL, err := url.Parse(proxyURL)
+	require.NoError(t, err)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(pURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get("https://ads.example.com/banner.js")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(body), "<code>ads.example.com</code> is blocked")
+	assert.Equal(t, int64(1), blocks.Load())
+
+	// Without a block page server the CONNECT itself is refused.
+	proxyURL, cleanup2 := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = blocker
+	})
+	defer cleanup2()
+	_, err = _proxyClient(proxyURL).Get("https://ads.example.com/banner.js")
+	assert.ErrorContains(t, err, "Forbidden")
+}
+
+func TestHTTPAllowedDomain(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprint(w, "allowed")
+	}))
+	defer upstream.Close()
+
+	// Blocker that blocks a different domain, not the upstream.
+	blocker := &_mockBlocker{blocked: map[string]bool{
+		"blocked.example.com": true,
+	}}
+
+	proxyURL, cleanup := _startTestProxyWithBlocker(t, blocker)
+	defer cleanup()
+
+	client := _proxyClient(proxyURL)
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "allowed", string(body))
+}
+
+func TestHTTPBlockedDomainReportsReferer(t *testing.T) {
+	type blockEvent struct{ domain, referer string }
+	var mu sync.Mutex
+	var events []blockEvent
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = &_mockBlocker{blocked: map[string]bool{"ads.example.com": true}}
+		cfg.OnBlock = func(_, domain, referer string) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, blockEvent{domain, referer})
+		}
+	})
+	defer cleanup()
+
+	req, err := http.NewRequest(http.MethodGet, "http://ads.example.com/pixel.gif", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Referer", "http://news.example.com/story")
+	resp, err := _proxyClient(proxyURL).Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []blockEvent{{"ads.example.com", "http://news.example.com/story"}}, events)
+}
+
+func TestPassthroughForwardsBlockedDomain(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("upstream-ok"))
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	passthrough := new(atomic.Bool)
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = &_mockBlocker{blocked: map[string]bool{upstreamURL.Hostname(): true}}
+		cfg.Passthrough = passthrough
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	passthrough.Store(true)
+	resp, err = client.Get(upstream.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "upstream-ok", string(body))
+
+	// CONNECT is forwarded too.
+	conn := _openConnectTunnel(t, strings.TrimPrefix(proxyURL, "http://"), upstreamURL.Host)
+	_ = conn.Close()
+}
+
+func TestCONNECTBlockedDomain(t *testing.T) {
+	// Create an HTTPS upstream that should never be reached.
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("upstream should not be reached for blocked domains")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	blocker := &_mockBlocker{blocked: map[string]bool{
+		upstreamURL.Hostname(): true,
+	}}
+
+	proxyURL, cleanup := _startTestProxyWithBlocker(t, blocker)
+	defer cleanup()
+
+	client := _proxyClient(proxyURL)
+	// Override TLS config to trust test server cert.
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	upstreamTransport, ok := upstream.Client().Transport.(*http.Transport)
+	require.True(t, ok)
+	transport.TLSClientConfig = upstreamTransport.TLSClientConfig
+
+	// CONNECT to a blocked domain should fail. The HTTP client will get an error
+	// because the proxy returns 403 instead of establishing the tunnel.
+	_, err = client.Get(upstream.URL)
+	assert.Error(t, err, "CONNECT to blocked domain should fail")
+}
+
+func TestBlockedCIDRs(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
+	// Address the upstream by name so the proxy has to resolve it.
+	_, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	require.NoError(t, err)
+	target := net.JoinHostPort("localhost", port)
+
+	var blocked atomic.Int64
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.BlockedCIDRs = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+		cfg.OnRequest = func(_, _ string, b bool, _, _ int64) {
+			if b {
+				blocked.Add(1)
+			}
+		}
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+
+	resp, err := client.Get("http://" + target + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, err = client.Get("https://" + target + "/")
+	assert.ErrorContains(t, err, "Forbidden")
+	assert.Equal(t, int64(2), blocked.Load())
+
+	// MITM'd hosts are dialed by the interceptor, but are still refused
+	// before the tunnel is established.
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	require.NoError(t, mitm.GenerateCA(certPath, keyPath, false))
+	ca, err := mitm.LoadCA(certPath, keyPath)
+	require.NoError(t, err)
+	interceptor := mitm.NewInterceptor(&mitm.InterceptorConfig{
+		CA:      ca,
+		Domains: []string{"localhost"},
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	defer interceptor.Close()
+	proxyURL, cleanupMITM := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.BlockedCIDRs = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+		cfg.MITMInterceptor = interceptor
+	})
+	defer cleanupMITM()
+	_, err = _proxyClient(proxyURL).Get("https://" + target + "/")
+	assert.ErrorContains(t, err, "Forbidden")
+
+	// Hosts outside the blocked ranges are dialed as usual.
+	proxyURL, cleanup2 := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.BlockedCIDRs = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	})
+	defer cleanup2()
+	resp, err = _proxyClient(proxyURL).Get("http://" + target + "/")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestHeartbeatShowsPassthroughWithNoBlocker(t *testing.T) {
+	proxyURL, cleanup := _startTestProxy(t)
+	defer cleanup()
+
+	resp, err := http.Get(proxyURL + "/fps/heartbeat")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var hbResp probe.HeartbeatResponse
+	err = json.NewDecoder(resp.Body).Decode(&hbResp)
+	require.NoError(t, err)
+
+	assert.Equal(t, "passthrough", hbResp.Mode)
+}
+
+// _shortBodyHandler declares a 100-byte body, sends 5 bytes, and closes.
+func _shortBodyHandler(w http.ResponseWriter, _ *http.Request) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 100\r\n\r\nshort")
+	_ = buf.Flush()
+}
+
+func TestStrictContentLengthAbortsShortBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(_shortBodyHandler))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.StrictContentLength = true
+	})
+	defer cleanup()
+
+	client := _proxyClient(proxyURL)
+	client.Timeout = 5 * time.Second
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// A truncated body is not relayed as a complete 200.
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "upstream response truncated")
+}
+
+// _panicBlocker panics for one domain, standing in for a buggy hook.
+type _panicBlocker struct{ domain string }
+
+func (b _panicBlocker) IsBlocked(domain string) bool {
+	if domain == b.domain {
+		panic("blocker bug")
+	}
+	return false
+}
+
+func TestRecoversHandlerPanic(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	srv := proxy.New(&proxy.Config{
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Blocker:          _panicBlocker{domain: "panic.test"},
+		HeartbeatHandler: http.NotFound,
+		StatsHandler:     http.NotFound,
+	})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client := _proxyClient(ts.URL)
+
+	resp, err := client.Get("http://panic.test/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int64(1), srv.Panics())
+
+	// The proxy keeps serving after the panic.
+	resp, err = client.Get(upstream.URL)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int64(1), srv.Panics())
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := proxy.New(&proxy.Config{
+		ListenAddr:       addr,
+		Logger:           logger,
+		HeartbeatHandler: http.NotFound,
+		StatsHandler:     http.NotFound,
+	})
+
+	done := make(chan error, 1)
 	go func() {
 		done <- srv.ListenAndServe()
 	}()
@@ -559,3 +1610,554 @@ func TestGracefulShutdown(t *testing.T) {
 	err = srv.Shutdown(ctx)
 	assert.NoError(t, err)
 }
+
+// _openConnectTunnel dials the proxy, issues a CONNECT to target, and returns
+// the established tunnel connection.
+func _openConnectTunnel(t *testing.T, proxyAddr, target string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 2*time.Second)
+	require.NoError(t, err)
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	require.NoError(t, err)
+
+	buf := make([]byte, len("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Contains(t, string(buf), "200")
+	return conn
+}
+
+func TestDrainTunnels(t *testing.T) {
+	// Upstream that holds connections open until the client closes.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			c, acceptErr := upstream.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, c); _ = c.Close() }()
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := proxy.New(&proxy.Config{
+		ListenAddr:       addr,
+		Logger:           logger,
+		HeartbeatHandler: http.NotFound,
+		StatsHandler:     http.NotFound,
+	})
+	go func() { _ = srv.ListenAndServe() }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, dialErr := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if dialErr == nil {
+			_ = conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("completes when tunnels close", func(t *testing.T) {
+		tunnel := _openConnectTunnel(t, addr, upstream.Addr().String())
+		assert.Eventually(t, func() bool { return srv.TunnelsActive() == 1 }, time.Second, 10*time.Millisecond)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = tunnel.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		assert.NoError(t, srv.DrainTunnels(ctx))
+		assert.Equal(t, 0, srv.TunnelsActive())
+	})
+
+	t.Run("force-closes on timeout", func(t *testing.T) {
+		tunnel := _openConnectTunnel(t, addr, upstream.Addr().String())
+		defer tunnel.Close()
+		assert.Eventually(t, func() bool { return srv.TunnelsActive() == 1 }, time.Second, 10*time.Millisecond)
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+		defer shutdownCancel()
+		require.NoError(t, srv.Shutdown(shutdownCtx))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, srv.DrainTunnels(ctx), context.DeadlineExceeded)
+
+		// The client side of the tunnel observes the close.
+		_ = tunnel.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, readErr := tunnel.Read(make([]byte, 1))
+		assert.Error(t, readErr)
+		assert.Eventually(t, func() bool { return srv.TunnelsActive() == 0 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestSessionsListOpenTunnels(t *testing.T) {
+	// Upstream echoes each chunk back and holds the connection open until
+	// the client closes.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			c, acceptErr := upstream.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(c, c); _ = c.Close() }()
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	srv := proxy.New(&proxy.Config{
+		ListenAddr:       addr,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		HeartbeatHandler: http.NotFound,
+		StatsHandler:     http.NotFound,
+	})
+	go func() { _ = srv.ListenAndServe() }()
+	defer func() { _ = srv.Shutdown(context.Background()) }()
+	require.Eventually(t, func() bool {
+		conn, dialErr := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if dialErr == nil {
+			_ = conn.Close()
+		}
+		return dialErr == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Empty(t, srv.Sessions())
+
+	tunnel := _openConnectTunnel(t, addr, upstream.Addr().String())
+	_, err = tunnel.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(tunnel, make([]byte, 5))
+	require.NoError(t, err)
+
+	sessions := srv.Sessions()
+	require.Len(t, sessions, 1)
+	s := sessions[0]
+	assert.Equal(t, "proxy", s.Listener)
+	assert.Equal(t, "tunnel", s.Kind)
+	assert.Equal(t, "127.0.0.1", s.ClientIP)
+	assert.Equal(t, "127.0.0.1", s.Domain)
+	assert.WithinDuration(t, time.Now(), s.Started, 5*time.Second)
+	assert.Equal(t, int64(5), s.UploadBytes)
+	assert.Equal(t, int64(5), s.DownloadBytes)
+
+	_ = tunnel.Close()
+	assert.Eventually(t, func() bool { return len(srv.Sessions()) == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWebSocketUpgrade(t *testing.T) {
+	// Upstream completes the handshake and echoes bytes after it.
+	var sawUpgrade, sawKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawUpgrade = r.Header.Get("Connection") + "/" + r.Header.Get("Upgrade")
+		sawKey = r.Header.Get("Sec-WebSocket-Key")
+		conn, buf, err := w.(http.Hijacker).Hijack() //nolint:forcetypeassert // test server supports hijacking
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_, _ = io.Copy(conn, buf)
+	}))
+	defer upstream.Close()
+
+	type closed struct{ up, down int64 }
+	tunnelClosed := make(chan closed, 1)
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.OnTunnelClose = func(_ string, up, down int64) { tunnelClosed <- closed{up, down} }
+	})
+	defer cleanup()
+
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(proxyURL, "http://"), 2*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The first frame follows the handshake in the same write, so it must
+	// survive in the proxy's read buffer.
+	_, err = fmt.Fprintf(conn, "GET %s/chat HTTP/1.1\r\nHost: %s\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Upgrade: websocket\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\nhello",
+		upstream.URL, strings.TrimPrefix(upstream.URL, "http://"))
+	require.NoError(t, err)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade/websocket", sawUpgrade)
+	assert.Equal(t, "dGhlIHNhbXBsZSBub25jZQ==", sawKey)
+
+	echo := make([]byte, 5)
+	_, err = io.ReadFull(br, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(echo))
+
+	_, err = conn.Write([]byte("again"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(br, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(echo))
+
+	_ = conn.Close()
+	select {
+	case c := <-tunnelClosed:
+		assert.Equal(t, int64(10), c.up)
+		assert.Greater(t, c.down, int64(10), "101 response plus echoed frames")
+	case <-time.After(2 * time.Second):
+		t.Fatal("tunnel close not reported")
+	}
+}
+
+func TestChaosDelay(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
+
+	const delay = 300 * time.Millisecond
+	for _, tc := range []struct {
+		probability float64
+		delayed     bool
+	}{
+		{probability: 1.0, delayed: true},
+		{probability: 0.0, delayed: false},
+	} {
+		t.Run(fmt.Sprintf("probability %.1f", tc.probability), func(t *testing.T) {
+			proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+				cfg.ChaosDelay = delay
+				cfg.ChaosProbability = tc.probability
+			})
+			defer cleanup()
+
+			start := time.Now()
+			resp, err := _proxyClient(proxyURL).Get(upstream.URL)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			elapsed := time.Since(start)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			resp, err = http.Get(proxyURL + "/fps/stats")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			var stats struct {
+				Connections probe.ConnectionsBlock `json:"connections"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+
+			if tc.delayed {
+				assert.GreaterOrEqual(t, elapsed, delay)
+				assert.Equal(t, int64(1), stats.Connections.ChaosDelayed)
+			} else {
+				assert.Less(t, elapsed, delay)
+				assert.Zero(t, stats.Connections.ChaosDelayed)
+			}
+		})
+	}
+}
+
+func TestTransportKeepAlives(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disable_keep_alives=%v", disable), func(t *testing.T) {
+			var conns atomic.Int32
+			upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = fmt.Fprint(w, "ok")
+			}))
+			upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			upstream.Start()
+			defer upstream.Close()
+
+			proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+				cfg.Transport = proxy.TransportConfig{MaxIdleConnsPerHost: 4, DisableKeepAlives: disable}
+			})
+			defer cleanup()
+			client := _proxyClient(proxyURL)
+
+			for range 3 {
+				resp, err := client.Get(upstream.URL)
+				require.NoError(t, err)
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+
+			if disable {
+				assert.Equal(t, int32(3), conns.Load(), "one upstream connection per request")
+			} else {
+				assert.Equal(t, int32(1), conns.Load(), "idle upstream connection reused")
+			}
+		})
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	var fetches sync.Map // path -> *atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := fetches.LoadOrStore(r.URL.Path, &atomic.Int32{})
+		count := n.(*atomic.Int32).Add(1) //nolint:errcheck // type is guaranteed by LoadOrStore
+		switch r.URL.Path {
+		case "/static.js":
+			w.Header().Set("Cache-Control", "public, max-age=300")
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=300")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=300")
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+		_, _ = fmt.Fprintf(w, "%s #%d", r.URL.Path, count)
+	}))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.CacheMaxBytes = 1 << 20
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+
+	get := func(path string, hdr ...string) (string, http.Header) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, upstream.URL+path, nil)
+		require.NoError(t, err)
+		for i := 0; i < len(hdr); i += 2 {
+			req.Header.Set(hdr[i], hdr[i+1])
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return string(body), resp.Header
+	}
+
+	body, _ := get("/static.js")
+	assert.Equal(t, "/static.js #1", body)
+	body, h := get("/static.js")
+	assert.Equal(t, "/static.js #1", body, "served from cache")
+	assert.Equal(t, "public, max-age=300", h.Get("Cache-Control"))
+	assert.NotEmpty(t, h.Get("Age"))
+
+	// A client reload bypasses the cache and refreshes the entry.
+	body, _ = get("/static.js", "Cache-Control", "no-cache")
+	assert.Equal(t, "/static.js #2", body)
+	body, _ = get("/static.js")
+	assert.Equal(t, "/static.js #2", body)
+
+	for _, path := range []string{"/private", "/no-store", "/vary", "/no-lifetime"} {
+		get(path)
+		body, _ = get(path)
+		assert.Equal(t, path+" #2", body, "%s is not cached", path)
+	}
+
+	// Only GET is cached.
+	resp, err := client.Post(upstream.URL+"/static.js", "text/plain", strings.NewReader("x"))
+	require.NoError(t, err)
+	body2, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "/static.js #3", string(body2))
+
+	resp, err = http.Get(proxyURL + "/fps/stats")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var stats struct {
+		Cache probe.CacheBlock `json:"cache"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	// Hits: the second and fourth /static.js. Misses: the first, the
+	// reload, and both requests for each uncached path.
+	assert.Equal(t, probe.CacheBlock{Hits: 2, Misses: 10}, stats.Cache)
+}
+
+// _retryCounter is a log sink counting "upstream retry" lines. first is
+// closed when the first one is logged.
+type _retryCounter struct {
+	n     atomic.Int32
+	first chan struct{}
+}
+
+func (c *_retryCounter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), `msg="upstream retry"`) && c.n.Add(1) == 1 {
+		close(c.first)
+	}
+	return len(p), nil
+}
+
+func TestUpstreamRetries(t *testing.T) {
+	// An address with nothing listening yet: dials are refused.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	retries := &_retryCounter{first: make(chan struct{})}
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Logger = slog.New(slog.NewTextHandler(retries, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		cfg.UpstreamRetries = 3
+		cfg.UpstreamRetryBackoff = 300 * time.Millisecond
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+
+	t.Run("POST is not retried", func(t *testing.T) {
+		resp, err := client.Post("http://"+target+"/", "text/plain", strings.NewReader("x"))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Zero(t, retries.n.Load())
+	})
+
+	t.Run("GET succeeds once the upstream is up", func(t *testing.T) {
+		// Bring the upstream up during the first backoff.
+		go func() {
+			<-retries.first
+			l, listenErr := net.Listen("tcp", target)
+			if listenErr != nil {
+				return
+			}
+			srv := &http.Server{ReadHeaderTimeout: time.Second, Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = fmt.Fprint(w, "up")
+			})}
+			t.Cleanup(func() { _ = srv.Close() })
+			_ = srv.Serve(l)
+		}()
+
+		resp, err := client.Get("http://" + target + "/")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "up", string(body))
+		assert.Equal(t, int32(1), retries.n.Load())
+	})
+}
+
+func TestUpstreamRetriesExhausted(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	retries := &_retryCounter{first: make(chan struct{})}
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Logger = slog.New(slog.NewTextHandler(retries, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		cfg.UpstreamRetries = 2
+		cfg.UpstreamRetryBackoff = 10 * time.Millisecond
+	})
+	defer cleanup()
+
+	resp, err := _proxyClient(proxyURL).Get("http://" + target + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(2), retries.n.Load())
+}
+
+func TestDebugTraceHeader(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = &_mockBlocker{blocked: map[string]bool{"ads.example.com": true}}
+		cfg.Tracer = headers.NewTracer([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")})
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "path=http; blocked=0", resp.Header.Get(headers.TraceHeader))
+
+	resp, err = client.Get("http://ads.example.com/banner.js")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "path=http; blocked=1", resp.Header.Get(headers.TraceHeader))
+}
+
+// _reasonBlocker is a _mockBlocker that names the blocking rule.
+type _reasonBlocker struct{ _mockBlocker }
+
+func (*_reasonBlocker) BlockReason(string) string { return "suffix" }
+
+func TestDebugTraceHeaderAllResponses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=300")
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tracer := headers.NewTracer([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")})
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Blocker = &_reasonBlocker{_mockBlocker{blocked: map[string]bool{"ads.example.com": true}}}
+		cfg.Tracer = tracer
+		cfg.CacheMaxBytes = 1 << 20
+	})
+	defer cleanup()
+	client := _proxyClient(proxyURL)
+	trace := func(url string, status int) string {
+		t.Helper()
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode)
+		return resp.Header.Get(headers.TraceHeader)
+	}
+
+	assert.Equal(t, "path=http; blocked=1; reason=suffix", trace("http://ads.example.com/banner.js", http.StatusForbidden))
+	assert.Equal(t, "path=http; blocked=0", trace(upstream.URL, http.StatusOK))
+	assert.Equal(t, "path=http; blocked=0; cache=hit", trace(upstream.URL, http.StatusOK))
+	assert.Equal(t, "path=http; blocked=0", trace(closed.URL, http.StatusBadGateway))
+
+	proxyURL, cleanup2 := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.BlockedCIDRs = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+		cfg.Tracer = tracer
+	})
+	defer cleanup2()
+	client = _proxyClient(proxyURL)
+	assert.Equal(t, "path=http; blocked=1; reason=cidr:127.0.0.0/8", trace(upstream.URL, http.StatusForbidden))
+}
+
+func TestDebugTraceHeaderOtherClients(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	proxyURL, cleanup := _startTestProxyWith(t, func(cfg *proxy.Config) {
+		cfg.Tracer = headers.NewTracer([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	})
+	defer cleanup()
+
+	resp, err := _proxyClient(proxyURL).Get(upstream.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, resp.Header.Get(headers.TraceHeader))
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitMaxClients bounds the number of client buckets tracked.
+	rateLimitMaxClients = 4096
+	// rateLimitSweepInterval is how often idle buckets are dropped.
+	rateLimitSweepInterval = time.Minute
+)
+
+// rateLimiter is a per-client token bucket: each client IP may make burst
+// requests at once, refilled at rate per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter allowing rate requests per second per
+// client with the given burst. A burst below 1 uses max(1, rate). Returns
+// nil (no limiting) when rate is not positive.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b < 1 {
+		b = max(1, rate)
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     b,
+		now:       time.Now,
+		clients:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from clientIP's bucket, reporting false if it is
+// empty. The wait until the next token is returned for Retry-After.
+func (l *rateLimiter) allow(clientIP string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval || len(l.clients) >= rateLimitMaxClients {
+		l.sweepLocked(now)
+	}
+
+	b, ok := l.clients[clientIP]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[clientIP] = b
+	} else {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweepLocked drops buckets that have refilled completely; they are
+// indistinguishable from a new client's. If the map is still full, the
+// least recently seen bucket is dropped to make room.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	l.lastSweep = now
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	var oldestIP string
+	var oldest time.Time
+	for ip, b := range l.clients {
+		if now.Sub(b.last) >= refill {
+			delete(l.clients, ip)
+			continue
+		}
+		if oldestIP == "" || b.last.Before(oldest) {
+			oldestIP, oldest = ip, b.last
+		}
+	}
+	if len(l.clients) >= rateLimitMaxClients {
+		delete(l.clients, oldestIP)
+	}
+}
+
+// rejectRateLimited answers a client over its rate limit with 429.
+func (s *Server) rejectRateLimited(w http.ResponseWriter, r *http.Request, clientIP string, wait time.Duration) {
+	s.connectionsRateLimited.Add(1)
+	secs := int(wait.Seconds())
+	if wait > time.Duration(secs)*time.Second {
+		secs++
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, secs)))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+	s.logger.Debug("request rate limited",
+		"method", r.Method,
+		"host", r.Host,
+		"client", clientIP,
+	)
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for range 3 {
+		ok, _ := l.allow("10.0.0.1")
+		assert.True(t, ok)
+	}
+	ok, wait := l.allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Other clients have their own bucket.
+	ok, _ = l.allow("10.0.0.2")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.allow("10.0.0.1")
+	assert.True(t, ok)
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(1, 5)
+	l.now = func() time.Time { return now }
+
+	// Filling the map forces a sweep; the least recently seen client makes
+	// room when none has refilled yet.
+	for i := range rateLimitMaxClients + 10 {
+		now = now.Add(time.Millisecond)
+		l.allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	assert.LessOrEqual(t, len(l.clients), rateLimitMaxClients)
+	assert.NotContains(t, l.clients, "10.0.0.0")
+
+	// Once idle long enough to refill, buckets are dropped on the next
+	// periodic sweep.
+	now = now.Add(rateLimitSweepInterval)
+	l.allow("192.0.2.1")
+	assert.Len(t, l.clients, 1)
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0, 10))
+	assert.Equal(t, float64(1), newRateLimiter(0.5, 0).burst)
+	assert.Equal(t, float64(20), newRateLimiter(20, 0).burst)
+}
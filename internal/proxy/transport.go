@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// TransportConfig tunes the upstream HTTP transport used for plain HTTP
+// forwarding. Zero fields keep the http.DefaultTransport values.
+type TransportConfig struct {
+	// MaxIdleConns caps idle upstream connections across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost caps idle upstream connections per host (Go's
+	// default is 2).
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes idle upstream connections after this long.
+	IdleConnTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake with an https upstream
+	// (absolute-form https:// requests).
+	TLSHandshakeTimeout time.Duration
+	// DisableKeepAlives uses a new upstream connection per request.
+	DisableKeepAlives bool
+}
+
+// newTransport builds the server's own upstream transport from a clone of
+// http.DefaultTransport, so tuning it never affects other users of the
+// default.
+func newTransport(tc TransportConfig) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck,forcetypeassert // DefaultTransport is always *http.Transport
+	if tc.MaxIdleConns > 0 {
+		t.MaxIdleConns = tc.MaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+	if tc.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = tc.IdleConnTimeout
+	}
+	if tc.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = tc.TLSHandshakeTimeout
+	}
+	t.DisableKeepAlives = tc.DisableKeepAlives
+	return t
+}
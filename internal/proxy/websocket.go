@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/session"
+)
+
+// handleWebSocket relays a plain HTTP (ws://) WebSocket handshake and the
+// connection after it. The hop-by-hop Upgrade and Connection headers that
+// a normal round trip strips are what the handshake needs, so the request
+// is written to the upstream by hand and the two connections are then
+// spliced like a CONNECT tunnel. The upstream's 101 response and frames
+// are relayed as-is, so only refusals and errors carry trace (nil for none).
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request, domain, clientIP string, trace *headers.Trace) {
+	start := time.Now()
+
+	addr := r.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+
+	dialCtx, cancelDial := context.WithTimeout(r.Context(), s.connectTimeout)
+	destConn, err := s.dialUpstream(dialCtx, "tcp", addr)
+	cancelDial()
+	if ipErr := asIPBlocked(err); ipErr != nil {
+		s.ipBlocked(w, r.Method, r.URL.Host, r.RemoteAddr, clientIP, domain, trace, ipErr)
+		return
+	}
+	if trace != nil {
+		w.Header().Set(headers.TraceHeader, trace.String())
+	}
+	if err != nil && s.fallback != nil {
+		primaryErr := err
+		destConn, err = s.fallback.DialTimeout("tcp", addr, s.connectTimeout)
+		s.logFallback(r.Method, addr, primaryErr, err)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("proxy error: %v", err), http.StatusBadGateway)
+		s.logger.Error("websocket dial failed",
+			"url", r.URL.String(),
+			"error", err,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+		return
+	}
+
+	// Strip as for any forwarded request, then restore the upgrade.
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	upgrade := r.Header.Get("Upgrade")
+	s.headers.StripRequest(outReq.Header, domain)
+	outReq.Header.Set("Connection", "Upgrade")
+	outReq.Header.Set("Upgrade", upgrade)
+	if err := outReq.Write(destConn); err != nil {
+		_ = destConn.Close()
+		http.Error(w, fmt.Sprintf("proxy error: %v", err), http.StatusBadGateway)
+		s.logger.Error("websocket handshake failed",
+			"url", r.URL.String(),
+			"error", err,
+		)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		_ = destConn.Close()
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("hijack error: %v", err), http.StatusInternalServerError)
+		_ = destConn.Close()
+		return
+	}
+
+	if s.onRequest != nil {
+		s.onRequest(clientIP, domain, false, 0, 0)
+	}
+
+	s.logger.Info("websocket",
+		"url", r.URL.String(),
+		"remote", r.RemoteAddr,
+	)
+
+	// Frames the client sent right behind the handshake may already sit in
+	// clientBuf, so the upload side reads through it.
+	sess := s.trackTunnel(clientConn, session.KindWebSocket, clientIP, domain)
+	sess.ReportLive(s.onTunnelBytes)
+	var uploadBytes, downloadBytes atomic.Int64
+	go func() {
+		defer func() { _ = destConn.Close() }()
+		defer func() { _ = clientConn.Close() }()
+		n, _ := io.Copy(sess.CountUpload(destConn), clientBuf) //nolint:errcheck // tunnel streaming
+		uploadBytes.Store(n)
+	}()
+	go func() {
+		defer s.untrackTunnel(sess)
+		defer func() { _ = destConn.Close() }()
+		defer func() { _ = clientConn.Close() }()
+		n, _ := io.Copy(sess.CountDownload(clientConn), destConn) //nolint:errcheck // tunnel streaming
+		downloadBytes.Store(n)
+
+		up := uploadBytes.Load()
+		down := downloadBytes.Load()
+		if s.onTunnelClose != nil {
+			s.onTunnelClose(clientIP, up, down)
+		}
+
+		s.logger.Debug("websocket closed",
+			"url", r.URL.String(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"upload_bytes", up,
+			"download_bytes", down,
+		)
+	}()
+}
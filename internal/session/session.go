@@ -0,0 +1,205 @@
+/*
+Package session tracks open CONNECT tunnels and MITM sessions.
+
+A Registry holds the sessions of one listener. Each Session records the
+client, domain, start time, and live byte counts, and keeps the client
+connection so the listener can force-close it during shutdown.
+*/
+package session
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session kinds.
+const (
+	KindTunnel    = "tunnel"     // opaque byte relay
+	KindMITM      = "mitm"       // intercepted TLS session
+	KindBlockPage = "block-page" // blocked CONNECT answered with a block page
+	KindWebSocket = "websocket"  // plain HTTP WebSocket after its handshake
+)
+
+// Session is one open tunnel or MITM session.
+type Session struct {
+	Kind     string
+	ClientIP string
+	Domain   string
+	Started  time.Time
+
+	conn     net.Conn
+	upload   atomic.Int64 // client to upstream
+	download atomic.Int64 // upstream to client
+	live     func(clientIP string, n int64)
+}
+
+// ReportLive passes every byte count added after this call to fn (if not
+// nil), so rates can follow a long tunnel before it closes. Call it before
+// relaying starts.
+func (s *Session) ReportLive(fn func(clientIP string, n int64)) {
+	s.live = fn
+}
+
+func (s *Session) add(counter *atomic.Int64, n int) {
+	counter.Add(int64(n))
+	if s.live != nil && n > 0 {
+		s.live(s.ClientIP, int64(n))
+	}
+}
+
+// Bytes returns the bytes relayed so far in each direction.
+func (s *Session) Bytes() (upload, download int64) {
+	return s.upload.Load(), s.download.Load()
+}
+
+// Conn wraps c so bytes read from it count as upload and bytes written to
+// it as download. Use it for a client connection handed to code that does
+// its own relaying.
+func (s *Session) Conn(c net.Conn) net.Conn {
+	return &countingConn{Conn: c, s: s}
+}
+
+// CountUpload wraps w so bytes written to it count as upload.
+func (s *Session) CountUpload(w io.Writer) io.Writer {
+	return &countingWriter{w: w, s: s, n: &s.upload}
+}
+
+// CountDownload wraps w so bytes written to it count as download.
+func (s *Session) CountDownload(w io.Writer) io.Writer {
+	return &countingWriter{w: w, s: s, n: &s.download}
+}
+
+type countingConn struct {
+	net.Conn
+	s *Session
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.s.add(&c.s.upload, n)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.s.add(&c.s.download, n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	s *Session
+	n *atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.w.Write(b)
+	w.s.add(w.n, n)
+	return n, err
+}
+
+// Info is a point-in-time view of a session.
+type Info struct {
+	Listener      string    `json:"listener"`
+	Kind          string    `json:"kind"`
+	ClientIP      string    `json:"client_ip"`
+	Domain        string    `json:"domain"`
+	Started       time.Time `json:"started"`
+	DurationMS    int64     `json:"duration_ms"`
+	UploadBytes   int64     `json:"upload_bytes"`
+	DownloadBytes int64     `json:"download_bytes"`
+}
+
+// Registry tracks the open sessions of one listener. It is safe for
+// concurrent use.
+type Registry struct {
+	listener string
+
+	mu       sync.Mutex
+	sessions map[*Session]struct{}
+}
+
+// NewRegistry creates an empty registry. listener names the owning
+// listener ("proxy", "transparent") in Info.
+func NewRegistry(listener string) *Registry {
+	return &Registry{
+		listener: listener,
+		sessions: make(map[*Session]struct{}),
+	}
+}
+
+// Open registers a session for the client connection conn.
+func (r *Registry) Open(conn net.Conn, kind, clientIP, domain string) *Session {
+	s := &Session{
+		Kind:     kind,
+		ClientIP: clientIP,
+		Domain:   domain,
+		Started:  time.Now(),
+		conn:     conn,
+	}
+	r.mu.Lock()
+	r.sessions[s] = struct{}{}
+	r.mu.Unlock()
+	return s
+}
+
+// Close removes a session registered with Open. It does not close the
+// connection.
+func (r *Registry) Close(s *Session) {
+	r.mu.Lock()
+	delete(r.sessions, s)
+	r.mu.Unlock()
+}
+
+// Len returns the number of open sessions.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// CloseAll closes the client connection of every open session and returns
+// how many there were. Sessions stay registered until their owners call
+// Close.
+func (r *Registry) CloseAll() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for s := range r.sessions {
+		_ = s.conn.Close()
+	}
+	return len(r.sessions)
+}
+
+// List returns the open sessions, oldest first.
+func (r *Registry) List() []Info {
+	now := time.Now()
+	r.mu.Lock()
+	out := make([]Info, 0, len(r.sessions))
+	for s := range r.sessions {
+		up, down := s.Bytes()
+		out = append(out, Info{
+			Listener:      r.listener,
+			Kind:          s.Kind,
+			ClientIP:      s.ClientIP,
+			Domain:        s.Domain,
+			Started:       s.Started,
+			DurationMS:    now.Sub(s.Started).Milliseconds(),
+			UploadBytes:   up,
+			DownloadBytes: down,
+		})
+	}
+	r.mu.Unlock()
+	SortByStart(out)
+	return out
+}
+
+// SortByStart sorts sessions oldest first.
+func SortByStart(infos []Info) {
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+}
@@ -0,0 +1,75 @@
+package session_test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/session"
+)
+
+func TestRegistry(t *testing.T) {
+	r := session.NewRegistry("proxy")
+	client, peer := net.Pipe()
+	defer peer.Close()
+
+	first := r.Open(client, session.KindMITM, "10.0.0.1", "www.example.com")
+	time.Sleep(time.Millisecond)
+	second := r.Open(client, session.KindTunnel, "10.0.0.2", "cdn.example.com")
+	assert.Equal(t, 2, r.Len())
+
+	// Bytes read from the client are uploads, bytes written to it downloads.
+	conn := first.Conn(client)
+	go func() {
+		_, _ = peer.Write([]byte("ping"))
+		_, _ = io.ReadFull(peer, make([]byte, 6))
+	}()
+	_, err := io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("pong!!"))
+	require.NoError(t, err)
+	_, err = second.CountDownload(io.Discard).Write([]byte("abc"))
+	require.NoError(t, err)
+
+	infos := r.List()
+	require.Len(t, infos, 2)
+	assert.Equal(t, "www.example.com", infos[0].Domain, "oldest first")
+	assert.Equal(t, "proxy", infos[0].Listener)
+	assert.Equal(t, int64(4), infos[0].UploadBytes)
+	assert.Equal(t, int64(6), infos[0].DownloadBytes)
+	assert.Equal(t, int64(3), infos[1].DownloadBytes)
+
+	r.Close(first)
+	assert.Equal(t, 1, r.Len())
+
+	// CloseAll closes connections but leaves removal to the owners.
+	assert.Equal(t, 1, r.CloseAll())
+	_, err = client.Write([]byte("x"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.Equal(t, 1, r.Len())
+}
+
+func TestSessionReportLive(t *testing.T) {
+	r := session.NewRegistry("proxy")
+	client, peer := net.Pipe()
+	defer peer.Close()
+	s := r.Open(client, session.KindTunnel, "10.0.0.1", "cdn.example.com")
+
+	var live int64
+	s.ReportLive(func(clientIP string, n int64) {
+		assert.Equal(t, "10.0.0.1", clientIP)
+		live += n
+	})
+	_, err := s.CountUpload(io.Discard).Write([]byte("abc"))
+	require.NoError(t, err)
+	_, err = s.CountDownload(io.Discard).Write([]byte("defgh"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), live, "bytes are reported as they are counted")
+
+	up, down := s.Bytes()
+	assert.Equal(t, int64(3), up)
+	assert.Equal(t, int64(5), down)
+}
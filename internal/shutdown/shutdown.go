@@ -0,0 +1,67 @@
+/*
+Package shutdown runs an ordered sequence of graceful-shutdown steps, each
+with its own deadline.
+
+Steps run strictly one after another in slice order. A step that fails or
+times out is logged and the sequence continues, so a slow subsystem cannot
+prevent later subsystems from being stopped.
+*/
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Step is a single phase of the shutdown sequence.
+type Step struct {
+	// Name identifies the step in logs and errors (e.g., "proxy").
+	Name string
+	// Timeout bounds how long Fn may run. Zero or negative means no deadline.
+	Timeout time.Duration
+	// Fn stops the subsystem. It should return promptly once ctx is done.
+	Fn func(ctx context.Context) error
+}
+
+// Run executes steps in order, giving each its own context deadline.
+// Returns the joined errors of all failed steps (nil if all succeeded).
+func Run(steps []Step, logger *slog.Logger) error {
+	var errs []error
+
+	for _, step := range steps {
+		ctx, cancel := stepContext(step.Timeout)
+		start := time.Now()
+		err := step.Fn(ctx)
+		cancel()
+
+		if err != nil {
+			logger.Warn("shutdown step failed",
+				"step", step.Name,
+				"timeout", step.Timeout,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"error", err,
+			)
+			errs = append(errs, fmt.Errorf("%s: %w", step.Name, err))
+			continue
+		}
+
+		logger.Debug("shutdown step complete",
+			"step", step.Name,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+
+	return errors.Join(errs...)
+}
+
+// stepContext returns a context bounded by d, or an unbounded cancelable
+// context when d is not positive.
+func stepContext(d time.Duration) (context.Context, context.CancelFunc) {
+	if d > 0 {
+		return context.WithTimeout(context.Background(), d)
+	}
+	return context.WithCancel(context.Background())
+}
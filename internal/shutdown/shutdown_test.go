@@ -0,0 +1,86 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestRun_Order(t *testing.T) {
+	var order []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	err := Run([]Step{
+		{Name: "transparent", Timeout: time.Second, Fn: record("transparent")},
+		{Name: "proxy", Timeout: time.Second, Fn: record("proxy")},
+		{Name: "tunnels", Timeout: time.Second, Fn: record("tunnels")},
+	}, testLogger())
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"transparent", "proxy", "tunnels"}, order)
+}
+
+func TestRun_PerStepTimeout(t *testing.T) {
+	var deadlines []time.Duration
+	capture := func(ctx context.Context) error {
+		dl, ok := ctx.Deadline()
+		require.True(t, ok)
+		deadlines = append(deadlines, time.Until(dl).Round(time.Second))
+		return nil
+	}
+
+	err := Run([]Step{
+		{Name: "fast", Timeout: 1 * time.Second, Fn: capture},
+		{Name: "slow", Timeout: 30 * time.Second, Fn: capture},
+	}, testLogger())
+
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{1 * time.Second, 30 * time.Second}, deadlines)
+}
+
+func TestRun_ContinuesAfterFailure(t *testing.T) {
+	var ran []string
+
+	err := Run([]Step{
+		{Name: "first", Timeout: 10 * time.Millisecond, Fn: func(ctx context.Context) error {
+			ran = append(ran, "first")
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{Name: "second", Timeout: time.Second, Fn: func(context.Context) error {
+			ran = append(ran, "second")
+			return nil
+		}},
+	}, testLogger())
+
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "first:")
+	assert.Equal(t, []string{"first", "second"}, ran)
+}
+
+func TestRun_NoTimeout(t *testing.T) {
+	err := Run([]Step{
+		{Name: "unbounded", Fn: func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			assert.False(t, ok)
+			return nil
+		}},
+	}, testLogger())
+	assert.NoError(t, err)
+}
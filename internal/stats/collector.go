@@ -21,12 +21,66 @@ type clientStats struct {
 	Blocked  atomic.Int64
 	BytesIn  atomic.Int64
 	BytesOut atomic.Int64
+	// BytesSaved estimates the response bytes blocked requests would have
+	// cost (see estimateResponseSize).
+	BytesSaved atomic.Int64
+	// LastActive is when the client last made a request or closed a
+	// tunnel (Unix nanoseconds), for idle eviction.
+	LastActive atomic.Int64
+}
+
+// snapshot returns the counters of the client at ip.
+func (cs *clientStats) snapshot(ip, zone string) ClientSnapshot {
+	return ClientSnapshot{
+		IP:         ip,
+		Requests:   cs.Requests.Load(),
+		Blocked:    cs.Blocked.Load(),
+		BytesIn:    cs.BytesIn.Load(),
+		BytesOut:   cs.BytesOut.Load(),
+		BytesSaved: cs.BytesSaved.Load(),
+		Zone:       zone,
+	}
+}
+
+// sizeStats accumulates response sizes for averaging.
+type sizeStats struct {
+	count atomic.Int64
+	bytes atomic.Int64
+}
+
+func (s *sizeStats) add(n int64) {
+	s.count.Add(1)
+	s.bytes.Add(n)
+}
+
+// average returns the mean size, or 0 with nothing recorded.
+func (s *sizeStats) average() int64 {
+	if n := s.count.Load(); n > 0 {
+		return s.bytes.Load() / n
+	}
+	return 0
+}
+
+// liveIdle is how long a client with no open connections and no traffic
+// stays in the live set before it is evicted.
+const liveIdle = time.Minute
+
+// liveClient holds current-activity gauges for a recently-active client.
+// Fields are guarded by Collector.liveMu.
+type liveClient struct {
+	active   int64     // open connections
+	bytes    int64     // bytes in+out since the last sampler tick
+	bps      int64     // bytes/sec over the last sampler tick
+	lastSeen time.Time // last connection open/close or recorded bytes
 }
 
 // Collector accumulates in-memory traffic statistics.
 type Collector struct {
 	// Per-client-IP stats.
 	clients sync.Map // string -> *clientStats
+	// evicted holds the totals of clients removed by evictIdleClients, so
+	// aggregate totals never go backwards.
+	evicted clientStats
 
 	// Per-domain total request counts (all traffic, not just blocked).
 	domainRequests sync.Map // string -> *atomic.Int64
@@ -34,6 +88,11 @@ type Collector struct {
 	// Per-domain block counts.
 	domainBlocks sync.Map // string -> *atomic.Int64
 
+	// Sizes of allowed responses with a known length, per domain and
+	// overall, used to estimate bytes saved by blocks.
+	domainRespSizes sync.Map // string -> *sizeStats
+	respSizes       sizeStats
+
 	// Per-domain MITM intercept counts.
 	mitmIntercepts sync.Map // string -> *atomic.Int64
 
@@ -42,6 +101,8 @@ type Collector struct {
 	pluginMatched   sync.Map // string -> *atomic.Int64
 	pluginModified  sync.Map // string -> *atomic.Int64
 	pluginRules     sync.Map // "plugin:rule" -> *atomic.Int64
+	pluginNanos     sync.Map // string -> *atomic.Int64 (cumulative Filter time)
+	pluginPanics    sync.Map // string -> *atomic.Int64 (recovered Filter panics)
 
 	// Transparent proxy counters.
 	TransparentHTTP  atomic.Int64
@@ -50,10 +111,23 @@ type Collector struct {
 	TransparentBlock atomic.Int64
 	SNIMissing       atomic.Int64
 
+	// Proxy response cache lookups.
+	CacheHits   atomic.Int64
+	CacheMisses atomic.Int64
+
+	// Recently-active clients (current connections and byte rate). Entries
+	// idle for liveIdle are evicted by the sampler, bounding memory.
+	liveMu sync.Mutex
+	live   map[string]*liveClient
+
 	// Peak throughput watermarks (updated by sampler goroutine).
 	peakReqPerSec  atomic.Int64 // millireqs/sec (x1000 for int64 precision)
 	peakBytesInSec atomic.Int64 // bytes/sec
 
+	// zones attributes clients to network zones; nil when none are
+	// configured.
+	zones *Zones
+
 	// Sampler lifecycle.
 	samplerStop chan struct{}
 	samplerDone chan struct{}
@@ -64,6 +138,58 @@ func NewCollector() *Collector {
 	return &Collector{}
 }
 
+// SetZones sets the client zone mapping reported in client snapshots. Call
+// before recording starts.
+func (c *Collector) SetZones(z *Zones) {
+	c.zones = z
+}
+
+// Zone returns the network zone of clientIP ("" with no zones configured).
+func (c *Collector) Zone(clientIP string) string {
+	return c.zones.Zone(clientIP)
+}
+
+// Reset zeroes every counter and peak watermark. Live connection gauges
+// are kept: they describe connections that are still open.
+func (c *Collector) Reset() {
+	c.clients.Clear()
+	c.evicted.Requests.Store(0)
+	c.evicted.Blocked.Store(0)
+	c.evicted.BytesIn.Store(0)
+	c.evicted.BytesOut.Store(0)
+	c.evicted.BytesSaved.Store(0)
+	c.domainRequests.Clear()
+	c.domainBlocks.Clear()
+	c.domainRespSizes.Clear()
+	c.respSizes.count.Store(0)
+	c.respSizes.bytes.Store(0)
+	c.mitmIntercepts.Clear()
+	c.pluginInspected.Clear()
+	c.pluginMatched.Clear()
+	c.pluginModified.Clear()
+	c.pluginRules.Clear()
+	c.pluginNanos.Clear()
+	c.pluginPanics.Clear()
+	c.TransparentHTTP.Store(0)
+	c.TransparentTLS.Store(0)
+	c.TransparentMITM.Store(0)
+	c.TransparentBlock.Store(0)
+	c.SNIMissing.Store(0)
+	c.CacheHits.Store(0)
+	c.CacheMisses.Store(0)
+	c.peakReqPerSec.Store(0)
+	c.peakBytesInSec.Store(0)
+}
+
+// RecordCacheLookup counts a proxy response cache hit or miss.
+func (c *Collector) RecordCacheLookup(hit bool) {
+	if hit {
+		c.CacheHits.Add(1)
+	} else {
+		c.CacheMisses.Add(1)
+	}
+}
+
 // RecordRequest records a request from a client to a domain.
 func (c *Collector) RecordRequest(clientIP, domain string, blocked bool, bytesIn, bytesOut int64) {
 	// Per-client stats.
@@ -72,9 +198,16 @@ func (c *Collector) RecordRequest(clientIP, domain string, blocked bool, bytesIn
 	cs.Requests.Add(1)
 	cs.BytesIn.Add(bytesIn)
 	cs.BytesOut.Add(bytesOut)
+	cs.LastActive.Store(time.Now().UnixNano())
 	if blocked {
 		cs.Blocked.Add(1)
+		cs.BytesSaved.Add(c.estimateResponseSize(domain))
+	} else if bytesOut > 0 {
+		sv, _ := c.domainRespSizes.LoadOrStore(domain, &sizeStats{})
+		sv.(*sizeStats).add(bytesOut) //nolint:errcheck // type is guaranteed by LoadOrStore
+		c.respSizes.add(bytesOut)
 	}
+	c.addLiveBytes(clientIP, bytesIn+bytesOut)
 
 	// Per-domain request count.
 	dv, _ := c.domainRequests.LoadOrStore(domain, &atomic.Int64{})
@@ -87,13 +220,151 @@ func (c *Collector) RecordRequest(clientIP, domain string, blocked bool, bytesIn
 	}
 }
 
+// estimateResponseSize estimates the response a blocked request for domain
+// would have received: the average of the domain's allowed responses when
+// any were seen (before it was blocked, or while allowed temporarily),
+// otherwise the average across all domains. Tunnelled responses have no
+// per-request size and are not counted, so both averages come from plain
+// HTTP and MITM traffic.
+func (c *Collector) estimateResponseSize(domain string) int64 {
+	if sv, ok := c.domainRespSizes.Load(domain); ok {
+		if avg := sv.(*sizeStats).average(); avg > 0 { //nolint:errcheck // type is guaranteed by LoadOrStore
+			return avg
+		}
+	}
+	return c.respSizes.average()
+}
+
 // RecordBytes adds byte counts to an existing client entry (for CONNECT tunnels
-// where final byte counts are known after the tunnel closes).
+// where final byte counts are known after the tunnel closes). The bytes do
+// not count toward live rates; RecordLiveBytes feeds those as they flow.
 func (c *Collector) RecordBytes(clientIP string, bytesIn, bytesOut int64) {
 	val, _ := c.clients.LoadOrStore(clientIP, &clientStats{})
 	cs, _ := val.(*clientStats) //nolint:errcheck // type is guaranteed by LoadOrStore
 	cs.BytesIn.Add(bytesIn)
 	cs.BytesOut.Add(bytesOut)
+	cs.LastActive.Store(time.Now().UnixNano())
+}
+
+// RecordLiveBytes counts n bytes relayed by an open tunnel toward the
+// client's current byte rate.
+func (c *Collector) RecordLiveBytes(clientIP string, n int64) {
+	c.addLiveBytes(clientIP, n)
+}
+
+// evictIdleClients removes clients with no activity since cutoff and no
+// open connections, returning their final counters. Their totals move to
+// c.evicted so TotalRequests and friends are unaffected. A client that
+// shows up again starts a fresh entry.
+func (c *Collector) evictIdleClients(cutoff time.Time) []ClientSnapshot {
+	var out []ClientSnapshot
+	c.clients.Range(func(key, value any) bool {
+		cs, _ := value.(*clientStats) //nolint:errcheck // type is guaranteed
+		ip, _ := key.(string)         //nolint:errcheck // type is guaranteed
+		if cs.LastActive.Load() >= cutoff.UnixNano() || c.hasOpenConns(ip) {
+			return true
+		}
+		if !c.clients.CompareAndDelete(key, value) {
+			return true
+		}
+		final := cs.snapshot(ip, c.zones.Zone(ip))
+		c.evicted.Requests.Add(final.Requests)
+		c.evicted.Blocked.Add(final.Blocked)
+		c.evicted.BytesIn.Add(final.BytesIn)
+		c.evicted.BytesOut.Add(final.BytesOut)
+		c.evicted.BytesSaved.Add(final.BytesSaved)
+		out = append(out, final)
+		return true
+	})
+	return out
+}
+
+// hasOpenConns reports whether clientIP has an open connection or tunnel.
+func (c *Collector) hasOpenConns(clientIP string) bool {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	lc, ok := c.live[clientIP]
+	return ok && lc.active > 0
+}
+
+// ConnOpened records a connection opened by a client.
+func (c *Collector) ConnOpened(clientIP string) {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	lc := c.liveClientLocked(clientIP)
+	lc.active++
+	lc.lastSeen = time.Now()
+}
+
+// ConnClosed records a connection closed by a client.
+func (c *Collector) ConnClosed(clientIP string) {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	lc := c.liveClientLocked(clientIP)
+	if lc.active > 0 {
+		lc.active--
+	}
+	lc.lastSeen = time.Now()
+}
+
+// addLiveBytes counts bytes toward a client's current byte rate.
+func (c *Collector) addLiveBytes(clientIP string, n int64) {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	lc := c.liveClientLocked(clientIP)
+	lc.bytes += n
+	lc.lastSeen = time.Now()
+}
+
+// liveClientLocked returns the live entry for clientIP, creating it if
+// needed. Caller must hold c.liveMu.
+func (c *Collector) liveClientLocked(clientIP string) *liveClient {
+	if c.live == nil {
+		c.live = make(map[string]*liveClient)
+	}
+	lc, ok := c.live[clientIP]
+	if !ok {
+		lc = &liveClient{}
+		c.live[clientIP] = lc
+	}
+	return lc
+}
+
+// sampleLive turns the bytes counted since the last tick into per-client
+// rates over dt seconds and evicts clients idle for liveIdle.
+func (c *Collector) sampleLive(now time.Time, dt float64) {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	for ip, lc := range c.live {
+		lc.bps = 0
+		if dt > 0 {
+			lc.bps = int64(float64(lc.bytes) / dt)
+		}
+		lc.bytes = 0
+		if lc.active == 0 && lc.bps == 0 && now.Sub(lc.lastSeen) > liveIdle {
+			delete(c.live, ip)
+		}
+	}
+}
+
+// LiveClientSnapshot captures a recently-active client's current gauges.
+type LiveClientSnapshot struct {
+	IP     string
+	Active int64 // open connections
+	BPS    int64 // bytes/sec (in+out) over the last sampler tick
+}
+
+// SnapshotLiveClients returns the recently-active clients. Byte rates are
+// updated by the sampler; CONNECT tunnel bytes count as they are relayed
+// (see RecordLiveBytes).
+func (c *Collector) SnapshotLiveClients() []LiveClientSnapshot {
+	c.liveMu.Lock()
+	defer c.liveMu.Unlock()
+	out := make([]LiveClientSnapshot, 0, len(c.live))
+	for ip, lc := range c.live {
+		out = append(out, LiveClientSnapshot{IP: ip, Active: lc.active, BPS: lc.bps})
+	}
+	return out
 }
 
 // RecordMITMRequest records an HTTP request-response cycle through a MITM session.
@@ -128,11 +399,13 @@ func (c *Collector) TotalMITMIntercepts() int64 {
 
 // ClientSnapshot captures a point-in-time view of per-client counters.
 type ClientSnapshot struct {
-	IP       string
-	Requests int64
-	Blocked  int64
-	BytesIn  int64
-	BytesOut int64
+	IP         string
+	Requests   int64
+	Blocked    int64
+	BytesIn    int64
+	BytesOut   int64
+	BytesSaved int64  // estimated; see estimateResponseSize
+	Zone       string // network zone ("" with no zones configured)
 }
 
 // DomainCount holds a domain and its counter value.
@@ -147,13 +420,7 @@ func (c *Collector) SnapshotClients() []ClientSnapshot {
 	c.clients.Range(func(key, value any) bool {
 		cs, _ := value.(*clientStats) //nolint:errcheck // type is guaranteed
 		ip, _ := key.(string)         //nolint:errcheck // type is guaranteed
-		out = append(out, ClientSnapshot{
-			IP:       ip,
-			Requests: cs.Requests.Load(),
-			Blocked:  cs.Blocked.Load(),
-			BytesIn:  cs.BytesIn.Load(),
-			BytesOut: cs.BytesOut.Load(),
-		})
+		out = append(out, cs.snapshot(ip, c.zones.Zone(ip)))
 		return true
 	})
 	return out
@@ -163,7 +430,7 @@ func (c *Collector) SnapshotClients() []ClientSnapshot {
 func (c *Collector) SnapshotDomainRequests() []DomainCount {
 	var out []DomainCount
 	c.domainRequests.Range(func(key, value any) bool {
-		domain, _ := key.(string)         //nolint:errcheck // type is guaranteed
+		domain, _ := key.(string)           //nolint:errcheck // type is guaranteed
 		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed
 		out = append(out, DomainCount{Domain: domain, Count: counter.Load()})
 		return true
@@ -175,7 +442,7 @@ func (c *Collector) SnapshotDomainRequests() []DomainCount {
 func (c *Collector) SnapshotDomainBlocks() []DomainCount {
 	var out []DomainCount
 	c.domainBlocks.Range(func(key, value any) bool {
-		domain, _ := key.(string)         //nolint:errcheck // type is guaranteed
+		domain, _ := key.(string)           //nolint:errcheck // type is guaranteed
 		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed
 		out = append(out, DomainCount{Domain: domain, Count: counter.Load()})
 		return true
@@ -183,9 +450,9 @@ func (c *Collector) SnapshotDomainBlocks() []DomainCount {
 	return out
 }
 
-// TotalRequests returns the sum of all client request counts.
+// TotalRequests returns the sum of all client request counts, including evicted clients.
 func (c *Collector) TotalRequests() int64 {
-	var total int64
+	total := c.evicted.Requests.Load()
 	c.clients.Range(func(_, value any) bool {
 		cs, _ := value.(*clientStats) //nolint:errcheck // type is guaranteed
 		total += cs.Requests.Load()
@@ -194,9 +461,9 @@ func (c *Collector) TotalRequests() int64 {
 	return total
 }
 
-// TotalBlocked returns the sum of all client blocked counts.
+// TotalBlocked returns the sum of all client blocked counts, including evicted clients.
 func (c *Collector) TotalBlocked() int64 {
-	var total int64
+	total := c.evicted.Blocked.Load()
 	c.clients.Range(func(_, value any) bool {
 		cs, _ := value.(*clientStats) //nolint:errcheck // type is guaranteed
 		total += cs.Blocked.Load()
@@ -205,9 +472,9 @@ func (c *Collector) TotalBlocked() int64 {
 	return total
 }
 
-// TotalBytesIn returns the sum of all client bytes-in counts.
+// TotalBytesIn returns the sum of all client bytes-in counts, including evicted clients.
 func (c *Collector) TotalBytesIn() int64 {
-	var total int64
+	total := c.evicted.BytesIn.Load()
 	c.clients.Range(func(_, value any) bool {
 		cs, _ := value.(*clientStats) //nolint:errcheck // type is guaranteed
 		total += cs.BytesIn.Load()
@@ -216,9 +483,9 @@ func (c *Collector) TotalBytesIn() int64 {
 	return total
 }
 
-// TotalBytesOut returns the sum of all client bytes-out counts.
+// TotalBytesOut returns the sum of all client bytes-out counts, including evicted clients.
 func (c *Collector) TotalBytesOut() int64 {
-	var total int64
+	total := c.evicted.BytesOut.Load()
 	c.clients.Range(func(_, value any) bool {
 		cs, _ := value.(*clientStats) //nolint:errcheck // type is guaranteed
 		total += cs.BytesOut.Load()
@@ -250,19 +517,41 @@ func (c *Collector) RecordPluginMatch(pluginName, rule string, modified bool, re
 	}
 }
 
+// RecordPluginFilterDuration adds the time spent in one plugin Filter call.
+func (c *Collector) RecordPluginFilterDuration(pluginName string, d time.Duration) {
+	v, _ := c.pluginNanos.LoadOrStore(pluginName, &atomic.Int64{})
+	v.(*atomic.Int64).Add(int64(d)) //nolint:errcheck // type is guaranteed by LoadOrStore
+}
+
+// RecordPluginPanic records a recovered panic in a plugin's Filter.
+func (c *Collector) RecordPluginPanic(pluginName string) {
+	v, _ := c.pluginPanics.LoadOrStore(pluginName, &atomic.Int64{})
+	v.(*atomic.Int64).Add(1) //nolint:errcheck // type is guaranteed by LoadOrStore
+}
+
 // PluginSnapshot holds a point-in-time view of per-plugin counters.
 type PluginSnapshot struct {
-	Name      string
-	Inspected int64
-	Matched   int64
-	Modified  int64
+	Name        string
+	Inspected   int64
+	Matched     int64
+	Modified    int64
+	FilterNanos int64 // cumulative time spent in Filter
+	Panics      int64 // recovered Filter panics
+}
+
+// AvgFilterMicros returns the mean Filter duration per inspected response.
+func (s PluginSnapshot) AvgFilterMicros() float64 {
+	if s.Inspected == 0 {
+		return 0
+	}
+	return float64(s.FilterNanos) / float64(s.Inspected) / 1e3
 }
 
 // SnapshotPlugins returns current per-plugin filter stats.
 func (c *Collector) SnapshotPlugins() []PluginSnapshot {
 	var out []PluginSnapshot
 	c.pluginInspected.Range(func(key, value any) bool {
-		name, _ := key.(string)            //nolint:errcheck // type is guaranteed
+		name, _ := key.(string)             //nolint:errcheck // type is guaranteed
 		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed
 		snap := PluginSnapshot{
 			Name:      name,
@@ -274,6 +563,12 @@ func (c *Collector) SnapshotPlugins() []PluginSnapshot {
 		if modv, ok := c.pluginModified.Load(name); ok {
 			snap.Modified = modv.(*atomic.Int64).Load() //nolint:errcheck // type is guaranteed
 		}
+		if nv, ok := c.pluginNanos.Load(name); ok {
+			snap.FilterNanos = nv.(*atomic.Int64).Load() //nolint:errcheck // type is guaranteed
+		}
+		if pv, ok := c.pluginPanics.Load(name); ok {
+			snap.Panics = pv.(*atomic.Int64).Load() //nolint:errcheck // type is guaranteed
+		}
 		out = append(out, snap)
 		return true
 	})
@@ -319,12 +614,14 @@ func (c *Collector) runSampler() {
 				prevReqs = c.TotalRequests()
 				prevBytes = c.TotalBytesIn()
 				prevTime = now
+				c.sampleLive(now, 0)
 				continue
 			}
 			dt := now.Sub(prevTime).Seconds()
 			if dt <= 0 {
 				continue
 			}
+			c.sampleLive(now, dt)
 
 			curReqs := c.TotalRequests()
 			curBytes := c.TotalBytesIn()
@@ -367,7 +664,7 @@ func (c *Collector) SnapshotPluginRules(pluginName string, n int) []RuleCount {
 	prefix := pluginName + ":"
 	var out []RuleCount
 	c.pluginRules.Range(func(key, value any) bool {
-		k, _ := key.(string)              //nolint:errcheck // type is guaranteed
+		k, _ := key.(string)                //nolint:errcheck // type is guaranteed
 		counter, _ := value.(*atomic.Int64) //nolint:errcheck // type is guaranteed
 		if len(k) > len(prefix) && k[:len(prefix)] == prefix {
 			out = append(out, RuleCount{
@@ -0,0 +1,34 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector_LiveClientsEvictIdle(t *testing.T) {
+	c := NewCollector()
+	c.ConnOpened("10.0.0.1")
+	c.RecordLiveBytes("10.0.0.2", 2000)
+	c.RecordLiveBytes("10.0.0.3", 0)
+
+	now := time.Now()
+	c.sampleLive(now, 2)
+	live := c.SnapshotLiveClients()
+	assert.Len(t, live, 3, "recently seen clients stay")
+	for _, lc := range live {
+		if lc.IP == "10.0.0.2" {
+			assert.Equal(t, int64(1000), lc.BPS)
+		}
+	}
+
+	// Past liveIdle only the client with an open connection remains.
+	c.sampleLive(now.Add(liveIdle+time.Second), 1)
+	live = c.SnapshotLiveClients()
+	assert.Equal(t, []LiveClientSnapshot{{IP: "10.0.0.1", Active: 1}}, live)
+
+	c.ConnClosed("10.0.0.1")
+	c.sampleLive(time.Now().Add(liveIdle+time.Second), 1)
+	assert.Empty(t, c.SnapshotLiveClients())
+}
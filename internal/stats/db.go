@@ -2,9 +2,13 @@ package stats
 
 import (
 	"context"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"log/slog"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,9 +17,17 @@ import (
 )
 
 // DB manages the stats SQLite database and periodic flushing.
+//
+// Writes (flushes) go through conn under mu. Queries use a separate
+// read-only connection under readMu, so dashboard polling doesn't contend
+// with the flush loop; the database runs in WAL mode so the reader never
+// blocks the writer. In-memory databases can't be shared between
+// connections, so they have no reader and queries fall back to conn.
 type DB struct {
 	mu        sync.Mutex
 	conn      *sqlite.Conn
+	readMu    sync.Mutex
+	reader    *sqlite.Conn
 	collector *Collector
 	logger    *slog.Logger
 	interval  time.Duration
@@ -28,11 +40,41 @@ type DB struct {
 	lastDomainReqs   map[string]int64
 	lastDomainBlks   map[string]int64
 	lastDomainAllows map[string]int64
+	lastDomainMITM   map[string]int64
+
+	// flushSeq is incremented (under mu) after every committed flush. Merged
+	// queries use it to detect a flush landing between reading the DB
+	// totals and snapshotting the last* maps above.
+	flushSeq uint64
+
+	// now returns the current time; replaced in tests.
+	now func() time.Time
 
 	// allowSnapshotFn is an optional callback that returns per-domain allow
 	// counts from the blocklist package. Set via SetAllowStatsSource to
 	// avoid an import cycle between stats and blocklist.
 	allowSnapshotFn func() map[string]int64
+	// allowResetFn zeroes the counters behind allowSnapshotFn. Set via
+	// SetAllowStatsReset; called by Reset.
+	allowResetFn func()
+
+	// mitmSnapshotFn is an optional callback that returns per-domain MITM
+	// intercept counts. Set via SetMITMStatsSource.
+	mitmSnapshotFn func() []DomainCount
+
+	// retention bounds traffic_hourly: each flush deletes rows for hours
+	// older than now - retention. 0 keeps everything. The per-domain
+	// tables (blocked, allowed, requested, MITM) are cumulative counters
+	// without an hour dimension, so they keep accumulating regardless.
+	retention time.Duration
+	// pruned counts rows deleted since the last Vacuum (guarded by mu).
+	pruned int64
+
+	// clientIdle evicts clients idle this long from the collector after
+	// each flush (0 = never). clientIdleRetention, if set, also deletes an
+	// evicted client's traffic_hourly rows older than now - it.
+	clientIdle          time.Duration
+	clientIdleRetention time.Duration
 }
 
 // Open opens or creates a stats database at the given path.
@@ -52,6 +94,8 @@ func Open(dbPath string, collector *Collector, logger *slog.Logger, flushInterva
 		lastDomainReqs:   make(map[string]int64),
 		lastDomainBlks:   make(map[string]int64),
 		lastDomainAllows: make(map[string]int64),
+		lastDomainMITM:   make(map[string]int64),
+		now:              time.Now,
 	}
 
 	if err := db.ensureSchema(); err != nil {
@@ -59,15 +103,61 @@ func Open(dbPath string, collector *Collector, logger *slog.Logger, flushInterva
 		return nil, err
 	}
 
+	if !isMemoryPath(dbPath) {
+		reader, err := sqlite.OpenConn(dbPath, sqlite.OpenReadOnly)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("open stats db reader: %w", err)
+		}
+		reader.SetBusyTimeout(5 * time.Second)
+		db.reader = reader
+	}
+
 	return db, nil
 }
 
+// isMemoryPath reports whether dbPath names an in-memory database.
+func isMemoryPath(dbPath string) bool {
+	return dbPath == "" || dbPath == ":memory:" || strings.Contains(dbPath, "mode=memory")
+}
+
 // SetAllowStatsSource sets the callback used to snapshot per-domain allow
 // counts from the blocklist. This avoids an import cycle between packages.
 func (db *DB) SetAllowStatsSource(fn func() map[string]int64) {
 	db.allowSnapshotFn = fn
 }
 
+// SetAllowStatsReset sets the callback Reset uses to zero the blocklist
+// allow counters, so the next flush doesn't write them back.
+func (db *DB) SetAllowStatsReset(fn func()) {
+	db.allowResetFn = fn
+}
+
+// SetMITMStatsSource sets the callback used to snapshot per-domain MITM
+// intercept counts, normally Collector.SnapshotMITMIntercepts.
+func (db *DB) SetMITMStatsSource(fn func() []DomainCount) {
+	db.mitmSnapshotFn = fn
+}
+
+// SetRetention sets how long traffic_hourly rows are kept. 0 (the default)
+// keeps them forever.
+func (db *DB) SetRetention(d time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.retention = d
+}
+
+// SetClientIdle enables idle client eviction: after each flush, clients
+// with no activity for idle and no open connections are dropped from the
+// collector once their final counts are written. With retention > 0 their
+// traffic_hourly rows older than retention are deleted at the same time.
+func (db *DB) SetClientIdle(idle, retention time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.clientIdle = idle
+	db.clientIdleRetention = retention
+}
+
 // Start begins the background flush loop.
 func (db *DB) Start() {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -76,7 +166,9 @@ func (db *DB) Start() {
 	go db.flushLoop(ctx)
 }
 
-// Close stops the flush loop, performs a final flush, and closes the database.
+// Close stops the flush loop, performs a final flush, and closes the
+// database. If retention pruning deleted rows, the file is vacuumed first
+// to give the space back.
 func (db *DB) Close() error {
 	if db.cancel != nil {
 		db.cancel()
@@ -88,6 +180,18 @@ func (db *DB) Close() error {
 		db.logger.Error("final stats flush failed", "error", err)
 	}
 
+	db.mu.Lock()
+	pruned := db.pruned
+	db.mu.Unlock()
+	if pruned > 0 {
+		if err := db.Vacuum(); err != nil {
+			db.logger.Error("stats vacuum failed", "error", err)
+		}
+	}
+
+	if db.reader != nil {
+		_ = db.reader.Close()
+	}
 	return db.conn.Close()
 }
 
@@ -111,10 +215,20 @@ func (db *DB) flushLoop(ctx context.Context) {
 }
 
 // Flush computes deltas since the last flush and writes them to SQLite.
-func (db *DB) Flush() (err error) {
+func (db *DB) Flush() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	hour := time.Now().UTC().Truncate(time.Hour).Format("2006-01-02T15")
+	if err := db.flushLocked(); err != nil {
+		return err
+	}
+	db.flushSeq++
+	return nil
+}
+
+// flushLocked writes the deltas in a single savepoint. Caller holds mu.
+func (db *DB) flushLocked() (err error) {
+	now := db.now().UTC()
+	hour := now.Truncate(time.Hour).Format("2006-01-02T15")
 
 	defer sqlitex.Save(db.conn)(&err)
 
@@ -122,31 +236,24 @@ func (db *DB) Flush() (err error) {
 	currentClients := make(map[string]ClientSnapshot)
 	for _, cs := range db.collector.SnapshotClients() {
 		currentClients[cs.IP] = cs
-		prev := db.lastClients[cs.IP]
-		dReqs := cs.Requests - prev.Requests
-		dBlocked := cs.Blocked - prev.Blocked
-		dIn := cs.BytesIn - prev.BytesIn
-		dOut := cs.BytesOut - prev.BytesOut
-		if dReqs == 0 && dBlocked == 0 && dIn == 0 && dOut == 0 {
-			continue
-		}
-		err = sqlitex.Execute(db.conn, `
-			INSERT INTO traffic_hourly (hour, client_ip, requests, blocked, bytes_in, bytes_out)
-			VALUES (?, ?, ?, ?, ?, ?)
-			ON CONFLICT (hour, client_ip) DO UPDATE SET
-				requests  = requests  + excluded.requests,
-				blocked   = blocked   + excluded.blocked,
-				bytes_in  = bytes_in  + excluded.bytes_in,
-				bytes_out = bytes_out + excluded.bytes_out
-		`, &sqlitex.ExecOptions{
-			Args: []any{hour, cs.IP, dReqs, dBlocked, dIn, dOut},
-		})
-		if err != nil {
-			return fmt.Errorf("upsert traffic_hourly: %w", err)
+		if err := db.upsertClientDelta(hour, cs, db.lastClients[cs.IP]); err != nil {
+			return err
 		}
 	}
 	db.lastClients = currentClients
 
+	if db.clientIdle > 0 {
+		if err := db.evictIdleLocked(now, hour); err != nil {
+			return err
+		}
+	}
+
+	if db.retention > 0 {
+		if err := db.pruneLocked(now); err != nil {
+			return err
+		}
+	}
+
 	// Flush per-domain block count deltas.
 	currentBlks := snapshotToMap(db.collector.SnapshotDomainBlocks())
 	if err := db.flushDomainDeltas("blocked_domains", currentBlks, db.lastDomainBlks); err != nil {
@@ -156,7 +263,7 @@ func (db *DB) Flush() (err error) {
 
 	// Flush per-domain request count deltas.
 	currentReqs := snapshotToMap(db.collector.SnapshotDomainRequests())
-	if err := db.flushDomainDeltas("domain_requests", currentReqs, db.lastDomainReqs); err != nil {
+	if err := db.flushDomainRequests(currentReqs, db.lastDomainReqs, now); err != nil {
 		return err
 	}
 	db.lastDomainReqs = currentReqs
@@ -170,6 +277,152 @@ func (db *DB) Flush() (err error) {
 		db.lastDomainAllows = currentAllows
 	}
 
+	// Flush per-domain MITM intercept deltas (if source is configured).
+	if db.mitmSnapshotFn != nil {
+		currentMITM := snapshotToMap(db.mitmSnapshotFn())
+		if err := db.flushDomainDeltas("mitm_intercepts", currentMITM, db.lastDomainMITM); err != nil {
+			return err
+		}
+		db.lastDomainMITM = currentMITM
+	}
+
+	return nil
+}
+
+// Reset deletes all persisted statistics and zeroes the in-memory counters
+// they are flushed from, leaving the schema in place. Counters are zeroed
+// under mu, together with the last* snapshots, so no flush can write a
+// delta computed against the old totals.
+func (db *DB) Reset() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.deleteAllLocked(); err != nil {
+		return err
+	}
+	db.collector.Reset()
+	if db.allowResetFn != nil {
+		db.allowResetFn()
+	}
+	db.lastClients = make(map[string]ClientSnapshot)
+	db.lastDomainReqs = make(map[string]int64)
+	db.lastDomainBlks = make(map[string]int64)
+	db.lastDomainAllows = make(map[string]int64)
+	db.lastDomainMITM = make(map[string]int64)
+	db.flushSeq++
+	db.logger.Info("stats reset")
+	return nil
+}
+
+// deleteAllLocked empties every stats table in a single savepoint. Deleted
+// rows count toward pruned so Close vacuums the file. Caller holds mu.
+func (db *DB) deleteAllLocked() (err error) {
+	defer sqlitex.Save(db.conn)(&err)
+
+	var deleted int64
+	for _, table := range []string{"traffic_hourly", "blocked_domains", "domain_requests", "allowed_domains", "mitm_intercepts"} {
+		if err := sqlitex.Execute(db.conn, "DELETE FROM "+table, nil); err != nil {
+			return fmt.Errorf("reset %s: %w", table, err)
+		}
+		deleted += int64(db.conn.Changes())
+	}
+	db.pruned += deleted
+	return nil
+}
+
+// upsertClientDelta adds the change in a client's counters since prev to
+// its traffic_hourly row for hour. Caller holds mu.
+func (db *DB) upsertClientDelta(hour string, cs, prev ClientSnapshot) error {
+	dReqs := cs.Requests - prev.Requests
+	dBlocked := cs.Blocked - prev.Blocked
+	dIn := cs.BytesIn - prev.BytesIn
+	dOut := cs.BytesOut - prev.BytesOut
+	dSaved := cs.BytesSaved - prev.BytesSaved
+	if dReqs == 0 && dBlocked == 0 && dIn == 0 && dOut == 0 && dSaved == 0 {
+		return nil
+	}
+	err := sqlitex.Execute(db.conn, `
+		INSERT INTO traffic_hourly (hour, client_ip, requests, blocked, bytes_in, bytes_out, bytes_saved, zone)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hour, client_ip) DO UPDATE SET
+			requests    = requests    + excluded.requests,
+			blocked     = blocked     + excluded.blocked,
+			bytes_in    = bytes_in    + excluded.bytes_in,
+			bytes_out   = bytes_out   + excluded.bytes_out,
+			bytes_saved = bytes_saved + excluded.bytes_saved,
+			zone        = excluded.zone
+	`, &sqlitex.ExecOptions{
+		Args: []any{hour, cs.IP, dReqs, dBlocked, dIn, dOut, dSaved, cs.Zone},
+	})
+	if err != nil {
+		return fmt.Errorf("upsert traffic_hourly: %w", err)
+	}
+	return nil
+}
+
+// evictIdleLocked drops clients idle for clientIdle from the collector.
+// Their counts were just flushed; anything recorded since the flush
+// snapshot is written before the client is forgotten. Caller holds mu.
+func (db *DB) evictIdleLocked(now time.Time, hour string) error {
+	evicted := db.collector.evictIdleClients(now.Add(-db.clientIdle))
+	if len(evicted) == 0 {
+		return nil
+	}
+	cutoff := now.Add(-db.clientIdleRetention).Truncate(time.Hour).Format("2006-01-02T15")
+	var pruned int
+	for _, cs := range evicted {
+		if err := db.upsertClientDelta(hour, cs, db.lastClients[cs.IP]); err != nil {
+			return err
+		}
+		delete(db.lastClients, cs.IP)
+		if db.clientIdleRetention <= 0 {
+			continue
+		}
+		err := sqlitex.Execute(db.conn, `DELETE FROM traffic_hourly WHERE client_ip = ? AND hour < ?`, &sqlitex.ExecOptions{
+			Args: []any{cs.IP, cutoff},
+		})
+		if err != nil {
+			return fmt.Errorf("prune idle client rows: %w", err)
+		}
+		pruned += db.conn.Changes()
+	}
+	db.pruned += int64(pruned)
+	db.logger.Debug("evicted idle stats clients", "clients", len(evicted), "pruned_rows", pruned)
+	return nil
+}
+
+// pruneLocked deletes traffic_hourly rows for hours that ended before
+// now - retention. Caller holds mu.
+func (db *DB) pruneLocked(now time.Time) error {
+	cutoff := now.Add(-db.retention).Truncate(time.Hour).Format("2006-01-02T15")
+	err := sqlitex.Execute(db.conn, `DELETE FROM traffic_hourly WHERE hour < ?`, &sqlitex.ExecOptions{
+		Args: []any{cutoff},
+	})
+	if err != nil {
+		return fmt.Errorf("prune traffic_hourly: %w", err)
+	}
+	if n := db.conn.Changes(); n > 0 {
+		db.pruned += int64(n)
+		db.logger.Debug("pruned stats rows", "table", "traffic_hourly", "rows", n, "before", cutoff)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file to reclaim space left by pruned rows
+// and truncates the WAL. It blocks flushes and queries while it runs.
+func (db *DB) Vacuum() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.readMu.Lock()
+	defer db.readMu.Unlock()
+
+	if err := sqlitex.ExecuteTransient(db.conn, "VACUUM;", nil); err != nil {
+		return fmt.Errorf("vacuum stats db: %w", err)
+	}
+	if err := sqlitex.ExecuteTransient(db.conn, "PRAGMA wal_checkpoint(TRUNCATE);", nil); err != nil {
+		return fmt.Errorf("checkpoint stats db: %w", err)
+	}
+	db.pruned = 0
 	return nil
 }
 
@@ -194,6 +447,35 @@ func (db *DB) flushDomainDeltas(table string, current, last map[string]int64) er
 	return nil
 }
 
+// seenLayout is the first_seen/last_seen format: fixed-width UTC, so string
+// comparison orders by time.
+const seenLayout = "2006-01-02T15:04:05Z"
+
+// flushDomainRequests upserts request count deltas, recording when each
+// domain was first seen and refreshing last_seen. Timestamps have flush
+// granularity: a domain is "seen" at the flush that records its requests.
+func (db *DB) flushDomainRequests(current, last map[string]int64, now time.Time) error {
+	ts := now.Format(seenLayout)
+	for domain, count := range current {
+		delta := count - last[domain]
+		if delta == 0 {
+			continue
+		}
+		err := sqlitex.Execute(db.conn, `
+			INSERT INTO domain_requests (domain, count, first_seen, last_seen) VALUES (?, ?, ?, ?)
+			ON CONFLICT (domain) DO UPDATE SET
+				count     = count + excluded.count,
+				last_seen = excluded.last_seen
+		`, &sqlitex.ExecOptions{
+			Args: []any{domain, delta, ts, ts},
+		})
+		if err != nil {
+			return fmt.Errorf("upsert domain_requests: %w", err)
+		}
+	}
+	return nil
+}
+
 // snapshotToMap converts a DomainCount slice to a domain->count map.
 func snapshotToMap(counts []DomainCount) map[string]int64 {
 	m := make(map[string]int64, len(counts))
@@ -203,12 +485,59 @@ func snapshotToMap(counts []DomainCount) map[string]int64 {
 	return m
 }
 
-// TopBlocked returns the top n blocked domains from the database.
-func (db *DB) TopBlocked(n int) []DomainCount {
+// readConn locks and returns the connection plain queries should use,
+// along with the func that releases it.
+func (db *DB) readConn() (*sqlite.Conn, func()) {
+	if db.reader == nil {
+		db.mu.Lock()
+		return db.conn, db.mu.Unlock
+	}
+	db.readMu.Lock()
+	return db.reader, db.readMu.Unlock
+}
+
+// maxReadAttempts bounds optimistic merged reads before falling back to
+// reading under the writer lock.
+const maxReadAttempts = 3
+
+// consistentRead runs read against the DB and snapshot against the flush
+// state (last* maps) such that both reflect the same flush. On the reader
+// connection this is optimistic: if a flush commits while read runs, both
+// are retried. snapshot runs with mu held.
+func (db *DB) consistentRead(snapshot func(), read func(conn *sqlite.Conn)) {
+	if db.reader != nil {
+		for range maxReadAttempts {
+			db.mu.Lock()
+			seq := db.flushSeq
+			snapshot()
+			db.mu.Unlock()
+
+			db.readMu.Lock()
+			read(db.reader)
+			db.readMu.Unlock()
+
+			db.mu.Lock()
+			stable := db.flushSeq == seq
+			db.mu.Unlock()
+			if stable {
+				return
+			}
+		}
+	}
+
+	// No reader, or flushes keep landing mid-read: serialize with the writer.
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	snapshot()
+	read(db.conn)
+}
+
+// TopBlocked returns the top n blocked domains from the database.
+func (db *DB) TopBlocked(n int) []DomainCount {
+	conn, release := db.readConn()
+	defer release()
 	var out []DomainCount
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT domain, count FROM blocked_domains
 		ORDER BY count DESC LIMIT ?
 	`, &sqlitex.ExecOptions{
@@ -226,10 +555,10 @@ func (db *DB) TopBlocked(n int) []DomainCount {
 
 // TopRequested returns the top n most-requested domains from the database.
 func (db *DB) TopRequested(n int) []DomainCount {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	conn, release := db.readConn()
+	defer release()
 	var out []DomainCount
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT domain, count FROM domain_requests
 		ORDER BY count DESC LIMIT ?
 	`, &sqlitex.ExecOptions{
@@ -245,17 +574,54 @@ func (db *DB) TopRequested(n int) []DomainCount {
 	return out
 }
 
+// DomainSeen is a requested domain with its first/last-seen times.
+type DomainSeen struct {
+	Domain    string    `json:"domain"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// NewDomainsSince returns domains first seen at or after since, newest
+// first. Only flushed data is included.
+func (db *DB) NewDomainsSince(since time.Time) []DomainSeen {
+	conn, release := db.readConn()
+	defer release()
+	out := []DomainSeen{}
+	_ = sqlitex.Execute(conn, `
+		SELECT domain, count, first_seen, last_seen FROM domain_requests
+		WHERE first_seen >= ?
+		ORDER BY first_seen DESC, domain ASC
+	`, &sqlitex.ExecOptions{
+		Args: []any{since.UTC().Format(seenLayout)},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			first, _ := time.Parse(seenLayout, stmt.ColumnText(2)) //nolint:errcheck // written by flushDomainRequests
+			last, _ := time.Parse(seenLayout, stmt.ColumnText(3))  //nolint:errcheck // written by flushDomainRequests
+			out = append(out, DomainSeen{
+				Domain:    stmt.ColumnText(0),
+				Count:     stmt.ColumnInt64(1),
+				FirstSeen: first,
+				LastSeen:  last,
+			})
+			return nil
+		},
+	})
+	return out
+}
+
 // TopClients returns the top n clients by request count from the database.
 func (db *DB) TopClients(n int) []ClientSnapshot {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	conn, release := db.readConn()
+	defer release()
 	var out []ClientSnapshot
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT client_ip,
 			SUM(requests) as total_requests,
 			SUM(blocked) as total_blocked,
 			SUM(bytes_in) as total_bytes_in,
-			SUM(bytes_out) as total_bytes_out
+			SUM(bytes_out) as total_bytes_out,
+			SUM(bytes_saved) as total_bytes_saved,
+			MAX(zone) as zone
 		FROM traffic_hourly
 		GROUP BY client_ip
 		ORDER BY total_requests DESC LIMIT ?
@@ -263,11 +629,13 @@ func (db *DB) TopClients(n int) []ClientSnapshot {
 		Args: []any{n},
 		ResultFunc: func(stmt *sqlite.Stmt) error {
 			out = append(out, ClientSnapshot{
-				IP:       stmt.ColumnText(0),
-				Requests: stmt.ColumnInt64(1),
-				Blocked:  stmt.ColumnInt64(2),
-				BytesIn:  stmt.ColumnInt64(3),
-				BytesOut: stmt.ColumnInt64(4),
+				IP:         stmt.ColumnText(0),
+				Requests:   stmt.ColumnInt64(1),
+				Blocked:    stmt.ColumnInt64(2),
+				BytesIn:    stmt.ColumnInt64(3),
+				BytesOut:   stmt.ColumnInt64(4),
+				BytesSaved: stmt.ColumnInt64(5),
+				Zone:       stmt.ColumnText(6),
 			})
 			return nil
 		},
@@ -277,16 +645,18 @@ func (db *DB) TopClients(n int) []ClientSnapshot {
 
 // TopClientsSince returns the top n clients within a time window.
 func (db *DB) TopClientsSince(n int, since time.Time) []ClientSnapshot {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	conn, release := db.readConn()
+	defer release()
 	sinceHour := since.UTC().Truncate(time.Hour).Format("2006-01-02T15")
 	var out []ClientSnapshot
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT client_ip,
 			SUM(requests) as total_requests,
 			SUM(blocked) as total_blocked,
 			SUM(bytes_in) as total_bytes_in,
-			SUM(bytes_out) as total_bytes_out
+			SUM(bytes_out) as total_bytes_out,
+			SUM(bytes_saved) as total_bytes_saved,
+			MAX(zone) as zone
 		FROM traffic_hourly
 		WHERE hour >= ?
 		GROUP BY client_ip
@@ -295,11 +665,47 @@ func (db *DB) TopClientsSince(n int, since time.Time) []ClientSnapshot {
 		Args: []any{sinceHour, n},
 		ResultFunc: func(stmt *sqlite.Stmt) error {
 			out = append(out, ClientSnapshot{
-				IP:       stmt.ColumnText(0),
-				Requests: stmt.ColumnInt64(1),
-				Blocked:  stmt.ColumnInt64(2),
-				BytesIn:  stmt.ColumnInt64(3),
-				BytesOut: stmt.ColumnInt64(4),
+				IP:         stmt.ColumnText(0),
+				Requests:   stmt.ColumnInt64(1),
+				Blocked:    stmt.ColumnInt64(2),
+				BytesIn:    stmt.ColumnInt64(3),
+				BytesOut:   stmt.ColumnInt64(4),
+				BytesSaved: stmt.ColumnInt64(5),
+				Zone:       stmt.ColumnText(6),
+			})
+			return nil
+		},
+	})
+	return out
+}
+
+// ZoneClientsSince returns every client recorded in zone within a time
+// window, by request count. Clients are attributed to the zone they were
+// in when each hour's traffic was flushed.
+func (db *DB) ZoneClientsSince(zone string, since time.Time) []ClientSnapshot {
+	conn, release := db.readConn()
+	defer release()
+	sinceHour := since.UTC().Truncate(time.Hour).Format("2006-01-02T15")
+	var out []ClientSnapshot
+	_ = sqlitex.Execute(conn, `
+		SELECT client_ip,
+			SUM(requests) as total_requests,
+			SUM(blocked), SUM(bytes_in), SUM(bytes_out), SUM(bytes_saved)
+		FROM traffic_hourly
+		WHERE hour >= ? AND zone = ?
+		GROUP BY client_ip
+		ORDER BY total_requests DESC
+	`, &sqlitex.ExecOptions{
+		Args: []any{sinceHour, zone},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			out = append(out, ClientSnapshot{
+				IP:         stmt.ColumnText(0),
+				Requests:   stmt.ColumnInt64(1),
+				Blocked:    stmt.ColumnInt64(2),
+				BytesIn:    stmt.ColumnInt64(3),
+				BytesOut:   stmt.ColumnInt64(4),
+				BytesSaved: stmt.ColumnInt64(5),
+				Zone:       zone,
 			})
 			return nil
 		},
@@ -309,10 +715,10 @@ func (db *DB) TopClientsSince(n int, since time.Time) []ClientSnapshot {
 
 // TrafficTotalsSince returns aggregate traffic stats within a time window.
 func (db *DB) TrafficTotalsSince(since time.Time) (requests, blocked, bytesIn, bytesOut int64) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	conn, release := db.readConn()
+	defer release()
 	sinceHour := since.UTC().Truncate(time.Hour).Format("2006-01-02T15")
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT COALESCE(SUM(requests), 0),
 			COALESCE(SUM(blocked), 0),
 			COALESCE(SUM(bytes_in), 0),
@@ -332,98 +738,133 @@ func (db *DB) TrafficTotalsSince(since time.Time) (requests, blocked, bytesIn, b
 	return
 }
 
-// MergedTopBlocked returns the top n blocked domains by merging DB totals
-// with unflushed in-memory deltas.
-func (db *DB) MergedTopBlocked(n int) []DomainCount {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	merged := make(map[string]int64)
+// csvHeader is the column row written by ExportCSV.
+var csvHeader = []string{"hour", "client_ip", "requests", "blocked", "bytes_in", "bytes_out"}
 
-	// DB cumulative totals (all rows, no limit).
-	for _, dc := range db.allBlockedDomains() {
-		merged[dc.Domain] = dc.Count
-	}
+// ExportCSV writes the traffic_hourly rows for hours from since onward to w
+// as CSV, oldest first, with a header row. Rows are written as they are read
+// rather than collected first; the read connection is held until the last
+// row is written. Only flushed traffic is included.
+func (db *DB) ExportCSV(w io.Writer, since time.Time) error {
+	conn, release := db.readConn()
+	defer release()
+	sinceHour := since.UTC().Truncate(time.Hour).Format("2006-01-02T15")
 
-	// Add only the unflushed delta from in-memory.
-	for _, dc := range db.collector.SnapshotDomainBlocks() {
-		delta := dc.Count - db.lastDomainBlks[dc.Domain]
-		if delta > 0 {
-			merged[dc.Domain] += delta
-		}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
 	}
+	record := make([]string, len(csvHeader))
+	err := sqlitex.Execute(conn, `
+		SELECT hour, client_ip, requests, blocked, bytes_in, bytes_out
+		FROM traffic_hourly
+		WHERE hour >= ?
+		ORDER BY hour, client_ip
+	`, &sqlitex.ExecOptions{
+		Args: []any{sinceHour},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			record[0] = stmt.ColumnText(0)
+			record[1] = stmt.ColumnText(1)
+			for i := 2; i < len(record); i++ {
+				record[i] = strconv.FormatInt(stmt.ColumnInt64(i), 10)
+			}
+			return cw.Write(record)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("export traffic_hourly: %w", err)
+	}
+	cw.Flush()
+	return cw.Error()
+}
 
-	return topNFromMap(merged, n)
+// MergedTopBlocked returns the top n blocked domains by merging DB totals
+// with unflushed in-memory deltas.
+func (db *DB) MergedTopBlocked(n int) []DomainCount {
+	var last map[string]int64
+	var totals, current []DomainCount
+	db.consistentRead(func() {
+		last = db.lastDomainBlks
+	}, func(conn *sqlite.Conn) {
+		totals = allBlockedDomains(conn)
+		current = db.collector.SnapshotDomainBlocks()
+	})
+	return mergeDomainCounts(totals, snapshotToMap(current), last, n)
 }
 
 // MergedTopRequested returns the top n requested domains by merging DB totals
 // with unflushed in-memory deltas.
 func (db *DB) MergedTopRequested(n int) []DomainCount {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	merged := make(map[string]int64)
-
-	// DB cumulative totals (all rows, no limit).
-	for _, dc := range db.allDomainRequests() {
-		merged[dc.Domain] = dc.Count
-	}
-
-	// Add only the unflushed delta from in-memory.
-	for _, dc := range db.collector.SnapshotDomainRequests() {
-		delta := dc.Count - db.lastDomainReqs[dc.Domain]
-		if delta > 0 {
-			merged[dc.Domain] += delta
-		}
-	}
-
-	return topNFromMap(merged, n)
+	var last map[string]int64
+	var totals, current []DomainCount
+	db.consistentRead(func() {
+		last = db.lastDomainReqs
+	}, func(conn *sqlite.Conn) {
+		totals = allDomainRequests(conn)
+		current = db.collector.SnapshotDomainRequests()
+	})
+	return mergeDomainCounts(totals, snapshotToMap(current), last, n)
 }
 
 // MergedTopClients returns the top n clients by merging DB totals
 // with unflushed in-memory deltas.
 func (db *DB) MergedTopClients(n int) []ClientSnapshot {
-	db.mu.Lock()
-	defer db.mu.Unlock()
 	merged := make(map[string]*ClientSnapshot)
+	var last map[string]ClientSnapshot
+	var current []ClientSnapshot
 
-	// DB cumulative totals.
-	_ = sqlitex.Execute(db.conn, `
-		SELECT client_ip,
-			SUM(requests), SUM(blocked), SUM(bytes_in), SUM(bytes_out)
-		FROM traffic_hourly
-		GROUP BY client_ip
-	`, &sqlitex.ExecOptions{
-		ResultFunc: func(stmt *sqlite.Stmt) error {
-			cs := ClientSnapshot{
-				IP:       stmt.ColumnText(0),
-				Requests: stmt.ColumnInt64(1),
-				Blocked:  stmt.ColumnInt64(2),
-				BytesIn:  stmt.ColumnInt64(3),
-				BytesOut: stmt.ColumnInt64(4),
-			}
-			merged[cs.IP] = &cs
-			return nil
-		},
+	db.consistentRead(func() {
+		last = db.lastClients
+	}, func(conn *sqlite.Conn) {
+		clear(merged)
+		// DB cumulative totals.
+		_ = sqlitex.Execute(conn, `
+			SELECT client_ip,
+				SUM(requests), SUM(blocked), SUM(bytes_in), SUM(bytes_out), SUM(bytes_saved), MAX(zone)
+			FROM traffic_hourly
+			GROUP BY client_ip
+		`, &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				cs := ClientSnapshot{
+					IP:         stmt.ColumnText(0),
+					Requests:   stmt.ColumnInt64(1),
+					Blocked:    stmt.ColumnInt64(2),
+					BytesIn:    stmt.ColumnInt64(3),
+					BytesOut:   stmt.ColumnInt64(4),
+					BytesSaved: stmt.ColumnInt64(5),
+					Zone:       stmt.ColumnText(6),
+				}
+				merged[cs.IP] = &cs
+				return nil
+			},
+		})
+		current = db.collector.SnapshotClients()
 	})
 
 	// Add only the unflushed deltas from in-memory.
-	for _, cs := range db.collector.SnapshotClients() {
-		prev := db.lastClients[cs.IP]
+	for _, cs := range current {
+		prev := last[cs.IP]
 		dReqs := cs.Requests - prev.Requests
 		dBlocked := cs.Blocked - prev.Blocked
 		dIn := cs.BytesIn - prev.BytesIn
 		dOut := cs.BytesOut - prev.BytesOut
+		dSaved := cs.BytesSaved - prev.BytesSaved
 		if existing, ok := merged[cs.IP]; ok {
 			existing.Requests += dReqs
 			existing.Blocked += dBlocked
 			existing.BytesIn += dIn
 			existing.BytesOut += dOut
+			existing.BytesSaved += dSaved
+			existing.Zone = cs.Zone
 		} else if dReqs > 0 || dIn > 0 || dOut > 0 {
 			merged[cs.IP] = &ClientSnapshot{
-				IP:       cs.IP,
-				Requests: dReqs,
-				Blocked:  dBlocked,
-				BytesIn:  dIn,
-				BytesOut: dOut,
+				IP:         cs.IP,
+				Requests:   dReqs,
+				Blocked:    dBlocked,
+				BytesIn:    dIn,
+				BytesOut:   dOut,
+				BytesSaved: dSaved,
+				Zone:       cs.Zone,
 			}
 		}
 	}
@@ -446,10 +887,10 @@ func (db *DB) MergedTopClients(n int) []ClientSnapshot {
 
 // TopAllowed returns the top n allowed domains from the database.
 func (db *DB) TopAllowed(n int) []DomainCount {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	conn, release := db.readConn()
+	defer release()
 	var out []DomainCount
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT domain, count FROM allowed_domains
 		ORDER BY count DESC LIMIT ?
 	`, &sqlitex.ExecOptions{
@@ -468,22 +909,50 @@ func (db *DB) TopAllowed(n int) []DomainCount {
 // MergedTopAllowed returns the top n allowed domains by merging DB totals
 // with unflushed in-memory deltas.
 func (db *DB) MergedTopAllowed(n int) []DomainCount {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	merged := make(map[string]int64)
+	var last, current map[string]int64
+	var totals []DomainCount
+	db.consistentRead(func() {
+		last = db.lastDomainAllows
+	}, func(conn *sqlite.Conn) {
+		totals = allAllowedDomains(conn)
+		if db.allowSnapshotFn != nil {
+			current = db.allowSnapshotFn()
+		}
+	})
+	return mergeDomainCounts(totals, current, last, n)
+}
 
-	// DB cumulative totals.
-	for _, dc := range db.allAllowedDomains() {
+// MergedTopMITM returns the top n MITM-intercepted domains by merging DB
+// totals with unflushed in-memory deltas.
+func (db *DB) MergedTopMITM(n int) []DomainCount {
+	var last map[string]int64
+	var totals, current []DomainCount
+	db.consistentRead(func() {
+		last = db.lastDomainMITM
+	}, func(conn *sqlite.Conn) {
+		totals = allMITMIntercepts(conn)
+		if db.mitmSnapshotFn != nil {
+			current = db.mitmSnapshotFn()
+		}
+	})
+	return mergeDomainCounts(totals, snapshotToMap(current), last, n)
+}
+
+// mergeDomainCounts adds the unflushed delta (current - last) for each
+// domain to the DB cumulative totals and returns the top n.
+func mergeDomainCounts(totals []DomainCount, current, last map[string]int64, n int) []DomainCount {
+	merged := make(map[string]int64, len(totals))
+
+	// DB cumulative totals (all rows, no limit).
+	for _, dc := range totals {
 		merged[dc.Domain] = dc.Count
 	}
 
 	// Add only the unflushed delta from in-memory.
-	if db.allowSnapshotFn != nil {
-		for domain, count := range db.allowSnapshotFn() {
-			delta := count - db.lastDomainAllows[domain]
-			if delta > 0 {
-				merged[domain] += delta
-			}
+	for domain, count := range current {
+		delta := count - last[domain]
+		if delta > 0 {
+			merged[domain] += delta
 		}
 	}
 
@@ -491,9 +960,9 @@ func (db *DB) MergedTopAllowed(n int) []DomainCount {
 }
 
 // allAllowedDomains returns all allowed domain counts (no limit).
-func (db *DB) allAllowedDomains() []DomainCount {
+func allAllowedDomains(conn *sqlite.Conn) []DomainCount {
 	var out []DomainCount
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT domain, count FROM allowed_domains ORDER BY count DESC
 	`, &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
@@ -522,16 +991,23 @@ func topNFromMap(m map[string]int64, n int) []DomainCount {
 	return out
 }
 
-// ensureSchema creates the stats tables.
+// ensureSchema enables WAL mode and creates the stats tables. WAL lets the
+// read-only query connection run alongside flushes; for in-memory databases
+// the pragma is a no-op.
 func (db *DB) ensureSchema() error {
-	return sqlitex.ExecuteScript(db.conn, `
+	if err := sqlitex.ExecuteTransient(db.conn, "PRAGMA journal_mode=WAL;", nil); err != nil {
+		return fmt.Errorf("enable stats db WAL: %w", err)
+	}
+	err := sqlitex.ExecuteScript(db.conn, `
 		CREATE TABLE IF NOT EXISTS traffic_hourly (
-			hour      TEXT NOT NULL,
-			client_ip TEXT NOT NULL,
-			requests  INTEGER NOT NULL DEFAULT 0,
-			blocked   INTEGER NOT NULL DEFAULT 0,
-			bytes_in  INTEGER NOT NULL DEFAULT 0,
-			bytes_out INTEGER NOT NULL DEFAULT 0,
+			hour        TEXT NOT NULL,
+			client_ip   TEXT NOT NULL,
+			requests    INTEGER NOT NULL DEFAULT 0,
+			blocked     INTEGER NOT NULL DEFAULT 0,
+			bytes_in    INTEGER NOT NULL DEFAULT 0,
+			bytes_out   INTEGER NOT NULL DEFAULT 0,
+			bytes_saved INTEGER NOT NULL DEFAULT 0,
+			zone        TEXT NOT NULL DEFAULT '',
 			PRIMARY KEY (hour, client_ip)
 		) WITHOUT ROWID;
 
@@ -541,11 +1017,18 @@ func (db *DB) ensureSchema() error {
 		) WITHOUT ROWID;
 
 		CREATE TABLE IF NOT EXISTS domain_requests (
+			domain     TEXT NOT NULL PRIMARY KEY,
+			count      INTEGER NOT NULL DEFAULT 0,
+			first_seen TEXT NOT NULL DEFAULT '',
+			last_seen  TEXT NOT NULL DEFAULT ''
+		) WITHOUT ROWID;
+
+		CREATE TABLE IF NOT EXISTS allowed_domains (
 			domain TEXT NOT NULL PRIMARY KEY,
 			count  INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
 
-		CREATE TABLE IF NOT EXISTS allowed_domains (
+		CREATE TABLE IF NOT EXISTS mitm_intercepts (
 			domain TEXT NOT NULL PRIMARY KEY,
 			count  INTEGER NOT NULL DEFAULT 0
 		) WITHOUT ROWID;
@@ -553,12 +1036,82 @@ func (db *DB) ensureSchema() error {
 		CREATE INDEX IF NOT EXISTS idx_traffic_hourly_hour ON traffic_hourly(hour);
 		CREATE INDEX IF NOT EXISTS idx_traffic_hourly_client ON traffic_hourly(client_ip);
 	`, nil)
+	if err != nil {
+		return err
+	}
+	return db.migrateSchema()
+}
+
+// migrateSchema adds columns missing from older databases. Existing
+// domain_requests rows predate first/last-seen tracking, so both are set
+// to the migration time; existing traffic_hourly rows get no bytes saved
+// and no zone.
+func (db *DB) migrateSchema() error {
+	hasSaved, err := db.hasColumn("traffic_hourly", "bytes_saved")
+	if err != nil {
+		return err
+	}
+	if !hasSaved {
+		err := sqlitex.ExecuteTransient(db.conn, "ALTER TABLE traffic_hourly ADD COLUMN bytes_saved INTEGER NOT NULL DEFAULT 0", nil)
+		if err != nil {
+			return fmt.Errorf("migrate traffic_hourly bytes_saved column: %w", err)
+		}
+	}
+	hasZone, err := db.hasColumn("traffic_hourly", "zone")
+	if err != nil {
+		return err
+	}
+	if !hasZone {
+		err := sqlitex.ExecuteTransient(db.conn, "ALTER TABLE traffic_hourly ADD COLUMN zone TEXT NOT NULL DEFAULT ''", nil)
+		if err != nil {
+			return fmt.Errorf("migrate traffic_hourly zone column: %w", err)
+		}
+	}
+
+	hasFirstSeen, err := db.hasColumn("domain_requests", "first_seen")
+	if err != nil {
+		return err
+	}
+	if hasFirstSeen {
+		return nil
+	}
+
+	ts := db.now().UTC().Format(seenLayout)
+	err = sqlitex.ExecuteScript(db.conn, `
+		ALTER TABLE domain_requests ADD COLUMN first_seen TEXT NOT NULL DEFAULT '';
+		ALTER TABLE domain_requests ADD COLUMN last_seen TEXT NOT NULL DEFAULT '';
+		UPDATE domain_requests SET first_seen = $ts, last_seen = $ts;
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{"$ts": ts},
+	})
+	if err != nil {
+		return fmt.Errorf("migrate domain_requests seen columns: %w", err)
+	}
+	return nil
+}
+
+// hasColumn reports whether table has the named column. Table names are
+// hardcoded string literals from callers, not user input.
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	found := false
+	err := sqlitex.Execute(db.conn, fmt.Sprintf("PRAGMA table_info(%s)", table), &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			if stmt.ColumnText(1) == column {
+				found = true
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("check stats schema: %w", err)
+	}
+	return found, nil
 }
 
 // allBlockedDomains returns all blocked domain counts (no limit).
-func (db *DB) allBlockedDomains() []DomainCount {
+func allBlockedDomains(conn *sqlite.Conn) []DomainCount {
 	var out []DomainCount
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT domain, count FROM blocked_domains ORDER BY count DESC
 	`, &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
@@ -572,10 +1125,27 @@ func (db *DB) allBlockedDomains() []DomainCount {
 	return out
 }
 
+// allMITMIntercepts returns all MITM intercept counts (no limit).
+func allMITMIntercepts(conn *sqlite.Conn) []DomainCount {
+	var out []DomainCount
+	_ = sqlitex.Execute(conn, `
+		SELECT domain, count FROM mitm_intercepts ORDER BY count DESC
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			out = append(out, DomainCount{
+				Domain: stmt.ColumnText(0),
+				Count:  stmt.ColumnInt64(1),
+			})
+			return nil
+		},
+	})
+	return out
+}
+
 // allDomainRequests returns all domain request counts.
-func (db *DB) allDomainRequests() []DomainCount {
+func allDomainRequests(conn *sqlite.Conn) []DomainCount {
 	var out []DomainCount
-	_ = sqlitex.Execute(db.conn, `
+	_ = sqlitex.Execute(conn, `
 		SELECT domain, count FROM domain_requests ORDER BY count DESC
 	`, &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
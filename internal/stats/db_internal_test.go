@@ -0,0 +1,303 @@
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func TestDB_LongQueryDoesNotBlockFlush(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(filepath.Join(t.TempDir(), "stats.db"), collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	require.NotNil(t, db.reader, "file-backed DB should open a reader connection")
+
+	collector.RecordRequest("10.0.0.1", "a.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+
+	// Park a query mid-statement on the reader until the flush finishes.
+	parked := make(chan struct{})
+	flushed := make(chan struct{})
+	queryDone := make(chan struct{})
+	go func() {
+		defer close(queryDone)
+		conn, release := db.readConn()
+		defer release()
+		_ = sqlitex.Execute(conn, `SELECT domain FROM domain_requests`, &sqlitex.ExecOptions{
+			ResultFunc: func(*sqlite.Stmt) error {
+				close(parked)
+				select {
+				case <-flushed:
+				case <-time.After(5 * time.Second):
+				}
+				return nil
+			},
+		})
+	}()
+
+	<-parked
+	collector.RecordRequest("10.0.0.1", "b.com", true, 0, 0)
+	start := time.Now()
+	go func() {
+		assert.NoError(t, db.Flush())
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+		assert.Less(t, time.Since(start), 2*time.Second)
+	case <-time.After(3 * time.Second):
+		t.Fatal("flush blocked behind a running query")
+	}
+	<-queryDone
+
+	assert.Len(t, db.TopRequested(10), 2)
+}
+
+func TestDB_DomainFirstLastSeen(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(":memory:", collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	db.now = func() time.Time { return t0 }
+	collector.RecordRequest("10.0.0.1", "old.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+
+	t1 := t0.Add(2 * time.Hour)
+	db.now = func() time.Time { return t1 }
+	collector.RecordRequest("10.0.0.1", "old.com", false, 0, 0)
+	collector.RecordRequest("10.0.0.1", "new.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+
+	// A flush with no new requests for a domain leaves last_seen alone.
+	db.now = func() time.Time { return t1.Add(time.Hour) }
+	require.NoError(t, db.Flush())
+
+	all := db.NewDomainsSince(time.Time{})
+	require.Len(t, all, 2)
+	assert.Equal(t, DomainSeen{Domain: "new.com", Count: 1, FirstSeen: t1, LastSeen: t1}, all[0])
+	assert.Equal(t, DomainSeen{Domain: "old.com", Count: 2, FirstSeen: t0, LastSeen: t1}, all[1])
+
+	recent := db.NewDomainsSince(t0.Add(time.Hour))
+	require.Len(t, recent, 1)
+	assert.Equal(t, "new.com", recent[0].Domain)
+}
+
+func TestDB_MigratesBytesSavedColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	conn, err := sqlite.OpenConn(path, sqlite.OpenReadWrite|sqlite.OpenCreate)
+	require.NoError(t, err)
+	require.NoError(t, sqlitex.ExecuteScript(conn, `
+		CREATE TABLE traffic_hourly (
+			hour      TEXT NOT NULL,
+			client_ip TEXT NOT NULL,
+			requests  INTEGER NOT NULL DEFAULT 0,
+			blocked   INTEGER NOT NULL DEFAULT 0,
+			bytes_in  INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (hour, client_ip)
+		) WITHOUT ROWID;
+		INSERT INTO traffic_hourly VALUES ('2026-01-01T00', '10.0.0.1', 5, 1, 10, 20);
+	`, nil))
+	require.NoError(t, conn.Close())
+
+	collector := NewCollector()
+	db, err := Open(path, collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	collector.RecordRequest("10.0.0.1", "a.com", false, 0, 300)
+	collector.RecordRequest("10.0.0.1", "b.com", true, 0, 0)
+	require.NoError(t, db.Flush())
+
+	top := db.TopClients(1)
+	require.Len(t, top, 1)
+	assert.Equal(t, int64(7), top[0].Requests)
+	assert.Equal(t, int64(300), top[0].BytesSaved)
+}
+
+func TestDB_MigratesDomainSeenColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	conn, err := sqlite.OpenConn(path, sqlite.OpenReadWrite|sqlite.OpenCreate)
+	require.NoError(t, err)
+	require.NoError(t, sqlitex.ExecuteScript(conn, `
+		CREATE TABLE domain_requests (
+			domain TEXT NOT NULL PRIMARY KEY,
+			count  INTEGER NOT NULL DEFAULT 0
+		) WITHOUT ROWID;
+		INSERT INTO domain_requests (domain, count) VALUES ('legacy.com', 7);
+	`, nil))
+	require.NoError(t, conn.Close())
+
+	db, err := Open(path, NewCollector(), slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	got := db.NewDomainsSince(time.Now().Add(-time.Minute))
+	require.Len(t, got, 1)
+	assert.Equal(t, "legacy.com", got[0].Domain)
+	assert.Equal(t, int64(7), got[0].Count)
+	assert.WithinDuration(t, time.Now(), got[0].FirstSeen, time.Minute)
+	assert.Equal(t, got[0].FirstSeen, got[0].LastSeen)
+}
+
+func TestDB_RetentionPrunesTrafficHourly(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(filepath.Join(t.TempDir(), "stats.db"), collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetRetention(48 * time.Hour)
+
+	t0 := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	db.now = func() time.Time { return t0 }
+	collector.RecordRequest("10.0.0.1", "old.com", true, 0, 0)
+	require.NoError(t, db.Flush())
+
+	db.now = func() time.Time { return t0.Add(24 * time.Hour) }
+	collector.RecordRequest("10.0.0.2", "new.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+	assert.Len(t, db.TopClients(10), 2, "rows within retention are kept")
+
+	// Three days on, the first hour is past retention; the second is not.
+	db.now = func() time.Time { return t0.Add(72 * time.Hour) }
+	require.NoError(t, db.Flush())
+	top := db.TopClients(10)
+	require.Len(t, top, 1)
+	assert.Equal(t, "10.0.0.2", top[0].IP)
+	assert.Equal(t, int64(1), db.pruned)
+
+	// Domain totals have no hour and are never pruned.
+	assert.Len(t, db.TopBlocked(10), 1)
+
+	require.NoError(t, db.Vacuum())
+	assert.Zero(t, db.pruned)
+	assert.Len(t, db.TopClients(10), 1)
+}
+
+func TestDB_EvictsIdleClients(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(filepath.Join(t.TempDir(), "stats.db"), collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetClientIdle(time.Hour, 0)
+
+	collector.RecordRequest("10.0.0.9", "scan.com", false, 10, 20) // one-off scanner
+	collector.RecordRequest("10.0.0.1", "a.com", false, 0, 0)      // open tunnel
+	collector.ConnOpened("10.0.0.1")
+	require.NoError(t, db.Flush())
+	assert.Len(t, collector.SnapshotClients(), 2, "not idle yet")
+
+	// Activity after the last flush must not be lost on eviction.
+	collector.RecordBytes("10.0.0.9", 5, 5)
+
+	db.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	require.NoError(t, db.Flush())
+
+	clients := collector.SnapshotClients()
+	require.Len(t, clients, 1, "idle client evicted")
+	assert.Equal(t, "10.0.0.1", clients[0].IP, "client with an open connection kept")
+	assert.NotContains(t, db.lastClients, "10.0.0.9")
+
+	// Its counts stay in the totals and in stats.db.
+	assert.Equal(t, int64(2), collector.TotalRequests())
+	assert.Equal(t, int64(15), collector.TotalBytesIn())
+	var scanner ClientSnapshot
+	for _, cs := range db.TopClients(10) {
+		if cs.IP == "10.0.0.9" {
+			scanner = cs
+		}
+	}
+	assert.Equal(t, int64(1), scanner.Requests)
+	assert.Equal(t, int64(15), scanner.BytesIn)
+	assert.Equal(t, int64(25), scanner.BytesOut)
+
+	// A returning client starts a fresh entry; its rows keep adding up.
+	collector.RecordRequest("10.0.0.9", "scan.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+	for _, cs := range db.MergedTopClients(10) {
+		if cs.IP == "10.0.0.9" {
+			assert.Equal(t, int64(2), cs.Requests)
+		}
+	}
+}
+
+func TestDB_EvictIdlePrunesRows(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(filepath.Join(t.TempDir(), "stats.db"), collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetClientIdle(time.Hour, 2*time.Hour)
+
+	collector.RecordRequest("10.0.0.9", "scan.com", false, 0, 0)
+	require.NoError(t, db.Flush())
+
+	db.now = func() time.Time { return time.Now().Add(4 * time.Hour) }
+	require.NoError(t, db.Flush())
+	assert.Empty(t, db.TopClients(10), "evicted client's old rows pruned")
+	assert.Equal(t, int64(1), db.pruned)
+	assert.Equal(t, int64(1), collector.TotalRequests())
+}
+
+func TestDB_ExportCSV(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(":memory:", collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	t0 := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	db.now = func() time.Time { return t0 }
+	collector.RecordRequest("10.0.0.1", "ads.com", true, 10, 0)
+	require.NoError(t, db.Flush())
+
+	db.now = func() time.Time { return t0.Add(2 * time.Hour) }
+	collector.RecordRequest("10.0.0.2", "example.com", false, 20, 300)
+	collector.RecordRequest("10.0.0.1", "example.com", false, 5, 50)
+	require.NoError(t, db.Flush())
+
+	var buf bytes.Buffer
+	require.NoError(t, db.ExportCSV(&buf, time.Time{}))
+	assert.Equal(t, "hour,client_ip,requests,blocked,bytes_in,bytes_out\n"+
+		"2026-03-01T10,10.0.0.1,1,1,10,0\n"+
+		"2026-03-01T12,10.0.0.1,1,0,5,50\n"+
+		"2026-03-01T12,10.0.0.2,1,0,20,300\n", buf.String())
+
+	// since is truncated to the hour, like the other windowed queries.
+	buf.Reset()
+	require.NoError(t, db.ExportCSV(&buf, t0.Add(90*time.Minute)))
+	assert.Equal(t, "hour,client_ip,requests,blocked,bytes_in,bytes_out\n"+
+		"2026-03-01T12,10.0.0.1,1,0,5,50\n"+
+		"2026-03-01T12,10.0.0.2,1,0,20,300\n", buf.String())
+}
+
+// failWriter fails every write after the first n bytes.
+type failWriter struct{ n int }
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, errors.New("disk full")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestDB_ExportCSVWriteError(t *testing.T) {
+	collector := NewCollector()
+	db, err := Open(":memory:", collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	collector.RecordRequest("10.0.0.1", "ads.com", true, 10, 0)
+	require.NoError(t, db.Flush())
+
+	assert.Error(t, db.ExportCSV(&failWriter{}, time.Time{}))
+}
@@ -2,6 +2,10 @@ package stats_test
 
 import (
 	"log/slog"
+	"maps"
+	"net/netip"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -88,6 +92,21 @@ func TestCollector_SnapshotDomainBlocks(t *testing.T) {
 	assert.Equal(t, int64(2), snaps[0].Count)
 }
 
+func TestCollector_PluginFilterDuration(t *testing.T) {
+	c := stats.NewCollector()
+	c.RecordPluginInspected("reddit-promotions")
+	c.RecordPluginInspected("reddit-promotions")
+	c.RecordPluginFilterDuration("reddit-promotions", 300*time.Microsecond)
+	c.RecordPluginFilterDuration("reddit-promotions", 100*time.Microsecond)
+
+	snaps := c.SnapshotPlugins()
+	require.Len(t, snaps, 1)
+	assert.Equal(t, int64(400*time.Microsecond), snaps[0].FilterNanos)
+	assert.InDelta(t, 200.0, snaps[0].AvgFilterMicros(), 0.001)
+
+	assert.Zero(t, stats.PluginSnapshot{}.AvgFilterMicros())
+}
+
 func TestCollector_Watermarks(t *testing.T) {
 	c := stats.NewCollector()
 	c.StartSampler()
@@ -150,6 +169,63 @@ func TestCollector_StopSamplerClean(t *testing.T) {
 	}
 }
 
+func TestCollector_LiveClientsConcurrent(t *testing.T) {
+	c := stats.NewCollector()
+
+	// 50 connections open concurrently from one client, with traffic.
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ConnOpened("10.0.0.1")
+			c.RecordRequest("10.0.0.1", "example.com", false, 100, 1000)
+		}()
+	}
+	wg.Wait()
+	c.ConnOpened("10.0.0.2")
+
+	live := _liveByIP(c)
+	assert.Equal(t, int64(50), live["10.0.0.1"].Active)
+	assert.Equal(t, int64(1), live["10.0.0.2"].Active)
+
+	// Close 20 of them concurrently.
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ConnClosed("10.0.0.1")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(30), _liveByIP(c)["10.0.0.1"].Active)
+}
+
+func TestCollector_LiveClientsRate(t *testing.T) {
+	c := stats.NewCollector()
+	c.StartSampler()
+	defer c.StopSampler()
+
+	// Wait for the sampler's baseline tick, then generate traffic.
+	time.Sleep(1500 * time.Millisecond)
+	c.RecordRequest("10.0.0.1", "example.com", false, 4000, 6000)
+
+	time.Sleep(1000 * time.Millisecond)
+	assert.Positive(t, _liveByIP(c)["10.0.0.1"].BPS, "rate should reflect traffic since the last tick")
+
+	// With no further traffic the rate drops back to zero.
+	time.Sleep(1000 * time.Millisecond)
+	assert.Zero(t, _liveByIP(c)["10.0.0.1"].BPS)
+}
+
+func _liveByIP(c *stats.Collector) map[string]stats.LiveClientSnapshot {
+	out := make(map[string]stats.LiveClientSnapshot)
+	for _, lc := range c.SnapshotLiveClients() {
+		out[lc.IP] = lc
+	}
+	return out
+}
+
 func _openTestDB(t *testing.T) (*stats.DB, *stats.Collector) {
 	t.Helper()
 	collector := stats.NewCollector()
@@ -219,6 +295,31 @@ func TestDB_TopClients(t *testing.T) {
 	assert.Equal(t, int64(1), top[0].Blocked)
 }
 
+func TestDB_ClientBytesSaved(t *testing.T) {
+	db, collector := _openTestDB(t)
+
+	// Blocked requests are estimated from the domain's allowed responses,
+	// or the overall average for domains never served.
+	collector.RecordRequest("10.0.0.1", "ads.com", false, 10, 3000)
+	collector.RecordRequest("10.0.0.1", "ads.com", false, 10, 1000)
+	collector.RecordRequest("10.0.0.2", "page.com", false, 10, 8000)
+	collector.RecordRequest("10.0.0.2", "ads.com", true, 0, 0)
+	collector.RecordRequest("10.0.0.2", "tracker.com", true, 0, 0)
+	require.NoError(t, db.Flush())
+	collector.RecordRequest("10.0.0.2", "ads.com", true, 0, 0)
+
+	byIP := func(snaps []stats.ClientSnapshot) map[string]int64 {
+		m := make(map[string]int64)
+		for _, cs := range snaps {
+			m[cs.IP] = cs.BytesSaved
+		}
+		return m
+	}
+	assert.Equal(t, map[string]int64{"10.0.0.1": 0, "10.0.0.2": 2000 + 4000}, byIP(db.TopClients(10)))
+	assert.Equal(t, map[string]int64{"10.0.0.1": 0, "10.0.0.2": 2000 + 4000 + 2000}, byIP(db.MergedTopClients(10)))
+	assert.Equal(t, map[string]int64{"10.0.0.1": 0, "10.0.0.2": 2000 + 4000 + 2000}, byIP(collector.SnapshotClients()))
+}
+
 func TestDB_MergedTopBlocked(t *testing.T) {
 	db, collector := _openTestDB(t)
 
@@ -329,3 +430,145 @@ func TestDB_TrafficTotalsSince(t *testing.T) {
 	assert.Equal(t, int64(0), bytesIn)
 	assert.Equal(t, int64(0), bytesOut)
 }
+
+func TestDB_FileBackedReaderSeesFlushes(t *testing.T) {
+	collector := stats.NewCollector()
+	db, err := stats.Open(filepath.Join(t.TempDir(), "stats.db"), collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	collector.RecordRequest("10.0.0.1", "ads.com", true, 0, 0)
+	collector.RecordRequest("10.0.0.1", "ads.com", true, 0, 0)
+	require.NoError(t, db.Flush())
+
+	top := db.TopBlocked(10)
+	require.Len(t, top, 1)
+	assert.Equal(t, int64(2), top[0].Count)
+
+	// Unflushed deltas are merged on top of what the reader sees.
+	collector.RecordRequest("10.0.0.2", "ads.com", true, 0, 0)
+	merged := db.MergedTopBlocked(10)
+	require.Len(t, merged, 1)
+	assert.Equal(t, int64(3), merged[0].Count)
+
+	clients := db.MergedTopClients(10)
+	assert.Len(t, clients, 2)
+
+	require.NoError(t, db.Flush())
+	merged = db.MergedTopBlocked(10)
+	require.Len(t, merged, 1)
+	assert.Equal(t, int64(3), merged[0].Count, "flushed delta must not be double-counted")
+}
+
+func TestZones(t *testing.T) {
+	z := stats.NewZones(map[string][]netip.Prefix{
+		"guest":    {netip.MustParsePrefix("192.168.50.0/24"), netip.MustParsePrefix("fd00:50::/64")},
+		"internal": {netip.MustParsePrefix("192.168.0.0/16")},
+		"printer":  {netip.MustParsePrefix("192.168.50.9/32")},
+	})
+
+	assert.Equal(t, "guest", z.Zone("192.168.50.20"), "most specific range wins")
+	assert.Equal(t, "printer", z.Zone("192.168.50.9"))
+	assert.Equal(t, "internal", z.Zone("192.168.1.5"))
+	assert.Equal(t, "internal", z.Zone("::ffff:192.168.1.5"), "IPv4-mapped addresses match IPv4 ranges")
+	assert.Equal(t, "guest", z.Zone("fd00:50::1"))
+	assert.Equal(t, stats.DefaultZone, z.Zone("10.0.0.1"))
+	assert.Equal(t, stats.DefaultZone, z.Zone("not-an-ip"))
+
+	var none *stats.Zones
+	assert.Empty(t, none.Zone("192.168.50.20"), "no zones configured")
+}
+
+func TestDB_ZoneAttribution(t *testing.T) {
+	db, collector := _openTestDB(t)
+	collector.SetZones(stats.NewZones(map[string][]netip.Prefix{
+		"guest": {netip.MustParsePrefix("192.168.50.0/24")},
+	}))
+
+	collector.RecordRequest("192.168.50.10", "a.com", false, 10, 100)
+	collector.RecordRequest("192.168.50.11", "a.com", true, 0, 0)
+	collector.RecordRequest("192.168.1.2", "a.com", false, 10, 100)
+	require.NoError(t, db.Flush())
+	collector.RecordRequest("192.168.50.10", "b.com", false, 10, 100)
+
+	guest := db.ZoneClientsSince("guest", time.Now().Add(-time.Hour))
+	require.Len(t, guest, 2)
+	for _, cs := range guest {
+		assert.Equal(t, "guest", cs.Zone)
+	}
+	assert.Len(t, db.ZoneClientsSince(stats.DefaultZone, time.Now().Add(-time.Hour)), 1)
+
+	zones := make(map[string]string)
+	for _, cs := range db.MergedTopClients(0) {
+		zones[cs.IP] = cs.Zone
+	}
+	assert.Equal(t, map[string]string{
+		"192.168.50.10": "guest",
+		"192.168.50.11": "guest",
+		"192.168.1.2":   stats.DefaultZone,
+	}, zones)
+}
+
+func TestDB_MITMInterceptsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.db")
+	collector := stats.NewCollector()
+	db, err := stats.Open(path, collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	db.SetMITMStatsSource(collector.SnapshotMITMIntercepts)
+
+	collector.RecordMITMRequest("10.0.0.1", "www.reddit.com")
+	collector.RecordMITMRequest("10.0.0.1", "www.reddit.com")
+	require.NoError(t, db.Flush())
+	collector.RecordMITMRequest("10.0.0.1", "www.reddit.com")
+	collector.RecordMITMRequest("10.0.0.1", "i.redd.it")
+	assert.Equal(t, []stats.DomainCount{
+		{Domain: "www.reddit.com", Count: 3},
+		{Domain: "i.redd.it", Count: 1},
+	}, db.MergedTopMITM(10), "unflushed deltas are merged")
+	require.NoError(t, db.Close(), "close flushes pending intercepts")
+
+	// A restart starts a fresh collector; totals come back from the DB.
+	collector = stats.NewCollector()
+	db, err = stats.Open(path, collector, slog.Default(), time.Minute)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	db.SetMITMStatsSource(collector.SnapshotMITMIntercepts)
+
+	collector.RecordMITMRequest("10.0.0.1", "i.redd.it")
+	assert.Equal(t, []stats.DomainCount{
+		{Domain: "www.reddit.com", Count: 3},
+		{Domain: "i.redd.it", Count: 2},
+	}, db.MergedTopMITM(10))
+}
+
+func TestDB_Reset(t *testing.T) {
+	db, collector := _openTestDB(t)
+	allows := map[string]int64{"safe.example.com": 2}
+	db.SetAllowStatsSource(func() map[string]int64 { return maps.Clone(allows) })
+	db.SetAllowStatsReset(func() { clear(allows) })
+	db.SetMITMStatsSource(collector.SnapshotMITMIntercepts)
+
+	collector.RecordRequest("10.0.0.1", "ads.com", true, 10, 0)
+	collector.RecordMITMRequest("10.0.0.1", "www.reddit.com")
+	collector.TransparentHTTP.Add(1)
+	require.NoError(t, db.Flush())
+	collector.RecordRequest("10.0.0.1", "example.com", false, 10, 100) // unflushed
+
+	require.NoError(t, db.Reset())
+	assert.Empty(t, db.MergedTopClients(10))
+	assert.Empty(t, db.MergedTopBlocked(10))
+	assert.Empty(t, db.MergedTopRequested(10))
+	assert.Empty(t, db.MergedTopAllowed(10))
+	assert.Empty(t, db.MergedTopMITM(10))
+	assert.Zero(t, collector.TotalRequests())
+	assert.Zero(t, collector.TransparentHTTP.Load())
+	assert.Empty(t, allows)
+
+	// Counting resumes from zero; nothing from before the reset is flushed.
+	collector.RecordRequest("10.0.0.2", "ads.com", true, 0, 0)
+	require.NoError(t, db.Flush())
+	assert.Equal(t, []stats.DomainCount{{Domain: "ads.com", Count: 1}}, db.TopBlocked(10))
+	requests, blocked, _, _ := db.TrafficTotalsSince(time.Time{})
+	assert.Equal(t, int64(1), requests)
+	assert.Equal(t, int64(1), blocked)
+}
@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// DefaultZone is the zone of clients outside every configured zone range.
+const DefaultZone = "default"
+
+type zoneRange struct {
+	prefix netip.Prefix
+	name   string
+}
+
+// Zones maps client addresses to network zone names by CIDR.
+type Zones struct {
+	ranges []zoneRange // most specific first
+}
+
+// NewZones creates a Zones from zone names and their client ranges. When
+// ranges overlap, the most specific (longest) prefix wins.
+func NewZones(zones map[string][]netip.Prefix) *Zones {
+	z := &Zones{}
+	for name, prefixes := range zones {
+		for _, p := range prefixes {
+			z.ranges = append(z.ranges, zoneRange{prefix: p.Masked(), name: name})
+		}
+	}
+	sort.Slice(z.ranges, func(i, j int) bool {
+		if a, b := z.ranges[i].prefix.Bits(), z.ranges[j].prefix.Bits(); a != b {
+			return a > b
+		}
+		return z.ranges[i].name < z.ranges[j].name
+	})
+	return z
+}
+
+// Zone returns the zone of clientIP: the name of the most specific range
+// containing it, or DefaultZone. A nil Zones (no zones configured) returns
+// "".
+func (z *Zones) Zone(clientIP string) string {
+	if z == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return DefaultZone
+	}
+	addr = addr.Unmap()
+	for _, r := range z.ranges {
+		if r.prefix.Contains(addr) {
+			return r.name
+		}
+	}
+	return DefaultZone
+}
@@ -0,0 +1,191 @@
+/*
+Package statsd pushes metrics to a StatsD (or DogStatsD) server over UDP.
+
+Client formats gauges and counters in the plain StatsD line protocol
+("name:value|g", "name:value|c") and packs them into UDP packets. Exporter
+samples a metric source on an interval and sends gauges as-is and counters
+as the increase since the previous push.
+*/
+package statsd
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPacket bounds a UDP payload so packets fit a common path MTU without
+// fragmentation.
+const maxPacket = 1432
+
+// Client buffers metric lines and sends them in UDP packets. It is not safe
+// for concurrent use.
+type Client struct {
+	conn   net.Conn
+	prefix string
+	buf    []byte
+}
+
+// Dial creates a client sending to addr (host:port). A non-empty prefix is
+// prepended to every metric name, separated by a dot.
+func Dial(addr, prefix string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd %s: %w", addr, err)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &Client{conn: conn, prefix: prefix, buf: make([]byte, 0, maxPacket)}, nil
+}
+
+// Gauge queues a gauge (current value).
+func (c *Client) Gauge(name string, value int64) error {
+	return c.add(name, value, "g")
+}
+
+// Count queues a counter increment.
+func (c *Client) Count(name string, delta int64) error {
+	return c.add(name, delta, "c")
+}
+
+// add appends one line, sending the buffered packet first if the line
+// would not fit.
+func (c *Client) add(name string, value int64, typ string) error {
+	line := c.prefix + name + ":" + strconv.FormatInt(value, 10) + "|" + typ
+	if len(c.buf) > 0 && len(c.buf)+1+len(line) > maxPacket {
+		if err := c.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(c.buf) > 0 {
+		c.buf = append(c.buf, '\n')
+	}
+	c.buf = append(c.buf, line...)
+	return nil
+}
+
+// Flush sends any buffered lines.
+func (c *Client) Flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	if err != nil {
+		return fmt.Errorf("send statsd packet: %w", err)
+	}
+	return nil
+}
+
+// Close closes the UDP socket.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Sample is one metric reading. Counter samples are cumulative totals;
+// the exporter sends their increase since the previous push.
+type Sample struct {
+	Name    string
+	Value   int64
+	Counter bool
+}
+
+// Exporter pushes samples from a source to a Client on an interval.
+type Exporter struct {
+	client   *Client
+	source   func() []Sample
+	interval time.Duration
+	logger   *slog.Logger
+
+	// last holds each counter's total at the previous push.
+	last map[string]int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewExporter creates an exporter. Counter totals at creation are the
+// baseline, so only increases from then on are sent.
+func NewExporter(client *Client, source func() []Sample, interval time.Duration, logger *slog.Logger) *Exporter {
+	e := &Exporter{
+		client:   client,
+		source:   source,
+		interval: interval,
+		logger:   logger,
+		last:     make(map[string]int64),
+	}
+	for _, s := range source() {
+		if s.Counter {
+			e.last[s.Name] = s.Value
+		}
+	}
+	return e
+}
+
+// Start begins the background push loop.
+func (e *Exporter) Start() {
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.run()
+}
+
+// Stop ends the push loop, sends a final push, and closes the client.
+func (e *Exporter) Stop() {
+	if e.stop != nil {
+		close(e.stop)
+		<-e.done
+	}
+	if err := e.Push(); err != nil {
+		e.logger.Debug("final statsd push failed", "error", err)
+	}
+	_ = e.client.Close()
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			// UDP has no delivery guarantee anyway; a down server only
+			// costs the packets of this push.
+			if err := e.Push(); err != nil {
+				e.logger.Debug("statsd push failed", "error", err)
+			}
+		}
+	}
+}
+
+// Push samples the source once and sends gauges and counter increases.
+// Counters that did not change are skipped. A counter below its previous
+// total was reset, so its whole current value counts as the increase.
+// Not safe for concurrent use; after Start only the push loop calls it.
+func (e *Exporter) Push() error {
+	for _, s := range e.source() {
+		if !s.Counter {
+			if err := e.client.Gauge(s.Name, s.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		delta := s.Value - e.last[s.Name]
+		if delta < 0 {
+			delta = s.Value
+		}
+		e.last[s.Name] = s.Value
+		if delta == 0 {
+			continue
+		}
+		if err := e.client.Count(s.Name, delta); err != nil {
+			return err
+		}
+	}
+	return e.client.Flush()
+}
@@ -0,0 +1,84 @@
+package statsd_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/statsd"
+)
+
+// listen opens a UDP listener standing in for a StatsD server.
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// readPacket returns the lines of the next packet received on conn.
+func readPacket(t *testing.T, conn *net.UDPConn) []string {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestExporterPush(t *testing.T) {
+	server := listen(t)
+	client, err := statsd.Dial(server.LocalAddr().String(), "fps")
+	require.NoError(t, err)
+
+	requests, active := int64(100), int64(3)
+	source := func() []statsd.Sample {
+		return []statsd.Sample{
+			{Name: "requests", Value: requests, Counter: true},
+			{Name: "blocks", Value: 7, Counter: true},
+			{Name: "connections.active", Value: active},
+		}
+	}
+	e := statsd.NewExporter(client, source, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(e.Stop)
+
+	// Totals at creation are the baseline: unchanged counters are skipped.
+	requests += 5
+	require.NoError(t, e.Push())
+	assert.Equal(t, []string{"fps.requests:5|c", "fps.connections.active:3|g"}, readPacket(t, server))
+
+	// A counter that went down was reset; its new total is the increase.
+	requests, active = 2, 0
+	require.NoError(t, e.Push())
+	assert.Equal(t, []string{"fps.requests:2|c", "fps.connections.active:0|g"}, readPacket(t, server))
+}
+
+func TestClientSplitsPackets(t *testing.T) {
+	server := listen(t)
+	client, err := statsd.Dial(server.LocalAddr().String(), "")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	name := strings.Repeat("m", 100)
+	for range 30 {
+		require.NoError(t, client.Gauge(name, 1))
+	}
+	require.NoError(t, client.Flush())
+
+	var lines int
+	for lines < 30 {
+		packet := readPacket(t, server)
+		assert.LessOrEqual(t, len(strings.Join(packet, "\n")), 1432)
+		for _, l := range packet {
+			assert.Equal(t, name+":1|g", l)
+		}
+		lines += len(packet)
+	}
+	assert.Equal(t, 30, lines)
+}
@@ -0,0 +1,258 @@
+/*
+Package suggest derives advisory allowlist suggestions from block events.
+
+A blocked domain that keeps getting requested while clients load a given
+site is likely a subresource the site needs (CDN, login, player), so
+blocking it may be breaking the page. Each block is attributed to a site:
+the Referer host when the request carries one (plain HTTP), otherwise the
+site the same client last loaded within a short window (CONNECT and SNI
+blocks have no headers). Blocked domains attributed often enough are
+reported as allowlist candidates. Nothing is allowlisted automatically.
+
+Memory is bounded: at most MaxPairs (site, blocked domain) pairs and
+MaxClients recent client visits are kept; the least recently seen entry
+is evicted when a limit is reached.
+*/
+package suggest
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default limits used when Config fields are zero.
+const (
+	DefaultMinBlocks  = 5
+	DefaultWindow     = 5 * time.Second
+	DefaultMaxPairs   = 1000
+	DefaultMaxClients = 1024
+
+	// maxSitesPerSuggestion caps the sites listed on a suggestion.
+	maxSitesPerSuggestion = 5
+)
+
+// Config holds tracker settings. Zero values use the defaults above.
+type Config struct {
+	// MinBlocks is how many attributed blocks a domain needs before it is
+	// suggested.
+	MinBlocks int
+	// Window is how long after a client loads a site its header-less
+	// blocks are attributed to that site.
+	Window time.Duration
+	// MaxPairs bounds tracked (site, blocked domain) pairs.
+	MaxPairs int
+	// MaxClients bounds tracked per-client recent visits.
+	MaxClients int
+}
+
+// Suggestion is a blocked domain that is probably breaking sites.
+type Suggestion struct {
+	Domain   string    `json:"domain"`
+	Blocks   int64     `json:"blocks"`
+	Sites    []string  `json:"sites"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type pairKey struct {
+	site, blocked string
+}
+
+type pairStats struct {
+	count int64
+	last  time.Time
+}
+
+type visit struct {
+	site string
+	at   time.Time
+}
+
+// Tracker correlates blocks with the sites that triggered them.
+type Tracker struct {
+	minBlocks  int
+	window     time.Duration
+	maxPairs   int
+	maxClients int
+	now        func() time.Time
+
+	mu     sync.Mutex
+	pairs  map[pairKey]*pairStats
+	visits map[string]visit // client IP -> last allowed load
+}
+
+// NewTracker creates a Tracker.
+func NewTracker(cfg Config) *Tracker {
+	t := &Tracker{
+		minBlocks:  cfg.MinBlocks,
+		window:     cfg.Window,
+		maxPairs:   cfg.MaxPairs,
+		maxClients: cfg.MaxClients,
+		now:        time.Now,
+		pairs:      make(map[pairKey]*pairStats),
+		visits:     make(map[string]visit),
+	}
+	if t.minBlocks <= 0 {
+		t.minBlocks = DefaultMinBlocks
+	}
+	if t.window <= 0 {
+		t.window = DefaultWindow
+	}
+	if t.maxPairs <= 0 {
+		t.maxPairs = DefaultMaxPairs
+	}
+	if t.maxClients <= 0 {
+		t.maxClients = DefaultMaxClients
+	}
+	return t
+}
+
+// RecordAllowed notes that clientIP just loaded domain (an allowed
+// request), making it the site for the client's next header-less blocks.
+func (t *Tracker) RecordAllowed(clientIP, domain string) {
+	domain = strings.ToLower(domain)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.visits[clientIP]; !ok && len(t.visits) >= t.maxClients {
+		t.evictOldestVisitLocked()
+	}
+	t.visits[clientIP] = visit{site: domain, at: t.now()}
+}
+
+// RecordBlocked attributes a block of domain to a site, using referer when
+// non-empty and the client's recent visit otherwise. Unattributable blocks
+// are dropped.
+func (t *Tracker) RecordBlocked(clientIP, domain, referer string) {
+	domain = strings.ToLower(domain)
+	now := t.now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	site := refererHost(referer)
+	if site == "" {
+		v, ok := t.visits[clientIP]
+		if !ok || now.Sub(v.at) > t.window {
+			return
+		}
+		site = v.site
+	}
+	if site == domain {
+		return
+	}
+
+	key := pairKey{site: site, blocked: domain}
+	p, ok := t.pairs[key]
+	if !ok {
+		if len(t.pairs) >= t.maxPairs {
+			t.evictOldestPairLocked()
+		}
+		p = &pairStats{}
+		t.pairs[key] = p
+	}
+	p.count++
+	p.last = now
+}
+
+// Suggestions returns blocked domains with at least MinBlocks attributed
+// blocks, most blocked first. Sites lists the top referring sites.
+func (t *Tracker) Suggestions() []Suggestion {
+	type agg struct {
+		s     Suggestion
+		sites map[string]int64
+	}
+	byDomain := make(map[string]*agg)
+
+	t.mu.Lock()
+	for k, p := range t.pairs {
+		a, ok := byDomain[k.blocked]
+		if !ok {
+			a = &agg{s: Suggestion{Domain: k.blocked}, sites: make(map[string]int64)}
+			byDomain[k.blocked] = a
+		}
+		a.s.Blocks += p.count
+		a.sites[k.site] += p.count
+		if p.last.After(a.s.LastSeen) {
+			a.s.LastSeen = p.last
+		}
+	}
+	minBlocks := int64(t.minBlocks)
+	t.mu.Unlock()
+
+	out := []Suggestion{}
+	for _, a := range byDomain {
+		if a.s.Blocks < minBlocks {
+			continue
+		}
+		a.s.Sites = topSites(a.sites)
+		out = append(out, a.s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Blocks != out[j].Blocks {
+			return out[i].Blocks > out[j].Blocks
+		}
+		return out[i].Domain < out[j].Domain
+	})
+	return out
+}
+
+// topSites returns up to maxSitesPerSuggestion sites by count.
+func topSites(counts map[string]int64) []string {
+	sites := make([]string, 0, len(counts))
+	for s := range counts {
+		sites = append(sites, s)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if counts[sites[i]] != counts[sites[j]] {
+			return counts[sites[i]] > counts[sites[j]]
+		}
+		return sites[i] < sites[j]
+	})
+	if len(sites) > maxSitesPerSuggestion {
+		sites = sites[:maxSitesPerSuggestion]
+	}
+	return sites
+}
+
+func (t *Tracker) evictOldestPairLocked() {
+	var oldest pairKey
+	var oldestAt time.Time
+	first := true
+	for k, p := range t.pairs {
+		if first || p.last.Before(oldestAt) {
+			oldest, oldestAt, first = k, p.last, false
+		}
+	}
+	delete(t.pairs, oldest)
+}
+
+func (t *Tracker) evictOldestVisitLocked() {
+	var oldest string
+	var oldestAt time.Time
+	first := true
+	for ip, v := range t.visits {
+		if first || v.at.Before(oldestAt) {
+			oldest, oldestAt, first = ip, v.at, false
+		}
+	}
+	delete(t.visits, oldest)
+}
+
+// refererHost extracts the lowercased hostname from a Referer value.
+func refererHost(referer string) string {
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	host := u.Host
+	if h, _, splitErr := net.SplitHostPort(host); splitErr == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,109 @@
+package suggest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// _newTestTracker returns a tracker with a controllable clock.
+func _newTestTracker(cfg Config) (*Tracker, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	t := NewTracker(cfg)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestRefererAttributedBlocksBecomeSuggestion(t *testing.T) {
+	tr, _ := _newTestTracker(Config{MinBlocks: 3})
+
+	for range 3 {
+		tr.RecordBlocked("10.0.0.1", "CDN.Example.net", "https://news.example.com/article?id=1")
+	}
+	tr.RecordBlocked("10.0.0.1", "ads.example.org", "https://news.example.com/")
+
+	got := tr.Suggestions()
+	require.Len(t, got, 1)
+	assert.Equal(t, "cdn.example.net", got[0].Domain)
+	assert.Equal(t, int64(3), got[0].Blocks)
+	assert.Equal(t, []string{"news.example.com"}, got[0].Sites)
+}
+
+func TestHeaderlessBlocksUseRecentVisitWithinWindow(t *testing.T) {
+	tr, now := _newTestTracker(Config{MinBlocks: 2, Window: 5 * time.Second})
+
+	tr.RecordAllowed("10.0.0.1", "video.example.com")
+	*now = now.Add(time.Second)
+	tr.RecordBlocked("10.0.0.1", "player.example.net", "")
+	tr.RecordBlocked("10.0.0.1", "player.example.net", "")
+
+	// Outside the window, and from a client with no visit: not attributed.
+	*now = now.Add(10 * time.Second)
+	tr.RecordBlocked("10.0.0.1", "late.example.net", "")
+	tr.RecordBlocked("10.0.0.1", "late.example.net", "")
+	tr.RecordBlocked("10.0.0.2", "other.example.net", "")
+	tr.RecordBlocked("10.0.0.2", "other.example.net", "")
+
+	got := tr.Suggestions()
+	require.Len(t, got, 1)
+	assert.Equal(t, "player.example.net", got[0].Domain)
+	assert.Equal(t, []string{"video.example.com"}, got[0].Sites)
+}
+
+func TestSelfReferencedBlocksIgnored(t *testing.T) {
+	tr, _ := _newTestTracker(Config{MinBlocks: 1})
+
+	tr.RecordAllowed("10.0.0.1", "ads.example.com")
+	tr.RecordBlocked("10.0.0.1", "ads.example.com", "")
+	tr.RecordBlocked("10.0.0.1", "ads.example.com", "http://ads.example.com:8080/")
+
+	assert.Empty(t, tr.Suggestions())
+}
+
+func TestSuggestionsSortedAndSitesAggregated(t *testing.T) {
+	tr, _ := _newTestTracker(Config{MinBlocks: 1})
+
+	tr.RecordBlocked("10.0.0.1", "a.example.net", "https://one.example.com/")
+	for i := range 3 {
+		tr.RecordBlocked("10.0.0.1", "b.example.net", fmt.Sprintf("https://site%d.example.com/", i))
+	}
+	tr.RecordBlocked("10.0.0.1", "b.example.net", "https://site2.example.com/")
+
+	got := tr.Suggestions()
+	require.Len(t, got, 2)
+	assert.Equal(t, "b.example.net", got[0].Domain)
+	assert.Equal(t, int64(4), got[0].Blocks)
+	assert.Equal(t, "site2.example.com", got[0].Sites[0])
+	assert.Len(t, got[0].Sites, 3)
+	assert.Equal(t, "a.example.net", got[1].Domain)
+}
+
+func TestMemoryBounded(t *testing.T) {
+	tr, now := _newTestTracker(Config{MinBlocks: 1, MaxPairs: 10, MaxClients: 4})
+
+	for i := range 100 {
+		*now = now.Add(time.Millisecond)
+		tr.RecordAllowed(fmt.Sprintf("10.0.0.%d", i), "site.example.com")
+		tr.RecordBlocked("10.0.0.1", fmt.Sprintf("d%d.example.net", i), "https://site.example.com/")
+	}
+
+	assert.Len(t, tr.pairs, 10)
+	assert.Len(t, tr.visits, 4)
+
+	// The most recent entries survive eviction.
+	_, ok := tr.pairs[pairKey{site: "site.example.com", blocked: "d99.example.net"}]
+	assert.True(t, ok)
+	_, ok = tr.visits["10.0.0.99"]
+	assert.True(t, ok)
+}
+
+func TestDefaults(t *testing.T) {
+	tr := NewTracker(Config{})
+	assert.Equal(t, DefaultMinBlocks, tr.minBlocks)
+	assert.Equal(t, DefaultWindow, tr.window)
+	assert.Equal(t, DefaultMaxPairs, tr.maxPairs)
+	assert.Equal(t, DefaultMaxClients, tr.maxClients)
+}
@@ -9,10 +9,15 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/ushineko/face-puncher-supreme/internal/headers"
+	"github.com/ushineko/face-puncher-supreme/internal/session"
+	"github.com/ushineko/face-puncher-supreme/internal/upstream"
 )
 
 // Blocker checks whether a domain should be blocked.
@@ -37,10 +42,22 @@ type Config struct {
 	Blocker         Blocker
 	MITMInterceptor MITMInterceptor
 	ConnectTimeout  time.Duration
+	Dialer          *upstream.Dialer // nil uses the system resolver
+	Passthrough     *atomic.Bool     // when true, blocking and MITM are skipped
+
+	// Extra headers to strip on forward/response (beyond hop-by-hop).
+	StripRequestHeaders  []string
+	StripResponseHeaders []string
+	StripCookies         []*regexp.Regexp // cookie names to strip both ways
+	StrictContentLength  bool             // buffer declared-length bodies and abort short ones
 
 	// Stats callbacks — same interface as the explicit proxy.
 	OnRequest     func(clientIP, domain string, blocked bool, bytesIn, bytesOut int64)
 	OnTunnelClose func(clientIP string, bytesIn, bytesOut int64)
+	OnTunnelBytes func(clientIP string, n int64)         // live tunnel bytes, as relayed
+	OnBlock       func(clientIP, domain, referer string) // referer is empty for HTTPS
+	OnConnOpen    func(clientIP string)
+	OnConnClose   func(clientIP string)
 
 	// Transparent-specific stats.
 	OnTransparentHTTP  func()
@@ -57,6 +74,10 @@ type Listener struct {
 	logger        *slog.Logger
 	verbose       bool
 	cfg           *Config
+	headers       *headers.Stripper
+
+	// sessions lists open HTTPS tunnels and MITM sessions.
+	sessions *session.Registry
 
 	wg sync.WaitGroup
 }
@@ -69,13 +90,24 @@ func New(cfg *Config) *Listener {
 	if cfg.ConnectTimeout <= 0 {
 		cfg.ConnectTimeout = 10 * time.Second
 	}
+	stripper := headers.NewStripper(cfg.StripRequestHeaders, cfg.StripResponseHeaders, cfg.StripCookies)
+	if cfg.Verbose {
+		stripper.SetLogger(cfg.Logger)
+	}
 	return &Listener{
-		logger:  cfg.Logger,
-		verbose: cfg.Verbose,
-		cfg:     cfg,
+		logger:   cfg.Logger,
+		verbose:  cfg.Verbose,
+		cfg:      cfg,
+		headers:  stripper,
+		sessions: session.NewRegistry("transparent"),
 	}
 }
 
+// Sessions lists the open HTTPS tunnels and MITM sessions, oldest first.
+func (l *Listener) Sessions() []session.Info {
+	return l.sessions.List()
+}
+
 // ListenAndServe starts the transparent HTTP and/or HTTPS listeners.
 // Blocks until both listeners are closed.
 func (l *Listener) ListenAndServe() error {
@@ -132,6 +164,12 @@ func (l *Listener) Shutdown(_ context.Context) {
 	}
 }
 
+// filtering reports whether blocking and MITM apply (the passthrough kill
+// switch is off).
+func (l *Listener) filtering() bool {
+	return l.cfg.Passthrough == nil || !l.cfg.Passthrough.Load()
+}
+
 // acceptHTTP accepts connections on the transparent HTTP listener.
 func (l *Listener) acceptHTTP(ln net.Listener) {
 	for {
@@ -160,11 +198,27 @@ func (l *Listener) acceptHTTPS(ln net.Listener) {
 	}
 }
 
+// connOpened reports a client connection opened via OnConnOpen.
+func (l *Listener) connOpened(clientIP string) {
+	if l.cfg.OnConnOpen != nil {
+		l.cfg.OnConnOpen(clientIP)
+	}
+}
+
+// connClosed reports a client connection closed via OnConnClose.
+func (l *Listener) connClosed(clientIP string) {
+	if l.cfg.OnConnClose != nil {
+		l.cfg.OnConnClose(clientIP)
+	}
+}
+
 // handleHTTP handles a transparent HTTP connection.
 func (l *Listener) handleHTTP(conn net.Conn) {
 	defer conn.Close() //nolint:errcheck // best-effort close
 
 	clientIP := stripPort(conn.RemoteAddr().String())
+	l.connOpened(clientIP)
+	defer l.connClosed(clientIP)
 
 	// Read the HTTP request. In transparent mode, it arrives with a relative
 	// URI (e.g., GET /path HTTP/1.1) and a Host header.
@@ -190,12 +244,15 @@ func (l *Listener) handleHTTP(conn net.Conn) {
 	domain := stripPort(host)
 
 	// Blocklist check.
-	if l.cfg.Blocker != nil && l.cfg.Blocker.IsBlocked(domain) {
+	if l.filtering() && l.cfg.Blocker != nil && l.cfg.Blocker.IsBlocked(domain) {
 		writeHTTPError(conn, http.StatusForbidden, "blocked by proxy")
 		l.logger.Info("transparent blocked", "domain", domain, "remote", clientIP, "proto", "http")
 		if l.cfg.OnRequest != nil {
 			l.cfg.OnRequest(clientIP, domain, true, 0, 0)
 		}
+		if l.cfg.OnBlock != nil {
+			l.cfg.OnBlock(clientIP, domain, req.Header.Get("Referer"))
+		}
 		if l.cfg.OnTransparentBlock != nil {
 			l.cfg.OnTransparentBlock()
 		}
@@ -207,23 +264,23 @@ func (l *Listener) handleHTTP(conn net.Conn) {
 	}
 
 	// Determine upstream address. Use original port 80 by default.
-	upstream := host
-	if !strings.Contains(upstream, ":") {
-		upstream += ":80"
+	upstreamAddr := host
+	if !strings.Contains(upstreamAddr, ":") {
+		upstreamAddr += ":80"
 	}
 
 	// Dial upstream.
-	upConn, err := net.DialTimeout("tcp", upstream, l.cfg.ConnectTimeout)
+	upConn, err := l.cfg.Dialer.DialTimeout("tcp", upstreamAddr, l.cfg.ConnectTimeout)
 	if err != nil {
 		writeHTTPError(conn, http.StatusBadGateway, "upstream connection failed")
 		l.logger.Error("transparent http dial failed",
-			"domain", domain, "upstream", upstream, "remote", clientIP, "error", err)
+			"domain", domain, "upstream", upstreamAddr, "remote", clientIP, "error", err)
 		return
 	}
 	defer upConn.Close() //nolint:errcheck // best-effort close
 
 	// Forward the request.
-	removeHopByHopHeaders(req.Header)
+	l.headers.StripRequest(req.Header, domain)
 	if writeErr := req.Write(upConn); writeErr != nil {
 		l.logger.Error("transparent http request write failed",
 			"domain", domain, "remote", clientIP, "error", writeErr)
@@ -239,7 +296,16 @@ func (l *Listener) handleHTTP(conn net.Conn) {
 	}
 	defer resp.Body.Close() //nolint:errcheck // best-effort close
 
-	removeHopByHopHeaders(resp.Header)
+	l.headers.StripResponse(resp.Header, domain)
+	if l.cfg.StrictContentLength {
+		if _, lenErr := headers.BufferDeclared(resp, headers.StrictLengthLimit); lenErr != nil {
+			// Close without a response rather than relay a truncated
+			// body as complete.
+			l.logger.Warn("transparent http response truncated, aborting",
+				"domain", domain, "remote", clientIP, "error", lenErr)
+			return
+		}
+	}
 
 	// Write response to client.
 	if writeErr := resp.Write(conn); writeErr != nil {
@@ -274,6 +340,8 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 	defer conn.Close() //nolint:errcheck // best-effort close
 
 	clientIP := stripPort(conn.RemoteAddr().String())
+	l.connOpened(clientIP)
+	defer l.connClosed(clientIP)
 
 	// Peek at the TLS ClientHello to extract SNI.
 	serverName, peeked, err := peekClientHello(conn)
@@ -310,21 +378,13 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 			"remote", clientIP, "origdst", upstreamHost)
 	}
 
-	// Blocklist check.
-	if l.cfg.Blocker != nil && l.cfg.Blocker.IsBlocked(domain) {
-		// No HTTP layer — just close the connection.
-		l.logger.Info("transparent blocked", "domain", domain, "remote", clientIP, "proto", "https")
-		if l.cfg.OnRequest != nil {
-			l.cfg.OnRequest(clientIP, domain, true, 0, 0)
-		}
-		if l.cfg.OnTransparentBlock != nil {
-			l.cfg.OnTransparentBlock()
-		}
+	// Blocklist check. No HTTP layer — just close the connection.
+	if l.blockHTTPS(clientIP, domain) {
 		return
 	}
 
 	// MITM interception.
-	if serverName != "" && l.cfg.MITMInterceptor != nil && l.cfg.MITMInterceptor.IsMITMDomain(domain) {
+	if l.interceptHTTPS(serverName, domain) {
 		if l.cfg.OnTransparentMITM != nil {
 			l.cfg.OnTransparentMITM()
 		}
@@ -333,10 +393,13 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 		// Wrap conn to replay the peeked ClientHello bytes.
 		wrapped := newPrefixConn(conn, peeked)
 
+		sess := l.sessions.Open(conn, session.KindMITM, clientIP, domain)
+		defer l.sessions.Close(sess)
+
 		// Delegate to the MITM handler. It takes ownership and closes the conn.
 		// We must not close conn ourselves after this (defer close is harmless
 		// on an already-closed conn).
-		l.cfg.MITMInterceptor.Handle(wrapped, domain, upstreamHost, clientIP)
+		l.cfg.MITMInterceptor.Handle(sess.Conn(wrapped), domain, upstreamHost, clientIP)
 		return
 	}
 
@@ -345,7 +408,7 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 		l.cfg.OnTransparentTLS()
 	}
 
-	upConn, err := net.DialTimeout("tcp", upstreamHost, l.cfg.ConnectTimeout)
+	upConn, err := l.cfg.Dialer.DialTimeout("tcp", upstreamHost, l.cfg.ConnectTimeout)
 	if err != nil {
 		l.logger.Error("transparent tunnel dial failed",
 			"domain", domain, "upstream", upstreamHost, "remote", clientIP, "error", err)
@@ -366,6 +429,10 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 
 	l.logger.Info("transparent tunnel", "domain", domain, "remote", clientIP)
 
+	sess := l.sessions.Open(conn, session.KindTunnel, clientIP, domain)
+	defer l.sessions.Close(sess)
+	sess.ReportLive(l.cfg.OnTunnelBytes)
+
 	// Bidirectional byte copy.
 	var uploadBytes, downloadBytes atomic.Int64
 	var wg sync.WaitGroup
@@ -373,7 +440,7 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 
 	go func() {
 		defer wg.Done()
-		n, _ := io.Copy(upConn, conn) //nolint:errcheck // tunnel streaming
+		n, _ := io.Copy(sess.CountUpload(upConn), conn) //nolint:errcheck // tunnel streaming
 		uploadBytes.Store(n)
 		// Signal upstream we're done sending.
 		if tc, ok := upConn.(*net.TCPConn); ok {
@@ -383,7 +450,7 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 
 	go func() {
 		defer wg.Done()
-		n, _ := io.Copy(conn, upConn) //nolint:errcheck // tunnel streaming
+		n, _ := io.Copy(sess.CountDownload(conn), upConn) //nolint:errcheck // tunnel streaming
 		downloadBytes.Store(n)
 		if tc, ok := conn.(*net.TCPConn); ok {
 			_ = tc.CloseWrite()
@@ -405,6 +472,35 @@ func (l *Listener) handleHTTPS(conn net.Conn) {
 	}
 }
 
+// blockHTTPS reports whether a transparent HTTPS connection to domain is
+// blocked, recording the block if so. Nothing is blocked in passthrough
+// mode.
+func (l *Listener) blockHTTPS(clientIP, domain string) bool {
+	if !l.filtering() || l.cfg.Blocker == nil || !l.cfg.Blocker.IsBlocked(domain) {
+		return false
+	}
+	l.logger.Info("transparent blocked", "domain", domain, "remote", clientIP, "proto", "https")
+	if l.cfg.OnRequest != nil {
+		l.cfg.OnRequest(clientIP, domain, true, 0, 0)
+	}
+	if l.cfg.OnBlock != nil {
+		l.cfg.OnBlock(clientIP, domain, "")
+	}
+	if l.cfg.OnTransparentBlock != nil {
+		l.cfg.OnTransparentBlock()
+	}
+	return true
+}
+
+// interceptHTTPS reports whether a transparent HTTPS connection goes to
+// the MITM interceptor rather than being tunneled without inspection. That
+// needs an SNI name (to mint a leaf certificate), filtering enabled (not
+// passthrough), and a MITM domain.
+func (l *Listener) interceptHTTPS(serverName, domain string) bool {
+	return serverName != "" && l.filtering() &&
+		l.cfg.MITMInterceptor != nil && l.cfg.MITMInterceptor.IsMITMDomain(domain)
+}
+
 // writeHTTPError writes a simple HTTP error response to a raw connection.
 func writeHTTPError(conn net.Conn, statusCode int, msg string) {
 	resp := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
@@ -412,25 +508,6 @@ func writeHTTPError(conn net.Conn, statusCode int, msg string) {
 	_, _ = conn.Write([]byte(resp)) //nolint:gosec // best-effort error response
 }
 
-// hopByHopHeaders are headers that must not be forwarded by proxies.
-var hopByHopHeaders = []string{
-	"Connection",
-	"Keep-Alive",
-	"Proxy-Authenticate",
-	"Proxy-Authorization",
-	"TE",
-	"Trailers",
-	"Transfer-Encoding",
-	"Upgrade",
-}
-
-// removeHopByHopHeaders strips hop-by-hop headers from an HTTP header set.
-func removeHopByHopHeaders(h http.Header) {
-	for _, hdr := range hopByHopHeaders {
-		h.Del(hdr)
-	}
-}
-
 // stripPort removes the port from a host:port string.
 func stripPort(hostport string) string {
 	if idx := strings.LastIndex(hostport, ":"); idx >= 0 {
@@ -438,4 +515,3 @@ func stripPort(hostport string) string {
 	}
 	return hostport
 }
-
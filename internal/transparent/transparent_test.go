@@ -1,11 +1,16 @@
 package transparent
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
 	"encoding/binary"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -256,3 +261,40 @@ func TestExtractSNI_RealTLSClientHello(t *testing.T) {
 		t.Fatal("timeout waiting for TLS ClientHello")
 	}
 }
+
+func TestHandleHTTP_StripsConfiguredHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("X-Client-Data"), "configured request header should be stripped")
+		assert.Empty(t, r.Header.Get("Proxy-Connection"), "hop-by-hop header should be stripped")
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Real-Header", "kept")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	l := New(&Config{
+		Logger:               slog.New(slog.NewTextHandler(io.Discard, nil)),
+		StripRequestHeaders:  []string{"X-Client-Data"},
+		StripResponseHeaders: []string{"Server"},
+	})
+
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	go l.handleHTTP(proxySide)
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	req, err := http.NewRequest(http.MethodGet, "/", http.NoBody)
+	require.NoError(t, err)
+	req.Host = host
+	req.Header.Set("X-Client-Data", "tracking")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	require.NoError(t, req.Write(clientSide))
+
+	resp, err := http.ReadResponse(bufio.NewReader(clientSide), req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Server"))
+	assert.Equal(t, "kept", resp.Header.Get("X-Real-Header"))
+}
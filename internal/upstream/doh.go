@@ -0,0 +1,130 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxDNSMessage is the largest DNS message that fits a TCP length prefix.
+const maxDNSMessage = 65535
+
+// newDoHResolver returns a resolver that sends queries to a DNS-over-HTTPS
+// endpoint. The Go resolver speaks DNS-over-TCP framing (2-byte length
+// prefix) to any conn that is not a net.PacketConn; dohConn turns each
+// framed query into an RFC 8484 POST.
+func newDoHResolver(endpoint string, client *http.Client) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return &dohConn{ctx: ctx, endpoint: endpoint, client: client}, nil
+		},
+	}
+}
+
+// dohConn is an in-memory net.Conn that answers each length-prefixed DNS
+// query written to it with the DoH server's response.
+type dohConn struct {
+	ctx      context.Context
+	endpoint string
+	client   *http.Client
+
+	mu       sync.Mutex
+	wbuf     bytes.Buffer
+	rbuf     bytes.Buffer
+	deadline time.Time
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.wbuf.Write(p)
+	for c.wbuf.Len() >= 2 {
+		n := int(binary.BigEndian.Uint16(c.wbuf.Bytes()))
+		if c.wbuf.Len() < 2+n {
+			break
+		}
+		c.wbuf.Next(2)
+		query := bytes.Clone(c.wbuf.Next(n))
+
+		answer, err := c.exchange(query)
+		if err != nil {
+			return 0, err
+		}
+		var prefix [2]byte
+		binary.BigEndian.PutUint16(prefix[:], uint16(len(answer))) //nolint:gosec // bounded by maxDNSMessage
+		c.rbuf.Write(prefix[:])
+		c.rbuf.Write(answer)
+	}
+	return len(p), nil
+}
+
+func (c *dohConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(p)
+}
+
+// exchange POSTs one DNS query and returns the response message.
+func (c *dohConn) exchange(query []byte) ([]byte, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("doh request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doh query: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // response body close in defer
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh query: status %d", resp.StatusCode)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessage+1))
+	if err != nil {
+		return nil, fmt.Errorf("doh response: %w", err)
+	}
+	if len(answer) > maxDNSMessage {
+		return nil, fmt.Errorf("doh response exceeds %d bytes", maxDNSMessage)
+	}
+	return answer, nil
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr{} }
+func (c *dohConn) SetReadDeadline(time.Time) error    { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// dohAddr is the placeholder address of a dohConn.
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+func (dohAddr) String() string  { return "doh" }
@@ -0,0 +1,157 @@
+package upstream
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// FallbackDirect is the fallback spec for a direct connection using the
+// system resolver.
+const FallbackDirect = "direct"
+
+// Fallback is a secondary path to upstream servers, tried when the primary
+// dial fails: either a direct connection via the system resolver (useful
+// when a configured resolver is down) or an HTTP proxy.
+type Fallback struct {
+	proxyURL  *url.URL // nil = direct
+	proxyAuth string   // Proxy-Authorization value for CONNECT ("" = none)
+	transport *http.Transport
+}
+
+// NewFallback parses a fallback spec: "" (no fallback, returns nil),
+// "direct", or an http:// proxy URL without credentials.
+func NewFallback(spec string) (*Fallback, error) {
+	return NewFallbackAuth(spec, "", "")
+}
+
+// NewFallbackAuth is NewFallback for a proxy that requires Basic
+// authentication. The credentials go only to the proxy (on CONNECT and
+// on forwarded plain HTTP requests), never to the destination. An empty
+// user sends no credentials; they are rejected for a direct fallback.
+func NewFallbackAuth(spec, user, pass string) (*Fallback, error) {
+	spec = strings.TrimSpace(spec)
+	if user != "" && (spec == "" || spec == FallbackDirect) {
+		return nil, fmt.Errorf("proxy credentials need an http:// proxy fallback, got %q", spec)
+	}
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == FallbackDirect:
+		t := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck,forcetypeassert // DefaultTransport is always *http.Transport
+		t.Proxy = nil
+		return &Fallback{transport: t}, nil
+	default:
+		u, err := url.Parse(spec)
+		if err != nil || u.Scheme != "http" || u.Host == "" {
+			return nil, fmt.Errorf("invalid fallback %q: want %q or an http:// proxy URL", spec, FallbackDirect)
+		}
+		if u.User != nil {
+			// URL credentials would reach plain HTTP requests but not CONNECT.
+			return nil, fmt.Errorf("invalid fallback %q: pass proxy credentials separately, not in the URL", u.Redacted())
+		}
+		f := &Fallback{proxyURL: u}
+		proxyURL := u
+		if user != "" {
+			withAuth := *u
+			withAuth.User = url.UserPassword(user, pass)
+			proxyURL = &withAuth
+			f.proxyAuth = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone() //nolint:errcheck,forcetypeassert // DefaultTransport is always *http.Transport
+		t.Proxy = http.ProxyURL(proxyURL)
+		f.transport = t
+		return f, nil
+	}
+}
+
+// String returns "direct" or the fallback proxy's host:port.
+func (f *Fallback) String() string {
+	if f.proxyURL == nil {
+		return FallbackDirect
+	}
+	return f.proxyURL.Host
+}
+
+// Transport returns the round tripper for plain HTTP forwarding via the
+// fallback path.
+func (f *Fallback) Transport() http.RoundTripper {
+	return f.transport
+}
+
+// DialTimeout opens a TCP stream to address via the fallback path. For a
+// proxy fallback the stream is a CONNECT tunnel through the proxy.
+func (f *Fallback) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	if f.proxyURL == nil {
+		return net.DialTimeout(network, address, timeout)
+	}
+
+	conn, err := net.DialTimeout(network, f.proxyURL.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if f.proxyAuth != "" {
+		req.Header.Set("Proxy-Authorization", f.proxyAuth)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("fallback proxy connect: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("fallback proxy connect: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("fallback proxy connect: %s", resp.Status)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn replays bytes read past the CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// IsDialError reports whether err means the upstream connection could not
+// be established (nothing was sent), so the request is safe to retry via
+// a fallback path.
+func IsDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial" || opErr.Op == "proxyconnect"
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Package upstream provides the dialer shared by every forwarding path
+(explicit proxy, transparent listener, MITM) for connections to upstream
+servers.
+
+By default upstream hostnames are resolved by the system resolver. An
+operator can instead point resolution at a specific DNS server
+("10.0.0.1:53") or a DNS-over-HTTPS endpoint ("https://dns.example/dns-query"),
+e.g. for split-horizon DNS or to bypass an untrusted system resolver. This
+is independent of blocklist-based blocking.
+*/
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Dialer dials upstream connections. A nil *Dialer uses the system resolver.
+type Dialer struct {
+	resolver *net.Resolver
+}
+
+// NewDialer creates a Dialer that resolves hostnames via spec: "" for the
+// system resolver, "host:port" (or a bare IP, port 53) for a plain DNS
+// server, or an https:// URL for DNS-over-HTTPS (RFC 8484).
+func NewDialer(spec string) (*Dialer, error) {
+	resolver, err := NewResolver(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer{resolver: resolver}, nil
+}
+
+// NewResolver builds the net.Resolver for spec (see NewDialer). It returns
+// nil for an empty spec.
+func NewResolver(spec string) (*net.Resolver, error) {
+	addr, doh, err := ParseResolver(spec)
+	switch {
+	case err != nil:
+		return nil, err
+	case addr == "":
+		return nil, nil
+	case doh:
+		return newDoHResolver(addr, &http.Client{Timeout: 10 * time.Second}), nil
+	default:
+		return newDNSResolver(addr), nil
+	}
+}
+
+// ParseResolver checks a resolver spec (see NewDialer) and returns the DoH
+// URL (doh true) or the plain DNS server as "ip:port". An empty spec
+// returns an empty addr. Config validation uses it as well.
+func ParseResolver(spec string) (addr string, doh bool, err error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return "", false, nil
+	case strings.HasPrefix(spec, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return "", false, fmt.Errorf("invalid DoH resolver URL %q", spec)
+		}
+		return spec, true, nil
+	case net.ParseIP(spec) != nil:
+		return net.JoinHostPort(spec, "53"), false, nil
+	}
+	host, port, err := net.SplitHostPort(spec)
+	if err != nil || net.ParseIP(host) == nil || port == "" {
+		return "", false, fmt.Errorf("invalid resolver %q: want ip, ip:port, or an https:// DoH URL", spec)
+	}
+	return spec, false, nil
+}
+
+// newDNSResolver returns a resolver that sends every query to addr.
+func newDNSResolver(addr string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Resolver returns the custom resolver, or nil for the system resolver.
+func (d *Dialer) Resolver() *net.Resolver {
+	if d == nil {
+		return nil
+	}
+	return d.resolver
+}
+
+// DialTimeout is like net.DialTimeout but resolves through the configured
+// resolver.
+func (d *Dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	nd := net.Dialer{Timeout: timeout, Resolver: d.Resolver()}
+	return nd.Dial(network, address)
+}
+
+// DialContext dials through the configured resolver with the defaults of
+// http.DefaultTransport (30s timeout and keep-alive). It fits
+// http.Transport.DialContext.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	nd := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: d.Resolver()}
+	return nd.DialContext(ctx, network, address)
+}
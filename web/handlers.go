@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // handleLogin validates credentials and creates a session.
@@ -77,6 +78,33 @@ func (s *DashboardServer) handleConfig(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write(data) //nolint:errcheck // best-effort response
 }
 
+// handleConfigSnapshot returns the running allowlist, blocklist, and plugin
+// state as a YAML fragment for merging back into fpsd.yml.
+func (s *DashboardServer) handleConfigSnapshot(w http.ResponseWriter, _ *http.Request) {
+	data, err := s.snapshotFn()
+	if err != nil {
+		s.logger.Error("failed to snapshot config", "error", err)
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="fpsd-snapshot.yml"`)
+	_, _ = w.Write(data) //nolint:errcheck // best-effort response
+}
+
+// handleSessions lists open CONNECT tunnels and MITM sessions with their
+// live byte counts.
+func (s *DashboardServer) handleSessions(w http.ResponseWriter, _ *http.Request) {
+	data, err := s.sessionsFn()
+	if err != nil {
+		s.logger.Error("failed to list sessions", "error", err)
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data) //nolint:errcheck // best-effort response
+}
+
 // handleLogs returns recent log entries from the circular buffer.
 // Query params: n (max entries, default 100, max 1000), level (min level, default INFO).
 func (s *DashboardServer) handleLogs(w http.ResponseWriter, r *http.Request) {
@@ -90,19 +118,63 @@ func (s *DashboardServer) handleLogs(w http.ResponseWriter, r *http.Request) {
 		n = 1000
 	}
 
-	minLevel := slog.LevelInfo
-	if lvl := r.URL.Query().Get("level"); lvl != "" {
-		switch lvl {
-		case "debug", "DEBUG":
-			minLevel = slog.LevelDebug
-		case "warn", "WARN":
-			minLevel = slog.LevelWarn
-		case "error", "ERROR":
-			minLevel = slog.LevelError
-		}
-	}
+	minLevel, _ := parseLevelParam(r.URL.Query().Get("level"))
 
 	entries := s.logBuffer.Recent(n, minLevel)
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(entries) //nolint:errcheck // best-effort response
 }
+
+// handleLogStream streams new log entries as JSON lines until the client
+// disconnects. Query param: level (min level, default INFO). Entries are
+// dropped for a client that reads too slowly; logging never blocks.
+func (s *DashboardServer) handleLogStream(w http.ResponseWriter, r *http.Request) {
+	minLevel, ok := parseLevelParam(r.URL.Query().Get("level"))
+	if !ok {
+		http.Error(w, `{"error":"level must be debug, info, warn, or error"}`, http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, `{"error":"streaming not supported"}`, http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.logBuffer.Subscribe(minLevel)
+	defer s.logBuffer.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry := <-sub.C:
+			if err := enc.Encode(entry); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseLevelParam parses a level query value. Empty means INFO; ok is
+// false for unknown values (which also yield INFO).
+func parseLevelParam(lvl string) (level slog.Level, ok bool) {
+	switch strings.ToLower(lvl) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	default:
+		return slog.LevelInfo, false
+	}
+}
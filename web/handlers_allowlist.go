@@ -0,0 +1,78 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
+)
+
+// handleAllowlistList returns all allowlist entries with their source.
+func (s *DashboardServer) handleAllowlistList(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.blocklistDB.Allowlist()) //nolint:errcheck // best-effort response
+}
+
+// handleAllowlistAdd adds a managed allowlist entry. Takes effect
+// immediately and is persisted to the managed allowlist file.
+func (s *DashboardServer) handleAllowlistAdd(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Entry string `json:"entry"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := blocklist.NormalizeAllowEntry(body.Entry); err != nil {
+		http.Error(w, `{"error":"invalid entry: want example.com or *.example.com"}`, http.StatusBadRequest)
+		return
+	}
+
+	entry, err := s.blocklistDB.AddAllowEntry(body.Entry)
+	switch {
+	case errors.Is(err, blocklist.ErrAllowEntryExists):
+		http.Error(w, `{"error":"entry already in allowlist"}`, http.StatusConflict)
+		return
+	case err != nil:
+		s.logger.Error("allowlist add failed", "entry", entry, "error", err)
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("allowlist entry added", "entry", entry)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(blocklist.AllowEntry{ //nolint:errcheck // best-effort response
+		Entry:  entry,
+		Source: blocklist.AllowSourceManaged,
+	})
+}
+
+// handleAllowlistDelete removes a managed allowlist entry. Entries from the
+// config file must be removed there.
+func (s *DashboardServer) handleAllowlistDelete(w http.ResponseWriter, r *http.Request) {
+	entry := r.PathValue("entry")
+	if _, err := blocklist.NormalizeAllowEntry(entry); err != nil {
+		http.Error(w, `{"error":"invalid entry"}`, http.StatusBadRequest)
+		return
+	}
+
+	err := s.blocklistDB.RemoveAllowEntry(entry)
+	switch {
+	case errors.Is(err, blocklist.ErrAllowEntryNotFound):
+		http.Error(w, `{"error":"entry not found"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, blocklist.ErrAllowEntryFromConfig):
+		http.Error(w, `{"error":"entry is defined in the config file"}`, http.StatusConflict)
+		return
+	case err != nil:
+		s.logger.Error("allowlist delete failed", "entry", entry, "error", err)
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("allowlist entry removed", "entry", entry)
+	w.WriteHeader(http.StatusNoContent)
+}
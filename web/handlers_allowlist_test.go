@@ -0,0 +1,186 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
+)
+
+func testAllowlistDashboard(t *testing.T) (*DashboardServer, *blocklist.DB, string) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	bl, err := blocklist.Open(":memory:", logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = bl.Close() })
+
+	bl.AddInlineDomains([]string{"ads.example.com", "cdn.tracker.com"})
+	bl.SetAllowlist([]string{"registry.api.cnn.io"})
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	require.NoError(t, bl.LoadManagedAllowlist(path))
+
+	return &DashboardServer{prefix: "/fps", blocklistDB: bl, logger: logger}, bl, path
+}
+
+func allowlistEntries(t *testing.T, s *DashboardServer) []blocklist.AllowEntry {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.handleAllowlistList(w, httptest.NewRequest("GET", "/fps/api/allowlist", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var entries []blocklist.AllowEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	return entries
+}
+
+func TestHandleBlocklistSourceEnable(t *testing.T) {
+	s, bl, _ := testAllowlistDashboard(t)
+	require.NoError(t, bl.Update([]string{"http://list"}, blocklist.FetchFunc(func(string) ([]string, error) {
+		return []string{"listed.example.com"}, nil
+	})))
+	require.True(t, bl.IsBlocked("listed.example.com"))
+
+	toggle := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleBlocklistSourceEnable(w, httptest.NewRequest("POST", "/fps/api/blocklist/sources?"+query, nil))
+		return w
+	}
+
+	w := toggle("url=http://list&enabled=false")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"url":"http://list","enabled":false}`, w.Body.String())
+	assert.False(t, bl.IsBlocked("listed.example.com"))
+	assert.True(t, bl.IsBlocked("ads.example.com"), "inline domains unaffected")
+
+	assert.Equal(t, http.StatusNotFound, toggle("url=http://other&enabled=false").Code)
+	assert.Equal(t, http.StatusBadRequest, toggle("url=http://list&enabled=maybe").Code)
+	assert.Equal(t, http.StatusBadRequest, toggle("enabled=true").Code)
+}
+
+func TestHandleTemporaryBlockAllow(t *testing.T) {
+	s, bl, _ := testAllowlistDashboard(t)
+	post := func(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("POST", "/fps/api/block", bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := post(s.handleTemporaryAllow, `{"domain":"ADS.example.com","ttl":"10m"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Domain  string    `json:"domain"`
+		Action  string    `json:"action"`
+		Expires time.Time `json:"expires"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "ads.example.com", resp.Domain)
+	assert.Equal(t, "allowed", resp.Action)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), resp.Expires, time.Minute)
+	assert.False(t, bl.IsBlocked("ads.example.com"))
+
+	w = post(s.handleTemporaryBlock, `{"domain":"news.example.com","ttl":"1h"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, bl.IsBlocked("news.example.com"))
+
+	assert.Equal(t, http.StatusBadRequest, post(s.handleTemporaryBlock, `{"domain":"*.example.com","ttl":"1h"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(s.handleTemporaryBlock, `{"domain":"example.com","ttl":"-5m"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(s.handleTemporaryBlock, `{"domain":"example.com"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(s.handleTemporaryBlock, `not json`).Code)
+
+	w = httptest.NewRecorder()
+	s.handleTemporaryList(w, httptest.NewRequest("GET", "/fps/api/temporary", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []struct {
+		Domain    string `json:"domain"`
+		Action    string `json:"action"`
+		Remaining int64  `json:"remaining_seconds"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 2)
+	assert.Equal(t, "ads.example.com", list[0].Domain, "soonest expiry first")
+	assert.Equal(t, "allowed", list[0].Action)
+	assert.InDelta(t, 600, list[0].Remaining, 60)
+	assert.Equal(t, "blocked", list[1].Action)
+}
+
+func TestHandleAllowlistAddRemove(t *testing.T) {
+	s, bl, path := testAllowlistDashboard(t)
+	assert.True(t, bl.IsBlocked("ads.example.com"))
+
+	w := httptest.NewRecorder()
+	s.handleAllowlistAdd(w, httptest.NewRequest("POST", "/fps/api/allowlist", bytes.NewBufferString(`{"entry":"ADS.example.com"}`)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"entry":"ads.example.com","source":"managed"}`, w.Body.String())
+
+	// Effective immediately.
+	assert.False(t, bl.IsBlocked("ads.example.com"))
+	assert.Equal(t, []blocklist.AllowEntry{
+		{Entry: "registry.api.cnn.io", Source: blocklist.AllowSourceConfig},
+		{Entry: "ads.example.com", Source: blocklist.AllowSourceManaged},
+	}, allowlistEntries(t, s))
+
+	// Persisted to the managed file.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "ads.example.com\n")
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/fps/api/allowlist/ads.example.com", nil)
+	r.SetPathValue("entry", "ads.example.com")
+	s.handleAllowlistDelete(w, r)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	assert.True(t, bl.IsBlocked("ads.example.com"))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "ads.example.com")
+}
+
+func TestHandleAllowlistSuffixEntry(t *testing.T) {
+	s, bl, _ := testAllowlistDashboard(t)
+
+	w := httptest.NewRecorder()
+	s.handleAllowlistAdd(w, httptest.NewRequest("POST", "/fps/api/allowlist", bytes.NewBufferString(`{"entry":"*.tracker.com"}`)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.False(t, bl.IsBlocked("cdn.tracker.com"))
+}
+
+func TestHandleAllowlistErrors(t *testing.T) {
+	s, _, _ := testAllowlistDashboard(t)
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`not json`, http.StatusBadRequest},
+		{`{"entry":""}`, http.StatusBadRequest},
+		{`{"entry":"bad/entry"}`, http.StatusBadRequest},
+		{`{"entry":"ads.*.com"}`, http.StatusBadRequest},
+		{`{"entry":"registry.api.cnn.io"}`, http.StatusConflict},
+	} {
+		w := httptest.NewRecorder()
+		s.handleAllowlistAdd(w, httptest.NewRequest("POST", "/fps/api/allowlist", bytes.NewBufferString(tc.body)))
+		assert.Equal(t, tc.code, w.Code, tc.body)
+	}
+
+	for entry, code := range map[string]int{
+		"registry.api.cnn.io": http.StatusConflict,
+		"unknown.example.com": http.StatusNotFound,
+		"bad entry":           http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("DELETE", "/fps/api/allowlist/x", nil)
+		r.SetPathValue("entry", entry)
+		s.handleAllowlistDelete(w, r)
+		assert.Equal(t, code, w.Code, entry)
+	}
+}
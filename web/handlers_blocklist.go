@@ -0,0 +1,111 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
+)
+
+// handleBlocklistSourceEnable enables or disables one blocklist source
+// (?url=<source>&enabled=true|false). The source's domains stop (or start)
+// matching immediately; the setting persists in blocklist.db.
+func (s *DashboardServer) handleBlocklistSourceEnable(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if url == "" || err != nil {
+		http.Error(w, `{"error":"query parameters url and enabled (true or false) are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch err := s.blocklistDB.SetSourceEnabled(url, enabled); {
+	case errors.Is(err, blocklist.ErrSourceNotFound):
+		http.Error(w, `{"error":"source not found"}`, http.StatusNotFound)
+		return
+	case err != nil:
+		s.logger.Error("blocklist source toggle failed", "url", url, "error", err)
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"url": url, "enabled": enabled}) //nolint:errcheck // best-effort response
+}
+
+// handleTemporaryBlock blocks a domain for a limited time. See
+// handleTemporary.
+func (s *DashboardServer) handleTemporaryBlock(w http.ResponseWriter, r *http.Request) {
+	s.handleTemporary(w, r, "blocked", s.blocklistDB.BlockTemporarily)
+}
+
+// handleTemporaryAllow lets a domain through for a limited time. See
+// handleTemporary.
+func (s *DashboardServer) handleTemporaryAllow(w http.ResponseWriter, r *http.Request) {
+	s.handleTemporary(w, r, "allowed", s.blocklistDB.AllowTemporarily)
+}
+
+// handleTemporary reads {"domain": "example.com", "ttl": "10m"} and applies
+// set to it. The entry matches the exact domain, is kept in memory only,
+// and lapses after ttl (a Go duration).
+func (s *DashboardServer) handleTemporary(w http.ResponseWriter, r *http.Request, action string, set func(string, time.Duration)) {
+	var body struct {
+		Domain string `json:"domain"`
+		TTL    string `json:"ttl"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	domain, err := blocklist.NormalizeAllowEntry(body.Domain)
+	if err != nil || strings.HasPrefix(domain, "*.") {
+		http.Error(w, `{"error":"invalid domain: want example.com"}`, http.StatusBadRequest)
+		return
+	}
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil || ttl <= 0 {
+		http.Error(w, `{"error":"invalid ttl: want a positive duration such as 10m"}`, http.StatusBadRequest)
+		return
+	}
+
+	set(domain, ttl)
+	expires := time.Now().Add(ttl)
+	s.logger.Info("domain temporarily "+action, "domain", domain, "ttl", ttl)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{ //nolint:errcheck // best-effort response
+		"domain":  domain,
+		"action":  action,
+		"expires": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// handleTemporaryList returns the active temporary allows and blocks with
+// their expiry and remaining seconds, soonest expiry first.
+func (s *DashboardServer) handleTemporaryList(w http.ResponseWriter, _ *http.Request) {
+	type entry struct {
+		Domain    string `json:"domain"`
+		Action    string `json:"action"`
+		Expires   string `json:"expires"`
+		Remaining int64  `json:"remaining_seconds"`
+	}
+	now := time.Now()
+	out := []entry{}
+	for _, e := range s.blocklistDB.Temporary() {
+		action := "blocked"
+		if e.Allow {
+			action = "allowed"
+		}
+		out = append(out, entry{
+			Domain:    e.Domain,
+			Action:    action,
+			Expires:   e.Expires.UTC().Format(time.RFC3339),
+			Remaining: int64(e.Expires.Sub(now).Round(time.Second) / time.Second),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out) //nolint:errcheck // best-effort response
+}
@@ -0,0 +1,60 @@
+package web
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigSnapshotEndpoint(t *testing.T) {
+	s := &DashboardServer{
+		prefix:   "/fps",
+		sessions: newSessionStore(),
+		snapshotFn: func() ([]byte, error) {
+			return []byte("allowlist:\n    - cdn.example.com\n"), nil
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	s.mux = s.buildMux()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/fps/api/config/snapshot", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	token, err := s.sessions.create()
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/fps/api/config/snapshot?token="+token, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "allowlist:\n    - cdn.example.com\n", w.Body.String())
+}
+
+func TestSessionsEndpoint(t *testing.T) {
+	s := &DashboardServer{
+		prefix:   "/fps",
+		sessions: newSessionStore(),
+		sessionsFn: func() ([]byte, error) {
+			return []byte(`{"count":0,"sessions":[]}`), nil
+		},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	s.mux = s.buildMux()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/fps/sessions", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	token, err := s.sessions.create()
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/fps/sessions?token="+token, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"count":0,"sessions":[]}`, w.Body.String())
+}
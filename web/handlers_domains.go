@@ -0,0 +1,73 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxTestDomains bounds the number of domains in one test-domains request.
+const maxTestDomains = 1000
+
+// DomainVerdict is the dry-run classification of a single domain.
+type DomainVerdict struct {
+	Domain      string `json:"domain"`
+	Verdict     string `json:"verdict"` // "blocked", "mitm", "allowed", or "pass"
+	Blocklisted bool   `json:"blocklisted"`
+	Allowlisted bool   `json:"allowlisted"`
+	MITM        bool   `json:"mitm"`
+}
+
+// testDomain classifies a domain the way the proxy would, without touching
+// any counters. Blocking wins (the blocklist check runs before MITM), then
+// MITM interception, then an allowlist rescue of a blocklisted domain.
+func (s *DashboardServer) testDomain(domain string) DomainVerdict {
+	v := DomainVerdict{Domain: domain}
+	if s.domainCheckFn != nil {
+		v.Blocklisted, v.Allowlisted = s.domainCheckFn(domain)
+	}
+	if s.mitmDomainFn != nil {
+		v.MITM = s.mitmDomainFn(domain)
+	}
+
+	blocked := v.Blocklisted && !v.Allowlisted
+	switch {
+	case blocked:
+		v.Verdict = "blocked"
+	case v.MITM:
+		v.Verdict = "mitm"
+	case v.Blocklisted:
+		v.Verdict = "allowed"
+	default:
+		v.Verdict = "pass"
+	}
+	return v
+}
+
+// handleTestDomains classifies a JSON array of domains in one call.
+// Read-only: block/allow counters are not affected.
+func (s *DashboardServer) handleTestDomains(w http.ResponseWriter, r *http.Request) {
+	var domains []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&domains); err != nil {
+		http.Error(w, `{"error":"request body must be a JSON array of domains"}`, http.StatusBadRequest)
+		return
+	}
+	if len(domains) > maxTestDomains {
+		http.Error(w, fmt.Sprintf(`{"error":"too many domains (max %d)"}`, maxTestDomains), http.StatusBadRequest)
+		return
+	}
+
+	verdicts := make([]DomainVerdict, 0, len(domains))
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if d == "" {
+			http.Error(w, `{"error":"empty domain in list"}`, http.StatusBadRequest)
+			return
+		}
+		verdicts = append(verdicts, s.testDomain(d))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(verdicts) //nolint:errcheck // best-effort response
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
+)
+
+func testDomainsDashboard(t *testing.T) (*DashboardServer, *blocklist.DB) {
+	t.Helper()
+	bl, err := blocklist.Open(":memory:", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = bl.Close() })
+
+	bl.AddInlineDomains([]string{"ads.example.com", "tracker.example.com"})
+	bl.SetAllowlist([]string{"tracker.example.com"})
+
+	return &DashboardServer{
+		prefix:        "/fps",
+		domainCheckFn: bl.Check,
+		mitmDomainFn:  func(d string) bool { return d == "www.reddit.com" },
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, bl
+}
+
+func TestHandleTestDomains(t *testing.T) {
+	s, bl := testDomainsDashboard(t)
+
+	body := `["ads.example.com", "Tracker.Example.com", "www.reddit.com", "example.org."]`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/fps/api/test-domains", bytes.NewBufferString(body))
+	s.handleTestDomains(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var verdicts []DomainVerdict
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &verdicts))
+	assert.Equal(t, []DomainVerdict{
+		{Domain: "ads.example.com", Verdict: "blocked", Blocklisted: true},
+		{Domain: "tracker.example.com", Verdict: "allowed", Blocklisted: true, Allowlisted: true},
+		{Domain: "www.reddit.com", Verdict: "mitm", MITM: true},
+		{Domain: "example.org", Verdict: "pass"},
+	}, verdicts)
+
+	// Read-only: no block/allow counters touched.
+	assert.Equal(t, int64(0), bl.BlocksTotal())
+	assert.Equal(t, int64(0), bl.AllowsTotal())
+}
+
+func TestHandleTestDomainsRejectsBadInput(t *testing.T) {
+	s, _ := testDomainsDashboard(t)
+
+	tooMany := make([]string, maxTestDomains+1)
+	for i := range tooMany {
+		tooMany[i] = "example.com"
+	}
+	tooManyJSON, err := json.Marshal(tooMany)
+	require.NoError(t, err)
+
+	for name, body := range map[string]string{
+		"not an array": `{"domain":"example.com"}`,
+		"empty domain": `["example.com", " "]`,
+		"too many":     string(tooManyJSON),
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/fps/api/test-domains", strings.NewReader(body))
+		s.handleTestDomains(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+}
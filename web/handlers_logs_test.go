@@ -0,0 +1,67 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/logbuf"
+)
+
+func testLogStreamDashboard(t *testing.T) (*DashboardServer, *logbuf.Buffer, string) {
+	t.Helper()
+	buf := logbuf.New(100)
+	s := &DashboardServer{
+		prefix:    "/fps",
+		sessions:  newSessionStore(),
+		logBuffer: buf,
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	s.mux = s.buildMux()
+	token, err := s.sessions.create()
+	require.NoError(t, err)
+	return s, buf, token
+}
+
+func TestLogStreamFiltersByLevel(t *testing.T) {
+	s, buf, token := testLogStreamDashboard(t)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/fps/logs/stream?level=warn&token=" + token)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	// Headers arrive after the subscription is registered.
+	logger := slog.New(buf.Handler())
+	logger.Info("below threshold")
+	logger.Warn("upstream slow", "host", "example.com")
+
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	require.NoError(t, err)
+	var entry logbuf.Entry
+	require.NoError(t, json.Unmarshal(line, &entry))
+	assert.Equal(t, "WARN", entry.Level)
+	assert.Equal(t, "upstream slow", entry.Message)
+	assert.Equal(t, "example.com", entry.Attrs["host"])
+}
+
+func TestLogStreamRequiresAuthAndValidLevel(t *testing.T) {
+	s, _, token := testLogStreamDashboard(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/fps/logs/stream", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/fps/logs/stream?level=loud&token="+token, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,29 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// handlePassthrough turns the passthrough kill switch on or off
+// (?on=true|false). While on, blocking, MITM, and plugins are skipped.
+// The flag is in-memory and resets on restart.
+func (s *DashboardServer) handlePassthrough(w http.ResponseWriter, r *http.Request) {
+	on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+	if err != nil {
+		http.Error(w, `{"error":"query parameter on must be true or false"}`, http.StatusBadRequest)
+		return
+	}
+
+	if prev := s.passthrough.Swap(on); prev != on {
+		if on {
+			s.logger.Warn("passthrough forced: blocking, MITM, and plugins disabled")
+		} else {
+			s.logger.Info("passthrough released: filtering restored")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]bool{"passthrough": on}) //nolint:errcheck // best-effort response
+}
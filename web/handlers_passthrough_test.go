@@ -0,0 +1,50 @@
+package web
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePassthrough(t *testing.T) {
+	flag := new(atomic.Bool)
+	s := &DashboardServer{prefix: "/fps", passthrough: flag, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	w := httptest.NewRecorder()
+	s.handlePassthrough(w, httptest.NewRequest("POST", "/fps/api/passthrough?on=true", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"passthrough":true}`, w.Body.String())
+	assert.True(t, flag.Load())
+
+	w = httptest.NewRecorder()
+	s.handlePassthrough(w, httptest.NewRequest("POST", "/fps/api/passthrough?on=false", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"passthrough":false}`, w.Body.String())
+	assert.False(t, flag.Load())
+
+	w = httptest.NewRecorder()
+	s.handlePassthrough(w, httptest.NewRequest("POST", "/fps/api/passthrough", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, flag.Load())
+}
+
+func TestPassthroughRequiresAuth(t *testing.T) {
+	s := &DashboardServer{
+		prefix:      "/fps",
+		sessions:    newSessionStore(),
+		passthrough: new(atomic.Bool),
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	s.mux = s.buildMux()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/fps/api/passthrough?on=true", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, s.passthrough.Load())
+}
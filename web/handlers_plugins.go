@@ -0,0 +1,50 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ushineko/face-puncher-supreme/internal/plugin"
+)
+
+// handlePluginPausedList returns all paused (plugin, domain) pairs.
+func (s *DashboardServer) handlePluginPausedList(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.pluginPauses.Paused()) //nolint:errcheck // best-effort response
+}
+
+// handlePluginPause pauses a plugin for one of its domains.
+func (s *DashboardServer) handlePluginPause(w http.ResponseWriter, r *http.Request) {
+	s.setPluginPaused(w, r, true)
+}
+
+// handlePluginResume resumes a plugin for one of its domains.
+func (s *DashboardServer) handlePluginResume(w http.ResponseWriter, r *http.Request) {
+	s.setPluginPaused(w, r, false)
+}
+
+// setPluginPaused applies a pause or resume and responds with the updated
+// list of paused pairs.
+func (s *DashboardServer) setPluginPaused(w http.ResponseWriter, r *http.Request, pause bool) {
+	name, domain := r.PathValue("name"), r.PathValue("domain")
+
+	var err error
+	if pause {
+		err = s.pluginPauses.Pause(name, domain)
+	} else {
+		err = s.pluginPauses.Resume(name, domain)
+	}
+	if errors.Is(err, plugin.ErrUnknownPluginDomain) {
+		http.Error(w, `{"error":"plugin does not handle domain"}`, http.StatusNotFound)
+		return
+	}
+
+	action := "plugin domain resumed"
+	if pause {
+		action = "plugin domain paused"
+	}
+	s.logger.Info(action, "plugin", name, "domain", domain)
+
+	s.handlePluginPausedList(w, r)
+}
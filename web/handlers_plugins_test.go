@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ushineko/face-puncher-supreme/internal/plugin"
+)
+
+type namedFilter struct{ name string }
+
+func (f namedFilter) Name() string                                  { return f.name }
+func (f namedFilter) Version() string                               { return "1.0" }
+func (f namedFilter) Domains() []string                             { return nil }
+func (f namedFilter) Init(*plugin.PluginConfig, *slog.Logger) error { return nil }
+func (f namedFilter) Filter(_ *http.Request, _ *http.Response, body []byte) ([]byte, plugin.FilterResult, error) {
+	return body, plugin.FilterResult{}, nil
+}
+
+func TestHandlePluginPauseResume(t *testing.T) {
+	pauses := plugin.NewPauseSet([]plugin.InitResult{{
+		Plugin: namedFilter{name: "reddit-promotions"},
+		Config: plugin.PluginConfig{Domains: []string{"www.reddit.com", "gql-fed.reddit.com"}},
+	}})
+	s := &DashboardServer{
+		prefix:       "/fps",
+		pluginPauses: pauses,
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	do := func(handler http.HandlerFunc, name, domain string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/fps/api/plugins/x/domains/y/pause", nil)
+		r.SetPathValue("name", name)
+		r.SetPathValue("domain", domain)
+		handler(w, r)
+		return w
+	}
+
+	w := do(s.handlePluginPause, "reddit-promotions", "gql-fed.reddit.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	var paused []plugin.PausedPair
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &paused))
+	assert.Equal(t, []plugin.PausedPair{{Plugin: "reddit-promotions", Domain: "gql-fed.reddit.com"}}, paused)
+	assert.True(t, pauses.IsPaused("reddit-promotions", "gql-fed.reddit.com"))
+	assert.False(t, pauses.IsPaused("reddit-promotions", "www.reddit.com"))
+
+	assert.Equal(t, http.StatusNotFound, do(s.handlePluginPause, "reddit-promotions", "example.com").Code)
+	assert.Equal(t, http.StatusNotFound, do(s.handlePluginResume, "nope", "www.reddit.com").Code)
+
+	w = do(s.handlePluginResume, "reddit-promotions", "gql-fed.reddit.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
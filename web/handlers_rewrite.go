@@ -176,6 +176,62 @@ func (s *DashboardServer) handleRewriteTest(w http.ResponseWriter, r *http.Reque
 	_ = json.NewEncoder(w).Encode(resp) //nolint:errcheck // best-effort response
 }
 
+// rewriteSimulateRequest is the request body for the simulate endpoint.
+type rewriteSimulateRequest struct {
+	Rule        plugin.RewriteRule `json:"rule"`
+	ContentType string             `json:"content_type"`
+	URL         string             `json:"url"`
+	Body        string             `json:"body"`
+}
+
+// rewriteSimulateMatch is one rule's match count in a simulation.
+type rewriteSimulateMatch struct {
+	Rule  string `json:"rule"`
+	Count int    `json:"count"`
+}
+
+// rewriteSimulateResponse is the response body for the simulate endpoint.
+type rewriteSimulateResponse struct {
+	Body     string                 `json:"body"`
+	Matched  bool                   `json:"matched"`
+	Modified bool                   `json:"modified"`
+	Removed  int                    `json:"removed"`
+	Rules    []rewriteSimulateMatch `json:"rules"`
+	Valid    bool                   `json:"valid"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// handleRewriteSimulate runs a draft rule against a sample response body
+// through the rewrite filter, without persisting the rule. Unlike the test
+// endpoint, domain, URL pattern, content type, and HTML-safety handling all
+// apply. An invalid rule returns valid=false with the error and the body
+// unchanged.
+func (s *DashboardServer) handleRewriteSimulate(w http.ResponseWriter, r *http.Request) {
+	var req rewriteSimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	resp := rewriteSimulateResponse{Body: req.Body, Rules: []rewriteSimulateMatch{}, Valid: true}
+	out, result, err := plugin.SimulateRewrite(req.Rule, req.ContentType, req.URL, []byte(req.Body))
+	if err != nil {
+		resp.Valid = false
+		resp.Error = err.Error()
+	} else {
+		resp.Body = string(out)
+		resp.Matched = result.Matched
+		resp.Modified = result.Modified
+		resp.Removed = result.Removed
+		for _, m := range result.Rules {
+			resp.Rules = append(resp.Rules, rewriteSimulateMatch{Rule: m.Rule, Count: m.Count})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp) //nolint:errcheck // best-effort response
+}
+
 // handleRestart restarts the proxy via systemd if running as a managed service.
 func (s *DashboardServer) handleRestart(w http.ResponseWriter, _ *http.Request) {
 	if os.Getenv("INVOCATION_ID") == "" {
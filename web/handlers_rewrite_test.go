@@ -162,6 +162,56 @@ func TestHandleRewriteTest(t *testing.T) {
 	assert.NotEmpty(t, resp.Error)
 }
 
+func TestHandleRewriteSimulate(t *testing.T) {
+	s := testDashboard(t)
+	simulate := func(body string) rewriteSimulateResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/fps/api/rewrite/simulate", bytes.NewBufferString(body))
+		s.handleRewriteSimulate(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp rewriteSimulateResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// Literal: script blocks are protected in HTML, as in the live filter.
+	resp := simulate(`{"rule":{"name":"ads","pattern":"Sponsored","replacement":""},
+		"content_type":"text/html; charset=utf-8","url":"https://www.example.com/feed",
+		"body":"<p>Sponsored</p><script>var s='Sponsored'</script>"}`)
+	assert.True(t, resp.Valid)
+	assert.True(t, resp.Modified)
+	assert.Equal(t, "<p></p><script>var s='Sponsored'</script>", resp.Body)
+	assert.Equal(t, []rewriteSimulateMatch{{Rule: "ads", Count: 1}}, resp.Rules)
+
+	// Regex on JSON, with the content type opted in.
+	resp = simulate(`{"rule":{"pattern":"\"promoted\":true","replacement":"\"promoted\":false","is_regex":true,
+		"content_types":["application/json"]},
+		"content_type":"application/json","url":"https://api.example.com/v1/items",
+		"body":"[{\"promoted\":true},{\"promoted\":true}]"}`)
+	assert.True(t, resp.Valid)
+	assert.Equal(t, `[{"promoted":false},{"promoted":false}]`, resp.Body)
+	assert.Equal(t, 2, resp.Removed)
+
+	// Domain scoping applies: no match for another host.
+	resp = simulate(`{"rule":{"pattern":"x","replacement":"y","domains":["www.example.com"]},
+		"content_type":"text/plain","url":"https://other.example.com/","body":"xx"}`)
+	assert.True(t, resp.Valid)
+	assert.False(t, resp.Matched)
+	assert.Equal(t, "xx", resp.Body)
+	assert.Empty(t, resp.Rules)
+
+	// Compile errors are reported and the rule is not stored.
+	resp = simulate(`{"rule":{"pattern":"[bad","is_regex":true},"content_type":"text/plain","body":"b"}`)
+	assert.False(t, resp.Valid)
+	assert.Contains(t, resp.Error, "invalid regex")
+	assert.Equal(t, "b", resp.Body)
+
+	rules, err := s.rewriteStore.List()
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+}
+
 func TestHandleRestartNoSystemd(t *testing.T) {
 	s := testDashboard(t)
 
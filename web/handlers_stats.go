@@ -0,0 +1,20 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleStatsReset zeroes all traffic statistics, in memory and in
+// stats.db. The database file and schema are kept.
+func (s *DashboardServer) handleStatsReset(w http.ResponseWriter, _ *http.Request) {
+	if err := s.resetStatsFn(); err != nil {
+		s.logger.Error("failed to reset stats", "error", err)
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+	s.logger.Info("stats reset from dashboard")
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"}) //nolint:errcheck // best-effort response
+}
@@ -0,0 +1,49 @@
+package web
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleStatsReset(t *testing.T) {
+	var calls int
+	s := &DashboardServer{
+		prefix:       "/fps",
+		resetStatsFn: func() error { calls++; return nil },
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	w := httptest.NewRecorder()
+	s.handleStatsReset(w, httptest.NewRequest("POST", "/fps/api/stats/reset", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String())
+	assert.Equal(t, 1, calls)
+
+	s.resetStatsFn = func() error { return errors.New("disk full") }
+	w = httptest.NewRecorder()
+	s.handleStatsReset(w, httptest.NewRequest("POST", "/fps/api/stats/reset", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestStatsResetRequiresAuth(t *testing.T) {
+	var called bool
+	s := &DashboardServer{
+		prefix:       "/fps",
+		sessions:     newSessionStore(),
+		resetStatsFn: func() error { called = true; return nil },
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	s.mux = s.buildMux()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/fps/api/stats/reset", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, called)
+}
@@ -12,7 +12,9 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 
+	"github.com/ushineko/face-puncher-supreme/internal/blocklist"
 	"github.com/ushineko/face-puncher-supreme/internal/logbuf"
 	"github.com/ushineko/face-puncher-supreme/internal/plugin"
 )
@@ -34,12 +36,33 @@ type DashboardConfig struct {
 	StatsJSON func() ([]byte, error)
 	// ConfigJSON returns the redacted config as JSON bytes.
 	ConfigJSON func() ([]byte, error)
+	// ConfigSnapshot returns the running allowlist, blocklist, and plugin
+	// state as a YAML config fragment (nil disables the export).
+	ConfigSnapshot func() ([]byte, error)
+	// SessionsJSON returns the open tunnels and MITM sessions as JSON bytes
+	// (nil disables the sessions endpoint).
+	SessionsJSON func() ([]byte, error)
 	// ReloadFn reloads the proxy configuration.
 	ReloadFn func() error
+	// ResetStatsFn zeroes traffic statistics (nil disables the reset
+	// endpoint).
+	ResetStatsFn func() error
 	// RewriteStore is the rewrite rule persistence store (nil if plugin disabled).
 	RewriteStore *plugin.RewriteStore
 	// RewriteReloadFn reloads compiled rewrite rules from the store.
 	RewriteReloadFn func() error
+	// PluginPauses tracks paused (plugin, domain) pairs (nil if no plugins).
+	PluginPauses *plugin.PauseSet
+	// DomainCheckFn reports blocklist/allowlist membership for a domain
+	// without mutating counters. Nil disables the test-domains endpoint.
+	DomainCheckFn func(domain string) (blocklisted, allowlisted bool)
+	// MITMDomainFn reports whether a domain is MITM'd (nil if MITM disabled).
+	MITMDomainFn func(domain string) bool
+	// BlocklistDB backs the live allowlist editor (nil disables it).
+	BlocklistDB *blocklist.DB
+	// Passthrough is the kill switch shared with the proxy and transparent
+	// listener (nil disables the passthrough endpoint).
+	Passthrough *atomic.Bool
 	// Logger is the structured logger.
 	Logger *slog.Logger
 }
@@ -54,9 +77,17 @@ type DashboardServer struct {
 	hub             *Hub
 	logBuffer       *logbuf.Buffer
 	configFn        func() ([]byte, error)
+	snapshotFn      func() ([]byte, error)
+	sessionsFn      func() ([]byte, error)
 	reloadFn        func() error
+	resetStatsFn    func() error
 	rewriteStore    *plugin.RewriteStore
 	rewriteReloadFn func() error
+	pluginPauses    *plugin.PauseSet
+	domainCheckFn   func(domain string) (blocklisted, allowlisted bool)
+	mitmDomainFn    func(domain string) bool
+	blocklistDB     *blocklist.DB
+	passthrough     *atomic.Bool
 	logger          *slog.Logger
 	mux             *http.ServeMux
 }
@@ -71,9 +102,17 @@ func NewDashboard(cfg *DashboardConfig) *DashboardServer {
 		sessions:        newSessionStore(),
 		logBuffer:       cfg.LogBuffer,
 		configFn:        cfg.ConfigJSON,
+		snapshotFn:      cfg.ConfigSnapshot,
+		sessionsFn:      cfg.SessionsJSON,
 		reloadFn:        cfg.ReloadFn,
+		resetStatsFn:    cfg.ResetStatsFn,
 		rewriteStore:    cfg.RewriteStore,
 		rewriteReloadFn: cfg.RewriteReloadFn,
+		pluginPauses:    cfg.PluginPauses,
+		domainCheckFn:   cfg.DomainCheckFn,
+		mitmDomainFn:    cfg.MITMDomainFn,
+		blocklistDB:     cfg.BlocklistDB,
+		passthrough:     cfg.Passthrough,
 		logger:          cfg.Logger,
 	}
 
@@ -109,7 +148,14 @@ func (s *DashboardServer) buildMux() *http.ServeMux {
 	// Protected API endpoints.
 	mux.HandleFunc("GET "+p+"/api/readme", s.requireAuth(s.handleReadme))
 	mux.HandleFunc("GET "+p+"/api/config", s.requireAuth(s.handleConfig))
+	if s.snapshotFn != nil {
+		mux.HandleFunc("GET "+p+"/api/config/snapshot", s.requireAuth(s.handleConfigSnapshot))
+	}
 	mux.HandleFunc("GET "+p+"/api/logs", s.requireAuth(s.handleLogs))
+	mux.HandleFunc("GET "+p+"/logs/stream", s.requireAuth(s.handleLogStream))
+	if s.sessionsFn != nil {
+		mux.HandleFunc("GET "+p+"/sessions", s.requireAuth(s.handleSessions))
+	}
 	mux.HandleFunc(p+"/api/ws", s.requireAuth(s.handleWebSocket))
 
 	// Rewrite rules CRUD (only if rewrite plugin is active).
@@ -121,6 +167,40 @@ func (s *DashboardServer) buildMux() *http.ServeMux {
 		mux.HandleFunc("DELETE "+p+"/api/rewrite/rules/{id}", s.requireAuth(s.handleRewriteDelete))
 		mux.HandleFunc("PATCH "+p+"/api/rewrite/rules/{id}/toggle", s.requireAuth(s.handleRewriteToggle))
 		mux.HandleFunc("POST "+p+"/api/rewrite/test", s.requireAuth(s.handleRewriteTest))
+		mux.HandleFunc("POST "+p+"/api/rewrite/simulate", s.requireAuth(s.handleRewriteSimulate))
+	}
+
+	// Per-domain plugin pause/resume (only if plugins are active).
+	if s.pluginPauses != nil {
+		mux.HandleFunc("GET "+p+"/api/plugins/paused", s.requireAuth(s.handlePluginPausedList))
+		mux.HandleFunc("POST "+p+"/api/plugins/{name}/domains/{domain}/pause", s.requireAuth(s.handlePluginPause))
+		mux.HandleFunc("POST "+p+"/api/plugins/{name}/domains/{domain}/resume", s.requireAuth(s.handlePluginResume))
+	}
+
+	// Bulk domain test (read-only).
+	if s.domainCheckFn != nil {
+		mux.HandleFunc("POST "+p+"/api/test-domains", s.requireAuth(s.handleTestDomains))
+	}
+
+	// Live allowlist editing.
+	if s.blocklistDB != nil {
+		mux.HandleFunc("GET "+p+"/api/allowlist", s.requireAuth(s.handleAllowlistList))
+		mux.HandleFunc("POST "+p+"/api/allowlist", s.requireAuth(s.handleAllowlistAdd))
+		mux.HandleFunc("DELETE "+p+"/api/allowlist/{entry}", s.requireAuth(s.handleAllowlistDelete))
+		mux.HandleFunc("POST "+p+"/api/blocklist/sources", s.requireAuth(s.handleBlocklistSourceEnable))
+		mux.HandleFunc("POST "+p+"/api/block", s.requireAuth(s.handleTemporaryBlock))
+		mux.HandleFunc("POST "+p+"/api/allow", s.requireAuth(s.handleTemporaryAllow))
+		mux.HandleFunc("GET "+p+"/api/temporary", s.requireAuth(s.handleTemporaryList))
+	}
+
+	// Passthrough kill switch.
+	if s.passthrough != nil {
+		mux.HandleFunc("POST "+p+"/api/passthrough", s.requireAuth(s.handlePassthrough))
+	}
+
+	// Stats reset.
+	if s.resetStatsFn != nil {
+		mux.HandleFunc("POST "+p+"/api/stats/reset", s.requireAuth(s.handleStatsReset))
 	}
 
 	// Proxy restart.
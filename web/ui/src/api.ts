@@ -71,6 +71,7 @@ export interface RewriteRule {
   pattern: string;
   replacement: string;
   is_regex: boolean;
+  guard: string;
   domains: string[];
   url_patterns: string[];
   content_types: string[];
@@ -129,6 +130,29 @@ export async function testRewritePattern(
   });
 }
 
+export interface RewriteSimulateResult {
+  body: string;
+  matched: boolean;
+  modified: boolean;
+  removed: number;
+  rules: { rule: string; count: number }[];
+  valid: boolean;
+  error?: string;
+}
+
+export async function simulateRewriteRule(
+  rule: Partial<RewriteRule>,
+  content_type: string,
+  url: string,
+  body: string,
+): Promise<RewriteSimulateResult> {
+  return apiFetch("/rewrite/simulate", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ rule, content_type, url, body }),
+  });
+}
+
 export interface RestartResult {
   status: string;
   message: string;
@@ -137,3 +161,28 @@ export interface RestartResult {
 export async function restartProxy(): Promise<RestartResult> {
   return apiFetch("/restart", { method: "POST" });
 }
+
+export async function resetStats(): Promise<void> {
+  await apiFetch("/stats/reset", { method: "POST" });
+}
+
+// --- Temporary allow/block API ---
+
+export interface TemporaryEntry {
+  domain: string;
+  action: "allowed" | "blocked";
+  expires: string;
+  remaining_seconds: number;
+}
+
+export async function fetchTemporary(): Promise<TemporaryEntry[]> {
+  return apiFetch("/temporary");
+}
+
+export async function allowTemporarily(domain: string, ttl: string): Promise<void> {
+  await apiFetch("/allow", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ domain, ttl }),
+  });
+}
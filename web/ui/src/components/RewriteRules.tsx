@@ -16,6 +16,7 @@ const emptyRule: RewriteRuleInput = {
   pattern: "",
   replacement: "",
   is_regex: false,
+  guard: "",
   domains: [],
   url_patterns: [],
   content_types: [],
@@ -55,6 +56,7 @@ export default function RewriteRules() {
       pattern: rule.pattern,
       replacement: rule.replacement,
       is_regex: rule.is_regex,
+      guard: rule.guard,
       domains: rule.domains,
       url_patterns: rule.url_patterns,
       content_types: rule.content_types,
@@ -314,6 +316,19 @@ function RuleForm({ form, setForm, onSave, onCancel, saving, isNew }: RuleFormPr
           </label>
         </div>
 
+        <label className="block">
+          <span className="text-xs text-vsc-muted">
+            Guard (body must contain this text for the rule to run, empty = always)
+          </span>
+          <input
+            type="text"
+            value={form.guard}
+            onChange={(e) => setForm({ ...form, guard: e.target.value })}
+            placeholder="data-promo"
+            className="mt-1 w-full bg-vsc-bg border border-vsc-border rounded px-2 py-1 text-xs text-vsc-fg font-mono focus:border-vsc-accent outline-none"
+          />
+        </label>
+
         <label className="block">
           <span className="text-xs text-vsc-muted">
             Content types (comma-separated, empty = text/html + text/plain only)
@@ -12,6 +12,7 @@ interface TopTableProps {
   chartVisible?: boolean;
   onToggleChart?: () => void;
   chart?: React.ReactNode;
+  rowAction?: { label: string; title?: string; onClick: (label: string) => void };
 }
 
 export default function TopTable({
@@ -26,6 +27,7 @@ export default function TopTable({
   chartVisible,
   onToggleChart,
   chart,
+  rowAction,
 }: TopTableProps) {
   const cardRef = useRef<HTMLDivElement>(null);
 
@@ -96,6 +98,17 @@ export default function TopTable({
                   <td className="py-1 pl-2 text-right text-vsc-accent whitespace-nowrap">
                     {item.value.toLocaleString()}
                   </td>
+                  {rowAction && (
+                    <td className="py-1 pl-2 text-right whitespace-nowrap">
+                      <button
+                        onClick={() => rowAction.onClick(item.label)}
+                        className="text-vsc-muted hover:text-vsc-accent transition-colors"
+                        title={rowAction.title}
+                      >
+                        {rowAction.label}
+                      </button>
+                    </td>
+                  )}
                 </tr>
               ))}
             </tbody>
@@ -5,6 +5,12 @@ import StatCard, { StatRow } from "../components/StatCard";
 import TopTable from "../components/TopTable";
 import LineChart, { TimePoint } from "../components/LineChart";
 import PieChart from "../components/PieChart";
+import {
+  resetStats,
+  fetchTemporary,
+  allowTemporarily,
+  TemporaryEntry,
+} from "../api";
 
 interface HeartbeatData {
   status: string;
@@ -33,6 +39,9 @@ interface ClientEntry {
   blocked: number;
   bytes_in: number;
   bytes_out: number;
+  bytes_saved?: number;
+  active_connections: number;
+  current_bps: number;
 }
 
 interface PluginFilterEntry {
@@ -42,6 +51,9 @@ interface PluginFilterEntry {
   responses_inspected: number;
   responses_matched: number;
   responses_modified: number;
+  filter_micros_total: number;
+  avg_filter_micros: number;
+  paused_domains: string[];
   top_rules: { rule: string; count: number }[];
 }
 
@@ -59,8 +71,20 @@ interface WatermarksData {
   peak_bytes_in_sec: number;
 }
 
+interface BlocklistSource {
+  url: string;
+  title?: string;
+  version?: string;
+  expires?: string;
+  domains: number;
+  fetched: string;
+  stale: boolean;
+  enabled: boolean;
+  blocks: number;
+}
+
 interface StatsData {
-  connections: { total: number; active: number };
+  connections: { total: number; active: number; shed: number; rate_limited: number; truncated: number; sanitized: number; chaos_delayed: number };
   blocking: {
     blocks_total: number;
     allows_total: number;
@@ -69,19 +93,28 @@ interface StatsData {
     blocklist_sources: number;
     top_blocked: TopEntry[];
     top_allowed: TopEntry[];
+    top_rescued: TopEntry[];
+    sources?: BlocklistSource[];
   };
   mitm: {
     enabled: boolean;
     intercepts_total: number;
     domains_configured: number;
     top_intercepted: TopEntry[];
+    protocol: {
+      http10_requests: number;
+      http11_requests: number;
+      http10_responses: number;
+      malformed_requests: number;
+    };
   };
+  cache: { hits: number; misses: number };
   plugins: {
     active: number;
     filters: PluginFilterEntry[];
   };
   domains: { top_requested: TopEntry[] };
-  clients: { top_by_requests: ClientEntry[] };
+  clients: { top_by_requests: ClientEntry[]; active: ClientEntry[] };
   traffic: {
     total_requests: number;
     total_blocked: number;
@@ -134,6 +167,52 @@ export default function Stats() {
 
   const layout = useLayout();
 
+  const [confirmReset, setConfirmReset] = useState(false);
+  const [resetting, setResetting] = useState(false);
+  const [resetError, setResetError] = useState("");
+
+  async function handleResetStats() {
+    setResetting(true);
+    setConfirmReset(false);
+    try {
+      await resetStats();
+      setResetError("");
+      setTrafficHistory([]);
+    } catch (e: unknown) {
+      setResetError((e as Error).message);
+    } finally {
+      setResetting(false);
+    }
+  }
+
+  // Active temporary allows/blocks, polled (they are not in the stats feed).
+  const [temporary, setTemporary] = useState<TemporaryEntry[]>([]);
+  const [temporaryError, setTemporaryError] = useState("");
+
+  const loadTemporary = useCallback(() => {
+    fetchTemporary()
+      .then((entries) => {
+        setTemporary(entries);
+        setTemporaryError("");
+      })
+      .catch(() => setTemporary([]));
+  }, []);
+
+  useEffect(() => {
+    loadTemporary();
+    const id = setInterval(loadTemporary, 15000);
+    return () => clearInterval(id);
+  }, [loadTemporary]);
+
+  async function handleAllowTemporarily(domain: string) {
+    try {
+      await allowTemporarily(domain, "30m");
+      loadTemporary();
+    } catch (e: unknown) {
+      setTemporaryError((e as Error).message);
+    }
+  }
+
   // Rolling time-series for the traffic line chart.
   // Must use useState (not useRef) so LineChart receives a new array reference
   // on each update — otherwise the canvas draw effect never re-fires.
@@ -220,6 +299,34 @@ export default function Stats() {
               label="Active"
               value={stats.connections.active.toLocaleString()}
             />
+            <StatRow
+              label="Shed (503)"
+              value={stats.connections.shed.toLocaleString()}
+            />
+            <StatRow
+              label="Rate limited (429)"
+              value={stats.connections.rate_limited.toLocaleString()}
+            />
+            <StatRow
+              label="Truncated"
+              value={stats.connections.truncated.toLocaleString()}
+            />
+            <StatRow
+              label="Sanitized"
+              value={stats.connections.sanitized.toLocaleString()}
+            />
+            {stats.connections.chaos_delayed > 0 && (
+              <StatRow
+                label="Chaos delayed"
+                value={stats.connections.chaos_delayed.toLocaleString()}
+              />
+            )}
+            {stats.cache.hits + stats.cache.misses > 0 && (
+              <StatRow
+                label="Cache hits / misses"
+                value={`${stats.cache.hits.toLocaleString()} / ${stats.cache.misses.toLocaleString()}`}
+              />
+            )}
             <div className="mt-2 border-t border-vsc-border pt-2">
               <div className="text-xs text-vsc-accent mb-1">Blocking</div>
               <StatRow
@@ -254,6 +361,14 @@ export default function Stats() {
                   label="Domains"
                   value={stats.mitm.domains_configured.toString()}
                 />
+                <StatRow
+                  label="HTTP/1.0 req / resp"
+                  value={`${stats.mitm.protocol.http10_requests.toLocaleString()} / ${stats.mitm.protocol.http10_responses.toLocaleString()}`}
+                />
+                <StatRow
+                  label="Malformed"
+                  value={stats.mitm.protocol.malformed_requests.toLocaleString()}
+                />
               </div>
             )}
           </>
@@ -321,6 +436,16 @@ export default function Stats() {
                   label="Modified"
                   value={f.responses_modified.toLocaleString()}
                 />
+                <StatRow
+                  label="Avg filter time"
+                  value={`${f.avg_filter_micros.toFixed(1)} µs`}
+                />
+                {f.paused_domains.length > 0 && (
+                  <StatRow
+                    label="Paused"
+                    value={f.paused_domains.join(", ")}
+                  />
+                )}
               </div>
             ))}
           </>
@@ -379,6 +504,36 @@ export default function Stats() {
           value: e.count,
         })),
       },
+      {
+        id: "top-rescued",
+        title: "Top Rescued Domains",
+        items: (stats.blocking.top_rescued ?? []).map((e) => ({
+          label: e.domain,
+          value: e.count,
+        })),
+      },
+      {
+        id: "blocklist-sources",
+        title: "Blocklist Sources",
+        items: (stats.blocking.sources ?? []).map((s) => ({
+          label:
+            (s.title || s.url) +
+            (s.version ? ` v${s.version}` : "") +
+            (s.stale ? " (stale)" : "") +
+            (s.enabled === false ? " (disabled)" : ""),
+          value: s.domains,
+        })),
+      },
+      {
+        id: "source-blocks",
+        title: "Blocks by Source",
+        items: [...(stats.blocking.sources ?? [])]
+          .sort((a, b) => (b.blocks ?? 0) - (a.blocks ?? 0))
+          .map((s) => ({
+            label: s.title || s.url,
+            value: s.blocks ?? 0,
+          })),
+      },
       {
         id: "top-requested",
         title: "Top Requested Domains",
@@ -395,6 +550,25 @@ export default function Stats() {
           value: e.requests,
         })),
       },
+      {
+        id: "client-bytes-saved",
+        title: "Est. Bytes Saved by Client",
+        items: [...stats.clients.top_by_requests]
+          .filter((e) => (e.bytes_saved ?? 0) > 0)
+          .sort((a, b) => (b.bytes_saved ?? 0) - (a.bytes_saved ?? 0))
+          .map((e) => ({
+            label: e.hostname || e.client_ip,
+            value: e.bytes_saved ?? 0,
+          })),
+      },
+      {
+        id: "active-clients",
+        title: "Active Clients (bytes/sec)",
+        items: (stats.clients.active ?? []).map((e) => ({
+          label: `${e.hostname || e.client_ip} (${e.active_connections} conn)`,
+          value: e.current_bps,
+        })),
+      },
     ];
 
     if (stats.mitm.enabled && stats.mitm.top_intercepted.length > 0) {
@@ -437,8 +611,34 @@ export default function Stats() {
 
   return (
     <div className="space-y-4">
-      {/* Header with reset button */}
-      <div className="flex justify-end">
+      {/* Header with reset buttons */}
+      <div className="flex justify-end items-center gap-3">
+        {confirmReset ? (
+          <div className="flex items-center gap-1">
+            <span className="text-xs text-vsc-error">Reset all stats?</span>
+            <button
+              onClick={handleResetStats}
+              disabled={resetting}
+              className="text-xs bg-vsc-error/20 border border-vsc-error/40 rounded px-2 py-1 text-vsc-error hover:bg-vsc-error/30 disabled:opacity-50 transition-colors"
+            >
+              Yes
+            </button>
+            <button
+              onClick={() => setConfirmReset(false)}
+              className="text-xs bg-vsc-surface border border-vsc-border rounded px-2 py-1 text-vsc-muted hover:text-vsc-fg transition-colors"
+            >
+              No
+            </button>
+          </div>
+        ) : (
+          <button
+            onClick={() => setConfirmReset(true)}
+            disabled={resetting}
+            className="text-xs text-vsc-muted hover:text-vsc-error disabled:opacity-50 transition-colors"
+          >
+            {resetting ? "Resetting..." : "Reset Stats"}
+          </button>
+        )}
         <button
           onClick={layout.resetLayout}
           className="text-xs text-vsc-muted hover:text-vsc-accent transition-colors"
@@ -447,6 +647,51 @@ export default function Stats() {
         </button>
       </div>
 
+      {resetError && (
+        <div className="text-xs p-2 rounded border border-vsc-error/50 text-vsc-error bg-vsc-error/10">
+          {resetError}
+        </div>
+      )}
+
+      {temporaryError && (
+        <div className="text-xs p-2 rounded border border-vsc-error/50 text-vsc-error bg-vsc-error/10">
+          {temporaryError}
+        </div>
+      )}
+
+      {temporary.length > 0 && (
+        <div className="bg-vsc-surface border border-vsc-border rounded p-4">
+          <h3 className="text-xs text-vsc-muted uppercase tracking-wider mb-3">
+            Temporary Exceptions
+          </h3>
+          <table className="w-full text-xs">
+            <tbody>
+              {temporary.map((e) => (
+                <tr
+                  key={e.domain}
+                  className="border-b border-vsc-border last:border-0"
+                >
+                  <td className="py-1 truncate max-w-0 w-full">{e.domain}</td>
+                  <td
+                    className={`py-1 pl-2 whitespace-nowrap ${
+                      e.action === "allowed" ? "text-vsc-success" : "text-vsc-error"
+                    }`}
+                  >
+                    {e.action}
+                  </td>
+                  <td
+                    className="py-1 pl-2 text-right text-vsc-accent whitespace-nowrap"
+                    title={`expires ${new Date(e.expires).toLocaleString()}`}
+                  >
+                    {formatUptime(e.remaining_seconds)} left
+                  </td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </div>
+      )}
+
       {/* Stat Cards */}
       <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 xl:grid-cols-4 gap-4">
         {orderedCards.map((id) => {
@@ -490,6 +735,15 @@ export default function Stats() {
                 onDragEnd={layout.onDragEnd}
                 onDragOver={layout.onDragOver}
                 onDrop={layout.onDrop("table", id)}
+                rowAction={
+                  id === "top-blocked"
+                    ? {
+                        label: "allow 30m",
+                        title: "Temporarily allow this domain for 30 minutes",
+                        onClick: handleAllowTemporarily,
+                      }
+                    : undefined
+                }
                 chartVisible={
                   hasPie ? layout.isChartVisible(id) : undefined
                 }